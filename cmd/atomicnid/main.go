@@ -0,0 +1,52 @@
+// atomicnid is the optional AtomicNI node daemon. It runs maintenance loops
+// such as periodic IPAM garbage collection next to the CNI binary.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/daemon"
+)
+
+func main() {
+	opts := daemon.Options{}
+	flag.StringVar(&opts.DataDir, "data-dir", config.DataDir(), "IPAM and attachment cache directory")
+	flag.DurationVar(&opts.GCInterval, "gc-interval", daemon.DefaultGCInterval, "interval between GC passes")
+	flag.StringVar(&opts.CRISocket, "cri-socket", "", "CRI runtime socket used to confirm sandboxes are gone before GC; overrides the criSocket config key")
+	flag.StringVar(&opts.ListenAddr, "listen", "", "optional address serving /metrics, plus the state API on loopback (e.g. 127.0.0.1:9723)")
+	flag.StringVar(&opts.APISocket, "api-socket", "", "optional unix socket serving /metrics and the read-only state API")
+	flag.StringVar(&opts.EventsFile, "events-file", "", "optional NDJSON file receiving GC release events")
+	flag.StringVar(&opts.EventsSocket, "events-socket", "", "optional unix socket receiving GC release events")
+	flag.BoolVar(&opts.LeaderElect, "leader-elect", false, "run GC only on the replica holding the data-dir leader lease")
+	flag.StringVar(&opts.Identity, "identity", "", "replica identity recorded in the leader lease (default host/pid)")
+	flag.BoolVar(&opts.ReapplyRules, "reapply-rules", false, "re-install host firewall/QoS rules flushed by external tooling after each GC pass")
+	flag.DurationVar(&opts.DrainTimeout, "drain-timeout", daemon.DefaultDrainTimeout, "how long shutdown waits for a running GC pass and in-flight API requests")
+	showVersion := flag.Bool("version", false, "print build version and exit")
+	flag.Parse()
+	if *showVersion {
+		fmt.Printf("atomicnid %s\n", buildinfo.Get())
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := log.New(os.Stderr, "atomicnid: ", log.LstdFlags)
+	logger.Printf("starting %s", buildinfo.Get())
+	if opts.CRISocket != "" && !buildinfo.K8s {
+		logger.Fatal("--cri-socket: CRI support is not compiled in (built with atomicni_nok8s)")
+	}
+	d := daemon.New(atomicni.NewPlugin(), opts, logger)
+	if err := d.Run(ctx); err != nil {
+		logger.Fatal(err)
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// hostTools are the external programs AtomicNI may run, with what needs them.
+var hostTools = []struct{ name, usedBy string }{
+	{"ip", "links, addresses, routes"},
+	{"bridge", "VLAN trunks, port isolation"},
+	{"nft", "firewall (nftables backend)"},
+	{"iptables", "firewall (iptables backend)"},
+	{"ipset", "pod sets (iptables backend)"},
+	{"conntrack", "conntrack zone flushes"},
+	{"tc", "bandwidth shaper checks"},
+	{"ping", "verifyConnectivity"},
+	{"firewall-cmd", "firewalld zones"},
+	{"crictl", "CRI sandbox checks"},
+	{"kubectl", "IPAM exhaustion Node events (atomicnid)"},
+	{"tcpdump", "atomicni capture"},
+}
+
+// capabilities is the report printed by `atomicni capabilities`.
+type capabilities struct {
+	Build    buildinfo.Info      `json:"build"`
+	Features []buildinfo.Feature `json:"features"`
+	Firewall string              `json:"firewall"`
+	Tools    map[string]string   `json:"tools"`
+}
+
+// runCapabilities implements `atomicni capabilities`: it lists the optional
+// subsystems compiled into this build, the firewall backend it would use on
+// this host, and which external tools are installed.
+func runCapabilities(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("capabilities", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("%w: unknown format %q", errUsage, *format)
+	}
+
+	report := capabilities{
+		Build:    buildinfo.Get(),
+		Features: buildinfo.Features(),
+		Firewall: netops.DetectFirewall().Name(),
+		Tools:    map[string]string{},
+	}
+	for _, t := range hostTools {
+		if path, err := exec.LookPath(t.name); err == nil {
+			report.Tools[t.name] = path
+		}
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Fprintf(stdout, "atomicni %s\n\n", report.Build)
+	fmt.Fprintf(stdout, "%-10s %-9s %-22s %s\n", "FEATURE", "BUILD", "TAG", "PROVIDES")
+	for _, f := range report.Features {
+		state := "enabled"
+		if !f.Enabled {
+			state = "disabled"
+		}
+		fmt.Fprintf(stdout, "%-10s %-9s %-22s %s\n", f.Name, state, f.Tag, f.Summary)
+	}
+	fmt.Fprintf(stdout, "\nfirewall backend: %s\n\n", report.Firewall)
+	fmt.Fprintf(stdout, "%-14s %-24s %s\n", "TOOL", "PATH", "USED BY")
+	for _, t := range hostTools {
+		path := report.Tools[t.name]
+		if path == "" {
+			path = "missing"
+		}
+		fmt.Fprintf(stdout, "%-14s %-24s %s\n", t.name, path, t.usedBy)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// runCapture implements `atomicni capture <containerID> [filter...]`: it runs
+// tcpdump on the pod's interface inside its netns, or on its host veth with
+// --host, and writes pcap to --out or stdout. Arguments after the container
+// ID form the BPF filter.
+func runCapture(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("capture", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	network := fs.String("network", "", "network of the attachment (when the pod has several)")
+	ifName := fs.String("ifname", "", "pod interface (when the pod has several)")
+	host := fs.Bool("host", false, "capture on the host veth instead of inside the pod netns")
+	out := fs.String("out", "-", "pcap file, - for stdout")
+	count := fs.Int("count", 0, "stop after this many packets (0 = until interrupted)")
+	snaplen := fs.Int("snaplen", 0, "bytes captured per packet (0 = tcpdump default)")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%w: atomicni capture [flags] <containerID> [filter...]", errUsage)
+	}
+	a, err := findAttachment(*dataDir, fs.Arg(0), *network, *ifName)
+	if err != nil {
+		return err
+	}
+	dev := a.IfName
+	if *host {
+		dev = a.HostVeth
+	}
+	// -U flushes each packet so a reader on the pipe sees it immediately.
+	tcpArgs := []string{"-i", dev, "-U", "-w", *out}
+	if *count > 0 {
+		tcpArgs = append(tcpArgs, "-c", strconv.Itoa(*count))
+	}
+	if *snaplen > 0 {
+		tcpArgs = append(tcpArgs, "-s", strconv.Itoa(*snaplen))
+	}
+	tcpArgs = append(tcpArgs, fs.Args()[1:]...)
+
+	c, audit, err := netops.Command(context.Background(), "tcpdump", tcpArgs...)
+	if err != nil {
+		return err
+	}
+	if errors.Is(c.Err, exec.ErrNotFound) {
+		audit(c.Err)
+		return errors.New("capture requires tcpdump")
+	}
+	c.Stdout, c.Stderr = stdout, os.Stderr
+	// Ctrl-C reaches tcpdump through the process group; wait for it to flush
+	// instead of exiting first.
+	signal.Ignore(os.Interrupt, syscall.SIGTERM)
+	defer signal.Reset(os.Interrupt, syscall.SIGTERM)
+	fmt.Fprintf(os.Stderr, "capturing on %s (%s)\n", dev, a.Key())
+	if *host {
+		err = c.Start()
+	} else {
+		var target ns.NetNS
+		if target, err = ns.GetNS(a.Netns); err != nil {
+			return fmt.Errorf("open netns of %s: %w", a.Key(), err)
+		}
+		defer target.Close()
+		// The child inherits the netns of the thread that starts it.
+		err = target.Do(func(ns.NetNS) error { return c.Start() })
+	}
+	if err != nil {
+		audit(err)
+		return fmt.Errorf("start tcpdump: %w", err)
+	}
+	err = c.Wait()
+	audit(err)
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.Exited() {
+			return fmt.Errorf("tcpdump: %w", err)
+		}
+	}
+	return nil
+}
+
+// findAttachment returns the one cached attachment of container id, or of the
+// only container whose ID starts with id, narrowed by network and ifName when
+// set.
+func findAttachment(dataDir, id, network, ifName string) (*cache.Attachment, error) {
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var exact, prefixed []*cache.Attachment
+	for _, a := range attachments {
+		if (network != "" && a.Network != network) || (ifName != "" && a.IfName != ifName) {
+			continue
+		}
+		if a.ContainerID == id {
+			exact = append(exact, a)
+		} else if strings.HasPrefix(a.ContainerID, id) {
+			prefixed = append(prefixed, a)
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = prefixed
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no attachment for container %q", id)
+	case 1:
+		return matches[0], nil
+	}
+	keys := make([]string, 0, len(matches))
+	for _, a := range matches {
+		keys = append(keys, a.Key())
+	}
+	return nil, fmt.Errorf("%q matches %s; narrow it with --network or --ifname", id, strings.Join(keys, ", "))
+}
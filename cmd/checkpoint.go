@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// runCheckpoint implements `atomicni checkpoint <containerID>`: it writes the
+// network state of a pod's attachment as a JSON blob for `atomicni restore`,
+// to --out or stdout. Run it before the checkpoint tool tears the pod down.
+func runCheckpoint(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("checkpoint", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	confPath := fs.String("config", "", "network config file (.conf or .conflist)")
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	ifName := fs.String("ifname", "", "pod interface (when the pod has several)")
+	out := fs.String("out", "-", "checkpoint file, - for stdout")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *confPath == "" || fs.NArg() != 1 {
+		return fmt.Errorf("%w: atomicni checkpoint --config <file> [flags] <containerID>", errUsage)
+	}
+	conf, err := readPluginConf(*confPath)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Parse(conf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *confPath, err)
+	}
+	a, err := findAttachment(*dataDir, fs.Arg(0), cfg.Name, *ifName)
+	if err != nil {
+		return err
+	}
+
+	blob, err := atomicni.NewPlugin().Checkpoint(context.Background(), &skel.CmdArgs{
+		ContainerID: a.ContainerID,
+		Netns:       a.Netns,
+		IfName:      a.IfName,
+		StdinData:   conf,
+	})
+	if err != nil {
+		return err
+	}
+	blob = append(blob, '\n')
+	if *out == "-" {
+		_, err = stdout.Write(blob)
+		return err
+	}
+	return os.WriteFile(*out, blob, 0o600)
+}
+
+// runRestore implements `atomicni restore`: it rebuilds a checkpointed
+// attachment in the restored pod's netns from --in or stdin, with the same
+// address and MAC, and prints what it configured.
+func runRestore(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	confPath := fs.String("config", "", "network config file (.conf or .conflist)")
+	netnsPath := fs.String("netns", "", "network namespace of the restored pod")
+	containerID := fs.String("container", "", "container ID of the restored pod")
+	ifName := fs.String("ifname", "eth0", "pod interface name")
+	in := fs.String("in", "-", "checkpoint file, - for stdin")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *confPath == "" || *netnsPath == "" || *containerID == "" {
+		return fmt.Errorf("%w: --config, --netns, and --container are required", errUsage)
+	}
+	conf, err := readPluginConf(*confPath)
+	if err != nil {
+		return err
+	}
+	var blob []byte
+	if *in == "-" {
+		blob, err = io.ReadAll(os.Stdin)
+	} else {
+		blob, err = os.ReadFile(*in)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := atomicni.NewPlugin().Restore(context.Background(), &skel.CmdArgs{
+		ContainerID: *containerID,
+		Netns:       *netnsPath,
+		IfName:      *ifName,
+		StdinData:   conf,
+	}, blob)
+	if err != nil {
+		return err
+	}
+	for _, ip := range res.IPs {
+		fmt.Fprintf(stdout, "restored %s on %s gateway %s\n", ip.Address.String(), *ifName, ip.Gateway)
+	}
+	for _, iface := range res.Interfaces {
+		if iface.Sandbox != "" {
+			fmt.Fprintf(stdout, "restored MAC %s on %s\n", iface.Mac, iface.Name)
+		}
+	}
+	return nil
+}
+
+// readPluginConf returns the atomicni entry of a .conf or .conflist file.
+func readPluginConf(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	confs, err := config.FileConfs(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(confs) == 0 {
+		return nil, fmt.Errorf("%s: no %s plugin entry", path, config.PluginType)
+	}
+	return confs[0], nil
+}
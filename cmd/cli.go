@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// errUsage marks errors that should be followed by usage text.
+var errUsage = errors.New("usage")
+
+// subcommand is one administrative CLI entry.
+type subcommand struct {
+	summary string
+	run     func(args []string, stdout io.Writer) error
+}
+
+// subcommands returns the administrative CLI table.
+func subcommands() map[string]subcommand {
+	return map[string]subcommand{
+		"doctor":       {summary: "check host settings that affect pod networking", run: runDoctor},
+		"drain":        {summary: "release every attachment on the node before decommissioning it", run: runDrain},
+		"explain":      {summary: "print the netlink/iproute operations ADD performs and why", run: runExplain},
+		"capabilities": {summary: "list compiled-in features and host tools", run: runCapabilities},
+		"conformance":  {summary: "run the CNI spec's required behaviors against throwaway namespaces", run: runConformance},
+		"capture":      {summary: "record a pod's traffic as pcap with tcpdump", run: runCapture},
+		"checkpoint":   {summary: "export a pod's network state for a container checkpoint", run: runCheckpoint},
+		"genconf":      {summary: "print or write example network configs", run: runGenconf},
+		"ipam":         {summary: "inspect and repair IPAM state", run: runIPAM},
+		"latency":      {summary: "show p50/p95/p99 of ADD setup steps", run: runLatency},
+		"links":        {summary: "list host veths created by atomicni", run: runLinks},
+		"rules":        {summary: "detect and re-apply flushed host rules", run: runRules},
+		"restore":      {summary: "rebuild a checkpointed pod's network state", run: runRestore},
+		"state":        {summary: "back up or restore the whole data dir as one tar", run: runState},
+		"stress":       {summary: "multi-process IPAM allocation stress test", run: runStress},
+		"topology":     {summary: "print bridges, veths, pods, and routes as JSON or DOT", run: runTopology},
+		"tui":          {summary: "live dashboard of networks, allocations, and pod counters", run: runTUI},
+		"validate":     {summary: "check network config files before the runtime uses them", run: runValidate},
+		"version":      {summary: "print build version and commit", run: runVersion},
+	}
+}
+
+// Run dispatches administrative subcommands used when the binary is invoked
+// directly by an operator instead of by a container runtime. It returns the
+// process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	cmds := subcommands()
+	if len(args) == 0 {
+		printUsage(stderr, cmds)
+		return 2
+	}
+	if args[0] == "--version" || args[0] == "-version" {
+		args[0] = "version"
+	}
+	sub, ok := cmds[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n", args[0])
+		printUsage(stderr, cmds)
+		return 2
+	}
+	if err := sub.run(args[1:], stdout); err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", args[0], err)
+		if errors.Is(err, errUsage) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// printUsage lists subcommands in a stable order.
+func printUsage(w io.Writer, cmds map[string]subcommand) {
+	names := make([]string, 0, len(cmds))
+	for name := range cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "usage: atomicni <command> [flags]")
+	fmt.Fprintln(w, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-12s %s\n", name, cmds[name].summary)
+	}
+}
@@ -1,4 +1,4 @@
-// cmd package provides the cmdAdd, cmdDel, and cmdCheck functions for CNI plugin operations: ADD, DEL and CHECK, respectively.
+// cmd package provides the Add, Del, Check, and GC functions for CNI plugin operations: ADD, DEL, CHECK, and GC, respectively.
 //It uses the skeleton package from the CNI library to handle command execution and versioning.
 //
 // Refer to [CNI spec](https://www.cni.dev/docs/spec/) to better understand each command's purpose.
@@ -29,14 +29,18 @@ func Add(args *skel.CmdArgs) error {
 
 // Del removes a container from a network or reverts modifications.
 func Del(args *skel.CmdArgs) error {
-	// Implementation for Del command
-	fmt.Println("Executing cmdDel")
-	return nil
+	plugin := atomicni.NewPlugin()
+	return plugin.Del(context.Background(), args)
 }
 
 // Check verifies the current state of a container's network configuration.
 func Check(args *skel.CmdArgs) error {
-	// Implementation for CHECK command
-	fmt.Println("Executing cmdCheck")
-	return nil
+	plugin := atomicni.NewPlugin()
+	return plugin.Check(context.Background(), args)
+}
+
+// GC removes what the plugin holds for attachments the runtime no longer lists.
+func GC(args *skel.CmdArgs) error {
+	plugin := atomicni.NewPlugin()
+	return plugin.GCNetwork(context.Background(), args)
 }
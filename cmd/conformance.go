@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/annis-souames/atomicni/pkg/result"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+)
+
+// conformanceOutcome is one line of the conformance report.
+type conformanceOutcome struct {
+	status string // PASS, FAIL, or SKIP
+	name   string
+	detail string
+}
+
+// conformanceRun drives a plugin binary, exactly as a runtime
+// would: one process per call, config on stdin, CNI_* in the environment.
+type conformanceRun struct {
+	self     string
+	dataDir  string
+	conf     []byte
+	version  string
+	foreign  bool // testing another plugin with --plugin
+	outcomes []conformanceOutcome
+}
+
+// cniCall is one plugin invocation. An empty containerID leaves
+// CNI_CONTAINERID unset.
+type cniCall struct {
+	command, containerID, netns, ifName string
+	stdin                               []byte
+}
+
+// cniReply is what a plugin invocation wrote to stdout and how it exited.
+type cniReply struct {
+	stdout []byte
+	failed bool
+	err    *types.Error
+}
+
+// runConformance implements `atomicni conformance`: it runs the CNI spec's
+// required plugin behaviors against disposable network namespaces and prints
+// a PASS/FAIL/SKIP line for each.
+func runConformance(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	confPath := fs.String("config", "", "network config to test (default: a throwaway bridge network)")
+	ifName := fs.String("ifname", "eth0", "pod interface name")
+	plugin := fs.String("plugin", "", "plugin binary to test (default: this binary; requires --config)")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *plugin != "" && *confPath == "" {
+		return fmt.Errorf("%w: --plugin requires --config", errUsage)
+	}
+	if os.Geteuid() != 0 {
+		return errors.New("conformance creates network namespaces and links; run it as root")
+	}
+
+	run := &conformanceRun{foreign: *plugin != ""}
+	var err error
+	if run.self = *plugin; run.self == "" {
+		if run.self, err = os.Executable(); err != nil {
+			return fmt.Errorf("locate executable: %w", err)
+		}
+	}
+	if run.dataDir, err = os.MkdirTemp("", "atomicni-conformance-"); err != nil {
+		return fmt.Errorf("create temp data dir: %w", err)
+	}
+	defer os.RemoveAll(run.dataDir)
+
+	if *confPath == "" {
+		sample := sampleBridge(genconfOptions{
+			name:    "atomicni-conformance",
+			bridge:  "atomic-conf0",
+			subnet:  "10.254.254.0/24",
+			gateway: "10.254.254.1",
+		}, true)
+		sample.Comment, sample.IPMasq = nil, false
+		if run.conf, err = json.Marshal(sample); err != nil {
+			return err
+		}
+		defer func() { _ = netops.NewNetlinkOps().DeleteLink(sample.Bridge) }()
+	} else if run.conf, err = os.ReadFile(*confPath); err != nil {
+		return err
+	} else if *plugin == "" {
+		confs, err := config.FileConfs(run.conf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *confPath, err)
+		}
+		if len(confs) == 0 {
+			return fmt.Errorf("%s: no %s plugin entry", *confPath, config.PluginType)
+		}
+		run.conf = confs[0]
+	}
+	if run.version, err = new(version.ConfigDecoder).Decode(run.conf); err != nil {
+		return fmt.Errorf("read cniVersion: %w", err)
+	}
+
+	podNS, err := testutils.NewNS()
+	if err != nil {
+		return fmt.Errorf("create netns: %w", err)
+	}
+	defer closeNS(podNS)
+	goneNS, err := testutils.NewNS()
+	if err != nil {
+		return fmt.Errorf("create netns: %w", err)
+	}
+	defer closeNS(goneNS)
+
+	run.checkVersion()
+	run.checkErrors(podNS.Path(), *ifName)
+	run.checkLifecycle(podNS.Path(), *ifName)
+	run.checkDelGoneNetns(goneNS, *ifName)
+
+	fmt.Fprintf(stdout, "atomicni conformance: cniVersion %s\n", run.version)
+	counts := map[string]int{}
+	for _, o := range run.outcomes {
+		counts[o.status]++
+		line := fmt.Sprintf("%-4s  %s", o.status, o.name)
+		if o.detail != "" {
+			line += ": " + o.detail
+		}
+		fmt.Fprintln(stdout, line)
+	}
+	fmt.Fprintf(stdout, "%d passed, %d failed, %d skipped\n", counts["PASS"], counts["FAIL"], counts["SKIP"])
+	if counts["FAIL"] > 0 {
+		return fmt.Errorf("%d conformance checks failed", counts["FAIL"])
+	}
+	return nil
+}
+
+// checkVersion covers the VERSION command and version negotiation.
+func (r *conformanceRun) checkVersion() {
+	reply := r.invoke(cniCall{command: "VERSION", stdin: []byte(fmt.Sprintf(`{"cniVersion":%q}`, r.version))})
+	info, err := new(version.PluginDecoder).Decode(reply.stdout)
+	if reply.failed || err != nil {
+		r.fail("VERSION reports supported versions", "%s", reply.describe(err))
+		r.skip("unsupported cniVersion is rejected with code 1", "VERSION failed")
+		return
+	}
+	supported := info.SupportedVersions()
+	if slices.Contains(supported, r.version) {
+		r.pass("VERSION reports supported versions")
+	} else {
+		r.fail("VERSION reports supported versions", "%v does not include the config's %s", supported, r.version)
+	}
+
+	old := ""
+	for _, v := range []string{"0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0"} {
+		if !slices.Contains(supported, v) {
+			old = v
+			break
+		}
+	}
+	if old == "" {
+		r.skip("unsupported cniVersion is rejected with code 1", "every spec version is supported")
+		return
+	}
+	reply = r.invoke(cniCall{command: "ADD", containerID: "conformance-old", netns: "/proc/self/ns/net", ifName: "eth0", stdin: r.withField(r.conf, "cniVersion", old)})
+	r.expectCode("unsupported cniVersion is rejected with code 1", reply, types.ErrIncompatibleCNIVersion)
+}
+
+// checkErrors covers the error format: a non-zero exit with a JSON error
+// object on stdout.
+func (r *conformanceRun) checkErrors(netns, ifName string) {
+	reply := r.invoke(cniCall{command: "ADD", netns: netns, ifName: ifName, stdin: r.conf})
+	r.expectCode("missing CNI_CONTAINERID is rejected with code 4", reply, types.ErrInvalidEnvironmentVariables)
+
+	reply = r.invoke(cniCall{command: "ADD", containerID: "conformance-bad", netns: netns, ifName: ifName, stdin: []byte(`{"cniVersion":`)})
+	r.expectError("malformed config fails with a JSON error on stdout", reply)
+	if r.foreign {
+		return
+	}
+	reply = r.invoke(cniCall{command: "ADD", containerID: "conformance-bad", netns: netns, ifName: ifName, stdin: r.withField(r.conf, "subnet", "not-a-cidr")})
+	r.expectError("invalid subnet fails with a JSON error on stdout", reply)
+}
+
+// checkLifecycle covers ADD, CHECK, and DEL ordering for one attachment.
+func (r *conformanceRun) checkLifecycle(netns, ifName string) {
+	const id = "conformance-pod"
+	add := r.invoke(cniCall{command: "ADD", containerID: id, netns: netns, ifName: ifName, stdin: r.conf})
+	res, err := r.parseResult(add, netns, ifName)
+	if err != nil {
+		r.fail("ADD writes one result for the config's cniVersion", "%v", err)
+		for _, name := range []string{"CHECK passes after ADD", "DEL with prevResult succeeds", "DEL is idempotent", "CHECK fails after DEL"} {
+			r.skip(name, "ADD failed")
+		}
+		_ = r.invoke(cniCall{command: "DEL", containerID: id, netns: netns, ifName: ifName, stdin: r.conf})
+		return
+	}
+	r.pass("ADD writes one result for the config's cniVersion")
+	withPrev := r.withPrevResult(add.stdout)
+
+	r.expectOK("CHECK passes after ADD", r.invoke(cniCall{command: "CHECK", containerID: id, netns: netns, ifName: ifName, stdin: withPrev}))
+	r.expectOK("DEL with prevResult succeeds", r.invoke(cniCall{command: "DEL", containerID: id, netns: netns, ifName: ifName, stdin: withPrev}))
+	r.expectOK("DEL is idempotent", r.invoke(cniCall{command: "DEL", containerID: id, netns: netns, ifName: ifName, stdin: withPrev}))
+	if check := r.invoke(cniCall{command: "CHECK", containerID: id, netns: netns, ifName: ifName, stdin: withPrev}); check.failed {
+		r.pass("CHECK fails after DEL")
+	} else {
+		r.fail("CHECK fails after DEL", "CHECK succeeded for %s", res.IPs[0].Address.String())
+	}
+	r.expectOK("DEL without a prior ADD succeeds", r.invoke(cniCall{command: "DEL", containerID: "conformance-never-added", netns: netns, ifName: ifName, stdin: r.conf}))
+}
+
+// checkDelGoneNetns covers DEL after the runtime has already removed the
+// pod's namespace, which must still release everything.
+func (r *conformanceRun) checkDelGoneNetns(target ns.NetNS, ifName string) {
+	const id, name = "conformance-gone", "DEL succeeds after the netns is deleted"
+	path := target.Path()
+	add := r.invoke(cniCall{command: "ADD", containerID: id, netns: path, ifName: ifName, stdin: r.conf})
+	if _, err := r.parseResult(add, path, ifName); err != nil {
+		r.skip(name, "ADD failed")
+		return
+	}
+	closeNS(target)
+	r.expectOK(name, r.invoke(cniCall{command: "DEL", containerID: id, netns: path, ifName: ifName, stdin: r.withPrevResult(add.stdout)}))
+}
+
+// invoke runs this binary as the plugin for call.
+func (r *conformanceRun) invoke(call cniCall) cniReply {
+	env := []string{}
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "CNI_") && !strings.HasPrefix(kv, config.EnvDataDir+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		"CNI_COMMAND="+call.command,
+		"CNI_NETNS="+call.netns,
+		"CNI_IFNAME="+call.ifName,
+		"CNI_PATH="+filepath.Dir(r.self),
+		config.EnvDataDir+"="+r.dataDir,
+	)
+	if call.containerID != "" {
+		env = append(env, "CNI_CONTAINERID="+call.containerID)
+	}
+	c := exec.Command(r.self)
+	c.Env = env
+	c.Stdin = bytes.NewReader(call.stdin)
+	var out bytes.Buffer
+	c.Stdout = &out
+	err := c.Run()
+
+	reply := cniReply{stdout: out.Bytes(), failed: err != nil}
+	if reply.failed {
+		e := &types.Error{}
+		if json.Unmarshal(reply.stdout, e) == nil {
+			reply.err = e
+		}
+	}
+	return reply
+}
+
+// parseResult checks that an ADD reply is exactly one result document in the
+// config's cniVersion naming the pod interface in netns.
+func (r *conformanceRun) parseResult(reply cniReply, netns, ifName string) (*current.Result, error) {
+	if reply.failed {
+		return nil, errors.New(reply.describe(nil))
+	}
+	dec := json.NewDecoder(bytes.NewReader(reply.stdout))
+	var doc json.RawMessage
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("stdout is not JSON: %q", reply.stdout)
+	}
+	if dec.More() || len(bytes.TrimSpace(reply.stdout)) != len(bytes.TrimSpace(doc)) {
+		return nil, fmt.Errorf("stdout holds more than the result: %q", reply.stdout)
+	}
+	res, err := result.ParsePrevResult(doc, r.version)
+	if err != nil {
+		return nil, err
+	}
+	if res.CNIVersion != r.version {
+		return nil, fmt.Errorf("result cniVersion %q, want %q", res.CNIVersion, r.version)
+	}
+	if len(res.IPs) == 0 {
+		return nil, errors.New("result has no IPs")
+	}
+	if !slices.ContainsFunc(res.Interfaces, func(i *current.Interface) bool { return i.Name == ifName && i.Sandbox == netns }) {
+		return nil, fmt.Errorf("result has no interface %s with sandbox %s", ifName, netns)
+	}
+	return res, nil
+}
+
+// withField returns conf with one top-level key replaced.
+func (r *conformanceRun) withField(conf []byte, key string, value any) []byte {
+	var m map[string]any
+	_ = json.Unmarshal(conf, &m)
+	m[key] = value
+	out, _ := json.Marshal(m)
+	return out
+}
+
+// withPrevResult returns the config a runtime passes to CHECK and DEL.
+func (r *conformanceRun) withPrevResult(result []byte) []byte {
+	return r.withField(r.conf, "prevResult", json.RawMessage(bytes.TrimSpace(result)))
+}
+
+func (r *conformanceRun) expectOK(name string, reply cniReply) {
+	if reply.failed {
+		r.fail(name, "%s", reply.describe(nil))
+		return
+	}
+	r.pass(name)
+}
+
+func (r *conformanceRun) expectError(name string, reply cniReply) {
+	switch {
+	case !reply.failed:
+		r.fail(name, "call succeeded")
+	case reply.err == nil || reply.err.Code == 0 || reply.err.Msg == "":
+		r.fail(name, "stdout %q is not a CNI error", reply.stdout)
+	default:
+		r.pass(name)
+	}
+}
+
+func (r *conformanceRun) expectCode(name string, reply cniReply, code uint) {
+	switch {
+	case !reply.failed:
+		r.fail(name, "call succeeded")
+	case reply.err == nil:
+		r.fail(name, "stdout %q is not a CNI error", reply.stdout)
+	case reply.err.Code != code:
+		r.fail(name, "got code %d (%s)", reply.err.Code, reply.err.Msg)
+	default:
+		r.pass(name)
+	}
+}
+
+func (r *conformanceRun) pass(name string) {
+	r.outcomes = append(r.outcomes, conformanceOutcome{status: "PASS", name: name})
+}
+
+func (r *conformanceRun) fail(name, format string, args ...any) {
+	r.outcomes = append(r.outcomes, conformanceOutcome{status: "FAIL", name: name, detail: fmt.Sprintf(format, args...)})
+}
+
+func (r *conformanceRun) skip(name, reason string) {
+	r.outcomes = append(r.outcomes, conformanceOutcome{status: "SKIP", name: name, detail: reason})
+}
+
+// describe summarizes a failed call for the report.
+func (c cniReply) describe(err error) string {
+	switch {
+	case c.err != nil:
+		return fmt.Sprintf("code %d: %s", c.err.Code, c.err.Msg)
+	case err != nil:
+		return err.Error()
+	default:
+		return fmt.Sprintf("unexpected output %q", c.stdout)
+	}
+}
+
+// closeNS releases a namespace from testutils.NewNS; it is safe to call twice.
+func closeNS(n ns.NetNS) {
+	_ = n.Close()
+	_ = testutils.UnmountNS(n)
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// runDoctor implements `atomicni doctor`: it reports host settings that
+// commonly break pod networking at scale and exits non-zero on problems.
+func runDoctor(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	confPath := fs.String("config", "", "network config file to compare the host against")
+	bridge := fs.String("bridge", "", "bridge to inspect (default: the config's bridge)")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	var cfg *config.NetworkConfig
+	if *confPath != "" {
+		raw, err := os.ReadFile(*confPath)
+		if err != nil {
+			return err
+		}
+		if cfg, err = config.ParseDetailed(raw); err != nil {
+			return fmt.Errorf("%s: %w", *confPath, err)
+		}
+		if *bridge == "" && cfg.Mode == config.ModeBridge {
+			*bridge = cfg.Bridge
+		}
+	}
+
+	problems := checkNesting(cfg, stdout)
+	problems = append(problems, checkNeighbors(netops.NewNetlinkOps(), cfg, *bridge, stdout)...)
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(stdout, "PROBLEM: %s\n", p)
+		}
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Fprintln(stdout, "no problems found")
+	return nil
+}
+
+// checkNesting reports a nested environment such as a kind node and the
+// settings it takes away.
+func checkNesting(cfg *config.NetworkConfig, stdout io.Writer) []string {
+	n := netops.DetectNesting()
+	if !n.Nested() && !n.SysctlReadOnly {
+		return nil
+	}
+	fmt.Fprintf(stdout, "environment: nested (%s)\n", cmp.Or(n.Runtime, "unknown"))
+	if !n.SysctlReadOnly {
+		return nil
+	}
+	if cfg != nil && cfg.Mode == config.ModePTP {
+		return []string{"/proc/sys is mounted read-only; ptp mode cannot enable proxy_arp"}
+	}
+	fmt.Fprintln(stdout, "/proc/sys is mounted read-only; neighbor tuning is skipped")
+	return nil
+}
+
+// checkNeighbors reports the neighbor table sizing against current usage and
+// the config's `neighbor` settings.
+func checkNeighbors(ops *netops.NetlinkOps, cfg *config.NetworkConfig, bridge string, stdout io.Writer) []string {
+	current, err := ops.NeighborSettings(bridge)
+	if errors.Is(err, netops.ErrSysctlUnavailable) {
+		fmt.Fprintln(stdout, "neighbor gc_thresh1/2/3: not in this namespace, set them on the outer host")
+		return nil
+	}
+	if err != nil {
+		return []string{err.Error()}
+	}
+	count, err := ops.NeighborCount()
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	fmt.Fprintf(stdout, "neighbor gc_thresh1/2/3: %d/%d/%d\n", current.GCThresh1, current.GCThresh2, current.GCThresh3)
+	fmt.Fprintf(stdout, "neighbor entries: %d\n", count)
+	if bridge != "" {
+		fmt.Fprintf(stdout, "%s base_reachable_time: %s\n", bridge, current.BaseReachableTime)
+	}
+
+	var problems []string
+	switch {
+	case count*10 >= current.GCThresh3*9:
+		problems = append(problems, fmt.Sprintf("%d neighbor entries are near gc_thresh3 (%d); new neighbors will be dropped", count, current.GCThresh3))
+	case count > current.GCThresh2:
+		problems = append(problems, fmt.Sprintf("%d neighbor entries exceed gc_thresh2 (%d); entries are garbage collected aggressively", count, current.GCThresh2))
+	}
+	if cfg == nil || cfg.Neighbor == nil {
+		return problems
+	}
+	want := cfg.Neighbor
+	for _, c := range []struct {
+		name      string
+		have, set int
+	}{
+		{"gc_thresh1", current.GCThresh1, want.GCThresh1},
+		{"gc_thresh2", current.GCThresh2, want.GCThresh2},
+		{"gc_thresh3", current.GCThresh3, want.GCThresh3},
+	} {
+		if c.set > 0 && c.have < c.set {
+			problems = append(problems, fmt.Sprintf("%s is %d, config wants at least %d (applied on the next ADD)", c.name, c.have, c.set))
+		}
+	}
+	if want.BaseReachableTime > 0 && bridge != "" && int(current.BaseReachableTime.Seconds()) != want.BaseReachableTime {
+		problems = append(problems, fmt.Sprintf("%s base_reachable_time is %s, config wants %ds", bridge, current.BaseReachableTime, want.BaseReachableTime))
+	}
+	return problems
+}
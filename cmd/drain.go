@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// runDrain implements `atomicni drain`: it tears down every attachment
+// recorded on the node and releases its address, for decommissioning the
+// node, after asking for confirmation unless --force is given. It exits
+// non-zero while anything is left behind.
+func runDrain(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("drain", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	force := fs.Bool("force", false, "do not ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	if !*force {
+		attachments, err := cache.List(*dataDir)
+		if err != nil {
+			return err
+		}
+		for _, a := range attachments {
+			fmt.Fprintf(stdout, "will drain %s (netns %s)\n", a.Key(), a.Netns)
+		}
+		fmt.Fprintf(os.Stderr, "drain %d attachments and release every address under %s? [y/N] ", len(attachments), *dataDir)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			return errors.New("aborted")
+		}
+	}
+
+	report, err := atomicni.NewPlugin().Drain(context.Background(), *dataDir)
+	if report != nil {
+		for _, key := range report.Drained {
+			fmt.Fprintf(stdout, "drained  %s\n", key)
+		}
+		for _, owner := range report.Released {
+			fmt.Fprintf(stdout, "released %s\n", owner)
+		}
+		for _, name := range report.Orphans {
+			fmt.Fprintf(stdout, "deleted  orphan veth %s\n", name)
+		}
+		for _, leak := range report.Leaks {
+			fmt.Fprintf(stdout, "leaked   %s\n", leak)
+		}
+		fmt.Fprintf(stdout, "%d attachments drained, %d addresses released, %d orphan veths deleted, %d leaks\n",
+			len(report.Drained), len(report.Released), len(report.Orphans), len(report.Leaks))
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Leaks) > 0 {
+		return fmt.Errorf("%d leaks remain", len(report.Leaks))
+	}
+	return nil
+}
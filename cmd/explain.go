@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// runExplain implements `atomicni explain`: it runs ADD for a config through
+// netops.Explainer and prints the ordered NetOps calls with why each is
+// needed. Without --run nothing on the host changes and IPAM state lives in a
+// temporary data dir.
+func runExplain(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	confPath := fs.String("config", "", "network config file (.conf or .conflist)")
+	netnsPath := fs.String("netns", "", "pod network namespace (required with --run)")
+	containerID := fs.String("container", "explain", "container ID passed to ADD")
+	ifName := fs.String("ifname", "eth0", "pod interface name")
+	run := fs.Bool("run", false, "execute the operations as well as printing them")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *confPath == "" {
+		return fmt.Errorf("%w: --config is required", errUsage)
+	}
+	if *run && *netnsPath == "" {
+		return fmt.Errorf("%w: --run requires --netns", errUsage)
+	}
+
+	raw, err := os.ReadFile(*confPath)
+	if err != nil {
+		return err
+	}
+	confs, err := config.FileConfs(raw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *confPath, err)
+	}
+	if len(confs) == 0 {
+		return fmt.Errorf("%s: no %s plugin entry", *confPath, config.PluginType)
+	}
+
+	var plugin *atomicni.Plugin
+	if *run {
+		plugin = atomicni.NewPlugin()
+		plugin.NetOps = netops.NewExplainer(plugin.NetOps)
+	} else {
+		dir, err := os.MkdirTemp("", "atomicni-explain-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		os.Setenv(config.EnvDataDir, dir)
+		if *netnsPath == "" {
+			*netnsPath = "/proc/self/ns/net"
+		}
+		plugin = &atomicni.Plugin{
+			NetOps: netops.NewExplainer(nil),
+			IPAM:   ipam.NewFileAllocator(),
+			Events: discardSink{},
+			Log:    io.Discard,
+		}
+	}
+	res, addErr := plugin.Add(context.Background(), &skel.CmdArgs{
+		ContainerID: *containerID,
+		Netns:       *netnsPath,
+		IfName:      *ifName,
+		StdinData:   confs[0],
+	})
+
+	steps := plugin.NetOps.(*netops.Explainer).Steps
+	if !*run {
+		fmt.Fprintln(stdout, "dry run: nothing was executed")
+	}
+	for i, s := range steps {
+		fmt.Fprintf(stdout, "%2d. %s %s\n", i+1, s.Op, s.Args)
+		fmt.Fprintf(stdout, "    %s\n", s.Why)
+		if s.Err != nil {
+			fmt.Fprintf(stdout, "    failed: %v\n", s.Err)
+		}
+	}
+	if addErr != nil {
+		return addErr
+	}
+	for _, ip := range res.IPs {
+		fmt.Fprintf(stdout, "pod address: %s gateway %s\n", ip.Address.String(), ip.Gateway)
+	}
+	return nil
+}
+
+// discardSink drops lifecycle events so a dry run does not report a pod that
+// was never created.
+type discardSink struct{}
+
+func (discardSink) Emit(events.Event) error { return nil }
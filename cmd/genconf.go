@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// sampleConf is the subset of config.NetworkConfig a new network needs, in
+// the order it reads best. Keys starting with "_" are ignored by the plugin.
+type sampleConf struct {
+	Comment    []string   `json:"_comment,omitempty"`
+	CNIVersion string     `json:"cniVersion,omitempty"`
+	Name       string     `json:"name,omitempty"`
+	Type       string     `json:"type"`
+	Mode       string     `json:"mode"`
+	Bridge     string     `json:"bridge,omitempty"`
+	Subnet     string     `json:"subnet"`
+	Gateway    string     `json:"gateway"`
+	MTU        int        `json:"mtu"`
+	IPMasq     bool       `json:"ipMasq,omitempty"`
+	IPAM       sampleIPAM `json:"ipam"`
+}
+
+type sampleIPAM struct {
+	DataDir string `json:"dataDir"`
+}
+
+type sampleList struct {
+	Comment    []string     `json:"_comment,omitempty"`
+	CNIVersion string       `json:"cniVersion"`
+	Name       string       `json:"name"`
+	Plugins    []sampleConf `json:"plugins"`
+}
+
+// genconfVariants maps each variant to its file name and builder.
+var genconfVariants = map[string]struct {
+	file  string
+	build func(o genconfOptions) any
+}{
+	"bridge":   {"10-atomicni.conf", func(o genconfOptions) any { return sampleBridge(o, true) }},
+	"ptp":      {"10-atomicni-ptp.conf", func(o genconfOptions) any { return samplePTP(o) }},
+	"conflist": {"10-atomicni.conflist", func(o genconfOptions) any { return sampleConflist(o) }},
+}
+
+type genconfOptions struct {
+	name, bridge, subnet, gateway string
+}
+
+// runGenconf implements `atomicni genconf`: it prints a validated example
+// network config, or writes every variant into --out.
+func runGenconf(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("genconf", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	variant := fs.String("variant", "bridge", "bridge, ptp, or conflist")
+	out := fs.String("out", "", "write all variants into this directory instead of printing one")
+	name := fs.String("name", "atomic-net", "network name")
+	bridge := fs.String("bridge", "atomic0", "bridge name (bridge mode)")
+	subnet := fs.String("subnet", "10.22.0.0/24", "pod IPv4 subnet")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	prefix, err := netip.ParsePrefix(*subnet)
+	if err != nil || !prefix.Addr().Is4() {
+		return fmt.Errorf("%w: --subnet must be an IPv4 CIDR", errUsage)
+	}
+	opts := genconfOptions{
+		name:    *name,
+		bridge:  *bridge,
+		subnet:  prefix.Masked().String(),
+		gateway: prefix.Masked().Addr().Next().String(),
+	}
+
+	if *out == "" {
+		v, ok := genconfVariants[*variant]
+		if !ok {
+			return fmt.Errorf("%w: unknown variant %q", errUsage, *variant)
+		}
+		raw, err := renderSample(v.build(opts))
+		if err != nil {
+			return err
+		}
+		_, err = stdout.Write(raw)
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(genconfVariants))
+	for name := range genconfVariants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := genconfVariants[name]
+		raw, err := renderSample(v.build(opts))
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(*out, v.file)
+		if err := os.WriteFile(path, raw, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, path)
+	}
+	return nil
+}
+
+// renderSample marshals a sample and checks the plugin accepts it.
+func renderSample(sample any) ([]byte, error) {
+	raw, err := json.MarshalIndent(sample, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	raw = append(raw, '\n')
+	confs, err := config.FileConfs(raw)
+	if err != nil {
+		return nil, fmt.Errorf("generated config invalid: %w", err)
+	}
+	for _, conf := range confs {
+		if _, err := config.ParseDetailed(conf); err != nil {
+			return nil, fmt.Errorf("generated config invalid: %w", err)
+		}
+	}
+	return raw, nil
+}
+
+func sampleBridge(o genconfOptions, standalone bool) sampleConf {
+	c := sampleConf{
+		Comment: []string{
+			"AtomicNI bridge network: pod veths join bridge " + o.bridge + ", which holds the gateway address.",
+			"Addresses are allocated sequentially from subnet and persisted under ipam.dataDir.",
+			"ipMasq source-NATs pod traffic leaving the subnet. See docs/guide.md for every option.",
+		},
+		Type:    config.PluginType,
+		Mode:    config.ModeBridge,
+		Bridge:  o.bridge,
+		Subnet:  o.subnet,
+		Gateway: o.gateway,
+		MTU:     config.DefaultMTU,
+		IPMasq:  true,
+		IPAM:    sampleIPAM{DataDir: config.DefaultDataDir},
+	}
+	if standalone {
+		c.CNIVersion, c.Name = "1.1.0", o.name
+	}
+	return c
+}
+
+func samplePTP(o genconfOptions) sampleConf {
+	return sampleConf{
+		Comment: []string{
+			"AtomicNI ptp network: no bridge is created; each pod address is routed as a /32 out of its host veth.",
+			"Every host veth carries the gateway as a /32 with proxy ARP. See docs/guide.md for every option.",
+		},
+		CNIVersion: "1.1.0",
+		Name:       o.name,
+		Type:       config.PluginType,
+		Mode:       config.ModePTP,
+		Subnet:     o.subnet,
+		Gateway:    o.gateway,
+		MTU:        config.DefaultMTU,
+		IPMasq:     true,
+		IPAM:       sampleIPAM{DataDir: config.DefaultDataDir},
+	}
+}
+
+func sampleConflist(o genconfOptions) sampleList {
+	return sampleList{
+		Comment: []string{
+			"Conflist form: the runtime injects cniVersion and name into each plugin entry.",
+			"Chain further plugins (for example bandwidth) after atomicni in plugins.",
+		},
+		CNIVersion: "1.1.0",
+		Name:       o.name,
+		Plugins:    []sampleConf{sampleBridge(o, false)},
+	}
+}
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+)
+
+// runIPAM implements `atomicni ipam <list|release|force-release|migrate|prune|who-had>`.
+func runIPAM(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: atomicni ipam <list|release|force-release|migrate|prune|who-had> [flags]", errUsage)
+	}
+
+	fs := flag.NewFlagSet("ipam "+args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "IPAM data directory")
+	network := fs.String("network", "", "network name")
+	ipStr := fs.String("ip", "", "IPv4 address (release)")
+	containerID := fs.String("container", "", "container ID (force-release)")
+	from := fs.String("from", "", "source backend (migrate): file, host-local")
+	fromDir := fs.String("from-dir", "", "source data directory (migrate)")
+	to := fs.String("to", "file", "destination backend (migrate)")
+	dryRun := fs.Bool("dry-run", false, "only report what would change (migrate, prune)")
+	olderThan := fs.Duration("older-than", 0, "prune allocations older than this age")
+	match := fs.String("match", "", "prune allocations whose container ID matches this regex")
+	at := fs.String("at", "", "who-had: RFC 3339 time or how long ago (e.g. 90m); default now")
+	flagArgs := args[1:]
+	// who-had takes the address as its first argument.
+	if args[0] == "who-had" && len(flagArgs) > 0 && !strings.HasPrefix(flagArgs[0], "-") {
+		*ipStr, flagArgs = flagArgs[0], flagArgs[1:]
+	}
+	if err := fs.Parse(flagArgs); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	ctx := context.Background()
+	alloc := ipam.NewFileAllocator()
+
+	switch args[0] {
+	case "list":
+		return listAllocations(ctx, alloc, *dataDir, *network, stdout)
+	case "release":
+		if *network == "" || *ipStr == "" {
+			return fmt.Errorf("%w: release requires --network and --ip", errUsage)
+		}
+		ip := net.ParseIP(*ipStr)
+		if ip == nil {
+			return fmt.Errorf("invalid IP %q", *ipStr)
+		}
+		if err := alloc.ReleaseIP(ctx, *dataDir, *network, ip); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "released %s from %s\n", ip, *network)
+		return nil
+	case "force-release":
+		if *network == "" || *containerID == "" {
+			return fmt.Errorf("%w: force-release requires --network and --container", errUsage)
+		}
+		if err := alloc.ForceRelease(ctx, *dataDir, *network, *containerID); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "released %s from %s\n", *containerID, *network)
+		return nil
+	case "migrate":
+		if *from == "" || *fromDir == "" {
+			return fmt.Errorf("%w: migrate requires --from and --from-dir", errUsage)
+		}
+		return migrateAllocations(ctx, *from, *fromDir, *to, *dataDir, *network, *dryRun, stdout)
+	case "prune":
+		if *network == "" || (*olderThan <= 0 && *match == "") {
+			return fmt.Errorf("%w: prune requires --network and --older-than and/or --match", errUsage)
+		}
+		filter := ipam.PruneFilter{OlderThan: *olderThan}
+		if *match != "" {
+			re, err := regexp.Compile(*match)
+			if err != nil {
+				return fmt.Errorf("invalid --match: %w", err)
+			}
+			filter.Match = re
+		}
+		pruned, err := alloc.Prune(ctx, *dataDir, *network, filter, *dryRun)
+		if err != nil {
+			return err
+		}
+		verb := "pruned"
+		if *dryRun {
+			verb = "would prune"
+		}
+		for _, e := range pruned {
+			allocatedAt := "unknown"
+			if !e.AllocatedAt.IsZero() {
+				allocatedAt = e.AllocatedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", verb, e.ContainerID, e.IP, allocatedAt)
+		}
+		fmt.Fprintf(stdout, "%s %d allocations from %s\n", verb, len(pruned), *network)
+		return nil
+	case "who-had":
+		ip := net.ParseIP(*ipStr)
+		if ip == nil {
+			return fmt.Errorf("%w: who-had requires an IPv4 address, e.g. atomicni ipam who-had 10.22.0.10 --at 2h", errUsage)
+		}
+		when, err := parseAt(*at, time.Now())
+		if err != nil {
+			return err
+		}
+		return whoHad(ctx, alloc, *dataDir, *network, ip, when, stdout)
+	default:
+		return fmt.Errorf("%w: unknown ipam command %q", errUsage, args[0])
+	}
+}
+
+// parseAt reads --at as an RFC 3339 time or a duration before now.
+func parseAt(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return now, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --at %q: want an RFC 3339 time or a duration ago such as 90m", value)
+}
+
+// whoHad prints "network ip owner pod allocatedAt releasedAt" for every owner
+// holding ip at when, in one or all networks.
+func whoHad(ctx context.Context, alloc *ipam.FileAllocator, dataDir, network string, ip net.IP, when time.Time, stdout io.Writer) error {
+	networks := []string{network}
+	if network == "" {
+		var err error
+		networks, err = alloc.Networks(ctx, dataDir)
+		if err != nil {
+			return err
+		}
+	}
+	stamp := func(t time.Time, unset string) string {
+		if t.IsZero() {
+			return unset
+		}
+		return t.Format(time.RFC3339)
+	}
+	found := 0
+	var errs []error
+	for _, n := range networks {
+		records, err := alloc.History(ctx, dataDir, n)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", n, err))
+			continue
+		}
+		for _, r := range ipam.WhoHad(records, ip, when) {
+			pod := r.Pod
+			if pod == "" {
+				pod = "-"
+			}
+			fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t%s\t%s\n", n, r.IP, r.Owner, pod, stamp(r.AllocatedAt, "unknown"), stamp(r.ReleasedAt, "held"))
+			found++
+		}
+	}
+	if found == 0 && len(errs) == 0 {
+		fmt.Fprintf(stdout, "no recorded owner of %s at %s\n", ip, when.Format(time.RFC3339))
+	}
+	return errors.Join(errs...)
+}
+
+// migrateAllocations copies one or all networks between backends with verification.
+func migrateAllocations(ctx context.Context, from, fromDir, to, toDir, network string, dryRun bool, stdout io.Writer) error {
+	var src ipam.Lister
+	switch from {
+	case "file":
+		src = ipam.NewFileAllocator()
+	case "host-local":
+		src = ipam.HostLocalStore{}
+	default:
+		return fmt.Errorf("source backend %q is not available in this build", from)
+	}
+	if to != "file" {
+		return fmt.Errorf("destination backend %q is not available in this build", to)
+	}
+	dst := ipam.NewFileAllocator()
+
+	networks := []string{network}
+	if network == "" {
+		var err error
+		networks, err = src.Networks(ctx, fromDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	verb := "migrated"
+	if dryRun {
+		verb = "would migrate"
+	}
+	for _, n := range networks {
+		migrated, err := ipam.Migrate(ctx, src, fromDir, dst, toDir, n, dryRun)
+		if err != nil {
+			return fmt.Errorf("%s: %w", n, err)
+		}
+		fmt.Fprintf(stdout, "%s %d allocations for %s\n", verb, len(migrated), n)
+	}
+	return nil
+}
+
+// listAllocations prints "network containerID ip" rows for one or all networks.
+func listAllocations(ctx context.Context, alloc ipam.Allocator, dataDir, network string, stdout io.Writer) error {
+	networks := []string{network}
+	if network == "" {
+		var err error
+		networks, err = alloc.Networks(ctx, dataDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, n := range networks {
+		allocations, err := alloc.List(ctx, dataDir, n)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", n, err))
+			continue
+		}
+		ids := make([]string, 0, len(allocations))
+		for id := range allocations {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			fmt.Fprintf(stdout, "%s\t%s\t%s\n", n, id, allocations[id])
+		}
+	}
+	return errors.Join(errs...)
+}
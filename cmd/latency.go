@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/metrics"
+)
+
+// runLatency implements `atomicni latency`: it prints p50/p95/p99 of each ADD
+// setup step over the recent samples recorded in the metrics snapshot.
+func runLatency(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("latency", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	network := fs.String("network", "", "only show this network")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	snap, err := metrics.Load(*dataDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%-24s %-8s %8s %10s %10s %10s\n", "NETWORK", "STEP", "SAMPLES", "P50", "P95", "P99")
+	for _, r := range snap.StepRows() {
+		if *network != "" && r.Network != *network {
+			continue
+		}
+		fmt.Fprintf(stdout, "%-24s %-8s %8d", r.Network, r.Step, len(r.Stats.Samples))
+		for _, q := range metrics.StepQuantiles {
+			fmt.Fprintf(stdout, " %10s", seconds(r.Stats.Quantile(q)))
+		}
+		fmt.Fprintln(stdout)
+	}
+	return nil
+}
+
+// seconds renders a duration in seconds rounded for display.
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(10 * time.Microsecond).String()
+}
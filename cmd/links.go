@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// runLinks implements `atomicni links`: it lists the host veths AtomicNI
+// created with the pod recorded in each alias.
+func runLinks(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("links", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	bridge := fs.String("bridge", "", "only list ports of this bridge")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	links, err := netops.NewNetlinkOps().ListOwnedLinks(*bridge, atomicni.NewPlugin().HostPrefix())
+	if err != nil {
+		return err
+	}
+	for _, l := range links {
+		fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\n", l.Name, l.Master, l.MAC, l.Alias)
+	}
+	return nil
+}
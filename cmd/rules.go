@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// runRules implements `atomicni rules`: it reports host firewall and QoS
+// rules recorded at ADD that are no longer installed as recorded, re-applying
+// them with --reapply.
+func runRules(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("rules", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	reapply := fs.Bool("reapply", false, "re-install missing or changed rules")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	report, err := atomicni.NewPlugin().ReconcileRules(context.Background(), *dataDir, *reapply)
+	if report != nil {
+		for _, id := range report.Missing {
+			fmt.Fprintf(stdout, "missing   %s\n", id)
+		}
+		for _, id := range report.Changed {
+			fmt.Fprintf(stdout, "changed   %s\n", id)
+		}
+		for _, id := range report.Reapplied {
+			fmt.Fprintf(stdout, "reapplied %s\n", id)
+		}
+		fmt.Fprintf(stdout, "%d rules checked, %d missing, %d changed, %d reapplied\n",
+			report.Checked, len(report.Missing), len(report.Changed), len(report.Reapplied))
+	}
+	if err != nil {
+		return err
+	}
+	if drifted := len(report.Missing) + len(report.Changed); drifted > len(report.Reapplied) {
+		return fmt.Errorf("%d host rules missing or changed", drifted-len(report.Reapplied))
+	}
+	return nil
+}
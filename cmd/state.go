@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/statebackup"
+)
+
+// runState implements `atomicni state <backup|restore>`: it writes the data
+// dir to one tar, or replaces the data dir with one after checking it.
+func runState(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: atomicni state <backup|restore> [flags]", errUsage)
+	}
+	fs := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	out := fs.String("out", "-", "backup: tar file, - for stdout")
+	in := fs.String("in", "-", "restore: tar file, - for stdin")
+	force := fs.Bool("force", false, "restore: replace a data dir that already holds state")
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "backup":
+		w := stdout
+		if *out != "-" {
+			f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		bw := bufio.NewWriter(w)
+		m, err := statebackup.Backup(ctx, *dataDir, bw)
+		if err != nil {
+			return err
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "backed up %d files and %d rule fingerprints from %s\n", len(m.Files), len(m.Rules), *dataDir)
+		return nil
+	case "restore":
+		r := io.Reader(os.Stdin)
+		if *in != "-" {
+			f, err := os.Open(*in)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		res, err := statebackup.Restore(ctx, *dataDir, bufio.NewReader(r), *force)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "restored %d files and %d rule fingerprints into %s (backup of %s, %s)\n",
+			len(res.Manifest.Files), len(res.Manifest.Rules), *dataDir, res.Manifest.CreatedAt.Format("2006-01-02T15:04:05Z"), res.Manifest.PluginBuild)
+		if res.Previous != "" {
+			fmt.Fprintf(stdout, "previous state moved to %s\n", res.Previous)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown state command %q", errUsage, args[0])
+	}
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// stressOptions configures a stress run; workers share one data dir.
+type stressOptions struct {
+	dataDir    string
+	network    string
+	subnet     *net.IPNet
+	workers    int
+	iterations int
+}
+
+// runStress implements `atomicni stress`: it forks worker processes that
+// interleave Allocate/Release on one data dir, then verifies that every kept
+// allocation is unique and the persisted state is consistent.
+func runStress(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("stress", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", "", "IPAM data directory (default: a new temp dir)")
+	network := fs.String("network", "stress", "network name")
+	subnet := fs.String("subnet", "10.250.0.0/16", "subnet to allocate from")
+	workers := fs.Int("workers", 4, "number of worker processes")
+	iterations := fs.Int("iterations", 100, "allocations per worker")
+	workerID := fs.Int("worker", -1, "internal: run as worker N")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *workers < 1 || *iterations < 1 {
+		return fmt.Errorf("%w: --workers and --iterations must be positive", errUsage)
+	}
+	prefix, err := netip.ParsePrefix(*subnet)
+	if err != nil || !prefix.Addr().Is4() {
+		return fmt.Errorf("%w: --subnet must be an IPv4 CIDR", errUsage)
+	}
+	if prefix.Bits() > 29 {
+		return fmt.Errorf("%w: --subnet must be /29 or larger", errUsage)
+	}
+	subnetNet := netaddr.ToIPNet(prefix.Masked())
+
+	opts := stressOptions{dataDir: *dataDir, network: *network, subnet: subnetNet, workers: *workers, iterations: *iterations}
+	if *workerID >= 0 {
+		if opts.dataDir == "" {
+			return fmt.Errorf("%w: --worker requires --data-dir", errUsage)
+		}
+		return stressWorker(context.Background(), opts, *workerID, stdout)
+	}
+	if opts.dataDir == "" {
+		opts.dataDir, err = os.MkdirTemp("", "atomicni-stress-")
+		if err != nil {
+			return fmt.Errorf("create temp data dir: %w", err)
+		}
+		defer os.RemoveAll(opts.dataDir)
+	}
+	return stressCoordinate(context.Background(), opts, stdout)
+}
+
+// stressCoordinate forks the workers, collects the allocations they kept,
+// and cross-checks them against the persisted state.
+func stressCoordinate(ctx context.Context, opts stressOptions, stdout io.Writer) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate executable: %w", err)
+	}
+
+	var (
+		mu   sync.Mutex
+		kept = map[string]string{}
+		errs []error
+		wg   sync.WaitGroup
+	)
+	for id := 0; id < opts.workers; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			c := exec.CommandContext(ctx, self, "stress",
+				"--worker", strconv.Itoa(id),
+				"--data-dir", opts.dataDir,
+				"--network", opts.network,
+				"--subnet", opts.subnet.String(),
+				"--iterations", strconv.Itoa(opts.iterations))
+			var out, stderr bytes.Buffer
+			c.Stdout, c.Stderr = &out, &stderr
+			runErr := c.Run()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if runErr != nil {
+				errs = append(errs, fmt.Errorf("worker %d: %v: %s", id, runErr, strings.TrimSpace(stderr.String())))
+				return
+			}
+			scanner := bufio.NewScanner(&out)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) != 2 {
+					continue
+				}
+				if owner, dup := kept[fields[1]]; dup {
+					errs = append(errs, fmt.Errorf("ip %s handed to both %s and %s", fields[1], owner, fields[0]))
+				}
+				kept[fields[1]] = fields[0]
+			}
+		}(id)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	alloc := ipam.NewFileAllocator()
+	if err := alloc.Verify(ctx, opts.dataDir, opts.network); err != nil {
+		return fmt.Errorf("state integrity: %w", err)
+	}
+	state, err := alloc.List(ctx, opts.dataDir, opts.network)
+	if err != nil {
+		return err
+	}
+	for ip, containerID := range kept {
+		if got := state[containerID]; !got.Equal(net.ParseIP(ip)) {
+			return fmt.Errorf("container %s kept %s but state has %v", containerID, ip, got)
+		}
+	}
+	if len(state) != len(kept) {
+		return fmt.Errorf("state holds %d allocations, workers kept %d", len(state), len(kept))
+	}
+
+	fmt.Fprintf(stdout, "ok: %d workers x %d iterations, %d allocations kept, state consistent (%s)\n",
+		opts.workers, opts.iterations, len(kept), opts.dataDir)
+	return nil
+}
+
+// stressWorker allocates opts.iterations addresses, releasing every other one
+// right away, and prints "<container> <ip>" for each allocation it keeps.
+func stressWorker(ctx context.Context, opts stressOptions, id int, stdout io.Writer) error {
+	alloc := ipam.NewFileAllocator()
+	gateway, start, end := stressBounds(opts.subnet)
+	for i := 0; i < opts.iterations; i++ {
+		containerID := fmt.Sprintf("stress-%d-%d", id, i)
+		req := ipam.AllocationRequest{
+			DataDir:     opts.dataDir,
+			Network:     opts.network,
+			ContainerID: containerID,
+			Subnet:      opts.subnet,
+			Gateway:     gateway,
+			RangeStart:  start,
+			RangeEnd:    end,
+		}
+		ip, err := alloc.Allocate(ctx, req)
+		if err != nil {
+			return fmt.Errorf("allocate %s: %w", containerID, err)
+		}
+		if i%2 == 1 {
+			if err := alloc.Release(ctx, opts.dataDir, opts.network, containerID); err != nil {
+				return fmt.Errorf("release %s: %w", containerID, err)
+			}
+			continue
+		}
+		fmt.Fprintf(stdout, "%s %s\n", containerID, ip)
+	}
+	return nil
+}
+
+// stressBounds returns the first host address of subnet as gateway and the
+// remaining host addresses as the allocation range.
+func stressBounds(subnet *net.IPNet) (gateway, start, end net.IP) {
+	prefix, _ := netaddr.V4Prefix(subnet)
+	first := prefix.Addr().Next()
+	return netaddr.ToIP(first), netaddr.ToIP(first.Next()), netaddr.ToIP(netaddr.Broadcast(prefix).Prev())
+}
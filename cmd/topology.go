@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// runTopology implements `atomicni topology`: it prints the node's bridges,
+// host veths, and pods with their addresses and routes as JSON or Graphviz
+// DOT (`atomicni topology --format dot | dot -Tsvg > node.svg`).
+func runTopology(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("topology", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	format := fs.String("format", "json", "output format: json or dot")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *format != "json" && *format != "dot" {
+		return fmt.Errorf("%w: unknown format %q", errUsage, *format)
+	}
+
+	topo, err := atomicni.NewPlugin().Topology(context.Background(), *dataDir)
+	if err != nil {
+		return err
+	}
+	if *format == "dot" {
+		_, err := io.WriteString(stdout, topo.DOT())
+		return err
+	}
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(topo)
+}
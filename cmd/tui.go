@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\x1b[H\x1b[2J"
+
+// runTUI implements `atomicni tui`: a full-screen dashboard of networks,
+// allocations, and pod veth counters that redraws every --interval until
+// interrupted. It uses plain ANSI escapes, so any terminal works and --once
+// prints a single frame for scripts.
+func runTUI(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dataDir := fs.String("data-dir", config.DataDir(), "plugin data directory")
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	once := fs.Bool("once", false, "print one frame without clearing the screen and exit")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("%w: --interval must be positive", errUsage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	d := &dashboard{
+		dataDir: *dataDir,
+		plugin:  atomicni.NewPlugin(),
+		ops:     netops.NewNetlinkOps(),
+		alloc:   ipam.NewFileAllocator(),
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		frame := d.frame(ctx, *interval)
+		if *once {
+			_, err := io.WriteString(stdout, frame)
+			return err
+		}
+		if _, err := io.WriteString(stdout, clearScreen+frame); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// dashboard renders frames and keeps the previous counters to show rates.
+type dashboard struct {
+	dataDir string
+	plugin  *atomicni.Plugin
+	ops     *netops.NetlinkOps
+	alloc   *ipam.FileAllocator
+
+	prev     map[string]netops.LinkCounters
+	prevTime time.Time
+}
+
+// frame renders one screen. Query errors are shown in place of their section
+// so a transient failure does not end the session.
+func (d *dashboard) frame(ctx context.Context, interval time.Duration) string {
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "atomicni  %s  data dir %s  refresh %s, Ctrl-C quits\n\n",
+		now.Format("2006-01-02 15:04:05"), d.dataDir, interval)
+
+	b.WriteString("NETWORKS\n")
+	networks, err := d.alloc.Networks(ctx, d.dataDir)
+	if err != nil {
+		fmt.Fprintf(&b, "  error: %v\n", err)
+	}
+	for _, network := range networks {
+		allocations, err := d.alloc.List(ctx, d.dataDir, network)
+		if err != nil {
+			fmt.Fprintf(&b, "  %-24s error: %v\n", network, err)
+			continue
+		}
+		fmt.Fprintf(&b, "  %-24s %d allocated\n", network, len(allocations))
+	}
+	if err == nil && len(networks) == 0 {
+		b.WriteString("  (none)\n")
+	}
+
+	b.WriteString("\nPODS\n")
+	topo, err := d.plugin.Topology(ctx, d.dataDir)
+	if err != nil {
+		fmt.Fprintf(&b, "  error: %v\n", err)
+		return b.String()
+	}
+	counters, err := d.ops.ListLinkCounters(d.plugin.HostPrefix())
+	if err != nil {
+		fmt.Fprintf(&b, "  counters unavailable: %v\n", err)
+	}
+	fmt.Fprintf(&b, "  %-16s %-14s %-6s %-18s %-16s %12s %12s %10s %10s\n",
+		"NETWORK", "CONTAINER", "IF", "IP", "HOST VETH", "POD RX", "POD TX", "RX/s", "TX/s")
+	elapsed := now.Sub(d.prevTime).Seconds()
+	for _, p := range topo.Pods {
+		ip := "-"
+		if len(p.IPs) > 0 {
+			ip = p.IPs[0]
+		}
+		veth, rx, tx, rxRate, txRate := "-", "-", "-", "-", "-"
+		if p.HostVeth != "" {
+			veth = p.HostVeth
+			// The host end transmits what the pod receives.
+			if c, ok := counters[p.HostVeth]; ok {
+				rx, tx = humanBytes(c.TxBytes), humanBytes(c.RxBytes)
+				// A recreated veth restarts its counters; skip that interval.
+				if last, ok := d.prev[p.HostVeth]; ok && elapsed > 0 && c.TxBytes >= last.TxBytes && c.RxBytes >= last.RxBytes {
+					rxRate = humanBytes(uint64(float64(c.TxBytes-last.TxBytes)/elapsed)) + "/s"
+					txRate = humanBytes(uint64(float64(c.RxBytes-last.RxBytes)/elapsed)) + "/s"
+				}
+			}
+		}
+		fmt.Fprintf(&b, "  %-16s %-14s %-6s %-18s %-16s %12s %12s %10s %10s\n",
+			p.Network, shortID(p.ContainerID), p.IfName, ip, veth, rx, tx, rxRate, txRate)
+	}
+	if len(topo.Pods) == 0 {
+		b.WriteString("  (none)\n")
+	}
+	if counters != nil {
+		d.prev, d.prevTime = counters, now
+	}
+	return b.String()
+}
+
+// shortID abbreviates container IDs the way container runtimes print them.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// humanBytes renders a byte count with a binary unit.
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
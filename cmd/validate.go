@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// runValidate implements `atomicni validate`: it checks .conf/.conflist files
+// in strict mode, prints every problem with its field path, and fails when
+// any file has one.
+func runValidate(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: validate <path>...", errUsage)
+	}
+
+	problems := 0
+	for _, path := range args {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		confs, err := config.FileConfs(raw)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %v\n", path, err)
+			problems++
+			continue
+		}
+		found := 0
+		for _, conf := range confs {
+			for _, p := range config.Validate(conf) {
+				fmt.Fprintf(stdout, "%s: %v\n", path, p)
+				found++
+			}
+		}
+		if found == 0 {
+			fmt.Fprintf(stdout, "%s: ok\n", path)
+		}
+		problems += found
+	}
+	switch {
+	case problems == 1:
+		return errors.New("1 problem")
+	case problems > 1:
+		return fmt.Errorf("%d problems", problems)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// PluginInfo adds the build identity to the CNI VERSION response. Runtimes
+// ignore the extra field; support tooling can read it.
+func PluginInfo(base version.PluginInfo) version.PluginInfo {
+	return pluginInfo{base}
+}
+
+type pluginInfo struct {
+	version.PluginInfo
+}
+
+func (p pluginInfo) Encode(w io.Writer) error {
+	return json.NewEncoder(w).Encode(struct {
+		CNIVersion        string         `json:"cniVersion"`
+		SupportedVersions []string       `json:"supportedVersions,omitempty"`
+		Build             buildinfo.Info `json:"atomicniBuild"`
+	}{version.Current(), p.SupportedVersions(), buildinfo.Get()})
+}
+
+// runVersion implements `atomicni version` and `atomicni --version`.
+func runVersion(args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		return fmt.Errorf("%w: version takes no arguments", errUsage)
+	}
+	fmt.Fprintf(stdout, "atomicni %s\n", buildinfo.Get())
+	return nil
+}
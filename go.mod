@@ -7,7 +7,7 @@ require github.com/containernetworking/cni v1.3.0
 require (
 	github.com/containernetworking/plugins v1.9.0
 	github.com/vishvananda/netns v0.0.5 // indirect
-	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/sys v0.35.0
 )
 
 replace github.com/vishvananda/netns => github.com/vishvananda/netns v0.0.4
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"os"
 
 	"github.com/annis-souames/atomicni/cmd"
 	"github.com/containernetworking/cni/pkg/skel"
@@ -11,17 +11,21 @@ import (
 const CNI_VERSION = "1.1.0"
 
 func main() {
-	fmt.Println("Starting CNI plugin operations")
+	// Runtimes always set CNI_COMMAND; anything else is an operator at a shell.
+	if os.Getenv("CNI_COMMAND") == "" && len(os.Args) > 1 {
+		os.Exit(cmd.Run(os.Args[1:], os.Stdout, os.Stderr))
+	}
 
 	funcs := skel.CNIFuncs{
 		Add:   cmd.Add,
 		Del:   cmd.Del,
 		Check: cmd.Check,
+		GC:    cmd.GC,
 	}
-	// Method from CNI skel pkg that registers Add, Check, Del functions and provide info about CNI
+	// Method from CNI skel pkg that registers Add, Check, Del, GC functions and provide info about CNI
 	skel.PluginMainFuncs(
 		funcs,
-		version.VersionsStartingFrom(CNI_VERSION),
+		cmd.PluginInfo(version.VersionsStartingFrom(CNI_VERSION)),
 		"Atomic CNI Plugin - Simple CNI for learning purposes",
 	)
 
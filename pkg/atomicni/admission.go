@@ -0,0 +1,178 @@
+package atomicni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// AdmissionDir holds the admission slots and token bucket under the data dir.
+const AdmissionDir = "admission"
+
+// admissionPollInterval is how often a queued ADD retries for a slot.
+const admissionPollInterval = 20 * time.Millisecond
+
+// ErrAdmissionTimeout is returned when an ADD queued longer than
+// admission.maxWait.
+var ErrAdmissionTimeout = errors.New("admission queue wait exceeded")
+
+// bucket is the token bucket shared by every ADD process on the node.
+type bucket struct {
+	Tokens  float64   `json:"tokens"`
+	Updated time.Time `json:"updated"`
+}
+
+// admit queues the ADD described by stdin until the config's `admission`
+// limits let it start, and returns a function giving its slot back. Each ADD
+// is its own process, so the slots are flock'ed files and the bucket is a
+// file: the limits hold across processes and a crashed ADD frees its slot.
+func admit(ctx context.Context, stdin []byte, log *opLog) (release func(), err error) {
+	settings, dataDir := config.AdmissionSettings(stdin)
+	if settings == nil {
+		return func() {}, nil
+	}
+	dir := filepath.Join(dataDir, AdmissionDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create admission dir: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(settings.MaxWait)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if settings.Rate > 0 {
+		if err := takeToken(ctx, dir, settings.Rate, settings.Burst); err != nil {
+			return nil, admissionErr(ctx, err)
+		}
+	}
+	release = func() {}
+	if settings.MaxConcurrent > 0 {
+		if release, err = takeSlot(ctx, dir, settings.MaxConcurrent); err != nil {
+			return nil, admissionErr(ctx, err)
+		}
+	}
+	if waited := time.Since(start); waited >= admissionPollInterval {
+		log.printf(1, "admission: queued %s", waited.Round(time.Millisecond))
+	}
+	return release, nil
+}
+
+// admissionErr reports a wait cut short by maxWait as ErrAdmissionTimeout.
+func admissionErr(ctx context.Context, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrAdmissionTimeout
+	}
+	return err
+}
+
+// takeSlot locks one of max slot files, waiting until one is free.
+func takeSlot(ctx context.Context, dir string, max int) (func(), error) {
+	files := make([]*os.File, max)
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				_ = f.Close()
+			}
+		}
+	}()
+	for i := range files {
+		f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("slot-%d.lock", i)), os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open admission slot: %w", err)
+		}
+		files[i] = f
+	}
+	for {
+		for i, f := range files {
+			err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+			if err == nil {
+				files[i] = nil
+				return func() {
+					_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+					_ = f.Close()
+				}, nil
+			}
+			if !errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, fmt.Errorf("lock admission slot: %w", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(admissionPollInterval):
+		}
+	}
+}
+
+// takeToken removes one token from the bucket, refilling it at rate per
+// second up to burst and waiting while it is empty.
+func takeToken(ctx context.Context, dir string, rate float64, burst int) error {
+	for {
+		wait, err := tryToken(dir, rate, burst)
+		if err != nil {
+			return err
+		}
+		if wait == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tryToken takes a token if one is available, otherwise returns how long
+// until one is.
+func tryToken(dir string, rate float64, burst int) (time.Duration, error) {
+	lock, err := os.OpenFile(filepath.Join(dir, "bucket.lock"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, fmt.Errorf("open admission bucket: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return 0, fmt.Errorf("lock admission bucket: %w", err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	path := filepath.Join(dir, "bucket.json")
+	now := time.Now()
+	b := bucket{Tokens: float64(burst), Updated: now}
+	if content, err := os.ReadFile(path); err == nil {
+		// A corrupt bucket starts full rather than blocking every ADD.
+		if json.Unmarshal(content, &b) != nil {
+			b = bucket{Tokens: float64(burst), Updated: now}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("read admission bucket: %w", err)
+	}
+	if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens += elapsed * rate
+	}
+	b.Tokens = math.Min(b.Tokens, float64(burst))
+	b.Updated = now
+
+	var wait time.Duration
+	if b.Tokens >= 1 {
+		b.Tokens--
+	} else {
+		wait = time.Duration((1 - b.Tokens) / rate * float64(time.Second))
+		wait = max(wait, time.Millisecond)
+	}
+	content, err := json.Marshal(&b)
+	if err != nil {
+		return 0, fmt.Errorf("marshal admission bucket: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return 0, fmt.Errorf("write admission bucket: %w", err)
+	}
+	return wait, nil
+}
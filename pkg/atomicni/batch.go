@@ -0,0 +1,33 @@
+package atomicni
+
+import (
+	"net"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// batchHostVeth does the host side of ADD with one ip invocation: create the
+// pair, label the host end, attach it to the bridge (or address it in ptp
+// mode), and move the peer into targetNS. Cleanup of both ends is registered
+// even when the batch fails, since it may stop after creating the pair.
+func (p *Plugin) batchHostVeth(cfg *config.NetworkConfig, hostVethName, peerTempName, alias string, targetNS ns.NetNS, rollback *rollbackStack) error {
+	v := netops.HostVeth{
+		Name:    hostVethName,
+		Peer:    peerTempName,
+		MTU:     cfg.MTU,
+		Alias:   taggedVethAlias(cfg.IPAM.DataDir, alias),
+		AltName: VethAltName(alias),
+		Netns:   targetNS,
+	}
+	if cfg.Mode == config.ModePTP {
+		v.Address = &net.IPNet{IP: cloneIP(cfg.GatewayIP), Mask: net.CIDRMask(32, 32)}
+	} else {
+		v.Bridge = cfg.Bridge
+	}
+	err := p.NetOps.SetupHostVeth(v)
+	rollback.Push(rollbackStep{Op: "delete-host-veth", Link: hostVethName})
+	rollback.Push(rollbackStep{Op: "delete-container-link"})
+	return err
+}
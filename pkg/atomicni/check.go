@@ -0,0 +1,314 @@
+package atomicni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// Check performs CNI CHECK: the attachment must have been recorded by ADD,
+// and then every validator in checkValidators that applies to the network's
+// features must pass.
+func (p *Plugin) Check(ctx context.Context, args *skel.CmdArgs) error {
+	start := time.Now()
+	args, err := expandArgs(args)
+	log := p.logger(args.StdinData)
+	log.begin("CHECK", args)
+	if err == nil {
+		restore := applyExecPolicy(args.StdinData, log)
+		err = p.check(ctx, args, log)
+		restore()
+	}
+	log.end("CHECK", start, err)
+	return err
+}
+
+func (p *Plugin) check(ctx context.Context, args *skel.CmdArgs, log *opLog) error {
+	if p.NetOps == nil {
+		return fmt.Errorf("plugin has nil NetOps")
+	}
+
+	cfg, err := config.ParseDetailed(args.StdinData)
+	if err != nil {
+		return opError("parse-config", err)
+	}
+	if cfg.DisableCheck {
+		log.printf(1, "CHECK skipped: disableCheck is set")
+		return nil
+	}
+	if len(cfg.Members) > 0 {
+		return p.checkMembers(ctx, args, cfg, log)
+	}
+	if err := cfg.ApplyCNIArgs(args.Args); err != nil {
+		return opError("parse-config", err)
+	}
+	return p.executeCheck(args, cfg, log)
+}
+
+// ExecuteCheck runs CHECK for a parsed request, such as one from ParseAdd.
+// It skips the exec policy Check puts in force.
+func (p *Plugin) ExecuteCheck(ctx context.Context, req *Request) error {
+	if p.NetOps == nil {
+		return fmt.Errorf("plugin has nil NetOps")
+	}
+	log := p.logger(req.Args.StdinData)
+	defer log.close()
+	if req.Config.DisableCheck {
+		return nil
+	}
+	if len(req.Members) > 0 {
+		return p.checkMembers(ctx, req.Args, req.Config, log)
+	}
+	return p.executeCheck(req.Args, req.Config, log)
+}
+
+func (p *Plugin) executeCheck(args *skel.CmdArgs, cfg *config.NetworkConfig, log *opLog) error {
+	attachment, ok, err := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName)
+	if err != nil {
+		return opError("load-cached-attachment", err)
+	}
+	if !ok {
+		return opError("load-cached-attachment", errors.New("no attachment recorded for container"))
+	}
+
+	t := &checkTarget{
+		args:       args,
+		cfg:        cfg,
+		attachment: attachment,
+		hostVeth:   attachment.HostVeth,
+		log:        log,
+	}
+	for _, v := range checkValidators {
+		if v.enabled != nil && !v.enabled(t) {
+			continue
+		}
+		if err := v.check(p, t); err != nil {
+			return opError(v.op, err)
+		}
+	}
+	return nil
+}
+
+// checkTarget is the pod interface one CHECK validates.
+type checkTarget struct {
+	args       *skel.CmdArgs
+	cfg        *config.NetworkConfig
+	attachment *cache.Attachment
+	hostVeth   string
+	log        *opLog
+}
+
+// checkValidator verifies one feature of an attachment. enabled selects the
+// attachments the feature applies to; nil means every attachment.
+type checkValidator struct {
+	op      string
+	enabled func(t *checkTarget) bool
+	check   func(p *Plugin, t *checkTarget) error
+}
+
+// checkValidators run in order and CHECK stops at the first failure. Link
+// state comes first so a missing veth is not reported as missing rules.
+var checkValidators = []checkValidator{
+	{op: "check-host-veth", check: func(p *Plugin, t *checkTarget) error {
+		_, err := p.NetOps.GetLinkMAC(t.hostVeth)
+		return err
+	}},
+	{op: "check-mtu", check: func(p *Plugin, t *checkTarget) error {
+		return p.checkMTU(t.cfg, t.hostVeth)
+	}},
+	{op: "check-container-link", check: (*Plugin).checkContainerLink},
+	{
+		// A sandbox copies the pod's routes into its guest when it starts,
+		// so the netns no longer shows what the pod routes by.
+		op:      "check-default-route",
+		enabled: func(t *checkTarget) bool { return t.cfg.DefaultRouteMetric != 0 && t.cfg.Sandbox == "" },
+		check:   (*Plugin).checkDefaultRoute,
+	},
+	{
+		op:      "check-dscp",
+		enabled: func(t *checkTarget) bool { return t.cfg.DSCP != nil },
+		check:   (*Plugin).checkDSCP,
+	},
+	{
+		op:      "check-shaper",
+		enabled: func(t *checkTarget) bool { return t.cfg.RuntimeConfig.Bandwidth != nil },
+		check: func(p *Plugin, t *checkTarget) error {
+			return p.checkShapers(t.cfg, t.args.ContainerID, t.hostVeth, t.log)
+		},
+	},
+	{
+		op:      "check-host-route",
+		enabled: func(t *checkTarget) bool { return t.cfg.RouteProto != 0 },
+		check:   (*Plugin).checkProtoRoutes,
+	},
+	{
+		op:      "check-pod-set",
+		enabled: func(t *checkTarget) bool { return t.cfg.PodSet },
+		check:   (*Plugin).checkPodSet,
+	},
+	ruleValidator("check-dscp-rule", netops.RuleDSCP),
+	ruleValidator("check-connlimit", netops.RuleConnLimit),
+	ruleValidator("check-portmap", netops.RulePortMap),
+	ruleValidator("check-masquerade", netops.RuleMasquerade),
+	ruleValidator("check-ctzone", netops.RuleCTZone),
+	ruleValidator("check-egress-gateway", netops.RuleEgress),
+	ruleValidator("check-dns-redirect", netops.RuleDNS),
+}
+
+// ruleValidator checks that the host rules of kind ADD recorded are still
+// installed.
+func ruleValidator(op, kind string) checkValidator {
+	return checkValidator{
+		op: op,
+		enabled: func(t *checkTarget) bool {
+			return slices.ContainsFunc(t.attachment.Rules, func(r cache.Rule) bool { return r.Kind == kind })
+		},
+		check: func(p *Plugin, t *checkTarget) error {
+			var ids []string
+			for _, rule := range t.attachment.Rules {
+				if rule.Kind != kind {
+					continue
+				}
+				drift, err := p.ruleDrift(rule)
+				if err != nil {
+					return err
+				}
+				if drift != "" {
+					ids = append(ids, fmt.Sprintf("%s %s@%s (%s)", rule.Kind, rule.Key, rule.Fingerprint, drift))
+				}
+			}
+			if len(ids) > 0 {
+				return fmt.Errorf("host rules missing or changed (run `atomicni rules --reapply`): %s", strings.Join(ids, ", "))
+			}
+			return nil
+		},
+	}
+}
+
+// checkDefaultRoute verifies the pod's default route still has the metric ADD
+// reported for it. A result without a default route, because ADD kept
+// another one, has nothing to check.
+func (p *Plugin) checkDefaultRoute(t *checkTarget) error {
+	var want *types.Route
+	if res := t.attachment.Result; res != nil {
+		for _, r := range res.Routes {
+			if ones, _ := r.Dst.Mask.Size(); ones == 0 && r.Dst.IP.Equal(net.IPv4zero) {
+				want = r
+				break
+			}
+		}
+	}
+	if want == nil {
+		return nil
+	}
+	targetNS, err := p.getNS(t.args.Netns)
+	if err != nil {
+		return err
+	}
+	defer targetNS.Close()
+	metric, ok, err := p.NetOps.DefaultRouteMetric(targetNS, t.args.IfName, want.GW)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no default route via %s on %q", want.GW, t.args.IfName)
+	}
+	if metric != want.Priority {
+		return fmt.Errorf("default route via %s on %q has metric %d, want %d", want.GW, t.args.IfName, metric, want.Priority)
+	}
+	return nil
+}
+
+// checkDSCP verifies the host veth carries the configured DSCP mark.
+func (p *Plugin) checkDSCP(t *checkTarget) error {
+	got, marked, err := p.NetOps.GetDSCP(t.hostVeth)
+	if err != nil {
+		return err
+	}
+	if !marked {
+		return fmt.Errorf("no dscp mark on %q, want %d", t.hostVeth, *t.cfg.DSCP)
+	}
+	if got != *t.cfg.DSCP {
+		return fmt.Errorf("dscp mark on %q is %d, want %d", t.hostVeth, got, *t.cfg.DSCP)
+	}
+	return nil
+}
+
+// checkProtoRoutes verifies every pod address still has its routeProto route.
+func (p *Plugin) checkProtoRoutes(t *checkTarget) error {
+	for _, ip := range attachmentIPs(t.attachment) {
+		dst := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+		ok, err := p.NetOps.ProtoRoutePresent(dst, t.cfg.RouteProto)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no proto %d route for %s", t.cfg.RouteProto, dst)
+		}
+	}
+	return nil
+}
+
+// checkPodSet verifies every pod address is still in the network's pod set.
+func (p *Plugin) checkPodSet(t *checkTarget) error {
+	for _, ip := range attachmentIPs(t.attachment) {
+		ok, err := p.NetOps.PodSetHasMember(t.cfg.Name, ip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%s missing from set %s", ip, netops.PodSetName(t.cfg.Name))
+		}
+	}
+	return nil
+}
+
+// attachmentIPs returns the pod addresses of the attachment's ADD result.
+func attachmentIPs(a *cache.Attachment) []net.IP {
+	if a.Result == nil {
+		return nil
+	}
+	ips := make([]net.IP, 0, len(a.Result.IPs))
+	for _, ipc := range a.Result.IPs {
+		ips = append(ips, ipc.Address.IP)
+	}
+	return ips
+}
+
+// checkContainerLink verifies the pod interface still carries the MAC ADD
+// recorded, which catches interfaces recreated by something else. Under a
+// sandbox the shim may have moved the interface into its guest, so a missing
+// interface passes.
+func (p *Plugin) checkContainerLink(t *checkTarget) error {
+	args, attachment := t.args, t.attachment
+	if attachment.ContainerMAC == "" {
+		return nil
+	}
+	targetNS, err := p.getNS(args.Netns)
+	if err != nil {
+		return err
+	}
+	defer targetNS.Close()
+	mac, err := p.NetOps.GetLinkMACInNS(targetNS, args.IfName)
+	if t.cfg.Sandbox != "" && errors.Is(err, netops.ErrLinkNotFound) {
+		t.log.printf(1, "check-container-link: %q is not in the netns, taken by the %s sandbox", args.IfName, t.cfg.Sandbox)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if mac != attachment.ContainerMAC {
+		return fmt.Errorf("%q has MAC %s, ADD recorded %s", args.IfName, mac, attachment.ContainerMAC)
+	}
+	return nil
+}
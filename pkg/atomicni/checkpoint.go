@@ -0,0 +1,178 @@
+package atomicni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// CheckpointVersion is the format of Checkpoint blobs this build writes and
+// reads.
+const CheckpointVersion = 1
+
+// Checkpoint is one attachment's network state, exported when its container
+// is checkpointed (for example with CRIU) so that this or another node can
+// rebuild it on restore.
+type Checkpoint struct {
+	Version     int             `json:"version"`
+	Network     string          `json:"network"`
+	ContainerID string          `json:"containerID"`
+	IfName      string          `json:"ifName"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Link        netops.PodLink  `json:"link"`
+	Result      *current.Result `json:"result,omitempty"`
+	Rules       []cache.Rule    `json:"rules,omitempty"`
+}
+
+// Checkpoint exports the attachment args names as a JSON blob: the pod
+// interface's MAC, MTU, addresses, and routes as read from its netns, and
+// the cached ADD result and host rules.
+func (p *Plugin) Checkpoint(ctx context.Context, args *skel.CmdArgs) ([]byte, error) {
+	if p.NetOps == nil {
+		return nil, errors.New("plugin has nil NetOps")
+	}
+	args, err := expandArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.ParseDetailed(args.StdinData)
+	if err != nil {
+		return nil, opError("parse-config", err)
+	}
+	if len(cfg.Members) > 0 {
+		return nil, opError("parse-config", errors.New("checkpoint each member network on its own"))
+	}
+	attachment, ok, err := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName)
+	if err != nil {
+		return nil, opError("load-cached-attachment", err)
+	}
+	if !ok {
+		return nil, opError("load-cached-attachment", errors.New("no attachment recorded for container"))
+	}
+	targetNS, err := p.getNS(args.Netns)
+	if err != nil {
+		return nil, opError("open-netns", err)
+	}
+	defer targetNS.Close()
+	link, err := p.NetOps.PodLinkState(targetNS, args.IfName)
+	if err != nil {
+		return nil, opError("read-pod-link", err)
+	}
+	cp := Checkpoint{
+		Version:     CheckpointVersion,
+		Network:     cfg.Name,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		CreatedAt:   time.Now().UTC(),
+		Link:        link,
+		Result:      attachment.Result,
+		Rules:       attachment.Rules,
+	}
+	return json.MarshalIndent(cp, "", "  ")
+}
+
+// Restore rebuilds a checkpointed attachment for the container args names,
+// which may differ from the checkpointed one. It runs ADD asking for the
+// checkpointed address and MAC, fails unless that address was free, and then
+// re-adds the routes via a gateway that ADD did not install. Host rules come
+// from this node's config; rule kinds the checkpoint had but ADD did not
+// install are logged.
+func (p *Plugin) Restore(ctx context.Context, args *skel.CmdArgs, blob []byte) (*current.Result, error) {
+	var cp Checkpoint
+	if err := json.Unmarshal(blob, &cp); err != nil {
+		return nil, opError("decode-checkpoint", err)
+	}
+	if cp.Version != CheckpointVersion {
+		return nil, opError("decode-checkpoint", fmt.Errorf("checkpoint version %d, this build reads %d", cp.Version, CheckpointVersion))
+	}
+	args, err := expandArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.ParseDetailed(args.StdinData)
+	if err != nil {
+		return nil, opError("parse-config", err)
+	}
+	if cp.Network != cfg.Name || cp.IfName != args.IfName {
+		return nil, opError("decode-checkpoint", fmt.Errorf("checkpoint is for %s/%s, not %s/%s", cp.Network, cp.IfName, cfg.Name, args.IfName))
+	}
+	if len(cp.Link.Addrs) == 0 {
+		return nil, opError("decode-checkpoint", errors.New("checkpoint records no address"))
+	}
+	want, _, err := net.ParseCIDR(cp.Link.Addrs[0])
+	if err != nil {
+		return nil, opError("decode-checkpoint", err)
+	}
+
+	restoreArgs := *args
+	extra := []string{"IP=" + cp.Link.Addrs[0]}
+	if cp.Link.MAC != "" {
+		extra = append(extra, "MAC="+cp.Link.MAC)
+	}
+	restoreArgs.Args = strings.Join(append([]string{args.Args}, extra...), ";")
+	res, err := p.Add(ctx, &restoreArgs)
+	if err != nil {
+		return nil, err
+	}
+	// undo removes the attachment ADD made when the rest of the restore fails.
+	undo := func(op string, err error) (*current.Result, error) {
+		_ = p.Del(context.WithoutCancel(ctx), &restoreArgs)
+		return nil, opError(op, err)
+	}
+	if !slices.ContainsFunc(res.IPs, func(ipc *current.IPConfig) bool { return ipc.Address.IP.Equal(want) }) {
+		return undo("restore-address", fmt.Errorf("%s is not available on this node", want))
+	}
+
+	targetNS, err := p.getNS(args.Netns)
+	if err != nil {
+		return undo("open-netns", err)
+	}
+	defer targetNS.Close()
+	for _, r := range cp.Link.Routes {
+		if r.Gateway == "" || r.Dst == "default" {
+			// Link routes come with the address; the default route is ADD's.
+			continue
+		}
+		dst := r.Dst
+		if !strings.Contains(dst, "/") {
+			dst += "/32"
+		}
+		_, dstNet, err := net.ParseCIDR(dst)
+		if err != nil {
+			return undo("restore-route", err)
+		}
+		gw := net.ParseIP(r.Gateway)
+		if gw == nil {
+			return undo("restore-route", fmt.Errorf("invalid gateway %q", r.Gateway))
+		}
+		if err := p.NetOps.AddRoute(targetNS, args.IfName, dstNet, gw); err != nil {
+			return undo("restore-route", err)
+		}
+	}
+
+	if attachment, ok, _ := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName); ok {
+		var missing []string
+		for _, rule := range cp.Rules {
+			if !slices.Contains(missing, rule.Kind) && !slices.ContainsFunc(attachment.Rules, func(r cache.Rule) bool { return r.Kind == rule.Kind }) {
+				missing = append(missing, rule.Kind)
+			}
+		}
+		if len(missing) > 0 {
+			log := p.logger(args.StdinData)
+			log.printf(0, "restore: this node's config installs no %s rules the checkpoint had", strings.Join(missing, ", "))
+			log.close()
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,143 @@
+package atomicni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// GCNetwork performs CNI GC: every attachment of the config's network the
+// runtime did not list in cni.dev/valid-attachments is torn down the way
+// Drain does it, and allocations no valid attachment owns are released.
+// With disableGC set it succeeds without looking. Unlike GC, it never
+// consults the netns or the CRI runtime; the runtime's list is final.
+func (p *Plugin) GCNetwork(ctx context.Context, args *skel.CmdArgs) error {
+	start := time.Now()
+	args, err := expandArgs(args)
+	log := p.logger(args.StdinData)
+	log.begin("GC", args)
+	if err == nil {
+		restore := applyExecPolicy(args.StdinData, log)
+		err = p.gcNetwork(ctx, args, log)
+		restore()
+	}
+	log.end("GC", start, err)
+	return err
+}
+
+func (p *Plugin) gcNetwork(ctx context.Context, args *skel.CmdArgs, log *opLog) error {
+	if p.NetOps == nil {
+		return fmt.Errorf("plugin has nil NetOps")
+	}
+	if p.IPAM == nil {
+		return fmt.Errorf("plugin has nil IPAM allocator")
+	}
+	cfg, err := config.ParseDetailed(args.StdinData)
+	if err != nil {
+		return opError("parse-config", err)
+	}
+	if cfg.DisableGC {
+		log.printf(1, "GC skipped: disableGC is set")
+		return nil
+	}
+	var list struct {
+		Valid []types.GCAttachment `json:"cni.dev/valid-attachments"`
+	}
+	if err := json.Unmarshal(args.StdinData, &list); err != nil {
+		return opError("parse-config", err)
+	}
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
+	if len(cfg.Members) == 0 {
+		return p.gcAttachments(ctx, cfg, list.Valid)
+	}
+	var errs []error
+	for i, m := range cfg.Members {
+		if m.Config.DisableGC {
+			continue
+		}
+		// Members record their attachments under the interface ADD gave them.
+		valid := make([]types.GCAttachment, len(list.Valid))
+		for j, v := range list.Valid {
+			valid[j] = types.GCAttachment{ContainerID: v.ContainerID, IfName: MemberIfName(v.IfName, i)}
+		}
+		if err := p.gcAttachments(ctx, m.Config, valid); err != nil {
+			errs = append(errs, fmt.Errorf("network %s: %w", m.Config.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// gcAttachments reclaims what cfg's network holds for attachments outside valid.
+func (p *Plugin) gcAttachments(ctx context.Context, cfg *config.NetworkConfig, valid []types.GCAttachment) error {
+	dataDir := cfg.IPAM.DataDir
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return opError("list-attachments", err)
+	}
+	// keep holds the IPAM owners of valid attachments and of those that
+	// failed to tear down, which a later GC retries.
+	keep := map[string]bool{}
+	for _, v := range valid {
+		keep[v.ContainerID] = true
+		keep[ipamOwner(v.ContainerID, v.IfName, true)] = true
+	}
+
+	var errs []error
+	for _, a := range attachments {
+		if a.Network != cfg.Name || keep[ipamOwner(a.ContainerID, a.IfName, true)] {
+			continue
+		}
+		if _, err := p.recoverTxn(dataDir, a.Network, a.ContainerID, a.IfName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), opError("recover-txn", err)))
+		}
+		if err := p.drainAttachment(dataDir, a); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), err))
+			keep[a.ContainerID] = true
+			keep[ipamOwner(a.ContainerID, a.IfName, true)] = true
+			continue
+		}
+		if err := cache.Delete(dataDir, a.Network, a.ContainerID, a.IfName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), opError("delete-cached-attachment", err)))
+			continue
+		}
+		p.emit(cfg, events.Event{Type: events.AttachmentDeleted, Network: a.Network, ContainerID: a.ContainerID, IfName: a.IfName, Message: "removed by gc"})
+	}
+
+	allocations, err := p.IPAM.List(ctx, dataDir, cfg.Name)
+	if err != nil {
+		return errors.Join(append(errs, opError("list-allocations", err))...)
+	}
+	for owner, ip := range allocations {
+		if keep[owner] {
+			continue
+		}
+		containerID, ifName, _ := strings.Cut(owner, "/")
+		if err := p.NetOps.DeleteLink(p.hostVethName(containerID, ifName)); err != nil {
+			errs = append(errs, fmt.Errorf("delete-host-veth %q: %w", owner, err))
+		}
+		if cfg.PodSet {
+			if err := p.NetOps.RemovePodSetMember(cfg.Name, ip); err != nil {
+				errs = append(errs, fmt.Errorf("remove-pod-set-member %q: %w", owner, err))
+			}
+		}
+		if err := p.deleteReleasedRoute(ip, cfg.RouteProto); err != nil {
+			errs = append(errs, fmt.Errorf("delete-host-route %q: %w", owner, err))
+		}
+		if err := p.IPAM.ForceRelease(ctx, dataDir, cfg.Name, owner); err != nil {
+			errs = append(errs, fmt.Errorf("release-ip %q: %w", owner, err))
+			continue
+		}
+		p.emit(cfg, events.Event{Type: events.IPReleased, Network: cfg.Name, ContainerID: containerID, IfName: ifName, IP: ip.String(), Message: "reclaimed by gc"})
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,12 @@
+package atomicni
+
+import "hash/fnv"
+
+// ConntrackZone derives the conntrack zone of an attachment from its key.
+// Zones are 16-bit and zone 0 is the host default, so keys hash into
+// 1-65535; a collision only means two pods share a connection table.
+func ConntrackZone(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32()%65535) + 1
+}
@@ -0,0 +1,305 @@
+package atomicni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// DrainReport summarizes one Drain pass over a data dir.
+type DrainReport struct {
+	// Drained are the cache keys of the attachments torn down.
+	Drained []string
+	// Released are the IPAM owners released, as network/owner.
+	Released []string
+	// Orphans are host veths deleted that no attachment or allocation named.
+	Orphans []string
+	// Leaks is what is still on the node after the pass.
+	Leaks []string
+}
+
+// Drain releases every attachment recorded under dataDir, for decommissioning
+// a node: for each one it does what DEL does under the network config ADD
+// recorded (host veth, host rules, pod interface, pod set membership,
+// allocation, cached record), then releases allocations no attachment owns
+// and deletes the remaining host veths tagged for dataDir. Veths of other
+// data dirs are left alone. It finishes by listing what is left in Leaks.
+func (p *Plugin) Drain(ctx context.Context, dataDir string) (*DrainReport, error) {
+	if p.NetOps == nil {
+		return nil, errors.New("plugin has nil NetOps")
+	}
+	if p.IPAM == nil {
+		return nil, errors.New("plugin has nil IPAM allocator")
+	}
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("list-attachments: %w", err)
+	}
+
+	protos := recordedRouteProtos(dataDir, attachments)
+	report := &DrainReport{}
+	var errs []error
+	// kept holds the owners of attachments that failed to drain: their record
+	// and address stay so a second drain retries them.
+	kept := map[string]bool{}
+	keptVeths := map[string]bool{}
+	for _, a := range attachments {
+		if _, err := p.recoverTxn(dataDir, a.Network, a.ContainerID, a.IfName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), err))
+		}
+		if err := p.drainAttachment(dataDir, a); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), err))
+			kept[a.Network+"/"+a.ContainerID] = true
+			kept[a.Network+"/"+ipamOwner(a.ContainerID, a.IfName, true)] = true
+			keptVeths[a.HostVeth] = true
+			continue
+		}
+		if err := cache.Delete(dataDir, a.Network, a.ContainerID, a.IfName); err != nil {
+			errs = append(errs, fmt.Errorf("%s: delete-cached-attachment: %w", a.Key(), err))
+			continue
+		}
+		report.Drained = append(report.Drained, a.Key())
+		p.emitDrain(events.Event{Type: events.AttachmentDeleted, Network: a.Network, ContainerID: a.ContainerID, IfName: a.IfName})
+	}
+
+	networks, err := p.IPAM.Networks(ctx, dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("list-networks: %w", err)
+	}
+	for _, network := range networks {
+		allocations, err := p.IPAM.List(ctx, dataDir, network)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list-allocations %q: %w", network, err))
+			continue
+		}
+		for owner, ip := range allocations {
+			if kept[network+"/"+owner] {
+				continue
+			}
+			containerID, ifName, _ := strings.Cut(owner, "/")
+			// Owners without an attachment get GC's treatment.
+			if err := p.NetOps.DeleteLink(p.hostVethName(containerID, ifName)); err != nil {
+				errs = append(errs, fmt.Errorf("delete-host-veth %q: %w", owner, err))
+			}
+			if err := p.NetOps.RemovePodSetMember(network, ip); err != nil {
+				errs = append(errs, fmt.Errorf("remove-pod-set-member %q: %w", owner, err))
+			}
+			if err := p.deleteReleasedRoute(ip, protos[network]); err != nil {
+				errs = append(errs, fmt.Errorf("delete-host-route %q: %w", owner, err))
+			}
+			if err := p.IPAM.ForceRelease(ctx, dataDir, network, owner); err != nil {
+				errs = append(errs, fmt.Errorf("release-ip %q: %w", owner, err))
+				continue
+			}
+			report.Released = append(report.Released, network+"/"+owner)
+			p.emitDrain(events.Event{Type: events.IPReleased, Network: network, ContainerID: containerID, IfName: ifName, IP: ip.String(), Message: "released by drain"})
+		}
+	}
+
+	links, err := p.dataDirLinks(dataDir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, l := range links {
+		if keptVeths[l.Name] {
+			continue
+		}
+		if err := p.NetOps.DeleteLink(l.Name); err != nil {
+			errs = append(errs, fmt.Errorf("delete-orphan-veth %q: %w", l.Name, err))
+			continue
+		}
+		report.Orphans = append(report.Orphans, l.Name)
+	}
+
+	leaks, err := p.drainLeaks(ctx, dataDir, attachments)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	report.Leaks = leaks
+	return report, errors.Join(errs...)
+}
+
+// drainAttachment tears down what a's ADD created on the host and in the pod
+// netns with DEL's teardown under the recorded network config, and leaves the
+// set of pods its network keeps.
+func (p *Plugin) drainAttachment(dataDir string, a *cache.Attachment) error {
+	cfg, err := attachmentConfig(dataDir, a)
+	if err != nil {
+		return err
+	}
+	errs := p.teardown(attachmentArgs(a), cfg)
+	if a.Result != nil {
+		// Drain has no config to tell whether the network keeps a pod set;
+		// removing a member of a missing set is a no-op.
+		for _, ipc := range a.Result.IPs {
+			if err := p.NetOps.RemovePodSetMember(a.Network, ipc.Address.IP); err != nil {
+				errs = append(errs, fmt.Errorf("remove-pod-set-member: %w", err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attachmentConfig parses the network config a's ADD ran with.
+func attachmentConfig(dataDir string, a *cache.Attachment) (*config.NetworkConfig, error) {
+	cfg, err := config.ParseDetailed(a.Config)
+	if err != nil {
+		return nil, fmt.Errorf("parse-recorded-config: %w", err)
+	}
+	if cfg.Name != a.Network {
+		return nil, fmt.Errorf("parse-recorded-config: config is for network %q", cfg.Name)
+	}
+	// The record is where the attachment lives, whatever the config says.
+	cfg.IPAM.DataDir = dataDir
+	return cfg, nil
+}
+
+// attachmentArgs rebuilds the CNI arguments of a's ADD from its record.
+func attachmentArgs(a *cache.Attachment) *skel.CmdArgs {
+	return &skel.CmdArgs{
+		ContainerID: a.ContainerID,
+		Netns:       a.Netns,
+		IfName:      a.IfName,
+		Args:        a.CNIArgs,
+		StdinData:   a.Config,
+	}
+}
+
+// drainLeaks lists what survived a drain: cached attachments, allocations,
+// the data dir's host veths, and the host state of the drained attachments.
+func (p *Plugin) drainLeaks(ctx context.Context, dataDir string, drained []*cache.Attachment) ([]string, error) {
+	var leaks []string
+	var errs []error
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("list-attachments: %w", err))
+	}
+	for _, a := range attachments {
+		leaks = append(leaks, "attachment "+a.Key())
+	}
+	networks, err := p.IPAM.Networks(ctx, dataDir)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("list-networks: %w", err))
+	}
+	for _, network := range networks {
+		allocations, err := p.IPAM.List(ctx, dataDir, network)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list-allocations %q: %w", network, err))
+			continue
+		}
+		for owner, ip := range allocations {
+			leaks = append(leaks, fmt.Sprintf("allocation %s/%s %s", network, owner, ip))
+		}
+	}
+	links, err := p.dataDirLinks(dataDir)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, l := range links {
+		leaks = append(leaks, "veth "+l.Name)
+	}
+	for _, a := range drained {
+		left, err := p.attachmentLeaks(dataDir, a)
+		leaks = append(leaks, left...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), err))
+		}
+	}
+	sort.Strings(leaks)
+	return leaks, errors.Join(errs...)
+}
+
+// attachmentLeaks lists the host state of a drained attachment that is still
+// installed: its recorded rules and the kinds DEL removes beyond them (routeProto routes, the firewalld binding, static
+// neighbors, and conntrack zone entries).
+func (p *Plugin) attachmentLeaks(dataDir string, a *cache.Attachment) ([]string, error) {
+	var leaks []string
+	var errs []error
+	zoned := false
+	for _, rule := range a.Rules {
+		zoned = zoned || rule.Kind == netops.RuleCTZone
+		digest, err := p.NetOps.RuleDigest(rule.Kind, rule.Key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check-rule %s %q: %w", rule.Kind, rule.Key, err))
+			continue
+		}
+		if digest != "" {
+			leaks = append(leaks, fmt.Sprintf("rule %s %s", rule.Kind, rule.Key))
+		}
+	}
+	cfg, err := attachmentConfig(dataDir, a)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		zoned = zoned || cfg.ConntrackZones
+		if cfg.Mode == config.ModePTP {
+			if zone := p.firewalldZone(cfg); zone != "" && p.NetOps.FirewalldBound(zone, a.HostVeth) {
+				leaks = append(leaks, fmt.Sprintf("firewalld %s %s", zone, a.HostVeth))
+			}
+		}
+		if a.Result != nil {
+			for _, ipc := range a.Result.IPs {
+				ip := ipc.Address.IP
+				if cfg.RouteProto != 0 {
+					dst := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+					ok, err := p.NetOps.ProtoRoutePresent(dst, cfg.RouteProto)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("check-host-route %s: %w", dst, err))
+					} else if ok {
+						leaks = append(leaks, fmt.Sprintf("route %s proto %d", dst, cfg.RouteProto))
+					}
+				}
+				if cfg.StaticNeighbors.Pod && cfg.Mode == config.ModeBridge {
+					ok, err := p.NetOps.HostStaticNeighborPresent(cfg.Bridge, ip)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("check-static-neighbor %s: %w", ip, err))
+					} else if ok {
+						leaks = append(leaks, fmt.Sprintf("neighbor %s %s", cfg.Bridge, ip))
+					}
+				}
+			}
+		}
+	}
+	if zoned {
+		zone := ConntrackZone(a.Key())
+		n, err := p.NetOps.ConntrackZoneEntries(zone)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check-ctzone %d: %w", zone, err))
+		} else if n > 0 {
+			leaks = append(leaks, fmt.Sprintf("conntrack zone %d (%d entries)", zone, n))
+		}
+	}
+	return leaks, errors.Join(errs...)
+}
+
+// dataDirLinks lists the host veths whose alias carries dataDir's owner tag.
+func (p *Plugin) dataDirLinks(dataDir string) ([]netops.OwnedLink, error) {
+	links, err := p.NetOps.ListOwnedLinks("", p.names().HostPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("list-owned-links: %w", err)
+	}
+	tag := vethOwnerTag(dataDir)
+	var owned []netops.OwnedLink
+	for _, l := range links {
+		if owner, _ := splitVethAlias(l.Alias); owner == tag {
+			owned = append(owned, l)
+		}
+	}
+	return owned, nil
+}
+
+// emitDrain sends a drain event; like GC's, it has no config to filter by.
+func (p *Plugin) emitDrain(ev events.Event) {
+	if p.Events != nil {
+		_ = p.Events.Emit(ev)
+	}
+}
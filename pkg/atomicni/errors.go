@@ -0,0 +1,126 @@
+package atomicni
+
+import "errors"
+
+// OpError records which plugin step failed so callers can classify failures.
+type OpError struct {
+	Op  string
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// opError wraps err with the name of the failing step.
+func opError(op string, err error) error {
+	return &OpError{Op: op, Err: err}
+}
+
+// opStages buckets step names into coarse stages used by metrics.
+var opStages = map[string]string{
+	"parse-config":             "config",
+	"admit":                    "admission",
+	"plan-add":                 "config",
+	"check-subnet-overlap":     "config",
+	"open-netns":               "netns",
+	"ensure-qinq-uplink":       "bridge",
+	"ensure-bridge":            "bridge",
+	"ensure-mtu":               "bridge",
+	"set-bridge-options":       "bridge",
+	"tune-neighbors":           "bridge",
+	"name-veth":                "veth",
+	"create-veth":              "veth",
+	"set-link-alias":           "veth",
+	"set-host-mac":             "veth",
+	"set-vlan-trunk":           "veth",
+	"isolate-port":             "veth",
+	"attach-host-veth":         "veth",
+	"setup-ptp-host":           "veth",
+	"add-host-route":           "route",
+	"delete-host-route":        "route",
+	"add-service-route":        "route",
+	"ensure-service-route":     "route",
+	"add-dns-route":            "route",
+	"add-pod-routes":           "route",
+	"set-pod-sysctls":          "datapath",
+	"move-peer-to-netns":       "veth",
+	"prepare-container-link":   "veth",
+	"read-host-mac":            "veth",
+	"delete-host-veth":         "veth",
+	"delete-container-link":    "veth",
+	"set-dscp":                 "qos",
+	"clear-dscp":               "qos",
+	"set-connlimit":            "qos",
+	"clear-connlimit":          "qos",
+	"check-dscp-rule":          "firewall",
+	"check-connlimit":          "firewall",
+	"check-portmap":            "firewall",
+	"check-masquerade":         "firewall",
+	"check-ctzone":             "firewall",
+	"check-egress-gateway":     "firewall",
+	"check-dns-redirect":       "firewall",
+	"check-pod-set":            "firewall",
+	"check-host-route":         "route",
+	"check-default-route":      "route",
+	"firewalld-trust":          "firewall",
+	"firewalld-untrust":        "firewall",
+	"set-masquerade":           "firewall",
+	"clear-masquerade":         "firewall",
+	"set-ctzone":               "firewall",
+	"clear-ctzone":             "firewall",
+	"set-portmap":              "firewall",
+	"clear-portmap":            "firewall",
+	"set-egress-gateway":       "firewall",
+	"clear-egress-gateway":     "firewall",
+	"set-dns-redirect":         "firewall",
+	"clear-dns-redirect":       "firewall",
+	"add-pod-set-member":       "firewall",
+	"remove-pod-set-member":    "firewall",
+	"check-dscp":               "qos",
+	"check-host-veth":          "veth",
+	"check-mtu":                "veth",
+	"check-shaper":             "qos",
+	"check-container-link":     "veth",
+	"set-container-mac":        "veth",
+	"read-pod-link":            "veth",
+	"decode-checkpoint":        "config",
+	"restore-address":          "ipam",
+	"restore-route":            "route",
+	"load-cached-attachment":   "cache",
+	"partition-subnet":         "ipam",
+	"alloc-ip":                 "ipam",
+	"release-ip":               "ipam",
+	"configure-container-ip":   "address",
+	"set-static-neighbor":      "address",
+	"delete-static-neighbor":   "address",
+	"verify-connectivity":      "datapath",
+	"validate-result":          "result",
+	"enable-state-encryption":  "cache",
+	"cache-attachment":         "cache",
+	"cache-result":             "cache",
+	"log-txn":                  "cache",
+	"recover-txn":              "cache",
+	"delete-cached-attachment": "cache",
+	"list-attachments":         "cache",
+	"list-allocations":         "ipam",
+}
+
+// Stage returns the coarse stage of the first failing step in err, "" for nil,
+// and "other" when the step is unknown.
+func Stage(err error) string {
+	if err == nil {
+		return ""
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		if stage, ok := opStages[opErr.Op]; ok {
+			return stage
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,31 @@
+package atomicni
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// applyExecPolicy puts the config's `exec` settings in force for one
+// operation and returns a function restoring the previous policy. Commands
+// are logged at debug, or always with `exec.audit`; refused ones are logged
+// as errors.
+func applyExecPolicy(stdin []byte, log *opLog) (restore func()) {
+	settings := config.ExecSettings(stdin)
+	return netops.SetExecPolicy(netops.ExecPolicy{
+		Allow: settings.Allow,
+		Audit: func(name string, args []string, err error) {
+			line := strings.Join(append([]string{name}, args...), " ")
+			switch {
+			case errors.Is(err, netops.ErrExecDenied):
+				log.printf(0, "exec refused: %s", line)
+			case settings.Audit:
+				log.audit("exec: %s (err=%v)", line, err)
+			default:
+				log.printf(2, "exec: %s (err=%v)", line, err)
+			}
+		},
+	})
+}
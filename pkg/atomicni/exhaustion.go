@@ -0,0 +1,167 @@
+package atomicni
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/metrics"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// ExhaustionReason is the reason of the Node event posted for a pool warning.
+const ExhaustionReason = "IPAMPoolNearlyExhausted"
+
+// warnedSuffix names the file marking a network whose warning has fired, so
+// it fires once per crossing instead of on every later ADD. ADD or DEL
+// removes it once the pool is back under the threshold.
+const warnedSuffix = ".warned"
+
+// NodeWarning is a pool warning waiting for atomicnid to post it as a Node
+// event. ADD only records it in the network's warned marker, so a slow API
+// server never holds up ADD.
+type NodeWarning struct {
+	Network    string `json:"network"`
+	Kubeconfig string `json:"kubeconfig"`
+	NodeName   string `json:"nodeName"`
+	Message    string `json:"message"`
+	Posted     bool   `json:"posted,omitempty"`
+}
+
+// poolUsage returns how many addresses of the network's pool are allocated
+// and how many it holds; ok is false when either is unknown.
+func (p *Plugin) poolUsage(ctx context.Context, cfg *config.NetworkConfig) (used, capacity int, ok bool) {
+	if cfg.SubnetNet == nil {
+		return 0, 0, false
+	}
+	req := ipam.AllocationRequest{Subnet: cfg.SubnetNet, Gateway: cfg.GatewayIP, RangeStart: cfg.RangeStartIP, RangeEnd: cfg.RangeEndIP}
+	for _, r := range cfg.Ranges {
+		req.Ranges = append(req.Ranges, ipam.Range{Start: r.Start, End: r.End, Priority: r.Priority})
+	}
+	allocations, err := p.IPAM.List(ctx, cfg.IPAM.DataDir, cfg.Name)
+	if err != nil || req.Capacity() == 0 {
+		return 0, 0, false
+	}
+	return len(allocations), req.Capacity(), true
+}
+
+// belowThreshold reports whether used is under threshold percent of capacity.
+func belowThreshold(used, capacity, threshold int) bool {
+	return used*100 < threshold*capacity
+}
+
+// warnUtilization warns after an allocation once the network's pool reaches
+// the configured threshold. Warnings never fail ADD.
+func (p *Plugin) warnUtilization(ctx context.Context, cfg *config.NetworkConfig, args *skel.CmdArgs) {
+	w := cfg.IPAM.ExhaustionWarning
+	if w == nil {
+		return
+	}
+	used, capacity, ok := p.poolUsage(ctx, cfg)
+	if !ok {
+		return
+	}
+	marker := filepath.Join(cfg.IPAM.DataDir, cfg.Name+warnedSuffix)
+	if belowThreshold(used, capacity, w.Threshold) {
+		_ = os.Remove(marker)
+		return
+	}
+	// O_EXCL picks one ADD to warn when several cross the threshold at once.
+	f, err := os.OpenFile(marker, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return
+	}
+	message := fmt.Sprintf("network %s pool is %d%% allocated (%d of %d addresses), warning threshold %d%%", cfg.Name, used*100/capacity, used, capacity, w.Threshold)
+	log := p.logger(args.StdinData)
+	defer log.close()
+	if w.Kubeconfig != "" {
+		pending, _ := json.Marshal(NodeWarning{Network: cfg.Name, Kubeconfig: w.Kubeconfig, NodeName: w.NodeName, Message: message})
+		if _, err := f.Write(pending); err != nil {
+			log.printf(0, "ipam: record node warning: %v", err)
+		}
+	}
+	_ = f.Close()
+
+	log.printf(0, "ipam: %s", message)
+	p.emit(cfg, events.Event{
+		Type:        events.IPPoolNearlyFull,
+		Network:     cfg.Name,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		Message:     message,
+	})
+	if err := metrics.RecordUtilizationWarning(cfg.IPAM.DataDir, cfg.Name, used, capacity); err != nil {
+		log.printf(0, "ipam: record utilization warning: %v", err)
+	}
+}
+
+// PendingNodeWarnings returns the warnings recorded in dataDir that atomicnid
+// has not posted yet. A marker still being written is picked up next time.
+func PendingNodeWarnings(dataDir string) ([]NodeWarning, error) {
+	markers, err := filepath.Glob(filepath.Join(dataDir, "*"+warnedSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("list warned markers: %w", err)
+	}
+	var pending []NodeWarning
+	for _, marker := range markers {
+		content, err := os.ReadFile(marker)
+		if err != nil {
+			continue
+		}
+		var w NodeWarning
+		if json.Unmarshal(content, &w) != nil || w.Posted || w.Kubeconfig == "" {
+			continue
+		}
+		pending = append(pending, w)
+	}
+	return pending, nil
+}
+
+// MarkNodeWarningPosted records that w was posted. A marker removed or
+// rewritten by a later crossing meanwhile is left alone.
+func MarkNodeWarningPosted(dataDir string, w NodeWarning) error {
+	marker := filepath.Join(dataDir, w.Network+warnedSuffix)
+	recorded, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("marshal node warning: %w", err)
+	}
+	content, err := os.ReadFile(marker)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && !bytes.Equal(content, recorded)) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read warned marker: %w", err)
+	}
+	w.Posted = true
+	posted, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("marshal node warning: %w", err)
+	}
+	if err := os.WriteFile(marker, posted, 0o644); err != nil {
+		return fmt.Errorf("mark node warning posted: %w", err)
+	}
+	return nil
+}
+
+// rearmUtilization lets the warning fire again once DEL brings the pool back
+// under the threshold.
+func (p *Plugin) rearmUtilization(ctx context.Context, cfg *config.NetworkConfig) {
+	w := cfg.IPAM.ExhaustionWarning
+	if w == nil {
+		return
+	}
+	marker := filepath.Join(cfg.IPAM.DataDir, cfg.Name+warnedSuffix)
+	if _, err := os.Stat(marker); err != nil {
+		return
+	}
+	if used, capacity, ok := p.poolUsage(ctx, cfg); ok && belowThreshold(used, capacity, w.Threshold) {
+		_ = os.Remove(marker)
+	}
+}
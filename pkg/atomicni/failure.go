@@ -0,0 +1,33 @@
+package atomicni
+
+import (
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// recordFailure marks the attachment of a failed ADD whose rollback policy
+// kept state, so DEL or, after the retry window, GC can finish the cleanup.
+// Best effort: the ADD error matters more than the record.
+func (p *Plugin) recordFailure(cfg *config.NetworkConfig, attachment *cache.Attachment, op string, opErr error) {
+	policy := cfg.RollbackPolicy()
+	if policy == config.RollbackKeepAllocation {
+		// The rollback cleared the rules.
+		attachment.Rules = nil
+	}
+	now := time.Now().UTC()
+	attachment.Failure = &cache.Failure{
+		Op:         op,
+		Error:      opErr.Error(),
+		Policy:     policy,
+		At:         now,
+		RetryUntil: now.Add(cfg.RollbackRetryWindow()),
+	}
+	_ = cache.Save(cfg.IPAM.DataDir, attachment)
+}
+
+// failureExpired reports whether a failed ADD's retry window has passed.
+func failureExpired(a *cache.Attachment, now time.Time) bool {
+	return a.Failure != nil && now.After(a.Failure.RetryUntil)
+}
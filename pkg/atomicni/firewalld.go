@@ -0,0 +1,15 @@
+package atomicni
+
+import (
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// firewalldZone returns the zone AtomicNI interfaces must join, or "" when the
+// integration is disabled or firewalld is not running on this host.
+func (p *Plugin) firewalldZone(cfg *config.NetworkConfig) string {
+	zone := cfg.FirewalldZone()
+	if zone == "" || !p.NetOps.FirewalldRunning() {
+		return ""
+	}
+	return zone
+}
@@ -0,0 +1,163 @@
+package atomicni
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/clock"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// GatewayMonitor probes the egress gateways that have a backup and moves
+// their routing table's default route between gateway and backup. Counters
+// live in memory: the route itself records which gateway is active, so a
+// restarted monitor carries on from it.
+type GatewayMonitor struct {
+	Plugin  *Plugin
+	DataDir string
+	// Clock schedules probes; nil means the system clock.
+	Clock clock.Clock
+
+	tables map[int]*gatewayHealth
+}
+
+// gatewayHealth tracks one egress table across probe rounds.
+type gatewayHealth struct {
+	spec    netops.EgressGateway
+	network string
+	// streak counts consecutive probes of the gateway contradicting the
+	// active route: failures while on it, successes while on the backup.
+	streak int
+	next   time.Time
+}
+
+// GatewayReport summarizes one probe round.
+type GatewayReport struct {
+	Probed   int
+	Switched []string
+}
+
+// NewGatewayMonitor returns a monitor for the attachments in dataDir.
+func NewGatewayMonitor(p *Plugin, dataDir string) *GatewayMonitor {
+	return &GatewayMonitor{Plugin: p, DataDir: dataDir, tables: map[int]*gatewayHealth{}}
+}
+
+// Probe probes every egress gateway whose interval has elapsed and fails
+// over to its backup after ProbeFailures failures in a row, or back after as
+// many successes in a row. It only moves to a backup that answers.
+func (m *GatewayMonitor) Probe(ctx context.Context) (*GatewayReport, error) {
+	if m.Plugin == nil || m.Plugin.NetOps == nil {
+		return nil, errors.New("gateway monitor has nil NetOps")
+	}
+	attachments, err := cache.List(m.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	specs, networks := backedEgressGateways(attachments)
+	for table := range m.tables {
+		if _, ok := specs[table]; !ok {
+			delete(m.tables, table)
+		}
+	}
+	tables := make([]int, 0, len(specs))
+	for table := range specs {
+		tables = append(tables, table)
+	}
+	sort.Ints(tables)
+
+	now := clock.Or(m.Clock).Now
+	ops := m.Plugin.NetOps
+	report := &GatewayReport{}
+	var errs []error
+	for _, table := range tables {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		spec := specs[table]
+		h := m.tables[table]
+		if h == nil || !sameGateways(h.spec, spec) {
+			h = &gatewayHealth{spec: spec, network: networks[table]}
+			m.tables[table] = h
+		}
+		if now().Before(h.next) {
+			continue
+		}
+		h.next = now().Add(time.Duration(spec.ProbeInterval) * time.Second)
+		report.Probed++
+
+		via, err := ops.EgressRoute(table)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		onBackup := via.Equal(spec.Backup)
+		if down := ops.ProbeGateway(spec.Gateway, spec.Probe) != nil; down != onBackup {
+			h.streak++
+		} else {
+			h.streak = 0
+		}
+		if h.streak < spec.ProbeFailures {
+			continue
+		}
+
+		target, kind := spec.Backup, events.GatewayFailover
+		if onBackup {
+			target, kind = spec.Gateway, events.GatewayFailback
+		} else if err := ops.ProbeGateway(spec.Backup, spec.Probe); err != nil {
+			// Stay put and retry next round; the streak is kept.
+			errs = append(errs, fmt.Errorf("table %d: gateway %s is down and so is backup: %w", table, spec.Gateway, err))
+			continue
+		}
+		if err := ops.SetEgressRoute(table, target); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		h.streak = 0
+		msg := fmt.Sprintf("table %d via %s after %d probes of %s", table, target, spec.ProbeFailures, spec.Gateway)
+		report.Switched = append(report.Switched, msg)
+		if m.Plugin.Events != nil {
+			_ = m.Plugin.Events.Emit(events.Event{
+				Time:    now(),
+				Type:    kind,
+				Network: h.network,
+				IP:      target.String(),
+				Message: msg,
+			})
+		}
+	}
+	return report, errors.Join(errs...)
+}
+
+// backedEgressGateways returns the recorded egress gateways that have a
+// backup by table, with the network of the first attachment using each.
+func backedEgressGateways(attachments []*cache.Attachment) (map[int]netops.EgressGateway, map[int]string) {
+	specs := map[int]netops.EgressGateway{}
+	networks := map[int]string{}
+	for _, a := range attachments {
+		for _, rule := range a.Rules {
+			if rule.Kind != netops.RuleEgress {
+				continue
+			}
+			var spec netops.EgressGateway
+			if err := json.Unmarshal(rule.Spec, &spec); err != nil || spec.Backup == nil {
+				continue
+			}
+			if _, ok := specs[spec.Table]; !ok {
+				specs[spec.Table], networks[spec.Table] = spec, a.Network
+			}
+		}
+	}
+	return specs, networks
+}
+
+// sameGateways reports whether two specs probe the same pair the same way.
+func sameGateways(a, b netops.EgressGateway) bool {
+	return a.Gateway.Equal(b.Gateway) && a.Backup.Equal(b.Backup) && a.Probe == b.Probe &&
+		a.ProbeInterval == b.ProbeInterval && a.ProbeFailures == b.ProbeFailures
+}
@@ -0,0 +1,260 @@
+package atomicni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/cri"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/annis-souames/atomicni/pkg/netnsutil"
+)
+
+// GCReport summarizes one reconciliation pass over a data dir.
+type GCReport struct {
+	Checked  int
+	Released []string
+	Pruned   []string
+	Kept     []string
+	// Orphans are host veths left behind without any cached attachment.
+	Orphans []string
+}
+
+// GC reconciles IPAM state under dataDir against cached attachments. An
+// allocation is reclaimed when no cached attachment owns it, when the owning
+// attachment's netns no longer exists, or when it belongs to a failed ADD
+// whose retry window has passed; such attachments are torn down the way
+// drain does before their record goes. When a Runtime is set, or the
+// network's recorded config names a criSocket, the container runtime has the
+// final say: sandboxes it still reports (or cannot answer for) are kept.
+// Host veths tagged for dataDir that no attachment accounts for are deleted
+// as well.
+func (p *Plugin) GC(ctx context.Context, dataDir string) (*GCReport, error) {
+	if p.NetOps == nil {
+		return nil, fmt.Errorf("plugin has nil NetOps")
+	}
+	if p.IPAM == nil {
+		return nil, fmt.Errorf("plugin has nil IPAM allocator")
+	}
+
+	report := &GCReport{}
+	var errs []error
+	// Allocations are listed before attachments: ADD records its attachment
+	// before allocating, so every allocation seen here whose ADD is still in
+	// flight already has a record below and is never taken for unowned.
+	networks, err := p.IPAM.Networks(ctx, dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("list-networks: %w", err)
+	}
+	allocations := map[string]map[string]net.IP{}
+	for _, network := range networks {
+		list, err := p.IPAM.List(ctx, dataDir, network)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list-allocations %q: %w", network, err))
+			continue
+		}
+		allocations[network] = list
+	}
+
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("list-attachments: %w", err)
+	}
+	protos := recordedRouteProtos(dataDir, attachments)
+	sockets := recordedCRISockets(dataDir, attachments)
+	live := map[string]bool{}
+	now := time.Now()
+	for _, a := range attachments {
+		expired := failureExpired(a, now)
+		if !expired && p.netnsExists(a.Netns) {
+			live[a.Network+"/"+a.ContainerID] = true
+			live[a.Network+"/"+ipamOwner(a.ContainerID, a.IfName, true)] = true
+			continue
+		}
+		// An ADD that died mid-way may have installed more than its record
+		// lists.
+		if _, err := p.recoverTxn(dataDir, a.Network, a.ContainerID, a.IfName); err != nil {
+			errs = append(errs, err)
+		}
+		// Its host rules go with the record, or the next pod handed the
+		// address would inherit them. A record that fails to tear down is
+		// kept, with its allocation, for the next pass.
+		if err := p.drainAttachment(dataDir, a); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), err))
+			live[a.Network+"/"+a.ContainerID] = true
+			live[a.Network+"/"+ipamOwner(a.ContainerID, a.IfName, true)] = true
+			continue
+		}
+		if err := cache.Delete(dataDir, a.Network, a.ContainerID, a.IfName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		report.Pruned = append(report.Pruned, a.Key())
+	}
+
+	for _, network := range networks {
+		for owner, ip := range allocations[network] {
+			report.Checked++
+			if live[network+"/"+owner] {
+				continue
+			}
+			// Secondary interfaces own their address as "<container>/<ifName>".
+			containerID, ifName, _ := strings.Cut(owner, "/")
+			if runtime := p.runtimeFor(sockets[network]); runtime != nil {
+				exists, err := runtime.SandboxExists(ctx, containerID)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("query-runtime %q: %w", containerID, err))
+				}
+				if exists || err != nil {
+					report.Kept = append(report.Kept, network+"/"+owner)
+					continue
+				}
+			}
+			if err := p.NetOps.DeleteLink(p.hostVethName(containerID, ifName)); err != nil {
+				errs = append(errs, fmt.Errorf("delete-host-veth %q: %w", owner, err))
+			}
+			// GC has no config to tell whether the network keeps a pod set;
+			// removing a member of a missing set is a no-op.
+			if err := p.NetOps.RemovePodSetMember(network, ip); err != nil {
+				errs = append(errs, fmt.Errorf("remove-pod-set-member %q: %w", owner, err))
+			}
+			if err := p.deleteReleasedRoute(ip, protos[network]); err != nil {
+				errs = append(errs, fmt.Errorf("delete-host-route %q: %w", owner, err))
+			}
+			if err := p.IPAM.ForceRelease(ctx, dataDir, network, owner); err != nil {
+				errs = append(errs, fmt.Errorf("release-ip %q: %w", owner, err))
+				continue
+			}
+			report.Released = append(report.Released, network+"/"+owner)
+			if p.Events != nil {
+				_ = p.Events.Emit(events.Event{
+					Type:        events.IPReleased,
+					Network:     network,
+					ContainerID: containerID,
+					IfName:      ifName,
+					IP:          ip.String(),
+					Message:     "reclaimed by gc",
+				})
+			}
+		}
+	}
+
+	orphans, err := p.orphanLinks(dataDir, report.Kept)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, name := range orphans {
+		if err := p.NetOps.DeleteLink(name); err != nil {
+			errs = append(errs, fmt.Errorf("delete-orphan-veth %q: %w", name, err))
+			continue
+		}
+		report.Orphans = append(report.Orphans, name)
+	}
+
+	return report, errors.Join(errs...)
+}
+
+// recordedRouteProtos maps each network to the routeProto its recorded
+// configs carry. GC and drain release addresses without the network config,
+// so this is how they know which /32 routes the released addresses have.
+func recordedRouteProtos(dataDir string, attachments []*cache.Attachment) map[string]int {
+	protos := map[string]int{}
+	for _, a := range attachments {
+		if cfg, err := attachmentConfig(dataDir, a); err == nil && cfg.RouteProto != 0 {
+			protos[a.Network] = cfg.RouteProto
+		}
+	}
+	return protos
+}
+
+// recordedCRISockets maps each network to the criSocket its recorded configs
+// name, the way recordedRouteProtos does for routeProto.
+func recordedCRISockets(dataDir string, attachments []*cache.Attachment) map[string]string {
+	sockets := map[string]string{}
+	for _, a := range attachments {
+		if cfg, err := attachmentConfig(dataDir, a); err == nil && cfg.CRISocket != "" {
+			sockets[a.Network] = cfg.CRISocket
+		}
+	}
+	return sockets
+}
+
+// runtimeFor returns the CRI client GC asks about a network's sandboxes:
+// Runtime when set, which is how atomicnid --cri-socket overrides the config,
+// else a client for the network's recorded criSocket, else nil.
+func (p *Plugin) runtimeFor(socket string) cri.Client {
+	if p.Runtime != nil {
+		return p.Runtime
+	}
+	if socket == "" || !buildinfo.K8s {
+		return nil
+	}
+	return cri.NewClient(socket)
+}
+
+// deleteReleasedRoute removes the routeProto /32 route of a released
+// address; proto 0 means there is none to remove.
+func (p *Plugin) deleteReleasedRoute(ip net.IP, proto int) error {
+	if proto == 0 {
+		return nil
+	}
+	return p.NetOps.DeleteProtoRoute(&net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}, proto)
+}
+
+// orphanLinks returns the host veths tagged for dataDir that no cached
+// attachment or kept sandbox accounts for. Veths of other data dirs are
+// never orphans. Links are
+// listed before attachments: ADD records its attachment before creating the
+// veth, so every in-flight veth seen here already has a record and is never
+// mistaken for an orphan.
+func (p *Plugin) orphanLinks(dataDir string, kept []string) ([]string, error) {
+	links, err := p.dataDirLinks(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		return nil, nil
+	}
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("list-attachments: %w", err)
+	}
+
+	owned := map[string]bool{}
+	for _, a := range attachments {
+		owned[a.HostVeth] = true
+	}
+	// A kept sandbox has no attachment to name its extra interfaces, so its
+	// veths are matched by the container ID their alias ends with.
+	keptIDs := map[string]bool{}
+	for _, k := range kept {
+		_, owner, _ := strings.Cut(k, "/")
+		containerID, ifName, _ := strings.Cut(owner, "/")
+		owned[p.hostVethName(containerID, ifName)] = true
+		keptIDs[VethAlias(nil, containerID)] = true
+	}
+	var orphans []string
+	for _, l := range links {
+		_, pod := splitVethAlias(l.Alias)
+		id := pod[strings.LastIndex(pod, "/")+1:]
+		if !owned[l.Name] && !keptIDs[id] {
+			orphans = append(orphans, l.Name)
+		}
+	}
+	return orphans, nil
+}
+
+// netnsExists reports whether a netns path still refers to a namespace. Errors
+// other than "gone" count as existing so GC never reclaims on uncertainty.
+func (p *Plugin) netnsExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	err := netnsutil.Or(p.NetNS).IsNS(path)
+	return err == nil || !isNetnsGone(err)
+}
@@ -0,0 +1,645 @@
+package atomicni
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/netnsutil"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// attachmentForTest is the record ADD writes for containerID's eth0 on
+// atomic-net under dataDir.
+func attachmentForTest(dataDir, containerID, netnsPath string) *cache.Attachment {
+	return &cache.Attachment{
+		Network:     "atomic-net",
+		ContainerID: containerID,
+		IfName:      "eth0",
+		Netns:       netnsPath,
+		HostVeth:    HostVethName(containerID),
+		Config:      netConfForTest(dataDir, ""),
+	}
+}
+
+// netConfForTest is an atomic-net config under dataDir with the extra
+// top-level fields, each followed by a comma.
+func netConfForTest(dataDir, extra string) []byte {
+	return []byte(fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1",%s"ipam":{"dataDir":%q}}`, extra, dataDir))
+}
+
+func TestGCReleasesStaleAllocations(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	for _, id := range []string{"live", "gone", "orphan"} {
+		allocateForTest(t, alloc, dataDir, id)
+	}
+	if err := cache.Save(dataDir, attachmentForTest(dataDir, "live", podNS.Path())); err != nil {
+		t.Fatalf("Save(live): %v", err)
+	}
+	if err := cache.Save(dataDir, attachmentForTest(dataDir, "gone", "/var/run/netns/atomicni-does-not-exist")); err != nil {
+		t.Fatalf("Save(gone): %v", err)
+	}
+
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc, NetNS: netns}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if report.Checked != 3 || len(report.Released) != 2 || len(report.Pruned) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	allocations, err := alloc.List(context.Background(), dataDir, "atomic-net")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(allocations) != 1 || allocations["live"] == nil {
+		t.Fatalf("expected only live allocation to remain, got %v", allocations)
+	}
+}
+
+func TestGCReclaimsExpiredFailedAdds(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "retrying")
+	allocateForTest(t, alloc, dataDir, "expired")
+	now := time.Now().UTC()
+	for id, until := range map[string]time.Time{"retrying": now.Add(time.Minute), "expired": now.Add(-time.Minute)} {
+		a := attachmentForTest(dataDir, id, podNS.Path())
+		a.Config = netConfForTest(dataDir, `"dscp":10,`)
+		a.Rules = []cache.Rule{{Kind: netops.RuleDSCP, Key: HostVethNameFor(id, "eth0")}}
+		a.Failure = &cache.Failure{Op: "configure-container-ip", Policy: "keep-links", RetryUntil: until}
+		if err := cache.Save(dataDir, a); err != nil {
+			t.Fatalf("Save(%s): %v", id, err)
+		}
+	}
+
+	netOps := &mockNetOps{dscp: map[string]int{HostVethNameFor("retrying", "eth0"): 10, HostVethNameFor("expired", "eth0"): 10}}
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Pruned) != 1 || report.Pruned[0] != "atomic-net:expired:eth0" || len(report.Released) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if _, ok := netOps.dscp[HostVethNameFor("expired", "eth0")]; ok {
+		t.Fatalf("GC kept the rule of the expired failure")
+	}
+	if _, ok := netOps.dscp[HostVethNameFor("retrying", "eth0")]; !ok {
+		t.Fatalf("GC cleared the rule of a failure still in its retry window")
+	}
+}
+
+func TestGCClearsRulesOfPrunedAttachments(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/web")
+	dataDir := t.TempDir()
+	conf := fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipBatch":true,
+		"ipMasq":true,
+		"runtimeConfig":{"portMappings":[{"hostPort":8080,"containerPort":80}]},
+		"ipam":{"dataDir":%q}
+	}`, dataDir)
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: ipam.NewFileAllocator(), NetNS: netns, Log: io.Discard}
+	if _, err := p.Add(context.Background(), &skel.CmdArgs{ContainerID: "web", Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(conf)}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	key := cache.Key("atomic-net", "web", "eth0")
+	if _, ok := netOps.masq[key]; !ok || len(netOps.portMaps[key]) != 1 {
+		t.Fatalf("ADD rules: masq=%v portmaps=%v", netOps.masq, netOps.portMaps)
+	}
+
+	netns.Remove(podNS.Path())
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Pruned) != 1 || len(report.Released) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if _, ok := netOps.masq[key]; ok {
+		t.Fatalf("GC left the masquerade of the pruned attachment: %v", netOps.masq)
+	}
+	if _, ok := netOps.portMaps[key]; ok {
+		t.Fatalf("GC left the port mappings of the pruned attachment: %v", netOps.portMaps)
+	}
+}
+
+type mockRuntime struct {
+	live map[string]bool
+}
+
+func (m *mockRuntime) SandboxExists(_ context.Context, id string) (bool, error) {
+	return m.live[id], nil
+}
+
+func TestGCAndDrainDeleteRouteProtoRoutesOfReleasedAddresses(t *testing.T) {
+	netns := netnsutil.NewFake()
+	dataDir := t.TempDir()
+	conf := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","rangeStart":"10.22.0.10","rangeEnd":"10.22.0.20","routeProto":201,"ipBatch":true,"ipam":{"dataDir":%q}}`, dataDir)
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: ipam.NewFileAllocator(), NetNS: netns, Log: io.Discard}
+	for _, id := range []string{"gone", "unrecorded", "drained"} {
+		podNS := netns.Add("/var/run/netns/" + id)
+		if _, err := p.Add(context.Background(), &skel.CmdArgs{ContainerID: id, Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(conf)}); err != nil {
+			t.Fatalf("Add(%s) error = %v", id, err)
+		}
+	}
+	if len(netOps.protoRoutes) != 3 {
+		t.Fatalf("ADD routes = %v", netOps.protoRoutes)
+	}
+
+	// GC prunes the record of a pod whose netns is gone and releases its
+	// address without a DEL.
+	netns.Remove("/var/run/netns/gone")
+	// An allocation whose record was lost gets the routeProto of the
+	// network's other records.
+	if err := cache.Delete(dataDir, "atomic-net", "unrecorded", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Released) != 2 || len(netOps.protoRoutes) != 1 {
+		t.Fatalf("GC released %v, left routes %v", report.Released, netOps.protoRoutes)
+	}
+
+	// Drain's allocation pass does the same for an owner with no record.
+	if err := cache.Delete(dataDir, "atomic-net", "drained", "eth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(dataDir, &cache.Attachment{Network: "atomic-net", ContainerID: "other", IfName: "eth0", Config: []byte(conf)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Drain(context.Background(), dataDir); err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if len(netOps.protoRoutes) != 0 {
+		t.Fatalf("drain left routes %v", netOps.protoRoutes)
+	}
+}
+
+func TestGCKeepsSandboxesReportedByRuntime(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "uncached-live")
+	allocateForTest(t, alloc, dataDir, "uncached-dead")
+
+	p := &Plugin{
+		NetOps:  &mockNetOps{},
+		IPAM:    alloc,
+		Runtime: &mockRuntime{live: map[string]bool{"uncached-live": true}},
+	}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Kept) != 1 || len(report.Released) != 1 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	allocations, err := alloc.List(context.Background(), dataDir, "atomic-net")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if _, ok := allocations["uncached-live"]; !ok || len(allocations) != 1 {
+		t.Fatalf("expected runtime-live allocation to remain, got %v", allocations)
+	}
+}
+
+func TestGCAsksTheRecordedCRISocket(t *testing.T) {
+	if !buildinfo.K8s {
+		t.Skip("built with atomicni_nok8s")
+	}
+	bin := t.TempDir()
+	argsLog := filepath.Join(bin, "args")
+	script := "#!/bin/sh\necho \"$@\" >> " + argsLog + "\ncase \"$*\" in *uncached-live*) echo '{}' ;; *) echo 'sandbox not found' >&2; exit 1 ;; esac\n"
+	if err := os.WriteFile(filepath.Join(bin, "crictl"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "uncached-live")
+	allocateForTest(t, alloc, dataDir, "uncached-dead")
+	conf := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","criSocket":"/run/containerd/containerd.sock","ipam":{"dataDir":%q}}`, dataDir)
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/recorded")
+	if err := cache.Save(dataDir, &cache.Attachment{Network: "atomic-net", ContainerID: "recorded", IfName: "eth0", Netns: podNS.Path(), Config: []byte(conf)}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc, NetNS: netns}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if !slices.Equal(report.Kept, []string{"atomic-net/uncached-live"}) || !slices.Equal(report.Released, []string{"atomic-net/uncached-dead"}) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	logged, err := os.ReadFile(argsLog)
+	if err != nil || !strings.Contains(string(logged), "--runtime-endpoint unix:///run/containerd/containerd.sock") {
+		t.Fatalf("crictl was not pointed at the recorded socket: %q %v", logged, err)
+	}
+
+	// A Runtime set by atomicnid --cri-socket overrides the config.
+	allocateForTest(t, alloc, dataDir, "uncached-dead")
+	p.Runtime = &mockRuntime{live: map[string]bool{"uncached-live": true, "uncached-dead": true}}
+	if report, err = p.GC(context.Background(), dataDir); err != nil || len(report.Released) != 0 {
+		t.Fatalf("GC() with Runtime = %+v, %v", report, err)
+	}
+}
+
+// racingAllocator runs an ADD's record and allocation the first time GC
+// lists networks, as a pod coming up while GC runs would.
+type racingAllocator struct {
+	ipam.Allocator
+	add func()
+}
+
+func (r *racingAllocator) Networks(ctx context.Context, dataDir string) ([]string, error) {
+	if r.add != nil {
+		r.add()
+		r.add = nil
+	}
+	return r.Allocator.Networks(ctx, dataDir)
+}
+
+func TestGCKeepsAllocationOfInFlightAdd(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	racing := &racingAllocator{Allocator: alloc, add: func() {
+		if err := cache.Save(dataDir, &cache.Attachment{Network: "atomic-net", ContainerID: "coming-up", IfName: "eth0", Netns: podNS.Path()}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		allocateForTest(t, alloc, dataDir, "coming-up")
+	}}
+
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: racing, NetNS: netns}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Released) != 0 {
+		t.Fatalf("GC reclaimed an in-flight ADD: %+v", report)
+	}
+	if _, ok, _ := alloc.GetByContainer(context.Background(), dataDir, "atomic-net", "coming-up"); !ok {
+		t.Fatal("allocation of the in-flight ADD was released")
+	}
+}
+
+func TestGCDeletesOrphanVeths(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	if err := cache.Save(dataDir, attachmentForTest(dataDir, "live", podNS.Path())); err != nil {
+		t.Fatalf("Save(live): %v", err)
+	}
+	otherDir := t.TempDir()
+	netOps := &mockNetOps{links: []netops.OwnedLink{
+		ownedLinkForTest(dataDir, "live"),
+		ownedLinkForTest(dataDir, "crashed-add"),
+		// Pods of another data dir are not this GC's to judge.
+		ownedLinkForTest(otherDir, "other-dir"),
+	}}
+
+	p := &Plugin{NetOps: netOps, IPAM: ipam.NewFileAllocator(), NetNS: netns}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != HostVethName("crashed-add") {
+		t.Fatalf("unexpected orphans: %v", report.Orphans)
+	}
+	var remaining []string
+	for _, l := range netOps.links {
+		remaining = append(remaining, l.Name)
+	}
+	if want := []string{HostVethName("live"), HostVethName("other-dir")}; !slices.Equal(remaining, want) {
+		t.Fatalf("remaining links = %v, want %v", remaining, want)
+	}
+}
+
+// ownedLinkForTest is the host veth ADD creates for containerID under dataDir.
+func ownedLinkForTest(dataDir, containerID string) netops.OwnedLink {
+	return netops.OwnedLink{Name: HostVethName(containerID), Master: "atomic0", Alias: taggedVethAlias(dataDir, VethAlias(nil, containerID))}
+}
+
+func TestDrainReleasesEverythingAndReportsLeaks(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	for _, id := range []string{"live", "gone", "uncached"} {
+		allocateForTest(t, alloc, dataDir, id)
+	}
+	dscp, err := newRule(netops.RuleDSCP, HostVethName("live"), dscpSpec{DSCP: 46})
+	if err != nil {
+		t.Fatalf("newRule: %v", err)
+	}
+	live := attachmentForTest(dataDir, "live", podNS.Path())
+	live.Config = netConfForTest(dataDir, `"dscp":46,`)
+	live.Rules = []cache.Rule{dscp}
+	if err := cache.Save(dataDir, live); err != nil {
+		t.Fatalf("Save(live): %v", err)
+	}
+	if err := cache.Save(dataDir, attachmentForTest(dataDir, "gone", "/var/run/netns/atomicni-does-not-exist")); err != nil {
+		t.Fatalf("Save(gone): %v", err)
+	}
+	otherDir := t.TempDir()
+	netOps := &mockNetOps{
+		dscp: map[string]int{HostVethName("live"): 46},
+		links: []netops.OwnedLink{
+			ownedLinkForTest(dataDir, "live"),
+			ownedLinkForTest(dataDir, "crashed-add"),
+			ownedLinkForTest(otherDir, "other-network"),
+		},
+	}
+
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns}
+	report, err := p.Drain(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if len(report.Drained) != 2 || len(report.Released) != 3 || len(report.Leaks) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0] != HostVethName("crashed-add") {
+		t.Fatalf("unexpected orphans: %v", report.Orphans)
+	}
+	if len(netOps.dscp) != 0 || len(netOps.links) != 1 || netOps.links[0].Name != HostVethName("other-network") {
+		t.Fatalf("left dscp=%v links=%v, want only the other data dir's veth", netOps.dscp, netOps.links)
+	}
+
+	// An attachment whose veth cannot be deleted keeps its record and address
+	// for the next drain, and both show up as leaks.
+	allocateForTest(t, alloc, dataDir, "busy")
+	if err := cache.Save(dataDir, attachmentForTest(dataDir, "busy", "")); err != nil {
+		t.Fatalf("Save(busy): %v", err)
+	}
+	netOps.links = []netops.OwnedLink{ownedLinkForTest(dataDir, "busy"), ownedLinkForTest(otherDir, "other-network")}
+	netOps.failDeleteLinks = 1
+	report, err = p.Drain(context.Background(), dataDir)
+	if err == nil {
+		t.Fatal("Drain() with a busy veth succeeded")
+	}
+	ip, _, _ := alloc.GetByContainer(context.Background(), dataDir, "atomic-net", "busy")
+	want := []string{"allocation atomic-net/busy " + ip.String(), "attachment atomic-net:busy:eth0", "veth " + HostVethName("busy")}
+	if !slices.Equal(report.Leaks, want) {
+		t.Fatalf("leaks = %v, want %v", report.Leaks, want)
+	}
+}
+
+func TestDrainRunsDelTeardownFromRecordedConfig(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	bridgeConf := fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"bridge-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"routeProto":201,
+		"staticNeighbors":{"pod":true},
+		"conntrackZones":true,
+		"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.40/24"}]}
+	}`, dataDir)
+	ptpConf := fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"ptp-net",
+		"type":"atomicni",
+		"mode":"ptp",
+		"subnet":"10.23.0.0/24",
+		"gateway":"10.23.0.1",
+		"firewalld":{"zone":"pods"},
+		"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.23.0.40/24"}]}
+	}`, dataDir)
+
+	netOps := &mockNetOps{firewalld: map[string]string{}}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	for id, conf := range map[string]string{"bridge-pod": bridgeConf, "ptp-pod": ptpConf} {
+		if _, err := p.Add(context.Background(), &skel.CmdArgs{ContainerID: id, Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(conf)}); err != nil {
+			t.Fatalf("Add(%s) error = %v", id, err)
+		}
+	}
+	zone := ConntrackZone(cache.Key("bridge-net", "bridge-pod", "eth0"))
+	netOps.ctEntries = map[int]int{zone: 3}
+	if len(netOps.neighbors) != 1 || len(netOps.protoRoutes) != 1 || netOps.firewalld[HostVethName("ptp-pod")] != "pods" {
+		t.Fatalf("ADD state: neighbors=%v routes=%v firewalld=%v", netOps.neighbors, netOps.protoRoutes, netOps.firewalld)
+	}
+
+	report, err := p.Drain(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("Drain() error = %v", err)
+	}
+	if len(report.Drained) != 2 || len(report.Leaks) != 0 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if len(netOps.neighbors) != 0 || len(netOps.protoRoutes) != 0 || !slices.Contains(netOps.flushedZones, zone) {
+		t.Fatalf("drain left neighbors=%v routes=%v, flushed zones %v", netOps.neighbors, netOps.protoRoutes, netOps.flushedZones)
+	}
+	if _, ok := netOps.firewalld[HostVethName("ptp-pod")]; ok {
+		t.Fatalf("drain left the ptp veth in the zone: %v", netOps.firewalld)
+	}
+}
+
+func TestDrainLeaksCoverDelOnlyState(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	dataDir := t.TempDir()
+	conf := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"bridge-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","routeProto":201,"staticNeighbors":{"pod":true},"conntrackZones":true,"ipam":{"dataDir":%q}}`, dataDir)
+	res, err := current.NewResultFromResult(&current.Result{CNIVersion: "1.1.0", IPs: []*current.IPConfig{{Address: net.IPNet{IP: net.ParseIP("10.22.0.40").To4(), Mask: net.CIDRMask(24, 32)}}}})
+	if err != nil {
+		t.Fatalf("NewResultFromResult: %v", err)
+	}
+	a := &cache.Attachment{Network: "bridge-net", ContainerID: "stuck", IfName: "eth0", Result: res, Config: []byte(conf)}
+	zone := ConntrackZone(a.Key())
+	dst := &net.IPNet{IP: net.ParseIP("10.22.0.40").To4(), Mask: net.CIDRMask(32, 32)}
+	netOps := &mockNetOps{
+		protoRoutes: map[string]int{dst.String(): 201},
+		neighbors:   map[string]string{"atomic0 10.22.0.40": "02:00:00:00:00:01"},
+		ctEntries:   map[int]int{zone: 2},
+	}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}}
+
+	leaks, err := p.attachmentLeaks(dataDir, a)
+	if err != nil {
+		t.Fatalf("attachmentLeaks() error = %v", err)
+	}
+	want := []string{"route 10.22.0.40/32 proto 201", "neighbor atomic0 10.22.0.40", fmt.Sprintf("conntrack zone %d (2 entries)", zone)}
+	if !slices.Equal(leaks, want) {
+		t.Fatalf("leaks = %q, want %q", leaks, want)
+	}
+}
+
+func TestGCRollsBackCrashedAddFromTxnLog(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: ipam.NewFileAllocator(), NetNS: netns, Log: io.Discard}
+	crashAdd(t, p, crashArgs(dataDir, podNS.Path()))
+
+	// The record of the crashed ADD lists no rules yet; only the log knows
+	// the pod was masqueraded.
+	a, ok, err := cache.Load(dataDir, "atomic-net", "crashed", "eth0")
+	if err != nil || !ok || len(a.Rules) != 0 {
+		t.Fatalf("record after crash = %+v %v %v", a, ok, err)
+	}
+	netns.Remove(podNS.Path())
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if len(report.Pruned) != 1 || len(netOps.masq) != 0 {
+		t.Fatalf("report = %+v, masquerade = %v", report, netOps.masq)
+	}
+	if _, ok, _ := cache.LoadTxn(dataDir, "atomic-net", "crashed", "eth0"); ok {
+		t.Fatal("transaction log left after GC")
+	}
+	if _, ok, _ := p.IPAM.GetByContainer(context.Background(), dataDir, "atomic-net", "crashed"); ok {
+		t.Fatal("allocation left after GC")
+	}
+}
+
+func TestGCNetworkReclaimsUnlistedAttachments(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	for _, id := range []string{"valid", "stale", "orphan"} {
+		allocateForTest(t, alloc, dataDir, id)
+	}
+	for _, a := range []*cache.Attachment{
+		attachmentForTest(dataDir, "valid", ""),
+		attachmentForTest(dataDir, "stale", ""),
+		{Network: "other-net", ContainerID: "elsewhere", IfName: "eth0"},
+	} {
+		if err := cache.Save(dataDir, a); err != nil {
+			t.Fatalf("Save(%s): %v", a.ContainerID, err)
+		}
+	}
+
+	netOps := &mockNetOps{}
+	for _, id := range []string{"valid", "stale", "orphan"} {
+		netOps.links = append(netOps.links, netops.OwnedLink{Name: HostVethNameFor(id, "eth0")})
+	}
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netnsutil.NewFake(), Log: io.Discard}
+	args := &skel.CmdArgs{StdinData: []byte(fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipam":{"dataDir":%q},
+		"cni.dev/valid-attachments":[{"containerID":"valid","ifname":"eth0"}]
+	}`, dataDir))}
+	if err := p.GCNetwork(context.Background(), args); err != nil {
+		t.Fatalf("GCNetwork() error = %v", err)
+	}
+
+	allocations, err := alloc.List(context.Background(), dataDir, "atomic-net")
+	if err != nil || len(allocations) != 1 || allocations["valid"] == nil {
+		t.Fatalf("allocations = %v (%v), want only valid", allocations, err)
+	}
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var keys []string
+	for _, a := range attachments {
+		keys = append(keys, a.Key())
+	}
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"atomic-net:valid:eth0", "other-net:elsewhere:eth0"}) {
+		t.Fatalf("attachments = %v", keys)
+	}
+	if len(netOps.links) != 1 || netOps.links[0].Name != HostVethNameFor("valid", "eth0") {
+		t.Fatalf("host veths = %v, want only valid's", netOps.links)
+	}
+}
+
+func TestDisableCheckAndGCSkipTheHandlers(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "stale")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netnsutil.NewFake(), Log: io.Discard}
+	args := &skel.CmdArgs{ContainerID: "never-added", IfName: "eth0", StdinData: []byte(fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"disableCheck":true,
+		"disableGC":true,
+		"ipam":{"dataDir":%q},
+		"cni.dev/valid-attachments":[]
+	}`, dataDir))}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v, want success with disableCheck", err)
+	}
+	if err := p.GCNetwork(context.Background(), args); err != nil {
+		t.Fatalf("GCNetwork() error = %v", err)
+	}
+	if allocations, _ := alloc.List(context.Background(), dataDir, "atomic-net"); len(allocations) != 1 {
+		t.Fatalf("disableGC released allocations: %v", allocations)
+	}
+	if len(netOps.calls) != 0 {
+		t.Fatalf("disabled handlers touched the host: %v", netOps.calls)
+	}
+}
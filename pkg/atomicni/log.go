@@ -0,0 +1,74 @@
+package atomicni
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// opLog writes one operation's diagnostics. CNI reserves stdout for results,
+// so lines go to the configured log file or stderr.
+type opLog struct {
+	w     io.Writer
+	close func()
+	level int
+}
+
+// logLevels is ordered by verbosity; a line is written when its index is at
+// most the configured level's.
+var logLevels = []string{config.LogLevelError, config.LogLevelInfo, config.LogLevelDebug}
+
+// logger opens the operation log for a raw stdin config.
+func (p *Plugin) logger(stdin []byte) *opLog {
+	level, file := config.LogSettings(stdin)
+	l := &opLog{w: p.Log, close: func() {}, level: slices.Index(logLevels, level)}
+	if l.w != nil {
+		return l
+	}
+	l.w = os.Stderr
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			l.w, l.close = f, func() { _ = f.Close() }
+		}
+	}
+	return l
+}
+
+func (l *opLog) printf(level int, format string, args ...any) {
+	if level > l.level {
+		return
+	}
+	fmt.Fprintf(l.w, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339Nano), logLevels[level], fmt.Sprintf(format, args...))
+}
+
+// audit writes an audit line regardless of the configured level.
+func (l *opLog) audit(format string, args ...any) {
+	fmt.Fprintf(l.w, "%s audit %s\n", time.Now().UTC().Format(time.RFC3339Nano), fmt.Sprintf(format, args...))
+}
+
+// begin logs the start of op with the plugin build; debug also lists active
+// ATOMICNI_* overrides.
+func (l *opLog) begin(op string, args *skel.CmdArgs) {
+	l.printf(1, "%s container=%s ifname=%s netns=%s build=%q", op, args.ContainerID, args.IfName, args.Netns, buildinfo.Get())
+	if env := config.EnvOverrides(); len(env) > 0 {
+		l.printf(2, "%s env overrides: %s", op, strings.Join(env, " "))
+	}
+}
+
+// end logs the outcome of op and releases the log file.
+func (l *opLog) end(op string, start time.Time, err error) {
+	if err != nil {
+		l.printf(0, "%s failed after %s (build %s): %v", op, time.Since(start), buildinfo.Get(), err)
+	} else {
+		l.printf(1, "%s done in %s", op, time.Since(start))
+	}
+	l.close()
+}
@@ -0,0 +1,66 @@
+package atomicni
+
+import (
+	"fmt"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// hostPath lists the shared host links pod traffic crosses in bridge mode,
+// parents first: the QinQ uplink chain, then the bridge.
+func hostPath(cfg *config.NetworkConfig) []string {
+	if cfg.Mode != config.ModeBridge {
+		return nil
+	}
+	var links []string
+	if q := cfg.QinQ; q != nil {
+		links = append(links, q.Master, q.OuterName, q.InnerName)
+	}
+	return append(links, cfg.Bridge)
+}
+
+// ensureJumboPath raises the MTU of every shared host link to a jumbo cfg.MTU.
+// A bridge otherwise follows its smallest port, and a VLAN link cannot exceed
+// its parent, so a default uplink would silently cap the path at 1500.
+func (p *Plugin) ensureJumboPath(cfg *config.NetworkConfig) error {
+	if cfg.MTU <= config.DefaultMTU {
+		return nil
+	}
+	for _, link := range hostPath(cfg) {
+		mtu, maxMTU, err := p.NetOps.LinkMTU(link)
+		if err != nil {
+			return err
+		}
+		if maxMTU > 0 && maxMTU < cfg.MTU {
+			return fmt.Errorf("%q supports at most MTU %d, config wants %d", link, maxMTU, cfg.MTU)
+		}
+		if mtu < cfg.MTU {
+			if err := p.NetOps.SetLinkMTU(link, cfg.MTU); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkMTU verifies the pod's host veth carries cfg.MTU and no shared host
+// link on its path is smaller.
+func (p *Plugin) checkMTU(cfg *config.NetworkConfig, hostVethName string) error {
+	mtu, _, err := p.NetOps.LinkMTU(hostVethName)
+	if err != nil {
+		return err
+	}
+	if mtu != cfg.MTU {
+		return fmt.Errorf("%q has MTU %d, want %d", hostVethName, mtu, cfg.MTU)
+	}
+	for _, link := range hostPath(cfg) {
+		mtu, _, err := p.NetOps.LinkMTU(link)
+		if err != nil {
+			return err
+		}
+		if mtu < cfg.MTU {
+			return fmt.Errorf("%q has MTU %d, smaller than the pod MTU %d", link, mtu, cfg.MTU)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package atomicni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/result"
+	"github.com/containernetworking/cni/pkg/skel"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// memberArgs returns the CNI args ADD/DEL/CHECK receive for member i.
+func memberArgs(args *skel.CmdArgs, cfg *config.NetworkConfig, i int) *skel.CmdArgs {
+	sub := *args
+	sub.StdinData = cfg.Members[i].Stdin
+	sub.IfName = MemberIfName(args.IfName, i)
+	return &sub
+}
+
+// addMembers attaches the container to every member network and returns the
+// combined result. A failure removes the members already attached.
+func (p *Plugin) addMembers(ctx context.Context, req *Request, plan *AddPlan, steps stepTimes) (*current.Result, error) {
+	var results []*current.Result
+	undo := func(n int) {
+		for i := n - 1; i >= 0; i-- {
+			_ = p.del(context.Background(), req.Members[i].Args)
+		}
+	}
+	for i, m := range req.Members {
+		res, err := p.executeAdd(ctx, m, plan.Members[i], steps)
+		if err != nil {
+			undo(i)
+			return nil, fmt.Errorf("network %s: %w", m.Config.Name, err)
+		}
+		results = append(results, res)
+	}
+	res := result.Merge(req.Config.CNIVersion, results...)
+	if err := result.Validate(res); err != nil {
+		undo(len(req.Members))
+		return nil, opError("validate-result", err)
+	}
+	return res, nil
+}
+
+// delMembers detaches the container from every member network, last first.
+func (p *Plugin) delMembers(ctx context.Context, args *skel.CmdArgs, cfg *config.NetworkConfig) error {
+	var errs []error
+	for i := len(cfg.Members) - 1; i >= 0; i-- {
+		if err := p.del(ctx, memberArgs(args, cfg, i)); err != nil {
+			errs = append(errs, fmt.Errorf("network %s: %w", cfg.Members[i].Config.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkMembers runs CHECK against every member network.
+func (p *Plugin) checkMembers(ctx context.Context, args *skel.CmdArgs, cfg *config.NetworkConfig, log *opLog) error {
+	for i, m := range cfg.Members {
+		if err := p.check(ctx, memberArgs(args, cfg, i), log); err != nil {
+			return fmt.Errorf("network %s: %w", m.Config.Name, err)
+		}
+	}
+	return nil
+}
@@ -2,27 +2,315 @@ package atomicni
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"hash"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
 )
 
-const linuxIfNameMaxLen = 15
+// HostVethPrefix starts the name of every host veth AtomicNI creates.
+const HostVethPrefix = "av"
+
+const (
+	linuxIfNameMaxLen  = config.MaxLinkName
+	linuxAltNameMaxLen = 127
+	aliasIDLen         = 12
+	podVethHashLen     = 5
+	// minVethSuffixLen is what a host prefix must leave of the name for
+	// telling pods apart.
+	minVethSuffixLen = 6
+)
+
+// DefaultIfName is the pod interface name runtimes pass by default.
+const DefaultIfName = "eth0"
+
+// NameGenerator names the veth pair of a pod interface. key is the container
+// ID, or "<container>/<ifName>" for interfaces other than DefaultIfName. Names
+// must be deterministic, since DEL and GC recompute them, and every host veth
+// name must start with HostPrefix so GC can find the links it owns.
+type NameGenerator interface {
+	HostVethName(key string) string
+	PeerVethTempName(key string) string
+	HostPrefix() string
+}
+
+// HashNames names veths as a prefix followed by the hex digest of the key,
+// truncated to the interface name limit.
+type HashNames struct {
+	Host string
+	Peer string
+	// Hash defaults to SHA-1; deployments that may not use it can supply
+	// e.g. sha256.New.
+	Hash func() hash.Hash
+}
+
+// DefaultNames is the NameGenerator used when Plugin.Names is nil.
+var DefaultNames NameGenerator = HashNames{Host: HostVethPrefix, Peer: "cv"}
+
+// HostVethName implements NameGenerator.
+func (h HashNames) HostVethName(key string) string {
+	return h.name(h.Host, key)
+}
+
+// PeerVethTempName implements NameGenerator.
+func (h HashNames) PeerVethTempName(key string) string {
+	return h.name(h.Peer, key)
+}
+
+// HostPrefix implements NameGenerator.
+func (h HashNames) HostPrefix() string {
+	return h.Host
+}
+
+func (h HashNames) name(prefix, key string) string {
+	newHash := h.Hash
+	if newHash == nil {
+		newHash = sha1.New
+	}
+	sum := newHash()
+	sum.Write([]byte(key))
+	return truncatedName(prefix, hex.EncodeToString(sum.Sum(nil)))
+}
 
 // HostVethName returns deterministic host-side veth name for a container ID.
 func HostVethName(containerID string) string {
-	return deterministicName("av", containerID)
+	return DefaultNames.HostVethName(containerID)
 }
 
 // PeerVethTempName returns deterministic temporary peer veth name before netns rename.
 func PeerVethTempName(containerID string) string {
-	return deterministicName("cv", containerID)
+	return DefaultNames.PeerVethTempName(containerID)
+}
+
+// HostVethNameFor names the host veth of one pod interface. The default
+// interface keeps the HostVethName of its container so existing attachments
+// still resolve; any other interface is keyed on the container and ifName.
+func HostVethNameFor(containerID, ifName string) string {
+	return HostVethName(interfaceKey(containerID, ifName))
 }
 
-func deterministicName(prefix, key string) string {
-	hash := sha1.Sum([]byte(key))
-	hexHash := hex.EncodeToString(hash[:])
+// PeerVethTempNameFor is PeerVethTempName for one pod interface.
+func PeerVethTempNameFor(containerID, ifName string) string {
+	return PeerVethTempName(interfaceKey(containerID, ifName))
+}
+
+// ipamOwner is the IPAM owner of a pod interface: the container ID, or
+// "<container>/<ifName>" for secondary interfaces so several interfaces of
+// one pod on the same network get their own addresses.
+func ipamOwner(containerID, ifName string, secondary bool) string {
+	if !secondary {
+		return containerID
+	}
+	return containerID + "/" + ifName
+}
+
+func interfaceKey(containerID, ifName string) string {
+	if ifName == "" || ifName == DefaultIfName {
+		return containerID
+	}
+	return containerID + "/" + ifName
+}
+
+func truncatedName(prefix, hexHash string) string {
 	maxHashLen := linuxIfNameMaxLen - len(prefix)
 	if maxHashLen < 1 {
 		maxHashLen = 1
 	}
-	return prefix + hexHash[:maxHashLen]
+	return prefix + hexHash[:min(maxHashLen, len(hexHash))]
+}
+
+// names returns the plugin's NameGenerator.
+func (p *Plugin) names() NameGenerator {
+	if p.Names != nil {
+		return p.Names
+	}
+	return DefaultNames
+}
+
+// HostPrefix returns the prefix every host veth name starts with under the
+// plugin's NameGenerator.
+func (p *Plugin) HostPrefix() string {
+	return p.names().HostPrefix()
+}
+
+// hostVethName is HostVethNameFor using the plugin's NameGenerator.
+func (p *Plugin) hostVethName(containerID, ifName string) string {
+	return p.names().HostVethName(interfaceKey(containerID, ifName))
+}
+
+// vethNames returns the host and temporary peer names of a pod interface,
+// rejecting names from a NameGenerator the kernel would not accept and host
+// prefixes too long to leave room for a per-pod suffix.
+func (p *Plugin) vethNames(containerID, ifName string) (string, string, error) {
+	if prefix := p.names().HostPrefix(); len(prefix) > linuxIfNameMaxLen-minVethSuffixLen {
+		return "", "", fmt.Errorf("host prefix %q leaves fewer than %d of %d bytes to tell pods apart", prefix, minVethSuffixLen, linuxIfNameMaxLen)
+	}
+	key := interfaceKey(containerID, ifName)
+	host, peer := p.names().HostVethName(key), p.names().PeerVethTempName(key)
+	for _, name := range []string{host, peer} {
+		if err := config.CheckLinkName(name); err != nil {
+			return "", "", fmt.Errorf("name generator: %w", err)
+		}
+	}
+	if host == peer {
+		return "", "", fmt.Errorf("name generator returned %q for both veth ends", host)
+	}
+	if !strings.HasPrefix(host, p.names().HostPrefix()) {
+		return "", "", fmt.Errorf("host veth %q does not start with prefix %q", host, p.names().HostPrefix())
+	}
+	return host, peer, nil
+}
+
+// PodVethName names a host veth after its pod: prefix, the pod name reduced
+// to [a-z0-9-] and cut to fit the interface name limit, a dash, and the first
+// hex digits of the SHA-256 of key, e.g. "avapi-7f9ab". It returns "" when
+// nothing of the pod name fits.
+func PodVethName(prefix, podName, key string) string {
+	room := linuxIfNameMaxLen - len(prefix) - 1 - podVethHashLen
+	var b strings.Builder
+	for _, r := range strings.ToLower(podName) {
+		if b.Len() >= room {
+			break
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	name := strings.TrimRight(b.String(), "-")
+	if name == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return prefix + name + "-" + hex.EncodeToString(sum[:])[:podVethHashLen]
+}
+
+// podVethName returns the host veth name for ADD under `vethNaming: pod`, or
+// fallback when the pod name is unknown or the name is taken by another
+// pod's link. A link of that name left by an earlier ADD of the same
+// container is reused.
+func (p *Plugin) podVethName(cfg *config.NetworkConfig, cniArgs map[string]string, containerID, ifName, fallback string) (string, error) {
+	if cfg.VethNaming != config.VethNamingPod {
+		return fallback, nil
+	}
+	name := PodVethName(p.names().HostPrefix(), cniArgs["K8S_POD_NAME"], interfaceKey(containerID, ifName))
+	if name == "" {
+		return fallback, nil
+	}
+	links, err := p.NetOps.ListOwnedLinks("", name)
+	if err != nil {
+		return "", err
+	}
+	for _, l := range links {
+		if _, pod := splitVethAlias(l.Alias); l.Name == name && !strings.HasSuffix(pod, VethAlias(nil, containerID)) {
+			return fallback, nil
+		}
+	}
+	return name, nil
+}
+
+// cachedHostVeth names the host veth of a pod interface for DEL and CHECK,
+// preferring the name recorded in its attachment.
+func (p *Plugin) cachedHostVeth(dataDir, network, containerID, ifName string) string {
+	if a, ok, _ := cache.Load(dataDir, network, containerID, ifName); ok {
+		return a.HostVeth
+	}
+	return p.hostVethName(containerID, ifName)
+}
+
+// MemberIfName names the pod interface of member i of a multi-network
+// config: the runtime's ifName for the first, then its numeric suffix counted
+// up (eth0, eth1, ...), or an appended index when it has none.
+func MemberIfName(ifName string, i int) string {
+	if i == 0 {
+		return ifName
+	}
+	base := strings.TrimRight(ifName, "0123456789")
+	n, err := strconv.Atoi(ifName[len(base):])
+	if err != nil {
+		return ifName + strconv.Itoa(i)
+	}
+	return base + strconv.Itoa(n+i)
+}
+
+// HostVethMAC is the MAC a pinned host veth gets for key, the container ID
+// or "<container>/<ifName>" as for NameGenerator. Like the kernel's random
+// MACs it is unicast and locally administered.
+func HostVethMAC(key string) net.HardwareAddr {
+	sum := sha256.Sum256([]byte(key))
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = mac[0]&0xfe | 0x02
+	return mac
+}
+
+// VethAlias describes the pod owning a host veth as "namespace/pod/<id>" from
+// the Kubernetes CNI args, or just the container ID prefix without them.
+func VethAlias(cniArgs map[string]string, containerID string) string {
+	id := containerID
+	if len(id) > aliasIDLen {
+		id = id[:aliasIDLen]
+	}
+	namespace, pod := cniArgs["K8S_POD_NAMESPACE"], cniArgs["K8S_POD_NAME"]
+	if namespace == "" || pod == "" {
+		return id
+	}
+	return namespace + "/" + pod + "/" + id
+}
+
+// vethOwnerTagPrefix starts the tag ADD puts in front of a host veth alias.
+const vethOwnerTagPrefix = "atomicni:"
+
+// vethOwnerTag names the data dir whose attachments own a host veth, so GC
+// and drain of one data dir never take the veths of another for orphans.
+func vethOwnerTag(dataDir string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(dataDir)))
+	return vethOwnerTagPrefix + hex.EncodeToString(sum[:4])
+}
+
+// taggedVethAlias is the alias ADD gives a host veth: the owner tag of
+// dataDir, then the pod from VethAlias. The tag goes first so the kernel's
+// alias length limit never cuts it off.
+func taggedVethAlias(dataDir, alias string) string {
+	return vethOwnerTag(dataDir) + " " + alias
+}
+
+// splitVethAlias splits a host veth alias into its owner tag and the pod
+// part.
+func splitVethAlias(alias string) (tag, pod string) {
+	tag, pod, _ = strings.Cut(alias, " ")
+	return tag, pod
+}
+
+// podName returns "namespace/pod" from the Kubernetes CNI args, or "" without
+// them.
+func podName(cniArgs map[string]string) string {
+	namespace, pod := cniArgs["K8S_POD_NAMESPACE"], cniArgs["K8S_POD_NAME"]
+	if namespace == "" || pod == "" {
+		return ""
+	}
+	return namespace + "/" + pod
+}
+
+// VethAltName turns an alias into a valid alternative interface name, which
+// may not contain '/' or whitespace.
+func VethAltName(alias string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == ':' || r == ' ' || r == '\t' || r == '\n' {
+			return '.'
+		}
+		return r
+	}, alias)
+	if len(name) > linuxAltNameMaxLen {
+		name = name[:linuxAltNameMaxLen]
+	}
+	return name
 }
@@ -1,6 +1,18 @@
 package atomicni
 
-import "testing"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/netnsutil"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/skel"
+)
 
 func TestDeterministicNames(t *testing.T) {
 	containerID := "1234567890abcdef1234567890abcdef"
@@ -21,3 +33,196 @@ func TestDeterministicNames(t *testing.T) {
 		t.Fatalf("host and peer names should use different prefixes")
 	}
 }
+
+func TestVethAlias(t *testing.T) {
+	containerID := "1234567890abcdef1234567890abcdef"
+	args := map[string]string{"K8S_POD_NAMESPACE": "kube-system", "K8S_POD_NAME": "coredns-5d78c9869d-abcde"}
+
+	alias := VethAlias(args, containerID)
+	if alias != "kube-system/coredns-5d78c9869d-abcde/1234567890ab" {
+		t.Fatalf("VethAlias() = %q", alias)
+	}
+	if alt := VethAltName(alias); alt != "kube-system.coredns-5d78c9869d-abcde.1234567890ab" {
+		t.Fatalf("VethAltName() = %q", alt)
+	}
+	if alias := VethAlias(map[string]string{"K8S_POD_NAME": "lonely"}, containerID); alias != "1234567890ab" {
+		t.Fatalf("VethAlias() without namespace = %q", alias)
+	}
+	if alt := VethAltName(strings.Repeat("a", 200)); len(alt) != linuxAltNameMaxLen {
+		t.Fatalf("VethAltName() length = %d", len(alt))
+	}
+}
+
+func TestTaggedVethAlias(t *testing.T) {
+	alias := taggedVethAlias("/var/lib/atomicni/", "kube-system/coredns/1234567890ab")
+	tag, pod := splitVethAlias(alias)
+	if tag != vethOwnerTag("/var/lib/atomicni") || pod != "kube-system/coredns/1234567890ab" {
+		t.Fatalf("splitVethAlias(%q) = %q, %q", alias, tag, pod)
+	}
+	if tag == vethOwnerTag("/var/lib/other") {
+		t.Fatal("data dirs share an owner tag")
+	}
+}
+
+func TestMemberIfName(t *testing.T) {
+	for _, tc := range []struct {
+		ifName string
+		i      int
+		want   string
+	}{
+		{"eth0", 0, "eth0"},
+		{"eth0", 2, "eth2"},
+		{"net1", 1, "net2"},
+		{"pod", 1, "pod1"},
+	} {
+		if got := MemberIfName(tc.ifName, tc.i); got != tc.want {
+			t.Fatalf("MemberIfName(%q, %d) = %q, want %q", tc.ifName, tc.i, got, tc.want)
+		}
+	}
+}
+
+func TestHashNamesCustomHash(t *testing.T) {
+	names := HashNames{Host: "k1", Peer: "k1p", Hash: sha256.New}
+	sum := sha256.Sum256([]byte("pod"))
+	if got, want := names.HostVethName("pod"), "k1"+hex.EncodeToString(sum[:])[:13]; got != want {
+		t.Fatalf("HostVethName() = %q, want %q", got, want)
+	}
+	if got := names.PeerVethTempName("pod"); len(got) != linuxIfNameMaxLen || !strings.HasPrefix(got, "k1p") {
+		t.Fatalf("PeerVethTempName() = %q", got)
+	}
+	if HostVethName("pod") != (HashNames{Host: HostVethPrefix}).HostVethName("pod") {
+		t.Fatalf("HostVethName must keep the SHA-1 default")
+	}
+}
+
+type fixedNames struct{ host, peer string }
+
+func (f fixedNames) HostVethName(string) string     { return f.host }
+func (f fixedNames) PeerVethTempName(string) string { return f.peer }
+func (f fixedNames) HostPrefix() string             { return "pod" }
+
+func TestAddUsesNameGenerator(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	add := func(names NameGenerator) (*mockNetOps, error) {
+		netOps := &mockNetOps{}
+		p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, Names: names, NetNS: netns}
+		_, err := p.Add(context.Background(), &skel.CmdArgs{
+			ContainerID: "named",
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			Args:        "IP=10.22.0.250/24",
+			StdinData: []byte(fmt.Sprintf(`{
+				"cniVersion":"1.1.0",
+				"name":"atomic-net",
+				"type":"atomicni",
+				"bridge":"atomic0",
+				"subnet":"10.22.0.0/24",
+				"gateway":"10.22.0.1",
+				"ipam":{"type":"static","dataDir":%q}
+			}`, t.TempDir())),
+		})
+		return netOps, err
+	}
+
+	netOps, err := add(fixedNames{host: "pod-host", peer: "pod-peer"})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if len(netOps.links) != 1 || netOps.links[0].Name != "pod-host" {
+		t.Fatalf("host veth = %+v, want pod-host", netOps.links)
+	}
+
+	for _, names := range []NameGenerator{
+		fixedNames{host: "pod-host-name-too-long", peer: "pod-peer"},
+		fixedNames{host: "pod-same", peer: "pod-same"},
+		fixedNames{host: "other", peer: "pod-peer"},
+	} {
+		netOps, err := add(names)
+		if err == nil || !strings.Contains(err.Error(), "name-veth") {
+			t.Fatalf("Add() with %+v error = %v, want name-veth failure", names, err)
+		}
+		if len(netOps.links) != 0 {
+			t.Fatalf("Add() with %+v created links %+v", names, netOps.links)
+		}
+	}
+}
+
+func TestPodVethName(t *testing.T) {
+	name := PodVethName("av", "api-server-7d9f8", "c1")
+	if len(name) != linuxIfNameMaxLen || !strings.HasPrefix(name, "avapi-ser-") {
+		t.Fatalf("PodVethName() = %q, want av + api-ser + hash", name)
+	}
+	if name != PodVethName("av", "api-server-7d9f8", "c1") || name == PodVethName("av", "api-server-7d9f8", "c2") {
+		t.Fatalf("PodVethName() must be deterministic and keyed on the container")
+	}
+	if got := PodVethName("av", "API.v2", "c1"); !strings.HasPrefix(got, "avapi-v2-") || len(got) != len("avapi-v2-")+podVethHashLen {
+		t.Fatalf("PodVethName() = %q, want sanitized pod name", got)
+	}
+	if got := PodVethName("av", "--", "c1"); got != "" {
+		t.Fatalf("PodVethName() = %q, want empty for an unusable pod name", got)
+	}
+}
+
+func TestAddNamesVethAfterPod(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	podVeth := PodVethName(HostVethPrefix, "api", "named")
+	add := func(netOps *mockNetOps) (*Plugin, *skel.CmdArgs, *cache.Attachment) {
+		dataDir := t.TempDir()
+		p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+		args := &skel.CmdArgs{
+			ContainerID: "named",
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			Args:        "IP=10.22.0.250/24;K8S_POD_NAMESPACE=default;K8S_POD_NAME=api",
+			StdinData: []byte(fmt.Sprintf(`{
+				"cniVersion":"1.1.0",
+				"name":"atomic-net",
+				"type":"atomicni",
+				"bridge":"atomic0",
+				"subnet":"10.22.0.0/24",
+				"gateway":"10.22.0.1",
+				"vethNaming":"pod",
+				"ipam":{"type":"static","dataDir":%q}
+			}`, dataDir)),
+		}
+		if _, err := p.Add(context.Background(), args); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		a, _, err := cache.Load(dataDir, "atomic-net", "named", "eth0")
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		return p, args, a
+	}
+
+	netOps := &mockNetOps{}
+	p, args, a := add(netOps)
+	if a.HostVeth != podVeth || len(netOps.links) != 1 || netOps.links[0].Name != podVeth {
+		t.Fatalf("host veth = %+v, cached %q; want %q", netOps.links, a.HostVeth, podVeth)
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if len(netOps.links) != 0 {
+		t.Fatalf("Del() left links %+v", netOps.links)
+	}
+
+	// Another pod already holds the name, so the hash name is used.
+	netOps = &mockNetOps{links: []netops.OwnedLink{{Name: podVeth, Alias: "default/api/other"}}}
+	if _, _, a := add(netOps); a.HostVeth != HostVethName("named") {
+		t.Fatalf("cached host veth = %q, want fallback %q", a.HostVeth, HostVethName("named"))
+	}
+}
+
+func TestHostPrefixFollowsNameGenerator(t *testing.T) {
+	if got := (&Plugin{}).HostPrefix(); got != HostVethPrefix {
+		t.Fatalf("default HostPrefix() = %q, want %q", got, HostVethPrefix)
+	}
+	if got := (&Plugin{Names: fixedNames{}}).HostPrefix(); got != "pod" {
+		t.Fatalf("HostPrefix() = %q, want the generator prefix", got)
+	}
+}
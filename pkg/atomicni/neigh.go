@@ -0,0 +1,39 @@
+package atomicni
+
+import (
+	"net"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// neighborLink returns the host link the pod's neighbors live on: the bridge,
+// or the pod's own host veth in ptp mode.
+func neighborLink(cfg *config.NetworkConfig, hostVethName string) string {
+	if cfg.Mode == config.ModeBridge {
+		return cfg.Bridge
+	}
+	return hostVethName
+}
+
+// setStaticNeighbors programs the permanent neighbor entries requested by
+// `staticNeighbors`, registering host-side removal on rollback.
+func (p *Plugin) setStaticNeighbors(cfg *config.NetworkConfig, targetNS ns.NetNS, ifName, hostVethName string, podIP, gateway net.IP, podMAC string, rollback *rollbackStack) error {
+	link := neighborLink(cfg, hostVethName)
+	if cfg.StaticNeighbors.Gateway && gateway != nil {
+		gatewayMAC, err := p.NetOps.GetLinkMAC(link)
+		if err != nil {
+			return err
+		}
+		if err := p.NetOps.AddStaticNeighbor(targetNS, ifName, gateway, gatewayMAC); err != nil {
+			return err
+		}
+	}
+	if cfg.StaticNeighbors.Pod {
+		if err := p.NetOps.AddHostStaticNeighbor(link, podIP, podMAC); err != nil {
+			return err
+		}
+		rollback.Push(rollbackStep{Op: "delete-static-neighbor", Link: link, IP: podIP})
+	}
+	return nil
+}
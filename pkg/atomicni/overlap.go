@@ -0,0 +1,48 @@
+package atomicni
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+)
+
+// checkOverlap refuses a subnet that overlaps another network on the node or
+// a host link's network, which would otherwise break routing in ways that
+// are hard to trace back. It runs when the network's subnet is first seen;
+// `allowOverlap` records the subnet without checking.
+func (p *Plugin) checkOverlap(cfg *config.NetworkConfig) error {
+	return ipam.ClaimSubnet(cfg.IPAM.DataDir, cfg.Name, cfg.SubnetNet, func(others map[string]*net.IPNet) error {
+		if cfg.AllowOverlap {
+			return nil
+		}
+		var conflicts []string
+		for name, other := range others {
+			if ipam.Overlaps(cfg.SubnetNet, other) {
+				conflicts = append(conflicts, fmt.Sprintf("network %q (%s)", name, other))
+			}
+		}
+		addrs, err := p.NetOps.ListHostAddresses()
+		if err != nil {
+			return err
+		}
+		for _, a := range addrs {
+			// The network's own bridge or ptp host ends carry its gateway.
+			if a.Link == cfg.Bridge || a.Addr.IP.Equal(cfg.GatewayIP) {
+				continue
+			}
+			hostNet := &net.IPNet{IP: a.Addr.IP.Mask(a.Addr.Mask), Mask: a.Addr.Mask}
+			if ipam.Overlaps(cfg.SubnetNet, hostNet) {
+				conflicts = append(conflicts, fmt.Sprintf("host link %s (%s)", a.Link, hostNet))
+			}
+		}
+		if len(conflicts) == 0 {
+			return nil
+		}
+		sort.Strings(conflicts)
+		return fmt.Errorf("subnet %s overlaps %s; pick another subnet or set allowOverlap", cfg.SubnetNet, strings.Join(conflicts, ", "))
+	})
+}
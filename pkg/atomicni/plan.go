@@ -0,0 +1,227 @@
+package atomicni
+
+import (
+	"fmt"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// Request is a parsed CNI invocation: the args the runtime passed and the
+// network config resolved from them.
+type Request struct {
+	Args   *skel.CmdArgs
+	Config *config.NetworkConfig
+	// Members are the parsed member networks of a multi-network config.
+	Members []*Request
+}
+
+// AddPlan is the ordered list of steps ADD performs for one attachment. It
+// is plain data: it can be printed, stored as JSON, and handed to
+// ExecuteAdd later. Steps are named like the OpError ops they fail with.
+type AddPlan struct {
+	Network     string `json:"network"`
+	ContainerID string `json:"containerID"`
+	IfName      string `json:"ifName"`
+	HostVeth    string `json:"hostVeth,omitempty"`
+	PeerVeth    string `json:"peerVeth,omitempty"`
+	// FirewalldZone is the zone the firewalld-trust step adds interfaces to.
+	FirewalldZone string     `json:"firewalldZone,omitempty"`
+	Steps         []string   `json:"steps,omitempty"`
+	Members       []*AddPlan `json:"members,omitempty"`
+}
+
+// ParseAdd is ADD's parse phase: it expands template variables, parses the
+// config on stdin, resolves a partitioned subnet, and applies CNI_ARGS.
+// Member networks are parsed the same way. Resolving a partition may record
+// the carved subnet; nothing else is written.
+func ParseAdd(args *skel.CmdArgs) (*Request, error) {
+	args, err := expandArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.CheckLinkName(args.IfName); err != nil {
+		return nil, opError("parse-config", fmt.Errorf("CNI_IFNAME: %w", err))
+	}
+	cfg, err := config.ParseDetailed(args.StdinData)
+	if err != nil {
+		return nil, opError("parse-config", err)
+	}
+	if err := cfg.CheckDeviceID(); err != nil {
+		return nil, opError("parse-config", err)
+	}
+	req := &Request{Args: args, Config: cfg}
+	if len(cfg.Members) > 0 {
+		for i, m := range cfg.Members {
+			member, err := ParseAdd(memberArgs(args, cfg, i))
+			if err != nil {
+				return nil, fmt.Errorf("network %s: %w", m.Config.Name, err)
+			}
+			req.Members = append(req.Members, member)
+		}
+		return req, nil
+	}
+	if cfg.NeedsPartition() {
+		if err := resolvePartition(cfg); err != nil {
+			return nil, opError("partition-subnet", err)
+		}
+	}
+	if err := cfg.ApplyCNIArgs(args.Args); err != nil {
+		return nil, opError("parse-config", err)
+	}
+	return req, nil
+}
+
+// PlanAdd is ADD's plan phase: it names the veths and lists the steps the
+// request's config needs, in the order ExecuteAdd runs them. It reads the
+// host (whether firewalld runs, which links exist) but changes nothing.
+func (p *Plugin) PlanAdd(req *Request) (*AddPlan, error) {
+	if p.NetOps == nil {
+		return nil, fmt.Errorf("plugin has nil NetOps")
+	}
+	args, cfg := req.Args, req.Config
+	plan := &AddPlan{Network: cfg.Name, ContainerID: args.ContainerID, IfName: args.IfName}
+	if len(req.Members) > 0 {
+		for _, m := range req.Members {
+			member, err := p.PlanAdd(m)
+			if err != nil {
+				return nil, fmt.Errorf("network %s: %w", m.Config.Name, err)
+			}
+			plan.Members = append(plan.Members, member)
+		}
+		return plan, nil
+	}
+
+	hostVeth, peer, err := p.vethNames(args.ContainerID, args.IfName)
+	if err != nil {
+		return nil, opError("name-veth", err)
+	}
+	hostVeth, err = p.podVethName(cfg, config.ParseCNIArgs(args.Args), args.ContainerID, args.IfName, hostVeth)
+	if err != nil {
+		return nil, opError("name-veth", err)
+	}
+	plan.HostVeth, plan.PeerVeth = hostVeth, peer
+	plan.FirewalldZone = p.firewalldZone(cfg)
+
+	for _, s := range addSteps {
+		if s.when == nil || s.when(cfg, plan) {
+			plan.Steps = append(plan.Steps, s.op)
+		}
+	}
+	return plan, nil
+}
+
+// check reports why plan cannot run for req, or nil when it can: it must be
+// for the same attachment and list known steps in execution order, including
+// every step that always runs.
+func (plan *AddPlan) check(req *Request) error {
+	args := req.Args
+	if plan.Network != req.Config.Name || plan.ContainerID != args.ContainerID || plan.IfName != args.IfName {
+		return fmt.Errorf("plan is for %s/%s/%s, request for %s/%s/%s", plan.Network, plan.ContainerID, plan.IfName, req.Config.Name, args.ContainerID, args.IfName)
+	}
+	if len(plan.Members) != len(req.Members) {
+		return fmt.Errorf("plan has %d member networks, request %d", len(plan.Members), len(req.Members))
+	}
+	if len(req.Members) > 0 {
+		return nil
+	}
+	next := 0
+	for _, op := range plan.Steps {
+		for next < len(addSteps) && addSteps[next].op != op {
+			if addSteps[next].when == nil {
+				return fmt.Errorf("plan skips step %s", addSteps[next].op)
+			}
+			next++
+		}
+		if next == len(addSteps) {
+			return fmt.Errorf("plan step %s is unknown or out of order", op)
+		}
+		next++
+	}
+	for ; next < len(addSteps); next++ {
+		if addSteps[next].when == nil {
+			return fmt.Errorf("plan skips step %s", addSteps[next].op)
+		}
+	}
+	return nil
+}
+
+// addSteps lists every ADD step in execution order with the configs it
+// applies to; a nil when means always.
+var addSteps = []struct {
+	op   string
+	when func(cfg *config.NetworkConfig, plan *AddPlan) bool
+}{
+	{"check-subnet-overlap", nil},
+	{"open-netns", nil},
+	{"ensure-bridge", bridgeMode},
+	{"set-bridge-options", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.Mode == config.ModeBridge && cfg.BridgeOptions != nil
+	}},
+	{"ensure-qinq-uplink", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.Mode == config.ModeBridge && cfg.QinQ != nil
+	}},
+	{"ensure-mtu", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.Mode == config.ModeBridge && cfg.MTU > config.DefaultMTU
+	}},
+	{"tune-neighbors", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.Neighbor != nil }},
+	// The bridge joins the firewalld zone before any pod uses it; a ptp
+	// host veth once it exists.
+	{"firewalld-trust", func(cfg *config.NetworkConfig, plan *AddPlan) bool {
+		return cfg.Mode == config.ModeBridge && plan.FirewalldZone != ""
+	}},
+	{"enable-state-encryption", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.IPAM.EncryptState }},
+	{"cache-attachment", nil},
+	{"create-veth", nil},
+	{"set-host-mac", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.HostMAC == config.HostMACPinned }},
+	{"set-link-alias", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return !cfg.IPBatch }},
+	{"setup-ptp-host", ptpMode},
+	{"firewalld-trust", func(cfg *config.NetworkConfig, plan *AddPlan) bool {
+		return cfg.Mode == config.ModePTP && plan.FirewalldZone != ""
+	}},
+	{"attach-host-veth", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.Mode == config.ModeBridge && !cfg.IPBatch }},
+	{"set-vlan-trunk", bridgeMode},
+	{"isolate-port", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.Mode == config.ModeBridge && cfg.IsolatePods
+	}},
+	{"set-dscp", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.DSCP != nil }},
+	// With ipBatch the peer is already in the pod netns, and with dynamic
+	// IPAM the container side is one batch after allocation.
+	{"move-peer-to-netns", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return !cfg.IPBatch }},
+	{"prepare-container-link", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return !cfg.IPBatch || staticIPAM(cfg) }},
+	{"alloc-ip", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return !staticIPAM(cfg) }},
+	{"configure-container-ip", nil},
+	{"set-container-mac", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.PodMAC != nil }},
+	{"set-pod-sysctls", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return len(cfg.Sysctls) > 0 }},
+	{"add-pod-routes", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return len(cfg.ExtraRoutes) > 0 }},
+	{"add-service-route", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.ServiceCIDRNet != nil }},
+	{"ensure-service-route", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.ServiceCIDRNet != nil && cfg.ServiceHostRoute != ""
+	}},
+	{"add-dns-route", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.NodeLocalDNSIP != nil }},
+	{"set-dns-redirect", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.NodeLocalDNSIP != nil && cfg.NodeLocalDNSTarget != nil
+	}},
+	{"add-pod-set-member", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.PodSet }},
+	{"set-connlimit", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.ConnLimit != nil }},
+	{"set-ctzone", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.ConntrackZones }},
+	{"set-masquerade", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.IPMasq }},
+	{"set-egress-gateway", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.UseEgressGateway }},
+	{"set-portmap", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return len(cfg.PortRanges) > 0 }},
+	{"add-host-route", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.Mode == config.ModePTP || cfg.RouteProto != 0
+	}},
+	{"set-static-neighbor", func(cfg *config.NetworkConfig, _ *AddPlan) bool {
+		return cfg.StaticNeighbors.Gateway || cfg.StaticNeighbors.Pod
+	}},
+	{"verify-connectivity", func(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.VerifyConnectivity }},
+	{"read-host-mac", nil},
+	{"validate-result", nil},
+	{"cache-result", nil},
+}
+
+func bridgeMode(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.Mode == config.ModeBridge }
+
+func ptpMode(cfg *config.NetworkConfig, _ *AddPlan) bool { return cfg.Mode == config.ModePTP }
+
+func staticIPAM(cfg *config.NetworkConfig) bool { return cfg.IPAM.Type == config.IPAMTypeStatic }
@@ -2,14 +2,27 @@ package atomicni
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/netip"
+	"time"
 
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/cache"
 	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/cri"
+	"github.com/annis-souames/atomicni/pkg/events"
 	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/metrics"
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+	"github.com/annis-souames/atomicni/pkg/netnsutil"
 	"github.com/annis-souames/atomicni/pkg/netops"
 	"github.com/annis-souames/atomicni/pkg/result"
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 	"github.com/containernetworking/plugins/pkg/ns"
 )
@@ -18,112 +31,797 @@ import (
 type Plugin struct {
 	NetOps netops.NetOps
 	IPAM   ipam.Allocator
+	// Runtime is optional; when set, GC confirms with the container runtime
+	// that a sandbox is gone before reclaiming its address.
+	Runtime cri.Client
+	// Metrics is optional; when set, every ADD/DEL is recorded per network.
+	Metrics metrics.Recorder
+	// Events overrides the event sink derived from the network config.
+	Events events.Sink
+	// Log overrides the log destination derived from the network config.
+	Log io.Writer
+	// Names overrides how veths are named; nil means DefaultNames.
+	Names NameGenerator
+	// NetNS opens pod network namespaces; nil means the host's.
+	NetNS netnsutil.Opener
 }
 
-// NewPlugin wires default Linux net operations and file-backed IPAM.
+// NewPlugin wires default Linux net operations and file-backed IPAM. Both
+// operation and allocator metrics persist to the data dir for atomicnid.
 func NewPlugin() *Plugin {
+	alloc := ipam.NewFileAllocator()
+	alloc.Metrics = metrics.IPAMJournal{}
 	return &Plugin{
-		NetOps: netops.NewNetlinkOps(),
-		IPAM:   ipam.NewFileAllocator(),
+		NetOps:  netops.NewNetlinkOps(),
+		IPAM:    alloc,
+		Metrics: metrics.NewFileRecorder(),
 	}
 }
 
 // Add performs CNI ADD for bridge + veth + IPv4 setup and returns CNI result.
 func (p *Plugin) Add(ctx context.Context, args *skel.CmdArgs) (*current.Result, error) {
+	start := time.Now()
+	args, err := expandArgs(args)
+	log := p.logger(args.StdinData)
+	log.begin("ADD", args)
+	steps := stepTimes{}
+	var res *current.Result
+	if err == nil {
+		var release func()
+		if release, err = admit(ctx, args.StdinData, log); err != nil {
+			err = opError("admit", err)
+		} else {
+			restore := applyExecPolicy(args.StdinData, log)
+			res, err = p.add(ctx, args, steps)
+			restore()
+			release()
+		}
+	}
+	log.end("ADD", start, err)
+	p.observe(args.StdinData, "ADD", start, err, steps)
+	return res, err
+}
+
+func (p *Plugin) add(ctx context.Context, args *skel.CmdArgs, steps stepTimes) (*current.Result, error) {
 	if p.NetOps == nil {
 		return nil, fmt.Errorf("plugin has nil NetOps")
 	}
 	if p.IPAM == nil {
 		return nil, fmt.Errorf("plugin has nil IPAM allocator")
 	}
-
-	cfg, err := config.Parse(args.StdinData)
+	req, err := ParseAdd(args)
 	if err != nil {
-		return nil, fmt.Errorf("parse-config: %w", err)
+		return nil, err
 	}
-
-	targetNS, err := ns.GetNS(args.Netns)
+	plan, err := p.PlanAdd(req)
 	if err != nil {
-		return nil, fmt.Errorf("open-netns: %w", err)
+		return nil, err
 	}
-	defer targetNS.Close()
+	return p.executeAdd(ctx, req, plan, steps)
+}
 
-	gatewayCIDR := &net.IPNet{IP: cloneIP(cfg.GatewayIP), Mask: cfg.SubnetNet.Mask}
-	if err := p.NetOps.EnsureBridge(cfg.Bridge, gatewayCIDR); err != nil {
-		return nil, fmt.Errorf("ensure-bridge: %w", err)
+// ExecuteAdd is ADD's execute phase: it runs plan's steps for req in order
+// and, when one fails, rolls back per the config's rollback policy. It skips
+// the admission control, exec policy, logging, and metrics Add adds.
+func (p *Plugin) ExecuteAdd(ctx context.Context, req *Request, plan *AddPlan) (*current.Result, error) {
+	if p.NetOps == nil {
+		return nil, fmt.Errorf("plugin has nil NetOps")
 	}
+	if p.IPAM == nil {
+		return nil, fmt.Errorf("plugin has nil IPAM allocator")
+	}
+	return p.executeAdd(ctx, req, plan, stepTimes{})
+}
 
-	hostVethName := HostVethName(args.ContainerID)
-	peerTempName := PeerVethTempName(args.ContainerID)
+func (p *Plugin) executeAdd(ctx context.Context, req *Request, plan *AddPlan, steps stepTimes) (*current.Result, error) {
+	if err := plan.check(req); err != nil {
+		return nil, opError("plan-add", err)
+	}
+	cfg, args := req.Config, req.Args
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
+	if len(req.Members) > 0 {
+		return p.addMembers(ctx, req, plan, steps)
+	}
 
-	rollback := rollbackStack{}
-	fail := func(op string, opErr error) (*current.Result, error) {
-		rollback.Run()
-		return nil, fmt.Errorf("%s: %w", op, opErr)
+	dataDir := cfg.IPAM.DataDir
+	if step, err := p.recoverTxn(dataDir, cfg.Name, args.ContainerID, args.IfName); err != nil {
+		return nil, opError("recover-txn", err)
+	} else if step != "" {
+		log := p.logger(args.StdinData)
+		log.printf(0, "rolled back an earlier ADD that died in step %s", step)
+		log.close()
 	}
 
-	if err := p.NetOps.CreateVethPair(hostVethName, peerTempName, cfg.MTU); err != nil {
-		return fail("create-veth", err)
+	r := &addRun{req: req, plan: plan, gateway: cfg.GatewayAddr()}
+	defer func() {
+		if r.targetNS != nil {
+			r.targetNS.Close()
+		}
+	}()
+	txn := &addTxn{Netns: args.Netns, StartedAt: time.Now().UTC(), Plan: plan, Done: make([]bool, len(plan.Steps))}
+	if err := txn.save(dataDir); err != nil {
+		return nil, opError("log-txn", err)
+	}
+	fail := func(op string, opErr error) (*current.Result, error) {
+		// Nothing needs undoing before the attachment is recorded.
+		if r.attachment != nil {
+			policy := cfg.RollbackPolicy()
+			target := &undoTarget{dataDir: dataDir, plan: plan, netns: args.Netns, targetNS: r.targetNS}
+			r.rollback.Run(policy, func(s rollbackStep) { p.undo(target, s) })
+			if policy != config.RollbackFull {
+				p.recordFailure(cfg, r.attachment, op, opErr)
+			}
+		}
+		_ = cache.DeleteTxn(dataDir, cfg.Name, args.ContainerID, args.IfName)
+		return nil, opError(op, opErr)
+	}
+	for i, op := range plan.Steps {
+		start := time.Now()
+		if err := p.addStep(ctx, r, op); err != nil {
+			return fail(op, err)
+		}
+		if step := stepTimers[op]; step != "" {
+			steps.record(step, start)
+		}
+		txn.Done[i] = true
+		txn.Rollback = r.rollback
+		if err := txn.save(dataDir); err != nil {
+			return fail("log-txn", err)
+		}
+	}
+	if err := cache.DeleteTxn(dataDir, cfg.Name, args.ContainerID, args.IfName); err != nil {
+		return fail("log-txn", err)
 	}
-	rollback.Push(func() {
-		_ = p.NetOps.DeleteLink(hostVethName)
-	})
 
-	if err := p.NetOps.AttachHostVethToBridge(hostVethName, cfg.Bridge); err != nil {
-		return fail("attach-host-veth", err)
+	if cfg.IPAM.Type != config.IPAMTypeStatic {
+		p.emit(cfg, events.Event{Type: events.IPAllocated, Network: cfg.Name, ContainerID: args.ContainerID, IfName: args.IfName, IP: r.podAddr.Addr().String()})
 	}
+	p.emit(cfg, events.Event{Type: events.AttachmentCreated, Network: cfg.Name, ContainerID: args.ContainerID, IfName: args.IfName, IP: r.podAddr.Addr().String()})
+	return r.res, nil
+}
+
+// stepTimers maps ADD steps to the setup step whose duration they count
+// towards in metrics.
+var stepTimers = map[string]string{
+	"ensure-bridge":          "bridge",
+	"set-bridge-options":     "bridge",
+	"ensure-qinq-uplink":     "bridge",
+	"ensure-mtu":             "bridge",
+	"create-veth":            "veth",
+	"set-link-alias":         "veth",
+	"setup-ptp-host":         "veth",
+	"attach-host-veth":       "veth",
+	"set-vlan-trunk":         "veth",
+	"isolate-port":           "veth",
+	"move-peer-to-netns":     "netns",
+	"prepare-container-link": "netns",
+	"alloc-ip":               "ipam",
+	"configure-container-ip": "address",
+}
 
-	if err := p.NetOps.MoveToNamespace(peerTempName, targetNS); err != nil {
-		return fail("move-peer-to-netns", err)
+// addRun is the state one ADD carries from step to step.
+type addRun struct {
+	req        *Request
+	plan       *AddPlan
+	targetNS   ns.NetNS
+	attachment *cache.Attachment
+	rollback   rollbackStack
+	podAddr    netip.Prefix
+	gateway    netip.Addr
+	// owner is the IPAM owner of a dynamic allocation.
+	owner        string
+	containerMAC string
+	// defaultRoute reports whether the pod took this network's default route.
+	defaultRoute netops.RouteOutcome
+	hostMAC      string
+	res          *current.Result
+}
+
+// podIP and podCIDR are the pod address in the net forms the NetOps and
+// firewall APIs take.
+func (r *addRun) podIP() net.IP { return netaddr.ToIP(r.podAddr.Addr()) }
+
+func (r *addRun) podCIDR() *net.IPNet { return netaddr.ToIPNet(r.podAddr) }
+
+// gatewayIP is the pod's gateway as a net.IP; nil when it has none.
+func (r *addRun) gatewayIP() net.IP { return netaddr.ToIP(r.gateway) }
+
+// addStep runs one step of an ADD plan.
+func (p *Plugin) addStep(ctx context.Context, r *addRun, op string) error {
+	args, cfg := r.req.Args, r.req.Config
+	hostVeth, peer := r.plan.HostVeth, r.plan.PeerVeth
+	switch op {
+	case "check-subnet-overlap":
+		return p.checkOverlap(cfg)
+	case "open-netns":
+		targetNS, err := p.getNS(args.Netns)
+		if err != nil {
+			return err
+		}
+		r.targetNS = targetNS
+		return nil
+	case "ensure-bridge":
+		gatewayCIDR := &net.IPNet{IP: cloneIP(cfg.GatewayIP), Mask: cfg.SubnetNet.Mask}
+		return p.NetOps.EnsureBridge(cfg.Bridge, gatewayCIDR)
+	case "set-bridge-options":
+		o := cfg.BridgeOptions
+		return p.NetOps.SetBridgeOptions(cfg.Bridge, netops.BridgeOptions{AgeingTime: o.AgeingTime, McastSnooping: o.McastSnooping, McastQuerier: o.McastQuerier})
+	case "ensure-qinq-uplink":
+		return p.ensureQinQUplink(cfg)
+	case "ensure-mtu":
+		return p.ensureJumboPath(cfg)
+	case "tune-neighbors":
+		return p.tuneNeighbors(args, cfg)
+	case "firewalld-trust":
+		if cfg.Mode == config.ModeBridge {
+			return p.NetOps.FirewalldTrust(r.plan.FirewalldZone, cfg.Bridge)
+		}
+		if err := p.NetOps.FirewalldTrust(r.plan.FirewalldZone, hostVeth); err != nil {
+			return err
+		}
+		r.rollback.Push(rollbackStep{Op: "firewalld-untrust", Link: hostVeth})
+		return nil
+	case "enable-state-encryption":
+		return statecrypt.Enable(cfg.IPAM.DataDir, cfg.IPAM.StateKey)
+	case "cache-attachment":
+		// The attachment record is written before any allocation so GC never
+		// sees an address without an owner while ADD is still in flight.
+		attachment := &cache.Attachment{
+			Network:     cfg.Name,
+			ContainerID: args.ContainerID,
+			IfName:      args.IfName,
+			Netns:       args.Netns,
+			CreatedAt:   time.Now().UTC(),
+			PluginBuild: buildinfo.Get().String(),
+			HostVeth:    hostVeth,
+			Config:      args.StdinData,
+			CNIArgs:     args.Args,
+		}
+		if err := cache.Save(cfg.IPAM.DataDir, attachment); err != nil {
+			return err
+		}
+		r.attachment = attachment
+		r.rollback.PushRecord(rollbackStep{Op: "delete-cached-attachment"})
+		return nil
+	case "create-veth":
+		alias := VethAlias(config.ParseCNIArgs(args.Args), args.ContainerID)
+		if cfg.IPBatch {
+			return p.batchHostVeth(cfg, hostVeth, peer, alias, r.targetNS, &r.rollback)
+		}
+		if err := p.NetOps.CreateVethPair(hostVeth, peer, cfg.MTU); err != nil {
+			return err
+		}
+		r.rollback.Push(rollbackStep{Op: "delete-host-veth", Link: hostVeth})
+		return nil
+	case "set-host-mac":
+		return p.NetOps.SetLinkMAC(hostVeth, HostVethMAC(interfaceKey(args.ContainerID, args.IfName)).String())
+	case "set-link-alias":
+		alias := VethAlias(config.ParseCNIArgs(args.Args), args.ContainerID)
+		return p.NetOps.SetLinkAlias(hostVeth, taggedVethAlias(cfg.IPAM.DataDir, alias), VethAltName(alias))
+	case "setup-ptp-host":
+		// The batch already addressed the host veth.
+		if cfg.IPBatch {
+			return p.NetOps.SetProxyARP(hostVeth, true)
+		}
+		return p.setupPTPHost(hostVeth, cfg)
+	case "attach-host-veth":
+		return p.NetOps.AttachHostVethToBridge(hostVeth, cfg.Bridge)
+	case "set-vlan-trunk":
+		return p.NetOps.SetBridgePortVLANs(cfg.Bridge, hostVeth, cfg.VLANs)
+	case "isolate-port":
+		return p.NetOps.SetBridgePortIsolated(hostVeth, true)
+	case "set-dscp":
+		return p.installRule(r.attachment, &r.rollback, netops.RuleDSCP, hostVeth, dscpSpec{DSCP: *cfg.DSCP})
+	case "move-peer-to-netns":
+		if err := p.NetOps.MoveToNamespace(peer, r.targetNS); err != nil {
+			return err
+		}
+		r.rollback.Push(rollbackStep{Op: "delete-container-link"})
+		return nil
+	case "prepare-container-link":
+		mac, err := p.NetOps.PrepareContainerLink(r.targetNS, peer, args.IfName)
+		r.containerMAC = mac
+		return err
+	case "alloc-ip":
+		return p.allocate(ctx, r)
+	case "configure-container-ip":
+		return p.configureContainerIP(r)
+	case "set-container-mac":
+		if err := p.NetOps.SetLinkMACInNS(r.targetNS, args.IfName, cfg.PodMAC.String()); err != nil {
+			return err
+		}
+		r.containerMAC = cfg.PodMAC.String()
+		return nil
+	case "set-pod-sysctls":
+		return p.NetOps.SetSysctls(r.targetNS, cfg.Sysctls)
+	case "add-pod-routes":
+		for _, route := range extraRoutes(cfg, r.gatewayIP()) {
+			if err := p.NetOps.AddRoute(r.targetNS, args.IfName, &route.Dst, route.GW); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "add-service-route":
+		return p.NetOps.AddRoute(r.targetNS, args.IfName, cfg.ServiceCIDRNet, r.gatewayIP())
+	case "ensure-service-route":
+		return p.NetOps.EnsureServiceRoute(cfg.ServiceCIDRNet, cfg.ServiceHostVia)
+	case "add-dns-route":
+		dst := &net.IPNet{IP: cfg.NodeLocalDNSIP, Mask: net.CIDRMask(32, 32)}
+		return p.NetOps.AddRoute(r.targetNS, args.IfName, dst, r.gatewayIP())
+	case "set-dns-redirect":
+		redirect := netops.DNSRedirect{PodIP: r.podIP(), Listen: cfg.NodeLocalDNSIP, Target: cfg.NodeLocalDNSTarget}
+		return p.installRule(r.attachment, &r.rollback, netops.RuleDNS, r.attachment.Key(), redirect)
+	case "add-pod-set-member":
+		for _, ip := range podIPs(cfg, r.podCIDR()) {
+			if err := p.NetOps.AddPodSetMember(cfg.Name, ip); err != nil {
+				return err
+			}
+			r.rollback.Push(rollbackStep{Op: "remove-pod-set-member", IP: ip})
+		}
+		return nil
+	case "set-connlimit":
+		spec := connLimitSpec{PodIP: r.podIP(), PerSecond: cfg.ConnLimit.PerSecond, Burst: cfg.ConnLimit.Burst}
+		return p.installRule(r.attachment, &r.rollback, netops.RuleConnLimit, r.attachment.Key(), spec)
+	case "set-ctzone":
+		zone := netops.CTZone{
+			HostLink:      hostVeth,
+			PodLinkPrefix: p.names().HostPrefix(),
+			PodIP:         r.podIP(),
+			Zone:          ConntrackZone(r.attachment.Key()),
+		}
+		return p.installRule(r.attachment, &r.rollback, netops.RuleCTZone, r.attachment.Key(), zone)
+	case "set-masquerade":
+		masq := netops.Masquerade{
+			Source:   r.podIP(),
+			Exclude:  cfg.SubnetNet,
+			EgressIP: cfg.EgressIP,
+			PortMin:  cfg.SNATPortMin,
+			PortMax:  cfg.SNATPortMax,
+		}
+		return p.installRule(r.attachment, &r.rollback, netops.RuleMasquerade, r.attachment.Key(), masq)
+	case "set-egress-gateway":
+		gw := netops.EgressGateway{
+			PodIP:   r.podIP(),
+			Exclude: cfg.SubnetNet,
+			Gateway: cfg.EgressGatewayIP,
+			Table:   cfg.EgressGateway.Table,
+		}
+		if cfg.EgressBackupIP != nil {
+			gw.Backup = cfg.EgressBackupIP
+			gw.Probe = cfg.EgressGateway.Probe
+			gw.ProbeInterval = cfg.EgressGateway.ProbeInterval
+			gw.ProbeFailures = cfg.EgressGateway.ProbeFailures
+		}
+		return p.installRule(r.attachment, &r.rollback, netops.RuleEgress, r.attachment.Key(), gw)
+	case "set-portmap":
+		spec := portMapSpec{PodIP: r.podIP(), Forwards: portForwards(cfg.PortRanges)}
+		return p.installRule(r.attachment, &r.rollback, netops.RulePortMap, r.attachment.Key(), spec)
+	case "add-host-route":
+		link := cfg.Bridge
+		if cfg.Mode == config.ModePTP {
+			link = hostVeth
+		}
+		return p.addHostRoutes(link, r.podCIDR(), cfg, &r.rollback)
+	case "set-static-neighbor":
+		return p.setStaticNeighbors(cfg, r.targetNS, args.IfName, hostVeth, r.podIP(), r.gatewayIP(), r.containerMAC, &r.rollback)
+	case "verify-connectivity":
+		return p.NetOps.VerifyGateway(r.targetNS, args.IfName, r.gatewayIP())
+	case "read-host-mac":
+		mac, err := p.NetOps.GetLinkMAC(hostVeth)
+		r.hostMAC = mac
+		return err
+	case "validate-result":
+		r.res = buildResult(r)
+		return result.Validate(r.res)
+	case "cache-result":
+		r.attachment.Result = r.res
+		r.attachment.HostMAC = r.hostMAC
+		r.attachment.ContainerMAC = r.containerMAC
+		return cache.Save(cfg.IPAM.DataDir, r.attachment)
 	}
-	rollback.Push(func() {
-		_ = p.NetOps.DeleteLinkInNS(targetNS, args.IfName)
-		_ = p.NetOps.DeleteLinkInNS(targetNS, peerTempName)
-	})
+	return fmt.Errorf("unknown step %q", op)
+}
 
-	containerMAC, err := p.NetOps.PrepareContainerLink(targetNS, peerTempName, args.IfName)
-	if err != nil {
-		return fail("prepare-container-link", err)
+// tuneNeighbors applies the configured neighbor table sizing.
+func (p *Plugin) tuneNeighbors(args *skel.CmdArgs, cfg *config.NetworkConfig) error {
+	n := cfg.Neighbor
+	link := ""
+	if cfg.Mode == config.ModeBridge {
+		link = cfg.Bridge
+	}
+	tuning := netops.NeighborTuning{
+		GCThresh1:         n.GCThresh1,
+		GCThresh2:         n.GCThresh2,
+		GCThresh3:         n.GCThresh3,
+		BaseReachableTime: time.Duration(n.BaseReachableTime) * time.Second,
 	}
+	err := p.NetOps.TuneNeighbors(link, tuning)
+	if errors.Is(err, netops.ErrSysctlUnavailable) {
+		// Inside kind or sysbox the thresholds belong to the outer host.
+		log := p.logger(args.StdinData)
+		log.printf(0, "tune-neighbors: %v", err)
+		log.close()
+		return nil
+	}
+	return err
+}
 
+// allocate takes the pod's address from IPAM and registers its release on
+// rollback.
+func (p *Plugin) allocate(ctx context.Context, r *addRun) error {
+	args, cfg := r.req.Args, r.req.Config
+	r.owner = ipamOwner(args.ContainerID, args.IfName, cfg.Secondary)
 	ipReq := ipam.AllocationRequest{
-		DataDir:     cfg.IPAM.DataDir,
-		Network:     cfg.Name,
-		ContainerID: args.ContainerID,
-		Subnet:      cfg.SubnetNet,
-		Gateway:     cfg.GatewayIP,
-		RangeStart:  cfg.RangeStartIP,
-		RangeEnd:    cfg.RangeEndIP,
-	}
-	allocatedIP, err := p.IPAM.Allocate(ctx, ipReq)
+		DataDir:          cfg.IPAM.DataDir,
+		Network:          cfg.Name,
+		ContainerID:      r.owner,
+		Subnet:           cfg.SubnetNet,
+		Gateway:          cfg.GatewayIP,
+		RangeStart:       cfg.RangeStartIP,
+		RangeEnd:         cfg.RangeEndIP,
+		PreferredIP:      cfg.PreferredIP,
+		Pod:              podName(config.ParseCNIArgs(args.Args)),
+		ReservationsFile: cfg.IPAM.ReservationsFile,
+	}
+	if l := cfg.IPAM.DHCPLeases; l != nil {
+		ipReq.DHCPLeases = &ipam.LeaseSource{File: l.File, Format: l.Format, Refresh: time.Duration(l.RefreshInterval) * time.Second}
+	}
+	for _, rg := range cfg.Ranges {
+		ipReq.Ranges = append(ipReq.Ranges, ipam.Range{Start: rg.Start, End: rg.End, Priority: rg.Priority})
+	}
+	ipReq.OnFallback = func(primary, used ipam.Range) {
+		p.emit(cfg, events.Event{
+			Type:        events.IPRangeFallback,
+			Network:     cfg.Name,
+			ContainerID: args.ContainerID,
+			IfName:      args.IfName,
+			Message:     fmt.Sprintf("priority %d ranges exhausted, allocating from priority %d range %s-%s", primary.Priority, used.Priority, used.Start, used.End),
+		})
+	}
+	addr, err := ipam.AllocateAddr(ctx, p.IPAM, ipReq)
 	if err != nil {
-		return fail("alloc-ip", err)
+		return err
 	}
-	rollback.Push(func() {
-		_ = p.IPAM.Release(context.Background(), cfg.IPAM.DataDir, cfg.Name, args.ContainerID)
-	})
+	p.warnUtilization(ctx, cfg, args)
+	r.rollback.PushAllocation(rollbackStep{Op: "release-ip", Owner: r.owner})
+	r.podAddr = netip.PrefixFrom(addr, cfg.SubnetPrefix().Bits())
+	return nil
+}
 
-	podCIDR := &net.IPNet{IP: cloneIP(allocatedIP), Mask: cfg.SubnetNet.Mask}
-	if err := p.NetOps.AddAddressAndRoute(targetNS, args.IfName, podCIDR, cfg.GatewayIP); err != nil {
-		return fail("configure-container-ip", err)
+// configureContainerIP addresses the pod interface: every static address
+// and route, or the allocated address with this network's default route.
+func (p *Plugin) configureContainerIP(r *addRun) error {
+	args, cfg := r.req.Args, r.req.Config
+	if cfg.IPAM.Type == config.IPAMTypeStatic {
+		first := cfg.StaticAddrs[0]
+		r.podAddr, _ = netaddr.FromIPNet(first.Addr)
+		r.gateway, _ = netaddr.FromIP(first.Gateway)
+		return p.configureStatic(r.targetNS, args.IfName, cfg)
 	}
-
-	hostMAC, err := p.NetOps.GetLinkMAC(hostVethName)
-	if err != nil {
-		return fail("read-host-mac", err)
+	var err error
+	route := &netops.DefaultRoute{Gateway: cfg.GatewayIP, Nexthops: cfg.ECMPGatewayIPs, Metric: cfg.DefaultRouteMetric, OnConflict: cfg.DefaultRouteConflict}
+	switch {
+	case cfg.IPBatch:
+		if cfg.Secondary {
+			route = nil
+		}
+		r.containerMAC, r.defaultRoute, err = p.NetOps.SetupContainerLink(r.targetNS, r.plan.PeerVeth, args.IfName, r.podCIDR(), route)
+	case cfg.Secondary:
+		err = netops.AddPrefix(p.NetOps, r.targetNS, args.IfName, r.podAddr)
+	default:
+		r.defaultRoute, err = netops.AddPrefixAndRoute(p.NetOps, r.targetNS, args.IfName, r.podAddr, *route)
 	}
+	return err
+}
 
-	res := result.BuildAddResult(
+// buildResult assembles the CNI result of a finished ADD.
+func buildResult(r *addRun) *current.Result {
+	args, cfg := r.req.Args, r.req.Config
+	res := result.BuildAddResultAddr(
 		cfg.CNIVersion,
-		hostVethName,
-		hostMAC,
+		r.plan.HostVeth,
+		r.hostMAC,
 		args.IfName,
-		containerMAC,
+		r.containerMAC,
 		args.Netns,
-		podCIDR,
-		cfg.GatewayIP,
+		r.podAddr,
+		r.gateway,
 	)
-	return res, nil
+	if cfg.IPAM.Type == config.IPAMTypeStatic {
+		staticResult(res, cfg)
+	} else if cfg.Secondary {
+		result.SetRoutes(res, nil)
+	} else {
+		if len(cfg.ECMPGatewayIPs) > 0 {
+			result.SetECMPDefaultRoute(res, cfg.ECMPGatewayIPs)
+		}
+		result.SetDefaultRoute(res, r.defaultRoute.Installed, r.defaultRoute.Metric)
+	}
+	res.Routes = append(res.Routes, extraRoutes(cfg, r.gatewayIP())...)
+	if cfg.ServiceCIDRNet != nil {
+		result.AppendRoute(res, cfg.ServiceCIDRNet, r.gatewayIP())
+	}
+	if d := cfg.NodeLocalDNS; d != nil {
+		result.SetDNS(res, cfg.NodeLocalDNSIP, d.Domain, d.Search, d.Options)
+	} else if d := cfg.DNS; d != nil {
+		res.DNS = types.DNS{Nameservers: d.Nameservers, Domain: d.Domain, Search: d.Search, Options: d.Options}
+	}
+	return res
+}
+
+// Del performs CNI DEL. It is idempotent: missing links, netns, or allocations
+// are not errors, and every cleanup step is attempted even when an earlier one fails.
+func (p *Plugin) Del(ctx context.Context, args *skel.CmdArgs) error {
+	start := time.Now()
+	args, err := expandArgs(args)
+	log := p.logger(args.StdinData)
+	log.begin("DEL", args)
+	if err == nil {
+		restore := applyExecPolicy(args.StdinData, log)
+		err = p.del(ctx, args)
+		restore()
+	}
+	log.end("DEL", start, err)
+	p.observe(args.StdinData, "DEL", start, err, nil)
+	return err
+}
+
+func (p *Plugin) del(ctx context.Context, args *skel.CmdArgs) error {
+	if p.NetOps == nil {
+		return fmt.Errorf("plugin has nil NetOps")
+	}
+	if p.IPAM == nil {
+		return fmt.Errorf("plugin has nil IPAM allocator")
+	}
+
+	cfg, err := config.ParseDetailed(args.StdinData)
+	if err != nil {
+		return opError("parse-config", err)
+	}
+	return p.executeDel(ctx, args, cfg)
+}
+
+// ExecuteDel runs DEL for a parsed request, such as one from ParseAdd. It
+// skips the exec policy, logging, and metrics Del adds.
+func (p *Plugin) ExecuteDel(ctx context.Context, req *Request) error {
+	if p.NetOps == nil {
+		return fmt.Errorf("plugin has nil NetOps")
+	}
+	if p.IPAM == nil {
+		return fmt.Errorf("plugin has nil IPAM allocator")
+	}
+	return p.executeDel(ctx, req.Args, req.Config)
+}
+
+func (p *Plugin) executeDel(ctx context.Context, args *skel.CmdArgs, cfg *config.NetworkConfig) error {
+	ctx, cancel := withTimeout(ctx, cfg)
+	defer cancel()
+	if len(cfg.Members) > 0 {
+		return p.delMembers(ctx, args, cfg)
+	}
+
+	var errs []error
+	// An ADD that died mid-way may have installed more than its config
+	// tells DEL about.
+	if _, err := p.recoverTxn(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName); err != nil {
+		errs = append(errs, opError("recover-txn", err))
+	}
+	errs = append(errs, p.teardown(args, cfg)...)
+
+	owner := ipamOwner(args.ContainerID, args.IfName, cfg.Secondary)
+	releasedIP, hadIP, _ := p.IPAM.GetByContainer(ctx, cfg.IPAM.DataDir, cfg.Name, owner)
+	if cfg.PodSet {
+		// Leave the set before the address can be handed to another pod.
+		var ips []net.IP
+		if hadIP {
+			ips = append(ips, releasedIP)
+		}
+		if attachment, ok, _ := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName); ok && attachment.Result != nil {
+			for _, ipc := range attachment.Result.IPs {
+				ips = append(ips, ipc.Address.IP)
+			}
+		}
+		for _, ip := range ips {
+			if err := p.NetOps.RemovePodSetMember(cfg.Name, ip); err != nil {
+				errs = append(errs, opError("remove-pod-set-member", err))
+			}
+		}
+	}
+	if err := p.IPAM.Release(ctx, cfg.IPAM.DataDir, cfg.Name, owner); err != nil {
+		errs = append(errs, opError("release-ip", err))
+	} else if hadIP {
+		p.emit(cfg, events.Event{Type: events.IPReleased, Network: cfg.Name, ContainerID: args.ContainerID, IfName: args.IfName, IP: releasedIP.String()})
+	}
+	if hadIP {
+		p.rearmUtilization(ctx, cfg)
+	}
+
+	_, hadAttachment, _ := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName)
+	if err := cache.Delete(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName); err != nil {
+		errs = append(errs, opError("delete-cached-attachment", err))
+	} else if hadAttachment {
+		p.emit(cfg, events.Event{Type: events.AttachmentDeleted, Network: cfg.Name, ContainerID: args.ContainerID, IfName: args.IfName})
+	}
+
+	return errors.Join(errs...)
+}
+
+// teardown undoes what ADD set up on the host and in the pod netns for one
+// attachment: the host veth and its host-side state, the host rules, and the
+// pod interface. DEL and drain share it; the address and cached record are
+// left to the caller.
+func (p *Plugin) teardown(args *skel.CmdArgs, cfg *config.NetworkConfig) []error {
+	var errs []error
+	hostVeth := p.cachedHostVeth(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName)
+	key := cache.Key(cfg.Name, args.ContainerID, args.IfName)
+	if err := p.NetOps.DeleteLink(hostVeth); err != nil {
+		errs = append(errs, opError("delete-host-veth", err))
+	}
+	if cfg.ConnLimit != nil {
+		if err := p.NetOps.ClearConnLimit(key); err != nil {
+			errs = append(errs, opError("clear-connlimit", err))
+		}
+	}
+	if cfg.Mode == config.ModePTP {
+		if zone := p.firewalldZone(cfg); zone != "" {
+			if err := p.NetOps.FirewalldUntrust(zone, hostVeth); err != nil {
+				errs = append(errs, opError("firewalld-untrust", err))
+			}
+		}
+	}
+	if cfg.IPMasq {
+		if err := p.NetOps.ClearMasquerade(key); err != nil {
+			errs = append(errs, opError("clear-masquerade", err))
+		}
+	}
+	if cfg.ConntrackZones {
+		if err := p.NetOps.ClearCTZone(key); err != nil {
+			errs = append(errs, opError("clear-ctzone", err))
+		} else if err := p.NetOps.FlushConntrackZone(ConntrackZone(key)); err != nil {
+			errs = append(errs, opError("clear-ctzone", err))
+		}
+	}
+	if cfg.EgressGateway != nil {
+		// Cleared whatever CNI_ARGS said at ADD time; clearing is idempotent.
+		if err := p.NetOps.ClearEgressGateway(key); err != nil {
+			errs = append(errs, opError("clear-egress-gateway", err))
+		}
+	}
+	if cfg.NodeLocalDNSTarget != nil {
+		if err := p.NetOps.ClearDNSRedirect(key); err != nil {
+			errs = append(errs, opError("clear-dns-redirect", err))
+		}
+	}
+	if len(cfg.PortRanges) > 0 {
+		if err := p.NetOps.ClearPortMappings(key); err != nil {
+			errs = append(errs, opError("clear-portmap", err))
+		}
+	}
+	if cfg.StaticNeighbors.Pod && cfg.Mode == config.ModeBridge {
+		// Entries on a ptp host veth went away with the link.
+		if attachment, ok, _ := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName); ok && attachment.Result != nil {
+			for _, ipc := range attachment.Result.IPs {
+				if err := p.NetOps.DeleteHostStaticNeighbor(cfg.Bridge, ipc.Address.IP); err != nil {
+					errs = append(errs, opError("delete-static-neighbor", err))
+				}
+			}
+		}
+	}
+	if cfg.RouteProto != 0 {
+		// Matching the protocol number leaves any route another daemon
+		// installed for the same prefix in place.
+		if attachment, ok, _ := cache.Load(cfg.IPAM.DataDir, cfg.Name, args.ContainerID, args.IfName); ok && attachment.Result != nil {
+			for _, ipc := range attachment.Result.IPs {
+				dst := &net.IPNet{IP: ipc.Address.IP, Mask: net.CIDRMask(32, 32)}
+				if err := p.NetOps.DeleteProtoRoute(dst, cfg.RouteProto); err != nil {
+					errs = append(errs, opError("delete-host-route", err))
+				}
+			}
+		}
+	}
+	if cfg.DSCP != nil || config.ParseCNIArgs(args.Args)["DSCP"] != "" {
+		if err := p.NetOps.ClearDSCP(hostVeth); err != nil {
+			errs = append(errs, opError("clear-dscp", err))
+		}
+	}
+
+	if args.Netns != "" {
+		targetNS, err := p.getNS(args.Netns)
+		switch {
+		case err == nil:
+			if err := p.NetOps.DeleteLinkInNS(targetNS, args.IfName); err != nil {
+				errs = append(errs, opError("delete-container-link", err))
+			}
+			targetNS.Close()
+		case isNetnsGone(err):
+			// The runtime already tore down the sandbox; the veth went with it.
+		default:
+			errs = append(errs, opError("open-netns", err))
+		}
+	}
+	return errs
+}
+
+// resolvePartition carves (or looks up) this network's subnet from clusterSubnet.
+func resolvePartition(cfg *config.NetworkConfig) error {
+	key, err := cfg.PartitionKey()
+	if err != nil {
+		return err
+	}
+	subnet, err := ipam.CarveSubnet(cfg.PartitionIndex, cfg.ClusterSubnetNet, cfg.PartitionPrefix, key)
+	if err != nil {
+		return err
+	}
+	return cfg.ApplySubnet(subnet)
+}
+
+// emit sends lifecycle events to the configured sink. Events are best effort:
+// an unreachable consumer must never fail pod networking.
+func (p *Plugin) emit(cfg *config.NetworkConfig, evs ...events.Event) {
+	sink := p.Events
+	if sink == nil {
+		sink = events.New(cfg.Events.File, cfg.Events.Socket)
+	}
+	if sink == nil {
+		return
+	}
+	for _, ev := range evs {
+		_ = sink.Emit(ev)
+	}
+}
+
+// withTimeout bounds an operation by the configured timeout, if any.
+func withTimeout(ctx context.Context, cfg *config.NetworkConfig) (context.Context, context.CancelFunc) {
+	if cfg.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(cfg.Timeout)*time.Second)
+}
+
+// observe records an operation outcome and the setup steps that ran; metrics
+// failures never fail the operation. ADD holds up pod startup, so when the
+// recorder can defer, its observation is only journaled and the snapshot
+// rewrite is left to the next DEL or atomicnid. This is the only ADD work
+// deferred: exec audit lines and lifecycle events are written as they happen.
+func (p *Plugin) observe(stdin []byte, op string, start time.Time, err error, steps stepTimes) {
+	if p.Metrics == nil {
+		return
+	}
+	network, dataDir := config.Identity(stdin)
+	obs := metrics.Observation{
+		Network:   network,
+		Operation: op,
+		Stage:     Stage(err),
+		Duration:  time.Since(start),
+		Steps:     steps,
+	}
+	if d, ok := p.Metrics.(metrics.Deferrer); ok && op == "ADD" {
+		_ = d.Defer(dataDir, obs)
+		return
+	}
+	_ = p.Metrics.Observe(dataDir, obs)
+}
+
+// stepTimes accumulates the duration of each ADD setup step; a multi-network
+// ADD sums its members.
+type stepTimes map[string]time.Duration
+
+// record adds the time since start to step.
+func (s stepTimes) record(step string, start time.Time) {
+	s[step] += time.Since(start)
+}
+
+// getNS opens a pod network namespace through p.NetNS.
+func (p *Plugin) getNS(path string) (ns.NetNS, error) {
+	return netnsutil.Or(p.NetNS).GetNS(path)
+}
+
+// isNetnsGone reports whether a netns open error means the namespace no longer exists.
+func isNetnsGone(err error) bool {
+	var notExist ns.NSPathNotExistErr
+	var notNS ns.NSPathNotNSErr
+	return errors.As(err, &notExist) || errors.As(err, &notNS)
 }
 
 // cloneIP returns a detached copy so callers can safely mutate the value.
@@ -133,19 +831,15 @@ func cloneIP(ip net.IP) net.IP {
 	return dup
 }
 
-// rollbackStack stores cleanup actions and executes them in reverse order.
-type rollbackStack struct {
-	fns []func()
-}
-
-// Push registers one cleanup function.
-func (r *rollbackStack) Push(fn func()) {
-	r.fns = append(r.fns, fn)
-}
-
-// Run executes all cleanup functions in LIFO order.
-func (r *rollbackStack) Run() {
-	for i := len(r.fns) - 1; i >= 0; i-- {
-		r.fns[i]()
+// expandArgs returns args with the template variables in its config
+// resolved from the environment and CNI_ARGS. On error args is returned
+// unchanged, so the operation can still log with it.
+func expandArgs(args *skel.CmdArgs) (*skel.CmdArgs, error) {
+	stdin, err := config.Expand(args.StdinData, config.TemplateVars(args.Args))
+	if err != nil {
+		return args, opError("parse-config", err)
 	}
+	expanded := *args
+	expanded.StdinData = stdin
+	return &expanded, nil
 }
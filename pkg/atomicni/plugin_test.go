@@ -1,18 +1,66 @@
 package atomicni
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/clock"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/events"
 	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/metrics"
+	"github.com/annis-souames/atomicni/pkg/netnsutil"
+	"github.com/annis-souames/atomicni/pkg/netops"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/plugins/pkg/ns"
 )
 
 type mockNetOps struct {
-	calls []string
+	dscp            map[string]int
+	connLimits      map[string]uint64
+	portMaps        map[string][]netops.PortForward
+	masq            map[string]netops.Masquerade
+	ctZones         map[string]netops.CTZone
+	egress          map[string]netops.EgressGateway
+	dnsRedirects    map[string]netops.DNSRedirect
+	shapers         map[string]netops.Shaper
+	podSets         map[string][]string
+	flushedZones    []int
+	neighbors       map[string]string
+	mtus            map[string]int
+	links           []netops.OwnedLink
+	containerMAC    string
+	maxMTUs         map[string]int
+	firewalld       map[string]string
+	calls           []string
+	failDeleteLinks int
+	verifyErr       error
+	protoRouteGone  bool
+	protoRoutes     map[string]int
+	ctEntries       map[int]int
+	hostAddrs       []netops.HostAddress
+	defaultRoute    *netops.RouteOutcome
+	routes          []netops.DefaultRoute
+	egressRoutes    map[int]net.IP
+	gatewaysDown    map[string]bool
+	tuneErr         error
+	containerGone   bool
+	podLink         netops.PodLink
+	addedRoutes     []string
+	sysctls         map[string]string
 }
 
 func (m *mockNetOps) EnsureBridge(name string, gateway *net.IPNet) error {
@@ -22,6 +70,7 @@ func (m *mockNetOps) EnsureBridge(name string, gateway *net.IPNet) error {
 
 func (m *mockNetOps) CreateVethPair(hostName, peerName string, mtu int) error {
 	m.calls = append(m.calls, "CreateVethPair")
+	m.links = append(m.links, netops.OwnedLink{Name: hostName})
 	return nil
 }
 
@@ -30,6 +79,31 @@ func (m *mockNetOps) AttachHostVethToBridge(hostName, bridgeName string) error {
 	return nil
 }
 
+func (m *mockNetOps) SetLinkAlias(name, alias, altName string) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetLinkAlias %s %s", alias, altName))
+	return nil
+}
+
+func (m *mockNetOps) SetBridgeOptions(name string, opts netops.BridgeOptions) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetBridgeOptions ageing=%d snooping=%t querier=%t", *opts.AgeingTime, *opts.McastSnooping, *opts.McastQuerier))
+	return nil
+}
+
+func (m *mockNetOps) SetBridgePortVLANs(bridgeName, portName string, vids []int) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetBridgePortVLANs%v", vids))
+	return nil
+}
+
+func (m *mockNetOps) SetBridgePortIsolated(portName string, isolated bool) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetBridgePortIsolated %t", isolated))
+	return nil
+}
+
+func (m *mockNetOps) EnsureVLANLink(parent, name string, id int, protocol string) error {
+	m.calls = append(m.calls, fmt.Sprintf("EnsureVLANLink %s>%s %s/%d", parent, name, protocol, id))
+	return nil
+}
+
 func (m *mockNetOps) MoveToNamespace(linkName string, target ns.NetNS) error {
 	m.calls = append(m.calls, "MoveToNamespace")
 	return nil
@@ -40,13 +114,356 @@ func (m *mockNetOps) PrepareContainerLink(target ns.NetNS, currentName, targetNa
 	return "11:22:33:44:55:66", nil
 }
 
-func (m *mockNetOps) AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, gateway net.IP) error {
+func (m *mockNetOps) SetupHostVeth(v netops.HostVeth) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetupHostVeth master=%s addr=%v", v.Bridge, v.Address))
+	m.links = append(m.links, netops.OwnedLink{Name: v.Name, Master: v.Bridge})
+	return nil
+}
+
+func (m *mockNetOps) SetupContainerLink(target ns.NetNS, peer, ifName string, addr *net.IPNet, route *netops.DefaultRoute) (string, netops.RouteOutcome, error) {
+	if route == nil {
+		m.calls = append(m.calls, fmt.Sprintf("SetupContainerLink %s via <nil>", addr))
+		return "11:22:33:44:55:66", netops.RouteOutcome{}, nil
+	}
+	m.calls = append(m.calls, fmt.Sprintf("SetupContainerLink %s via %v", addr, route.Gateway))
+	m.routes = append(m.routes, *route)
+	if m.defaultRoute == nil {
+		m.defaultRoute = &netops.RouteOutcome{Installed: true, Metric: route.Metric}
+	}
+	return "11:22:33:44:55:66", *m.defaultRoute, nil
+}
+
+func (m *mockNetOps) AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, route netops.DefaultRoute) (netops.RouteOutcome, error) {
 	m.calls = append(m.calls, "AddAddressAndRoute")
-	return errors.New("boom")
+	return netops.RouteOutcome{}, errors.New("boom")
+}
+
+func (m *mockNetOps) DefaultRouteMetric(target ns.NetNS, ifName string, gateway net.IP) (int, bool, error) {
+	if m.defaultRoute == nil || !m.defaultRoute.Installed {
+		return 0, false, nil
+	}
+	return m.defaultRoute.Metric, true, nil
+}
+
+func (m *mockNetOps) AddAddress(target ns.NetNS, ifName string, addr *net.IPNet) error {
+	m.calls = append(m.calls, "AddAddress")
+	return nil
+}
+
+func (m *mockNetOps) AddRoute(target ns.NetNS, ifName string, dst *net.IPNet, gateway net.IP) error {
+	m.calls = append(m.calls, "AddRoute")
+	m.addedRoutes = append(m.addedRoutes, fmt.Sprintf("%s via %s", dst, gateway))
+	return nil
+}
+
+func (m *mockNetOps) AddAddressOnHostLink(name string, addr *net.IPNet) error {
+	m.calls = append(m.calls, "AddAddressOnHostLink")
+	return nil
+}
+
+func (m *mockNetOps) AddStaticNeighbor(target ns.NetNS, link string, ip net.IP, mac string) error {
+	return m.AddHostStaticNeighbor("netns/"+link, ip, mac)
+}
+
+func (m *mockNetOps) AddHostStaticNeighbor(link string, ip net.IP, mac string) error {
+	if m.neighbors == nil {
+		m.neighbors = map[string]string{}
+	}
+	m.neighbors[link+" "+ip.String()] = mac
+	return nil
+}
+
+func (m *mockNetOps) VerifyGateway(target ns.NetNS, ifName string, gateway net.IP) error {
+	m.calls = append(m.calls, "VerifyGateway")
+	return m.verifyErr
+}
+
+func (m *mockNetOps) DeleteHostStaticNeighbor(link string, ip net.IP) error {
+	delete(m.neighbors, link+" "+ip.String())
+	return nil
+}
+
+func (m *mockNetOps) SetProxyARP(name string, enabled bool) error {
+	m.calls = append(m.calls, "SetProxyARP")
+	return nil
+}
+
+func (m *mockNetOps) TuneNeighbors(link string, t netops.NeighborTuning) error {
+	m.calls = append(m.calls, fmt.Sprintf("TuneNeighbors %s %d/%d/%d %s", link, t.GCThresh1, t.GCThresh2, t.GCThresh3, t.BaseReachableTime))
+	return m.tuneErr
+}
+
+func (m *mockNetOps) AddHostRoute(dst *net.IPNet, linkName string) error {
+	m.calls = append(m.calls, "AddHostRoute")
+	return nil
+}
+
+func (m *mockNetOps) AddProtoRoute(dst *net.IPNet, linkName string, proto int) error {
+	m.calls = append(m.calls, fmt.Sprintf("AddProtoRoute %s %s %d", dst, linkName, proto))
+	if m.protoRoutes == nil {
+		m.protoRoutes = map[string]int{}
+	}
+	m.protoRoutes[dst.String()] = proto
+	return nil
+}
+
+func (m *mockNetOps) DeleteProtoRoute(dst *net.IPNet, proto int) error {
+	m.calls = append(m.calls, fmt.Sprintf("DeleteProtoRoute %s %d", dst, proto))
+	if m.protoRoutes[dst.String()] == proto {
+		delete(m.protoRoutes, dst.String())
+	}
+	return nil
+}
+
+func (m *mockNetOps) EnsureServiceRoute(dst *net.IPNet, via net.IP) error {
+	m.calls = append(m.calls, "EnsureServiceRoute")
+	return nil
+}
+
+func (m *mockNetOps) SetDSCP(hostLink string, dscp int) error {
+	m.calls = append(m.calls, "SetDSCP")
+	if m.dscp == nil {
+		m.dscp = map[string]int{}
+	}
+	m.dscp[hostLink] = dscp
+	return nil
+}
+
+func (m *mockNetOps) ClearDSCP(hostLink string) error {
+	m.calls = append(m.calls, "ClearDSCP")
+	delete(m.dscp, hostLink)
+	return nil
+}
+
+func (m *mockNetOps) GetDSCP(hostLink string) (int, bool, error) {
+	m.calls = append(m.calls, "GetDSCP")
+	dscp, ok := m.dscp[hostLink]
+	return dscp, ok, nil
+}
+
+func (m *mockNetOps) SetConnLimit(key string, podIP net.IP, perSecond, burst int) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetConnLimit %s %d/%d", podIP, perSecond, burst))
+	if m.connLimits == nil {
+		m.connLimits = map[string]uint64{}
+	}
+	m.connLimits[key] = 0
+	return nil
+}
+
+func (m *mockNetOps) ClearConnLimit(key string) error {
+	m.calls = append(m.calls, "ClearConnLimit")
+	delete(m.connLimits, key)
+	return nil
+}
+
+func (m *mockNetOps) ConnLimitDrops() (map[string]uint64, error) {
+	return m.connLimits, nil
+}
+
+func (m *mockNetOps) SetPortMappings(key string, podIP net.IP, forwards []netops.PortForward) error {
+	m.calls = append(m.calls, "SetPortMappings")
+	if m.portMaps == nil {
+		m.portMaps = map[string][]netops.PortForward{}
+	}
+	m.portMaps[key] = forwards
+	return nil
+}
+
+func (m *mockNetOps) ClearPortMappings(key string) error {
+	m.calls = append(m.calls, "ClearPortMappings")
+	delete(m.portMaps, key)
+	return nil
+}
+
+func (m *mockNetOps) SetMasquerade(key string, masq netops.Masquerade) error {
+	m.calls = append(m.calls, "SetMasquerade")
+	if m.masq == nil {
+		m.masq = map[string]netops.Masquerade{}
+	}
+	m.masq[key] = masq
+	return nil
+}
+
+func (m *mockNetOps) ClearMasquerade(key string) error {
+	m.calls = append(m.calls, "ClearMasquerade")
+	delete(m.masq, key)
+	return nil
+}
+
+func (m *mockNetOps) SetCTZone(key string, z netops.CTZone) error {
+	m.calls = append(m.calls, "SetCTZone")
+	if m.ctZones == nil {
+		m.ctZones = map[string]netops.CTZone{}
+	}
+	m.ctZones[key] = z
+	return nil
+}
+
+func (m *mockNetOps) ClearCTZone(key string) error {
+	m.calls = append(m.calls, "ClearCTZone")
+	delete(m.ctZones, key)
+	return nil
+}
+
+func (m *mockNetOps) SetEgressGateway(key string, g netops.EgressGateway) error {
+	m.calls = append(m.calls, "SetEgressGateway")
+	if m.egress == nil {
+		m.egress = map[string]netops.EgressGateway{}
+	}
+	m.egress[key] = g
+	return nil
+}
+
+func (m *mockNetOps) ClearEgressGateway(key string) error {
+	m.calls = append(m.calls, "ClearEgressGateway")
+	delete(m.egress, key)
+	return nil
+}
+
+func (m *mockNetOps) EgressRoute(table int) (net.IP, error) {
+	return m.egressRoutes[table], nil
+}
+
+func (m *mockNetOps) SetEgressRoute(table int, gateway net.IP) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetEgressRoute %d %s", table, gateway))
+	if m.egressRoutes == nil {
+		m.egressRoutes = map[int]net.IP{}
+	}
+	m.egressRoutes[table] = gateway
+	return nil
+}
+
+func (m *mockNetOps) ProbeGateway(gateway net.IP, method string) error {
+	if m.gatewaysDown[gateway.String()] {
+		return fmt.Errorf("gateway %s did not answer", gateway)
+	}
+	return nil
+}
+
+func (m *mockNetOps) SetDNSRedirect(key string, d netops.DNSRedirect) error {
+	m.calls = append(m.calls, "SetDNSRedirect")
+	if m.dnsRedirects == nil {
+		m.dnsRedirects = map[string]netops.DNSRedirect{}
+	}
+	m.dnsRedirects[key] = d
+	return nil
+}
+
+func (m *mockNetOps) ClearDNSRedirect(key string) error {
+	m.calls = append(m.calls, "ClearDNSRedirect")
+	delete(m.dnsRedirects, key)
+	return nil
+}
+
+func (m *mockNetOps) AddPodSetMember(network string, ip net.IP) error {
+	m.calls = append(m.calls, "AddPodSetMember")
+	if m.podSets == nil {
+		m.podSets = map[string][]string{}
+	}
+	m.podSets[network] = append(m.podSets[network], ip.String())
+	return nil
+}
+
+func (m *mockNetOps) RemovePodSetMember(network string, ip net.IP) error {
+	m.calls = append(m.calls, "RemovePodSetMember")
+	if members, ok := m.podSets[network]; ok {
+		m.podSets[network] = slices.DeleteFunc(members, func(s string) bool { return s == ip.String() })
+	}
+	return nil
+}
+
+func (m *mockNetOps) FlushConntrackZone(zone int) error {
+	m.flushedZones = append(m.flushedZones, zone)
+	return nil
+}
+
+func (m *mockNetOps) PodSetHasMember(network string, ip net.IP) (bool, error) {
+	return slices.Contains(m.podSets[network], ip.String()), nil
+}
+
+func (m *mockNetOps) ProtoRoutePresent(dst *net.IPNet, proto int) (bool, error) {
+	p, ok := m.protoRoutes[dst.String()]
+	return !m.protoRouteGone && ok && p == proto, nil
+}
+
+func (m *mockNetOps) HostStaticNeighborPresent(link string, ip net.IP) (bool, error) {
+	_, ok := m.neighbors[link+" "+ip.String()]
+	return ok, nil
+}
+
+func (m *mockNetOps) ConntrackZoneEntries(zone int) (int, error) {
+	if slices.Contains(m.flushedZones, zone) {
+		return 0, nil
+	}
+	return m.ctEntries[zone], nil
+}
+
+func (m *mockNetOps) FirewalldBound(zone, iface string) bool {
+	return m.firewalld[iface] == zone
+}
+
+func (m *mockNetOps) RuleDigest(kind, key string) (string, error) {
+	var body any
+	var ok bool
+	switch kind {
+	case netops.RuleDSCP:
+		body, ok = m.dscp[key]
+	case netops.RuleConnLimit:
+		// The map holds drop counters, which are not part of the rule.
+		_, ok = m.connLimits[key]
+		body = key
+	case netops.RulePortMap:
+		body, ok = m.portMaps[key]
+	case netops.RuleMasquerade:
+		body, ok = m.masq[key]
+	case netops.RuleCTZone:
+		body, ok = m.ctZones[key]
+	case netops.RuleEgress:
+		body, ok = m.egress[key]
+	case netops.RuleDNS:
+		body, ok = m.dnsRedirects[key]
+	default:
+		return "", fmt.Errorf("unknown rule kind %q", kind)
+	}
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprintf("%s %+v", kind, body), nil
+}
+
+func (m *mockNetOps) FirewalldRunning() bool {
+	return m.firewalld != nil
+}
+
+func (m *mockNetOps) FirewalldTrust(zone, iface string) error {
+	m.calls = append(m.calls, "FirewalldTrust")
+	m.firewalld[iface] = zone
+	return nil
+}
+
+func (m *mockNetOps) FirewalldUntrust(zone, iface string) error {
+	m.calls = append(m.calls, "FirewalldUntrust")
+	delete(m.firewalld, iface)
+	return nil
+}
+
+func (m *mockNetOps) ListOwnedLinks(bridge, prefix string) ([]netops.OwnedLink, error) {
+	var out []netops.OwnedLink
+	for _, l := range m.links {
+		if strings.HasPrefix(l.Name, prefix) && (bridge == "" || l.Master == bridge) {
+			out = append(out, l)
+		}
+	}
+	return out, nil
 }
 
 func (m *mockNetOps) DeleteLink(name string) error {
 	m.calls = append(m.calls, "DeleteLink")
+	if m.failDeleteLinks > 0 {
+		m.failDeleteLinks--
+		return errors.New("link busy")
+	}
+	m.links = slices.DeleteFunc(m.links, func(l netops.OwnedLink) bool { return l.Name == name })
 	return nil
 }
 
@@ -55,6 +472,63 @@ func (m *mockNetOps) DeleteLinkInNS(target ns.NetNS, name string) error {
 	return nil
 }
 
+func (m *mockNetOps) GetLinkMACInNS(target ns.NetNS, name string) (string, error) {
+	if m.containerGone {
+		return "", fmt.Errorf("read MAC for %q: %w", name, netops.ErrLinkNotFound)
+	}
+	if m.containerMAC != "" {
+		return m.containerMAC, nil
+	}
+	return "11:22:33:44:55:66", nil
+}
+
+func (m *mockNetOps) SetLinkMAC(name, mac string) error {
+	m.calls = append(m.calls, "SetLinkMAC "+mac)
+	return nil
+}
+
+func (m *mockNetOps) SetLinkMACInNS(target ns.NetNS, name, mac string) error {
+	m.calls = append(m.calls, "SetLinkMACInNS "+mac)
+	m.containerMAC = mac
+	return nil
+}
+
+func (m *mockNetOps) SetSysctls(target ns.NetNS, values map[string]string) error {
+	m.calls = append(m.calls, "SetSysctls")
+	m.sysctls = values
+	return nil
+}
+
+func (m *mockNetOps) PodLinkState(target ns.NetNS, ifName string) (netops.PodLink, error) {
+	return m.podLink, nil
+}
+
+func (m *mockNetOps) ListHostAddresses() ([]netops.HostAddress, error) {
+	return m.hostAddrs, nil
+}
+
+func (m *mockNetOps) LinkMTU(name string) (int, int, error) {
+	mtu, ok := m.mtus[name]
+	if !ok {
+		mtu = 1500
+	}
+	return mtu, m.maxMTUs[name], nil
+}
+
+func (m *mockNetOps) GetShaper(link string) (netops.Shaper, bool, error) {
+	s, ok := m.shapers[link]
+	return s, ok, nil
+}
+
+func (m *mockNetOps) SetLinkMTU(name string, mtu int) error {
+	m.calls = append(m.calls, fmt.Sprintf("SetLinkMTU %s %d", name, mtu))
+	if m.mtus == nil {
+		m.mtus = map[string]int{}
+	}
+	m.mtus[name] = mtu
+	return nil
+}
+
 func (m *mockNetOps) GetLinkMAC(name string) (string, error) {
 	m.calls = append(m.calls, "GetLinkMAC")
 	return "aa:bb:cc:dd:ee:ff", nil
@@ -74,38 +548,47 @@ func (m *mockAllocator) Release(_ context.Context, dataDir, network, containerID
 	return nil
 }
 
+func (m *mockAllocator) ReleaseIP(_ context.Context, dataDir, network string, ip net.IP) error {
+	m.calls = append(m.calls, "ReleaseIP")
+	return nil
+}
+
+func (m *mockAllocator) ForceRelease(_ context.Context, dataDir, network, containerID string) error {
+	m.calls = append(m.calls, "ForceRelease")
+	return nil
+}
+
 func (m *mockAllocator) GetByContainer(_ context.Context, dataDir, network, containerID string) (net.IP, bool, error) {
 	m.calls = append(m.calls, "GetByContainer")
 	return nil, false, nil
 }
 
+func (m *mockAllocator) List(_ context.Context, dataDir, network string) (map[string]net.IP, error) {
+	m.calls = append(m.calls, "List")
+	return map[string]net.IP{}, nil
+}
+
+func (m *mockAllocator) Networks(_ context.Context, dataDir string) ([]string, error) {
+	m.calls = append(m.calls, "Networks")
+	return nil, nil
+}
+
 func TestAddRollsBackOnConfigureFailure(t *testing.T) {
-	nsPath, err := ns.GetCurrentNS()
-	if err != nil {
-		t.Fatalf("GetCurrentNS: %v", err)
-	}
-	defer nsPath.Close()
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
 
 	netOps := &mockNetOps{}
 	alloc := &mockAllocator{}
-	p := &Plugin{NetOps: netOps, IPAM: alloc}
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns}
 
 	args := &skel.CmdArgs{
 		ContainerID: "test-container",
-		Netns:       nsPath.Path(),
+		Netns:       podNS.Path(),
 		IfName:      "eth0",
-		StdinData: []byte(`{
-			"cniVersion":"1.1.0",
-			"name":"atomic-net",
-			"type":"atomicni",
-			"bridge":"atomic0",
-			"subnet":"10.22.0.0/24",
-			"gateway":"10.22.0.1",
-			"ipam":{"dataDir":"/tmp/atomicni-test","rangeStart":"10.22.0.10","rangeEnd":"10.22.0.20"}
-		}`),
+		StdinData:   testStdin(t.TempDir()),
 	}
 
-	_, err = p.Add(context.Background(), args)
+	_, err := p.Add(context.Background(), args)
 	if err == nil {
 		t.Fatalf("expected Add() failure")
 	}
@@ -128,3 +611,2457 @@ func TestAddRollsBackOnConfigureFailure(t *testing.T) {
 		t.Fatalf("expected link cleanup calls, got %v", netOps.calls)
 	}
 }
+
+type mockRecorder struct {
+	observations []metrics.Observation
+}
+
+func (m *mockRecorder) Observe(_ string, obs metrics.Observation) error {
+	m.observations = append(m.observations, obs)
+	return nil
+}
+
+func TestAddRecordsFailureStage(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	rec := &mockRecorder{}
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: &mockAllocator{}, Metrics: rec, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "test-container",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   testStdin(t.TempDir()),
+	}
+	if _, err := p.Add(context.Background(), args); err == nil {
+		t.Fatalf("expected Add() failure")
+	}
+
+	if len(rec.observations) != 1 {
+		t.Fatalf("expected one observation, got %d", len(rec.observations))
+	}
+	obs := rec.observations[0]
+	if obs.Network != "atomic-net" || obs.Operation != "ADD" || obs.Stage != "address" {
+		t.Fatalf("unexpected observation: %+v", obs)
+	}
+	for _, step := range []string{"bridge", "veth", "netns", "ipam"} {
+		if _, ok := obs.Steps[step]; !ok {
+			t.Fatalf("step %q not recorded: %v", step, obs.Steps)
+		}
+	}
+	if _, ok := obs.Steps["address"]; ok {
+		t.Fatalf("failed address step was recorded: %v", obs.Steps)
+	}
+}
+
+func testStdin(dataDir string) []byte {
+	return []byte(fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipam":{"dataDir":%q,"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.20"}
+	}`, dataDir))
+}
+
+func allocateForTest(t *testing.T, alloc ipam.Allocator, dataDir, containerID string) {
+	t.Helper()
+	_, subnet, _ := net.ParseCIDR("10.22.0.0/24")
+	_, err := alloc.Allocate(context.Background(), ipam.AllocationRequest{
+		DataDir:     dataDir,
+		Network:     "atomic-net",
+		ContainerID: containerID,
+		Subnet:      subnet,
+		Gateway:     net.ParseIP("10.22.0.1").To4(),
+		RangeStart:  net.ParseIP("10.22.0.10").To4(),
+		RangeEnd:    net.ParseIP("10.22.0.20").To4(),
+	})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+}
+
+func TestDelIsIdempotent(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "test-container")
+
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc}
+	args := &skel.CmdArgs{
+		ContainerID: "test-container",
+		Netns:       "/var/run/netns/atomicni-does-not-exist",
+		IfName:      "eth0",
+		StdinData:   testStdin(dataDir),
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := p.Del(context.Background(), args); err != nil {
+			t.Fatalf("Del() call %d error = %v", i+1, err)
+		}
+	}
+
+	_, ok, err := alloc.GetByContainer(context.Background(), dataDir, "atomic-net", "test-container")
+	if err != nil {
+		t.Fatalf("GetByContainer: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected allocation to be released")
+	}
+}
+
+type mockSink struct {
+	events []events.Event
+}
+
+func (m *mockSink) Emit(ev events.Event) error {
+	m.events = append(m.events, ev)
+	return nil
+}
+
+func TestDelEmitsReleaseEventOnce(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "test-container")
+
+	sink := &mockSink{}
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc, Events: sink}
+	args := &skel.CmdArgs{
+		ContainerID: "test-container",
+		IfName:      "eth0",
+		StdinData:   testStdin(dataDir),
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.Del(context.Background(), args); err != nil {
+			t.Fatalf("Del() error = %v", err)
+		}
+	}
+
+	if len(sink.events) != 1 || sink.events[0].Type != events.IPReleased || sink.events[0].IP != "10.22.0.10" {
+		t.Fatalf("unexpected events: %+v", sink.events)
+	}
+}
+
+func TestDelSucceedsWithoutNetns(t *testing.T) {
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: ipam.NewFileAllocator()}
+	args := &skel.CmdArgs{
+		ContainerID: "never-added",
+		IfName:      "eth0",
+		StdinData:   testStdin(t.TempDir()),
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+}
+
+func TestDelHonorsEnvOverrides(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "test-container")
+	t.Setenv(config.EnvDataDir, dataDir)
+	t.Setenv(config.EnvLogLevel, config.LogLevelInfo)
+
+	var log bytes.Buffer
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc, Log: &log}
+	args := &skel.CmdArgs{
+		ContainerID: "test-container",
+		IfName:      "eth0",
+		StdinData:   testStdin(t.TempDir()),
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if _, ok, _ := alloc.GetByContainer(context.Background(), dataDir, "atomic-net", "test-container"); ok {
+		t.Fatalf("expected release from %s", config.EnvDataDir)
+	}
+	if out := log.String(); !strings.Contains(out, " info DEL container=test-container") || !strings.Contains(out, "build=") || !strings.Contains(out, " info DEL done") {
+		t.Fatalf("unexpected log output:\n%s", out)
+	}
+}
+
+func TestDelRetryAfterPartialFailure(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	allocateForTest(t, alloc, dataDir, "test-container")
+
+	netOps := &mockNetOps{failDeleteLinks: 1}
+	p := &Plugin{NetOps: netOps, IPAM: alloc}
+	args := &skel.CmdArgs{
+		ContainerID: "test-container",
+		IfName:      "eth0",
+		StdinData:   testStdin(dataDir),
+	}
+
+	if err := p.Del(context.Background(), args); err == nil {
+		t.Fatalf("expected first Del() to report link failure")
+	}
+	_, ok, err := alloc.GetByContainer(context.Background(), dataDir, "atomic-net", "test-container")
+	if err != nil {
+		t.Fatalf("GetByContainer: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected release to run even when link deletion fails")
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("second Del() error = %v", err)
+	}
+}
+
+func TestAddStaticIPAMSkipsAllocator(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	alloc := &mockAllocator{}
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "infra",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		Args:        "IP=10.22.0.250/24,10.22.0.251/24",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipam":{"type":"static","dataDir":%q,"routes":[{"dst":"192.168.0.0/16"}]}
+		}`, t.TempDir())),
+	}
+
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if len(alloc.calls) != 0 {
+		t.Fatalf("static IPAM must not call the allocator, got %v", alloc.calls)
+	}
+	if len(res.IPs) != 2 || res.IPs[1].Address.String() != "10.22.0.251/24" {
+		t.Fatalf("unexpected IPs: %+v", res.IPs)
+	}
+	if len(res.Routes) != 1 || res.Routes[0].Dst.String() != "192.168.0.0/16" || res.Routes[0].GW.String() != "10.22.0.1" {
+		t.Fatalf("unexpected routes: %+v", res.Routes)
+	}
+}
+
+func TestAddPTPModeSkipsBridge(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "ptp-pod",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"mode":"ptp",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	seen := map[string]bool{}
+	for _, c := range netOps.calls {
+		seen[c] = true
+	}
+	if seen["EnsureBridge"] || seen["AttachHostVethToBridge"] {
+		t.Fatalf("ptp mode must not touch bridges, calls: %v", netOps.calls)
+	}
+	for _, want := range []string{"AddAddressOnHostLink", "SetProxyARP", "AddHostRoute"} {
+		if !seen[want] {
+			t.Fatalf("expected %s call, got %v", want, netOps.calls)
+		}
+	}
+}
+
+func TestAddTrunksConfiguredVLANs(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "vnf",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"vlanTrunk":[{"id":100},{"minID":200,"maxID":202}],
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	want := "SetBridgePortVLANs[100 200 201 202]"
+	for i, c := range netOps.calls {
+		if c == want {
+			if i == 0 || netOps.calls[i-1] != "AttachHostVethToBridge" {
+				t.Fatalf("VLANs must be set after attaching to the bridge, calls: %v", netOps.calls)
+			}
+			return
+		}
+	}
+	t.Fatalf("expected %s, got %v", want, netOps.calls)
+}
+
+func TestAddAppliesBridgeOptions(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "mdns",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"bridgeOptions":{"ageingTime":60,"mcastSnooping":true,"mcastQuerier":true},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	want := "SetBridgeOptions ageing=60 snooping=true querier=true"
+	if len(netOps.calls) < 2 || netOps.calls[0] != "EnsureBridge" || netOps.calls[1] != want {
+		t.Fatalf("expected %s right after EnsureBridge, calls: %v", want, netOps.calls)
+	}
+}
+
+func TestAddTunesNeighborTable(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "dense",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"neighbor":{"gcThresh2":4096,"gcThresh3":8192,"baseReachableTime":60},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if want := "TuneNeighbors atomic0 0/4096/8192 1m0s"; !slices.Contains(netOps.calls, want) {
+		t.Fatalf("expected %s, calls: %v", want, netOps.calls)
+	}
+}
+
+func TestAddSkipsNeighborTuningInNestedNamespace(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	stdin := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"neighbor":{"gcThresh3":8192},
+		"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+	}`
+	for _, tc := range []struct {
+		err     error
+		wantErr bool
+	}{
+		{err: fmt.Errorf("%w: skipped gc_thresh3", netops.ErrSysctlUnavailable)},
+		{err: errors.New("disk on fire"), wantErr: true},
+	} {
+		var logs bytes.Buffer
+		p := &Plugin{NetOps: &mockNetOps{tuneErr: tc.err}, IPAM: &mockAllocator{}, Log: &logs, NetNS: netns}
+		args := &skel.CmdArgs{
+			ContainerID: "kind",
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(fmt.Sprintf(stdin, t.TempDir())),
+		}
+		_, err := p.Add(context.Background(), args)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("tune error %v: Add() error = %v, want error %t", tc.err, err, tc.wantErr)
+		}
+		if !tc.wantErr && !strings.Contains(logs.String(), "skipped gc_thresh3") {
+			t.Fatalf("skipped tuning not logged: %q", logs.String())
+		}
+	}
+}
+
+func TestAddIsolatesPodPorts(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "tenant",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"isolatePods":true,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	attached := slices.Index(netOps.calls, "AttachHostVethToBridge")
+	isolated := slices.Index(netOps.calls, "SetBridgePortIsolated true")
+	if attached < 0 || isolated < attached {
+		t.Fatalf("expected port isolation after attaching to the bridge, calls: %v", netOps.calls)
+	}
+}
+
+func TestAddBuildsQinQUplink(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "lab",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"qinq":{"master":"eth1","sVlan":100,"cVlan":10},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	want := []string{
+		"EnsureBridge",
+		"EnsureVLANLink eth1>eth1.100 802.1ad/100",
+		"EnsureVLANLink eth1.100>eth1.100.10 802.1Q/10",
+		"AttachHostVethToBridge",
+	}
+	if fmt.Sprint(netOps.calls[:len(want)]) != fmt.Sprint(want) {
+		t.Fatalf("calls = %v, want prefix %v", netOps.calls, want)
+	}
+}
+
+func TestDSCPMarkLifecycle(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "qos-pod",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		Args:        "DSCP=46",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"dscp":10,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if got := netOps.dscp[HostVethName("qos-pod")]; got != 46 {
+		t.Fatalf("dscp = %d, want per-pod override 46", got)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	netOps.dscp[HostVethName("qos-pod")] = 0
+	if err := p.Check(context.Background(), args); Stage(err) != "qos" {
+		t.Fatalf("Check() with drifted mark = %v, want qos failure", err)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if _, ok := netOps.dscp[HostVethName("qos-pod")]; ok {
+		t.Fatal("Del() left the dscp mark installed")
+	}
+	if err := p.Check(context.Background(), args); Stage(err) != "cache" {
+		t.Fatalf("Check() after Del = %v, want cache failure", err)
+	}
+}
+
+func TestConnLimitLifecycle(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "chatty",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"connLimit":{"perSecond":50},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !slices.Contains(netOps.calls, "SetConnLimit 10.22.0.30 50/50") {
+		t.Fatalf("expected connection limit with burst defaulted, calls: %v", netOps.calls)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if len(netOps.connLimits) != 0 {
+		t.Fatalf("Del() left limits installed: %v", netOps.connLimits)
+	}
+}
+
+func TestPortMappingRanges(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "web",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"runtimeConfig":{"portMappings":[
+				{"hostPort":30000,"hostPortEnd":30009,"containerPort":4000,"protocol":"udp"},
+				{"hostPort":8080,"containerPort":80}
+			]},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	key := "atomic-net:web:eth0"
+	got := netOps.portMaps[key]
+	if len(got) != 2 || got[0].Protocol != "tcp" || got[1].HostEnd != 30009 || got[1].ContainerStart != 4000 {
+		t.Fatalf("unexpected forwards: %+v", got)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if _, ok := netOps.portMaps[key]; ok {
+		t.Fatal("Del() left port mappings installed")
+	}
+}
+
+func TestMasqueradeWithSNATPool(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "egress",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipMasq":true,
+			"snat":{"portRange":"20000-29999","egressIP":"192.0.2.10"},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	got, ok := netOps.masq["atomic-net:egress:eth0"]
+	if !ok || got.Source.String() != "10.22.0.30" || got.Exclude.String() != "10.22.0.0/24" ||
+		got.EgressIP.String() != "192.0.2.10" || got.PortMin != 20000 || got.PortMax != 29999 {
+		t.Fatalf("unexpected masquerade: %+v", got)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if len(netOps.masq) != 0 {
+		t.Fatalf("Del() left masquerade installed: %v", netOps.masq)
+	}
+}
+
+func TestEgressGatewaySelection(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	stdin := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"egressGateway":{"ip":"10.22.0.5","optIn":%t},
+		"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+	}`
+	for _, tc := range []struct {
+		optIn bool
+		args  string
+		want  bool
+	}{
+		{optIn: false, want: true},
+		{optIn: false, args: "EGRESS_GATEWAY=false", want: false},
+		{optIn: true, want: false},
+		{optIn: true, args: "EGRESS_GATEWAY=true", want: true},
+	} {
+		netOps := &mockNetOps{}
+		p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+		args := &skel.CmdArgs{
+			ContainerID: "egress",
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			Args:        tc.args,
+			StdinData:   []byte(fmt.Sprintf(stdin, tc.optIn, t.TempDir())),
+		}
+		if _, err := p.Add(context.Background(), args); err != nil {
+			t.Fatalf("optIn=%t %q: Add() error = %v", tc.optIn, tc.args, err)
+		}
+		got, ok := netOps.egress["atomic-net:egress:eth0"]
+		if ok != tc.want {
+			t.Fatalf("optIn=%t %q: egress gateway installed = %t, want %t", tc.optIn, tc.args, ok, tc.want)
+		}
+		if ok && (got.PodIP.String() != "10.22.0.30" || got.Exclude.String() != "10.22.0.0/24" ||
+			got.Gateway.String() != "10.22.0.5" || got.Table != config.DefaultEgressTable) {
+			t.Fatalf("unexpected egress gateway: %+v", got)
+		}
+		if err := p.Del(context.Background(), args); err != nil {
+			t.Fatalf("Del() error = %v", err)
+		}
+		if len(netOps.egress) != 0 {
+			t.Fatalf("Del() left egress gateway installed: %v", netOps.egress)
+		}
+	}
+}
+
+func TestGatewayMonitorFailsOverAndBack(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{egressRoutes: map[int]net.IP{100: net.ParseIP("10.22.0.5").To4()}, gatewaysDown: map[string]bool{}}
+	sink := &mockSink{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, Events: sink, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "egress",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"egressGateway":{"ip":"10.22.0.5","backup":"10.22.0.6","probeInterval":2,"probeFailures":2},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, dataDir)),
+	}
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if got := netOps.egress["atomic-net:egress:eth0"]; got.Backup.String() != "10.22.0.6" || got.Probe != config.ProbeICMP {
+		t.Fatalf("egress rule does not record the backup: %+v", got)
+	}
+
+	fake := clock.NewFake(time.Unix(1000, 0))
+	monitor := NewGatewayMonitor(p, dataDir)
+	monitor.Clock = fake
+	probe := func() *GatewayReport {
+		t.Helper()
+		fake.Advance(2 * time.Second)
+		report, err := monitor.Probe(context.Background())
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		return report
+	}
+	via := func() string { return netOps.egressRoutes[100].String() }
+
+	netOps.gatewaysDown["10.22.0.5"] = true
+	if report := probe(); report.Probed != 1 || via() != "10.22.0.5" {
+		t.Fatalf("one failed probe: %+v via %s", report, via())
+	}
+	if report, err := monitor.Probe(context.Background()); err != nil || report.Probed != 0 {
+		t.Fatalf("probe before the interval elapsed: %+v %v", report, err)
+	}
+	if report := probe(); len(report.Switched) != 1 || via() != "10.22.0.6" {
+		t.Fatalf("two failed probes did not fail over: %+v via %s", report, via())
+	}
+
+	netOps.gatewaysDown["10.22.0.5"] = false
+	probe()
+	if via() != "10.22.0.6" {
+		t.Fatalf("failed back after one good probe")
+	}
+	if report := probe(); len(report.Switched) != 1 || via() != "10.22.0.5" {
+		t.Fatalf("two good probes did not fail back: %+v via %s", report, via())
+	}
+	if len(sink.events) < 2 || sink.events[len(sink.events)-2].Type != events.GatewayFailover ||
+		sink.events[len(sink.events)-1].Type != events.GatewayFailback || sink.events[len(sink.events)-1].Network != "atomic-net" {
+		t.Fatalf("unexpected events: %+v", sink.events)
+	}
+
+	netOps.gatewaysDown["10.22.0.5"], netOps.gatewaysDown["10.22.0.6"] = true, true
+	probe()
+	fake.Advance(2 * time.Second)
+	if _, err := monitor.Probe(context.Background()); err == nil || via() != "10.22.0.5" {
+		t.Fatalf("failed over to a dead backup: err=%v via %s", err, via())
+	}
+}
+
+func TestServiceCIDRRoute(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "svc",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"serviceCIDR":"10.96.0.0/12",
+			"serviceHostRoute":"blackhole",
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !slices.Contains(netOps.calls, "AddRoute") || !slices.Contains(netOps.calls, "EnsureServiceRoute") {
+		t.Fatalf("service routes not installed: %v", netOps.calls)
+	}
+	routes := res.Routes
+	if len(routes) != 2 || routes[1].Dst.String() != "10.96.0.0/12" || routes[1].GW.String() != "10.22.0.1" {
+		t.Fatalf("unexpected result routes: %+v", routes)
+	}
+}
+
+func TestNodeLocalDNS(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "dns",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"nodeLocalDNS":{"ip":"169.254.20.10","target":"192.0.2.53","search":["svc.cluster.local"],"options":["ndots:5"]},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, t.TempDir())),
+	}
+
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if !slices.Equal(res.DNS.Nameservers, []string{"169.254.20.10"}) || !slices.Equal(res.DNS.Search, []string{"svc.cluster.local"}) ||
+		!slices.Equal(res.DNS.Options, []string{"ndots:5"}) {
+		t.Fatalf("unexpected dns: %+v", res.DNS)
+	}
+	got, ok := netOps.dnsRedirects["atomic-net:dns:eth0"]
+	if !ok || got.PodIP.String() != "10.22.0.30" || got.Listen.String() != "169.254.20.10" || got.Target.String() != "192.0.2.53" {
+		t.Fatalf("unexpected dns redirect: %+v", got)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if len(netOps.dnsRedirects) != 0 {
+		t.Fatalf("Del() left dns redirect installed: %v", netOps.dnsRedirects)
+	}
+}
+
+func TestPodSetTracksAddresses(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "members",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"podSet":true,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"},{"address":"10.22.0.31/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if got := netOps.podSets["atomic-net"]; !slices.Equal(got, []string{"10.22.0.30", "10.22.0.31"}) {
+		t.Fatalf("pod set = %v", got)
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if got := netOps.podSets["atomic-net"]; len(got) != 0 {
+		t.Fatalf("Del() left pod set members: %v", got)
+	}
+}
+
+func TestIPBatchAdd(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "batched",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipBatch":true,
+			"ipam":{"dataDir":%q}
+		}`, t.TempDir())),
+	}
+
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v, calls: %v", err, netOps.calls)
+	}
+	if got := res.IPs[0].Address.String(); got != "10.22.0.10/24" {
+		t.Fatalf("address = %s", got)
+	}
+	for _, want := range []string{"SetupHostVeth master=atomic0 addr=<nil>", "SetupContainerLink 10.22.0.10/24 via 10.22.0.1"} {
+		if !slices.Contains(netOps.calls, want) {
+			t.Fatalf("missing %q in calls: %v", want, netOps.calls)
+		}
+	}
+	for _, unbatched := range []string{"CreateVethPair", "AttachHostVethToBridge", "MoveToNamespace", "PrepareContainerLink", "AddAddressAndRoute"} {
+		if slices.Contains(netOps.calls, unbatched) {
+			t.Fatalf("batched ADD called %s: %v", unbatched, netOps.calls)
+		}
+	}
+	if n := netns.Open(); n != 0 {
+		t.Fatalf("ADD left %d namespace handles open", n)
+	}
+}
+
+func TestAddAppliesTemplateDNSRoutesAndSysctls(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	templates := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(templates, []byte(`{"templates":{"lab":{
+		"dns":{"nameservers":["10.96.0.10"],"search":["lab.svc"]},
+		"routes":[{"dst":"10.50.0.0/16"}],
+		"sysctls":{"net.ipv4.conf.all.rp_filter":"0"}
+	}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "templated",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipBatch":true,
+			"template":"lab",
+			"templatesFile":%q,
+			"routes":[{"dst":"192.168.0.0/16","gw":"10.22.0.254"}],
+			"sysctls":{"net.ipv4.ip_forward":"1"},
+			"ipam":{"dataDir":%q}
+		}`, templates, t.TempDir())),
+	}
+
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v, calls: %v", err, netOps.calls)
+	}
+	if want := []string{"10.50.0.0/16 via 10.22.0.1", "192.168.0.0/16 via 10.22.0.254"}; !slices.Equal(netOps.addedRoutes, want) {
+		t.Fatalf("pod routes = %v, want %v", netOps.addedRoutes, want)
+	}
+	if len(netOps.sysctls) != 2 || netOps.sysctls["net.ipv4.conf.all.rp_filter"] != "0" || netOps.sysctls["net.ipv4.ip_forward"] != "1" {
+		t.Fatalf("sysctls = %v", netOps.sysctls)
+	}
+	if len(res.Routes) != 3 || res.Routes[1].Dst.String() != "10.50.0.0/16" || !res.Routes[2].GW.Equal(net.ParseIP("10.22.0.254")) {
+		t.Fatalf("result routes = %v", res.Routes)
+	}
+	if !slices.Equal(res.DNS.Nameservers, []string{"10.96.0.10"}) || !slices.Equal(res.DNS.Search, []string{"lab.svc"}) {
+		t.Fatalf("result DNS = %+v", res.DNS)
+	}
+}
+
+func TestAddAndDelExpandTemplateVars(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	base := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc, NetNS: netns, Log: io.Discard}
+	args := &skel.CmdArgs{
+		ContainerID: "tenant-pod",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		Args:        "K8S_POD_NAMESPACE=team-a;K8S_POD_NAME=web-0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipBatch":true,
+			"ipam":{"dataDir":"%s/{{podNamespace}}"}
+		}`, base)),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	dataDir := filepath.Join(base, "team-a")
+	if allocations, err := alloc.List(context.Background(), dataDir, "atomic-net"); err != nil || len(allocations) != 1 {
+		t.Fatalf("allocations in %s = %v (%v)", dataDir, allocations, err)
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if allocations, _ := alloc.List(context.Background(), dataDir, "atomic-net"); len(allocations) != 0 {
+		t.Fatalf("DEL left allocations in %s: %v", dataDir, allocations)
+	}
+
+	args.Args = ""
+	if _, err := p.Add(context.Background(), args); err == nil || !strings.Contains(err.Error(), "K8S_POD_NAMESPACE") {
+		t.Fatalf("Add() without the pod namespace error = %v", err)
+	}
+}
+
+func TestAddRejectsInvalidIfNameBeforeTouchingHost(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+	netOps := &mockNetOps{}
+	alloc := &mockAllocator{}
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns, Log: io.Discard}
+
+	for _, ifName := range []string{"eth0-with-a-long-name", "lo", "eth:0"} {
+		args := &skel.CmdArgs{
+			ContainerID: "bad-ifname",
+			Netns:       podNS.Path(),
+			IfName:      ifName,
+			StdinData:   []byte(fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipBatch":true,"ipam":{"dataDir":%q}}`, t.TempDir())),
+		}
+		_, err := p.Add(context.Background(), args)
+		if err == nil || !strings.Contains(err.Error(), "CNI_IFNAME") || Stage(err) != "config" {
+			t.Fatalf("Add(%q) error = %v (stage %q)", ifName, err, Stage(err))
+		}
+	}
+	if len(netOps.calls) != 0 {
+		t.Fatalf("netops calls = %v, want none", netOps.calls)
+	}
+}
+
+func TestDeviceIDRejectedOnlyByAdd(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns, Log: io.Discard}
+	args := &skel.CmdArgs{
+		ContainerID: "vf-pod",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   []byte(fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","deviceID":"0000:3b:02.1","ipam":{"dataDir":%q}}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err == nil || !strings.Contains(err.Error(), "SR-IOV is not supported") {
+		t.Fatalf("Add() error = %v, want deviceID rejection", err)
+	}
+	if len(netOps.calls) != 0 {
+		t.Fatalf("rejected ADD touched the host: %v", netOps.calls)
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if err := p.GCNetwork(context.Background(), args); err != nil {
+		t.Fatalf("GCNetwork() error = %v", err)
+	}
+}
+
+func TestAddPinsHostVethMAC(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+	base := `{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipBatch":true,"ipam":{"dataDir":%q}%s}`
+
+	for _, ifName := range []string{"eth0", "net1"} {
+		netOps := &mockNetOps{}
+		p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns, Log: io.Discard}
+		args := &skel.CmdArgs{ContainerID: "pinned", Netns: podNS.Path(), IfName: ifName, StdinData: []byte(fmt.Sprintf(base, t.TempDir(), ""))}
+		if _, err := p.Add(context.Background(), args); err != nil {
+			t.Fatalf("Add(%s) error = %v", ifName, err)
+		}
+		mac := HostVethMAC(interfaceKey("pinned", ifName))
+		if mac[0]&0x03 != 0x02 {
+			t.Fatalf("HostVethMAC = %s, want unicast and locally administered", mac)
+		}
+		if !slices.Contains(netOps.calls, "SetLinkMAC "+mac.String()) {
+			t.Fatalf("%s: host MAC %s not pinned, calls: %v", ifName, mac, netOps.calls)
+		}
+	}
+	if HostVethMAC("pinned").String() == HostVethMAC("pinned/net1").String() {
+		t.Fatal("interfaces of one container share a host MAC")
+	}
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns, Log: io.Discard}
+	args := &skel.CmdArgs{ContainerID: "random", Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(fmt.Sprintf(base, t.TempDir(), `,"hostMAC":"random"`))}
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	for _, call := range netOps.calls {
+		if strings.HasPrefix(call, "SetLinkMAC ") {
+			t.Fatalf("hostMAC random still pinned: %v", netOps.calls)
+		}
+	}
+}
+
+func TestAddPhasesRunFromSerializedPlan(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "planned",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		Args:        "DSCP=10",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipBatch":true,
+			"ipam":{"dataDir":%q}
+		}`, t.TempDir())),
+	}
+
+	req, err := ParseAdd(args)
+	if err != nil {
+		t.Fatalf("ParseAdd() error = %v", err)
+	}
+	if req.Config.DSCP == nil || *req.Config.DSCP != 10 {
+		t.Fatalf("CNI_ARGS not applied: DSCP = %v", req.Config.DSCP)
+	}
+	plan, err := p.PlanAdd(req)
+	if err != nil {
+		t.Fatalf("PlanAdd() error = %v", err)
+	}
+	want := []string{
+		"check-subnet-overlap", "open-netns", "ensure-bridge", "cache-attachment", "create-veth",
+		"set-host-mac", "set-vlan-trunk", "set-dscp", "alloc-ip", "configure-container-ip",
+		"read-host-mac", "validate-result", "cache-result",
+	}
+	if !slices.Equal(plan.Steps, want) {
+		t.Fatalf("steps = %v, want %v", plan.Steps, want)
+	}
+	if len(netOps.calls) != 0 {
+		t.Fatalf("PlanAdd changed the host: %v", netOps.calls)
+	}
+
+	raw, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded AddPlan
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	res, err := p.ExecuteAdd(context.Background(), req, &decoded)
+	if err != nil {
+		t.Fatalf("ExecuteAdd() error = %v, calls: %v", err, netOps.calls)
+	}
+	if got := res.IPs[0].Address.String(); got != "10.22.0.10/24" {
+		t.Fatalf("address = %s", got)
+	}
+	if netOps.dscp[plan.HostVeth] != 10 {
+		t.Fatalf("dscp = %v", netOps.dscp)
+	}
+
+	if err := p.ExecuteDel(context.Background(), req); err != nil {
+		t.Fatalf("ExecuteDel() error = %v", err)
+	}
+	if _, ok, _ := cache.Load(req.Config.IPAM.DataDir, "atomic-net", "planned", "eth0"); ok {
+		t.Fatal("attachment still cached after ExecuteDel")
+	}
+}
+
+func TestExecuteAddRejectsMismatchedPlans(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: &mockAllocator{}, NetNS: netns}
+	newArgs := func(containerID string) *skel.CmdArgs {
+		return &skel.CmdArgs{
+			ContainerID: containerID,
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			StdinData:   []byte(fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipBatch":true,"ipam":{"dataDir":%q}}`, t.TempDir())),
+		}
+	}
+	req, err := ParseAdd(newArgs("pod-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := p.PlanAdd(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ParseAdd(newArgs("pod-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reordered := *plan
+	reordered.Steps = slices.Clone(plan.Steps)
+	slices.Reverse(reordered.Steps)
+	skipped := *plan
+	skipped.Steps = slices.DeleteFunc(slices.Clone(plan.Steps), func(op string) bool { return op == "cache-attachment" })
+	for name, tc := range map[string]struct {
+		req  *Request
+		plan *AddPlan
+	}{
+		"other container": {other, plan},
+		"reordered":       {req, &reordered},
+		"skipped step":    {req, &skipped},
+	} {
+		_, err := p.ExecuteAdd(context.Background(), tc.req, tc.plan)
+		var opErr *OpError
+		if !errors.As(err, &opErr) || opErr.Op != "plan-add" {
+			t.Fatalf("%s: ExecuteAdd() error = %v, want plan-add", name, err)
+		}
+	}
+}
+
+// crashingNetOps dies in VerifyGateway, as a plugin process killed mid-ADD.
+type crashingNetOps struct {
+	*mockNetOps
+}
+
+func (crashingNetOps) VerifyGateway(ns.NetNS, string, net.IP) error {
+	panic("killed")
+}
+
+// crashAdd runs an ADD of container that dies after masquerading the pod,
+// leaving its transaction log behind.
+func crashAdd(t *testing.T, p *Plugin, args *skel.CmdArgs) {
+	t.Helper()
+	netOps := p.NetOps
+	p.NetOps = crashingNetOps{netOps.(*mockNetOps)}
+	defer func() {
+		p.NetOps = netOps
+		if recover() == nil {
+			t.Fatal("ADD did not reach the crash")
+		}
+	}()
+	_, _ = p.Add(context.Background(), args)
+}
+
+func crashArgs(dataDir, netns string) *skel.CmdArgs {
+	return &skel.CmdArgs{
+		ContainerID: "crashed",
+		Netns:       netns,
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipBatch":true,
+			"ipMasq":true,
+			"verifyConnectivity":true,
+			"ipam":{"dataDir":%q}
+		}`, dataDir)),
+	}
+}
+
+func TestAddRollsBackCrashedAddFromTxnLog(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: ipam.NewFileAllocator(), NetNS: netns, Log: io.Discard}
+	args := crashArgs(dataDir, podNS.Path())
+
+	crashAdd(t, p, args)
+	raw, ok, err := cache.LoadTxn(dataDir, "atomic-net", "crashed", "eth0")
+	if err != nil || !ok {
+		t.Fatalf("transaction log after crash: ok=%v err=%v", ok, err)
+	}
+	var txn addTxn
+	if err := json.Unmarshal(raw, &txn); err != nil {
+		t.Fatal(err)
+	}
+	if got := txn.interrupted(); got != "verify-connectivity" {
+		t.Fatalf("interrupted step = %q", got)
+	}
+	if len(netOps.masq) != 1 {
+		t.Fatalf("masquerade before retry = %v", netOps.masq)
+	}
+
+	netOps.calls = nil
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("retried Add() error = %v", err)
+	}
+	clear, set := slices.Index(netOps.calls, "ClearMasquerade"), slices.Index(netOps.calls, "SetMasquerade")
+	if clear < 0 || clear > set {
+		t.Fatalf("retry did not undo the crashed ADD first: %v", netOps.calls)
+	}
+	if _, ok, _ := cache.LoadTxn(dataDir, "atomic-net", "crashed", "eth0"); ok {
+		t.Fatal("transaction log left after a successful ADD")
+	}
+	allocations, err := p.IPAM.List(context.Background(), dataDir, "atomic-net")
+	if err != nil || len(allocations) != 1 || allocations["crashed"] == nil {
+		t.Fatalf("allocations after retry = %v %v", allocations, err)
+	}
+}
+
+func TestRollbackPolicyKeepsState(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	for _, tc := range []struct {
+		policy      string
+		deleteLinks bool
+	}{
+		{policy: "keep-allocation", deleteLinks: true},
+		{policy: "keep-links", deleteLinks: false},
+	} {
+		dataDir := t.TempDir()
+		netOps := &mockNetOps{}
+		alloc := &mockAllocator{}
+		p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns}
+		args := &skel.CmdArgs{
+			ContainerID: "kept",
+			Netns:       podNS.Path(),
+			IfName:      "eth0",
+			StdinData: []byte(fmt.Sprintf(`{
+				"cniVersion":"1.1.0",
+				"name":"atomic-net",
+				"type":"atomicni",
+				"bridge":"atomic0",
+				"subnet":"10.22.0.0/24",
+				"gateway":"10.22.0.1",
+				"rollback":{"policy":%q},
+				"ipam":{"dataDir":%q}
+			}`, tc.policy, dataDir)),
+		}
+
+		// The mock fails configuring the address, after allocation.
+		if _, err := p.Add(context.Background(), args); err == nil {
+			t.Fatalf("%s: expected Add() failure", tc.policy)
+		}
+		if slices.Contains(alloc.calls, "Release") {
+			t.Fatalf("%s: allocation released: %v", tc.policy, alloc.calls)
+		}
+		if got := slices.Contains(netOps.calls, "DeleteLink"); got != tc.deleteLinks {
+			t.Fatalf("%s: DeleteLink called = %t, calls: %v", tc.policy, got, netOps.calls)
+		}
+		a, ok, err := cache.Load(dataDir, "atomic-net", "kept", "eth0")
+		if err != nil || !ok {
+			t.Fatalf("%s: cache.Load() = %v, %v", tc.policy, ok, err)
+		}
+		if f := a.Failure; f == nil || f.Policy != tc.policy || f.Op != "configure-container-ip" || !f.RetryUntil.After(f.At) {
+			t.Fatalf("%s: failure record = %+v", tc.policy, a.Failure)
+		}
+	}
+}
+
+func TestVerifyConnectivityRollsBack(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{verifyErr: errors.New("gateway 10.22.0.1 did not answer ARP")}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "verified",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"verifyConnectivity":true,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+		}`, dataDir)),
+	}
+
+	_, err := p.Add(context.Background(), args)
+	if err == nil || Stage(err) != "datapath" {
+		t.Fatalf("Add() error = %v (stage %q), want datapath failure", err, Stage(err))
+	}
+	if !slices.Contains(netOps.calls, "DeleteLink") {
+		t.Fatalf("failed verification did not roll back the veth, calls: %v", netOps.calls)
+	}
+	if _, ok, _ := cache.Load(dataDir, "atomic-net", "verified", "eth0"); ok {
+		t.Fatalf("failed verification left a cached attachment")
+	}
+
+	netOps.verifyErr = nil
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if _, ok, _ := cache.Load(dataDir, "atomic-net", "verified", "eth0"); !ok {
+		t.Fatalf("verified ADD did not cache its attachment")
+	}
+}
+
+func TestFirewalldTrustsInterfaces(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	stdin := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"mode":%q,
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"firewalld":{"zone":"pods"},
+		"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.30/24"}]}
+	}`
+
+	netOps := &mockNetOps{firewalld: map[string]string{}}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	bridgeArgs := &skel.CmdArgs{ContainerID: "br-pod", Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(fmt.Sprintf(stdin, "bridge", t.TempDir()))}
+	if _, err := p.Add(context.Background(), bridgeArgs); err != nil {
+		t.Fatalf("Add(bridge) error = %v", err)
+	}
+	if netOps.firewalld["atomic0"] != "pods" {
+		t.Fatalf("bridge not bound to zone: %v", netOps.firewalld)
+	}
+
+	ptpArgs := &skel.CmdArgs{ContainerID: "ptp-pod", Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(fmt.Sprintf(stdin, "ptp", t.TempDir()))}
+	if _, err := p.Add(context.Background(), ptpArgs); err != nil {
+		t.Fatalf("Add(ptp) error = %v", err)
+	}
+	veth := HostVethName("ptp-pod")
+	if netOps.firewalld[veth] != "pods" {
+		t.Fatalf("ptp host veth not bound to zone: %v", netOps.firewalld)
+	}
+	if err := p.Del(context.Background(), ptpArgs); err != nil {
+		t.Fatalf("Del(ptp) error = %v", err)
+	}
+	if _, ok := netOps.firewalld[veth]; ok {
+		t.Fatal("Del() left the ptp host veth in the zone")
+	}
+}
+
+func TestCheckDetectsAndReappliesFlushedRules(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "flushed",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"connLimit":{"perSecond":20},
+			"ipMasq":true,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.40/24"}]}
+		}`, dataDir)),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	attachment, ok, err := cache.Load(dataDir, "atomic-net", "flushed", "eth0")
+	if err != nil || !ok {
+		t.Fatalf("cache.Load() = %v, %v", ok, err)
+	}
+	if len(attachment.Rules) != 2 || attachment.Rules[0].Fingerprint == "" {
+		t.Fatalf("expected two fingerprinted rules, got %+v", attachment.Rules)
+	}
+
+	// Simulate an external flush of the connection limit.
+	delete(netOps.connLimits, attachment.Key())
+	err = p.Check(context.Background(), args)
+	if Stage(err) != "firewall" || !strings.Contains(err.Error(), netops.RuleConnLimit) {
+		t.Fatalf("Check() error = %v, want missing connlimit rule", err)
+	}
+
+	report, err := p.ReconcileRules(context.Background(), dataDir, false)
+	if err != nil {
+		t.Fatalf("ReconcileRules() error = %v", err)
+	}
+	if report.Checked != 2 || len(report.Missing) != 1 || len(report.Reapplied) != 0 {
+		t.Fatalf("dry run report = %+v", report)
+	}
+	if _, ok := netOps.connLimits[attachment.Key()]; ok {
+		t.Fatalf("dry run re-installed the limit")
+	}
+
+	report, err = p.ReconcileRules(context.Background(), dataDir, true)
+	if err != nil {
+		t.Fatalf("ReconcileRules(reapply) error = %v", err)
+	}
+	if len(report.Reapplied) != 1 {
+		t.Fatalf("reapply report = %+v", report)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() after reapply error = %v", err)
+	}
+
+	// Simulate a hand edit of the masquerade rule: still present, different body.
+	masq := netOps.masq[attachment.Key()]
+	masq.Source = net.ParseIP("10.22.0.99")
+	netOps.masq[attachment.Key()] = masq
+	err = p.Check(context.Background(), args)
+	if Stage(err) != "firewall" || !strings.Contains(err.Error(), "(changed)") {
+		t.Fatalf("Check() error = %v, want changed masquerade rule", err)
+	}
+	report, err = p.ReconcileRules(context.Background(), dataDir, true)
+	if err != nil {
+		t.Fatalf("ReconcileRules(reapply) error = %v", err)
+	}
+	if len(report.Missing) != 0 || len(report.Changed) != 1 || len(report.Reapplied) != 1 {
+		t.Fatalf("changed rule report = %+v", report)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() after reapplying changed rule error = %v", err)
+	}
+}
+
+func TestConntrackZoneLifecycle(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "zoned",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"conntrackZones":true,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.50/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	key := cache.Key("atomic-net", "zoned", "eth0")
+	zone, ok := netOps.ctZones[key]
+	if !ok || zone.Zone != ConntrackZone(key) || zone.HostLink != HostVethName("zoned") || zone.PodLinkPrefix != HostVethPrefix || zone.PodIP.String() != "10.22.0.50" {
+		t.Fatalf("unexpected zone assignment: %+v", netOps.ctZones)
+	}
+	if zone.Zone < 1 || zone.Zone > 65535 {
+		t.Fatalf("zone %d out of range", zone.Zone)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if len(netOps.ctZones) != 0 || !slices.Equal(netOps.flushedZones, []int{zone.Zone}) {
+		t.Fatalf("Del() left zone state: rules=%v flushed=%v", netOps.ctZones, netOps.flushedZones)
+	}
+}
+
+func TestStaticNeighborLifecycle(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "lab",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"staticNeighbors":{"gateway":true,"pod":true},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.60/24","gateway":"10.22.0.1"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	want := map[string]string{
+		"netns/eth0 10.22.0.1": "aa:bb:cc:dd:ee:ff",
+		"atomic0 10.22.0.60":   "11:22:33:44:55:66",
+	}
+	if fmt.Sprint(netOps.neighbors) != fmt.Sprint(want) {
+		t.Fatalf("neighbors = %v, want %v", netOps.neighbors, want)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if _, ok := netOps.neighbors["atomic0 10.22.0.60"]; ok {
+		t.Fatalf("Del() left the bridge entry: %v", netOps.neighbors)
+	}
+}
+
+func TestJumboMTUPath(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	hostVeth := HostVethName("jumbo")
+	netOps := &mockNetOps{mtus: map[string]int{hostVeth: 9000}}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "jumbo",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"mtu":9000,
+			"qinq":{"master":"eth1","sVlan":100,"cVlan":10},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.70/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	var raised []string
+	for _, c := range netOps.calls {
+		if strings.HasPrefix(c, "SetLinkMTU") {
+			raised = append(raised, c)
+		}
+	}
+	want := []string{"SetLinkMTU eth1 9000", "SetLinkMTU eth1.100 9000", "SetLinkMTU eth1.100.10 9000", "SetLinkMTU atomic0 9000"}
+	if !slices.Equal(raised, want) {
+		t.Fatalf("MTU changes = %v, want %v", raised, want)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	// Something outside AtomicNI lowers the uplink.
+	netOps.mtus["eth1.100.10"] = 1500
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "check-mtu") {
+		t.Fatalf("Check() error = %v, want MTU mismatch", err)
+	}
+
+	netOps.maxMTUs = map[string]int{"eth1": 1500}
+	args.ContainerID = "jumbo2"
+	var opErr *OpError
+	if _, err := p.Add(context.Background(), args); !errors.As(err, &opErr) || opErr.Op != "ensure-mtu" {
+		t.Fatalf("Add() error = %v, want ensure-mtu failure", err)
+	}
+}
+
+func TestCheckDetectsReplacedContainerLink(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "replaced",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.80/24"}]}
+		}`, dataDir)),
+	}
+
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	attachment, _, err := cache.Load(dataDir, "atomic-net", "replaced", "eth0")
+	if err != nil || attachment.ContainerMAC != "11:22:33:44:55:66" || attachment.HostMAC != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("cached MACs = %q/%q (%v)", attachment.HostMAC, attachment.ContainerMAC, err)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	netOps.containerMAC = "02:00:00:00:00:01"
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "check-container-link") {
+		t.Fatalf("Check() error = %v, want container link mismatch", err)
+	}
+}
+
+func TestCheckToleratesInterfaceTakenBySandbox(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "kata",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"defaultRouteMetric":50,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.81/24"}]}
+		}`, dataDir)),
+	}
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	// The shim moved the interface and its routes into the guest.
+	netOps.containerGone = true
+	netOps.defaultRoute = &netops.RouteOutcome{}
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "check-container-link") {
+		t.Fatalf("Check() without sandbox error = %v, want check-container-link", err)
+	}
+	args.Args = "SANDBOX=kata"
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() under kata error = %v", err)
+	}
+	args.Args = "SANDBOX=firecracker"
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "SANDBOX") {
+		t.Fatalf("Check() with unknown sandbox error = %v", err)
+	}
+}
+
+func TestCheckVerifiesShapers(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	var log bytes.Buffer
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, Log: &log, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "shaped",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"logLevel":"info",
+			"runtimeConfig":{"bandwidth":{"ingressRate":8000000,"ingressBurst":80000,"egressRate":4000000,"egressBurst":40000}},
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.80/24"}]}
+		}`, t.TempDir())),
+	}
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	hostVeth := HostVethNameFor("shaped", "eth0")
+	ifb := bandwidthIfbName("atomic-net", "shaped")
+	if len(ifb) != 15 || !strings.HasPrefix(ifb, "bwp") {
+		t.Fatalf("bandwidthIfbName() = %q", ifb)
+	}
+	netOps.shapers = map[string]netops.Shaper{
+		hostVeth: {Rate: 1000000, Bytes: 4096, Packets: 3},
+		ifb:      {Rate: 500000},
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if !strings.Contains(log.String(), "ingress shaper on "+hostVeth+": rate=8000000bit/s bytes=4096 packets=3") {
+		t.Fatalf("shaper counters not logged:\n%s", log.String())
+	}
+
+	netOps.shapers[ifb] = netops.Shaper{Rate: 250000}
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "egress shaper") {
+		t.Fatalf("Check() error = %v, want egress rate mismatch", err)
+	}
+	delete(netOps.shapers, hostVeth)
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "no ingress shaper") {
+		t.Fatalf("Check() error = %v, want missing ingress shaper", err)
+	}
+}
+
+func TestAddAttachesMultipleNetworks(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	stdin := func(second string) []byte {
+		return []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"lab",
+			"type":"atomicni",
+			"networks":[
+				{"name":"lab-a","bridge":"br-a","subnet":"10.10.0.0/24","gateway":"10.10.0.1",
+				 "ipam":{"type":"static","dataDir":%[1]q,"addresses":[{"address":"10.10.0.5/24"}]}},
+				{"name":"lab-b","bridge":"br-b","subnet":"10.20.0.0/24","gateway":"10.20.0.1",
+				 "ipam":%[2]s}
+			]
+		}`, dataDir, fmt.Sprintf(second, dataDir)))
+	}
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: ipam.NewFileAllocator(), NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "vnf",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   stdin(`{"type":"static","dataDir":%q,"addresses":[{"address":"10.20.0.5/24"}]}`),
+	}
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if len(res.Interfaces) != 4 || res.Interfaces[1].Name != "eth0" || res.Interfaces[3].Name != "eth1" {
+		t.Fatalf("unexpected interfaces: %+v", res.Interfaces)
+	}
+	if len(res.IPs) != 2 || *res.IPs[0].Interface != 1 || *res.IPs[1].Interface != 3 || res.IPs[1].Address.IP.String() != "10.20.0.5" {
+		t.Fatalf("unexpected IPs: %+v", res.IPs)
+	}
+	defaults := 0
+	for _, r := range res.Routes {
+		if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+			defaults++
+		}
+	}
+	if defaults != 1 {
+		t.Fatalf("expected one default route, got %d", defaults)
+	}
+	if res.Interfaces[0].Name != HostVethNameFor("vnf", "eth0") || res.Interfaces[2].Name != HostVethNameFor("vnf", "eth1") || res.Interfaces[0].Name == res.Interfaces[2].Name {
+		t.Fatalf("host veths not distinct: %s %s", res.Interfaces[0].Name, res.Interfaces[2].Name)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if len(netOps.links) != 0 {
+		t.Fatalf("Del() left links: %+v", netOps.links)
+	}
+
+	// The second network fails to configure its address, so the first is
+	// detached again.
+	args.StdinData = stdin(`{"dataDir":%q}`)
+	if _, err := p.Add(context.Background(), args); err == nil || !strings.Contains(err.Error(), "network lab-b") {
+		t.Fatalf("expected lab-b failure, got %v", err)
+	}
+	if len(netOps.links) != 0 {
+		t.Fatalf("failed Add() left links: %+v", netOps.links)
+	}
+	if attachments, _ := cache.List(dataDir); len(attachments) != 0 {
+		t.Fatalf("failed Add() left attachments: %+v", attachments)
+	}
+}
+
+func TestSecondaryInterfaces(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	alloc := ipam.NewFileAllocator()
+	p := &Plugin{NetOps: netOps, IPAM: alloc, NetNS: netns}
+	stdin := []byte(fmt.Sprintf(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"secondary":true,
+		"ipam":{"dataDir":%q}
+	}`, dataDir))
+
+	ips := map[string]string{}
+	for _, ifName := range []string{"net1", "net2"} {
+		args := &skel.CmdArgs{ContainerID: "multus", Netns: podNS.Path(), IfName: ifName, StdinData: stdin}
+		res, err := p.Add(context.Background(), args)
+		if err != nil {
+			t.Fatalf("Add(%s) error = %v", ifName, err)
+		}
+		if len(res.Routes) != 0 {
+			t.Fatalf("%s: secondary interface got routes %+v", ifName, res.Routes)
+		}
+		if res.Interfaces[0].Name != HostVethNameFor("multus", ifName) || res.Interfaces[1].Name != ifName {
+			t.Fatalf("%s: unexpected interfaces %+v", ifName, res.Interfaces)
+		}
+		ips[ifName] = res.IPs[0].Address.IP.String()
+	}
+	if ips["net1"] == ips["net2"] {
+		t.Fatalf("net1 and net2 share %s", ips["net1"])
+	}
+	if slices.Contains(netOps.calls, "AddAddressAndRoute") {
+		t.Fatalf("secondary interface installed a default route: %v", netOps.calls)
+	}
+
+	// net2's attachment record is lost; GC reclaims its address and veth only.
+	if err := cache.Delete(dataDir, "atomic-net", "multus", "net2"); err != nil {
+		t.Fatalf("cache.Delete: %v", err)
+	}
+	report, err := p.GC(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if !slices.Equal(report.Released, []string{"atomic-net/multus/net2"}) {
+		t.Fatalf("unexpected GC release: %+v", report)
+	}
+
+	args := &skel.CmdArgs{ContainerID: "multus", Netns: podNS.Path(), IfName: "net1", StdinData: stdin}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	left, err := alloc.List(context.Background(), dataDir, "atomic-net")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(left) != 0 || len(netOps.links) != 0 {
+		t.Fatalf("leftovers after Del: allocations=%v links=%v", left, netOps.links)
+	}
+}
+
+func TestRouteProtoLifecycle(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{verifyErr: errors.New("gateway did not answer")}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "announced",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData: []byte(fmt.Sprintf(`{
+			"cniVersion":"1.1.0",
+			"name":"atomic-net",
+			"type":"atomicni",
+			"bridge":"atomic0",
+			"subnet":"10.22.0.0/24",
+			"gateway":"10.22.0.1",
+			"routeProto":201,
+			"verifyConnectivity":true,
+			"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.40/24"}]}
+		}`, t.TempDir())),
+	}
+
+	if _, err := p.Add(context.Background(), args); err == nil {
+		t.Fatalf("Add() succeeded despite failed verification")
+	}
+	for _, want := range []string{"AddProtoRoute 10.22.0.40/32 atomic0 201", "DeleteProtoRoute 10.22.0.40/32 201"} {
+		if !slices.Contains(netOps.calls, want) {
+			t.Fatalf("missing %q after rollback, calls: %v", want, netOps.calls)
+		}
+	}
+
+	netOps.verifyErr = nil
+	netOps.calls = nil
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if slices.Contains(netOps.calls, "DeleteProtoRoute 10.22.0.40/32 201") {
+		t.Fatalf("successful ADD removed its route, calls: %v", netOps.calls)
+	}
+	if err := p.Del(context.Background(), args); err != nil {
+		t.Fatalf("Del() error = %v", err)
+	}
+	if !slices.Contains(netOps.calls, "DeleteProtoRoute 10.22.0.40/32 201") {
+		t.Fatalf("DEL did not remove the proto route, calls: %v", netOps.calls)
+	}
+}
+
+func TestCheckRunsFeatureValidators(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	dataDir := t.TempDir()
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	conf := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"podSet":true,
+		%s
+		"ipam":{"type":"static","dataDir":%q,"addresses":[{"address":"10.22.0.50/24"}]}
+	}`
+	args := &skel.CmdArgs{
+		ContainerID: "checked",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   []byte(fmt.Sprintf(conf, `"routeProto":201,`, dataDir)),
+	}
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	netOps.protoRouteGone = true
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "check-host-route") {
+		t.Fatalf("Check() error = %v, want check-host-route failure", err)
+	}
+	// Without routeProto the route validator does not apply.
+	args.StdinData = []byte(fmt.Sprintf(conf, "", dataDir))
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() without routeProto error = %v", err)
+	}
+
+	netOps.podSets["atomic-net"] = nil
+	if err := p.Check(context.Background(), args); err == nil || !strings.Contains(err.Error(), "check-pod-set") || Stage(err) != "firewall" {
+		t.Fatalf("Check() error = %v, want check-pod-set failure", err)
+	}
+}
+
+func TestExecPolicyRefusesAndAudits(t *testing.T) {
+	var buf bytes.Buffer
+	log := &opLog{w: &buf, close: func() {}, level: 0}
+
+	restore := applyExecPolicy([]byte(`{"exec":{"allow":["tc"]}}`), log)
+	err := netops.NewNetlinkOps().SetLinkMTU("nope0", 1400)
+	restore()
+	if !errors.Is(err, netops.ErrExecDenied) {
+		t.Fatalf("SetLinkMTU() error = %v, want ErrExecDenied", err)
+	}
+	if !strings.Contains(buf.String(), "error exec refused: ip link set dev nope0 mtu 1400") {
+		t.Fatalf("log = %q, want refused command", buf.String())
+	}
+
+	buf.Reset()
+	restore = applyExecPolicy([]byte(`{"exec":{"allow":["ip"],"audit":true}}`), log)
+	_ = netops.NewNetlinkOps().SetLinkMTU("nope0", 1400)
+	restore()
+	if !strings.Contains(buf.String(), "audit exec: ip link set dev nope0 mtu 1400") {
+		t.Fatalf("log = %q, want audited command", buf.String())
+	}
+}
+
+func TestAdmissionSlotsLimitConcurrency(t *testing.T) {
+	dir := t.TempDir()
+	release, err := takeSlot(context.Background(), dir, 1)
+	if err != nil {
+		t.Fatalf("takeSlot() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+	if _, err := takeSlot(ctx, dir, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second takeSlot() error = %v, want deadline exceeded", err)
+	}
+	release()
+	again, err := takeSlot(context.Background(), dir, 1)
+	if err != nil {
+		t.Fatalf("takeSlot() after release error = %v", err)
+	}
+	again()
+}
+
+func TestAdmissionTokenBucket(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		if wait, err := tryToken(dir, 1, 2); err != nil || wait != 0 {
+			t.Fatalf("token %d: wait=%v err=%v, want immediate", i, wait, err)
+		}
+	}
+	if wait, err := tryToken(dir, 1, 2); err != nil || wait <= 0 || wait > time.Second {
+		t.Fatalf("empty bucket: wait=%v err=%v, want up to 1s", wait, err)
+	}
+}
+
+func TestAddFailsWhenAdmissionQueueTimesOut(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataDir, AdmissionDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	release, err := takeSlot(context.Background(), filepath.Join(dataDir, AdmissionDir), 1)
+	if err != nil {
+		t.Fatalf("takeSlot() error = %v", err)
+	}
+	defer release()
+
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: &mockAllocator{}}
+	stdin := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"cni0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":%q},"admission":{"maxConcurrent":1,"maxWait":1}}`, dataDir)
+	_, err = p.Add(context.Background(), &skel.CmdArgs{ContainerID: "c1", Netns: "/proc/self/ns/net", IfName: "eth0", StdinData: []byte(stdin)})
+	if !errors.Is(err, ErrAdmissionTimeout) || Stage(err) != "admission" {
+		t.Fatalf("Add() error = %v (stage %q), want admission timeout", err, Stage(err))
+	}
+}
+
+func TestWarnUtilizationFiresOncePerCrossing(t *testing.T) {
+	dataDir := t.TempDir()
+	stdin := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"cni0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":%q,"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.13","exhaustionWarning":{"threshold":50}}}`, dataDir)
+	cfg, err := config.Parse([]byte(stdin))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	alloc := ipam.NewFileAllocator()
+	var logs bytes.Buffer
+	sink := &mockSink{}
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: alloc, Events: sink, Log: &logs}
+	req := ipam.AllocationRequest{DataDir: dataDir, Network: cfg.Name, Subnet: cfg.SubnetNet, Gateway: cfg.GatewayIP, RangeStart: cfg.RangeStartIP, RangeEnd: cfg.RangeEndIP}
+	add := func(id string) {
+		t.Helper()
+		req.ContainerID = id
+		if _, err := alloc.Allocate(context.Background(), req); err != nil {
+			t.Fatalf("Allocate(%s): %v", id, err)
+		}
+		p.warnUtilization(context.Background(), cfg, &skel.CmdArgs{ContainerID: id, IfName: "eth0", StdinData: []byte(stdin)})
+	}
+
+	add("c1")
+	if len(sink.events) != 0 {
+		t.Fatalf("warned at 25%%: %+v", sink.events)
+	}
+	add("c2")
+	add("c3")
+	if len(sink.events) != 1 || sink.events[0].Type != events.IPPoolNearlyFull || sink.events[0].ContainerID != "c2" {
+		t.Fatalf("want one warning from c2, got %+v", sink.events)
+	}
+	if !strings.Contains(logs.String(), "50% allocated (2 of 4 addresses)") {
+		t.Fatalf("missing warning log:\n%s", logs.String())
+	}
+	snap, err := metrics.Load(dataDir)
+	if err != nil || snap.UtilizationWarnings["atomic-net"] == nil || snap.UtilizationWarnings["atomic-net"].Count != 1 {
+		t.Fatalf("metrics = %+v, %v", snap, err)
+	}
+
+	// Dropping below the threshold re-arms the warning.
+	for _, id := range []string{"c2", "c3"} {
+		if err := alloc.Release(context.Background(), dataDir, cfg.Name, id); err != nil {
+			t.Fatalf("Release(%s): %v", id, err)
+		}
+		p.rearmUtilization(context.Background(), cfg)
+	}
+	add("c4")
+	if len(sink.events) != 2 || sink.events[1].ContainerID != "c4" {
+		t.Fatalf("want a second warning from c4, got %+v", sink.events)
+	}
+}
+
+func TestWarnUtilizationLeavesNodeEventToAtomicnid(t *testing.T) {
+	if !buildinfo.K8s {
+		t.Skip("built with atomicni_nok8s")
+	}
+	dataDir := t.TempDir()
+	stdin := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"cni0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":%q,"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.11","exhaustionWarning":{"threshold":50,"kubeconfig":"/etc/kubernetes/kubelet.conf","nodeName":"node-1"}}}`, dataDir)
+	cfg, err := config.Parse([]byte(stdin))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	// No kubectl may run on the ADD path.
+	defer netops.SetExecPolicy(netops.ExecPolicy{Allow: []string{}})()
+	p := &Plugin{NetOps: &mockNetOps{}, IPAM: ipam.NewFileAllocator(), Log: io.Discard}
+	allocateForTest(t, p.IPAM, dataDir, "c1")
+	p.warnUtilization(context.Background(), cfg, &skel.CmdArgs{ContainerID: "c1", IfName: "eth0", StdinData: []byte(stdin)})
+
+	pending, err := PendingNodeWarnings(dataDir)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("PendingNodeWarnings() = %+v, %v", pending, err)
+	}
+	w := pending[0]
+	if w.Network != "atomic-net" || w.NodeName != "node-1" || w.Kubeconfig != "/etc/kubernetes/kubelet.conf" || !strings.Contains(w.Message, "50% allocated") {
+		t.Fatalf("unexpected pending warning %+v", w)
+	}
+	if err := MarkNodeWarningPosted(dataDir, w); err != nil {
+		t.Fatalf("MarkNodeWarningPosted() error = %v", err)
+	}
+	if pending, err := PendingNodeWarnings(dataDir); err != nil || len(pending) != 0 {
+		t.Fatalf("posted warning still pending: %+v, %v", pending, err)
+	}
+}
+
+func TestCheckOverlapRefusesOverlappingSubnets(t *testing.T) {
+	dataDir := t.TempDir()
+	parse := func(name, bridge, subnet, gateway string, allow bool) *config.NetworkConfig {
+		t.Helper()
+		stdin := fmt.Sprintf(`{"cniVersion":"1.1.0","name":%q,"type":"atomicni","bridge":%q,"subnet":%q,"gateway":%q,"ipam":{"dataDir":%q},"allowOverlap":%t}`, name, bridge, subnet, gateway, dataDir, allow)
+		cfg, err := config.Parse([]byte(stdin))
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		return cfg
+	}
+	ops := &mockNetOps{hostAddrs: []netops.HostAddress{
+		{Link: "atomic0", Addr: &net.IPNet{IP: net.ParseIP("10.22.0.1").To4(), Mask: net.CIDRMask(24, 32)}},
+		{Link: "eth0", Addr: &net.IPNet{IP: net.ParseIP("192.168.1.5").To4(), Mask: net.CIDRMask(24, 32)}},
+	}}
+	p := &Plugin{NetOps: ops, IPAM: ipam.NewFileAllocator()}
+
+	// The network's own bridge address is not a conflict.
+	first := parse("atomic-net", "atomic0", "10.22.0.0/24", "10.22.0.1", false)
+	if err := p.checkOverlap(first); err != nil {
+		t.Fatalf("checkOverlap(first) error = %v", err)
+	}
+	allocateForTest(t, p.IPAM, dataDir, "c1")
+
+	err := p.checkOverlap(parse("lab", "lab0", "10.22.0.128/25", "10.22.0.129", false))
+	if err == nil || !strings.Contains(err.Error(), `network "atomic-net" (10.22.0.0/24)`) || !strings.Contains(err.Error(), "host link atomic0") {
+		t.Fatalf("checkOverlap(lab) error = %v, want network and host link conflicts", err)
+	}
+	err = p.checkOverlap(parse("home", "home0", "192.168.0.0/16", "192.168.0.1", false))
+	if err == nil || !strings.Contains(err.Error(), "host link eth0 (192.168.1.0/24)") {
+		t.Fatalf("checkOverlap(home) error = %v, want host link conflict", err)
+	}
+	if err := p.checkOverlap(parse("lab", "lab0", "10.22.0.128/25", "10.22.0.129", true)); err != nil {
+		t.Fatalf("checkOverlap(allowOverlap) error = %v", err)
+	}
+
+	// A network without allocation state no longer counts.
+	if err := os.Remove(filepath.Join(dataDir, "atomic-net.json")); err != nil {
+		t.Fatal(err)
+	}
+	ops.hostAddrs = nil
+	if err := p.checkOverlap(parse("other", "other0", "10.22.0.0/24", "10.22.0.1", false)); err != nil {
+		t.Fatalf("checkOverlap(other) error = %v", err)
+	}
+}
+
+func TestAddResultReflectsDefaultRouteDecision(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	for _, tc := range []struct {
+		name      string
+		outcome   netops.RouteOutcome
+		wantRoute bool
+		wantPrio  int
+	}{
+		{name: "kept existing", outcome: netops.RouteOutcome{}},
+		{name: "added with metric", outcome: netops.RouteOutcome{Installed: true, Metric: 101}, wantRoute: true, wantPrio: 101},
+		{name: "replaced", outcome: netops.RouteOutcome{Installed: true, Replaced: []string{"default via 10.0.0.1 dev net1 metric 0"}}, wantRoute: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			netOps := &mockNetOps{defaultRoute: &tc.outcome}
+			p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+			stdin := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipBatch":true,"defaultRouteConflict":"add-with-metric","ipam":{"dataDir":%q}}`, t.TempDir())
+			res, err := p.Add(context.Background(), &skel.CmdArgs{ContainerID: "c1", Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(stdin)})
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			if !tc.wantRoute {
+				if len(res.Routes) != 0 {
+					t.Fatalf("routes = %v, want the default route left out", res.Routes)
+				}
+				return
+			}
+			if len(res.Routes) != 1 || res.Routes[0].Priority != tc.wantPrio {
+				t.Fatalf("routes = %v, want one default route with priority %d", res.Routes, tc.wantPrio)
+			}
+		})
+	}
+}
+
+func TestDefaultRouteMetricLifecycle(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "ranked",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   []byte(fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipBatch":true,"defaultRouteMetric":200,"ipam":{"dataDir":%q}}`, t.TempDir())),
+	}
+	res, err := p.Add(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if len(res.Routes) != 1 || res.Routes[0].Priority != 200 {
+		t.Fatalf("routes = %v, want the default route with priority 200", res.Routes)
+	}
+	if err := p.Check(context.Background(), args); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	netOps.defaultRoute.Metric = 0
+	if err := p.Check(context.Background(), args); Stage(err) != "route" || !strings.Contains(err.Error(), "metric 0, want 200") {
+		t.Fatalf("Check() with drifted metric = %v, want route failure", err)
+	}
+	netOps.defaultRoute.Installed = false
+	if err := p.Check(context.Background(), args); Stage(err) != "route" || !strings.Contains(err.Error(), "no default route") {
+		t.Fatalf("Check() without the route = %v, want route failure", err)
+	}
+}
+
+func TestAddInstallsECMPDefaultRoute(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	netOps := &mockNetOps{}
+	p := &Plugin{NetOps: netOps, IPAM: &mockAllocator{}, NetNS: netns}
+	stdin := fmt.Sprintf(`{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipBatch":true,"ecmpGateways":["10.22.0.1","10.22.0.254"],"ipam":{"dataDir":%q,"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.200"}}`, t.TempDir())
+	res, err := p.Add(context.Background(), &skel.CmdArgs{ContainerID: "c1", Netns: podNS.Path(), IfName: "eth0", StdinData: []byte(stdin)})
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if len(netOps.routes) != 1 || len(netOps.routes[0].Nexthops) != 2 || !netOps.routes[0].Nexthops[1].Equal(net.ParseIP("10.22.0.254")) {
+		t.Fatalf("routes installed = %+v, want one multipath route over both gateways", netOps.routes)
+	}
+	var gws []string
+	for _, r := range res.Routes {
+		gws = append(gws, r.GW.String())
+	}
+	if !slices.Equal(gws, []string{"10.22.0.1", "10.22.0.254"}) {
+		t.Fatalf("result route gateways = %v, want one default route per next hop", gws)
+	}
+}
+
+func TestCheckpointRestoresAttachmentOnAnotherNode(t *testing.T) {
+	netns := netnsutil.NewFake()
+	podNS := netns.Add("/var/run/netns/test")
+
+	stdin := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipBatch":true,
+		"ipam":{"dataDir":%q}
+	}`
+	source := &mockNetOps{podLink: netops.PodLink{
+		MAC:   "02:aa:bb:cc:dd:ee",
+		MTU:   1500,
+		Addrs: []string{"10.22.0.2/24"},
+		Routes: []netops.PodLinkRoute{
+			{Dst: "default", Gateway: "10.22.0.1"},
+			{Dst: "10.22.0.0/24"},
+			{Dst: "192.168.7.0/24", Gateway: "10.22.0.254"},
+		},
+	}}
+	p := &Plugin{NetOps: source, IPAM: ipam.NewFileAllocator(), NetNS: netns}
+	args := &skel.CmdArgs{
+		ContainerID: "before",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   []byte(fmt.Sprintf(stdin, t.TempDir())),
+	}
+	if _, err := p.Add(context.Background(), args); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	blob, err := p.Checkpoint(context.Background(), args)
+	if err != nil {
+		t.Fatalf("Checkpoint() error = %v", err)
+	}
+
+	// The other node already handed out .2 once; the restore must still get it.
+	dest := &mockNetOps{}
+	target := &Plugin{NetOps: dest, IPAM: ipam.NewFileAllocator(), NetNS: netns}
+	restoreArgs := &skel.CmdArgs{
+		ContainerID: "after",
+		Netns:       podNS.Path(),
+		IfName:      "eth0",
+		StdinData:   []byte(fmt.Sprintf(stdin, t.TempDir())),
+	}
+	res, err := target.Restore(context.Background(), restoreArgs, blob)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if len(res.IPs) != 1 || res.IPs[0].Address.String() != "10.22.0.2/24" {
+		t.Fatalf("restored IPs = %v", res.IPs)
+	}
+	if !slices.Contains(dest.calls, "SetLinkMACInNS 02:aa:bb:cc:dd:ee") {
+		t.Fatalf("MAC not restored, calls: %v", dest.calls)
+	}
+	if !slices.Equal(dest.addedRoutes, []string{"192.168.7.0/24 via 10.22.0.254"}) {
+		t.Fatalf("restored routes = %v", dest.addedRoutes)
+	}
+
+	// A second restore finds the address taken and leaves nothing behind.
+	again := *restoreArgs
+	again.ContainerID = "again"
+	if _, err := target.Restore(context.Background(), &again, blob); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Fatalf("Restore() onto a taken address error = %v", err)
+	}
+	if _, ok, _ := target.IPAM.GetByContainer(context.Background(), "", "atomic-net", "again"); ok {
+		t.Fatal("failed restore kept its allocation")
+	}
+}
@@ -0,0 +1,21 @@
+package atomicni
+
+import (
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// portForwards converts parsed port ranges into NetOps forwards.
+func portForwards(ranges []config.PortRange) []netops.PortForward {
+	out := make([]netops.PortForward, 0, len(ranges))
+	for _, r := range ranges {
+		out = append(out, netops.PortForward{
+			Protocol:       r.Protocol,
+			HostIP:         r.HostIP,
+			HostStart:      r.HostStart,
+			HostEnd:        r.HostEnd,
+			ContainerStart: r.ContainerStart,
+		})
+	}
+	return out
+}
@@ -0,0 +1,51 @@
+package atomicni
+
+import (
+	"net"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// setupPTPHost gives the host veth the gateway as a /32 and enables proxy ARP,
+// so the pod resolves its gateway and on-subnet peers to the host end without
+// any bridge on the node.
+func (p *Plugin) setupPTPHost(hostVethName string, cfg *config.NetworkConfig) error {
+	gateway := &net.IPNet{IP: cloneIP(cfg.GatewayIP), Mask: net.CIDRMask(32, 32)}
+	if err := p.NetOps.AddAddressOnHostLink(hostVethName, gateway); err != nil {
+		return err
+	}
+	return p.NetOps.SetProxyARP(hostVethName, true)
+}
+
+// addHostRoutes routes every pod address as a /32 out of link. With
+// routeProto the routes carry that protocol number and are removed again by
+// rollback; otherwise they go away with the ptp host veth.
+func (p *Plugin) addHostRoutes(link string, podCIDR *net.IPNet, cfg *config.NetworkConfig, rollback *rollbackStack) error {
+	for _, ip := range podIPs(cfg, podCIDR) {
+		dst := &net.IPNet{IP: cloneIP(ip), Mask: net.CIDRMask(32, 32)}
+		if cfg.RouteProto == 0 {
+			if err := p.NetOps.AddHostRoute(dst, link); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.NetOps.AddProtoRoute(dst, link, cfg.RouteProto); err != nil {
+			return err
+		}
+		rollback.Push(rollbackStep{Op: "delete-host-route", Dst: dst.String(), Proto: cfg.RouteProto})
+	}
+	return nil
+}
+
+// podIPs returns every address of the pod interface: the static addresses,
+// or the allocated one.
+func podIPs(cfg *config.NetworkConfig, podCIDR *net.IPNet) []net.IP {
+	if cfg.IPAM.Type != config.IPAMTypeStatic {
+		return []net.IP{podCIDR.IP}
+	}
+	ips := make([]net.IP, 0, len(cfg.StaticAddrs))
+	for _, a := range cfg.StaticAddrs {
+		ips = append(ips, a.Addr.IP)
+	}
+	return ips
+}
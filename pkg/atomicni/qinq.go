@@ -0,0 +1,19 @@
+package atomicni
+
+import (
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// ensureQinQUplink builds master -> 802.1ad S-VLAN -> 802.1Q C-VLAN and
+// enslaves the inner link to the bridge. Like the bridge itself, the chain is
+// shared by every pod of the network and is never rolled back.
+func (p *Plugin) ensureQinQUplink(cfg *config.NetworkConfig) error {
+	q := cfg.QinQ
+	if err := p.NetOps.EnsureVLANLink(q.Master, q.OuterName, q.SVLAN, "802.1ad"); err != nil {
+		return err
+	}
+	if err := p.NetOps.EnsureVLANLink(q.OuterName, q.InnerName, q.CVLAN, "802.1Q"); err != nil {
+		return err
+	}
+	return p.NetOps.AttachHostVethToBridge(q.InnerName, cfg.Bridge)
+}
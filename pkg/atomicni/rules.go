@@ -0,0 +1,255 @@
+package atomicni
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// dscpSpec, connLimitSpec, and portMapSpec are the recorded parameters of a
+// host rule; the other kinds record the matching netops type (Masquerade,
+// CTZone, EgressGateway, DNSRedirect).
+type dscpSpec struct {
+	DSCP int `json:"dscp"`
+}
+
+type connLimitSpec struct {
+	PodIP     net.IP `json:"podIP"`
+	PerSecond int    `json:"perSecond"`
+	Burst     int    `json:"burst"`
+}
+
+type portMapSpec struct {
+	PodIP    net.IP               `json:"podIP"`
+	Forwards []netops.PortForward `json:"forwards"`
+}
+
+// newRule records a host rule of kind under key with its spec; installRule
+// fingerprints it once applied.
+func newRule(kind, key string, spec any) (cache.Rule, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return cache.Rule{}, fmt.Errorf("marshal %s rule: %w", kind, err)
+	}
+	return cache.Rule{Kind: kind, Key: key, Spec: raw}, nil
+}
+
+// ruleFingerprint hashes a rule's kind, key, and spec with the digest of its
+// installed host rule bodies. The spec is compacted first because the cache
+// re-indents it when saving.
+func ruleFingerprint(kind, key string, spec []byte, digest string) string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, spec); err != nil {
+		compact.Write(spec)
+	}
+	s := kind + "\x00" + key + "\x00" + compact.String() + "\x00" + digest
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:8])
+}
+
+// installRule applies rule, records it on the attachment with the digest of
+// what was installed, and registers its removal on rollback.
+func (p *Plugin) installRule(attachment *cache.Attachment, rollback *rollbackStack, kind, key string, spec any) error {
+	rule, err := newRule(kind, key, spec)
+	if err != nil {
+		return err
+	}
+	if err := p.applyRule(rule); err != nil {
+		return err
+	}
+	rollback.Push(rollbackStep{Op: "clear-rule", Rule: &rule})
+	digest, err := p.NetOps.RuleDigest(kind, key)
+	if err != nil {
+		return fmt.Errorf("digest %s rule: %w", kind, err)
+	}
+	rule.Fingerprint = ruleFingerprint(kind, key, rule.Spec, digest)
+	attachment.Rules = append(attachment.Rules, rule)
+	return nil
+}
+
+// applyRule (re)installs a recorded rule.
+func (p *Plugin) applyRule(rule cache.Rule) error {
+	switch rule.Kind {
+	case netops.RuleDSCP:
+		var spec dscpSpec
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode dscp rule: %w", err)
+		}
+		return p.NetOps.SetDSCP(rule.Key, spec.DSCP)
+	case netops.RuleConnLimit:
+		var spec connLimitSpec
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode connlimit rule: %w", err)
+		}
+		return p.NetOps.SetConnLimit(rule.Key, spec.PodIP, spec.PerSecond, spec.Burst)
+	case netops.RulePortMap:
+		var spec portMapSpec
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode portmap rule: %w", err)
+		}
+		return p.NetOps.SetPortMappings(rule.Key, spec.PodIP, spec.Forwards)
+	case netops.RuleMasquerade:
+		var spec netops.Masquerade
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode masquerade rule: %w", err)
+		}
+		return p.NetOps.SetMasquerade(rule.Key, spec)
+	case netops.RuleCTZone:
+		var spec netops.CTZone
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode ctzone rule: %w", err)
+		}
+		return p.NetOps.SetCTZone(rule.Key, spec)
+	case netops.RuleEgress:
+		var spec netops.EgressGateway
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode egress rule: %w", err)
+		}
+		return p.NetOps.SetEgressGateway(rule.Key, spec)
+	case netops.RuleDNS:
+		var spec netops.DNSRedirect
+		if err := json.Unmarshal(rule.Spec, &spec); err != nil {
+			return fmt.Errorf("decode dns rule: %w", err)
+		}
+		return p.NetOps.SetDNSRedirect(rule.Key, spec)
+	default:
+		return fmt.Errorf("unknown rule kind %q", rule.Kind)
+	}
+}
+
+// clearRule removes a recorded rule.
+func (p *Plugin) clearRule(rule cache.Rule) error {
+	switch rule.Kind {
+	case netops.RuleDSCP:
+		return p.NetOps.ClearDSCP(rule.Key)
+	case netops.RuleConnLimit:
+		return p.NetOps.ClearConnLimit(rule.Key)
+	case netops.RulePortMap:
+		return p.NetOps.ClearPortMappings(rule.Key)
+	case netops.RuleMasquerade:
+		return p.NetOps.ClearMasquerade(rule.Key)
+	case netops.RuleCTZone:
+		return p.NetOps.ClearCTZone(rule.Key)
+	case netops.RuleEgress:
+		return p.NetOps.ClearEgressGateway(rule.Key)
+	case netops.RuleDNS:
+		return p.NetOps.ClearDNSRedirect(rule.Key)
+	default:
+		return fmt.Errorf("unknown rule kind %q", rule.Kind)
+	}
+}
+
+// Rule drift: what is installed for a recorded rule, when it differs.
+const (
+	ruleMissing = "missing"
+	ruleChanged = "changed"
+)
+
+// ruleDrift compares a recorded rule with the host: "" when it is installed
+// as recorded, ruleMissing when nothing is installed, and ruleChanged when
+// the installed bodies no longer match the fingerprint.
+func (p *Plugin) ruleDrift(rule cache.Rule) (string, error) {
+	digest, err := p.NetOps.RuleDigest(rule.Kind, rule.Key)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case digest == "":
+		return ruleMissing, nil
+	case rule.Fingerprint != ruleFingerprint(rule.Kind, rule.Key, rule.Spec, digest):
+		return ruleChanged, nil
+	}
+	return "", nil
+}
+
+// driftedRule is a recorded rule and how the host differs from it.
+type driftedRule struct {
+	rule  cache.Rule
+	drift string
+}
+
+// driftedRules returns the recorded rules of an attachment that are no longer
+// installed on the host, or are installed with different bodies.
+func (p *Plugin) driftedRules(a *cache.Attachment) ([]driftedRule, error) {
+	var drifted []driftedRule
+	for _, rule := range a.Rules {
+		drift, err := p.ruleDrift(rule)
+		if err != nil {
+			return nil, err
+		}
+		if drift != "" {
+			drifted = append(drifted, driftedRule{rule: rule, drift: drift})
+		}
+	}
+	return drifted, nil
+}
+
+// RulesReport summarizes one host rule reconciliation pass.
+type RulesReport struct {
+	Checked   int
+	Missing   []string
+	Changed   []string
+	Reapplied []string
+}
+
+// ReconcileRules compares every cached attachment's recorded host rules with
+// what is installed, reporting rules flushed or edited by external tooling
+// (for example an iptables restart) and re-applying them when reapply is set.
+func (p *Plugin) ReconcileRules(_ context.Context, dataDir string, reapply bool) (*RulesReport, error) {
+	if p.NetOps == nil {
+		return nil, errors.New("plugin has nil NetOps")
+	}
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	links, err := p.NetOps.ListOwnedLinks("", p.names().HostPrefix())
+	if err != nil {
+		return nil, err
+	}
+	present := map[string]bool{}
+	for _, l := range links {
+		present[l.Name] = true
+	}
+
+	report := &RulesReport{}
+	var errs []error
+	for _, a := range attachments {
+		// Rules of a pod whose veth is gone are GC's to remove, not ours to
+		// restore.
+		if !present[a.HostVeth] {
+			continue
+		}
+		report.Checked += len(a.Rules)
+		drifted, err := p.driftedRules(a)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", a.Key(), err))
+			continue
+		}
+		for _, d := range drifted {
+			id := fmt.Sprintf("%s %s %s@%s", a.Key(), d.rule.Kind, d.rule.Key, d.rule.Fingerprint)
+			if d.drift == ruleChanged {
+				report.Changed = append(report.Changed, id)
+			} else {
+				report.Missing = append(report.Missing, id)
+			}
+			if !reapply {
+				continue
+			}
+			if err := p.applyRule(d.rule); err != nil {
+				errs = append(errs, fmt.Errorf("reapply %s: %w", id, err))
+				continue
+			}
+			report.Reapplied = append(report.Reapplied, id)
+		}
+	}
+	return report, errors.Join(errs...)
+}
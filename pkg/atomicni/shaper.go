@@ -0,0 +1,45 @@
+package atomicni
+
+import (
+	"crypto/sha512"
+	"fmt"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+)
+
+// bandwidthIfbName is the ifb device the bandwidth plugin redirects a pod's
+// egress to: "bwp" and a hash of network name and container ID, 15 characters.
+func bandwidthIfbName(network, containerID string) string {
+	return fmt.Sprintf("bwp%x", sha512.Sum512([]byte(network+containerID)))[:15]
+}
+
+// checkShapers verifies the bandwidth plugin's token buckets still shape the
+// pod at the rates in runtimeConfig.bandwidth: ingress on the host veth and
+// egress on the ifb device. Counters of every shaper found are logged.
+func (p *Plugin) checkShapers(cfg *config.NetworkConfig, containerID, hostVethName string, log *opLog) error {
+	bw := cfg.RuntimeConfig.Bandwidth
+	for _, s := range []struct {
+		dir, link string
+		rate      uint64
+	}{
+		{"ingress", hostVethName, bw.IngressRate},
+		{"egress", bandwidthIfbName(cfg.Name, containerID), bw.EgressRate},
+	} {
+		if s.rate == 0 {
+			continue
+		}
+		shaper, ok, err := p.NetOps.GetShaper(s.link)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no %s shaper on %q, want %d bit/s", s.dir, s.link, s.rate)
+		}
+		log.printf(1, "CHECK %s shaper on %s: rate=%dbit/s bytes=%d packets=%d drops=%d overlimits=%d",
+			s.dir, s.link, shaper.Rate*8, shaper.Bytes, shaper.Packets, shaper.Drops, shaper.Overlimits)
+		if want := s.rate / 8; shaper.Rate != want {
+			return fmt.Errorf("%s shaper on %q runs at %d bit/s, want %d", s.dir, s.link, shaper.Rate*8, s.rate)
+		}
+	}
+	return nil
+}
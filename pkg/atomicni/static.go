@@ -0,0 +1,75 @@
+package atomicni
+
+import (
+	"net"
+
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/annis-souames/atomicni/pkg/result"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// configureStatic assigns every static address and installs the configured
+// routes, or a default route via the first address gateway when none are set.
+func (p *Plugin) configureStatic(target ns.NetNS, ifName string, cfg *config.NetworkConfig) error {
+	for _, a := range cfg.StaticAddrs {
+		if err := p.NetOps.AddAddress(target, ifName, a.Addr); err != nil {
+			return err
+		}
+	}
+	for _, r := range staticRoutes(cfg) {
+		if err := p.NetOps.AddRoute(target, ifName, &r.Dst, r.GW); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// staticResult adds the remaining static addresses and routes to the result.
+func staticResult(res *current.Result, cfg *config.NetworkConfig) {
+	for _, a := range cfg.StaticAddrs[1:] {
+		result.AppendIP(res, &net.IPNet{IP: cloneIP(a.Addr.IP), Mask: a.Addr.Mask}, a.Gateway)
+	}
+	result.SetRoutes(res, staticRoutes(cfg))
+}
+
+// staticRoutes resolves configured routes, defaulting the gateway of each to the
+// first static address gateway and falling back to one default route on
+// primary interfaces.
+func staticRoutes(cfg *config.NetworkConfig) []*types.Route {
+	gateway := cfg.StaticAddrs[0].Gateway
+	if len(cfg.StaticRoutes) == 0 {
+		if cfg.Secondary {
+			return nil
+		}
+		return []*types.Route{{
+			Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			GW:  gateway,
+		}}
+	}
+
+	routes := make([]*types.Route, 0, len(cfg.StaticRoutes))
+	for _, r := range cfg.StaticRoutes {
+		gw := r.GW
+		if gw == nil {
+			gw = gateway
+		}
+		routes = append(routes, &types.Route{Dst: *r.Dst, GW: gw})
+	}
+	return routes
+}
+
+// extraRoutes resolves the network's `routes`, defaulting each gateway to
+// the pod's.
+func extraRoutes(cfg *config.NetworkConfig, gateway net.IP) []*types.Route {
+	routes := make([]*types.Route, 0, len(cfg.ExtraRoutes))
+	for _, r := range cfg.ExtraRoutes {
+		gw := r.GW
+		if gw == nil {
+			gw = gateway
+		}
+		routes = append(routes, &types.Route{Dst: *r.Dst, GW: gw})
+	}
+	return routes
+}
@@ -0,0 +1,161 @@
+package atomicni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+)
+
+// Topology is the node's AtomicNI network layout: bridges, the host veths
+// AtomicNI created, and the pod attachments behind them.
+type Topology struct {
+	Bridges []TopologyBridge `json:"bridges"`
+	Links   []TopologyLink   `json:"links"`
+	Pods    []TopologyPod    `json:"pods"`
+}
+
+// TopologyBridge is a bridge with its AtomicNI ports.
+type TopologyBridge struct {
+	Name  string   `json:"name"`
+	Ports []string `json:"ports"`
+}
+
+// TopologyLink is a host veth. Master is empty in ptp mode and Pod, the
+// attachment key, is empty for a veth no cached attachment accounts for.
+type TopologyLink struct {
+	Name   string `json:"name"`
+	Master string `json:"master,omitempty"`
+	MAC    string `json:"mac,omitempty"`
+	Pod    string `json:"pod,omitempty"`
+}
+
+// TopologyPod is one cached attachment with the addresses and routes of its
+// ADD result. HostVeth is empty when the link is gone.
+type TopologyPod struct {
+	Key         string   `json:"key"`
+	Network     string   `json:"network"`
+	ContainerID string   `json:"containerID"`
+	IfName      string   `json:"ifName"`
+	Netns       string   `json:"netns"`
+	MAC         string   `json:"mac,omitempty"`
+	HostVeth    string   `json:"hostVeth,omitempty"`
+	IPs         []string `json:"ips,omitempty"`
+	Routes      []string `json:"routes,omitempty"`
+}
+
+// Topology joins the attachment cache in dataDir with the host veths on the
+// node.
+func (p *Plugin) Topology(_ context.Context, dataDir string) (*Topology, error) {
+	if p.NetOps == nil {
+		return nil, errors.New("plugin has nil NetOps")
+	}
+	attachments, err := cache.List(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	links, err := p.NetOps.ListOwnedLinks("", p.names().HostPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	owners := map[string]string{}
+	topo := &Topology{Bridges: []TopologyBridge{}, Links: []TopologyLink{}, Pods: []TopologyPod{}}
+	present := map[string]bool{}
+	for _, l := range links {
+		present[l.Name] = true
+	}
+	for _, a := range attachments {
+		pod := TopologyPod{
+			Key:         a.Key(),
+			Network:     a.Network,
+			ContainerID: a.ContainerID,
+			IfName:      a.IfName,
+			Netns:       a.Netns,
+			MAC:         a.ContainerMAC,
+		}
+		if name := a.HostVeth; present[name] {
+			pod.HostVeth = name
+			owners[name] = pod.Key
+		}
+		if a.Result != nil {
+			for _, ipc := range a.Result.IPs {
+				pod.IPs = append(pod.IPs, ipc.Address.String())
+			}
+			for _, r := range a.Result.Routes {
+				route := r.Dst.String()
+				if r.GW != nil {
+					route += " via " + r.GW.String()
+				}
+				pod.Routes = append(pod.Routes, route)
+			}
+		}
+		topo.Pods = append(topo.Pods, pod)
+	}
+
+	ports := map[string][]string{}
+	for _, l := range links {
+		topo.Links = append(topo.Links, TopologyLink{Name: l.Name, Master: l.Master, MAC: l.MAC, Pod: owners[l.Name]})
+		if l.Master != "" {
+			ports[l.Master] = append(ports[l.Master], l.Name)
+		}
+	}
+	for name, names := range ports {
+		sort.Strings(names)
+		topo.Bridges = append(topo.Bridges, TopologyBridge{Name: name, Ports: names})
+	}
+	sort.Slice(topo.Bridges, func(i, j int) bool { return topo.Bridges[i].Name < topo.Bridges[j].Name })
+	sort.Slice(topo.Links, func(i, j int) bool { return topo.Links[i].Name < topo.Links[j].Name })
+	sort.Slice(topo.Pods, func(i, j int) bool { return topo.Pods[i].Key < topo.Pods[j].Key })
+	return topo, nil
+}
+
+// DOT renders the topology as a Graphviz digraph: the host, bridges, host
+// veths, and pods, with each pod labelled by its interface and addresses.
+// Veths without a bridge (ptp mode) hang off the host node.
+func (t *Topology) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph atomicni {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [fontname=\"monospace\"];\n")
+	b.WriteString("\t\"host\" [shape=house];\n")
+	for _, br := range t.Bridges {
+		fmt.Fprintf(&b, "\t%s [shape=box3d];\n", dotQuote("bridge:"+br.Name))
+		fmt.Fprintf(&b, "\t\"host\" -> %s;\n", dotQuote("bridge:"+br.Name))
+	}
+	for _, l := range t.Links {
+		label := l.Name
+		if l.Pod == "" {
+			label += "\n(orphan)"
+		}
+		fmt.Fprintf(&b, "\t%s [shape=ellipse, label=%s];\n", dotQuote("veth:"+l.Name), dotQuote(label))
+		parent := "host"
+		if l.Master != "" {
+			parent = "bridge:" + l.Master
+		}
+		fmt.Fprintf(&b, "\t%s -> %s;\n", dotQuote(parent), dotQuote("veth:"+l.Name))
+	}
+	for _, p := range t.Pods {
+		label := []string{p.ContainerID, p.IfName + " (" + p.Network + ")"}
+		label = append(label, p.IPs...)
+		for _, r := range p.Routes {
+			label = append(label, "route "+r)
+		}
+		fmt.Fprintf(&b, "\t%s [shape=component, label=%s];\n", dotQuote("pod:"+p.Key), dotQuote(strings.Join(label, "\n")))
+		if p.HostVeth != "" {
+			fmt.Fprintf(&b, "\t%s -> %s;\n", dotQuote("veth:"+p.HostVeth), dotQuote("pod:"+p.Key))
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// dotQuote renders s as a DOT string; newlines become line breaks in labels.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
@@ -0,0 +1,58 @@
+package atomicni
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/netops"
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+func TestTopology(t *testing.T) {
+	dataDir := t.TempDir()
+	_, addr, _ := net.ParseCIDR("10.22.0.30/24")
+	addr.IP = net.ParseIP("10.22.0.30").To4()
+	res := &current.Result{
+		IPs:    []*current.IPConfig{{Address: *addr}},
+		Routes: []*types.Route{{Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, GW: net.ParseIP("10.22.0.1")}},
+	}
+	veth := HostVethNameFor("web", "eth0")
+	if err := cache.Save(dataDir, &cache.Attachment{Network: "atomic-net", ContainerID: "web", IfName: "eth0", Netns: "/run/netns/web", HostVeth: veth, Result: res}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	netOps := &mockNetOps{links: []netops.OwnedLink{
+		{Name: veth, Master: "atomic0"},
+		{Name: HostVethPrefix + "0123456789abc"},
+	}}
+	topo, err := (&Plugin{NetOps: netOps}).Topology(context.Background(), dataDir)
+	if err != nil {
+		t.Fatalf("Topology() error = %v", err)
+	}
+	if len(topo.Bridges) != 1 || topo.Bridges[0].Name != "atomic0" || len(topo.Bridges[0].Ports) != 1 {
+		t.Fatalf("unexpected bridges: %+v", topo.Bridges)
+	}
+	if len(topo.Pods) != 1 || topo.Pods[0].HostVeth != veth || topo.Pods[0].IPs[0] != "10.22.0.30/24" ||
+		topo.Pods[0].Routes[0] != "0.0.0.0/0 via 10.22.0.1" {
+		t.Fatalf("unexpected pods: %+v", topo.Pods)
+	}
+	if len(topo.Links) != 2 || topo.Links[1].Pod != "atomic-net:web:eth0" || topo.Links[0].Pod != "" {
+		t.Fatalf("unexpected links: %+v", topo.Links)
+	}
+
+	dot := topo.DOT()
+	for _, want := range []string{
+		`"bridge:atomic0" -> "veth:` + veth + `";`,
+		`"veth:` + veth + `" -> "pod:atomic-net:web:eth0";`,
+		`"host" -> "veth:` + HostVethPrefix + `0123456789abc";`,
+		`label="web\neth0 (atomic-net)\n10.22.0.30/24\nroute 0.0.0.0/0 via 10.22.0.1"`,
+	} {
+		if !strings.Contains(dot, want) {
+			t.Fatalf("DOT() missing %s:\n%s", want, dot)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package atomicni
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/config"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// rollbackStack stores cleanups and runs them in reverse order. Cleanups are
+// plain data, so the stack can go into an ADD's transaction log and be run by
+// a later invocation when the process dies mid-way.
+type rollbackStack struct {
+	Steps []rollbackStep `json:"steps,omitempty"`
+}
+
+// rollbackStep is one cleanup, named like the DEL step doing the same. Kind
+// tells what it undoes so a rollback policy can keep it. Which of the other
+// fields are set depends on Op; names shared by the whole ADD come from its
+// plan.
+type rollbackStep struct {
+	Kind  rollbackKind `json:"kind"`
+	Op    string       `json:"op"`
+	Link  string       `json:"link,omitempty"`
+	Owner string       `json:"owner,omitempty"`
+	IP    net.IP       `json:"ip,omitempty"`
+	Dst   string       `json:"dst,omitempty"`
+	Proto int          `json:"proto,omitempty"`
+	Rule  *cache.Rule  `json:"rule,omitempty"`
+}
+
+type rollbackKind int
+
+const (
+	rollbackDatapath   rollbackKind = iota // links, addresses, host rules
+	rollbackAllocation                     // the IPAM allocation
+	rollbackRecord                         // the attachment record
+)
+
+// Push registers one datapath cleanup.
+func (r *rollbackStack) Push(s rollbackStep) {
+	s.Kind = rollbackDatapath
+	r.Steps = append(r.Steps, s)
+}
+
+// PushAllocation registers the release of the IPAM allocation.
+func (r *rollbackStack) PushAllocation(s rollbackStep) {
+	s.Kind = rollbackAllocation
+	r.Steps = append(r.Steps, s)
+}
+
+// PushRecord registers the removal of the attachment record.
+func (r *rollbackStack) PushRecord(s rollbackStep) {
+	s.Kind = rollbackRecord
+	r.Steps = append(r.Steps, s)
+}
+
+// Run passes to undo, in LIFO order, the cleanups policy does not keep.
+func (r *rollbackStack) Run(policy string, undo func(rollbackStep)) {
+	for i := len(r.Steps) - 1; i >= 0; i-- {
+		s := r.Steps[i]
+		switch {
+		case policy == config.RollbackKeepLinks:
+			continue
+		case policy == config.RollbackKeepAllocation && s.Kind != rollbackDatapath:
+			continue
+		}
+		undo(s)
+	}
+}
+
+// undoTarget is the ADD a rollback undoes.
+type undoTarget struct {
+	dataDir string
+	plan    *AddPlan
+	netns   string
+	// targetNS is the pod netns while the ADD still has it open; otherwise
+	// it is opened from netns.
+	targetNS ns.NetNS
+}
+
+// undo runs one cleanup. Cleanups are best effort, like the DEL steps they
+// mirror: a missing link, rule, or allocation is already undone.
+func (p *Plugin) undo(t *undoTarget, s rollbackStep) {
+	plan := t.plan
+	switch s.Op {
+	case "delete-cached-attachment":
+		_ = cache.Delete(t.dataDir, plan.Network, plan.ContainerID, plan.IfName)
+	case "delete-host-veth":
+		_ = p.NetOps.DeleteLink(s.Link)
+	case "delete-container-link":
+		targetNS := t.targetNS
+		if targetNS == nil {
+			opened, err := p.getNS(t.netns)
+			if err != nil {
+				// The runtime tore the sandbox down and the links with it.
+				return
+			}
+			defer opened.Close()
+			targetNS = opened
+		}
+		_ = p.NetOps.DeleteLinkInNS(targetNS, plan.IfName)
+		_ = p.NetOps.DeleteLinkInNS(targetNS, plan.PeerVeth)
+	case "firewalld-untrust":
+		_ = p.NetOps.FirewalldUntrust(plan.FirewalldZone, s.Link)
+	case "release-ip":
+		_ = p.IPAM.Release(context.Background(), t.dataDir, plan.Network, s.Owner)
+	case "remove-pod-set-member":
+		_ = p.NetOps.RemovePodSetMember(plan.Network, s.IP)
+	case "clear-rule":
+		_ = p.clearRule(*s.Rule)
+	case "delete-host-route":
+		if _, dst, err := net.ParseCIDR(s.Dst); err == nil {
+			_ = p.NetOps.DeleteProtoRoute(dst, s.Proto)
+		}
+	case "delete-static-neighbor":
+		_ = p.NetOps.DeleteHostStaticNeighbor(s.Link, s.IP)
+	}
+}
+
+// addTxn is the transaction log of one ADD, kept next to the attachment
+// record while the ADD runs: the plan, which of its steps finished, and the
+// rollback stack so far. A log still there when the next invocation for the
+// attachment comes means the ADD that wrote it died.
+type addTxn struct {
+	Netns     string        `json:"netns"`
+	StartedAt time.Time     `json:"startedAt"`
+	Plan      *AddPlan      `json:"plan"`
+	Done      []bool        `json:"done"`
+	Rollback  rollbackStack `json:"rollback"`
+}
+
+// save writes the log.
+func (t *addTxn) save(dataDir string) error {
+	raw, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal transaction log: %w", err)
+	}
+	return cache.SaveTxn(dataDir, t.Plan.Network, t.Plan.ContainerID, t.Plan.IfName, raw)
+}
+
+// interrupted returns the step the ADD was in when it died.
+func (t *addTxn) interrupted() string {
+	for i, done := range t.Done {
+		if !done && i < len(t.Plan.Steps) {
+			return t.Plan.Steps[i]
+		}
+	}
+	return "(finished)"
+}
+
+// recoverTxn rolls back an ADD of the attachment that died mid-way, as far
+// as its transaction log recorded it, whatever the rollback policy. It
+// removes the log, even one it cannot decode, and returns the step the ADD
+// was in, or "" when there was no log.
+func (p *Plugin) recoverTxn(dataDir, network, containerID, ifName string) (string, error) {
+	raw, ok, err := cache.LoadTxn(dataDir, network, containerID, ifName)
+	if err != nil || !ok {
+		return "", err
+	}
+	var txn addTxn
+	if err := json.Unmarshal(raw, &txn); err != nil || txn.Plan == nil {
+		_ = cache.DeleteTxn(dataDir, network, containerID, ifName)
+		return "", fmt.Errorf("transaction log of %s is corrupted: %v", cache.Key(network, containerID, ifName), err)
+	}
+	target := &undoTarget{dataDir: dataDir, plan: txn.Plan, netns: txn.Netns}
+	txn.Rollback.Run(config.RollbackFull, func(s rollbackStep) { p.undo(target, s) })
+	return txn.interrupted(), cache.DeleteTxn(dataDir, network, containerID, ifName)
+}
@@ -0,0 +1,73 @@
+// Package buildinfo identifies the build of the atomicni binaries. Release
+// builds stamp it at link time:
+//
+//	go build -ldflags "-X github.com/annis-souames/atomicni/pkg/buildinfo.Version=v0.4.0 \
+//	  -X github.com/annis-souames/atomicni/pkg/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/annis-souames/atomicni/pkg/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Unstamped builds fall back to the VCS data the Go toolchain embeds.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set via -ldflags -X.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info is the build identity reported by VERSION, logs, and `atomicni version`.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Date      string `json:"date,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the stamped build info, completed from the embedded VCS data.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = s.Value
+			}
+		case "vcs.time":
+			if info.Date == "" {
+				info.Date = s.Value
+			}
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// String renders i on one line, e.g. "v0.4.0 (commit 1a2b3c4d5e6f, 2026-01-02T03:04:05Z)".
+func (i Info) String() string {
+	commit := i.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if commit == "" {
+		commit = "unknown"
+	}
+	if i.Modified {
+		commit += "-dirty"
+	}
+	if i.Date == "" {
+		return fmt.Sprintf("%s (commit %s, %s)", i.Version, commit, i.GoVersion)
+	}
+	return fmt.Sprintf("%s (commit %s, %s, %s)", i.Version, commit, i.Date, i.GoVersion)
+}
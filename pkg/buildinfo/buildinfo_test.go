@@ -0,0 +1,29 @@
+package buildinfo
+
+import "testing"
+
+func TestGetPrefersStampedValues(t *testing.T) {
+	defer func(v, c, d string) { Version, Commit, Date = v, c, d }(Version, Commit, Date)
+	Version, Commit, Date = "v1.2.3", "0123456789abcdef0123", "2026-01-02T03:04:05Z"
+
+	info := Get()
+	if info.Version != "v1.2.3" || info.Commit != Commit || info.Date != Date || info.GoVersion == "" {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
+
+func TestInfoString(t *testing.T) {
+	tests := []struct {
+		info Info
+		want string
+	}{
+		{Info{Version: "v1.2.3", Commit: "0123456789abcdef", Date: "2026-01-02T03:04:05Z", GoVersion: "go1.24.3"}, "v1.2.3 (commit 0123456789ab, 2026-01-02T03:04:05Z, go1.24.3)"},
+		{Info{Version: "dev", Commit: "0123456789abcdef", Modified: true, GoVersion: "go1.24.3"}, "dev (commit 0123456789ab-dirty, go1.24.3)"},
+		{Info{Version: "dev", GoVersion: "go1.24.3"}, "dev (commit unknown, go1.24.3)"},
+	}
+	for _, tc := range tests {
+		if got := tc.info.String(); got != tc.want {
+			t.Fatalf("String() = %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package buildinfo
+
+// Feature is an optional subsystem that a build tag can leave out.
+type Feature struct {
+	Name    string `json:"name"`
+	Tag     string `json:"tag"`
+	Enabled bool   `json:"enabled"`
+	Summary string `json:"summary"`
+}
+
+// Features lists the optional subsystems and whether this build includes
+// them. Tag is the build tag that removes one:
+//
+//	go build -tags atomicni_nofirewall,atomicni_nok8s ./...
+func Features() []Feature {
+	return []Feature{
+		{
+			Name:    "firewall",
+			Tag:     "atomicni_nofirewall",
+			Enabled: Firewall,
+			Summary: "nftables/iptables host rules: masquerade, port maps, DSCP, connection limits, conntrack zones, egress gateways, DNS redirects, pod sets",
+		},
+		{
+			Name:    "k8s",
+			Tag:     "atomicni_nok8s",
+			Enabled: K8s,
+			Summary: "CRI sandbox checks before GC reclaims an address (criSocket, atomicnid --cri-socket) and Node events for IPAM exhaustion warnings",
+		},
+	}
+}
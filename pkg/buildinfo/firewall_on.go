@@ -0,0 +1,6 @@
+//go:build !atomicni_nofirewall
+
+package buildinfo
+
+// Firewall reports whether host firewall rules (nftables or iptables) are compiled in.
+const Firewall = true
@@ -0,0 +1,7 @@
+//go:build !atomicni_nok8s
+
+package buildinfo
+
+// K8s reports whether the CRI client used to confirm sandboxes are gone, and
+// the Node event writer, are compiled in.
+const K8s = true
@@ -0,0 +1,238 @@
+// Package cache persists one record per attachment so DEL, GC, and tooling can
+// find what ADD created after the plugin process has exited.
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/schema"
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+const resultsDir = "results"
+
+// Schema is the schema version of the attachment records this build writes.
+const Schema = 1
+
+// migrations upgrade older attachment records; entry i upgrades version i.
+var migrations = [Schema]schema.Migration{
+	// 0 -> 1: the version field itself.
+	schema.Stamp,
+}
+
+// Attachment is the cached record of one container attachment.
+type Attachment struct {
+	SchemaVersion int `json:"schemaVersion"`
+
+	Network     string    `json:"network"`
+	ContainerID string    `json:"containerID"`
+	IfName      string    `json:"ifName"`
+	Netns       string    `json:"netns"`
+	CreatedAt   time.Time `json:"createdAt"`
+	// PluginBuild identifies the atomicni build that created the attachment.
+	PluginBuild string `json:"pluginBuild,omitempty"`
+	// HostVeth is the host veth name ADD chose.
+	HostVeth     string          `json:"hostVeth,omitempty"`
+	HostMAC      string          `json:"hostMAC,omitempty"`
+	ContainerMAC string          `json:"containerMAC,omitempty"`
+	Result       *current.Result `json:"result,omitempty"`
+	Rules        []Rule          `json:"rules,omitempty"`
+	// Config and CNIArgs are the network config and CNI_ARGS ADD ran with,
+	// so drain can tear the attachment down the way DEL does.
+	Config  json.RawMessage `json:"config,omitempty"`
+	CNIArgs string          `json:"cniArgs,omitempty"`
+	// Failure is set when ADD failed and its rollback policy kept state for
+	// DEL or GC to clean up.
+	Failure *Failure `json:"failure,omitempty"`
+}
+
+// Failure records a failed ADD whose rollback kept the allocation, or also
+// the links and host rules, until RetryUntil.
+type Failure struct {
+	Op         string    `json:"op"`
+	Error      string    `json:"error"`
+	Policy     string    `json:"policy"`
+	At         time.Time `json:"at"`
+	RetryUntil time.Time `json:"retryUntil"`
+}
+
+// Rule records one host firewall rule installed for an attachment, with the
+// spec needed to re-apply it and a fingerprint of that spec.
+type Rule struct {
+	Kind        string          `json:"kind"`
+	Key         string          `json:"key"`
+	Spec        json.RawMessage `json:"spec"`
+	Fingerprint string          `json:"fingerprint"`
+}
+
+// Key returns the unique identity of the attachment.
+func (a *Attachment) Key() string {
+	return Key(a.Network, a.ContainerID, a.IfName)
+}
+
+// KeySep joins the parts of an attachment key. CNI restricts network names
+// and container IDs to letters, digits, '_', '.', and '-', and Linux refuses
+// ':' in interface names, so no part can contain it.
+const KeySep = ":"
+
+// Key builds the attachment identity used as the cache file name, as the
+// owner tag of host rules, and to derive the conntrack zone.
+func Key(network, containerID, ifName string) string {
+	return network + KeySep + containerID + KeySep + ifName
+}
+
+// Save atomically writes the attachment record under dataDir.
+func Save(dataDir string, a *Attachment) error {
+	if a.Network == "" || a.ContainerID == "" || a.IfName == "" {
+		return errors.New("network, containerID, and ifName are required")
+	}
+	if strings.Contains(a.Network+a.ContainerID+a.IfName, KeySep) {
+		return fmt.Errorf("network, containerID, and ifName must not contain %q", KeySep)
+	}
+	dir := filepath.Join(dataDir, resultsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create cache dir: %w", err)
+	}
+
+	a.SchemaVersion = Schema
+	content, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal attachment: %w", err)
+	}
+
+	return writeSealed(dataDir, filepath.Join(dir, a.Key()+".json"), content, "attachment")
+}
+
+// writeSealed seals content and atomically replaces path with it.
+func writeSealed(dataDir, path string, content []byte, what string) error {
+	content, err := statecrypt.Seal(dataDir, filepath.Base(path), content)
+	if err != nil {
+		return fmt.Errorf("seal %s: %w", what, err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("write temp %s: %w", what, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace %s: %w", what, err)
+	}
+	return nil
+}
+
+// Load reads one attachment record, reporting false when it does not exist.
+func Load(dataDir, network, containerID, ifName string) (*Attachment, bool, error) {
+	path := filepath.Join(dataDir, resultsDir, Key(network, containerID, ifName)+".json")
+	a, err := readAttachment(dataDir, path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return a, true, nil
+}
+
+// Delete removes one attachment record; a missing record is not an error.
+func Delete(dataDir, network, containerID, ifName string) error {
+	path := filepath.Join(dataDir, resultsDir, Key(network, containerID, ifName)+".json")
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete attachment: %w", err)
+	}
+	return nil
+}
+
+// SaveTxn atomically writes the transaction log of an ADD in progress for an
+// attachment. The plugin owns its content; it is kept next to the record as
+// <key>.txn, which List skips.
+func SaveTxn(dataDir, network, containerID, ifName string, content []byte) error {
+	dir := filepath.Join(dataDir, resultsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create cache dir: %w", err)
+	}
+	return writeSealed(dataDir, filepath.Join(dir, Key(network, containerID, ifName)+".txn"), content, "transaction log")
+}
+
+// LoadTxn reads the transaction log of an attachment, reporting false when
+// there is none.
+func LoadTxn(dataDir, network, containerID, ifName string) ([]byte, bool, error) {
+	path := filepath.Join(dataDir, resultsDir, Key(network, containerID, ifName)+".txn")
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err == nil {
+		content, err = statecrypt.Open(dataDir, filepath.Base(path), content)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read transaction log: %w", err)
+	}
+	return content, true, nil
+}
+
+// DeleteTxn removes the transaction log of an attachment; a missing log is
+// not an error.
+func DeleteTxn(dataDir, network, containerID, ifName string) error {
+	path := filepath.Join(dataDir, resultsDir, Key(network, containerID, ifName)+".txn")
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("delete transaction log: %w", err)
+	}
+	return nil
+}
+
+// List returns every cached attachment under dataDir.
+func List(dataDir string) ([]*Attachment, error) {
+	entries, err := os.ReadDir(filepath.Join(dataDir, resultsDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read cache dir: %w", err)
+	}
+
+	var out []*Attachment
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		a, err := readAttachment(dataDir, filepath.Join(dataDir, resultsDir, entry.Name()))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// readAttachment decodes one attachment file.
+func readAttachment(dataDir, path string) (*Attachment, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = statecrypt.Open(dataDir, filepath.Base(path), content); err != nil {
+		return nil, err
+	}
+	content, _, err = schema.Upgrade(content, migrations[:])
+	if errors.Is(err, schema.ErrNewer) {
+		return nil, fmt.Errorf("attachment cache file %s: %w", path, err)
+	}
+	a := &Attachment{}
+	if err == nil {
+		err = json.Unmarshal(content, a)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("attachment cache file %s is corrupted: %w", path, err)
+	}
+	return a, nil
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/annis-souames/atomicni/pkg/schema"
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
+)
+
+func TestSaveLoadListDelete(t *testing.T) {
+	dir := t.TempDir()
+	a := &Attachment{Network: "atomic-net", ContainerID: "c1", IfName: "eth0", Netns: "/var/run/netns/c1"}
+	if err := Save(dir, a); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, ok, err := Load(dir, "atomic-net", "c1", "eth0")
+	if err != nil || !ok {
+		t.Fatalf("Load: ok=%v err=%v", ok, err)
+	}
+	if got.Netns != a.Netns {
+		t.Fatalf("expected netns %q, got %q", a.Netns, got.Netns)
+	}
+
+	all, err := List(dir)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(all) != 1 || all[0].ContainerID != "c1" {
+		t.Fatalf("unexpected list result: %+v", all)
+	}
+
+	if err := Delete(dir, "atomic-net", "c1", "eth0"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := Delete(dir, "atomic-net", "c1", "eth0"); err != nil {
+		t.Fatalf("second Delete: %v", err)
+	}
+	if _, ok, _ := Load(dir, "atomic-net", "c1", "eth0"); ok {
+		t.Fatalf("expected attachment to be deleted")
+	}
+}
+
+func TestListMissingDir(t *testing.T) {
+	all, err := List(t.TempDir())
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("expected no attachments, got %d", len(all))
+	}
+}
+
+func TestTxnLogLivesBesideRecords(t *testing.T) {
+	dir := t.TempDir()
+	if err := Save(dir, &Attachment{Network: "atomic-net", ContainerID: "c1", IfName: "eth0"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := SaveTxn(dir, "atomic-net", "c1", "eth0", []byte(`{"done":[true]}`)); err != nil {
+		t.Fatalf("SaveTxn: %v", err)
+	}
+	got, ok, err := LoadTxn(dir, "atomic-net", "c1", "eth0")
+	if err != nil || !ok || string(got) != `{"done":[true]}` {
+		t.Fatalf("LoadTxn = %q %v %v", got, ok, err)
+	}
+	if all, err := List(dir); err != nil || len(all) != 1 {
+		t.Fatalf("List = %d attachments, %v", len(all), err)
+	}
+
+	if err := DeleteTxn(dir, "atomic-net", "c1", "eth0"); err != nil {
+		t.Fatalf("DeleteTxn: %v", err)
+	}
+	if err := DeleteTxn(dir, "atomic-net", "c1", "eth0"); err != nil {
+		t.Fatalf("second DeleteTxn: %v", err)
+	}
+	if _, ok, err := LoadTxn(dir, "atomic-net", "c1", "eth0"); ok || err != nil {
+		t.Fatalf("LoadTxn after delete: ok=%v err=%v", ok, err)
+	}
+}
+
+func TestSaveSealsEncryptedDir(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(t.TempDir(), "state.key")
+	if err := os.WriteFile(keyPath, bytes.Repeat([]byte{7}, 32), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := statecrypt.Enable(dir, statecrypt.KeyFilePrefix+keyPath); err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	a := &Attachment{Network: "atomic-net", ContainerID: "c1", IfName: "eth0", Netns: "/var/run/netns/c1"}
+	if err := Save(dir, a); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, resultsDir, a.Key()+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("/var/run/netns/c1")) {
+		t.Fatalf("attachment stored in plaintext: %s", raw)
+	}
+	got, ok, err := Load(dir, "atomic-net", "c1", "eth0")
+	if err != nil || !ok || got.Netns != a.Netns {
+		t.Fatalf("Load: %+v ok=%v err=%v", got, ok, err)
+	}
+}
+
+func TestSchemaUpgradesOldRecordsAndRefusesNewer(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, resultsDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, resultsDir, Key("atomic-net", "c1", "eth0")+".json")
+	old := `{"network":"atomic-net","containerID":"c1","ifName":"eth0","netns":"/var/run/netns/c1"}`
+	if err := os.WriteFile(path, []byte(old), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, ok, err := Load(dir, "atomic-net", "c1", "eth0")
+	if err != nil || !ok || a.SchemaVersion != Schema || a.Netns != "/var/run/netns/c1" {
+		t.Fatalf("Load(version 0) = %+v, %v, %v", a, ok, err)
+	}
+
+	newer := fmt.Sprintf(`{"schemaVersion":%d,"network":"atomic-net","containerID":"c1","ifName":"eth0"}`, Schema+1)
+	if err := os.WriteFile(path, []byte(newer), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Load(dir, "atomic-net", "c1", "eth0"); !errors.Is(err, schema.ErrNewer) {
+		t.Fatalf("Load(newer) error = %v", err)
+	}
+	if _, err := List(dir); !errors.Is(err, schema.ErrNewer) {
+		t.Fatalf("List(newer) error = %v", err)
+	}
+}
+
+func TestKeysOfHyphenatedPartsDoNotCollide(t *testing.T) {
+	if Key("a-b", "c", "eth0") == Key("a", "b-c", "eth0") {
+		t.Fatalf("keys collide: %q", Key("a-b", "c", "eth0"))
+	}
+
+	dir := t.TempDir()
+	for _, a := range []*Attachment{
+		{Network: "a-b", ContainerID: "c", IfName: "eth0", Netns: "/ns/1"},
+		{Network: "a", ContainerID: "b-c", IfName: "eth0", Netns: "/ns/2"},
+	} {
+		if err := Save(dir, a); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	if err := Delete(dir, "a-b", "c", "eth0"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, ok, err := Load(dir, "a", "b-c", "eth0"); err != nil || !ok || got.Netns != "/ns/2" {
+		t.Fatalf("Load of the other attachment = %+v, %v, %v", got, ok, err)
+	}
+	if err := Save(dir, &Attachment{Network: "a:b", ContainerID: "c", IfName: "eth0"}); err == nil {
+		t.Fatalf("expected Save to refuse a part containing %q", KeySep)
+	}
+}
@@ -0,0 +1,59 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTickerFiresAsTimeAdvances(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Minute)
+
+	f.Advance(59 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before the period elapsed")
+	default:
+	}
+	f.Advance(time.Second)
+	if got := <-ticker.C(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("tick at %v", got)
+	}
+
+	// Like time.Ticker, a reader that falls behind gets one tick, not a
+	// backlog.
+	f.Advance(5 * time.Minute)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("ticks queued for a slow reader")
+	default:
+	}
+	if !f.Now().Equal(start.Add(6 * time.Minute)) {
+		t.Fatalf("Now() = %v", f.Now())
+	}
+
+	ticker.Stop()
+	f.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Fatal("stopped ticker fired")
+	default:
+	}
+}
+
+func TestBlockUntilWaitsForTickers(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	started := make(chan struct{})
+	go func() {
+		f.NewTicker(time.Second)
+		f.NewTicker(time.Second)
+		close(started)
+	}()
+	f.BlockUntil(2)
+	<-started
+	if Or(nil) != Real || Or(f) != Clock(f) {
+		t.Fatal("Or does not default to the system clock")
+	}
+}
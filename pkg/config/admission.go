@@ -0,0 +1,67 @@
+package config
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// DefaultAdmissionMaxWait is how long ADD queues for admission, in seconds.
+const DefaultAdmissionMaxWait = 60
+
+// AdmissionConfig throttles ADDs node-wide so a mass reschedule does not run
+// hundreds of setups at once. MaxConcurrent caps ADDs in flight; Rate (ADDs
+// per second) and Burst form a token bucket on ADD starts. Limits are shared
+// by every network using the same data dir. An ADD that waits longer than
+// MaxWait seconds fails and the runtime retries it.
+type AdmissionConfig struct {
+	MaxConcurrent int     `json:"maxConcurrent,omitempty"`
+	Rate          float64 `json:"rate,omitempty"`
+	Burst         int     `json:"burst,omitempty"`
+	MaxWait       int     `json:"maxWait,omitempty"`
+}
+
+// parseAdmission validates `admission` and applies its defaults.
+func (c *NetworkConfig) parseAdmission() error {
+	a := c.Admission
+	if a == nil {
+		return nil
+	}
+	if a.MaxConcurrent < 0 {
+		return fieldErrorf("admission.maxConcurrent", a.MaxConcurrent, "cannot be negative")
+	}
+	if a.Rate < 0 || math.IsInf(a.Rate, 0) || math.IsNaN(a.Rate) {
+		return fieldErrorf("admission.rate", a.Rate, "is not a positive rate")
+	}
+	if a.Burst < 0 {
+		return fieldErrorf("admission.burst", a.Burst, "cannot be negative")
+	}
+	if a.Burst > 0 && a.Rate == 0 {
+		return fieldErrorf("admission.burst", a.Burst, "requires admission.rate")
+	}
+	if a.MaxWait < 0 {
+		return fieldErrorf("admission.maxWait", a.MaxWait, "cannot be negative")
+	}
+	if a.MaxConcurrent == 0 && a.Rate == 0 {
+		return fieldErrorf("admission", nil, "set maxConcurrent, rate, or both")
+	}
+	if a.Rate > 0 && a.Burst == 0 {
+		a.Burst = int(math.Max(1, math.Ceil(a.Rate)))
+	}
+	if a.MaxWait == 0 {
+		a.MaxWait = DefaultAdmissionMaxWait
+	}
+	return nil
+}
+
+// AdmissionSettings extracts `admission` and the data dir without validating
+// the rest of the config, so ADD can queue before doing any work. It returns
+// nil when admission is off or invalid; Parse rejects an invalid config.
+func AdmissionSettings(stdin []byte) (*AdmissionConfig, string) {
+	var cfg NetworkConfig
+	_ = json.Unmarshal(stdin, &cfg)
+	if cfg.Admission == nil || cfg.parseAdmission() != nil {
+		return nil, ""
+	}
+	_, dataDir := Identity(stdin)
+	return cfg.Admission, dataDir
+}
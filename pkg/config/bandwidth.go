@@ -0,0 +1,24 @@
+package config
+
+// BandwidthEntry is the `bandwidth` capability: rates in bits per second and
+// bursts in bits. AtomicNI does not shape traffic itself; it receives the
+// entry when its conflist entry enables the capability so CHECK can verify
+// the qdiscs a chained bandwidth plugin installed.
+type BandwidthEntry struct {
+	IngressRate  uint64 `json:"ingressRate,omitempty"`
+	IngressBurst uint64 `json:"ingressBurst,omitempty"`
+	EgressRate   uint64 `json:"egressRate,omitempty"`
+	EgressBurst  uint64 `json:"egressBurst,omitempty"`
+}
+
+// parseBandwidth validates `runtimeConfig.bandwidth`.
+func (c *NetworkConfig) parseBandwidth() error {
+	bw := c.RuntimeConfig.Bandwidth
+	if bw == nil {
+		return nil
+	}
+	if (bw.IngressRate > 0) != (bw.IngressBurst > 0) || (bw.EgressRate > 0) != (bw.EgressBurst > 0) {
+		return fieldErrorf("runtimeConfig.bandwidth", bw, "rates and bursts must be set together")
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// maxAgeingTime is the kernel's upper bound for bridge FDB ageing, in seconds.
+const maxAgeingTime = 1000000
+
+// BridgeAuto as `bridge` names the bridge after the network, giving every
+// network its own bridge.
+const BridgeAuto = "auto"
+
+// autoBridgePrefix leaves 8 hex digits within the 15-byte interface name limit.
+const autoBridgePrefix = "atomic-"
+
+// AutoBridgeName is the bridge `bridge: "auto"` resolves to for network.
+func AutoBridgeName(network string) string {
+	sum := sha256.Sum256([]byte(network))
+	return autoBridgePrefix + hex.EncodeToString(sum[:])[:8]
+}
+
+// BridgeOptions tunes the managed bridge. Unset fields keep kernel defaults.
+type BridgeOptions struct {
+	// AgeingTime is the FDB entry lifetime in seconds; 0 keeps learned
+	// entries forever.
+	AgeingTime    *int  `json:"ageingTime,omitempty"`
+	McastSnooping *bool `json:"mcastSnooping,omitempty"`
+	McastQuerier  *bool `json:"mcastQuerier,omitempty"`
+}
+
+// parseBridgeOptions validates `bridgeOptions` and `isolatePods`.
+func (c *NetworkConfig) parseBridgeOptions() error {
+	if c.IsolatePods && c.Mode != ModeBridge {
+		return fieldErrorf("isolatePods", c.IsolatePods, "only supported in bridge mode")
+	}
+	o := c.BridgeOptions
+	if o == nil {
+		return nil
+	}
+	if c.Mode != ModeBridge {
+		return fieldErrorf("bridgeOptions", nil, "only supported in bridge mode")
+	}
+	if o.AgeingTime != nil && (*o.AgeingTime < 0 || *o.AgeingTime > maxAgeingTime) {
+		return fieldErrorf("bridgeOptions.ageingTime", *o.AgeingTime, "must be within 0-%d seconds", maxAgeingTime)
+	}
+	// Without snooping the bridge floods multicast and never sends queries.
+	if o.McastQuerier != nil && *o.McastQuerier && o.McastSnooping != nil && !*o.McastSnooping {
+		return fieldErrorf("bridgeOptions.mcastQuerier", *o.McastQuerier, "requires mcastSnooping")
+	}
+	return nil
+}
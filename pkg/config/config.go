@@ -5,186 +5,558 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
 )
 
 const (
 	DefaultMTU     = 1500
+	MinMTU         = 68
+	MaxMTU         = 65535
 	DefaultDataDir = "/var/lib/atomicni"
 )
 
+const (
+	ModeBridge = "bridge"
+	ModePTP    = "ptp"
+)
+
+const (
+	IPAMTypeFile   = "file"
+	IPAMTypeStatic = "static"
+)
+
 // IPAMConfig configures local IP allocation persistence and optional range bounds.
 type IPAMConfig struct {
-	DataDir    string `json:"dataDir"`
-	RangeStart string `json:"rangeStart,omitempty"`
-	RangeEnd   string `json:"rangeEnd,omitempty"`
+	Type       string          `json:"type,omitempty"`
+	DataDir    string          `json:"dataDir"`
+	RangeStart string          `json:"rangeStart,omitempty"`
+	RangeEnd   string          `json:"rangeEnd,omitempty"`
+	Ranges     []RangeConfig   `json:"ranges,omitempty"`
+	Addresses  []StaticAddress `json:"addresses,omitempty"`
+	Routes     []RouteConfig   `json:"routes,omitempty"`
+
+	// EncryptState seals IPAM state and cached results in DataDir with the
+	// key named by StateKey; see parseEncryptState.
+	EncryptState bool   `json:"encryptState,omitempty"`
+	StateKey     string `json:"stateKey,omitempty"`
+
+	// ReservationsFile seeds identity -> IP reservations (.csv or .yaml);
+	// see ipam.LoadReservations.
+	ReservationsFile string `json:"reservationsFile,omitempty"`
+
+	// ExhaustionWarning warns before the pool runs out; see
+	// ExhaustionWarningConfig.
+	ExhaustionWarning *ExhaustionWarningConfig `json:"exhaustionWarning,omitempty"`
+
+	// DHCPLeases excludes addresses an external DHCP server has leased; see
+	// DHCPLeasesConfig.
+	DHCPLeases *DHCPLeasesConfig `json:"dhcpLeases,omitempty"`
+}
+
+// EventsConfig selects where lifecycle events are emitted as NDJSON.
+type EventsConfig struct {
+	File   string `json:"file,omitempty"`
+	Socket string `json:"socket,omitempty"`
 }
 
 // NetworkConfig is AtomicNI plugin configuration loaded from CNI stdin.
 type NetworkConfig struct {
-	CNIVersion string     `json:"cniVersion"`
-	Name       string     `json:"name"`
-	Type       string     `json:"type"`
-	Bridge     string     `json:"bridge"`
-	Subnet     string     `json:"subnet"`
-	Gateway    string     `json:"gateway"`
-	MTU        int        `json:"mtu"`
-	IPAM       IPAMConfig `json:"ipam"`
+	CNIVersion string       `json:"cniVersion"`
+	Name       string       `json:"name"`
+	Type       string       `json:"type"`
+	Mode       string       `json:"mode,omitempty"`
+	Bridge     string       `json:"bridge"`
+	Subnet     string       `json:"subnet"`
+	Gateway    string       `json:"gateway"`
+	MTU        int          `json:"mtu"`
+	IPAM       IPAMConfig   `json:"ipam"`
+	Events     EventsConfig `json:"events"`
+
+	// LogLevel, LogFile, and Timeout (seconds, 0 for none) can also be set via
+	// ATOMICNI_* environment variables, which win over stdin.
+	LogLevel string `json:"logLevel,omitempty"`
+	LogFile  string `json:"logFile,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+
+	ClusterSubnet   string `json:"clusterSubnet,omitempty"`
+	PartitionPrefix int    `json:"partitionPrefix,omitempty"`
+	PartitionBy     string `json:"partitionBy,omitempty"`
+	PartitionIndex  string `json:"partitionIndex,omitempty"`
+
+	BridgeOptions   *BridgeOptions  `json:"bridgeOptions,omitempty"`
+	IsolatePods     bool            `json:"isolatePods,omitempty"`
+	Neighbor        *NeighborConfig `json:"neighbor,omitempty"`
+	StaticNeighbors StaticNeighbors `json:"staticNeighbors,omitempty"`
+
+	VLANTrunk []VLANTrunk `json:"vlanTrunk,omitempty"`
+	QinQ      *QinQConfig `json:"qinq,omitempty"`
+	DSCP      *int        `json:"dscp,omitempty"`
+	ConnLimit *ConnLimit  `json:"connLimit,omitempty"`
+	IPMasq    bool        `json:"ipMasq,omitempty"`
+	SNAT      *SNATConfig `json:"snat,omitempty"`
+
+	Firewalld      FirewalldConfig `json:"firewalld,omitempty"`
+	ConntrackZones bool            `json:"conntrackZones,omitempty"`
+
+	EgressGateway *EgressGatewayConfig `json:"egressGateway,omitempty"`
+	// PodSet keeps every pod IP of the network in a host set; see
+	// netops.PodSetName.
+	PodSet bool `json:"podSet,omitempty"`
+
+	// ServiceCIDR is routed via the gateway in every pod; see parseServiceCIDR.
+	ServiceCIDR      string `json:"serviceCIDR,omitempty"`
+	ServiceHostRoute string `json:"serviceHostRoute,omitempty"`
+
+	NodeLocalDNS *NodeLocalDNSConfig `json:"nodeLocalDNS,omitempty"`
+
+	// RouteProto, when set, installs a host /32 route per pod address with
+	// this protocol number; see parseRouteProto.
+	RouteProto int `json:"routeProto,omitempty"`
+
+	// IPBatch issues the link, address, and route commands of an ADD through
+	// one `ip -batch` per namespace instead of one ip process each.
+	IPBatch bool `json:"ipBatch,omitempty"`
+
+	// Rollback selects what a failed ADD undoes; see RollbackConfig.
+	Rollback *RollbackConfig `json:"rollback,omitempty"`
 
-	SubnetNet    *net.IPNet `json:"-"`
-	GatewayIP    net.IP     `json:"-"`
-	RangeStartIP net.IP     `json:"-"`
-	RangeEndIP   net.IP     `json:"-"`
+	// VethNaming selects how host veths are named; see VethNamingPod.
+	VethNaming string `json:"vethNaming,omitempty"`
+	// HostMAC selects how host veth MACs are chosen; see HostMACPinned.
+	HostMAC string `json:"hostMAC,omitempty"`
+
+	// Exec restricts and audits the external programs run; see ExecConfig.
+	Exec *ExecConfig `json:"exec,omitempty"`
+	// CNIPath lists directories searched for delegated plugins before
+	// CNI_PATH; see FindDelegate.
+	CNIPath []string `json:"cniPath,omitempty"`
+
+	// Admission throttles concurrent ADDs; see AdmissionConfig.
+	Admission *AdmissionConfig `json:"admission,omitempty"`
+
+	// AllowOverlap lets the subnet overlap another network's or a host
+	// link's; by default the first ADD refuses it.
+	AllowOverlap bool `json:"allowOverlap,omitempty"`
+
+	// DefaultRouteConflict decides what happens to an existing default
+	// route in the pod; see DefaultRouteKeep.
+	DefaultRouteConflict string `json:"defaultRouteConflict,omitempty"`
+	// DefaultRouteMetric ranks the pod's default route against those of
+	// other attachments; lower wins and 0 is the kernel default.
+	DefaultRouteMetric int `json:"defaultRouteMetric,omitempty"`
+	// ECMPGateways spreads the pod's default route over several next hops;
+	// see parseECMPGateways.
+	ECMPGateways []string `json:"ecmpGateways,omitempty"`
+
+	// Sandbox names the sandboxed runtime (SandboxKata, SandboxGVisor)
+	// whose shim takes over the pod interface; CHECK then skips pod-side
+	// checks the host can no longer see.
+	Sandbox string `json:"sandbox,omitempty"`
+
+	// Template names the entry of TemplatesFile (DefaultTemplatesFile when
+	// empty) whose dns, routes, and sysctls this network inherits.
+	Template      string `json:"template,omitempty"`
+	TemplatesFile string `json:"templatesFile,omitempty"`
+	// DNS is returned in the ADD result unless nodeLocalDNS replaces it.
+	DNS *DNSConfig `json:"dns,omitempty"`
+	// Routes are extra pod routes, on top of the default route.
+	Routes []RouteConfig `json:"routes,omitempty"`
+	// Sysctls are net.* sysctls set in the pod netns.
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+
+	// DisableCheck and DisableGC make CHECK and the CNI GC verb succeed
+	// without looking at anything, for runtimes that call them too often.
+	DisableCheck bool `json:"disableCheck,omitempty"`
+	DisableGC    bool `json:"disableGC,omitempty"`
+	// CRISocket is the container runtime socket GC confirms a sandbox is
+	// gone with before reclaiming its address; see parseCRISocket.
+	CRISocket string `json:"criSocket,omitempty"`
+
+	// VerifyConnectivity makes ADD ping the gateway from the pod and fail,
+	// rolling back, when it does not answer.
+	VerifyConnectivity bool `json:"verifyConnectivity,omitempty"`
+
+	// Secondary marks an extra pod interface, such as a Multus-delegated
+	// net1: it gets no default route unless ipam.routes asks for one, and its
+	// address is owned by the container and ifName together.
+	Secondary bool `json:"secondary,omitempty"`
+	// DeviceID is set by Multus for device-plugin resources; see CheckDeviceID.
+	DeviceID string `json:"deviceID,omitempty"`
+
+	RuntimeConfig RuntimeConfig `json:"runtimeConfig,omitempty"`
+
+	// Networks attaches the container to several networks in one ADD; see
+	// parseNetworks.
+	Networks []json.RawMessage `json:"networks,omitempty"`
+	Members  []Member          `json:"-"`
+
+	SubnetNet *net.IPNet `json:"-"`
+	GatewayIP net.IP     `json:"-"`
+	// ECMPGatewayIPs are the parsed ecmpGateways.
+	ECMPGatewayIPs []net.IP  `json:"-"`
+	RangeStartIP   net.IP    `json:"-"`
+	RangeEndIP     net.IP    `json:"-"`
+	Ranges         []IPRange `json:"-"`
+	PreferredIP    net.IP    `json:"-"`
+	// PodMAC is the pod interface's MAC from CNI_ARGS MAC; nil leaves the
+	// kernel's.
+	PodMAC net.HardwareAddr `json:"-"`
+
+	StaticAddrs  []StaticAddr `json:"-"`
+	StaticRoutes []Route      `json:"-"`
+	ExtraRoutes  []Route      `json:"-"`
+
+	ClusterSubnetNet *net.IPNet `json:"-"`
+
+	VLANs      []int       `json:"-"`
+	PortRanges []PortRange `json:"-"`
+
+	SNATPortMin int    `json:"-"`
+	SNATPortMax int    `json:"-"`
+	EgressIP    net.IP `json:"-"`
+
+	EgressGatewayIP  net.IP `json:"-"`
+	EgressBackupIP   net.IP `json:"-"`
+	UseEgressGateway bool   `json:"-"`
+
+	ServiceCIDRNet *net.IPNet `json:"-"`
+	ServiceHostVia net.IP     `json:"-"`
+
+	NodeLocalDNSIP     net.IP `json:"-"`
+	NodeLocalDNSTarget net.IP `json:"-"`
 }
 
 // Parse loads, defaults, and validates the CNI plugin config.
 func Parse(stdin []byte) (*NetworkConfig, error) {
+	cfg, errs := parse(stdin, false)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return cfg, nil
+}
+
+// parse runs Parse's validation steps in order. It stops at the first failure
+// unless all is set, in which case every step runs and each failure is
+// returned; a step that fails leaves its fields unset for the ones after it.
+func parse(stdin []byte, all bool) (*NetworkConfig, []error) {
 	cfg := &NetworkConfig{}
 	if err := json.Unmarshal(stdin, cfg); err != nil {
-		return nil, fmt.Errorf("parse config json: %w", err)
+		return nil, []error{fmt.Errorf("parse config json: %w", err)}
 	}
-
-	if cfg.Bridge == "" {
-		return nil, errors.New("bridge is required")
+	var errs []error
+	run := func(steps ...func() error) {
+		for _, step := range steps {
+			if len(errs) > 0 && !all {
+				return
+			}
+			if err := step(); err != nil {
+				errs = append(errs, err)
+			}
+		}
 	}
-	if cfg.Name == "" {
-		return nil, errors.New("name is required")
+
+	run(cfg.applyEnv)
+	if len(cfg.Networks) > 0 {
+		run(cfg.parseNetworks, cfg.parseLogging)
+		return cfg, errs
 	}
-	if cfg.Subnet == "" {
-		return nil, errors.New("subnet is required")
+
+	run(
+		cfg.parseMode,
+		func() error {
+			if cfg.Name == "" {
+				return fieldErrorf("name", nil, "is required")
+			}
+			return nil
+		},
+		func() error {
+			if cfg.MTU == 0 {
+				cfg.MTU = DefaultMTU
+			}
+			if cfg.MTU < MinMTU || cfg.MTU > MaxMTU {
+				return fieldErrorf("mtu", cfg.MTU, "out of range %d-%d", MinMTU, MaxMTU)
+			}
+			return nil
+		},
+		func() error {
+			if cfg.IPAM.DataDir == "" {
+				cfg.IPAM.DataDir = DefaultDataDir
+			}
+			if cfg.IPAM.Type == "" {
+				cfg.IPAM.Type = IPAMTypeFile
+			}
+			if cfg.IPAM.Type != IPAMTypeFile && cfg.IPAM.Type != IPAMTypeStatic {
+				return fieldErrorf("ipam.type", cfg.IPAM.Type, "unsupported value")
+			}
+			return nil
+		},
+		cfg.parseLogging,
+		cfg.applyTemplate,
+		cfg.parseDNS,
+		cfg.parseRoutes,
+		cfg.parseSysctls,
+		cfg.parsePartition,
+		cfg.parseBridgeOptions,
+		cfg.parseNeighbor,
+		cfg.parseVLANTrunk,
+		cfg.parseQinQ,
+		cfg.parseLinkNames,
+		func() error {
+			if cfg.DSCP != nil {
+				if err := checkDSCP(*cfg.DSCP); err != nil {
+					return nestedError("dscp", *cfg.DSCP, err)
+				}
+			}
+			return nil
+		},
+		cfg.parseConnLimit,
+		cfg.parsePortMappings,
+		cfg.parseBandwidth,
+		cfg.parseMasq,
+		cfg.parseConntrackZones,
+		cfg.parseEgressGateway,
+		cfg.parseServiceCIDR,
+		cfg.parseNodeLocalDNS,
+		cfg.parseRouteProto,
+		cfg.parseRollback,
+		cfg.parseVethNaming,
+		cfg.parseHostMAC,
+		cfg.parseExec,
+		cfg.parseCNIPath,
+		cfg.parseEncryptState,
+		cfg.parseAdmission,
+		cfg.parseExhaustionWarning,
+		cfg.parseReservationsFile,
+		cfg.parseDHCPLeases,
+		cfg.parseDefaultRoute,
+		cfg.parseSandbox,
+		cfg.parseCRISocket,
+		cfg.parseFeatures,
+		func() error {
+			if cfg.Subnet != "" {
+				return cfg.finishSubnet()
+			}
+			if cfg.ClusterSubnetNet == nil {
+				return fieldErrorf("subnet", nil, "is required")
+			}
+			// The subnet is carved from clusterSubnet at ADD time; see ApplySubnet.
+			return nil
+		},
+		func() error {
+			// finishSubnet covers the bounds when there is a subnet.
+			if cfg.Subnet == "" {
+				return cfg.checkRangeBounds()
+			}
+			return nil
+		},
+	)
+	return cfg, errs
+}
+
+// parseMode defaults and checks the attachment mode and its bridge.
+func (c *NetworkConfig) parseMode() error {
+	if c.Mode == "" {
+		c.Mode = ModeBridge
 	}
-	if cfg.Gateway == "" {
-		return nil, errors.New("gateway is required")
+	if c.Mode != ModeBridge && c.Mode != ModePTP {
+		return fieldErrorf("mode", c.Mode, "unsupported value")
 	}
-	if cfg.MTU == 0 {
-		cfg.MTU = DefaultMTU
+	if c.Mode == ModeBridge && c.Bridge == "" {
+		return fieldErrorf("bridge", nil, "is required")
 	}
-	if cfg.IPAM.DataDir == "" {
-		cfg.IPAM.DataDir = DefaultDataDir
+	if c.Mode == ModeBridge && c.Bridge == BridgeAuto {
+		c.Bridge = AutoBridgeName(c.Name)
 	}
+	return nil
+}
+
+// NeedsPartition reports whether the subnet must still be carved from clusterSubnet.
+func (c *NetworkConfig) NeedsPartition() bool {
+	return c.SubnetNet == nil && c.ClusterSubnetNet != nil
+}
+
+// ApplySubnet sets a carved subnet and runs the subnet-dependent validation.
+func (c *NetworkConfig) ApplySubnet(subnet *net.IPNet) error {
+	c.Subnet = subnet.String()
+	return c.finishSubnet()
+}
 
-	gatewayIP, err := parseIPv4(cfg.Gateway)
+// finishSubnet parses subnet, gateway, range, and static addresses.
+func (c *NetworkConfig) finishSubnet() error {
+	subnet, err := parseNetwork(c.Subnet)
 	if err != nil {
-		return nil, fmt.Errorf("gateway: %w", err)
+		return nestedError("subnet", c.Subnet, err)
 	}
-	cfg.GatewayIP = gatewayIP
+	subnetNet := netaddr.ToIPNet(subnet)
+	c.SubnetNet = subnetNet
+	b := newSubnetBounds(subnet)
 
-	_, subnetNet, err := net.ParseCIDR(cfg.Subnet)
-	if err != nil {
-		return nil, fmt.Errorf("subnet: invalid CIDR: %w", err)
+	if c.Gateway == "" {
+		if c.ClusterSubnetNet == nil {
+			return fieldErrorf("gateway", nil, "is required")
+		}
+		c.Gateway = b.network.Next().String()
 	}
-	if subnetNet.IP.To4() == nil {
-		return nil, errors.New("subnet: only IPv4 is supported")
+	gateway, err := parseAddr(c.Gateway)
+	if err != nil {
+		return nestedError("gateway", c.Gateway, err)
 	}
-	cfg.SubnetNet = subnetNet
+	c.GatewayIP = netaddr.ToIP(gateway)
 
-	if !subnetNet.Contains(gatewayIP) {
-		return nil, errors.New("gateway must be inside subnet")
+	if !subnet.Contains(gateway) {
+		return fieldErrorf("gateway", c.Gateway, "must be inside subnet")
 	}
-
-	networkIP, broadcastIP, err := networkAndBroadcast(subnetNet)
-	if err != nil {
-		return nil, err
+	if b.edge(gateway) {
+		return fieldErrorf("gateway", c.Gateway, "cannot be network or broadcast address")
 	}
-	if gatewayIP.Equal(networkIP) || gatewayIP.Equal(broadcastIP) {
-		return nil, errors.New("gateway cannot be network or broadcast address")
+	if c.NodeLocalDNSIP != nil && subnetNet.Contains(c.NodeLocalDNSIP) {
+		return fieldErrorf("nodeLocalDNS.ip", c.NodeLocalDNS.IP, "is inside subnet %s", subnetNet)
+	}
+	if c.ServiceCIDRNet != nil && overlaps(c.ServiceCIDRNet, subnetNet) {
+		return fieldErrorf("serviceCIDR", c.ServiceCIDR, "overlaps subnet %s", subnetNet)
 	}
 
-	if cfg.IPAM.RangeStart != "" {
-		cfg.RangeStartIP, err = parseIPv4(cfg.IPAM.RangeStart)
-		if err != nil {
-			return nil, fmt.Errorf("ipam.rangeStart: %w", err)
-		}
+	if err := c.checkRangeBounds(); err != nil {
+		return err
 	}
-	if cfg.IPAM.RangeEnd != "" {
-		cfg.RangeEndIP, err = parseIPv4(cfg.IPAM.RangeEnd)
-		if err != nil {
-			return nil, fmt.Errorf("ipam.rangeEnd: %w", err)
-		}
+	if c.IPAM.RangeStart != "" {
+		c.RangeStartIP, _ = parseIPv4(c.IPAM.RangeStart)
+		c.RangeEndIP, _ = parseIPv4(c.IPAM.RangeEnd)
 	}
-
-	if (cfg.IPAM.RangeStart == "") != (cfg.IPAM.RangeEnd == "") {
-		return nil, errors.New("ipam.rangeStart and ipam.rangeEnd must be set together")
+	if err := c.parseRanges(); err != nil {
+		return err
 	}
 
-	if cfg.RangeStartIP == nil && cfg.RangeEndIP == nil {
-		cfg.RangeStartIP, cfg.RangeEndIP, err = defaultRange(subnetNet)
+	if c.RangeStartIP == nil && c.RangeEndIP == nil {
+		start, end, err := b.defaultRange()
 		if err != nil {
-			return nil, err
+			return nestedError("subnet", c.Subnet, err)
 		}
+		c.RangeStartIP, c.RangeEndIP = netaddr.ToIP(start), netaddr.ToIP(end)
 	}
 
-	if !subnetNet.Contains(cfg.RangeStartIP) || !subnetNet.Contains(cfg.RangeEndIP) {
-		return nil, errors.New("ipam range must be inside subnet")
+	// Checked against their config entries, since c.Ranges is in priority
+	// order; ranges[0] is also the primary range checked below.
+	for i, r := range c.IPAM.Ranges {
+		start, _ := parseAddr(r.RangeStart)
+		end, _ := parseAddr(r.RangeEnd)
+		if err := b.checkRange(start, end); err != nil {
+			return nestedError(fmt.Sprintf("ipam.ranges[%d]", i), nil, err)
+		}
 	}
-	if ipv4ToUint(cfg.RangeStartIP) > ipv4ToUint(cfg.RangeEndIP) {
-		return nil, errors.New("ipam rangeStart must be <= rangeEnd")
+	start, end := c.RangeAddrs()
+	for _, bound := range []struct {
+		key  string
+		addr netip.Addr
+	}{{"ipam.rangeStart", start}, {"ipam.rangeEnd", end}} {
+		if !subnet.Contains(bound.addr) {
+			return fieldErrorf(bound.key, bound.addr.String(), "must be inside subnet")
+		}
+		if b.edge(bound.addr) {
+			return fieldErrorf(bound.key, bound.addr.String(), "cannot be network or broadcast")
+		}
 	}
-	if cfg.RangeStartIP.Equal(networkIP) || cfg.RangeStartIP.Equal(broadcastIP) {
-		return nil, errors.New("ipam rangeStart cannot be network or broadcast")
+	if end.Less(start) {
+		return fieldErrorf("ipam.rangeStart", start.String(), "must be <= rangeEnd")
 	}
-	if cfg.RangeEndIP.Equal(networkIP) || cfg.RangeEndIP.Equal(broadcastIP) {
-		return nil, errors.New("ipam rangeEnd cannot be network or broadcast")
+
+	if err := c.parseECMPGateways(b); err != nil {
+		return err
 	}
 
-	return cfg, nil
+	return c.parseStatic()
 }
 
-func parseIPv4(value string) (net.IP, error) {
-	ip := net.ParseIP(value)
-	if ip == nil {
-		return nil, errors.New("invalid IP address")
+// checkRangeBounds validates ipam.rangeStart and ipam.rangeEnd on their own;
+// finishSubnet checks them against the subnet.
+func (c *NetworkConfig) checkRangeBounds() error {
+	if c.IPAM.RangeStart != "" {
+		if _, err := parseIPv4(c.IPAM.RangeStart); err != nil {
+			return nestedError("ipam.rangeStart", c.IPAM.RangeStart, err)
+		}
+	}
+	if c.IPAM.RangeEnd != "" {
+		if _, err := parseIPv4(c.IPAM.RangeEnd); err != nil {
+			return nestedError("ipam.rangeEnd", c.IPAM.RangeEnd, err)
+		}
 	}
-	ip = ip.To4()
-	if ip == nil {
-		return nil, errors.New("only IPv4 is supported")
+	if (c.IPAM.RangeStart == "") != (c.IPAM.RangeEnd == "") {
+		return fieldErrorf("ipam.rangeStart", nil, "must be set together with ipam.rangeEnd")
 	}
-	return ip, nil
+	return nil
 }
 
-func defaultRange(subnet *net.IPNet) (net.IP, net.IP, error) {
-	ones, bits := subnet.Mask.Size()
-	if bits-ones < 2 {
-		return nil, nil, errors.New("subnet does not provide usable host addresses")
+// Identity extracts the network name and data dir without validating the rest
+// of the config, so even rejected configs can be attributed in metrics.
+func Identity(stdin []byte) (string, string) {
+	var cfg NetworkConfig
+	_ = json.Unmarshal(stdin, &cfg)
+	_ = cfg.applyEnv()
+	if cfg.IPAM.DataDir == "" {
+		cfg.IPAM.DataDir = DefaultDataDir
 	}
+	return cfg.Name, cfg.IPAM.DataDir
+}
 
-	networkIP, broadcastIP, err := networkAndBroadcast(subnet)
+// errNotIPv4 rejects IPv6 values.
+var errNotIPv4 = errors.New("only IPv4 is supported")
+
+// parseAddr parses an IPv4 address.
+func parseAddr(value string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(value)
 	if err != nil {
-		return nil, nil, err
+		return netip.Addr{}, errors.New("invalid IP address")
 	}
-	start := uintToIPv4(ipv4ToUint(networkIP) + 1)
-	end := uintToIPv4(ipv4ToUint(broadcastIP) - 1)
-	if ipv4ToUint(start) > ipv4ToUint(end) {
-		return nil, nil, errors.New("subnet does not provide usable host addresses")
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return netip.Addr{}, errNotIPv4
 	}
-	return start, end, nil
+	return addr, nil
 }
 
-func networkAndBroadcast(subnet *net.IPNet) (net.IP, net.IP, error) {
-	networkIP := subnet.IP.Mask(subnet.Mask).To4()
-	if networkIP == nil {
-		return nil, nil, errors.New("only IPv4 subnet is supported")
+// parseIPv4 is parseAddr for the net.IP fields of a parsed config.
+func parseIPv4(value string) (net.IP, error) {
+	addr, err := parseAddr(value)
+	if err != nil {
+		return nil, err
 	}
-	mask := net.IP(subnet.Mask).To4()
-	if mask == nil {
-		return nil, nil, errors.New("invalid subnet mask")
+	return netaddr.ToIP(addr), nil
+}
+
+// parseNetwork parses an IPv4 CIDR masked to its network, as net.ParseCIDR
+// returns it.
+func parseNetwork(value string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR: %w", err)
 	}
-	broadcast := make(net.IP, len(networkIP))
-	for i := range networkIP {
-		broadcast[i] = networkIP[i] | ^mask[i]
+	if !p.Addr().Unmap().Is4() {
+		return netip.Prefix{}, errNotIPv4
 	}
-	return networkIP, broadcast, nil
+	return p.Masked(), nil
+}
+
+// subnetBounds holds the network and broadcast addresses of a subnet.
+type subnetBounds struct {
+	subnet             netip.Prefix
+	network, broadcast netip.Addr
 }
 
-func ipv4ToUint(ip net.IP) uint32 {
-	ip = ip.To4()
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+func newSubnetBounds(subnet netip.Prefix) subnetBounds {
+	return subnetBounds{subnet: subnet, network: subnet.Addr(), broadcast: netaddr.Broadcast(subnet)}
 }
 
-func uintToIPv4(value uint32) net.IP {
-	return net.IPv4(
-		byte(value>>24),
-		byte(value>>16),
-		byte(value>>8),
-		byte(value),
-	).To4()
+// edge reports whether addr is the network or broadcast address.
+func (b subnetBounds) edge(addr netip.Addr) bool {
+	return addr == b.network || addr == b.broadcast
+}
+
+// defaultRange spans every host address of the subnet.
+func (b subnetBounds) defaultRange() (netip.Addr, netip.Addr, error) {
+	if b.subnet.Bits() > 30 {
+		return netip.Addr{}, netip.Addr{}, errors.New("provides no usable host addresses")
+	}
+	return b.network.Next(), b.broadcast.Prev(), nil
 }
@@ -1,8 +1,17 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
 )
 
 func TestParseValidConfigDefaults(t *testing.T) {
@@ -47,7 +56,7 @@ func TestParseRejectsGatewayOutsideSubnet(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected Parse() to fail")
 	}
-	if !strings.Contains(err.Error(), "gateway must be inside subnet") {
+	if !strings.Contains(err.Error(), "gateway: must be inside subnet") {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
@@ -67,7 +76,7 @@ func TestParseRejectsInvalidRangeOrder(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected Parse() to fail")
 	}
-	if !strings.Contains(err.Error(), "rangeStart must be <= rangeEnd") {
+	if !strings.Contains(err.Error(), "ipam.rangeStart: must be <= rangeEnd") {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
@@ -91,3 +100,1344 @@ func TestParseRejectsPartialRange(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestParseStaticIPAM(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipam":{
+			"type":"static",
+			"addresses":[{"address":"10.22.0.250/24"}],
+			"routes":[{"dst":"192.168.0.0/16","gw":"10.22.0.254"}]
+		}
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cfg.StaticAddrs) != 1 || cfg.StaticAddrs[0].Addr.String() != "10.22.0.250/24" {
+		t.Fatalf("unexpected static addresses: %+v", cfg.StaticAddrs)
+	}
+	if !cfg.StaticAddrs[0].Gateway.Equal(cfg.GatewayIP) {
+		t.Fatalf("expected default gateway, got %s", cfg.StaticAddrs[0].Gateway)
+	}
+	if len(cfg.StaticRoutes) != 1 || cfg.StaticRoutes[0].GW.String() != "10.22.0.254" {
+		t.Fatalf("unexpected static routes: %+v", cfg.StaticRoutes)
+	}
+
+	if err := cfg.ApplyCNIArgs("IgnoreUnknown=1;IP=10.22.0.240/24,10.22.0.241/24"); err != nil {
+		t.Fatalf("ApplyCNIArgs() error = %v", err)
+	}
+	if len(cfg.StaticAddrs) != 2 || cfg.StaticAddrs[1].Addr.IP.String() != "10.22.0.241" {
+		t.Fatalf("expected CNI_ARGS to replace addresses, got %+v", cfg.StaticAddrs)
+	}
+}
+
+func TestStaticIPAMRequiresAddresses(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipam":{"type":"static"}
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	err = cfg.ApplyCNIArgs("")
+	if err == nil || !strings.Contains(err.Error(), "requires ipam.addresses") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestParsePTPModeWithoutBridge(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"mode":"ptp",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1"
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Mode != ModePTP {
+		t.Fatalf("expected ptp mode, got %q", cfg.Mode)
+	}
+
+	_, err = Parse([]byte(strings.Replace(string(stdin), `"ptp"`, `"bridge"`, 1)))
+	if err == nil || !strings.Contains(err.Error(), "bridge: is required") {
+		t.Fatalf("expected bridge mode to require bridge, got %v", err)
+	}
+}
+
+func TestParseClusterSubnetDefersSubnet(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"clusterSubnet":"10.40.0.0/16",
+		"ipam":{"dataDir":"/tmp/atomicni"}
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !cfg.NeedsPartition() || cfg.PartitionPrefix != DefaultPartitionPrefix {
+		t.Fatalf("expected pending partition with default prefix, got %+v", cfg)
+	}
+	if cfg.PartitionIndex != "/tmp/atomicni/partitions/index.json" {
+		t.Fatalf("unexpected partition index: %q", cfg.PartitionIndex)
+	}
+
+	_, carved, _ := net.ParseCIDR("10.40.0.64/26")
+	if err := cfg.ApplySubnet(carved); err != nil {
+		t.Fatalf("ApplySubnet() error = %v", err)
+	}
+	if cfg.GatewayIP.String() != "10.40.0.65" {
+		t.Fatalf("expected default gateway 10.40.0.65, got %s", cfg.GatewayIP)
+	}
+	if cfg.RangeEndIP.String() != "10.40.0.126" {
+		t.Fatalf("unexpected rangeEnd %s", cfg.RangeEndIP)
+	}
+}
+
+func TestParseRangeGroupsSortedByPriority(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipam":{"ranges":[
+			{"rangeStart":"10.22.0.200","rangeEnd":"10.22.0.250","priority":10},
+			{"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.20"}
+		]}
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cfg.Ranges) != 2 || cfg.Ranges[0].Start.String() != "10.22.0.10" {
+		t.Fatalf("expected primary range first, got %+v", cfg.Ranges)
+	}
+	if cfg.RangeStartIP.String() != "10.22.0.10" || cfg.RangeEndIP.String() != "10.22.0.20" {
+		t.Fatalf("expected primary range exposed, got %s-%s", cfg.RangeStartIP, cfg.RangeEndIP)
+	}
+}
+
+func TestApplyCNIArgsPreferredIP(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1"
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := cfg.ApplyCNIArgs("K8S_POD_NAME=api;IP=10.22.0.42"); err != nil {
+		t.Fatalf("ApplyCNIArgs() error = %v", err)
+	}
+	if cfg.PreferredIP.String() != "10.22.0.42" {
+		t.Fatalf("expected preferred IP 10.22.0.42, got %s", cfg.PreferredIP)
+	}
+	if err := cfg.ApplyCNIArgs("IP=bogus"); err == nil {
+		t.Fatalf("expected invalid hint to fail")
+	}
+}
+
+func TestParseVLANTrunk(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"vlanTrunk":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `[{"id":300},{"minID":100,"maxID":102},{"id":101}]`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if fmt.Sprint(cfg.VLANs) != "[100 101 102 300]" {
+		t.Fatalf("VLANs = %v", cfg.VLANs)
+	}
+
+	for _, bad := range []string{`[{"id":0}]`, `[{"id":4095}]`, `[{"minID":10}]`, `[{"minID":20,"maxID":10}]`, `[{"id":5,"minID":1,"maxID":9}]`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, bad))); err == nil || !strings.Contains(err.Error(), "vlanTrunk") {
+			t.Fatalf("vlanTrunk %s: expected error, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseQinQDefaultsNames(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"qinq":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{"master":"eth1","sVlan":100,"cVlan":10}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.QinQ.OuterName != "eth1.100" || cfg.QinQ.InnerName != "eth1.100.10" {
+		t.Fatalf("unexpected names %q/%q", cfg.QinQ.OuterName, cfg.QinQ.InnerName)
+	}
+
+	for _, bad := range []string{`{"sVlan":100,"cVlan":10}`, `{"master":"eth1","sVlan":0,"cVlan":10}`, `{"master":"enp0s31f6","sVlan":1000,"cVlan":1000}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, bad))); err == nil || !strings.Contains(err.Error(), "qinq") {
+			t.Fatalf("qinq %s: expected error, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseConnLimit(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"connLimit":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{"perSecond":20}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.ConnLimit.Burst != 20 {
+		t.Fatalf("burst = %d, want default of perSecond", cfg.ConnLimit.Burst)
+	}
+	for _, bad := range []string{`{"perSecond":0}`, `{"perSecond":5,"burst":-1}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, bad))); err == nil || !strings.Contains(err.Error(), "connLimit") {
+			t.Fatalf("connLimit %s: expected error, got %v", bad, err)
+		}
+	}
+}
+
+func TestParsePortMappingsCoalescesRanges(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"runtimeConfig":{"portMappings":%s}
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `[
+		{"hostPort":8001,"containerPort":81},
+		{"hostPort":8000,"containerPort":80},
+		{"hostPort":8002,"hostPortEnd":8004,"containerPort":82},
+		{"hostPort":8005,"containerPort":90},
+		{"hostPort":8000,"containerPort":80,"protocol":"UDP"}
+	]`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := []PortRange{
+		{Protocol: "tcp", HostStart: 8000, HostEnd: 8004, ContainerStart: 80},
+		{Protocol: "tcp", HostStart: 8005, HostEnd: 8005, ContainerStart: 90},
+		{Protocol: "udp", HostStart: 8000, HostEnd: 8000, ContainerStart: 80},
+	}
+	if fmt.Sprint(cfg.PortRanges) != fmt.Sprint(want) {
+		t.Fatalf("PortRanges = %+v, want %+v", cfg.PortRanges, want)
+	}
+
+	for _, bad := range []string{
+		`[{"hostPort":0,"containerPort":80}]`,
+		`[{"hostPort":80,"containerPort":80,"protocol":"icmp"}]`,
+		`[{"hostPort":90,"hostPortEnd":80,"containerPort":80}]`,
+		`[{"hostPort":1000,"hostPortEnd":1010,"containerPort":65530}]`,
+		`[{"hostPort":8000,"hostPortEnd":8010,"containerPort":80},{"hostPort":8010,"containerPort":90}]`,
+		`[{"hostPort":8080,"containerPort":80,"hostIP":"10.0.0.1"},{"hostPort":8080,"containerPort":81}]`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, bad))); err == nil || !strings.Contains(err.Error(), "portMappings") {
+			t.Fatalf("portMappings %s: expected error, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseSNAT(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"ipMasq":%t,
+		"snat":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, true, `{"portRange":"10000-20000","egressIP":"192.0.2.10"}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.SNATPortMin != 10000 || cfg.SNATPortMax != 20000 || cfg.EgressIP.String() != "192.0.2.10" {
+		t.Fatalf("unexpected snat: %d-%d %v", cfg.SNATPortMin, cfg.SNATPortMax, cfg.EgressIP)
+	}
+
+	for _, tc := range []struct {
+		masq bool
+		snat string
+	}{
+		{false, `{"portRange":"10000-20000"}`},
+		{true, `{"portRange":"20000-10000"}`},
+		{true, `{"portRange":"1024"}`},
+		{true, `{"egressIP":"2001:db8::1"}`},
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, tc.masq, tc.snat))); err == nil || !strings.Contains(err.Error(), "snat") {
+			t.Fatalf("ipMasq=%t snat %s: expected error, got %v", tc.masq, tc.snat, err)
+		}
+	}
+}
+
+func TestParseEgressGateway(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"egressGateway":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{"ip":"10.22.0.5","table":200}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.EgressGatewayIP.String() != "10.22.0.5" || cfg.EgressGateway.Table != 200 || !cfg.UseEgressGateway {
+		t.Fatalf("unexpected egress gateway: %v %+v %t", cfg.EgressGatewayIP, cfg.EgressGateway, cfg.UseEgressGateway)
+	}
+	if err := cfg.ApplyCNIArgs("EGRESS_GATEWAY=false"); err != nil || cfg.UseEgressGateway {
+		t.Fatalf("EGRESS_GATEWAY=false: use=%t err=%v", cfg.UseEgressGateway, err)
+	}
+	if err := cfg.ApplyCNIArgs("EGRESS_GATEWAY=maybe"); err == nil {
+		t.Fatal("EGRESS_GATEWAY=maybe: expected error")
+	}
+
+	backed, err := Parse([]byte(fmt.Sprintf(base, `{"ip":"10.22.0.5","backup":"10.22.0.6"}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if g := backed.EgressGateway; backed.EgressBackupIP.String() != "10.22.0.6" || g.Probe != ProbeICMP ||
+		g.ProbeInterval != DefaultProbeInterval || g.ProbeFailures != DefaultProbeFailures {
+		t.Fatalf("unexpected backup gateway: %v %+v", backed.EgressBackupIP, g)
+	}
+
+	for _, gw := range []string{
+		`{"ip":"2001:db8::1"}`, `{"ip":"10.22.0.5","table":254}`, `{"table":100}`,
+		`{"ip":"10.22.0.5","backup":"10.22.0.5"}`,
+		`{"ip":"10.22.0.5","backup":"10.22.0.6","probe":"tcp"}`,
+		`{"ip":"10.22.0.5","backup":"10.22.0.6","probeFailures":-1}`,
+		`{"ip":"10.22.0.5","probe":"arp"}`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, gw))); err == nil || !strings.Contains(err.Error(), "egressGateway") {
+			t.Fatalf("egressGateway %s: expected error, got %v", gw, err)
+		}
+	}
+
+	plain, err := Parse([]byte(fmt.Sprintf(base, "null")))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := plain.ApplyCNIArgs("EGRESS_GATEWAY=true"); err == nil {
+		t.Fatal("EGRESS_GATEWAY=true without egressGateway: expected error")
+	}
+}
+
+func TestParseServiceCIDR(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `"serviceCIDR":"10.96.0.0/12","serviceHostRoute":"192.0.2.1"`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.ServiceCIDRNet.String() != "10.96.0.0/12" || cfg.ServiceHostVia.String() != "192.0.2.1" {
+		t.Fatalf("unexpected service route: %v via %v", cfg.ServiceCIDRNet, cfg.ServiceHostVia)
+	}
+	cfg, err = Parse([]byte(fmt.Sprintf(base, `"serviceCIDR":"10.96.0.0/12","serviceHostRoute":"blackhole"`)))
+	if err != nil || cfg.ServiceHostVia != nil {
+		t.Fatalf("blackhole: via=%v err=%v", cfg.ServiceHostVia, err)
+	}
+
+	for _, extra := range []string{
+		`"serviceCIDR":"fd00::/108"`,
+		`"serviceCIDR":"10.22.0.0/16"`,
+		`"serviceCIDR":"10.96.0.0/12","serviceHostRoute":"nowhere"`,
+		`"serviceHostRoute":"blackhole"`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, extra))); err == nil || !strings.Contains(err.Error(), "service") {
+			t.Fatalf("%s: expected error, got %v", extra, err)
+		}
+	}
+}
+
+func TestParseNodeLocalDNS(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `"nodeLocalDNS":{"ip":"169.254.20.10","target":"10.0.0.53"}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.NodeLocalDNSIP.String() != "169.254.20.10" || cfg.NodeLocalDNSTarget.String() != "10.0.0.53" {
+		t.Fatalf("unexpected node-local dns: %v -> %v", cfg.NodeLocalDNSIP, cfg.NodeLocalDNSTarget)
+	}
+
+	for _, extra := range []string{
+		`"nodeLocalDNS":{"ip":"fd00::a"}`,
+		`"nodeLocalDNS":{"ip":"10.22.0.53"}`,
+		`"nodeLocalDNS":{"ip":"169.254.20.10","target":"cache"}`,
+		`"nodeLocalDNS":{"ip":"169.254.20.10","target":"10.0.0.53"},"conntrackZones":true`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, extra))); err == nil || !strings.Contains(err.Error(), "nodeLocalDNS") {
+			t.Fatalf("%s: expected error, got %v", extra, err)
+		}
+	}
+}
+
+func TestParseBandwidth(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"runtimeConfig":{"bandwidth":%s}
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{"ingressRate":1000000,"ingressBurst":10000}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.RuntimeConfig.Bandwidth.IngressRate != 1000000 || cfg.RuntimeConfig.Bandwidth.EgressRate != 0 {
+		t.Fatalf("unexpected bandwidth: %+v", cfg.RuntimeConfig.Bandwidth)
+	}
+	for _, bw := range []string{`{"ingressRate":1000000}`, `{"egressBurst":10000}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, bw))); err == nil || !strings.Contains(err.Error(), "bandwidth") {
+			t.Fatalf("bandwidth %s: expected error, got %v", bw, err)
+		}
+	}
+}
+
+func TestParseRollback(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1"%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, "")))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.RollbackPolicy() != RollbackFull || cfg.RollbackRetryWindow() != DefaultRollbackRetryWindow {
+		t.Fatalf("defaults: policy %q, window %s", cfg.RollbackPolicy(), cfg.RollbackRetryWindow())
+	}
+	cfg, err = Parse([]byte(fmt.Sprintf(base, `,"rollback":{"policy":"keep-allocation","retryWindow":60}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.RollbackPolicy() != RollbackKeepAllocation || cfg.RollbackRetryWindow() != time.Minute {
+		t.Fatalf("policy %q, window %s", cfg.RollbackPolicy(), cfg.RollbackRetryWindow())
+	}
+	for _, r := range []string{`{"policy":"partial"}`, `{"retryWindow":-1}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, `,"rollback":`+r))); err == nil || !strings.Contains(err.Error(), "rollback") {
+			t.Fatalf("rollback %s: expected error, got %v", r, err)
+		}
+	}
+}
+
+func TestParseBridgeOptions(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"mode":%q,
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"bridgeOptions":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, ModeBridge, `{"ageingTime":30,"mcastSnooping":true,"mcastQuerier":true}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if *cfg.BridgeOptions.AgeingTime != 30 || !*cfg.BridgeOptions.McastQuerier {
+		t.Fatalf("unexpected bridge options: %+v", cfg.BridgeOptions)
+	}
+
+	for _, tc := range []struct{ mode, opts string }{
+		{ModeBridge, `{"ageingTime":-1}`},
+		{ModeBridge, `{"ageingTime":1000001}`},
+		{ModeBridge, `{"mcastSnooping":false,"mcastQuerier":true}`},
+		{ModePTP, `{"ageingTime":30}`},
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, tc.mode, tc.opts))); err == nil || !strings.Contains(err.Error(), "bridgeOptions") {
+			t.Fatalf("%s bridgeOptions %s: expected error, got %v", tc.mode, tc.opts, err)
+		}
+	}
+
+	ptp := `{"cniVersion":"1.1.0","name":"atomic-net","type":"atomicni","mode":"ptp","subnet":"10.22.0.0/24","gateway":"10.22.0.1","isolatePods":true}`
+	if _, err := Parse([]byte(ptp)); err == nil || !strings.Contains(err.Error(), "isolatePods") {
+		t.Fatalf("ptp isolatePods: expected error, got %v", err)
+	}
+}
+
+func TestParseConntrackZonesRejectsNAT(t *testing.T) {
+	if !buildinfo.Firewall {
+		t.Skip("built with atomicni_nofirewall")
+	}
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"conntrackZones":true,
+		%s
+	}`
+
+	if _, err := Parse([]byte(fmt.Sprintf(base, `"mtu":1500`))); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	for _, extra := range []string{
+		`"ipMasq":true`,
+		`"runtimeConfig":{"portMappings":[{"hostPort":8080,"containerPort":80}]}`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, extra))); err == nil || !strings.Contains(err.Error(), "conntrackZones") {
+			t.Fatalf("%s: expected error, got %v", extra, err)
+		}
+	}
+}
+
+func TestParseNeighbor(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"mode":%q,
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"neighbor":%s
+	}`
+
+	cfg, err := Parse([]byte(fmt.Sprintf(base, ModeBridge, `{"gcThresh1":1024,"gcThresh3":8192,"baseReachableTime":60}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Neighbor.GCThresh3 != 8192 || cfg.Neighbor.BaseReachableTime != 60 {
+		t.Fatalf("unexpected neighbor config: %+v", cfg.Neighbor)
+	}
+
+	for _, tc := range []struct{ mode, neigh string }{
+		{ModeBridge, `{"gcThresh1":-1}`},
+		{ModeBridge, `{"gcThresh2":4096,"gcThresh3":2048}`},
+		{ModeBridge, `{"gcThresh1":4096,"gcThresh3":2048}`},
+		{ModePTP, `{"baseReachableTime":60}`},
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, tc.mode, tc.neigh))); err == nil || !strings.Contains(err.Error(), "neighbor") {
+			t.Fatalf("%s neighbor %s: expected error, got %v", tc.mode, tc.neigh, err)
+		}
+	}
+}
+
+func TestNetipAccessors(t *testing.T) {
+	cfg, err := Parse([]byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1"
+	}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := cfg.SubnetPrefix().String(); got != "10.22.0.0/24" {
+		t.Fatalf("SubnetPrefix() = %s", got)
+	}
+	if gw := cfg.GatewayAddr(); !gw.Is4() || gw.String() != "10.22.0.1" {
+		t.Fatalf("GatewayAddr() = %s", gw)
+	}
+	start, end := cfg.RangeAddrs()
+	if start.String() != "10.22.0.1" || end.String() != "10.22.0.254" {
+		t.Fatalf("RangeAddrs() = %s-%s", start, end)
+	}
+}
+
+func TestParseDetailed(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		%s
+	}`
+
+	tests := []struct {
+		extra string
+		want  FieldError
+	}{
+		{`"gateway":"10.9.0.1"`, FieldError{Path: "gateway", Value: `"10.9.0.1"`, Constraint: "must be inside subnet"}},
+		{`"gateway":"10.22.0.1","mtu":"big"`, FieldError{Path: "mtu", Value: "string", Constraint: "must be a number", Line: 7, Column: 36}},
+		{`"gateway":"10.22.0.1","ipam":{"type":"static","addresses":[{"address":"10.9.0.5/24"}]}`, FieldError{Path: "ipam.addresses[0].address", Value: `"10.9.0.5/24"`, Constraint: "must be inside subnet"}},
+		{`"gateway":"10.22.0.1","ipam":{"ranges":[{"rangeStart":"10.22.0.30","rangeEnd":"10.23.0.1","priority":1},{"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.20"}]}`, FieldError{Path: "ipam.ranges[0].rangeEnd", Value: `"10.23.0.1"`, Constraint: "must be inside subnet"}},
+		{`"gateway":"10.22.0.1","runtimeConfig":{"portMappings":[{"hostPort":70000,"containerPort":80}]}`, FieldError{Path: "runtimeConfig.portMappings[0].hostPort", Value: "70000", Constraint: "must be within 1-65535"}},
+		{`"gateway":"10.22.0.1",,`, FieldError{Constraint: "invalid JSON: invalid character ',' looking for beginning of object key string", Line: 7, Column: 26}},
+	}
+	for _, tc := range tests {
+		_, err := ParseDetailed([]byte(fmt.Sprintf(base, tc.extra)))
+		fe, ok := err.(*FieldError)
+		if !ok {
+			t.Fatalf("%s: expected *FieldError, got %T %v", tc.extra, err, err)
+		}
+		if *fe != tc.want {
+			t.Fatalf("%s: got %#v, want %#v", tc.extra, *fe, tc.want)
+		}
+	}
+
+	if _, err := ParseDetailed([]byte(fmt.Sprintf(base, `"gateway":"10.22.0.1"`))); err != nil {
+		t.Fatalf("ParseDetailed() error = %v", err)
+	}
+}
+
+func FuzzParseDetailed(f *testing.F) {
+	f.Add([]byte(`{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"}`))
+	f.Add([]byte(`{"name":"n","ipam":{"ranges":[{"rangeStart":5}]}}`))
+	f.Add([]byte(`{"vlanTrunk":[{"id":5000}],`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cfg, err := ParseDetailed(data)
+		if err == nil {
+			if cfg == nil {
+				t.Fatal("nil config without error")
+			}
+			return
+		}
+		if _, ok := err.(*FieldError); !ok {
+			t.Fatalf("error %T is not a *FieldError: %v", err, err)
+		}
+	})
+}
+
+func TestParseEnvOverrides(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		"logLevel":"info",
+		"timeout":30,
+		"ipam":{"dataDir":"/from/stdin"}
+	}`)
+
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.IPAM.DataDir != "/from/stdin" || cfg.LogLevel != LogLevelInfo || cfg.Timeout != 30 {
+		t.Fatalf("unexpected config: dataDir=%s logLevel=%s timeout=%d", cfg.IPAM.DataDir, cfg.LogLevel, cfg.Timeout)
+	}
+
+	t.Setenv(EnvDataDir, "/from/env")
+	t.Setenv(EnvLogLevel, LogLevelDebug)
+	t.Setenv(EnvTimeout, "5")
+	cfg, err = Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.IPAM.DataDir != "/from/env" || cfg.LogLevel != LogLevelDebug || cfg.Timeout != 5 {
+		t.Fatalf("env did not win: dataDir=%s logLevel=%s timeout=%d", cfg.IPAM.DataDir, cfg.LogLevel, cfg.Timeout)
+	}
+	if _, dataDir := Identity(stdin); dataDir != "/from/env" {
+		t.Fatalf("Identity() dataDir = %s", dataDir)
+	}
+
+	for name, value := range map[string]string{EnvTimeout: "soon", EnvLogLevel: "trace"} {
+		t.Setenv(name, value)
+		if _, err := Parse(stdin); err == nil {
+			t.Fatalf("%s=%s: expected error", name, value)
+		}
+		t.Setenv(name, "")
+	}
+}
+
+func TestFileConfs(t *testing.T) {
+	list := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"plugins":[
+			{"type":"atomicni","bridge":"atomic0","subnet":"10.22.0.0/24","gateway":"10.22.0.1"},
+			{"type":"bandwidth"}
+		]
+	}`)
+	confs, err := FileConfs(list)
+	if err != nil {
+		t.Fatalf("FileConfs() error = %v", err)
+	}
+	if len(confs) != 1 {
+		t.Fatalf("expected one atomicni entry, got %d", len(confs))
+	}
+	cfg, err := Parse(confs[0])
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Name != "atomic-net" || cfg.CNIVersion != "1.1.0" {
+		t.Fatalf("list name/cniVersion not injected: %+v", cfg)
+	}
+
+	for _, raw := range []string{
+		`{"type":"bridge"}`,
+		`{"name":"n","plugins":[{"type":"bandwidth"}]}`,
+		`{"plugins":`,
+	} {
+		if _, err := FileConfs([]byte(raw)); err == nil {
+			t.Fatalf("%s: expected error", raw)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	problems := Validate([]byte(`{
+		"_comment":"ignored",
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.9.0.1",
+		"mtuu":9000,
+		"args":{"labels":[]},
+		"ipam":{"datadir":"/x","ranges":[{"rangeStart":"10.22.0.2","rangeEnd":"10.22.0.9","prio":1}]},
+		"runtimeConfig":{"bandwidth":{}}
+	}`))
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Path+": "+p.Constraint)
+	}
+	want := []string{
+		"ipam.datadir: unknown field",
+		"ipam.ranges[0].prio: unknown field",
+		"mtuu: unknown field",
+		"gateway: must be inside subnet",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if problems := Validate([]byte(`{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"}`)); problems != nil {
+		t.Fatalf("expected no problems, got %v", problems)
+	}
+}
+
+func TestValidateReportsEveryFailingStep(t *testing.T) {
+	problems := Validate([]byte(`{"cniVersion":"1.1.0","type":"atomicni","bridge":"b","gateway":"10.99.0.1","mtu":99999,"ipam":{"rangeStart":"1.2.3.4"}}`))
+
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.Error())
+	}
+	want := []string{
+		"name: is required",
+		"mtu: out of range 68-65535 (got 99999)",
+		"subnet: is required",
+		"ipam.rangeStart: must be set together with ipam.rangeEnd",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Validate() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if _, err := Parse([]byte(`{"cniVersion":"1.1.0","type":"atomicni","bridge":"b","mtu":99999}`)); err == nil || err.Error() != "name: is required" {
+		t.Fatalf("Parse() must stop at the first failure, got %v", err)
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	stdin := []byte(`{
+		"cniVersion":"1.1.0",
+		"name":"lab",
+		"type":"atomicni",
+		"networks":[
+			{"name":"lab-a","bridge":"br-a","subnet":"10.10.0.0/24","gateway":"10.10.0.1"},
+			{"name":"lab-b","mode":"ptp","subnet":"10.20.0.0/24","gateway":"10.20.0.1","mtu":9000}
+		]
+	}`)
+	cfg, err := Parse(stdin)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cfg.Members) != 2 || cfg.Members[1].Config.MTU != 9000 || cfg.Members[1].Config.CNIVersion != "1.1.0" {
+		t.Fatalf("unexpected members: %+v", cfg.Members)
+	}
+	if _, err := Parse(cfg.Members[0].Stdin); err != nil {
+		t.Fatalf("member stdin does not parse: %v", err)
+	}
+
+	_, err = ParseDetailed([]byte(`{"cniVersion":"1.1.0","name":"lab","type":"atomicni","networks":[
+		{"name":"lab-a","bridge":"br-a","subnet":"10.10.0.0/24","gateway":"10.99.0.1"}]}`))
+	if fe, ok := err.(*FieldError); !ok || fe.Path != "networks[0].gateway" {
+		t.Fatalf("expected networks[0].gateway error, got %#v", err)
+	}
+	if _, err := Parse([]byte(`{"name":"lab","networks":[{"name":"a","bridge":"b","subnet":"10.10.0.0/24","gateway":"10.10.0.1"},
+		{"name":"a","bridge":"c","subnet":"10.20.0.0/24","gateway":"10.20.0.1"}]}`)); err == nil {
+		t.Fatalf("expected duplicate name error")
+	}
+	if problems := Validate([]byte(`{"cniVersion":"1.1.0","name":"lab","type":"atomicni","networks":[
+		{"name":"lab-a","bridge":"br-a","subnet":"10.10.0.0/24","gateway":"10.10.0.1","brige":"x"}]}`)); len(problems) != 1 || problems[0].Path != "networks[0].brige" {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+}
+
+func TestParseRouteProto(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","routeProto":%d}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, 201)))
+	if err != nil || cfg.RouteProto != 201 {
+		t.Fatalf("Parse() = %+v, %v", cfg, err)
+	}
+	for _, proto := range []int{4, 256, -1} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, proto))); err == nil || !strings.Contains(err.Error(), "routeProto") {
+			t.Fatalf("routeProto %d: expected error, got %v", proto, err)
+		}
+	}
+}
+
+func TestParseCRISocket(t *testing.T) {
+	if !buildinfo.K8s {
+		if _, err := Parse([]byte(`{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","criSocket":"/run/containerd/containerd.sock"}`)); err == nil || !strings.Contains(err.Error(), "criSocket: needs Kubernetes support") {
+			t.Fatalf("Parse() error = %v, want criSocket rejected without Kubernetes support", err)
+		}
+		return
+	}
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","criSocket":%q}`
+	for _, socket := range []string{"/run/containerd/containerd.sock", "unix:///var/run/crio/crio.sock"} {
+		if cfg, err := Parse([]byte(fmt.Sprintf(base, socket))); err != nil || cfg.CRISocket != socket {
+			t.Fatalf("criSocket %q: Parse() = %+v, %v", socket, cfg, err)
+		}
+	}
+	for _, socket := range []string{"containerd.sock", "tcp://10.0.0.1:1234", "unix://run/crio.sock"} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, socket))); err == nil || !strings.Contains(err.Error(), "criSocket") {
+			t.Fatalf("criSocket %q: expected error, got %v", socket, err)
+		}
+	}
+
+	cfg, err := Parse([]byte(`{"cniVersion":"1.1.0","name":"lab","type":"atomicni","criSocket":"/run/containerd/containerd.sock","networks":[
+		{"name":"lab-a","bridge":"br-a","subnet":"10.10.0.0/24","gateway":"10.10.0.1"},
+		{"name":"lab-b","bridge":"br-b","subnet":"10.20.0.0/24","gateway":"10.20.0.1","criSocket":"/run/crio/crio.sock"}]}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.Members[0].Config.CRISocket != "/run/containerd/containerd.sock" || cfg.Members[1].Config.CRISocket != "/run/crio/crio.sock" {
+		t.Fatalf("members do not inherit criSocket: %q %q", cfg.Members[0].Config.CRISocket, cfg.Members[1].Config.CRISocket)
+	}
+}
+
+func TestParseFeatures(t *testing.T) {
+	_, err := Parse([]byte(`{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipMasq":true}`))
+	if buildinfo.Firewall && err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !buildinfo.Firewall && (err == nil || !strings.Contains(err.Error(), "ipMasq: needs firewall support")) {
+		t.Fatalf("Parse() error = %v, want ipMasq rejected without firewall support", err)
+	}
+}
+
+func TestParseAutoBridge(t *testing.T) {
+	cfg, err := Parse([]byte(`{"cniVersion":"1.1.0","name":"lab","type":"atomicni","networks":[
+		{"name":"lab-a","bridge":"auto","subnet":"10.10.0.0/24","gateway":"10.10.0.1"},
+		{"name":"lab-b","bridge":"auto","subnet":"10.20.0.0/24","gateway":"10.20.0.1"}]}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	a, b := cfg.Members[0].Config.Bridge, cfg.Members[1].Config.Bridge
+	if a != AutoBridgeName("lab-a") || b != AutoBridgeName("lab-b") || a == b {
+		t.Fatalf("auto bridges = %q, %q", a, b)
+	}
+	if len(a) != 15 || !strings.HasPrefix(a, "atomic-") {
+		t.Fatalf("AutoBridgeName() = %q, want atomic- plus 8 hex digits", a)
+	}
+	again, err := Parse(cfg.Members[0].Stdin)
+	if err != nil || again.Bridge != a {
+		t.Fatalf("member re-parse bridge = %v, %v; want %q", again, err, a)
+	}
+}
+
+func TestCheckDeviceID(t *testing.T) {
+	base := `{
+		"cniVersion":"1.1.0",
+		"name":"atomic-net",
+		"type":"atomicni",
+		"bridge":"atomic0",
+		"subnet":"10.22.0.0/24",
+		"gateway":"10.22.0.1",
+		%s
+	}`
+	for _, extra := range []string{
+		`"deviceID":"0000:3b:02.1"`,
+		`"runtimeConfig":{"deviceID":"0000:3b:02.1"}`,
+	} {
+		cfg, err := Parse([]byte(fmt.Sprintf(base, extra)))
+		if err != nil {
+			t.Fatalf("%s: Parse() error = %v; DEL and GC must still parse it", extra, err)
+		}
+		if err := cfg.CheckDeviceID(); err == nil || !strings.Contains(err.Error(), "SR-IOV is not supported") {
+			t.Fatalf("%s: expected rejection, got %v", extra, err)
+		}
+		if problems := Validate([]byte(fmt.Sprintf(base, extra))); len(problems) != 1 || !strings.Contains(problems[0].Error(), "SR-IOV") {
+			t.Fatalf("%s: Validate() = %v", extra, problems)
+		}
+	}
+}
+
+func TestParseEncryptState(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{%s}}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `"encryptState":true`)))
+	if err != nil || cfg.IPAM.StateKey != statecrypt.DefaultKeySource {
+		t.Fatalf("Parse() = %+v, %v; want default key", cfg, err)
+	}
+	cfg, err = Parse([]byte(fmt.Sprintf(base, `"encryptState":true,"stateKey":"keyring:atomicni-state"`)))
+	if err != nil || cfg.IPAM.StateKey != "keyring:atomicni-state" {
+		t.Fatalf("Parse() = %+v, %v", cfg, err)
+	}
+	for _, ipam := range []string{
+		`"stateKey":"file:/k"`,
+		`"encryptState":true,"stateKey":"/etc/atomicni/state.key"`,
+		`"encryptState":true,"stateKey":"file:state.key"`,
+		`"encryptState":true,"stateKey":"keyring:"`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, ipam))); err == nil || !strings.Contains(err.Error(), "ipam.stateKey") {
+			t.Fatalf("%s: expected error, got %v", ipam, err)
+		}
+	}
+}
+
+func TestParseExec(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","exec":%s}`
+	stdin := []byte(fmt.Sprintf(base, `{"allow":["ip","nft"],"audit":true}`))
+	if _, err := Parse(stdin); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := ExecSettings(stdin); !slices.Equal(got.Allow, []string{"ip", "nft"}) || !got.Audit {
+		t.Fatalf("ExecSettings() = %+v", got)
+	}
+	for _, exec := range []string{`{"allow":[]}`, `{"allow":["/usr/sbin/ip"]}`, `{"allow":[""]}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, exec))); err == nil || !strings.Contains(err.Error(), "exec.allow") {
+			t.Fatalf("%s: expected error, got %v", exec, err)
+		}
+	}
+}
+
+func TestFindDelegate(t *testing.T) {
+	override, envDir, missing := t.TempDir(), t.TempDir(), t.TempDir()
+	for path, mode := range map[string]os.FileMode{
+		filepath.Join(override, "host-local"): 0o755,
+		filepath.Join(envDir, "host-local"):   0o755,
+		filepath.Join(envDir, "dhcp"):         0o755,
+		filepath.Join(envDir, "notes"):        0o644,
+	} {
+		if err := os.WriteFile(path, nil, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv(EnvCNIPath, missing+string(os.PathListSeparator)+envDir)
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"%s}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `,"cniPath":["`+override+`"]`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got, err := cfg.FindDelegate("host-local"); err != nil || got != filepath.Join(override, "host-local") {
+		t.Fatalf("FindDelegate(host-local) = %q, %v; want the cniPath copy", got, err)
+	}
+	if got, err := cfg.FindDelegate("dhcp"); err != nil || got != filepath.Join(envDir, "dhcp") {
+		t.Fatalf("FindDelegate(dhcp) = %q, %v", got, err)
+	}
+	for _, name := range []string{"notes", "static"} {
+		_, err := cfg.FindDelegate(name)
+		if err == nil {
+			t.Fatalf("FindDelegate(%s) succeeded", name)
+		}
+		for _, dir := range []string{override, missing, envDir} {
+			if !strings.Contains(err.Error(), dir) {
+				t.Fatalf("FindDelegate(%s) error %q does not list %s", name, err, dir)
+			}
+		}
+	}
+	if _, err := cfg.FindDelegate("../bin/sh"); err == nil {
+		t.Fatal("FindDelegate accepted a path")
+	}
+	t.Setenv(EnvCNIPath, "")
+	if _, err := (&NetworkConfig{}).FindDelegate("dhcp"); err == nil || !strings.Contains(err.Error(), EnvCNIPath) {
+		t.Fatalf("expected missing CNI_PATH error, got %v", err)
+	}
+	if _, err := Parse([]byte(fmt.Sprintf(base, `,"cniPath":["opt/cni/bin"]`))); err == nil || !strings.Contains(err.Error(), "cniPath") {
+		t.Fatalf("expected cniPath error, got %v", err)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	templates := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(templates, []byte(`{"templates":{"lab":{
+		"dns":{"nameservers":["10.96.0.10"],"domain":"lab"},
+		"routes":[{"dst":"10.50.0.0/16"}],
+		"sysctls":{"net.ipv4.ip_forward":"0","net.core.somaxconn":"1024"}
+	}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"%s}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `,"template":"lab","templatesFile":"`+templates+`",
+		"routes":[{"dst":"192.168.0.0/16","gw":"10.22.0.254"}],"sysctls":{"net.ipv4.ip_forward":"1"}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.DNS == nil || cfg.DNS.Domain != "lab" {
+		t.Fatalf("DNS = %+v, want the template's", cfg.DNS)
+	}
+	if len(cfg.ExtraRoutes) != 2 || cfg.ExtraRoutes[0].Dst.String() != "10.50.0.0/16" || cfg.ExtraRoutes[0].GW != nil ||
+		!cfg.ExtraRoutes[1].GW.Equal(net.ParseIP("10.22.0.254")) {
+		t.Fatalf("ExtraRoutes = %+v, want the template's then the network's", cfg.ExtraRoutes)
+	}
+	if len(cfg.Sysctls) != 2 || cfg.Sysctls["net.ipv4.ip_forward"] != "1" || cfg.Sysctls["net.core.somaxconn"] != "1024" {
+		t.Fatalf("Sysctls = %v, want the network's to win", cfg.Sysctls)
+	}
+	cfg, err = Parse([]byte(fmt.Sprintf(base, `,"template":"lab","templatesFile":"`+templates+`","dns":{"nameservers":["1.1.1.1"]}`)))
+	if err != nil || cfg.DNS.Domain != "" || cfg.DNS.Nameservers[0] != "1.1.1.1" {
+		t.Fatalf("Parse() = %+v, %v; want the network's dns block", cfg.DNS, err)
+	}
+
+	for extra, want := range map[string]string{
+		`,"template":"prod","templatesFile":"` + templates + `"`:  "is not defined",
+		`,"template":"lab","templatesFile":"/nonexistent/t.json"`: "template:",
+		`,"templatesFile":"` + templates + `"`:                    "templatesFile: requires template",
+		`,"sysctls":{"kernel.pid_max":"1"}`:                       "not a net.* sysctl",
+		`,"sysctls":{"net.ipv4/../../kernel":"1"}`:                "not a net.* sysctl",
+		`,"sysctls":{"net.ipv4.ip_forward":""}`:                   "invalid value",
+		`,"dns":{"nameservers":["resolver"]}`:                     "dns.nameservers[0]",
+		`,"routes":[{"dst":"10.50.0.0"}]`:                         "routes[0].dst",
+		`,"routes":[{"dst":"10.50.0.0/16","gw":"fd00::1"}]`:       "routes[0].gw",
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, extra))); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("%s: error = %v, want %q", extra, err, want)
+		}
+	}
+}
+
+func TestExpandTemplateVars(t *testing.T) {
+	t.Setenv(EnvNodeName, "node-a")
+	t.Setenv(EnvNodeIP, "")
+	vars := TemplateVars("IgnoreUnknown=1;K8S_POD_NAMESPACE=team-a;K8S_POD_NAME=web-0")
+	if vars["nodeName"] != "node-a" || vars["podNamespace"] != "team-a" || vars["podName"] != "web-0" {
+		t.Fatalf("TemplateVars() = %v", vars)
+	}
+	if _, ok := vars["nodeIP"]; ok {
+		t.Fatalf("TemplateVars() set nodeIP without %s", EnvNodeIP)
+	}
+
+	stdin := []byte(`{"name":"n","ipam":{"dataDir":"/var/lib/atomicni/{{podNamespace}}"},"logFile":"/var/log/{{ nodeName }}-{{podName}}.log"}`)
+	got, err := Expand(stdin, vars)
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	if want := `{"name":"n","ipam":{"dataDir":"/var/lib/atomicni/team-a"},"logFile":"/var/log/node-a-web-0.log"}`; string(got) != want {
+		t.Fatalf("Expand() = %s, want %s", got, want)
+	}
+	if got, err := Expand([]byte(`{"name":"{{podName}}"}`), map[string]string{"podName": `a"b`}); err != nil || string(got) != `{"name":"a\"b"}` {
+		t.Fatalf("Expand() = %s, %v; want the value JSON-escaped", got, err)
+	}
+	for ref, want := range map[string]string{
+		`{{nodeIP}}`:   EnvNodeIP + " is not set",
+		`{{tenant}}`:   "unknown template variable",
+		`{{podName}}`:  "is not set",
+		`{{nodeName}}`: "",
+	} {
+		_, err := Expand([]byte(`{"gateway":"`+ref+`"}`), map[string]string{"nodeName": "node-a"})
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want)) {
+			t.Fatalf("Expand(%s) error = %v, want %q", ref, err, want)
+		}
+	}
+}
+
+func TestParseVethNaming(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"%s}`
+	if cfg, err := Parse([]byte(fmt.Sprintf(base, ""))); err != nil || cfg.VethNaming != VethNamingHash {
+		t.Fatalf("Parse() = %+v, %v; want hash naming by default", cfg, err)
+	}
+	if cfg, err := Parse([]byte(fmt.Sprintf(base, `,"vethNaming":"pod"`))); err != nil || cfg.VethNaming != VethNamingPod {
+		t.Fatalf("Parse() = %+v, %v", cfg, err)
+	}
+	if _, err := Parse([]byte(fmt.Sprintf(base, `,"vethNaming":"name"`))); err == nil || !strings.Contains(err.Error(), "vethNaming") {
+		t.Fatalf("expected vethNaming error, got %v", err)
+	}
+}
+
+func TestParseAdmission(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":"/tmp/adm"},"admission":%s}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{"maxConcurrent":8,"rate":2.5}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if a := cfg.Admission; a.MaxConcurrent != 8 || a.Burst != 3 || a.MaxWait != DefaultAdmissionMaxWait {
+		t.Fatalf("Admission = %+v, want burst 3 and default maxWait", a)
+	}
+	if a, dir := AdmissionSettings([]byte(fmt.Sprintf(base, `{"maxConcurrent":4}`))); a == nil || a.MaxConcurrent != 4 || dir != "/tmp/adm" {
+		t.Fatalf("AdmissionSettings() = %+v, %q", a, dir)
+	}
+	for _, admission := range []string{`{}`, `{"maxConcurrent":-1}`, `{"burst":4}`, `{"rate":-1}`, `{"maxConcurrent":1,"maxWait":-5}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, admission))); err == nil || !strings.Contains(err.Error(), "admission") {
+			t.Fatalf("%s: expected error, got %v", admission, err)
+		}
+		if a, _ := AdmissionSettings([]byte(fmt.Sprintf(base, admission))); a != nil {
+			t.Fatalf("%s: AdmissionSettings() = %+v, want nil", admission, a)
+		}
+	}
+}
+
+func TestParseExhaustionWarning(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":"/tmp/x","exhaustionWarning":%s}}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{}`)))
+	if err != nil || cfg.IPAM.ExhaustionWarning.Threshold != DefaultExhaustionThreshold {
+		t.Fatalf("Parse() = %+v, %v; want default threshold", cfg.IPAM.ExhaustionWarning, err)
+	}
+	cfg, err = Parse([]byte(fmt.Sprintf(base, `{"threshold":80,"kubeconfig":"/etc/kubernetes/kubelet.conf"}`)))
+	switch {
+	case !buildinfo.K8s:
+		if err == nil || !strings.Contains(err.Error(), "needs Kubernetes support") {
+			t.Fatalf("Parse() error = %v, want kubeconfig rejected without Kubernetes support", err)
+		}
+	case err != nil || cfg.IPAM.ExhaustionWarning.NodeName == "":
+		t.Fatalf("Parse() = %+v, %v; want node name from host name", cfg.IPAM.ExhaustionWarning, err)
+	}
+	for _, w := range []string{`{"threshold":101}`, `{"threshold":-1}`, `{"kubeconfig":"kubelet.conf"}`} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, w))); err == nil || !strings.Contains(err.Error(), "exhaustionWarning") {
+			t.Fatalf("%s: expected error, got %v", w, err)
+		}
+	}
+}
+
+func TestParseReservationsFile(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":"/tmp/x","reservationsFile":%q}}`
+	for _, path := range []string{"/etc/atomicni/reservations.csv", "/etc/atomicni/reservations.yaml"} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, path))); err != nil {
+			t.Fatalf("%s: Parse() error = %v", path, err)
+		}
+	}
+	for _, path := range []string{"reservations.csv", "/etc/atomicni/reservations.json"} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, path))); err == nil || !strings.Contains(err.Error(), "reservationsFile") {
+			t.Fatalf("%s: expected error, got %v", path, err)
+		}
+	}
+}
+
+func TestParseDHCPLeases(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"dataDir":"/tmp/x","dhcpLeases":%s}}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `{"file":"/var/lib/misc/dnsmasq.leases","format":"dnsmasq"}`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := cfg.IPAM.DHCPLeases.RefreshInterval; got != DefaultLeaseRefreshInterval {
+		t.Fatalf("refreshInterval = %d, want %d", got, DefaultLeaseRefreshInterval)
+	}
+	for _, bad := range []string{
+		`{"file":"dnsmasq.leases","format":"dnsmasq"}`,
+		`{"file":"/var/lib/kea/kea-leases4.csv","format":"isc"}`,
+		`{"file":"/var/lib/kea/kea-leases4.csv","format":"kea","refreshInterval":-1}`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, bad))); err == nil || !strings.Contains(err.Error(), "dhcpLeases") {
+			t.Fatalf("%s: expected error, got %v", bad, err)
+		}
+	}
+}
+
+func TestParseDefaultRoute(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"%s}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, "")))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if cfg.DefaultRouteConflict != DefaultRouteKeep {
+		t.Fatalf("defaultRouteConflict = %q, want %q", cfg.DefaultRouteConflict, DefaultRouteKeep)
+	}
+	for _, policy := range []string{DefaultRouteReplace, DefaultRouteAddWithMetric, DefaultRouteFail} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, fmt.Sprintf(`,"defaultRouteConflict":%q`, policy)))); err != nil {
+			t.Fatalf("%s: Parse() error = %v", policy, err)
+		}
+	}
+	if _, err := Parse([]byte(fmt.Sprintf(base, `,"defaultRouteConflict":"ignore"`))); err == nil || !strings.Contains(err.Error(), "defaultRouteConflict") {
+		t.Fatalf("expected defaultRouteConflict error, got %v", err)
+	}
+	if _, err := Parse([]byte(fmt.Sprintf(base, `,"defaultRouteMetric":-1`))); err == nil || !strings.Contains(err.Error(), "defaultRouteMetric") {
+		t.Fatalf("expected defaultRouteMetric error, got %v", err)
+	}
+}
+
+func TestParseECMPGateways(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.200"}%s}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `,"ecmpGateways":["10.22.0.1","10.22.0.254"]`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(cfg.ECMPGatewayIPs) != 2 || !cfg.ECMPGatewayIPs[1].Equal(net.ParseIP("10.22.0.254")) {
+		t.Fatalf("ECMPGatewayIPs = %v", cfg.ECMPGatewayIPs)
+	}
+	for _, tc := range []struct{ extra, want string }{
+		{`,"ecmpGateways":["10.22.0.254"]`, "at least two"},
+		{`,"ecmpGateways":["10.22.0.1","10.22.0.1"]`, "listed twice"},
+		{`,"ecmpGateways":["10.22.0.1","10.23.0.1"]`, "outside subnet"},
+		{`,"ecmpGateways":["10.22.0.1","10.22.0.255"]`, "broadcast"},
+		{`,"ecmpGateways":["10.22.0.1","10.22.0.100"]`, "allocation range"},
+		{`,"mode":"ptp","ecmpGateways":["10.22.0.1","10.22.0.254"]`, "ptp"},
+	} {
+		_, err := Parse([]byte(fmt.Sprintf(base, tc.extra)))
+		if err == nil || !strings.Contains(err.Error(), "ecmpGateways") || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: error = %v, want %q", tc.extra, err, tc.want)
+		}
+	}
+}
+
+func TestParseSandbox(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1","ipam":{"rangeStart":"10.22.0.10","rangeEnd":"10.22.0.200"}%s}`
+	cfg, err := Parse([]byte(fmt.Sprintf(base, `,"sandbox":"gvisor"`)))
+	if err != nil || cfg.Sandbox != SandboxGVisor {
+		t.Fatalf("Parse() = %q, %v", cfg.Sandbox, err)
+	}
+	if err := cfg.ApplyCNIArgs("SANDBOX=none"); err != nil || cfg.Sandbox != "" {
+		t.Fatalf("SANDBOX=none: sandbox=%q err=%v", cfg.Sandbox, err)
+	}
+	if err := cfg.ApplyCNIArgs("SANDBOX=kata"); err != nil || cfg.Sandbox != SandboxKata {
+		t.Fatalf("SANDBOX=kata: sandbox=%q err=%v", cfg.Sandbox, err)
+	}
+
+	for _, extra := range []string{
+		`,"sandbox":"runc"`,
+		`,"sandbox":"kata","ecmpGateways":["10.22.0.1","10.22.0.254"]`,
+	} {
+		if _, err := Parse([]byte(fmt.Sprintf(base, extra))); err == nil {
+			t.Fatalf("%s: expected error", extra)
+		}
+	}
+	ecmp, err := Parse([]byte(fmt.Sprintf(base, `,"ecmpGateways":["10.22.0.1","10.22.0.254"]`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := ecmp.ApplyCNIArgs("SANDBOX=kata"); err == nil || !strings.Contains(err.Error(), "ecmpGateways") {
+		t.Fatalf("SANDBOX=kata with ecmpGateways: error = %v", err)
+	}
+}
+
+func TestApplyCNIArgsMAC(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"}`
+	cfg, err := Parse([]byte(base))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if err := cfg.ApplyCNIArgs("MAC=02:AA:bb:cc:dd:ee"); err != nil || cfg.PodMAC.String() != "02:aa:bb:cc:dd:ee" {
+		t.Fatalf("MAC: mac=%v err=%v", cfg.PodMAC, err)
+	}
+	for _, bad := range []string{"MAC=nope", "MAC=01:00:5e:00:00:01", "MAC=00:00:00:00:fe:80:00:00:00:00:00:00:02:00:5e:10:00:00:00:01"} {
+		if err := cfg.ApplyCNIArgs(bad); err == nil {
+			t.Fatalf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestParseLinkNames(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":%q,"subnet":"10.22.0.0/24","gateway":"10.22.0.1"%s}`
+	for _, tc := range []struct{ bridge, extra, want string }{
+		{"atomic-bridge-0123", "", "bridge: \"atomic-bridge-0123\" is 18 bytes"},
+		{"lo", "", "bridge: \"lo\" is a reserved"},
+		{"br/0", "", "bridge: \"br/0\" contains '/'"},
+		{"br0", `,"qinq":{"master":"eth1","sVlan":100,"cVlan":10,"innerName":"eth1.100.10.long"}`, "qinq.innerName"},
+	} {
+		_, err := Parse([]byte(fmt.Sprintf(base, tc.bridge, tc.extra)))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("bridge %q %s: got %v, want %q", tc.bridge, tc.extra, err, tc.want)
+		}
+	}
+	if _, err := Parse([]byte(fmt.Sprintf(base, "atomic-br-01234", ""))); err != nil {
+		t.Fatalf("15-byte bridge rejected: %v", err)
+	}
+}
+
+func TestParseHostMAC(t *testing.T) {
+	base := `{"cniVersion":"1.1.0","name":"n","type":"atomicni","bridge":"b","subnet":"10.22.0.0/24","gateway":"10.22.0.1"%s}`
+	if cfg, err := Parse([]byte(fmt.Sprintf(base, ""))); err != nil || cfg.HostMAC != HostMACPinned {
+		t.Fatalf("Parse() = %+v, %v; want pinned host MACs by default", cfg, err)
+	}
+	if cfg, err := Parse([]byte(fmt.Sprintf(base, `,"hostMAC":"random"`))); err != nil || cfg.HostMAC != HostMACRandom {
+		t.Fatalf("Parse() = %+v, %v", cfg, err)
+	}
+	if _, err := Parse([]byte(fmt.Sprintf(base, `,"hostMAC":"fixed"`))); err == nil || !strings.Contains(err.Error(), "hostMAC") {
+		t.Fatalf("expected hostMAC error, got %v", err)
+	}
+}
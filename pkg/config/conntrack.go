@@ -0,0 +1,17 @@
+package config
+
+// parseConntrackZones rejects features whose reply traffic would be tracked
+// outside the pod's zone: NAT rewrites the pod address, so replies arrive
+// addressed to the host and miss the zone assignment.
+func (c *NetworkConfig) parseConntrackZones() error {
+	if !c.ConntrackZones {
+		return nil
+	}
+	if c.IPMasq {
+		return fieldErrorf("conntrackZones", c.ConntrackZones, "cannot be combined with ipMasq")
+	}
+	if len(c.PortRanges) > 0 {
+		return fieldErrorf("conntrackZones", c.ConntrackZones, "cannot be combined with portMappings")
+	}
+	return nil
+}
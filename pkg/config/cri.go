@@ -0,0 +1,26 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// parseCRISocket validates `criSocket`, the containerd or CRI-O socket GC
+// asks whether a sandbox still exists before reclaiming its address. It is a
+// path or a unix:// endpoint; atomicnid --cri-socket overrides it.
+func (c *NetworkConfig) parseCRISocket() error {
+	if c.CRISocket == "" {
+		return nil
+	}
+	path := c.CRISocket
+	if scheme, rest, ok := strings.Cut(path, "://"); ok {
+		if scheme != "unix" {
+			return fieldErrorf("criSocket", c.CRISocket, "is not a unix:// endpoint")
+		}
+		path = rest
+	}
+	if !filepath.IsAbs(path) {
+		return fieldErrorf("criSocket", c.CRISocket, "is not an absolute path")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"math"
+)
+
+// `defaultRouteConflict` values: what ADD does when the pod already has a
+// default route that is not this network's, e.g. from another attachment.
+const (
+	DefaultRouteKeep          = "keep"
+	DefaultRouteReplace       = "replace"
+	DefaultRouteAddWithMetric = "add-with-metric"
+	DefaultRouteFail          = "fail"
+)
+
+// parseDefaultRoute validates `defaultRouteMetric` and
+// `defaultRouteConflict`, the latter defaulting to DefaultRouteKeep: the
+// existing route stays and the result omits this network's.
+func (c *NetworkConfig) parseDefaultRoute() error {
+	if c.DefaultRouteMetric < 0 || int64(c.DefaultRouteMetric) > math.MaxUint32 {
+		return fieldErrorf("defaultRouteMetric", c.DefaultRouteMetric, "out of range 0-%d", uint32(math.MaxUint32))
+	}
+	switch c.DefaultRouteConflict {
+	case "":
+		c.DefaultRouteConflict = DefaultRouteKeep
+	case DefaultRouteKeep, DefaultRouteReplace, DefaultRouteAddWithMetric, DefaultRouteFail:
+	default:
+		return fieldErrorf("defaultRouteConflict", c.DefaultRouteConflict, "is not one of %s, %s, %s, %s",
+			DefaultRouteKeep, DefaultRouteReplace, DefaultRouteAddWithMetric, DefaultRouteFail)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EnvCNIPath is the runtime-set list of plugin directories.
+const EnvCNIPath = "CNI_PATH"
+
+// parseCNIPath validates `cniPath`.
+func (c *NetworkConfig) parseCNIPath() error {
+	for i, dir := range c.CNIPath {
+		if !filepath.IsAbs(dir) {
+			return fieldErrorf(fmt.Sprintf("cniPath[%d]", i), dir, "is not an absolute path")
+		}
+	}
+	return nil
+}
+
+// DelegatePath returns the directories FindDelegate searches, in order:
+// `cniPath`, then CNI_PATH. Duplicates and empty entries are dropped.
+func (c *NetworkConfig) DelegatePath() []string {
+	var dirs []string
+	seen := map[string]bool{}
+	for _, dir := range append(append([]string(nil), c.CNIPath...), filepath.SplitList(os.Getenv(EnvCNIPath))...) {
+		if dir == "" || seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// FindDelegate resolves the binary of a delegated plugin, such as an IPAM
+// or chained plugin type, to the first executable file named name in
+// DelegatePath. The error lists every directory searched.
+func (c *NetworkConfig) FindDelegate(name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("delegate %q is not a plugin name", name)
+	}
+	dirs := c.DelegatePath()
+	if len(dirs) == 0 {
+		return "", fmt.Errorf("delegate %q: no plugin directories: set %s or cniPath", name, EnvCNIPath)
+	}
+	for _, dir := range dirs {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return path, nil
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("delegate %q: %w", name, err)
+		}
+	}
+	return "", fmt.Errorf("delegate %q not found in %s", name, strings.Join(dirs, ", "))
+}
@@ -0,0 +1,18 @@
+package config
+
+// CheckDeviceID rejects device-plugin reservations. Multus passes the VF the
+// scheduler reserved as deviceID (at the top level or in runtimeConfig), but
+// AtomicNI has no SR-IOV mode and only creates veths, so accepting the config
+// would silently give the pod a veth instead of the reserved device. Only
+// ADD calls it: Parse accepts the key, so DEL, CHECK, and GC of such a pod
+// never fail on it.
+func (c *NetworkConfig) CheckDeviceID() error {
+	path, id := "deviceID", c.DeviceID
+	if id == "" {
+		path, id = "runtimeConfig.deviceID", c.RuntimeConfig.DeviceID
+	}
+	if id != "" {
+		return fieldErrorf(path, id, "device cannot be attached: SR-IOV is not supported")
+	}
+	return nil
+}
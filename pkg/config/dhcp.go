@@ -0,0 +1,42 @@
+package config
+
+import (
+	"path/filepath"
+)
+
+// DefaultLeaseRefreshInterval is how often, in seconds, an unchanged DHCP
+// lease file is re-imported so expired leases free their addresses.
+const DefaultLeaseRefreshInterval = 60
+
+// DHCPLeasesConfig points IPAM at the lease file of a DHCP server serving the
+// same L2, in dnsmasq or Kea memfile format. Addresses it has leased are
+// never allocated.
+type DHCPLeasesConfig struct {
+	File            string `json:"file"`
+	Format          string `json:"format"`
+	RefreshInterval int    `json:"refreshInterval,omitempty"`
+}
+
+// parseDHCPLeases validates `ipam.dhcpLeases` and applies its defaults. The
+// lease file itself is read under the IPAM lock at ADD time.
+func (c *NetworkConfig) parseDHCPLeases() error {
+	l := c.IPAM.DHCPLeases
+	if l == nil {
+		return nil
+	}
+	if !filepath.IsAbs(l.File) {
+		return fieldErrorf("ipam.dhcpLeases.file", l.File, "must be an absolute path")
+	}
+	switch l.Format {
+	case "dnsmasq", "kea":
+	default:
+		return fieldErrorf("ipam.dhcpLeases.format", l.Format, "is not one of dnsmasq, kea")
+	}
+	switch {
+	case l.RefreshInterval == 0:
+		l.RefreshInterval = DefaultLeaseRefreshInterval
+	case l.RefreshInterval < 0:
+		return fieldErrorf("ipam.dhcpLeases.refreshInterval", l.RefreshInterval, "must be positive")
+	}
+	return nil
+}
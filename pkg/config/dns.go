@@ -0,0 +1,38 @@
+package config
+
+import ()
+
+// NodeLocalDNSConfig points pod DNS at a node-local cache listening on IP
+// (for example 169.254.20.10). When the cache listens elsewhere, queries to
+// IP are DNATed to Target. Search, Domain, and Options fill the DNS block of
+// the result.
+type NodeLocalDNSConfig struct {
+	IP      string   `json:"ip"`
+	Target  string   `json:"target,omitempty"`
+	Search  []string `json:"search,omitempty"`
+	Domain  string   `json:"domain,omitempty"`
+	Options []string `json:"options,omitempty"`
+}
+
+// parseNodeLocalDNS validates `nodeLocalDNS`.
+func (c *NetworkConfig) parseNodeLocalDNS() error {
+	d := c.NodeLocalDNS
+	if d == nil {
+		return nil
+	}
+	ip, err := parseIPv4(d.IP)
+	if err != nil {
+		return nestedError("nodeLocalDNS.ip", d.IP, err)
+	}
+	c.NodeLocalDNSIP = ip
+	if d.Target != "" {
+		if c.ConntrackZones {
+			return fieldErrorf("nodeLocalDNS.target", d.Target, "cannot be combined with conntrackZones")
+		}
+		c.NodeLocalDNSTarget, err = parseIPv4(d.Target)
+		if err != nil {
+			return nestedError("nodeLocalDNS.target", d.Target, err)
+		}
+	}
+	return nil
+}
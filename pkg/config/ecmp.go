@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// parseECMPGateways validates `ecmpGateways`, the next hops of an equal-cost
+// multipath default route in the pod. Besides `gateway`, which the bridge
+// holds, they are routers on the same L2, such as an HA gateway pair, so
+// IPAM must never hand them out: each must be outside the allocation
+// ranges.
+func (c *NetworkConfig) parseECMPGateways(b subnetBounds) error {
+	c.ECMPGatewayIPs = nil
+	if len(c.ECMPGateways) == 0 {
+		return nil
+	}
+	if len(c.ECMPGateways) < 2 {
+		return fieldErrorf("ecmpGateways", c.ECMPGateways, "list at least two gateways")
+	}
+	if c.Mode == ModePTP {
+		return fieldErrorf("ecmpGateways", c.ECMPGateways, "ptp mode routes every pod through the host; use bridge mode")
+	}
+	if c.IPAM.Type == IPAMTypeStatic {
+		return fieldErrorf("ecmpGateways", c.ECMPGateways, "static IPAM sets its own routes in ipam.routes")
+	}
+	if c.Sandbox != "" {
+		return errSandboxECMP
+	}
+	seen := map[netip.Addr]bool{}
+	for i, value := range c.ECMPGateways {
+		path := fmt.Sprintf("ecmpGateways[%d]", i)
+		addr, err := parseAddr(value)
+		if err != nil {
+			return nestedError(path, value, err)
+		}
+		switch {
+		case seen[addr]:
+			return fieldErrorf(path, value, "is listed twice")
+		case !b.subnet.Contains(addr):
+			return fieldErrorf(path, value, "is outside subnet %s", c.SubnetNet)
+		case b.edge(addr):
+			return fieldErrorf(path, value, "is the network or broadcast address")
+		case addr != c.GatewayAddr() && c.allocatable(addr):
+			return fieldErrorf(path, value, "is inside the allocation range; narrow the range to leave it out")
+		}
+		seen[addr] = true
+		c.ECMPGatewayIPs = append(c.ECMPGatewayIPs, netaddr.ToIP(addr))
+	}
+	return nil
+}
+
+// allocatable reports whether addr is inside the ranges IPAM allocates from.
+func (c *NetworkConfig) allocatable(addr netip.Addr) bool {
+	within := func(start, end net.IP) bool {
+		s, _ := netaddr.V4(start)
+		e, _ := netaddr.V4(end)
+		return s.Compare(addr) <= 0 && addr.Compare(e) <= 0
+	}
+	if len(c.Ranges) == 0 {
+		return within(c.RangeStartIP, c.RangeEndIP)
+	}
+	for _, r := range c.Ranges {
+		if within(r.Start, r.End) {
+			return true
+		}
+	}
+	return false
+}
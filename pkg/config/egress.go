@@ -0,0 +1,119 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// DefaultEgressTable is the routing table used by egressGateway without one.
+const DefaultEgressTable = 100
+
+// How atomicnid probes an egress gateway that has a backup.
+const (
+	// ProbeICMP pings the gateway.
+	ProbeICMP = "icmp"
+	// ProbeARP only requires the gateway to answer ARP, for gateways that
+	// drop ping.
+	ProbeARP = "arp"
+)
+
+// Defaults for egressGateway health probing.
+const (
+	DefaultProbeInterval = 5
+	DefaultProbeFailures = 3
+)
+
+// EgressGatewayConfig policy-routes pod traffic leaving the subnet through
+// IP, a gateway pod or node, using routing table Table. With OptIn only pods
+// passing EGRESS_GATEWAY=true in CNI_ARGS use the gateway; otherwise every
+// pod does unless it passes EGRESS_GATEWAY=false.
+//
+// With Backup set, atomicnid probes IP every ProbeInterval seconds and moves
+// the table's default route to Backup after ProbeFailures failed probes in a
+// row, and back once IP answers as many probes in a row.
+type EgressGatewayConfig struct {
+	IP    string `json:"ip"`
+	Table int    `json:"table,omitempty"`
+	OptIn bool   `json:"optIn,omitempty"`
+
+	Backup        string `json:"backup,omitempty"`
+	Probe         string `json:"probe,omitempty"`
+	ProbeInterval int    `json:"probeInterval,omitempty"`
+	ProbeFailures int    `json:"probeFailures,omitempty"`
+}
+
+// parseEgressGateway validates `egressGateway` and selects pods by default
+// unless it is opt-in.
+func (c *NetworkConfig) parseEgressGateway() error {
+	g := c.EgressGateway
+	if g == nil {
+		return nil
+	}
+	ip, err := parseIPv4(g.IP)
+	if err != nil {
+		return nestedError("egressGateway.ip", g.IP, err)
+	}
+	c.EgressGatewayIP = ip
+	if g.Table == 0 {
+		g.Table = DefaultEgressTable
+	}
+	// 253-255 are the kernel's default, main, and local tables.
+	if g.Table < 1 || g.Table > 252 {
+		return fieldErrorf("egressGateway.table", g.Table, "is outside 1-252")
+	}
+	c.UseEgressGateway = !g.OptIn
+	return c.parseEgressBackup()
+}
+
+// parseEgressBackup validates the backup gateway and its probe settings.
+func (c *NetworkConfig) parseEgressBackup() error {
+	g := c.EgressGateway
+	if g.Backup == "" {
+		if g.Probe != "" || g.ProbeInterval != 0 || g.ProbeFailures != 0 {
+			return fieldErrorf("egressGateway.backup", nil, "is required by the probe settings")
+		}
+		return nil
+	}
+	ip, err := parseIPv4(g.Backup)
+	if err != nil {
+		return nestedError("egressGateway.backup", g.Backup, err)
+	}
+	if ip.Equal(c.EgressGatewayIP) {
+		return fieldErrorf("egressGateway.backup", g.Backup, "must differ from ip")
+	}
+	c.EgressBackupIP = ip
+	switch g.Probe {
+	case "":
+		g.Probe = ProbeICMP
+	case ProbeICMP, ProbeARP:
+	default:
+		return fieldErrorf("egressGateway.probe", g.Probe, "must be %q or %q", ProbeICMP, ProbeARP)
+	}
+	if g.ProbeInterval == 0 {
+		g.ProbeInterval = DefaultProbeInterval
+	}
+	if g.ProbeInterval < 1 {
+		return fieldErrorf("egressGateway.probeInterval", g.ProbeInterval, "must be at least 1")
+	}
+	if g.ProbeFailures == 0 {
+		g.ProbeFailures = DefaultProbeFailures
+	}
+	if g.ProbeFailures < 1 {
+		return fieldErrorf("egressGateway.probeFailures", g.ProbeFailures, "must be at least 1")
+	}
+	return nil
+}
+
+// applyEgressArg applies EGRESS_GATEWAY=<bool> from CNI_ARGS.
+func (c *NetworkConfig) applyEgressArg(value string) error {
+	use, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("CNI_ARGS EGRESS_GATEWAY: %w", err)
+	}
+	if use && c.EgressGateway == nil {
+		return errors.New("CNI_ARGS EGRESS_GATEWAY: network has no egressGateway")
+	}
+	c.UseEgressGateway = use
+	return nil
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
+)
+
+// parseEncryptState validates `ipam.encryptState` and `ipam.stateKey`,
+// defaulting the key to statecrypt.DefaultKeySource.
+func (c *NetworkConfig) parseEncryptState() error {
+	if !c.IPAM.EncryptState {
+		if c.IPAM.StateKey != "" {
+			return fieldErrorf("ipam.stateKey", c.IPAM.StateKey, "requires ipam.encryptState")
+		}
+		return nil
+	}
+	if c.IPAM.StateKey == "" {
+		c.IPAM.StateKey = statecrypt.DefaultKeySource
+	}
+	key := c.IPAM.StateKey
+	switch {
+	case strings.HasPrefix(key, statecrypt.KeyFilePrefix):
+		if !strings.HasPrefix(strings.TrimPrefix(key, statecrypt.KeyFilePrefix), "/") {
+			return fieldErrorf("ipam.stateKey", key, "key file path must be absolute")
+		}
+	case strings.HasPrefix(key, statecrypt.KeyringPrefix):
+		if strings.TrimPrefix(key, statecrypt.KeyringPrefix) == "" {
+			return fieldErrorf("ipam.stateKey", key, "missing key description")
+		}
+	default:
+		return fieldErrorf("ipam.stateKey", key, "want %s<path> or %s<description>", statecrypt.KeyFilePrefix, statecrypt.KeyringPrefix)
+	}
+	return nil
+}
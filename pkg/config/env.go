@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+)
+
+// Environment variables that take precedence over the stdin config, so a
+// kubelet drop-in can flip a node to debug logging without editing every
+// conflist.
+const (
+	EnvDataDir  = "ATOMICNI_DATA_DIR"
+	EnvLogLevel = "ATOMICNI_LOG_LEVEL"
+	EnvLogFile  = "ATOMICNI_LOG_FILE"
+	EnvTimeout  = "ATOMICNI_TIMEOUT"
+)
+
+// envVars lists the overrides in a stable order.
+var envVars = []string{EnvDataDir, EnvLogLevel, EnvLogFile, EnvTimeout}
+
+// Log levels for `logLevel`; each includes the ones before it.
+const (
+	LogLevelError = "error"
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// EnvOverrides returns the set ATOMICNI_* overrides as NAME=value.
+func EnvOverrides() []string {
+	var set []string
+	for _, name := range envVars {
+		if v, ok := os.LookupEnv(name); ok {
+			set = append(set, name+"="+v)
+		}
+	}
+	return set
+}
+
+// DataDir returns ATOMICNI_DATA_DIR, or DefaultDataDir when unset. Operator
+// commands use it as their --data-dir default so flags still win.
+func DataDir() string {
+	if v, ok := os.LookupEnv(EnvDataDir); ok && v != "" {
+		return v
+	}
+	return DefaultDataDir
+}
+
+// applyEnv overlays ATOMICNI_* environment variables on the stdin config.
+func (c *NetworkConfig) applyEnv() error {
+	if v, ok := os.LookupEnv(EnvDataDir); ok && v != "" {
+		c.IPAM.DataDir = v
+	}
+	if v, ok := os.LookupEnv(EnvLogLevel); ok && v != "" {
+		c.LogLevel = v
+	}
+	if v, ok := os.LookupEnv(EnvLogFile); ok && v != "" {
+		c.LogFile = v
+	}
+	if v, ok := os.LookupEnv(EnvTimeout); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fieldErrorf("timeout", v, "%s: invalid seconds", EnvTimeout)
+		}
+		c.Timeout = n
+	}
+	return nil
+}
+
+// parseLogging validates `logLevel` and `timeout`.
+func (c *NetworkConfig) parseLogging() error {
+	switch c.LogLevel {
+	case "":
+		c.LogLevel = LogLevelError
+	case LogLevelError, LogLevelInfo, LogLevelDebug:
+	default:
+		return fieldErrorf("logLevel", c.LogLevel, "unsupported value")
+	}
+	if c.Timeout < 0 {
+		return fieldErrorf("timeout", c.Timeout, "cannot be negative")
+	}
+	return nil
+}
+
+// LogSettings extracts the log level and file without validating the rest of
+// the config, so even rejected configs are logged.
+func LogSettings(stdin []byte) (level, file string) {
+	var cfg NetworkConfig
+	_ = json.Unmarshal(stdin, &cfg)
+	_ = cfg.applyEnv()
+	if cfg.parseLogging() != nil {
+		cfg.LogLevel = LogLevelError
+	}
+	return cfg.LogLevel, cfg.LogFile
+}
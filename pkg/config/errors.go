@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a structured config validation failure for runtimes and
+// tooling: which field, what it held, and which constraint it broke.
+type FieldError struct {
+	// Path is the JSON path of the field, e.g. "ipam.ranges[0].rangeStart";
+	// empty when the failure is not tied to one field.
+	Path string
+	// Value is the offending value as JSON, when it could be located.
+	Value string
+	// Constraint describes the violated rule.
+	Constraint string
+	// Line and Column locate JSON syntax and type errors (1-based).
+	Line, Column int
+}
+
+func (e *FieldError) Error() string {
+	var b strings.Builder
+	if e.Line > 0 {
+		fmt.Fprintf(&b, "line %d column %d: ", e.Line, e.Column)
+	}
+	if e.Path != "" {
+		b.WriteString(e.Path + ": ")
+	}
+	b.WriteString(e.Constraint)
+	if e.Value != "" {
+		fmt.Fprintf(&b, " (got %s)", e.Value)
+	}
+	return b.String()
+}
+
+// ParseDetailed is Parse returning a *FieldError on failure. It never panics
+// on malformed input.
+func ParseDetailed(stdin []byte) (*NetworkConfig, error) {
+	cfg, err := Parse(stdin)
+	if err == nil {
+		return cfg, nil
+	}
+	return nil, fieldError(stdin, err)
+}
+
+// fieldError converts a Parse error into a FieldError. Validation steps
+// return *FieldError already; only JSON syntax and type errors are converted
+// here.
+func fieldError(stdin []byte, err error) *FieldError {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		return fe
+	}
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, col := position(stdin, syntaxErr.Offset)
+		return &FieldError{Constraint: "invalid JSON: " + syntaxErr.Error(), Line: line, Column: col}
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		fe := typeError(typeErr)
+		fe.Line, fe.Column = position(stdin, typeErr.Offset)
+		return fe
+	}
+	return &FieldError{Constraint: err.Error()}
+}
+
+// typeError is the FieldError for a JSON value of the wrong type.
+func typeError(err *json.UnmarshalTypeError) *FieldError {
+	return &FieldError{Path: indexPath(err.Field), Value: err.Value, Constraint: "must be " + jsonKind(err.Type)}
+}
+
+// fieldErrorf reports that the field at path, holding value, breaks a
+// constraint. value is echoed as JSON; pass nil when the field is unset.
+func fieldErrorf(path string, value any, format string, args ...any) *FieldError {
+	fe := &FieldError{Path: path, Constraint: fmt.Sprintf(format, args...)}
+	if value != nil {
+		if raw, err := json.Marshal(value); err == nil {
+			fe.Value = string(raw)
+		}
+	}
+	return fe
+}
+
+// nestedError reports err, returned while checking the field at path that
+// holds value. A *FieldError from the nested check keeps its value and has its
+// path joined under path; any other error becomes the constraint.
+func nestedError(path string, value any, err error) *FieldError {
+	var fe *FieldError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &fe):
+	case errors.As(err, &typeErr):
+		fe = typeError(typeErr)
+	default:
+		return fieldErrorf(path, value, "%s", err.Error())
+	}
+	out := *fe
+	switch {
+	case out.Path == "":
+		out.Path = path
+	case strings.HasPrefix(out.Path, "["):
+		out.Path = path + out.Path
+	default:
+		out.Path = path + "." + out.Path
+	}
+	return &out
+}
+
+// indexPath rewrites encoding/json's "a.0.b" field paths as "a[0].b".
+func indexPath(field string) string {
+	parts := strings.Split(field, ".")
+	var b strings.Builder
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil && i > 0 {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// position converts a byte offset into a 1-based line and column.
+func position(data []byte, offset int64) (line, col int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// jsonKind names the JSON type a Go type decodes from.
+func jsonKind(t reflect.Type) string {
+	if t == nil {
+		return "a valid value"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	case reflect.Pointer:
+		return jsonKind(t.Elem())
+	default:
+		return "a valid " + t.String()
+	}
+}
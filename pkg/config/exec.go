@@ -0,0 +1,45 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExecConfig narrows which external programs the plugin may run and audits
+// each one it does. Allow replaces the built-in allow-list (ip, bridge, tc,
+// nft, iptables, ipset, conntrack, ping, firewall-cmd) and holds bare program
+// names, not paths; Audit logs every command with its arguments whatever the
+// log level.
+type ExecConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Audit bool     `json:"audit,omitempty"`
+}
+
+// parseExec validates `exec`.
+func (c *NetworkConfig) parseExec() error {
+	if c.Exec == nil || c.Exec.Allow == nil {
+		return nil
+	}
+	if len(c.Exec.Allow) == 0 {
+		return fieldErrorf("exec.allow", c.Exec.Allow, "empty list would refuse every command")
+	}
+	for i, name := range c.Exec.Allow {
+		if name == "" || strings.ContainsAny(name, "/ \t") {
+			return fieldErrorf(fmt.Sprintf("exec.allow[%d]", i), name, "is not a program name")
+		}
+	}
+	return nil
+}
+
+// ExecSettings extracts `exec` without validating the rest of the config, so
+// the policy is in force before anything runs. An invalid setting yields the
+// defaults; Parse rejects the config before any command is issued.
+func ExecSettings(stdin []byte) ExecConfig {
+	var cfg NetworkConfig
+	_ = json.Unmarshal(stdin, &cfg)
+	if cfg.Exec == nil || cfg.parseExec() != nil {
+		return ExecConfig{}
+	}
+	return *cfg.Exec
+}
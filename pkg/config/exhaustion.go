@@ -0,0 +1,45 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// DefaultExhaustionThreshold is the pool utilization, in percent, that
+// triggers a warning when `ipam.exhaustionWarning` sets none.
+const DefaultExhaustionThreshold = 90
+
+// ExhaustionWarningConfig warns once a network's pool utilization reaches
+// Threshold percent. With Kubeconfig, the warning is also posted as a
+// Kubernetes Event against NodeName, which defaults to the host name.
+type ExhaustionWarningConfig struct {
+	Threshold  int    `json:"threshold,omitempty"`
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	NodeName   string `json:"nodeName,omitempty"`
+}
+
+// parseExhaustionWarning validates `ipam.exhaustionWarning` and applies its
+// defaults.
+func (c *NetworkConfig) parseExhaustionWarning() error {
+	w := c.IPAM.ExhaustionWarning
+	if w == nil {
+		return nil
+	}
+	switch {
+	case w.Threshold == 0:
+		w.Threshold = DefaultExhaustionThreshold
+	case w.Threshold < 1 || w.Threshold > 100:
+		return fieldErrorf("ipam.exhaustionWarning.threshold", w.Threshold, "is not a percentage in 1-100")
+	}
+	if w.Kubeconfig != "" && !strings.HasPrefix(w.Kubeconfig, "/") {
+		return fieldErrorf("ipam.exhaustionWarning.kubeconfig", w.Kubeconfig, "must be an absolute path")
+	}
+	if w.NodeName == "" && w.Kubeconfig != "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nestedError("ipam.exhaustionWarning.nodeName", nil, err)
+		}
+		w.NodeName = strings.ToLower(host)
+	}
+	return nil
+}
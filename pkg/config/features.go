@@ -0,0 +1,35 @@
+package config
+
+import "github.com/annis-souames/atomicni/pkg/buildinfo"
+
+// parseFeatures rejects settings that need a subsystem this build leaves out,
+// so a minimal build fails at parse time instead of halfway through ADD.
+func (c *NetworkConfig) parseFeatures() error {
+	if !buildinfo.K8s && c.IPAM.ExhaustionWarning != nil && c.IPAM.ExhaustionWarning.Kubeconfig != "" {
+		return fieldErrorf("ipam.exhaustionWarning.kubeconfig", c.IPAM.ExhaustionWarning.Kubeconfig, "needs Kubernetes support, which this build leaves out (atomicni_nok8s)")
+	}
+	if !buildinfo.K8s && c.CRISocket != "" {
+		return fieldErrorf("criSocket", c.CRISocket, "needs Kubernetes support, which this build leaves out (atomicni_nok8s)")
+	}
+	if buildinfo.Firewall {
+		return nil
+	}
+	for _, s := range []struct {
+		key string
+		set bool
+	}{
+		{"dscp", c.DSCP != nil},
+		{"connLimit", c.ConnLimit != nil},
+		{"ipMasq", c.IPMasq},
+		{"conntrackZones", c.ConntrackZones},
+		{"egressGateway", c.EgressGateway != nil},
+		{"podSet", c.PodSet},
+		{"nodeLocalDNS.target", c.NodeLocalDNSTarget != nil},
+		{"runtimeConfig.portMappings", len(c.RuntimeConfig.PortMappings) > 0},
+	} {
+		if s.set {
+			return fieldErrorf(s.key, nil, "needs firewall support, which this build leaves out (atomicni_nofirewall)")
+		}
+	}
+	return nil
+}
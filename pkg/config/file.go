@@ -0,0 +1,55 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PluginType is the `type` runtimes use to invoke AtomicNI.
+const PluginType = "atomicni"
+
+// FileConfs returns the stdin a runtime would pass to AtomicNI for each
+// atomicni entry of a .conf or .conflist file. For a conflist the list's
+// cniVersion and name are injected into each entry, as runtimes do.
+func FileConfs(raw []byte) ([][]byte, error) {
+	var head struct {
+		CNIVersion string            `json:"cniVersion"`
+		Name       string            `json:"name"`
+		Type       string            `json:"type"`
+		Plugins    []json.RawMessage `json:"plugins"`
+	}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, fmt.Errorf("parse config json: %w", err)
+	}
+	if head.Plugins == nil {
+		if head.Type != PluginType {
+			return nil, fmt.Errorf("type: %q is not %s", head.Type, PluginType)
+		}
+		return [][]byte{raw}, nil
+	}
+
+	var confs [][]byte
+	for i, plugin := range head.Plugins {
+		var entry map[string]json.RawMessage
+		if err := json.Unmarshal(plugin, &entry); err != nil {
+			return nil, fmt.Errorf("plugins[%d]: %w", i, err)
+		}
+		var typ string
+		_ = json.Unmarshal(entry["type"], &typ)
+		if typ != PluginType {
+			continue
+		}
+		entry["cniVersion"], _ = json.Marshal(head.CNIVersion)
+		entry["name"], _ = json.Marshal(head.Name)
+		conf, err := json.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("plugins[%d]: %w", i, err)
+		}
+		confs = append(confs, conf)
+	}
+	if len(confs) == 0 {
+		return nil, errors.New("plugins: no " + PluginType + " entry")
+	}
+	return confs, nil
+}
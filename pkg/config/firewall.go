@@ -0,0 +1,22 @@
+package config
+
+// DefaultFirewalldZone receives AtomicNI interfaces when firewalld is running.
+const DefaultFirewalldZone = "trusted"
+
+// FirewalldConfig controls firewalld integration, which is on by default
+// whenever firewalld is detected.
+type FirewalldConfig struct {
+	Disabled bool   `json:"disabled,omitempty"`
+	Zone     string `json:"zone,omitempty"`
+}
+
+// FirewalldZone returns the zone to bind interfaces to, or "" when disabled.
+func (c *NetworkConfig) FirewalldZone() string {
+	if c.Firewalld.Disabled {
+		return ""
+	}
+	if c.Firewalld.Zone == "" {
+		return DefaultFirewalldZone
+	}
+	return c.Firewalld.Zone
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// MaxLinkName is the kernel limit on interface name length (IFNAMSIZ-1).
+const MaxLinkName = 15
+
+// reservedLinkNames are refused for links AtomicNI creates or takes over:
+// the kernel rejects "." and "..", "all" and "default" collide with the
+// /proc/sys/net/*/conf entries of the same name, and "lo" already exists in
+// every namespace.
+var reservedLinkNames = map[string]bool{".": true, "..": true, "all": true, "default": true, "lo": true}
+
+// CheckLinkName reports why name cannot be an interface name, or nil.
+func CheckLinkName(name string) error {
+	if name == "" {
+		return errors.New("interface name is empty")
+	}
+	if len(name) > MaxLinkName {
+		return fmt.Errorf("%q is %d bytes, over the %d-byte interface name limit", name, len(name), MaxLinkName)
+	}
+	if reservedLinkNames[name] {
+		return fmt.Errorf("%q is a reserved interface name", name)
+	}
+	// The kernel refuses '/', ':', and whitespace; control characters are
+	// refused too, since no tool prints them usefully.
+	if i := strings.IndexFunc(name, func(r rune) bool {
+		return r == '/' || r == ':' || r <= ' ' || r == 0x7f
+	}); i >= 0 {
+		return fmt.Errorf("%q contains %q, which interface names cannot", name, name[i])
+	}
+	return nil
+}
+
+// parseLinkNames validates the interface names the config makes AtomicNI
+// create or use.
+func (c *NetworkConfig) parseLinkNames() error {
+	if c.Mode == ModeBridge {
+		if err := CheckLinkName(c.Bridge); err != nil {
+			return nestedError("bridge", c.Bridge, err)
+		}
+	}
+	if q := c.QinQ; q != nil {
+		for _, f := range []struct{ key, name string }{
+			{"qinq.master", q.Master},
+			{"qinq.outerName", q.OuterName},
+			{"qinq.innerName", q.InnerName},
+		} {
+			if err := CheckLinkName(f.name); err != nil {
+				return nestedError(f.key, f.name, err)
+			}
+		}
+	}
+	return nil
+}
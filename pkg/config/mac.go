@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// applyMACArg applies MAC=<address> from CNI_ARGS, the pod interface's MAC;
+// restoring a checkpoint passes the MAC the pod had.
+func (c *NetworkConfig) applyMACArg(value string) error {
+	mac, err := net.ParseMAC(value)
+	if err != nil {
+		return fmt.Errorf("CNI_ARGS MAC: %w", err)
+	}
+	if len(mac) != 6 {
+		return fmt.Errorf("CNI_ARGS MAC: %s is not an Ethernet address", mac)
+	}
+	if mac[0]&1 != 0 {
+		return fmt.Errorf("CNI_ARGS MAC: %s is a multicast address", mac)
+	}
+	c.PodMAC = mac
+	return nil
+}
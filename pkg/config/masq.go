@@ -0,0 +1,40 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SNATConfig tunes masquerading: the source port range used for translated
+// connections and, optionally, a dedicated egress address on the host.
+type SNATConfig struct {
+	PortRange string `json:"portRange,omitempty"`
+	EgressIP  string `json:"egressIP,omitempty"`
+}
+
+// parseMasq validates `ipMasq` and `snat`.
+func (c *NetworkConfig) parseMasq() error {
+	if c.SNAT == nil {
+		return nil
+	}
+	if !c.IPMasq {
+		return fieldErrorf("snat", nil, "requires ipMasq")
+	}
+	if c.SNAT.PortRange != "" {
+		first, last, ok := strings.Cut(c.SNAT.PortRange, "-")
+		lo, err1 := strconv.Atoi(first)
+		hi, err2 := strconv.Atoi(last)
+		if !ok || err1 != nil || err2 != nil || !validPort(lo) || !validPort(hi) || lo > hi {
+			return fieldErrorf("snat.portRange", c.SNAT.PortRange, "is not a range like 32768-60999")
+		}
+		c.SNATPortMin, c.SNATPortMax = lo, hi
+	}
+	if c.SNAT.EgressIP != "" {
+		ip, err := parseIPv4(c.SNAT.EgressIP)
+		if err != nil {
+			return nestedError("snat.egressIP", c.SNAT.EgressIP, err)
+		}
+		c.EgressIP = ip
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Member is one logical network of a multi-network config.
+type Member struct {
+	// Stdin is the member's complete single-network config, as ADD receives it.
+	Stdin  []byte
+	Config *NetworkConfig
+}
+
+// parseNetworks validates `networks`: each entry is a single-network config
+// that inherits cniVersion, type, and criSocket from the top level, and the container is
+// attached to all of them in order.
+func (c *NetworkConfig) parseNetworks() error {
+	if c.Name == "" {
+		return fieldErrorf("name", nil, "is required")
+	}
+	names := map[string]bool{}
+	for i, raw := range c.Networks {
+		var entry map[string]json.RawMessage
+		path := fmt.Sprintf("networks[%d]", i)
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			return nestedError(path, nil, err)
+		}
+		if _, ok := entry["networks"]; ok {
+			return fieldErrorf(path+".networks", nil, "cannot be nested")
+		}
+		if _, ok := entry["cniVersion"]; !ok {
+			entry["cniVersion"], _ = json.Marshal(c.CNIVersion)
+		}
+		if _, ok := entry["type"]; !ok {
+			entry["type"], _ = json.Marshal(c.Type)
+		}
+		if _, ok := entry["criSocket"]; !ok && c.CRISocket != "" {
+			entry["criSocket"], _ = json.Marshal(c.CRISocket)
+		}
+		stdin, err := json.Marshal(entry)
+		if err != nil {
+			return nestedError(path, nil, err)
+		}
+		cfg, err := Parse(stdin)
+		if err != nil {
+			return nestedError(path, nil, err)
+		}
+		if names[cfg.Name] {
+			return fieldErrorf(path+".name", cfg.Name, "duplicate network")
+		}
+		names[cfg.Name] = true
+		c.Members = append(c.Members, Member{Stdin: stdin, Config: cfg})
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+// Host veth naming schemes.
+const (
+	// VethNamingHash names host veths from a hash of the container ID.
+	VethNamingHash = "hash"
+	// VethNamingPod names host veths after the pod (K8S_POD_NAME) plus a
+	// short hash, falling back to VethNamingHash without a pod name.
+	VethNamingPod = "pod"
+)
+
+// parseVethNaming validates `vethNaming`.
+func (c *NetworkConfig) parseVethNaming() error {
+	switch c.VethNaming {
+	case "":
+		c.VethNaming = VethNamingHash
+	case VethNamingHash, VethNamingPod:
+	default:
+		return fieldErrorf("vethNaming", c.VethNaming, "unsupported value")
+	}
+	return nil
+}
+
+// Host veth MAC schemes.
+const (
+	// HostMACPinned derives the host veth MAC from the container key, so a
+	// pod that comes back with the same identity shows the same MAC.
+	HostMACPinned = "pinned"
+	// HostMACRandom keeps the MAC the kernel picks when creating the veth.
+	HostMACRandom = "random"
+)
+
+// parseHostMAC validates `hostMAC`.
+func (c *NetworkConfig) parseHostMAC() error {
+	switch c.HostMAC {
+	case "":
+		c.HostMAC = HostMACPinned
+	case HostMACPinned, HostMACRandom:
+	default:
+		return fieldErrorf("hostMAC", c.HostMAC, "unsupported value")
+	}
+	return nil
+}
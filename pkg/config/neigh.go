@@ -0,0 +1,48 @@
+package config
+
+// NeighborConfig sizes the host's IPv4 neighbor (ARP) table for large pod
+// counts. Unset fields keep the host's values.
+type NeighborConfig struct {
+	GCThresh1 int `json:"gcThresh1,omitempty"`
+	GCThresh2 int `json:"gcThresh2,omitempty"`
+	GCThresh3 int `json:"gcThresh3,omitempty"`
+	// BaseReachableTime, in seconds, applies to the bridge only.
+	BaseReachableTime int `json:"baseReachableTime,omitempty"`
+}
+
+// parseNeighbor validates `neighbor`.
+func (c *NetworkConfig) parseNeighbor() error {
+	n := c.Neighbor
+	if n == nil {
+		return nil
+	}
+	for name, v := range map[string]int{"gcThresh1": n.GCThresh1, "gcThresh2": n.GCThresh2, "gcThresh3": n.GCThresh3, "baseReachableTime": n.BaseReachableTime} {
+		if v < 0 {
+			return fieldErrorf("neighbor."+name, v, "cannot be negative")
+		}
+	}
+	set := []int{}
+	for _, v := range []int{n.GCThresh1, n.GCThresh2, n.GCThresh3} {
+		if v > 0 {
+			set = append(set, v)
+		}
+	}
+	for i := 1; i < len(set); i++ {
+		if set[i] < set[i-1] {
+			return fieldErrorf("neighbor", n, "gcThresh1 <= gcThresh2 <= gcThresh3 required")
+		}
+	}
+	if n.BaseReachableTime > 0 && c.Mode != ModeBridge {
+		return fieldErrorf("neighbor.baseReachableTime", n.BaseReachableTime, "only supported in bridge mode")
+	}
+	return nil
+}
+
+// StaticNeighbors pre-populates neighbor tables with permanent entries so the
+// first packet never waits for ARP resolution.
+type StaticNeighbors struct {
+	// Gateway programs the gateway's MAC inside the pod.
+	Gateway bool `json:"gateway,omitempty"`
+	// Pod programs the pod's MAC on the host side of its link.
+	Pod bool `json:"pod,omitempty"`
+}
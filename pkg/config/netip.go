@@ -0,0 +1,26 @@
+package config
+
+import (
+	"net/netip"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// SubnetPrefix returns the parsed subnet as a netip.Prefix.
+func (c *NetworkConfig) SubnetPrefix() netip.Prefix {
+	p, _ := netaddr.FromIPNet(c.SubnetNet)
+	return p.Masked()
+}
+
+// GatewayAddr returns the parsed gateway; it is invalid when none is set.
+func (c *NetworkConfig) GatewayAddr() netip.Addr {
+	a, _ := netaddr.FromIP(c.GatewayIP)
+	return a
+}
+
+// RangeAddrs returns the parsed allocation range bounds.
+func (c *NetworkConfig) RangeAddrs() (start, end netip.Addr) {
+	start, _ = netaddr.FromIP(c.RangeStartIP)
+	end, _ = netaddr.FromIP(c.RangeEndIP)
+	return start, end
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+const (
+	DefaultPartitionPrefix = 26
+
+	PartitionByNetwork = "network"
+	PartitionByNode    = "node"
+)
+
+// parsePartition validates clusterSubnet auto-partitioning settings.
+func (c *NetworkConfig) parsePartition() error {
+	if c.ClusterSubnet == "" {
+		if c.PartitionPrefix != 0 || c.PartitionBy != "" || c.PartitionIndex != "" {
+			return fieldErrorf("clusterSubnet", nil, "is required by the partition settings")
+		}
+		return nil
+	}
+
+	cluster, err := parseNetwork(c.ClusterSubnet)
+	if err != nil {
+		return nestedError("clusterSubnet", c.ClusterSubnet, err)
+	}
+	c.ClusterSubnetNet = netaddr.ToIPNet(cluster)
+
+	if c.PartitionPrefix == 0 {
+		c.PartitionPrefix = DefaultPartitionPrefix
+	}
+	if c.PartitionPrefix < cluster.Bits() || c.PartitionPrefix > 30 {
+		return fieldErrorf("partitionPrefix", c.PartitionPrefix, "must be between /%d and /30", cluster.Bits())
+	}
+
+	if c.PartitionBy == "" {
+		c.PartitionBy = PartitionByNetwork
+	}
+	if c.PartitionBy != PartitionByNetwork && c.PartitionBy != PartitionByNode {
+		return fieldErrorf("partitionBy", c.PartitionBy, "unsupported value")
+	}
+	if c.PartitionIndex == "" {
+		c.PartitionIndex = filepath.Join(c.IPAM.DataDir, "partitions", "index.json")
+	}
+	if c.Subnet != "" {
+		return fieldErrorf("subnet", c.Subnet, "cannot be combined with clusterSubnet")
+	}
+	return nil
+}
+
+// PartitionKey returns the identity a carved subnet is recorded under.
+func (c *NetworkConfig) PartitionKey() (string, error) {
+	if c.PartitionBy != PartitionByNode {
+		return c.Name, nil
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("resolve node name: %w", err)
+	}
+	return host + "/" + c.Name, nil
+}
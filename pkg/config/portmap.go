@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// RuntimeConfig holds values injected by the runtime through CNI capabilities.
+type RuntimeConfig struct {
+	PortMappings []PortMapping   `json:"portMappings,omitempty"`
+	Bandwidth    *BandwidthEntry `json:"bandwidth,omitempty"`
+	DeviceID     string          `json:"deviceID,omitempty"`
+}
+
+// PortMapping is one `portMappings` capability entry. HostPortEnd extends the
+// standard entry into a contiguous range mapped onto containerPort onwards.
+type PortMapping struct {
+	HostPort      int    `json:"hostPort"`
+	HostPortEnd   int    `json:"hostPortEnd,omitempty"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol,omitempty"`
+	HostIP        string `json:"hostIP,omitempty"`
+}
+
+// PortRange is a validated host port range forwarded to a container range of
+// the same length starting at ContainerStart.
+type PortRange struct {
+	Protocol       string
+	HostIP         net.IP
+	HostStart      int
+	HostEnd        int
+	ContainerStart int
+}
+
+// parsePortMappings validates runtimeConfig.portMappings, refuses entries
+// whose host ports overlap, and coalesces adjacent entries, so a runtime
+// sending one entry per port still yields one range per contiguous block.
+func (c *NetworkConfig) parsePortMappings() error {
+	var ranges []PortRange
+	for i, m := range c.RuntimeConfig.PortMappings {
+		path := fmt.Sprintf("runtimeConfig.portMappings[%d]", i)
+		r, err := parsePortMapping(m)
+		if err != nil {
+			return nestedError(path, nil, err)
+		}
+		for j, prev := range ranges {
+			if r.overlaps(prev) {
+				return fieldErrorf(path+".hostPort", m.HostPort, "overlaps runtimeConfig.portMappings[%d]", j)
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	c.PortRanges = coalescePortRanges(ranges)
+	return nil
+}
+
+// parsePortMapping validates one entry.
+func parsePortMapping(m PortMapping) (PortRange, error) {
+	proto := strings.ToLower(m.Protocol)
+	if proto == "" {
+		proto = "tcp"
+	}
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return PortRange{}, fieldErrorf("protocol", m.Protocol, "unsupported value")
+	}
+	end := m.HostPortEnd
+	if end == 0 {
+		end = m.HostPort
+	}
+	for _, p := range []struct {
+		key  string
+		port int
+	}{{"hostPort", m.HostPort}, {"hostPortEnd", end}, {"containerPort", m.ContainerPort}} {
+		if !validPort(p.port) {
+			return PortRange{}, fieldErrorf(p.key, p.port, "must be within 1-65535")
+		}
+	}
+	if end < m.HostPort {
+		return PortRange{}, fieldErrorf("hostPortEnd", end, "must be >= hostPort")
+	}
+	if !validPort(m.ContainerPort + end - m.HostPort) {
+		return PortRange{}, fieldErrorf("containerPort", m.ContainerPort, "range exceeds 65535")
+	}
+	r := PortRange{Protocol: proto, HostStart: m.HostPort, HostEnd: end, ContainerStart: m.ContainerPort}
+	if m.HostIP != "" {
+		ip, err := parseIPv4(m.HostIP)
+		if err != nil {
+			return PortRange{}, nestedError("hostIP", m.HostIP, err)
+		}
+		r.HostIP = ip
+	}
+	return r, nil
+}
+
+// coalescePortRanges merges ranges that continue each other on the same
+// protocol, host IP, and host-to-container offset.
+func coalescePortRanges(ranges []PortRange) []PortRange {
+	sort.SliceStable(ranges, func(i, j int) bool {
+		a, b := ranges[i], ranges[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		if !a.HostIP.Equal(b.HostIP) {
+			return a.HostIP.String() < b.HostIP.String()
+		}
+		return a.HostStart < b.HostStart
+	})
+	var out []PortRange
+	for _, r := range ranges {
+		if n := len(out); n > 0 {
+			last := &out[n-1]
+			if last.Protocol == r.Protocol && last.HostIP.Equal(r.HostIP) &&
+				r.HostStart == last.HostEnd+1 &&
+				r.ContainerStart-r.HostStart == last.ContainerStart-last.HostStart {
+				last.HostEnd = r.HostEnd
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// overlaps reports whether r and o forward a common host port: the same
+// protocol, ports, and address, where no host IP means every local address.
+func (r PortRange) overlaps(o PortRange) bool {
+	if r.Protocol != o.Protocol || r.HostStart > o.HostEnd || o.HostStart > r.HostEnd {
+		return false
+	}
+	return r.HostIP == nil || o.HostIP == nil || r.HostIP.Equal(o.HostIP)
+}
+
+// validPort reports whether p is a usable TCP/UDP/SCTP port.
+func validPort(p int) bool {
+	return p >= 1 && p <= 65535
+}
@@ -0,0 +1,37 @@
+package config
+
+import (
+	"errors"
+)
+
+// checkDSCP rejects values outside the 6-bit DSCP field.
+func checkDSCP(dscp int) error {
+	if dscp < 0 || dscp > 63 {
+		return errors.New("out of range 0-63")
+	}
+	return nil
+}
+
+// ConnLimit caps new connections per second originating from each pod.
+type ConnLimit struct {
+	PerSecond int `json:"perSecond"`
+	Burst     int `json:"burst,omitempty"`
+}
+
+// parseConnLimit validates `connLimit`; burst defaults to perSecond.
+func (c *NetworkConfig) parseConnLimit() error {
+	l := c.ConnLimit
+	if l == nil {
+		return nil
+	}
+	if l.PerSecond <= 0 {
+		return fieldErrorf("connLimit.perSecond", l.PerSecond, "must be positive")
+	}
+	if l.Burst < 0 {
+		return fieldErrorf("connLimit.burst", l.Burst, "cannot be negative")
+	}
+	if l.Burst == 0 {
+		l.Burst = l.PerSecond
+	}
+	return nil
+}
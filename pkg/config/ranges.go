@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+)
+
+// RangeConfig is one entry of `ipam.ranges`; lower priority values are filled
+// first and ranges sharing a priority form one group.
+type RangeConfig struct {
+	RangeStart string `json:"rangeStart"`
+	RangeEnd   string `json:"rangeEnd"`
+	Priority   int    `json:"priority,omitempty"`
+}
+
+// IPRange is a parsed allocation range.
+type IPRange struct {
+	Start    net.IP
+	End      net.IP
+	Priority int
+}
+
+// parseRanges parses `ipam.ranges` sorted by priority and exposes the primary
+// range through RangeStartIP/RangeEndIP for single-range consumers.
+func (c *NetworkConfig) parseRanges() error {
+	if len(c.IPAM.Ranges) == 0 {
+		return nil
+	}
+	if c.IPAM.RangeStart != "" {
+		return fieldErrorf("ipam.ranges", nil, "cannot be combined with ipam.rangeStart/rangeEnd")
+	}
+
+	ranges := make([]IPRange, 0, len(c.IPAM.Ranges))
+	for i, r := range c.IPAM.Ranges {
+		start, err := parseIPv4(r.RangeStart)
+		if err != nil {
+			return nestedError(fmt.Sprintf("ipam.ranges[%d].rangeStart", i), r.RangeStart, err)
+		}
+		end, err := parseIPv4(r.RangeEnd)
+		if err != nil {
+			return nestedError(fmt.Sprintf("ipam.ranges[%d].rangeEnd", i), r.RangeEnd, err)
+		}
+		ranges = append(ranges, IPRange{Start: start, End: end, Priority: r.Priority})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Priority < ranges[j].Priority
+	})
+
+	c.Ranges = ranges
+	c.RangeStartIP = ranges[0].Start
+	c.RangeEndIP = ranges[0].End
+	return nil
+}
+
+// checkRange validates one range against the subnet bounds.
+func (b subnetBounds) checkRange(start, end netip.Addr) error {
+	for _, bound := range []struct {
+		key  string
+		addr netip.Addr
+	}{{"rangeStart", start}, {"rangeEnd", end}} {
+		if !b.subnet.Contains(bound.addr) {
+			return fieldErrorf(bound.key, bound.addr.String(), "must be inside subnet")
+		}
+		if b.edge(bound.addr) {
+			return fieldErrorf(bound.key, bound.addr.String(), "cannot be network or broadcast")
+		}
+	}
+	if end.Less(start) {
+		return fieldErrorf("rangeStart", start.String(), "must be <= rangeEnd")
+	}
+	return nil
+}
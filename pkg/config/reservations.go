@@ -0,0 +1,24 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// parseReservationsFile validates `ipam.reservationsFile`. The file itself is
+// read under the IPAM lock at ADD time, so edits apply without a restart.
+func (c *NetworkConfig) parseReservationsFile() error {
+	path := c.IPAM.ReservationsFile
+	if path == "" {
+		return nil
+	}
+	if !filepath.IsAbs(path) {
+		return fieldErrorf("ipam.reservationsFile", path, "must be an absolute path")
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".yaml", ".yml":
+	default:
+		return fieldErrorf("ipam.reservationsFile", path, "want a .csv, .yaml, or .yml file")
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import "time"
+
+// Rollback policies for a failed ADD.
+const (
+	// RollbackFull undoes everything ADD did.
+	RollbackFull = "full"
+	// RollbackKeepAllocation undoes links and host rules but keeps the
+	// address and the attachment record, so a retry gets the same address.
+	RollbackKeepAllocation = "keep-allocation"
+	// RollbackKeepLinks undoes nothing, leaving the datapath for debugging.
+	RollbackKeepLinks = "keep-links"
+)
+
+// DefaultRollbackRetryWindow is how long GC leaves what a failed ADD kept.
+const DefaultRollbackRetryWindow = 5 * time.Minute
+
+// RollbackConfig selects what a failed ADD undoes. Anything kept is recorded
+// as a failure in the attachment cache; DEL cleans it up, and GC does once
+// RetryWindow (seconds) has passed.
+type RollbackConfig struct {
+	Policy      string `json:"policy,omitempty"`
+	RetryWindow int    `json:"retryWindow,omitempty"`
+}
+
+// parseRollback validates `rollback`.
+func (c *NetworkConfig) parseRollback() error {
+	r := c.Rollback
+	if r == nil {
+		return nil
+	}
+	switch r.Policy {
+	case "":
+		r.Policy = RollbackFull
+	case RollbackFull, RollbackKeepAllocation, RollbackKeepLinks:
+	default:
+		return fieldErrorf("rollback.policy", r.Policy, "unsupported value")
+	}
+	if r.RetryWindow < 0 {
+		return fieldErrorf("rollback.retryWindow", r.RetryWindow, "cannot be negative")
+	}
+	return nil
+}
+
+// RollbackPolicy returns the configured policy, RollbackFull by default.
+func (c *NetworkConfig) RollbackPolicy() string {
+	if c.Rollback == nil {
+		return RollbackFull
+	}
+	return c.Rollback.Policy
+}
+
+// RollbackRetryWindow returns how long kept state survives GC.
+func (c *NetworkConfig) RollbackRetryWindow() time.Duration {
+	if c.Rollback == nil || c.Rollback.RetryWindow == 0 {
+		return DefaultRollbackRetryWindow
+	}
+	return time.Duration(c.Rollback.RetryWindow) * time.Second
+}
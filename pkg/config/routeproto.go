@@ -0,0 +1,20 @@
+package config
+
+// Route protocol numbers below MinRouteProto belong to the kernel (0-3) or
+// mark static routes any tool may add (4), so deleting by them is not safe.
+const (
+	MinRouteProto = 5
+	MaxRouteProto = 255
+)
+
+// parseRouteProto validates `routeProto`, the protocol number pod /32 host
+// routes are installed with so a routing daemon can redistribute them.
+func (c *NetworkConfig) parseRouteProto() error {
+	if c.RouteProto == 0 {
+		return nil
+	}
+	if c.RouteProto < MinRouteProto || c.RouteProto > MaxRouteProto {
+		return fieldErrorf("routeProto", c.RouteProto, "out of range %d-%d", MinRouteProto, MaxRouteProto)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sandboxed runtimes whose shim owns the pod netns after ADD.
+const (
+	SandboxKata   = "kata"
+	SandboxGVisor = "gvisor"
+)
+
+// parseSandbox validates `sandbox`.
+func (c *NetworkConfig) parseSandbox() error {
+	switch c.Sandbox {
+	case "", SandboxKata, SandboxGVisor:
+		return nil
+	default:
+		return fieldErrorf("sandbox", c.Sandbox, "must be %q or %q", SandboxKata, SandboxGVisor)
+	}
+}
+
+// applySandboxArg applies SANDBOX=<kata|gvisor|none> from CNI_ARGS, so one
+// network can serve pods of several runtime classes.
+func (c *NetworkConfig) applySandboxArg(value string) error {
+	if value == "none" {
+		c.Sandbox = ""
+		return nil
+	}
+	c.Sandbox = value
+	if err := c.parseSandbox(); err != nil {
+		return fmt.Errorf("CNI_ARGS SANDBOX: %w", err)
+	}
+	if len(c.ECMPGatewayIPs) > 0 {
+		return errors.New("CNI_ARGS SANDBOX: " + errSandboxECMP.Error())
+	}
+	return nil
+}
+
+// errSandboxECMP refuses multipath default routes, which the guest copies of
+// the pod's routes cannot express.
+var errSandboxECMP = &FieldError{Path: "ecmpGateways", Constraint: "sandboxed runtimes import one default gateway"}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"net"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// ServiceHostBlackhole as serviceHostRoute drops service traffic on the host
+// that nothing translated before routing.
+const ServiceHostBlackhole = "blackhole"
+
+// parseServiceCIDR validates `serviceCIDR` and `serviceHostRoute`, which is
+// "" (leave the host alone), "blackhole", or an IPv4 next hop.
+func (c *NetworkConfig) parseServiceCIDR() error {
+	if c.ServiceCIDR == "" {
+		if c.ServiceHostRoute != "" {
+			return fieldErrorf("serviceHostRoute", c.ServiceHostRoute, "requires serviceCIDR")
+		}
+		return nil
+	}
+	prefix, err := parseNetwork(c.ServiceCIDR)
+	if err != nil {
+		return nestedError("serviceCIDR", c.ServiceCIDR, err)
+	}
+	cidr := netaddr.ToIPNet(prefix)
+	if c.ClusterSubnetNet != nil && overlaps(cidr, c.ClusterSubnetNet) {
+		return fieldErrorf("serviceCIDR", c.ServiceCIDR, "overlaps clusterSubnet %s", c.ClusterSubnetNet)
+	}
+	c.ServiceCIDRNet = cidr
+
+	if c.ServiceHostRoute != "" && c.ServiceHostRoute != ServiceHostBlackhole {
+		via, err := parseIPv4(c.ServiceHostRoute)
+		if err != nil {
+			return nestedError("serviceHostRoute", c.ServiceHostRoute, err)
+		}
+		c.ServiceHostVia = via
+	}
+	return nil
+}
+
+// overlaps reports whether two prefixes share any address.
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
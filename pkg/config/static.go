@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// StaticAddress is one fixed address entry for `ipam.type: "static"`.
+type StaticAddress struct {
+	Address string `json:"address"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// RouteConfig is one extra route installed in the container.
+type RouteConfig struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
+
+// StaticAddr is a parsed static address; Addr keeps the host IP with the prefix mask.
+type StaticAddr struct {
+	Addr    *net.IPNet
+	Gateway net.IP
+}
+
+// Route is a parsed route; a nil GW means "via the attachment gateway".
+type Route struct {
+	Dst *net.IPNet
+	GW  net.IP
+}
+
+// parseStatic validates static addresses and routes from the IPAM section.
+func (c *NetworkConfig) parseStatic() error {
+	if c.IPAM.Type != IPAMTypeStatic {
+		if len(c.IPAM.Addresses) > 0 {
+			return fieldErrorf("ipam.addresses", nil, "requires ipam.type %q", IPAMTypeStatic)
+		}
+		return nil
+	}
+
+	addrs := make([]StaticAddr, 0, len(c.IPAM.Addresses))
+	for i, a := range c.IPAM.Addresses {
+		parsed, err := c.parseStaticAddr(a.Address, a.Gateway)
+		if err != nil {
+			return nestedError(fmt.Sprintf("ipam.addresses[%d]", i), nil, err)
+		}
+		addrs = append(addrs, parsed)
+	}
+	c.StaticAddrs = addrs
+
+	routes := make([]Route, 0, len(c.IPAM.Routes))
+	for i, r := range c.IPAM.Routes {
+		dst, err := parseNetwork(r.Dst)
+		if err != nil {
+			return nestedError(fmt.Sprintf("ipam.routes[%d].dst", i), r.Dst, err)
+		}
+		route := Route{Dst: netaddr.ToIPNet(dst)}
+		if r.GW != "" {
+			route.GW, err = parseIPv4(r.GW)
+			if err != nil {
+				return nestedError(fmt.Sprintf("ipam.routes[%d].gw", i), r.GW, err)
+			}
+		}
+		routes = append(routes, route)
+	}
+	c.StaticRoutes = routes
+	return nil
+}
+
+// parseStaticAddr parses one CIDR address and optional gateway, defaulting the
+// gateway to the network gateway.
+func (c *NetworkConfig) parseStaticAddr(address, gateway string) (StaticAddr, error) {
+	prefix, err := netip.ParsePrefix(address)
+	if err != nil {
+		return StaticAddr{}, fieldErrorf("address", address, "invalid CIDR: %v", err)
+	}
+	addr := prefix.Addr().Unmap()
+	if !addr.Is4() {
+		return StaticAddr{}, nestedError("address", address, errNotIPv4)
+	}
+	subnet := c.SubnetPrefix()
+	if !subnet.Contains(addr) {
+		return StaticAddr{}, fieldErrorf("address", address, "must be inside subnet")
+	}
+	if newSubnetBounds(subnet).edge(addr) || addr == c.GatewayAddr() {
+		return StaticAddr{}, fieldErrorf("address", address, "cannot be network, broadcast, or gateway")
+	}
+
+	gw := c.GatewayIP
+	if gateway != "" {
+		gw, err = parseIPv4(gateway)
+		if err != nil {
+			return StaticAddr{}, nestedError("gateway", gateway, err)
+		}
+	}
+	return StaticAddr{Addr: netaddr.ToIPNet(netip.PrefixFrom(addr, prefix.Bits())), Gateway: gw}, nil
+}
+
+// ApplyCNIArgs applies per-invocation CNI_ARGS. In static mode, IP=<cidr>[,<cidr>]
+// replaces the configured address list and at least one address is required.
+// In dynamic mode, IP=<ip> becomes an allocation hint. DSCP=<0-63> overrides
+// the network dscp mark and EGRESS_GATEWAY=<bool> selects or exempts the pod
+// from the network egress gateway in either mode.
+func (c *NetworkConfig) ApplyCNIArgs(raw string) error {
+	args := ParseCNIArgs(raw)
+	if value := args["EGRESS_GATEWAY"]; value != "" {
+		if err := c.applyEgressArg(value); err != nil {
+			return err
+		}
+	}
+	if value := args["DSCP"]; value != "" {
+		dscp, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("CNI_ARGS DSCP: %w", err)
+		}
+		if err := checkDSCP(dscp); err != nil {
+			return fmt.Errorf("CNI_ARGS DSCP: %d %w", dscp, err)
+		}
+		c.DSCP = &dscp
+	}
+	if value := args["SANDBOX"]; value != "" {
+		if err := c.applySandboxArg(value); err != nil {
+			return err
+		}
+	}
+	if value := args["MAC"]; value != "" {
+		if err := c.applyMACArg(value); err != nil {
+			return err
+		}
+	}
+	if c.IPAM.Type != IPAMTypeStatic {
+		if value := args["IP"]; value != "" {
+			hint, _, _ := strings.Cut(value, "/")
+			ip, err := parseIPv4(hint)
+			if err != nil {
+				return fmt.Errorf("CNI_ARGS IP: %w", err)
+			}
+			c.PreferredIP = ip
+		}
+		return nil
+	}
+
+	if value, ok := args["IP"]; ok && value != "" {
+		var addrs []StaticAddr
+		for i, cidr := range strings.Split(value, ",") {
+			parsed, err := c.parseStaticAddr(strings.TrimSpace(cidr), "")
+			if err != nil {
+				return fmt.Errorf("CNI_ARGS IP[%d]: %w", i, err)
+			}
+			addrs = append(addrs, parsed)
+		}
+		c.StaticAddrs = addrs
+	}
+
+	if len(c.StaticAddrs) == 0 {
+		return errors.New("static ipam requires ipam.addresses or IP in CNI_ARGS")
+	}
+	return nil
+}
+
+// ParseCNIArgs splits a CNI_ARGS string (K1=V1;K2=V2) into a map.
+func ParseCNIArgs(raw string) map[string]string {
+	out := map[string]string{}
+	for _, pair := range strings.Split(raw, ";") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			continue
+		}
+		out[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// cniKeys are standard CNI network config keys the plugin does not read.
+var cniKeys = map[string]bool{
+	"args":                      true,
+	"capabilities":              true,
+	"cni.dev/valid-attachments": true,
+	"prevResult":                true,
+}
+
+// Validate is Parse in strict mode for tooling: it reports every unknown key
+// (keys starting with "_" are comments) followed by every validation step
+// that fails, each as a *FieldError. It returns nil for a valid config.
+func Validate(stdin []byte) []*FieldError {
+	// Pod variables only have values during an operation; stand-ins let
+	// the rest of the config be checked.
+	stdin, err := Expand(stdin, validateVars())
+	if err != nil {
+		return []*FieldError{{Constraint: err.Error()}}
+	}
+	var problems []*FieldError
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(stdin))
+	dec.UseNumber()
+	if dec.Decode(&doc) == nil {
+		problems = unknownFields(doc, reflect.TypeOf(NetworkConfig{}), "")
+	}
+	cfg, errs := parse(stdin, true)
+	for _, err := range errs {
+		problems = append(problems, fieldError(stdin, err))
+	}
+	if len(errs) == 0 {
+		if err := cfg.CheckDeviceID(); err != nil {
+			// ADD refuses it though Parse does not.
+			problems = append(problems, fieldError(stdin, err))
+		}
+	}
+	return problems
+}
+
+// unknownFields walks doc against the JSON shape of t.
+func unknownFields(doc any, t reflect.Type, path string) []*FieldError {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice:
+		items, _ := doc.([]any)
+		var problems []*FieldError
+		for i, item := range items {
+			problems = append(problems, unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return problems
+	case reflect.Struct:
+	default:
+		return nil
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" || !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []*FieldError
+	for _, key := range keys {
+		keyPath := key
+		if path != "" {
+			keyPath = path + "." + key
+		}
+		ft, known := fields[key]
+		switch {
+		case strings.HasPrefix(key, "_"):
+		case !known && path == "" && cniKeys[key]:
+		case !known:
+			raw, _ := json.Marshal(obj[key])
+			problems = append(problems, &FieldError{Path: keyPath, Value: string(raw), Constraint: "unknown field"})
+		case ft == reflect.TypeOf(RuntimeConfig{}):
+			// Runtimes pass whichever capabilities the conflist enables.
+		case key == "networks":
+			problems = append(problems, unknownFields(obj[key], reflect.TypeOf([]NetworkConfig{}), keyPath)...)
+		default:
+			problems = append(problems, unknownFields(obj[key], ft, keyPath)...)
+		}
+	}
+	return problems
+}
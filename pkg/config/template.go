@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// DefaultTemplatesFile is where `template` is looked up when
+// `templatesFile` is unset. It lives outside the CNI config dir, which
+// runtimes scan for network configs.
+const DefaultTemplatesFile = "/etc/atomicni/templates.json"
+
+// Template is a named set of defaults shared by network configs: the DNS
+// block of the result, extra pod routes, and pod sysctls.
+type Template struct {
+	DNS     *DNSConfig        `json:"dns,omitempty"`
+	Routes  []RouteConfig     `json:"routes,omitempty"`
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+}
+
+// DNSConfig is the standard CNI `dns` block, returned in the ADD result.
+type DNSConfig struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// templatesDoc is the templates file: {"templates": {"<name>": Template}}.
+type templatesDoc struct {
+	Templates map[string]Template `json:"templates"`
+}
+
+// applyTemplate merges the named template into the config. Values the
+// network sets win: its `dns` replaces the template's, its routes follow
+// the template's, and its sysctls override keys the template also sets.
+func (c *NetworkConfig) applyTemplate() error {
+	if c.Template == "" {
+		if c.TemplatesFile != "" {
+			return fieldErrorf("templatesFile", c.TemplatesFile, "requires template")
+		}
+		return nil
+	}
+	path := c.TemplatesFile
+	if path == "" {
+		path = DefaultTemplatesFile
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nestedError("template", c.Template, err)
+	}
+	var doc templatesDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fieldErrorf("template", c.Template, "parse %s: %v", path, err)
+	}
+	t, ok := doc.Templates[c.Template]
+	if !ok {
+		return fieldErrorf("template", c.Template, "is not defined in %s", path)
+	}
+	if c.DNS == nil {
+		c.DNS = t.DNS
+	}
+	c.Routes = append(t.Routes, c.Routes...)
+	if len(t.Sysctls) > 0 {
+		sysctls := maps.Clone(t.Sysctls)
+		maps.Copy(sysctls, c.Sysctls)
+		c.Sysctls = sysctls
+	}
+	return nil
+}
+
+// parseDNS validates `dns`.
+func (c *NetworkConfig) parseDNS() error {
+	if c.DNS == nil {
+		return nil
+	}
+	for i, s := range c.DNS.Nameservers {
+		if net.ParseIP(s) == nil {
+			return fieldErrorf(fmt.Sprintf("dns.nameservers[%d]", i), s, "invalid IP address")
+		}
+	}
+	return nil
+}
+
+// parseRoutes validates `routes`, the extra pod routes installed for any
+// IPAM type; a missing gw means the pod's gateway.
+func (c *NetworkConfig) parseRoutes() error {
+	c.ExtraRoutes = nil
+	for i, r := range c.Routes {
+		dst, err := parseNetwork(r.Dst)
+		if err != nil {
+			return nestedError(fmt.Sprintf("routes[%d].dst", i), r.Dst, err)
+		}
+		route := Route{Dst: netaddr.ToIPNet(dst)}
+		if r.GW != "" {
+			if route.GW, err = parseIPv4(r.GW); err != nil {
+				return nestedError(fmt.Sprintf("routes[%d].gw", i), r.GW, err)
+			}
+		}
+		c.ExtraRoutes = append(c.ExtraRoutes, route)
+	}
+	return nil
+}
+
+// parseSysctls validates `sysctls`. Only net.* keys are accepted: they are
+// the ones scoped to the pod's network namespace.
+func (c *NetworkConfig) parseSysctls() error {
+	for key, value := range c.Sysctls {
+		parts := strings.Split(key, ".")
+		if len(parts) < 3 || parts[0] != "net" || strings.ContainsAny(key, "/ ") || strings.Contains(key, "..") {
+			return fieldErrorf("sysctls", key, "is not a net.* sysctl")
+		}
+		if value == "" || strings.ContainsAny(value, "\n") {
+			return fieldErrorf("sysctls."+key, value, "invalid value")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Environment variables naming the node for {{nodeName}} and {{nodeIP}}.
+// The node name falls back to the hostname; the node IP has no fallback.
+const (
+	EnvNodeName = "ATOMICNI_NODE_NAME"
+	EnvNodeIP   = "ATOMICNI_NODE_IP"
+)
+
+// varSources says where each template variable comes from, for errors
+// about unset ones.
+var varSources = map[string]string{
+	"nodeName":     EnvNodeName + " or the hostname",
+	"nodeIP":       EnvNodeIP,
+	"podNamespace": "K8S_POD_NAMESPACE in CNI_ARGS",
+	"podName":      "K8S_POD_NAME in CNI_ARGS",
+}
+
+// varRef matches {{name}}, with optional spaces inside the braces.
+var varRef = regexp.MustCompile(`\{\{\s*([A-Za-z]+)\s*\}\}`)
+
+// TemplateVars returns the template variables of one invocation: the node
+// from the environment and the pod from its CNI_ARGS. Unset ones are left
+// out.
+func TemplateVars(cniArgs string) map[string]string {
+	vars := map[string]string{}
+	if v := os.Getenv(EnvNodeName); v != "" {
+		vars["nodeName"] = v
+	} else if host, err := os.Hostname(); err == nil {
+		vars["nodeName"] = host
+	}
+	if v := os.Getenv(EnvNodeIP); v != "" {
+		vars["nodeIP"] = v
+	}
+	args := ParseCNIArgs(cniArgs)
+	if v := args["K8S_POD_NAMESPACE"]; v != "" {
+		vars["podNamespace"] = v
+	}
+	if v := args["K8S_POD_NAME"]; v != "" {
+		vars["podName"] = v
+	}
+	return vars
+}
+
+// Expand replaces each {{name}} in stdin with vars[name], escaped for a
+// JSON string. A reference to an unknown or unset variable is an error, so
+// a config never runs with a literal {{...}} in it.
+func Expand(stdin []byte, vars map[string]string) ([]byte, error) {
+	if !bytes.Contains(stdin, []byte("{{")) {
+		return stdin, nil
+	}
+	var err error
+	out := varRef.ReplaceAllFunc(stdin, func(ref []byte) []byte {
+		name := string(varRef.FindSubmatch(ref)[1])
+		source, known := varSources[name]
+		value, set := vars[name]
+		switch {
+		case err != nil:
+			return ref
+		case !known:
+			err = fmt.Errorf("{{%s}}: unknown template variable", name)
+			return ref
+		case !set:
+			err = fmt.Errorf("{{%s}}: %s is not set", name, source)
+			return ref
+		}
+		quoted, _ := json.Marshal(value)
+		return quoted[1 : len(quoted)-1]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// validateVars is TemplateVars with stand-ins for the pod variables.
+func validateVars() map[string]string {
+	return TemplateVars("K8S_POD_NAMESPACE=default;K8S_POD_NAME=validate")
+}
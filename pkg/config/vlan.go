@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// VLANTrunk is one `vlanTrunk` entry: a single `id` or a `minID`..`maxID` range.
+type VLANTrunk struct {
+	ID    *int `json:"id,omitempty"`
+	MinID *int `json:"minID,omitempty"`
+	MaxID *int `json:"maxID,omitempty"`
+}
+
+// parseVLANTrunk expands `vlanTrunk` into a sorted, de-duplicated VLAN list.
+func (c *NetworkConfig) parseVLANTrunk() error {
+	if len(c.VLANTrunk) == 0 {
+		return nil
+	}
+	if c.Mode != ModeBridge {
+		return fieldErrorf("vlanTrunk", nil, "only supported in bridge mode")
+	}
+
+	seen := map[int]bool{}
+	for i, t := range c.VLANTrunk {
+		path := fmt.Sprintf("vlanTrunk[%d]", i)
+		switch {
+		case t.ID != nil && (t.MinID != nil || t.MaxID != nil):
+			return fieldErrorf(path, t, "id and minID/maxID are mutually exclusive")
+		case t.ID != nil:
+			if err := checkVLANID(*t.ID); err != nil {
+				return nestedError(path+".id", *t.ID, err)
+			}
+			seen[*t.ID] = true
+		case t.MinID != nil && t.MaxID != nil:
+			if err := checkVLANID(*t.MinID); err != nil {
+				return nestedError(path+".minID", *t.MinID, err)
+			}
+			if err := checkVLANID(*t.MaxID); err != nil {
+				return nestedError(path+".maxID", *t.MaxID, err)
+			}
+			if *t.MinID > *t.MaxID {
+				return fieldErrorf(path+".minID", *t.MinID, "must be <= maxID")
+			}
+			for vid := *t.MinID; vid <= *t.MaxID; vid++ {
+				seen[vid] = true
+			}
+		default:
+			return fieldErrorf(path, t, "id or both minID and maxID are required")
+		}
+	}
+
+	c.VLANs = make([]int, 0, len(seen))
+	for vid := range seen {
+		c.VLANs = append(c.VLANs, vid)
+	}
+	sort.Ints(c.VLANs)
+	return nil
+}
+
+// checkVLANID rejects IDs outside the usable 802.1Q range.
+func checkVLANID(vid int) error {
+	if vid < 1 || vid > 4094 {
+		return errors.New("VLAN ID out of range 1-4094")
+	}
+	return nil
+}
+
+// QinQConfig stacks an 802.1ad service VLAN on an uplink and an 802.1Q
+// customer VLAN on top of it; the inner link is enslaved to the bridge.
+type QinQConfig struct {
+	Master    string `json:"master"`
+	SVLAN     int    `json:"sVlan"`
+	CVLAN     int    `json:"cVlan"`
+	OuterName string `json:"outerName,omitempty"`
+	InnerName string `json:"innerName,omitempty"`
+}
+
+// parseQinQ validates `qinq` and fills default sub-interface names
+// (<master>.<sVlan> and <outer>.<cVlan>).
+func (c *NetworkConfig) parseQinQ() error {
+	q := c.QinQ
+	if q == nil {
+		return nil
+	}
+	if c.Mode != ModeBridge {
+		return fieldErrorf("qinq", nil, "only supported in bridge mode")
+	}
+	if q.Master == "" {
+		return fieldErrorf("qinq.master", nil, "is required")
+	}
+	if err := checkVLANID(q.SVLAN); err != nil {
+		return nestedError("qinq.sVlan", q.SVLAN, err)
+	}
+	if err := checkVLANID(q.CVLAN); err != nil {
+		return nestedError("qinq.cVlan", q.CVLAN, err)
+	}
+	if q.OuterName == "" {
+		q.OuterName = fmt.Sprintf("%s.%d", q.Master, q.SVLAN)
+	}
+	if q.InnerName == "" {
+		q.InnerName = fmt.Sprintf("%s.%d", q.OuterName, q.CVLAN)
+	}
+	return nil
+}
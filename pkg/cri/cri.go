@@ -0,0 +1,76 @@
+// Package cri asks the container runtime whether pod sandboxes still exist so
+// reconciliation never reclaims an address from a live pod.
+package cri
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+const DefaultBinary = "crictl"
+
+// Client answers liveness questions about pod sandboxes.
+type Client interface {
+	SandboxExists(ctx context.Context, id string) (bool, error)
+}
+
+// CrictlClient queries a CRI runtime endpoint through the crictl tool.
+type CrictlClient struct {
+	Endpoint string
+	Binary   string
+}
+
+// NewClient returns a CRI client for a socket path or unix:// endpoint.
+func NewClient(endpoint string) *CrictlClient {
+	if endpoint != "" && !strings.Contains(endpoint, "://") {
+		endpoint = "unix://" + endpoint
+	}
+	return &CrictlClient{Endpoint: endpoint, Binary: DefaultBinary}
+}
+
+// SandboxExists reports whether the runtime still knows the pod sandbox ID.
+func (c *CrictlClient) SandboxExists(ctx context.Context, id string) (bool, error) {
+	if id == "" {
+		return false, errors.New("sandbox id is required")
+	}
+	binary := c.Binary
+	if binary == "" {
+		binary = DefaultBinary
+	}
+
+	args := []string{}
+	if c.Endpoint != "" {
+		args = append(args, "--runtime-endpoint", c.Endpoint)
+	}
+	args = append(args, "inspectp", "-o", "json", id)
+
+	cmd, audit, err := netops.Command(ctx, binary, args...)
+	if err != nil {
+		return false, fmt.Errorf("inspect sandbox %q: %w", id, err)
+	}
+	out, err := cmd.CombinedOutput()
+	audit(err)
+	if err == nil {
+		return true, nil
+	}
+	output := strings.TrimSpace(string(out))
+	if isNotFound(output) {
+		return false, nil
+	}
+	if output == "" {
+		output = err.Error()
+	}
+	return false, fmt.Errorf("inspect sandbox %q: %s", id, output)
+}
+
+// isNotFound matches the not-found forms reported by containerd and CRI-O.
+func isNotFound(output string) bool {
+	lower := strings.ToLower(output)
+	return strings.Contains(lower, "notfound") ||
+		strings.Contains(lower, "not found") ||
+		strings.Contains(lower, "does not exist")
+}
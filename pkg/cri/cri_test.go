@@ -0,0 +1,51 @@
+package cri
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+func fakeCrictl(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "crictl")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatalf("write fake crictl: %v", err)
+	}
+	return path
+}
+
+func TestSandboxExists(t *testing.T) {
+	client := NewClient("/run/containerd/containerd.sock")
+	if client.Endpoint != "unix:///run/containerd/containerd.sock" {
+		t.Fatalf("unexpected endpoint: %q", client.Endpoint)
+	}
+
+	client.Binary = fakeCrictl(t, `
+case "$6" in
+  live) echo '{}' ;;
+  gone) echo 'rpc error: code = NotFound desc = an error occurred' >&2; exit 1 ;;
+  *) echo 'connection refused' >&2; exit 1 ;;
+esac
+`)
+	if _, err := client.SandboxExists(context.Background(), "live"); !errors.Is(err, netops.ErrExecDenied) {
+		t.Fatalf("SandboxExists() ran a binary outside the exec allow-list: %v", err)
+	}
+	defer netops.SetExecPolicy(netops.ExecPolicy{Allow: []string{client.Binary}})()
+
+	ok, err := client.SandboxExists(context.Background(), "live")
+	if err != nil || !ok {
+		t.Fatalf("expected live sandbox, ok=%v err=%v", ok, err)
+	}
+	ok, err = client.SandboxExists(context.Background(), "gone")
+	if err != nil || ok {
+		t.Fatalf("expected missing sandbox, ok=%v err=%v", ok, err)
+	}
+	if _, err := client.SandboxExists(context.Background(), "broken"); err == nil {
+		t.Fatalf("expected runtime error to be reported")
+	}
+}
@@ -0,0 +1,200 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// NetworkStatus is one entry of GET /networks.
+type NetworkStatus struct {
+	Name        string `json:"name"`
+	Allocations int    `json:"allocations"`
+	Attachments int    `json:"attachments"`
+}
+
+// Allocation is one entry of GET /allocations.
+type Allocation struct {
+	Network string `json:"network"`
+	Owner   string `json:"owner"`
+	IP      string `json:"ip"`
+}
+
+// apiRoutes registers the read-only state API: node agents and dashboards
+// query it instead of parsing the data dir.
+func (d *Daemon) apiRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", d.handleHealthz)
+	mux.HandleFunc("GET /networks", d.handleNetworks)
+	mux.HandleFunc("GET /allocations", d.handleAllocations)
+	mux.HandleFunc("GET /attachments/{id}", d.handleAttachment)
+	mux.HandleFunc("GET /history", d.handleHistory)
+}
+
+// handleHealthz reports whether the data dir is reachable.
+func (d *Daemon) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	if _, err := os.Stat(d.Opts.DataDir); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleNetworks lists networks with IPAM state and their counts.
+func (d *Daemon) handleNetworks(w http.ResponseWriter, r *http.Request) {
+	networks, err := d.Plugin.IPAM.Networks(r.Context(), d.Opts.DataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	attachments, err := cache.List(d.Opts.DataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	perNetwork := map[string]int{}
+	for _, a := range attachments {
+		perNetwork[a.Network]++
+	}
+	out := []NetworkStatus{}
+	for _, n := range networks {
+		allocations, err := d.Plugin.IPAM.List(r.Context(), d.Opts.DataDir, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = append(out, NetworkStatus{Name: n, Allocations: len(allocations), Attachments: perNetwork[n]})
+	}
+	d.writeJSON(w, out)
+}
+
+// handleAllocations lists allocations, of one network with ?network=.
+func (d *Daemon) handleAllocations(w http.ResponseWriter, r *http.Request) {
+	networks := []string{r.URL.Query().Get("network")}
+	if networks[0] == "" {
+		var err error
+		if networks, err = d.Plugin.IPAM.Networks(r.Context(), d.Opts.DataDir); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	out := []Allocation{}
+	for _, n := range networks {
+		allocations, err := d.Plugin.IPAM.List(r.Context(), d.Opts.DataDir, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for owner, ip := range allocations {
+			out = append(out, Allocation{Network: n, Owner: owner, IP: ip.String()})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Network != out[j].Network {
+			return out[i].Network < out[j].Network
+		}
+		return out[i].Owner < out[j].Owner
+	})
+	d.writeJSON(w, out)
+}
+
+// handleAttachment returns every cached attachment of a container, one per
+// network and interface.
+func (d *Daemon) handleAttachment(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	attachments, err := cache.List(d.Opts.DataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var out []*cache.Attachment
+	for _, a := range attachments {
+		if a.ContainerID == id {
+			out = append(out, a)
+		}
+	}
+	if len(out) == 0 {
+		http.Error(w, "no attachment for container "+id, http.StatusNotFound)
+		return
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key() < out[j].Key() })
+	d.writeJSON(w, out)
+}
+
+// HistoryEntry is one entry of GET /history.
+type HistoryEntry struct {
+	Network string `json:"network"`
+	ipam.HistoryRecord
+}
+
+// handleHistory answers ?ip=A[&at=T][&network=N] with the owners holding A
+// at T (RFC 3339, default now).
+func (d *Daemon) handleHistory(w http.ResponseWriter, r *http.Request) {
+	reader, ok := d.Plugin.IPAM.(ipam.HistoryReader)
+	if !ok {
+		http.Error(w, "allocator keeps no history", http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query()
+	addr, ok := netaddr.ParseV4(query.Get("ip"))
+	if !ok {
+		http.Error(w, "ip: want an IPv4 address", http.StatusBadRequest)
+		return
+	}
+	ip := netaddr.ToIP(addr)
+	at := time.Now()
+	if value := query.Get("at"); value != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "at: want an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	networks := []string{query.Get("network")}
+	if networks[0] == "" {
+		var err error
+		if networks, err = d.Plugin.IPAM.Networks(r.Context(), d.Opts.DataDir); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	out := []HistoryEntry{}
+	for _, n := range networks {
+		records, err := reader.History(r.Context(), d.Opts.DataDir, n)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, rec := range ipam.WhoHad(records, ip, at) {
+			out = append(out, HistoryEntry{Network: n, HistoryRecord: rec})
+		}
+	}
+	d.writeJSON(w, out)
+}
+
+func (d *Daemon) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		d.Logger.Printf("api: %v", err)
+	}
+}
+
+// isLoopback reports whether a listen address only accepts local clients.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,350 @@
+// Package daemon runs AtomicNI as a long-lived node agent (atomicnid) that
+// performs maintenance the one-shot CNI binary cannot, such as periodic GC.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/clock"
+	"github.com/annis-souames/atomicni/pkg/events"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/metrics"
+)
+
+const DefaultGCInterval = 5 * time.Minute
+
+// DefaultDrainTimeout bounds how long shutdown waits for in-flight work.
+const DefaultDrainTimeout = 30 * time.Second
+
+// gatewayTick is how often the gateway monitor looks for probes that are
+// due; probe intervals are whole seconds.
+const gatewayTick = time.Second
+
+// Options configures the daemon.
+type Options struct {
+	DataDir    string
+	GCInterval time.Duration
+	// CRISocket optionally points at the container runtime so GC can confirm
+	// a sandbox is gone before reclaiming its address. It overrides the
+	// criSocket key of recorded network configs.
+	CRISocket string
+	// ListenAddr optionally serves /metrics and /metrics.json over HTTP, plus
+	// the state API when it is a loopback address.
+	ListenAddr string
+	// APISocket optionally serves metrics and the state API on a unix socket.
+	APISocket string
+	// EventsFile and EventsSocket receive ip.released events for GC reclaims.
+	EventsFile   string
+	EventsSocket string
+	// LeaderElect makes replicas sharing DataDir elect one leader through a
+	// file lease so pool-wide maintenance runs exactly once.
+	LeaderElect bool
+	// Identity names this replica in the lease; defaults to host/pid.
+	Identity string
+	// ReapplyRules re-installs recorded host rules flushed by external
+	// tooling after every GC pass; missing rules are always logged.
+	ReapplyRules bool
+	// DrainTimeout bounds how long a running GC pass and in-flight API
+	// requests may take to finish once shutdown starts.
+	DrainTimeout time.Duration
+}
+
+// Daemon owns the periodic maintenance loops.
+type Daemon struct {
+	Plugin *atomicni.Plugin
+	Opts   Options
+	Logger *log.Logger
+	// Clock drives the GC, watchdog, and gateway probe loops; nil means the
+	// system clock.
+	Clock clock.Clock
+}
+
+// New returns a daemon with defaults applied to zero-valued options.
+func New(plugin *atomicni.Plugin, opts Options, logger *log.Logger) *Daemon {
+	if opts.GCInterval <= 0 {
+		opts.GCInterval = DefaultGCInterval
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = DefaultDrainTimeout
+	}
+	if logger == nil {
+		logger = log.Default()
+	}
+	if opts.Identity == "" {
+		opts.Identity = defaultIdentity()
+	}
+	if plugin != nil {
+		useCRI(plugin, opts.CRISocket)
+	}
+	if plugin != nil && plugin.Events == nil {
+		plugin.Events = events.New(opts.EventsFile, opts.EventsSocket)
+	}
+	// Allocations made here, such as GC reclaims, land in the same journal
+	// as the plugin's so /metrics counts both.
+	if plugin != nil {
+		if fa, ok := plugin.IPAM.(*ipam.FileAllocator); ok {
+			if _, nop := fa.Metrics.(ipam.NopMetricsSink); nop || fa.Metrics == nil {
+				fa.Metrics = metrics.IPAMJournal{}
+			}
+		}
+	}
+	return &Daemon{Plugin: plugin, Opts: opts, Logger: logger}
+}
+
+// Run performs one GC pass immediately and then one per interval until ctx
+// ends. Under systemd it reports readiness and status, pings the watchdog,
+// and serves the state API on an activated socket. When ctx ends, a running
+// pass and in-flight API requests get DrainTimeout to finish.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Plugin == nil {
+		return errors.New("daemon has nil plugin")
+	}
+	if d.Opts.DataDir == "" {
+		return errors.New("daemon data dir is required")
+	}
+
+	activated, err := ActivationListeners()
+	if err != nil {
+		return err
+	}
+	if d.Opts.ListenAddr != "" {
+		ln, err := net.Listen("tcp", d.Opts.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", d.Opts.ListenAddr, err)
+		}
+		// The state API names pods and their addresses, so it stays off
+		// listeners other hosts can reach.
+		handler := d.MetricsHandler()
+		if isLoopback(d.Opts.ListenAddr) {
+			handler = d.Handler()
+		}
+		defer d.serve(ln, handler)()
+	}
+	if ln := activatedAPI(activated); ln != nil {
+		// systemd owns the socket file; it outlives this process.
+		defer d.serve(ln, d.Handler())()
+	} else if d.Opts.APISocket != "" {
+		_ = os.Remove(d.Opts.APISocket)
+		ln, err := net.Listen("unix", d.Opts.APISocket)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", d.Opts.APISocket, err)
+		}
+		defer os.Remove(d.Opts.APISocket)
+		defer d.serve(ln, d.Handler())()
+	}
+	for name, ln := range activated {
+		if ln != activatedAPI(activated) {
+			d.Logger.Printf("systemd: ignoring activated socket %q", name)
+			ln.Close()
+		}
+	}
+
+	// Work outlives ctx by up to DrainTimeout so a pass is not cut short
+	// halfway through releasing addresses.
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	stopDrain := context.AfterFunc(ctx, func() { time.AfterFunc(d.Opts.DrainTimeout, cancelWork) })
+	defer stopDrain()
+
+	var lease *FileLease
+	if d.Opts.LeaderElect {
+		lease = NewFileLease(filepath.Join(d.Opts.DataDir, LeaderLockName), d.Opts.Identity)
+		defer lease.Release()
+	}
+
+	d.notify("READY=1")
+	defer d.notify("STOPPING=1")
+	var health loopHealth
+	if interval := WatchdogInterval(); interval > 0 {
+		go d.watchdog(ctx, interval, &health)
+	}
+
+	// Only the leader moves egress routes, so replicas never fight over them.
+	var probing atomic.Bool
+	go d.monitorGateways(ctx, &probing)
+
+	ticker := clock.Or(d.Clock).NewTicker(d.Opts.GCInterval)
+	defer ticker.Stop()
+
+	leading, known := false, false
+	for {
+		health.busy.Store(true)
+		if lease == nil {
+			probing.Store(true)
+			d.runGC(work)
+		} else if ok, err := lease.TryAcquire(); err != nil {
+			d.Logger.Printf("leader: %v", err)
+		} else {
+			if ok != leading || !known {
+				if ok {
+					d.Logger.Printf("leader: acquired as %s", d.Opts.Identity)
+					d.notify("STATUS=leader")
+				} else {
+					d.Logger.Printf("leader: standing by, held by %s", lease.Holder())
+					d.notify("STATUS=standing by, leader is " + lease.Holder())
+				}
+				leading, known = ok, true
+			}
+			probing.Store(ok)
+			if ok {
+				d.runGC(work)
+			}
+		}
+		health.beats.Add(1)
+		health.busy.Store(false)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C():
+		}
+	}
+}
+
+// activatedAPI picks the activated socket serving the state API: the one
+// named APISocketName, or the only one when it is unnamed.
+func activatedAPI(activated map[string]net.Listener) net.Listener {
+	if ln, ok := activated[APISocketName]; ok {
+		return ln
+	}
+	if ln, ok := activated[""]; ok && len(activated) == 1 {
+		return ln
+	}
+	return nil
+}
+
+// notify forwards state to systemd, logging failures.
+func (d *Daemon) notify(state string) {
+	if _, err := Notify(state); err != nil {
+		d.Logger.Printf("systemd: %v", err)
+	}
+}
+
+// loopHealth records the main loop's progress for the watchdog.
+type loopHealth struct {
+	busy  atomic.Bool
+	beats atomic.Uint64
+	// last is the beat count at the previous ping; only the watchdog uses it.
+	last uint64
+}
+
+// progressed reports whether the main loop is idle or has finished an
+// iteration since the previous call, i.e. whether it is not wedged.
+func (h *loopHealth) progressed() bool {
+	beats := h.beats.Load()
+	if h.busy.Load() && beats == h.last {
+		return false
+	}
+	h.last = beats
+	return true
+}
+
+// watchdog pings the systemd watchdog every interval until ctx ends, as long
+// as the main loop keeps making progress. A GC pass wedged for longer than
+// WatchdogSec stops the pings, so systemd restarts the daemon.
+func (d *Daemon) watchdog(ctx context.Context, interval time.Duration, health *loopHealth) {
+	ticker := clock.Or(d.Clock).NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !health.progressed() {
+				d.Logger.Printf("systemd: main loop busy since the last watchdog ping, skipping it")
+				continue
+			}
+			d.notify("WATCHDOG=1")
+		}
+	}
+}
+
+// serve serves handler on ln in the background and returns a function
+// stopping it, letting in-flight requests finish within DrainTimeout.
+func (d *Daemon) serve(ln net.Listener, handler http.Handler) func() {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			d.Logger.Printf("http: %v", err)
+		}
+	}()
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), d.Opts.DrainTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			d.Logger.Printf("http: drain: %v", err)
+			_ = srv.Close()
+		}
+	}
+}
+
+// runGC executes one reconciliation pass and logs its outcome. It also folds
+// the ADD metrics journal into the snapshot and posts pending Node events.
+func (d *Daemon) runGC(ctx context.Context) {
+	if err := metrics.Compact(d.Opts.DataDir); err != nil {
+		d.Logger.Printf("metrics: %v", err)
+	}
+	d.postNodeWarnings(ctx)
+	report, err := d.Plugin.GC(ctx, d.Opts.DataDir)
+	if err != nil {
+		d.Logger.Printf("gc: %v", err)
+	}
+	if report == nil {
+		return
+	}
+	summary := fmt.Sprintf("checked=%d released=%d pruned=%d kept=%d orphans=%d", report.Checked, len(report.Released), len(report.Pruned), len(report.Kept), len(report.Orphans))
+	d.Logger.Printf("gc: %s", summary)
+	d.notify("STATUS=last gc: " + summary)
+	d.runRules(ctx)
+}
+
+// monitorGateways probes egress gateways that have a backup, failing over
+// and back, while enabled is set and until ctx ends.
+func (d *Daemon) monitorGateways(ctx context.Context, enabled *atomic.Bool) {
+	monitor := atomicni.NewGatewayMonitor(d.Plugin, d.Opts.DataDir)
+	monitor.Clock = d.Clock
+	ticker := clock.Or(d.Clock).NewTicker(gatewayTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		if !enabled.Load() {
+			continue
+		}
+		report, err := monitor.Probe(ctx)
+		if err != nil && ctx.Err() == nil {
+			d.Logger.Printf("gateways: %v", err)
+		}
+		if report != nil {
+			for _, s := range report.Switched {
+				d.Logger.Printf("gateways: %s", s)
+			}
+		}
+	}
+}
+
+// runRules reports, and optionally re-applies, host rules that disappeared or
+// changed.
+func (d *Daemon) runRules(ctx context.Context) {
+	report, err := d.Plugin.ReconcileRules(ctx, d.Opts.DataDir, d.Opts.ReapplyRules)
+	if err != nil {
+		d.Logger.Printf("rules: %v", err)
+	}
+	if report == nil || len(report.Missing)+len(report.Changed) == 0 {
+		return
+	}
+	d.Logger.Printf("rules: checked=%d missing=%d changed=%d reapplied=%d",
+		report.Checked, len(report.Missing), len(report.Changed), len(report.Reapplied))
+}
@@ -0,0 +1,412 @@
+package daemon
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/clock"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+	"github.com/annis-souames/atomicni/pkg/metrics"
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+// logLines hands each line a daemon logs to the test.
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	l <- string(p)
+	return len(p), nil
+}
+
+// next returns the first logged line containing substr.
+func (l logLines) next(t *testing.T, substr string) string {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line := <-l:
+			if strings.Contains(line, substr) {
+				return line
+			}
+		case <-timeout:
+			t.Fatalf("no log line containing %q", substr)
+		}
+	}
+}
+
+// drain collects the lines logged until Run returns its error on done.
+func (l logLines) drain(done <-chan error) (string, error) {
+	var b strings.Builder
+	for {
+		select {
+		case line := <-l:
+			b.WriteString(line)
+		case err := <-done:
+			return b.String(), err
+		}
+	}
+}
+
+func TestRunPerformsGCUntilCancelled(t *testing.T) {
+	lines := make(logLines)
+	fake := clock.NewFake(time.Unix(1000, 0))
+	plugin := &atomicni.Plugin{NetOps: netops.NewNetlinkOps(), IPAM: ipam.NewFileAllocator()}
+	d := New(plugin, Options{DataDir: t.TempDir(), GCInterval: time.Hour}, log.New(lines, "", 0))
+	d.Clock = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	lines.next(t, "gc: checked=0")
+	// The GC and gateway probe loops are both waiting on the clock.
+	fake.BlockUntil(2)
+	for i := 0; i < 2; i++ {
+		fake.Advance(time.Hour)
+		lines.next(t, "gc: checked=0")
+	}
+	cancel()
+	if _, err := lines.drain(done); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	if err := metrics.NewFileRecorder().Observe(dir, metrics.Observation{Network: "atomic-net", Operation: "ADD"}); err != nil {
+		t.Fatalf("Observe: %v", err)
+	}
+
+	d := New(&atomicni.Plugin{}, Options{DataDir: dir}, log.New(io.Discard, "", 0))
+	rec := httptest.NewRecorder()
+	d.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `atomicni_operations_total{network="atomic-net",operation="ADD"} 1`) {
+		t.Fatalf("unexpected body:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetricsEndpointIncludesPluginIPAM(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	// The allocations happen in a separate plugin, as in a CNI invocation.
+	alloc := atomicni.NewPlugin().IPAM
+	req := ipam.AllocationRequest{
+		DataDir:    dir,
+		Network:    "atomic-net",
+		Subnet:     &net.IPNet{IP: net.IPv4(10, 22, 0, 0).To4(), Mask: net.CIDRMask(24, 32)},
+		Gateway:    net.IPv4(10, 22, 0, 1),
+		RangeStart: net.IPv4(10, 22, 0, 10),
+		RangeEnd:   net.IPv4(10, 22, 0, 10),
+	}
+	req.ContainerID = "c1"
+	if _, err := alloc.Allocate(ctx, req); err != nil {
+		t.Fatalf("Allocate(c1): %v", err)
+	}
+	req.ContainerID = "c2"
+	if _, err := alloc.Allocate(ctx, req); err == nil {
+		t.Fatal("Allocate(c2) succeeded on an exhausted range")
+	}
+
+	d := New(&atomicni.Plugin{}, Options{DataDir: dir}, log.New(io.Discard, "", 0))
+	rec := httptest.NewRecorder()
+	d.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status %d", rec.Code)
+	}
+	for _, want := range []string{
+		`atomicni_ipam_allocations_total{network="atomic-net"} 1`,
+		`atomicni_ipam_exhausted_total{network="atomic-net"} 1`,
+		`atomicni_ipam_lock_wait_seconds_count{network="atomic-net"} 2`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("body missing %q:\n%s", want, rec.Body.String())
+		}
+	}
+}
+
+func TestFileLeaseIsExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LeaderLockName)
+	a := NewFileLease(path, "node-a/1")
+	b := NewFileLease(path, "node-b/2")
+
+	if ok, err := a.TryAcquire(); err != nil || !ok {
+		t.Fatalf("a.TryAcquire() = %v, %v; want true", ok, err)
+	}
+	if ok, err := b.TryAcquire(); err != nil || ok {
+		t.Fatalf("b.TryAcquire() = %v, %v; want false while a leads", ok, err)
+	}
+	if got := b.Holder(); got != "node-a/1" {
+		t.Fatalf("Holder() = %q", got)
+	}
+
+	a.Release()
+	if ok, err := b.TryAcquire(); err != nil || !ok {
+		t.Fatalf("b.TryAcquire() after release = %v, %v; want true", ok, err)
+	}
+	if got := a.Holder(); got != "node-b/2" {
+		t.Fatalf("Holder() after failover = %q", got)
+	}
+}
+
+func TestRunSkipsGCWhenNotLeader(t *testing.T) {
+	dir := t.TempDir()
+	holder := NewFileLease(filepath.Join(dir, LeaderLockName), "other/1")
+	if ok, err := holder.TryAcquire(); err != nil || !ok {
+		t.Fatalf("TryAcquire: %v, %v", ok, err)
+	}
+	defer holder.Release()
+
+	lines := make(logLines)
+	fake := clock.NewFake(time.Unix(1000, 0))
+	plugin := &atomicni.Plugin{NetOps: netops.NewNetlinkOps(), IPAM: ipam.NewFileAllocator()}
+	d := New(plugin, Options{DataDir: dir, GCInterval: time.Hour, LeaderElect: true, Identity: "me/2"}, log.New(lines, "", 0))
+	d.Clock = fake
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	if line := lines.next(t, "leader:"); !strings.Contains(line, "standing by, held by other/1") {
+		t.Fatalf("follower did not report the leader: %q", line)
+	}
+	fake.BlockUntil(2)
+	fake.Advance(3 * time.Hour)
+	cancel()
+	out, err := lines.drain(done)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if strings.Contains(out, "gc: checked") {
+		t.Fatalf("follower ran GC, log:\n%s", out)
+	}
+}
+
+func TestStateAPI(t *testing.T) {
+	dir := t.TempDir()
+	alloc := ipam.NewFileAllocator()
+	_, subnet, _ := net.ParseCIDR("10.22.0.0/24")
+	if _, err := alloc.Allocate(context.Background(), ipam.AllocationRequest{
+		DataDir: dir, Network: "atomic-net", ContainerID: "c1", Subnet: subnet, Gateway: net.ParseIP("10.22.0.1").To4(),
+		RangeStart: net.ParseIP("10.22.0.2").To4(), RangeEnd: net.ParseIP("10.22.0.254").To4(),
+	}); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if err := cache.Save(dir, &cache.Attachment{Network: "atomic-net", ContainerID: "c1", IfName: "eth0"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	d := New(&atomicni.Plugin{IPAM: alloc}, Options{DataDir: dir}, log.New(io.Discard, "", 0))
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		d.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	for path, want := range map[string]string{
+		"/healthz":                        "ok",
+		"/networks":                       `[{"name":"atomic-net","allocations":1,"attachments":1}]`,
+		"/allocations?network=atomic-net": `[{"network":"atomic-net","owner":"c1","ip":"10.22.0.2"}]`,
+		"/attachments/c1":                 `"containerID":"c1"`,
+		"/history?ip=10.22.0.2":           `"network":"atomic-net","ip":"10.22.0.2","owner":"c1"`,
+	} {
+		rec := get(path)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Fatalf("GET %s = %d %s, want %s", path, rec.Code, rec.Body.String(), want)
+		}
+	}
+	if rec := get("/history?ip=10.22.0.2&at=2001-01-01T00:00:00Z"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Fatalf("GET /history before allocation = %d %s, want []", rec.Code, rec.Body.String())
+	}
+	if rec := get("/attachments/missing"); rec.Code != http.StatusNotFound {
+		t.Fatalf("GET /attachments/missing = %d, want 404", rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	d.MetricsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/networks", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("MetricsHandler served /networks with %d", rec.Code)
+	}
+}
+
+func TestAPISocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "api.sock")
+	plugin := &atomicni.Plugin{NetOps: netops.NewNetlinkOps(), IPAM: ipam.NewFileAllocator()}
+	d := New(plugin, Options{DataDir: dir, GCInterval: time.Hour, APISocket: socket}, log.New(io.Discard, "", 0))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	var resp *http.Response
+	var err error
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if resp, err = client.Get("http://atomicnid/healthz"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("GET /healthz over %s: %v", socket, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /healthz = %d", resp.StatusCode)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:9723": true,
+		"[::1]:9723":     true,
+		"localhost:9723": true,
+		":9723":          false,
+		"0.0.0.0:9723":   false,
+		"10.0.0.5:9723":  false,
+	} {
+		if got := isLoopback(addr); got != want {
+			t.Errorf("isLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestRunNotifiesSystemd(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen notify socket: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", addr)
+
+	plugin := &atomicni.Plugin{NetOps: netops.NewNetlinkOps(), IPAM: ipam.NewFileAllocator()}
+	d := New(plugin, Options{DataDir: dir, GCInterval: time.Hour}, log.New(io.Discard, "", 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var got []string
+	buf := make([]byte, 256)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		got = append(got, string(buf[:n]))
+		if got[len(got)-1] == "STOPPING=1" {
+			break
+		}
+	}
+	want := []string{"READY=1", "STATUS=last gc: checked=0 released=0 pruned=0 kept=0 orphans=0", "STOPPING=1"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("notifications = %q, want %q", got, want)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Fatalf("Notify() = %v, %v; want false, nil", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "10000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 5*time.Second {
+		t.Fatalf("WatchdogInterval() = %v, want 5s", got)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := WatchdogInterval(); got != 0 {
+		t.Fatalf("WatchdogInterval() for another pid = %v, want 0", got)
+	}
+}
+
+func TestLoopHealthStopsOnWedgedIteration(t *testing.T) {
+	var h loopHealth
+	if !h.progressed() {
+		t.Fatalf("idle loop reported as stalled")
+	}
+	h.busy.Store(true)
+	if h.progressed() {
+		t.Fatalf("iteration without progress reported as progressing")
+	}
+	h.beats.Add(1)
+	if !h.progressed() {
+		t.Fatalf("finished iteration not reported as progress")
+	}
+	if h.progressed() {
+		t.Fatalf("same iteration counted twice")
+	}
+	h.busy.Store(false)
+	if !h.progressed() {
+		t.Fatalf("idle loop reported as stalled")
+	}
+}
+
+func TestActivationListenersIgnoresOtherPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := ActivationListeners()
+	if err != nil || listeners != nil {
+		t.Fatalf("ActivationListeners() = %v, %v; want nil, nil", listeners, err)
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Fatal("LISTEN_FDS not cleared")
+	}
+}
+
+func TestRunGCPostsPendingNodeWarnings(t *testing.T) {
+	if !buildinfo.K8s {
+		t.Skip("built with atomicni_nok8s")
+	}
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	kubectl := filepath.Join(dir, "kubectl")
+	if err := os.WriteFile(kubectl, []byte("#!/bin/sh\necho \"$@\" >> "+calls+"\ncat > /dev/null\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// atomicnid runs the built-in exec allow-list, which has kubectl.
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	marker := `{"network":"atomic-net","kubeconfig":"/etc/kubernetes/kubelet.conf","nodeName":"node-1","message":"pool is 90% allocated"}`
+	if err := os.WriteFile(filepath.Join(dir, "atomic-net.warned"), []byte(marker), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(&atomicni.Plugin{NetOps: netops.NewNetlinkOps(), IPAM: ipam.NewFileAllocator()}, Options{DataDir: dir}, log.New(io.Discard, "", 0))
+	d.postNodeWarnings(context.Background())
+	d.postNodeWarnings(context.Background())
+
+	content, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("kubectl never ran: %v", err)
+	}
+	if got := strings.Count(string(content), "create -f -"); got != 1 {
+		t.Fatalf("kubectl ran %d times, want once:\n%s", got, content)
+	}
+	if pending, err := atomicni.PendingNodeWarnings(dir); err != nil || len(pending) != 0 {
+		t.Fatalf("warning still pending after post: %+v, %v", pending, err)
+	}
+}
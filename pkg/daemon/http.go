@@ -0,0 +1,74 @@
+package daemon
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/annis-souames/atomicni/pkg/metrics"
+)
+
+// Handler returns the daemon HTTP routes: metrics and the state API.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	d.metricsRoutes(mux)
+	d.apiRoutes(mux)
+	return mux
+}
+
+// MetricsHandler returns only the metrics routes, for listeners reachable
+// from other hosts.
+func (d *Daemon) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	d.metricsRoutes(mux)
+	return mux
+}
+
+func (d *Daemon) metricsRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", d.handleMetrics)
+	mux.HandleFunc("GET /metrics.json", d.handleMetricsJSON)
+}
+
+// handleMetrics serves the persisted operation counters in Prometheus format.
+func (d *Daemon) handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	snap, err := metrics.Load(d.Opts.DataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.WritePrometheus(w, snap); err != nil {
+		d.Logger.Printf("metrics: %v", err)
+		return
+	}
+	// Nodes that never configured connLimit may lack nft entirely, so a
+	// failed read just omits the series.
+	if d.Plugin != nil && d.Plugin.NetOps != nil {
+		if drops, err := d.Plugin.NetOps.ConnLimitDrops(); err == nil && len(drops) > 0 {
+			if err := metrics.WriteConnLimitDrops(w, drops); err != nil {
+				d.Logger.Printf("metrics: %v", err)
+			}
+		}
+		if links, err := d.Plugin.NetOps.ListOwnedLinks("", d.Plugin.HostPrefix()); err == nil {
+			counts := map[string]int{}
+			for _, l := range links {
+				counts[l.Master]++
+			}
+			if err := metrics.WriteHostVeths(w, counts); err != nil {
+				d.Logger.Printf("metrics: %v", err)
+			}
+		}
+	}
+}
+
+// handleMetricsJSON serves the raw persisted snapshot.
+func (d *Daemon) handleMetricsJSON(w http.ResponseWriter, _ *http.Request) {
+	snap, err := metrics.Load(d.Opts.DataDir)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		d.Logger.Printf("metrics: %v", err)
+	}
+}
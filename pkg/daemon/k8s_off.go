@@ -0,0 +1,17 @@
+//go:build atomicni_nok8s
+
+package daemon
+
+import (
+	"context"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+)
+
+// useCRI is a no-op: the CRI client is not compiled in, and atomicnid refuses
+// --cri-socket.
+func useCRI(*atomicni.Plugin, string) {}
+
+// postNodeWarnings is a no-op: the Node event writer is not compiled in, and
+// configs asking for Node events are rejected.
+func (d *Daemon) postNodeWarnings(context.Context) {}
@@ -0,0 +1,46 @@
+//go:build !atomicni_nok8s
+
+package daemon
+
+import (
+	"context"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/atomicni"
+	"github.com/annis-souames/atomicni/pkg/cri"
+	"github.com/annis-souames/atomicni/pkg/kube"
+)
+
+// useCRI makes GC confirm sandboxes are gone with the runtime at socket
+// before reclaiming their addresses.
+func useCRI(plugin *atomicni.Plugin, socket string) {
+	if socket != "" && plugin.Runtime == nil {
+		plugin.Runtime = cri.NewClient(socket)
+	}
+}
+
+// nodeEventTimeout bounds one Node event write so a slow API server cannot
+// stall the pass.
+const nodeEventTimeout = 5 * time.Second
+
+// postNodeWarnings posts the pool warnings ADD recorded as Node events. A
+// failed post is retried on the next pass.
+func (d *Daemon) postNodeWarnings(ctx context.Context) {
+	pending, err := atomicni.PendingNodeWarnings(d.Opts.DataDir)
+	if err != nil {
+		d.Logger.Printf("node events: %v", err)
+		return
+	}
+	for _, w := range pending {
+		postCtx, cancel := context.WithTimeout(ctx, nodeEventTimeout)
+		err := kube.NewEventClient(w.Kubeconfig).NodeWarning(postCtx, w.NodeName, atomicni.ExhaustionReason, w.Message)
+		cancel()
+		if err != nil {
+			d.Logger.Printf("node events: %v", err)
+			continue
+		}
+		if err := atomicni.MarkNodeWarningPosted(d.Opts.DataDir, w); err != nil {
+			d.Logger.Printf("node events: %v", err)
+		}
+	}
+}
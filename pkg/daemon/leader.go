@@ -0,0 +1,80 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// LeaderLockName is the lease file in the data dir shared by every replica.
+const LeaderLockName = "leader.lock"
+
+// FileLease is a leader lease backed by a non-blocking flock. The kernel drops
+// the lock when the holder exits, so a crashed leader never wedges the lease.
+// It only elects correctly among processes sharing the same filesystem.
+type FileLease struct {
+	Path     string
+	Identity string
+
+	f *os.File
+}
+
+// NewFileLease returns a lease on path held under identity.
+func NewFileLease(path, identity string) *FileLease {
+	return &FileLease{Path: path, Identity: identity}
+}
+
+// TryAcquire takes the lease if it is free and reports whether this process
+// holds it. Holding it already is not an error.
+func (l *FileLease) TryAcquire() (bool, error) {
+	if l.f != nil {
+		return true, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o755); err != nil {
+		return false, fmt.Errorf("create lease dir: %w", err)
+	}
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("open lease: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("lock lease: %w", err)
+	}
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(l.Identity+"\n"), 0)
+	}
+	l.f = f
+	return true, nil
+}
+
+// Release gives up the lease if held.
+func (l *FileLease) Release() {
+	if l.f == nil {
+		return
+	}
+	_ = syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	_ = l.f.Close()
+	l.f = nil
+}
+
+// Holder returns the identity recorded by the current or last leader.
+func (l *FileLease) Holder() string {
+	content, err := os.ReadFile(l.Path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// defaultIdentity names this replica as host/pid.
+func defaultIdentity() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor systemd passes with socket
+// activation.
+const listenFDsStart = 3
+
+// APISocketName is the FileDescriptorName of an activated socket serving the
+// state API. A single unnamed activated socket is used for it too.
+const APISocketName = "api"
+
+// Notify sends state (e.g. "READY=1") to systemd's notification socket. It
+// reports false without error when not running under a notify unit.
+func Notify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	if addr[0] == '@' {
+		// Abstract namespace socket.
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("sd_notify: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often to send WATCHDOG=1: half the unit's
+// WatchdogSec, or 0 when the watchdog is off or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// ActivationListeners returns the sockets systemd passed to this process,
+// keyed by FileDescriptorName ("" when unnamed), and clears the LISTEN_*
+// variables so children do not inherit them.
+func ActivationListeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	listeners := map[string]net.Listener{}
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) && names[i] != "unknown" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("activated socket %d (%q): %w", i, name, err)
+		}
+		if _, dup := listeners[name]; dup {
+			ln.Close()
+			return nil, fmt.Errorf("activated sockets: duplicate name %q", name)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
@@ -0,0 +1,138 @@
+// Package events emits machine-readable lifecycle events (NDJSON) so external
+// inventory systems can track pod networking without scraping logs.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	AttachmentCreated = "attachment.created"
+	AttachmentDeleted = "attachment.deleted"
+	IPAllocated       = "ip.allocated"
+	IPReleased        = "ip.released"
+	IPRangeFallback   = "ip.range_fallback"
+	IPPoolNearlyFull  = "ip.pool_nearly_full"
+	GatewayFailover   = "gateway.failover"
+	GatewayFailback   = "gateway.failback"
+)
+
+const socketTimeout = time.Second
+
+// Event is one lifecycle event, serialized as a single JSON line.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Network     string    `json:"network"`
+	ContainerID string    `json:"containerID"`
+	IfName      string    `json:"ifName,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	Message     string    `json:"message,omitempty"`
+}
+
+// Sink receives lifecycle events.
+type Sink interface {
+	Emit(ev Event) error
+}
+
+// New returns a sink writing to the NDJSON file and/or unix socket that are
+// set, or nil when neither is configured.
+func New(file, socket string) Sink {
+	var sinks multiSink
+	if file != "" {
+		sinks = append(sinks, &FileSink{Path: file})
+	}
+	if socket != "" {
+		sinks = append(sinks, &SocketSink{Path: socket})
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	return sinks
+}
+
+// FileSink appends events to an NDJSON file.
+type FileSink struct {
+	Path string
+}
+
+// Emit appends one event line under an exclusive lock so concurrent plugin
+// invocations never interleave partial lines.
+func (s *FileSink) Emit(ev Event) error {
+	line, err := encode(ev)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return fmt.Errorf("create events dir: %w", err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open events file: %w", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock events file: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+	return nil
+}
+
+// SocketSink writes each event as one line to a listening unix stream socket.
+type SocketSink struct {
+	Path string
+}
+
+// Emit dials the socket, writes one event line, and disconnects.
+func (s *SocketSink) Emit(ev Event) error {
+	line, err := encode(ev)
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("unix", s.Path, socketTimeout)
+	if err != nil {
+		return fmt.Errorf("dial events socket: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(socketTimeout))
+	if _, err := conn.Write(line); err != nil {
+		return fmt.Errorf("write event: %w", err)
+	}
+	return nil
+}
+
+// multiSink fans one event out to several sinks.
+type multiSink []Sink
+
+func (m multiSink) Emit(ev Event) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Emit(ev); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// encode stamps the event time when unset and renders one NDJSON line.
+func encode(ev Event) ([]byte, error) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event: %w", err)
+	}
+	return append(line, '\n'), nil
+}
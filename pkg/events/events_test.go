@@ -0,0 +1,70 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSinkWritesNDJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "atomicni.ndjson")
+	sink := New(path, "")
+	for _, typ := range []string{IPAllocated, AttachmentCreated} {
+		if err := sink.Emit(Event{Type: typ, Network: "atomic-net", ContainerID: "c1"}); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(lines[1]), &ev); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if ev.Type != AttachmentCreated || ev.Time.IsZero() {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+}
+
+func TestSocketSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		got <- line
+	}()
+
+	if err := New("", path).Emit(Event{Type: IPReleased, Network: "atomic-net", ContainerID: "c1", IP: "10.22.0.10"}); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	if line := <-got; !strings.Contains(line, `"ip":"10.22.0.10"`) {
+		t.Fatalf("unexpected line: %q", line)
+	}
+}
+
+func TestNewWithoutTargets(t *testing.T) {
+	if New("", "") != nil {
+		t.Fatalf("expected nil sink without targets")
+	}
+}
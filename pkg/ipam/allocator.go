@@ -5,8 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/clock"
+	"github.com/annis-souames/atomicni/pkg/netaddr"
 )
 
+// ErrNoAvailableIP is returned when every candidate range is exhausted.
+var ErrNoAvailableIP = errors.New("no available IP addresses")
+
+// Range is one allocation range; ranges sharing a priority form a group and
+// lower priority values are filled first.
+type Range struct {
+	Start    net.IP
+	End      net.IP
+	Priority int
+}
+
 // AllocationRequest describes one IPv4 allocation request.
 type AllocationRequest struct {
 	DataDir     string
@@ -16,30 +32,142 @@ type AllocationRequest struct {
 	Gateway     net.IP
 	RangeStart  net.IP
 	RangeEnd    net.IP
+	// Ranges, when set, replaces RangeStart/RangeEnd with prioritized groups.
+	Ranges []Range
+	// OnFallback is called when the allocation spills out of the primary group.
+	OnFallback func(primary, used Range)
+	// PreferredIP is tried first; normal selection is used when it is taken
+	// or outside the allocatable ranges.
+	PreferredIP net.IP
+	// Pod optionally names the owning pod ("namespace/name") in the history
+	// and for reservations.
+	Pod string
+	// ReservationsFile optionally seeds identity -> IP reservations; see
+	// LoadReservations.
+	ReservationsFile string
+	// DHCPLeases optionally excludes addresses an external DHCP server has
+	// leased on the same L2; see ParseLeases.
+	DHCPLeases *LeaseSource
+}
+
+// ranges returns the candidate ranges in allocation order.
+func (r AllocationRequest) ranges() []Range {
+	if len(r.Ranges) == 0 {
+		return []Range{{Start: r.RangeStart, End: r.RangeEnd}}
+	}
+	return r.Ranges
+}
+
+// Capacity counts the addresses the request can allocate across its
+// ranges, leaving out the network, broadcast, and gateway addresses.
+func (r AllocationRequest) Capacity() int {
+	subnet, ok := netaddr.V4Prefix(r.Subnet)
+	if !ok {
+		return 0
+	}
+	gateway, _ := netaddr.V4(r.Gateway)
+	skip := []netip.Addr{subnet.Addr(), netaddr.Broadcast(subnet), gateway}
+	total := 0
+	for _, rg := range r.ranges() {
+		ar, ok := toAddrRange(rg)
+		if !ok {
+			continue
+		}
+		total += int(netaddr.Uint32(ar.end)-netaddr.Uint32(ar.start)) + 1
+		for _, addr := range skip {
+			if ar.contains(addr) {
+				total--
+			}
+		}
+	}
+	return total
+}
+
+// addrRange is a Range as netip values.
+type addrRange struct {
+	start, end netip.Addr
+	Range
+}
+
+// toAddrRange converts r, reporting false when a bound is not IPv4 or the
+// bounds are reversed.
+func toAddrRange(r Range) (addrRange, bool) {
+	start, ok1 := netaddr.V4(r.Start)
+	end, ok2 := netaddr.V4(r.End)
+	if !ok1 || !ok2 || end.Less(start) {
+		return addrRange{}, false
+	}
+	return addrRange{start: start, end: end, Range: r}, true
+}
+
+// contains reports whether addr lies within the range.
+func (r addrRange) contains(addr netip.Addr) bool {
+	return addr.IsValid() && r.start.Compare(addr) <= 0 && addr.Compare(r.end) <= 0
+}
+
+// bounds is a validated request's subnet, gateway, and ranges as netip
+// values; the allocator works on these and converts at the API edge.
+type bounds struct {
+	subnet    netip.Prefix
+	broadcast netip.Addr
+	gateway   netip.Addr
+	ranges    []addrRange
+}
+
+// allocatable reports whether addr is neither the network, the broadcast,
+// nor the gateway address.
+func (b bounds) allocatable(addr netip.Addr) bool {
+	return addr != b.subnet.Addr() && addr != b.broadcast && addr != b.gateway
+}
+
+// storedIP converts an address read from state to the net.IP the Allocator
+// API returns; it is nil when the stored value is not IPv4.
+func storedIP(s string) net.IP {
+	addr, ok := netaddr.ParseV4(s)
+	if !ok {
+		return nil
+	}
+	return netaddr.ToIP(addr)
 }
 
 // Allocator manages per-network IPv4 allocation.
 type Allocator interface {
 	Allocate(ctx context.Context, req AllocationRequest) (net.IP, error)
 	Release(ctx context.Context, dataDir, network, containerID string) error
+	ReleaseIP(ctx context.Context, dataDir, network string, ip net.IP) error
+	ForceRelease(ctx context.Context, dataDir, network, containerID string) error
 	GetByContainer(ctx context.Context, dataDir, network, containerID string) (net.IP, bool, error)
+	List(ctx context.Context, dataDir, network string) (map[string]net.IP, error)
+	Networks(ctx context.Context, dataDir string) ([]string, error)
 }
 
 // FileAllocator keeps allocation state on local disk.
-type FileAllocator struct{}
+type FileAllocator struct {
+	// Metrics receives allocator observations; nil means no-op.
+	Metrics MetricsSink
+	// Clock stamps allocations, releases, and history; nil means the system
+	// clock.
+	Clock clock.Clock
+}
 
 // NewFileAllocator returns an allocator that persists state in JSON files.
 func NewFileAllocator() *FileAllocator {
-	return &FileAllocator{}
+	return &FileAllocator{Metrics: NopMetricsSink{}}
+}
+
+// now is the allocator's clock reading in UTC.
+func (a *FileAllocator) now() time.Time {
+	return clock.Or(a.Clock).Now().UTC()
 }
 
 // Allocate returns a stable IPv4 for the container, creating one when needed.
-func (a *FileAllocator) Allocate(_ context.Context, req AllocationRequest) (net.IP, error) {
-	if err := validateRequest(req); err != nil {
+func (a *FileAllocator) Allocate(ctx context.Context, req AllocationRequest) (net.IP, error) {
+	b, err := validateRequest(req)
+	if err != nil {
 		return nil, err
 	}
 
-	lockFile, statePath, err := lockNetwork(req.DataDir, req.Network)
+	lockFile, statePath, err := a.lock(ctx, req.DataDir, req.Network)
 	if err != nil {
 		return nil, err
 	}
@@ -49,42 +177,67 @@ func (a *FileAllocator) Allocate(_ context.Context, req AllocationRequest) (net.
 	if err != nil {
 		return nil, err
 	}
+	if err := syncReservations(st, req, b); err != nil {
+		return nil, err
+	}
+	if err := syncLeases(st, req, a.now()); err != nil {
+		return nil, err
+	}
 
 	if existing, ok := st.ContainerToIP[req.ContainerID]; ok {
-		ip := net.ParseIP(existing).To4()
-		if ip == nil {
+		addr, ok := netaddr.ParseV4(existing)
+		if !ok {
 			return nil, fmt.Errorf("stored IP for container %q is invalid: %q", req.ContainerID, existing)
 		}
-		st.IPToContainer[ip.String()] = req.ContainerID
+		st.IPToContainer[addr.String()] = req.ContainerID
 		if err := saveState(statePath, st); err != nil {
 			return nil, err
 		}
-		return ip, nil
+		return netaddr.ToIP(addr), nil
 	}
 
-	selected, err := a.findNextIP(st, req)
-	if err != nil {
-		return nil, err
+	selected, ok := tryReserved(st, req)
+	if !ok {
+		selected, ok = a.tryPreferred(st, req, b)
+	}
+	if !ok {
+		selected, err = a.findInRanges(st, req, b)
+		if errors.Is(err, ErrNoAvailableIP) {
+			a.sink(req.DataDir).OnExhausted(req.Network)
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	selectedStr := selected.String()
 	st.ContainerToIP[req.ContainerID] = selectedStr
 	st.IPToContainer[selectedStr] = req.ContainerID
-	st.LastReserved = selectedStr
+	if st.AllocatedAt == nil {
+		st.AllocatedAt = map[string]time.Time{}
+	}
+	now := a.now()
+	st.AllocatedAt[req.ContainerID] = now
+	if reserved, _ := reservationOf(st, req); !netaddr.ToIP(selected).Equal(req.PreferredIP) && reserved != selectedStr {
+		st.LastReserved = selectedStr
+	}
 	if err := saveState(statePath, st); err != nil {
 		return nil, err
 	}
+	_ = recordHistory(req.DataDir, req.Network, historyEvent{IP: selectedStr, Owner: req.ContainerID, Pod: req.Pod, At: now})
 
-	return selected, nil
+	ip := netaddr.ToIP(selected)
+	a.sink(req.DataDir).OnAllocate(req.Network, ip)
+	return ip, nil
 }
 
 // Release removes a container allocation if it exists.
-func (a *FileAllocator) Release(_ context.Context, dataDir, network, containerID string) error {
+func (a *FileAllocator) Release(ctx context.Context, dataDir, network, containerID string) error {
 	if network == "" || containerID == "" {
 		return errors.New("network and containerID are required")
 	}
 
-	lockFile, statePath, err := lockNetwork(dataDir, network)
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
 	if err != nil {
 		return err
 	}
@@ -99,19 +252,120 @@ func (a *FileAllocator) Release(_ context.Context, dataDir, network, containerID
 	if !ok {
 		return nil
 	}
+	allocatedAt := st.AllocatedAt[containerID]
 	delete(st.ContainerToIP, containerID)
+	delete(st.AllocatedAt, containerID)
 	delete(st.IPToContainer, ip)
 
-	return saveState(statePath, st)
+	if err := saveState(statePath, st); err != nil {
+		return err
+	}
+	_ = recordHistory(dataDir, network, historyEvent{IP: ip, Owner: containerID, At: a.now(), Released: true, AllocatedAt: allocatedAt})
+	a.sink(dataDir).OnRelease(network, storedIP(ip))
+	return nil
+}
+
+// ReleaseIP removes whatever allocation holds ip, clearing both indexes even
+// when they disagree. Releasing a free IP is a no-op.
+func (a *FileAllocator) ReleaseIP(ctx context.Context, dataDir, network string, ip net.IP) error {
+	if network == "" {
+		return errors.New("network is required")
+	}
+	addr, ok := netaddr.V4(ip)
+	if !ok {
+		return errors.New("ip must be IPv4")
+	}
+
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return err
+	}
+	defer unlockNetwork(lockFile)
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return err
+	}
+
+	ipStr := addr.String()
+	now := a.now()
+	var released []historyEvent
+	owner, held := st.IPToContainer[ipStr]
+	delete(st.IPToContainer, ipStr)
+	for containerID, owned := range st.ContainerToIP {
+		if owned == ipStr {
+			held = true
+			released = append(released, historyEvent{IP: ipStr, Owner: containerID, At: now, Released: true, AllocatedAt: st.AllocatedAt[containerID]})
+			delete(st.ContainerToIP, containerID)
+			delete(st.AllocatedAt, containerID)
+		}
+	}
+	if held && len(released) == 0 {
+		released = append(released, historyEvent{IP: ipStr, Owner: owner, At: now, Released: true})
+	}
+	if err := saveState(statePath, st); err != nil {
+		return err
+	}
+	_ = recordHistory(dataDir, network, released...)
+	if held {
+		a.sink(dataDir).OnRelease(network, netaddr.ToIP(addr))
+	}
+	return nil
+}
+
+// ForceRelease removes every trace of containerID from both indexes, fixing
+// entries wedged by inconsistent state. Missing entries are ignored.
+func (a *FileAllocator) ForceRelease(ctx context.Context, dataDir, network, containerID string) error {
+	if network == "" || containerID == "" {
+		return errors.New("network and containerID are required")
+	}
+
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return err
+	}
+	defer unlockNetwork(lockFile)
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return err
+	}
+
+	released := map[string]bool{}
+	if ip, ok := st.ContainerToIP[containerID]; ok {
+		released[ip] = true
+	}
+	allocatedAt := st.AllocatedAt[containerID]
+	delete(st.ContainerToIP, containerID)
+	delete(st.AllocatedAt, containerID)
+	for ip, owner := range st.IPToContainer {
+		if owner == containerID {
+			released[ip] = true
+			delete(st.IPToContainer, ip)
+		}
+	}
+	if err := saveState(statePath, st); err != nil {
+		return err
+	}
+	now := a.now()
+	var events []historyEvent
+	for ip := range released {
+		events = append(events, historyEvent{IP: ip, Owner: containerID, At: now, Released: true, AllocatedAt: allocatedAt})
+	}
+	_ = recordHistory(dataDir, network, events...)
+	for ip := range released {
+		a.sink(dataDir).OnRelease(network, storedIP(ip))
+	}
+	return nil
 }
 
 // GetByContainer reads a container allocation without creating one.
-func (a *FileAllocator) GetByContainer(_ context.Context, dataDir, network, containerID string) (net.IP, bool, error) {
+func (a *FileAllocator) GetByContainer(ctx context.Context, dataDir, network, containerID string) (net.IP, bool, error) {
 	if network == "" || containerID == "" {
 		return nil, false, errors.New("network and containerID are required")
 	}
 
-	lockFile, statePath, err := lockNetwork(dataDir, network)
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
 	if err != nil {
 		return nil, false, err
 	}
@@ -126,105 +380,143 @@ func (a *FileAllocator) GetByContainer(_ context.Context, dataDir, network, cont
 	if !ok {
 		return nil, false, nil
 	}
-	ip := net.ParseIP(ipStr).To4()
+	ip := storedIP(ipStr)
 	if ip == nil {
 		return nil, false, fmt.Errorf("stored IP for container %q is invalid: %q", containerID, ipStr)
 	}
 	return ip, true, nil
 }
 
-// findNextIP performs next-fit allocation while skipping reserved addresses.
-func (a *FileAllocator) findNextIP(st *state, req AllocationRequest) (net.IP, error) {
-	start := ipv4ToUint(req.RangeStart)
-	end := ipv4ToUint(req.RangeEnd)
-	count := end - start + 1
+// List returns every container allocation of a network keyed by container ID.
+func (a *FileAllocator) List(ctx context.Context, dataDir, network string) (map[string]net.IP, error) {
+	if network == "" {
+		return nil, errors.New("network is required")
+	}
 
-	cursor := start
-	if st.LastReserved != "" {
-		last := net.ParseIP(st.LastReserved).To4()
-		if last != nil {
-			lastUint := ipv4ToUint(last)
-			if lastUint >= start && lastUint <= end {
-				cursor = lastUint + 1
-			}
-		}
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return nil, err
 	}
-	if cursor > end {
-		cursor = start
+	defer unlockNetwork(lockFile)
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]net.IP, len(st.ContainerToIP))
+	for containerID, ipStr := range st.ContainerToIP {
+		ip := storedIP(ipStr)
+		if ip == nil {
+			return nil, fmt.Errorf("stored IP for container %q is invalid: %q", containerID, ipStr)
+		}
+		out[containerID] = ip
 	}
+	return out, nil
+}
 
-	networkIP, broadcastIP := networkAndBroadcast(req.Subnet)
-	gateway := req.Gateway.To4()
+// Networks returns the names of networks that have state under dataDir.
+func (a *FileAllocator) Networks(_ context.Context, dataDir string) ([]string, error) {
+	return listNetworks(dataDir)
+}
 
-	for i := uint32(0); i < count; i++ {
-		candidate := cursor + i
-		if candidate > end {
-			candidate = start + (candidate - end - 1)
+// tryPreferred returns the hinted IP when it is allocatable and free.
+// A hint does not move the next-fit cursor.
+func (a *FileAllocator) tryPreferred(st *state, req AllocationRequest, b bounds) (netip.Addr, bool) {
+	addr, ok := netaddr.V4(req.PreferredIP)
+	if !ok || !b.allocatable(addr) || unavailable(st, req, addr.String()) {
+		return netip.Addr{}, false
+	}
+	for _, r := range b.ranges {
+		if r.contains(addr) {
+			return addr, true
 		}
+	}
+	return netip.Addr{}, false
+}
 
-		ip := uintToIPv4(candidate)
-		if ip.Equal(networkIP) || ip.Equal(broadcastIP) || ip.Equal(gateway) {
+// unavailable reports whether ip is held, reserved for another identity, or
+// leased by the site's DHCP server.
+func unavailable(st *state, req AllocationRequest, ip string) bool {
+	_, inUse := st.IPToContainer[ip]
+	return inUse || reservedForOther(st, req, ip) || st.leased[ip]
+}
+
+// findInRanges tries each range in priority order and reports group fallback.
+func (a *FileAllocator) findInRanges(st *state, req AllocationRequest, b bounds) (netip.Addr, error) {
+	for _, r := range b.ranges {
+		addr, ok := a.findNextIP(st, req, b, r)
+		if !ok {
 			continue
 		}
-		if _, inUse := st.IPToContainer[ip.String()]; inUse {
-			continue
+		if r.Priority != b.ranges[0].Priority && req.OnFallback != nil {
+			req.OnFallback(b.ranges[0].Range, r.Range)
 		}
-		return ip, nil
+		return addr, nil
 	}
+	return netip.Addr{}, ErrNoAvailableIP
+}
 
-	return nil, errors.New("no available IP addresses")
+// findNextIP performs next-fit allocation in r while skipping reserved
+// addresses, starting after the last address handed out.
+func (a *FileAllocator) findNextIP(st *state, req AllocationRequest, b bounds, r addrRange) (netip.Addr, bool) {
+	cursor := r.start
+	if last, ok := netaddr.ParseV4(st.LastReserved); ok && r.contains(last) && last != r.end {
+		cursor = last.Next()
+	}
+	addr := cursor
+	for {
+		if b.allocatable(addr) && !unavailable(st, req, addr.String()) {
+			return addr, true
+		}
+		if addr == r.end {
+			addr = r.start
+		} else {
+			addr = addr.Next()
+		}
+		if addr == cursor {
+			return netip.Addr{}, false
+		}
+	}
 }
 
-// validateRequest checks required fields and range constraints for allocation.
-func validateRequest(req AllocationRequest) error {
+// validateRequest checks required fields and range constraints for
+// allocation, returning the request's bounds.
+func validateRequest(req AllocationRequest) (bounds, error) {
 	if req.DataDir == "" {
-		return errors.New("dataDir is required")
+		return bounds{}, errors.New("dataDir is required")
 	}
 	if req.Network == "" {
-		return errors.New("network is required")
+		return bounds{}, errors.New("network is required")
 	}
 	if req.ContainerID == "" {
-		return errors.New("containerID is required")
+		return bounds{}, errors.New("containerID is required")
 	}
 	if req.Subnet == nil {
-		return errors.New("subnet is required")
-	}
-	if req.Subnet.IP.To4() == nil {
-		return errors.New("only IPv4 subnets are supported")
-	}
-	if req.Gateway.To4() == nil {
-		return errors.New("gateway must be IPv4")
+		return bounds{}, errors.New("subnet is required")
 	}
-	if req.RangeStart.To4() == nil || req.RangeEnd.To4() == nil {
-		return errors.New("range bounds must be IPv4")
-	}
-	if !req.Subnet.Contains(req.RangeStart) || !req.Subnet.Contains(req.RangeEnd) {
-		return errors.New("allocation range must be inside subnet")
+	subnet, ok := netaddr.V4Prefix(req.Subnet)
+	if !ok {
+		return bounds{}, errors.New("only IPv4 subnets are supported")
 	}
-	if ipv4ToUint(req.RangeStart) > ipv4ToUint(req.RangeEnd) {
-		return errors.New("rangeStart must be <= rangeEnd")
+	gateway, ok := netaddr.V4(req.Gateway)
+	if !ok {
+		return bounds{}, errors.New("gateway must be IPv4")
 	}
-	return nil
-}
-
-// networkAndBroadcast derives network and broadcast IPv4 addresses from a CIDR.
-func networkAndBroadcast(subnet *net.IPNet) (net.IP, net.IP) {
-	network := subnet.IP.Mask(subnet.Mask).To4()
-	mask := net.IP(subnet.Mask).To4()
-	broadcast := make(net.IP, len(network))
-	for i := range network {
-		broadcast[i] = network[i] | ^mask[i]
+	b := bounds{subnet: subnet, broadcast: netaddr.Broadcast(subnet), gateway: gateway}
+	for _, r := range req.ranges() {
+		start, ok1 := netaddr.V4(r.Start)
+		end, ok2 := netaddr.V4(r.End)
+		if !ok1 || !ok2 {
+			return bounds{}, errors.New("range bounds must be IPv4")
+		}
+		if !subnet.Contains(start) || !subnet.Contains(end) {
+			return bounds{}, errors.New("allocation range must be inside subnet")
+		}
+		if end.Less(start) {
+			return bounds{}, errors.New("rangeStart must be <= rangeEnd")
+		}
+		b.ranges = append(b.ranges, addrRange{start: start, end: end, Range: r})
 	}
-	return network, broadcast
-}
-
-// ipv4ToUint converts IPv4 to big-endian uint32 for range math.
-func ipv4ToUint(ip net.IP) uint32 {
-	ip = ip.To4()
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
-}
-
-// uintToIPv4 converts big-endian uint32 back to IPv4.
-func uintToIPv4(v uint32) net.IP {
-	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4()
+	return b, nil
 }
@@ -2,11 +2,17 @@ package ipam
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"net/netip"
+	"os"
 	"path/filepath"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/schema"
 )
 
 func mustCIDR(t *testing.T, cidr string) *net.IPNet {
@@ -187,3 +193,542 @@ func TestAllocateConcurrentUnique(t *testing.T) {
 		t.Fatalf("expected %d allocated IPs, got %d", n, len(seen))
 	}
 }
+
+func TestListAndNetworks(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	req := AllocationRequest{
+		DataDir:    dir,
+		Network:    "atomic-net",
+		Subnet:     mustCIDR(t, "10.22.0.0/24"),
+		Gateway:    mustIP(t, "10.22.0.1"),
+		RangeStart: mustIP(t, "10.22.0.10"),
+		RangeEnd:   mustIP(t, "10.22.0.20"),
+	}
+	for _, id := range []string{"c1", "c2"} {
+		req.ContainerID = id
+		if _, err := alloc.Allocate(context.Background(), req); err != nil {
+			t.Fatalf("Allocate(%s): %v", id, err)
+		}
+	}
+
+	allocs, err := alloc.List(context.Background(), dir, "atomic-net")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(allocs) != 2 || allocs["c1"].String() != "10.22.0.10" {
+		t.Fatalf("unexpected allocations: %v", allocs)
+	}
+
+	networks, err := alloc.Networks(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("Networks: %v", err)
+	}
+	if len(networks) != 1 || networks[0] != "atomic-net" {
+		t.Fatalf("unexpected networks: %v", networks)
+	}
+}
+
+func TestAllocateRangeGroupFallback(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+
+	var fallbacks []Range
+	req := AllocationRequest{
+		DataDir: dir,
+		Network: "atomic-net",
+		Subnet:  mustCIDR(t, "10.22.0.0/24"),
+		Gateway: mustIP(t, "10.22.0.1"),
+		Ranges: []Range{
+			{Start: mustIP(t, "10.22.0.10"), End: mustIP(t, "10.22.0.10"), Priority: 0},
+			{Start: mustIP(t, "10.22.0.11"), End: mustIP(t, "10.22.0.11"), Priority: 0},
+			{Start: mustIP(t, "10.22.0.100"), End: mustIP(t, "10.22.0.110"), Priority: 1},
+		},
+		OnFallback: func(primary, used Range) {
+			fallbacks = append(fallbacks, used)
+		},
+	}
+
+	var got []string
+	for _, id := range []string{"c1", "c2", "c3"} {
+		req.ContainerID = id
+		ip, err := alloc.Allocate(context.Background(), req)
+		if err != nil {
+			t.Fatalf("Allocate(%s): %v", id, err)
+		}
+		got = append(got, ip.String())
+	}
+
+	want := []string{"10.22.0.10", "10.22.0.11", "10.22.0.100"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, got)
+		}
+	}
+	if len(fallbacks) != 1 || fallbacks[0].Priority != 1 {
+		t.Fatalf("expected one fallback into priority 1, got %+v", fallbacks)
+	}
+}
+
+func TestAllocatePreferredIP(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	req := AllocationRequest{
+		DataDir:     dir,
+		Network:     "atomic-net",
+		Subnet:      mustCIDR(t, "10.22.0.0/24"),
+		Gateway:     mustIP(t, "10.22.0.1"),
+		RangeStart:  mustIP(t, "10.22.0.10"),
+		RangeEnd:    mustIP(t, "10.22.0.20"),
+		PreferredIP: mustIP(t, "10.22.0.15"),
+	}
+
+	req.ContainerID = "c1"
+	ip1, err := alloc.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Allocate(c1): %v", err)
+	}
+	if ip1.String() != "10.22.0.15" {
+		t.Fatalf("expected hinted 10.22.0.15, got %s", ip1)
+	}
+
+	req.ContainerID = "c2"
+	ip2, err := alloc.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Allocate(c2): %v", err)
+	}
+	if ip2.String() != "10.22.0.10" {
+		t.Fatalf("expected fallback to normal selection 10.22.0.10, got %s", ip2)
+	}
+
+	req.ContainerID = "c3"
+	req.PreferredIP = mustIP(t, "10.22.0.200")
+	ip3, err := alloc.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Allocate(c3): %v", err)
+	}
+	if ip3.String() != "10.22.0.11" {
+		t.Fatalf("expected out-of-range hint to be ignored, got %s", ip3)
+	}
+}
+
+func TestReleaseIPAndForceReleaseClearWedgedEntries(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "atomic-net.json")
+
+	st := newState()
+	st.ContainerToIP["c1"] = "10.22.0.10"
+	st.IPToContainer["10.22.0.10"] = "c1"
+	// Wedged: reverse entry without a forward entry, and vice versa.
+	st.IPToContainer["10.22.0.11"] = "ghost"
+	st.ContainerToIP["orphan"] = "10.22.0.12"
+	if err := saveState(statePath, st); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	if err := alloc.ForceRelease(context.Background(), dir, "atomic-net", "ghost"); err != nil {
+		t.Fatalf("ForceRelease(ghost): %v", err)
+	}
+	if err := alloc.ReleaseIP(context.Background(), dir, "atomic-net", mustIP(t, "10.22.0.12")); err != nil {
+		t.Fatalf("ReleaseIP(.12): %v", err)
+	}
+	if err := alloc.ReleaseIP(context.Background(), dir, "atomic-net", mustIP(t, "10.22.0.99")); err != nil {
+		t.Fatalf("ReleaseIP(free): %v", err)
+	}
+
+	got, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if len(got.ContainerToIP) != 1 || len(got.IPToContainer) != 1 || got.ContainerToIP["c1"] != "10.22.0.10" {
+		t.Fatalf("unexpected state after cleanup: %+v", got)
+	}
+}
+
+func TestAllocateAddr(t *testing.T) {
+	dataDir := t.TempDir()
+	alloc := NewFileAllocator()
+	start, end := netip.MustParseAddr("10.22.0.10"), netip.MustParseAddr("10.22.0.20")
+	req := AllocationRequest{
+		DataDir:     dataDir,
+		Network:     "atomic-net",
+		ContainerID: "c1",
+		Subnet:      mustCIDR(t, "10.22.0.0/24"),
+		Gateway:     mustIP(t, "10.22.0.1"),
+		Ranges:      []Range{RangeFromAddrs(start, end, 0)},
+	}
+
+	addr, err := AllocateAddr(context.Background(), alloc, req)
+	if err != nil {
+		t.Fatalf("AllocateAddr() error = %v", err)
+	}
+	if addr != start {
+		t.Fatalf("AllocateAddr() = %s, want %s", addr, start)
+	}
+	got, ok, err := GetAddrByContainer(context.Background(), alloc, dataDir, "atomic-net", "c1")
+	if err != nil || !ok || got != addr {
+		t.Fatalf("GetAddrByContainer() = %s, %v, %v", got, ok, err)
+	}
+	if err := ReleaseAddr(context.Background(), alloc, dataDir, "atomic-net", addr); err != nil {
+		t.Fatalf("ReleaseAddr() error = %v", err)
+	}
+	if _, ok, _ := GetAddrByContainer(context.Background(), alloc, dataDir, "atomic-net", "c1"); ok {
+		t.Fatalf("address still allocated after ReleaseAddr")
+	}
+}
+
+func TestAllocateGivesUpOnLockAtDeadline(t *testing.T) {
+	dir := t.TempDir()
+	lockFile, _, err := lockNetwork(context.Background(), dir, "atomic-net")
+	if err != nil {
+		t.Fatalf("lockNetwork: %v", err)
+	}
+	defer unlockNetwork(lockFile)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = NewFileAllocator().Allocate(ctx, AllocationRequest{
+		DataDir:     dir,
+		Network:     "atomic-net",
+		ContainerID: "c1",
+		Subnet:      mustCIDR(t, "10.22.0.0/29"),
+		Gateway:     mustIP(t, "10.22.0.1"),
+		RangeStart:  mustIP(t, "10.22.0.2"),
+		RangeEnd:    mustIP(t, "10.22.0.6"),
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestLockNetworkFollowsReplacedDataDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	held, _, err := lockNetwork(context.Background(), dir, "atomic-net")
+	if err != nil {
+		t.Fatalf("lockNetwork: %v", err)
+	}
+	got := make(chan *os.File, 1)
+	go func() {
+		f, _, err := lockNetwork(context.Background(), dir, "atomic-net")
+		if err != nil {
+			t.Errorf("lockNetwork(waiter): %v", err)
+		}
+		got <- f
+	}()
+	// Let the waiter block on the old lock file, then swap the data dir the
+	// way a restore does.
+	time.Sleep(50 * time.Millisecond)
+	if err := os.Rename(dir, dir+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	unlockNetwork(held)
+
+	f := <-got
+	if f == nil {
+		return
+	}
+	defer unlockNetwork(f)
+	locked, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := os.Stat(filepath.Join(dir, "atomic-net.lock"))
+	if err != nil || !os.SameFile(locked, current) {
+		t.Fatalf("waiter holds a lock file outside the data dir (%v)", err)
+	}
+}
+
+func TestAllocationRequestCapacity(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.22.0.0/24")
+	req := AllocationRequest{Subnet: subnet, Gateway: net.ParseIP("10.22.0.1"), RangeStart: net.ParseIP("10.22.0.0"), RangeEnd: net.ParseIP("10.22.0.255")}
+	if got := req.Capacity(); got != 253 {
+		t.Fatalf("Capacity() = %d, want 253", got)
+	}
+	req.Ranges = []Range{
+		{Start: net.ParseIP("10.22.0.10"), End: net.ParseIP("10.22.0.19")},
+		{Start: net.ParseIP("10.22.0.100"), End: net.ParseIP("10.22.0.104"), Priority: 1},
+	}
+	if got := req.Capacity(); got != 15 {
+		t.Fatalf("Capacity() with ranges = %d, want 15", got)
+	}
+}
+
+func TestHistoryAnswersWhoHad(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	req := AllocationRequest{
+		DataDir:    dir,
+		Network:    "atomic-net",
+		Subnet:     mustCIDR(t, "10.22.0.0/29"),
+		Gateway:    mustIP(t, "10.22.0.1"),
+		RangeStart: mustIP(t, "10.22.0.2"),
+		RangeEnd:   mustIP(t, "10.22.0.2"),
+	}
+	ctx := context.Background()
+
+	req.ContainerID, req.Pod = "c1", "default/web"
+	if _, err := alloc.Allocate(ctx, req); err != nil {
+		t.Fatalf("Allocate c1: %v", err)
+	}
+	during1 := time.Now()
+	if err := alloc.Release(ctx, dir, req.Network, "c1"); err != nil {
+		t.Fatalf("Release c1: %v", err)
+	}
+	req.ContainerID, req.Pod = "c2", ""
+	if _, err := alloc.Allocate(ctx, req); err != nil {
+		t.Fatalf("Allocate c2: %v", err)
+	}
+
+	records, err := alloc.History(ctx, dir, req.Network)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(records) != 2 || records[0].ReleasedAt.IsZero() || !records[1].ReleasedAt.IsZero() {
+		t.Fatalf("unexpected history %+v", records)
+	}
+	ip := mustIP(t, "10.22.0.2")
+	if got := WhoHad(records, ip, during1); len(got) != 1 || got[0].Owner != "c1" || got[0].Pod != "default/web" {
+		t.Fatalf("WhoHad(during c1) = %+v", got)
+	}
+	if got := WhoHad(records, ip, time.Now()); len(got) != 1 || got[0].Owner != "c2" {
+		t.Fatalf("WhoHad(now) = %+v", got)
+	}
+	if got := WhoHad(records, ip, records[0].AllocatedAt.Add(-time.Second)); len(got) != 0 {
+		t.Fatalf("WhoHad(before) = %+v", got)
+	}
+
+	// A release of an allocation that predates the history is still recorded.
+	if err := alloc.Import(ctx, dir, "other", map[string]net.IP{"old": mustIP(t, "10.22.0.3")}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if err := saveHistory(dir, "other", nil); err != nil {
+		t.Fatalf("saveHistory: %v", err)
+	}
+	if err := alloc.ReleaseIP(ctx, dir, "other", mustIP(t, "10.22.0.3")); err != nil {
+		t.Fatalf("ReleaseIP: %v", err)
+	}
+	records, err = alloc.History(ctx, dir, "other")
+	if err != nil || len(records) != 1 || records[0].Owner != "old" || records[0].ReleasedAt.IsZero() {
+		t.Fatalf("History(other) = %+v, %v", records, err)
+	}
+}
+
+func TestHistoryIsBounded(t *testing.T) {
+	dir := t.TempDir()
+	var events []historyEvent
+	for i := 0; i < HistoryLimit+10; i++ {
+		events = append(events, historyEvent{IP: "10.22.0.2", Owner: fmt.Sprintf("c%d", i), At: time.Now()})
+	}
+	if err := recordHistory(dir, "atomic-net", events...); err != nil {
+		t.Fatalf("recordHistory: %v", err)
+	}
+	records, err := loadHistory(dir, "atomic-net")
+	if err != nil || len(records) != HistoryLimit || records[0].Owner != "c10" {
+		t.Fatalf("history has %d records starting at %+v, err %v", len(records), records[0], err)
+	}
+}
+
+func TestAllocateHonoursReservationsFile(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	seed := filepath.Join(dir, "reservations.csv")
+	if err := os.WriteFile(seed, []byte("identity,ip\n# infrastructure\nkube-system/coredns,10.22.0.2\nlab/db, 10.22.0.200\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req := AllocationRequest{
+		DataDir:          dir,
+		Network:          "atomic-net",
+		Subnet:           mustCIDR(t, "10.22.0.0/24"),
+		Gateway:          mustIP(t, "10.22.0.1"),
+		RangeStart:       mustIP(t, "10.22.0.2"),
+		RangeEnd:         mustIP(t, "10.22.0.100"),
+		ReservationsFile: seed,
+	}
+	ctx := context.Background()
+	allocate := func(id, pod string) string {
+		t.Helper()
+		r := req
+		r.ContainerID, r.Pod = id, pod
+		ip, err := alloc.Allocate(ctx, r)
+		if err != nil {
+			t.Fatalf("Allocate(%s): %v", id, err)
+		}
+		return ip.String()
+	}
+
+	if got := allocate("c1", "default/web"); got != "10.22.0.3" {
+		t.Fatalf("unreserved pod got %s, want 10.22.0.3 (10.22.0.2 is reserved)", got)
+	}
+	if got := allocate("c2", "kube-system/coredns"); got != "10.22.0.2" {
+		t.Fatalf("coredns got %s, want its reservation 10.22.0.2", got)
+	}
+	// Reservations may sit outside the dynamic range and survive rebuilds:
+	// a new container of the same pod gets the same address.
+	if got := allocate("c3", "lab/db"); got != "10.22.0.200" {
+		t.Fatalf("db got %s, want 10.22.0.200", got)
+	}
+	if err := alloc.Release(ctx, dir, req.Network, "c3"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if got := allocate("c4", "lab/db"); got != "10.22.0.200" {
+		t.Fatalf("rebuilt db got %s, want 10.22.0.200", got)
+	}
+
+	// Editing the file takes effect on the next allocation.
+	yaml := filepath.Join(dir, "reservations.yaml")
+	if err := os.WriteFile(yaml, []byte("# seeds\n\"default/cache\": 10.22.0.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req.ReservationsFile = yaml
+	if got := allocate("c5", "default/other"); got != "10.22.0.5" {
+		t.Fatalf("unreserved pod got %s, want 10.22.0.5", got)
+	}
+	if got := allocate("c6", "default/cache"); got != "10.22.0.4" {
+		t.Fatalf("cache got %s, want 10.22.0.4", got)
+	}
+}
+
+func TestLoadReservationsRejectsBadFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"dup.csv":    "a,10.22.0.2\nb,10.22.0.2\n",
+		"twice.csv":  "a,10.22.0.2\na,10.22.0.3\n",
+		"badip.csv":  "a,10.22.0.300\n",
+		"nested.yml": "reservations:\n  a: 10.22.0.2\n",
+		"list.yaml":  "- a\n",
+		"seed.txt":   "a,10.22.0.2\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := LoadReservations(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestAllocateSkipsDHCPLeases(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	leases := filepath.Join(dir, "dnsmasq.leases")
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+	content := fmt.Sprintf("%d 52:54:00:00:00:01 10.22.0.2 printer *\n0 52:54:00:00:00:02 10.22.0.3 nas *\n%d 52:54:00:00:00:03 10.22.0.4 gone *\n", future, past)
+	if err := os.WriteFile(leases, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req := AllocationRequest{
+		DataDir:     dir,
+		Network:     "atomic-net",
+		ContainerID: "c1",
+		Subnet:      mustCIDR(t, "10.22.0.0/24"),
+		Gateway:     mustIP(t, "10.22.0.1"),
+		RangeStart:  mustIP(t, "10.22.0.2"),
+		RangeEnd:    mustIP(t, "10.22.0.100"),
+		PreferredIP: mustIP(t, "10.22.0.3"),
+		DHCPLeases:  &LeaseSource{File: leases, Format: LeasesDnsmasq, Refresh: time.Hour},
+	}
+	ip, err := alloc.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if ip.String() != "10.22.0.4" {
+		t.Fatalf("got %s, want 10.22.0.4 (.2 and .3 are leased, .4 expired)", ip)
+	}
+
+	// A changed file is re-imported before the refresh interval passes.
+	kea := "address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state\n" +
+		fmt.Sprintf("10.22.0.5,52:54:00:00:00:05,,3600,%d,1,0,0,cam,0\n", future) +
+		fmt.Sprintf("10.22.0.2,52:54:00:00:00:01,,3600,%d,1,0,0,printer,0\n", future) +
+		fmt.Sprintf("10.22.0.2,52:54:00:00:00:01,,3600,%d,1,0,0,printer,2\n", future)
+	if err := os.WriteFile(leases, []byte(kea), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(leases, later, later); err != nil {
+		t.Fatal(err)
+	}
+	req.ContainerID, req.PreferredIP = "c2", nil
+	req.DHCPLeases.Format = LeasesKea
+	ip, err = alloc.Allocate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if ip.String() != "10.22.0.6" {
+		t.Fatalf("got %s, want 10.22.0.6 (.5 is leased)", ip)
+	}
+	req.ContainerID, req.PreferredIP = "c3", mustIP(t, "10.22.0.2")
+	if ip, err = alloc.Allocate(context.Background(), req); err != nil || ip.String() != "10.22.0.2" {
+		t.Fatalf("got %v, %v; want the reclaimed 10.22.0.2", ip, err)
+	}
+
+	// An unreadable lease file fails the allocation.
+	if err := os.Remove(leases); err != nil {
+		t.Fatal(err)
+	}
+	req.ContainerID = "c4"
+	if _, err := alloc.Allocate(context.Background(), req); err == nil || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing lease file error, got %v", err)
+	}
+}
+
+func TestParseLeasesRejectsBadFiles(t *testing.T) {
+	for _, tc := range []struct{ format, content string }{
+		{LeasesDnsmasq, "soon 52:54:00:00:00:01 10.22.0.2 x *\n"},
+		{LeasesDnsmasq, "0 52:54:00:00:00:01\n"},
+		{LeasesKea, "address,hwaddr\n10.22.0.2,x\n"},
+		{LeasesKea, "address,expire,state\n10.22.0.2,never,0\n"},
+		{"isc", ""},
+	} {
+		if _, err := ParseLeases([]byte(tc.content), tc.format, time.Now()); err == nil {
+			t.Fatalf("%s %q: expected error", tc.format, tc.content)
+		}
+	}
+}
+
+func TestStateSchemaUpgradesOldFilesAndRefusesNewer(t *testing.T) {
+	alloc := NewFileAllocator()
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "atomic-net.json")
+	// A state file from before schemaVersion existed.
+	old := `{"containerToIP":{"c1":"10.22.0.2"},"ipToContainer":{"10.22.0.2":"c1"},"lastReserved":"10.22.0.2"}`
+	if err := os.WriteFile(statePath, []byte(old), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	req := AllocationRequest{
+		DataDir:     dir,
+		Network:     "atomic-net",
+		ContainerID: "c2",
+		Subnet:      mustCIDR(t, "10.22.0.0/29"),
+		Gateway:     mustIP(t, "10.22.0.1"),
+		RangeStart:  mustIP(t, "10.22.0.2"),
+		RangeEnd:    mustIP(t, "10.22.0.6"),
+	}
+	ip, err := alloc.Allocate(context.Background(), req)
+	if err != nil || ip.String() != "10.22.0.3" {
+		t.Fatalf("Allocate() over a version 0 file = %v, %v", ip, err)
+	}
+	st, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if st.SchemaVersion != StateSchema || st.ContainerToIP["c1"] != "10.22.0.2" {
+		t.Fatalf("rewritten state = %+v", st)
+	}
+
+	newer := fmt.Sprintf(`{"schemaVersion":%d,"containerToIP":{},"ipToContainer":{}}`, StateSchema+1)
+	if err := os.WriteFile(statePath, []byte(newer), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alloc.Allocate(context.Background(), req); !errors.Is(err, schema.ErrNewer) {
+		t.Fatalf("Allocate() over a newer file error = %v", err)
+	}
+	if content, _ := os.ReadFile(statePath); string(content) != newer {
+		t.Fatalf("newer state file was rewritten: %s", content)
+	}
+}
@@ -0,0 +1,187 @@
+package ipam
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// DHCP lease file formats.
+const (
+	// LeasesDnsmasq is dnsmasq's lease file: "expiry mac ip hostname
+	// client-id" per line, expiry 0 meaning infinite.
+	LeasesDnsmasq = "dnsmasq"
+	// LeasesKea is Kea's memfile CSV (kea-leases4.csv). Rows are appended, so
+	// the last row of an address wins.
+	LeasesKea = "kea"
+)
+
+// DefaultLeaseRefresh is how often the lease file is re-imported when it
+// has not changed, so expired leases free their addresses.
+const DefaultLeaseRefresh = time.Minute
+
+// LeaseSource points the allocator at an external DHCP server's lease file.
+// Addresses leased there are excluded from allocation.
+type LeaseSource struct {
+	File    string
+	Format  string
+	Refresh time.Duration
+}
+
+// kea lease states; expired-reclaimed leases no longer hold their address.
+const keaStateExpiredReclaimed = 2
+
+// ParseLeases returns the addresses the lease file holds as active at now.
+func ParseLeases(content []byte, format string, now time.Time) ([]net.IP, error) {
+	switch format {
+	case LeasesDnsmasq:
+		return parseDnsmasqLeases(content, now)
+	case LeasesKea:
+		return parseKeaLeases(content, now)
+	default:
+		return nil, fmt.Errorf("unsupported lease format %q", format)
+	}
+}
+
+func parseDnsmasqLeases(content []byte, now time.Time) ([]net.IP, error) {
+	var out []net.IP
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		// IPv6 leases follow a "duid" line and are not ours to exclude.
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: want \"expiry mac ip ...\"", n)
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: expiry %q: %w", n, fields[0], err)
+		}
+		if expiry != 0 && !now.Before(time.Unix(expiry, 0)) {
+			continue
+		}
+		if addr, ok := netaddr.ParseV4(fields[2]); ok {
+			out = append(out, netaddr.ToIP(addr))
+		}
+	}
+	return out, scanner.Err()
+}
+
+func parseKeaLeases(content []byte, now time.Time) ([]net.IP, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"address", "expire", "state"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("header lacks %q column", name)
+		}
+	}
+
+	// The memfile is append-only; the last row of an address is its state.
+	active := map[netip.Addr]bool{}
+	for n := 2; ; n++ {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) <= max(col["address"], col["expire"], col["state"]) {
+			return nil, fmt.Errorf("line %d: too few fields", n)
+		}
+		addr, ok := netaddr.ParseV4(row[col["address"]])
+		if !ok {
+			continue
+		}
+		expire, err := strconv.ParseInt(row[col["expire"]], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: expire %q: %w", n, row[col["expire"]], err)
+		}
+		state, err := strconv.Atoi(row[col["state"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: state %q: %w", n, row[col["state"]], err)
+		}
+		active[addr] = state != keaStateExpiredReclaimed && now.Before(time.Unix(expire, 0))
+	}
+	var addrs []netip.Addr
+	for addr, ok := range active {
+		if ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	var out []net.IP
+	for _, addr := range addrs {
+		out = append(out, netaddr.ToIP(addr))
+	}
+	return out, nil
+}
+
+// syncLeases re-imports the lease file into the state's exclusions when it
+// changed or the refresh interval has passed. An unreadable file fails the
+// allocation rather than risk handing out a leased address.
+func syncLeases(st *state, req AllocationRequest, now time.Time) error {
+	defer func() {
+		st.leased = make(map[string]bool, len(st.DHCPExcluded))
+		for _, ip := range st.DHCPExcluded {
+			st.leased[ip] = true
+		}
+	}()
+	src := req.DHCPLeases
+	if src == nil {
+		st.DHCPExcluded, st.DHCPImportedAt, st.DHCPLeasesModTime = nil, time.Time{}, time.Time{}
+		return nil
+	}
+	info, err := os.Stat(src.File)
+	if err != nil {
+		return fmt.Errorf("dhcp leases: %w", err)
+	}
+	refresh := src.Refresh
+	if refresh <= 0 {
+		refresh = DefaultLeaseRefresh
+	}
+	if info.ModTime().Equal(st.DHCPLeasesModTime) && now.Sub(st.DHCPImportedAt) < refresh {
+		return nil
+	}
+	content, err := os.ReadFile(src.File)
+	if err != nil {
+		return fmt.Errorf("dhcp leases: %w", err)
+	}
+	leases, err := ParseLeases(content, src.Format, now)
+	if err != nil {
+		return fmt.Errorf("dhcp leases %s: %w", src.File, err)
+	}
+	st.DHCPExcluded = st.DHCPExcluded[:0]
+	for _, ip := range leases {
+		if req.Subnet.Contains(ip) {
+			st.DHCPExcluded = append(st.DHCPExcluded, ip.String())
+		}
+	}
+	st.DHCPImportedAt, st.DHCPLeasesModTime = now.UTC(), info.ModTime()
+	return nil
+}
@@ -0,0 +1,166 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
+)
+
+// historySuffix names a network's allocation history next to its state file.
+const historySuffix = ".history"
+
+// HistoryLimit bounds the records kept per network; the oldest go first.
+const HistoryLimit = 4096
+
+// HistoryRecord is one tenancy of an address. ReleasedAt is zero while the
+// owner still holds it; AllocatedAt is zero when the allocation predates the
+// history.
+type HistoryRecord struct {
+	IP          string    `json:"ip"`
+	Owner       string    `json:"owner"`
+	Pod         string    `json:"pod,omitempty"`
+	AllocatedAt time.Time `json:"allocatedAt,omitzero"`
+	ReleasedAt  time.Time `json:"releasedAt,omitzero"`
+}
+
+// Held reports whether the record's owner held the address at t.
+func (r HistoryRecord) Held(t time.Time) bool {
+	if !r.AllocatedAt.IsZero() && t.Before(r.AllocatedAt) {
+		return false
+	}
+	return r.ReleasedAt.IsZero() || t.Before(r.ReleasedAt)
+}
+
+// historyPath returns the history file of network.
+func historyPath(dataDir, network string) string {
+	return filepath.Join(dataDir, network+historySuffix)
+}
+
+// loadHistory reads a network's history, empty when missing. Callers hold
+// the network lock.
+func loadHistory(dataDir, network string) ([]HistoryRecord, error) {
+	path := historyPath(dataDir, network)
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(content) == 0) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	if content, err = statecrypt.Open(dataDir, filepath.Base(path), content); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	var records []HistoryRecord
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, fmt.Errorf("history file %s is corrupted: %w", path, err)
+	}
+	return records, nil
+}
+
+// saveHistory persists records, keeping the newest HistoryLimit. Callers
+// hold the network lock.
+func saveHistory(dataDir, network string, records []HistoryRecord) error {
+	if len(records) > HistoryLimit {
+		records = records[len(records)-HistoryLimit:]
+	}
+	content, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("marshal history: %w", err)
+	}
+	path := historyPath(dataDir, network)
+	if content, err = statecrypt.Seal(dataDir, filepath.Base(path), content); err != nil {
+		return fmt.Errorf("seal history: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o644); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("replace history: %w", err)
+	}
+	return nil
+}
+
+// historyEvent is an allocation (Released false) or release to record.
+type historyEvent struct {
+	IP       string
+	Owner    string
+	Pod      string
+	At       time.Time
+	Released bool
+	// AllocatedAt, for a release with no open record, is when the state says
+	// the address was taken.
+	AllocatedAt time.Time
+}
+
+// recordHistory appends allocations and closes the open records of released
+// addresses. History is for forensics, so a failure to write it is left to
+// the caller to ignore rather than failing the allocation. Callers hold the
+// network lock.
+func recordHistory(dataDir, network string, events ...historyEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	records, err := loadHistory(dataDir, network)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if !ev.Released {
+			records = append(records, HistoryRecord{IP: ev.IP, Owner: ev.Owner, Pod: ev.Pod, AllocatedAt: ev.At})
+			continue
+		}
+		closed := false
+		for i := len(records) - 1; i >= 0; i-- {
+			if records[i].IP == ev.IP && records[i].Owner == ev.Owner && records[i].ReleasedAt.IsZero() {
+				records[i].ReleasedAt = ev.At
+				closed = true
+				break
+			}
+		}
+		if !closed {
+			records = append(records, HistoryRecord{IP: ev.IP, Owner: ev.Owner, AllocatedAt: ev.AllocatedAt, ReleasedAt: ev.At})
+		}
+	}
+	return saveHistory(dataDir, network, records)
+}
+
+// HistoryReader is implemented by allocators that keep allocation history.
+type HistoryReader interface {
+	History(ctx context.Context, dataDir, network string) ([]HistoryRecord, error)
+}
+
+// History returns a network's allocation history, oldest first.
+func (a *FileAllocator) History(ctx context.Context, dataDir, network string) ([]HistoryRecord, error) {
+	if network == "" {
+		return nil, errors.New("network is required")
+	}
+	lockFile, _, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockNetwork(lockFile)
+	return loadHistory(dataDir, network)
+}
+
+// WhoHad returns the records of ip whose owner held it at t, newest first.
+// More than one means the history saw overlapping owners, e.g. after a
+// forced release.
+func WhoHad(records []HistoryRecord, ip net.IP, t time.Time) []HistoryRecord {
+	var out []HistoryRecord
+	for _, r := range records {
+		if r.IP == ip.String() && r.Held(t) {
+			out = append(out, r)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].AllocatedAt.After(out[j].AllocatedAt) })
+	return out
+}
@@ -0,0 +1,62 @@
+package ipam
+
+import (
+	"context"
+	"net"
+	"os"
+	"time"
+)
+
+// MetricsSink receives allocator observations. Backends call it so any
+// allocator gains instrumentation without duplicating it.
+type MetricsSink interface {
+	OnAllocate(network string, ip net.IP)
+	OnRelease(network string, ip net.IP)
+	OnExhausted(network string)
+	OnLockWait(network string, wait time.Duration)
+}
+
+// NopMetricsSink discards every observation.
+type NopMetricsSink struct{}
+
+// OnAllocate implements MetricsSink.
+func (NopMetricsSink) OnAllocate(string, net.IP) {}
+
+// OnRelease implements MetricsSink.
+func (NopMetricsSink) OnRelease(string, net.IP) {}
+
+// OnExhausted implements MetricsSink.
+func (NopMetricsSink) OnExhausted(string) {}
+
+// OnLockWait implements MetricsSink.
+func (NopMetricsSink) OnLockWait(string, time.Duration) {}
+
+// DirSink is a MetricsSink that keeps observations per data dir, such as one
+// persisting them there for atomicnid. The allocator reports to the sink In
+// returns for the data dir of each call.
+type DirSink interface {
+	MetricsSink
+	In(dataDir string) MetricsSink
+}
+
+// sink returns the configured metrics sink for dataDir, or a no-op when unset.
+func (a *FileAllocator) sink(dataDir string) MetricsSink {
+	if a == nil || a.Metrics == nil {
+		return NopMetricsSink{}
+	}
+	if d, ok := a.Metrics.(DirSink); ok {
+		return d.In(dataDir)
+	}
+	return a.Metrics
+}
+
+// lock takes the network lock and reports how long acquiring it took.
+func (a *FileAllocator) lock(ctx context.Context, dataDir, network string) (*os.File, string, error) {
+	start := time.Now()
+	lockFile, statePath, err := lockNetwork(ctx, dataDir, network)
+	if err != nil {
+		return nil, "", err
+	}
+	a.sink(dataDir).OnLockWait(network, time.Since(start))
+	return lockFile, statePath, nil
+}
@@ -0,0 +1,84 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingSink struct {
+	mu                  sync.Mutex
+	allocated, released []string
+	exhausted, waits    int
+}
+
+func (s *recordingSink) OnAllocate(_ string, ip net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.allocated = append(s.allocated, ip.String())
+}
+
+func (s *recordingSink) OnRelease(_ string, ip net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.released = append(s.released, ip.String())
+}
+
+func (s *recordingSink) OnExhausted(string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exhausted++
+}
+
+func (s *recordingSink) OnLockWait(string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.waits++
+}
+
+func TestAllocatorReportsMetrics(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	sink := &recordingSink{}
+	alloc := &FileAllocator{Metrics: sink}
+
+	req := AllocationRequest{
+		DataDir:    dir,
+		Network:    "atomic-net",
+		Subnet:     mustCIDR(t, "10.22.0.0/24"),
+		Gateway:    mustIP(t, "10.22.0.1"),
+		RangeStart: mustIP(t, "10.22.0.10"),
+		RangeEnd:   mustIP(t, "10.22.0.10"),
+	}
+
+	req.ContainerID = "c1"
+	if _, err := alloc.Allocate(ctx, req); err != nil {
+		t.Fatalf("Allocate(c1): %v", err)
+	}
+	req.ContainerID = "c2"
+	if _, err := alloc.Allocate(ctx, req); !errors.Is(err, ErrNoAvailableIP) {
+		t.Fatalf("Allocate(c2) error = %v, want ErrNoAvailableIP", err)
+	}
+	if err := alloc.Release(ctx, dir, "atomic-net", "c1"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := alloc.Release(ctx, dir, "atomic-net", "c1"); err != nil {
+		t.Fatalf("Release(again): %v", err)
+	}
+
+	if len(sink.allocated) != 1 || sink.allocated[0] != "10.22.0.10" {
+		t.Fatalf("allocated = %v", sink.allocated)
+	}
+	if len(sink.released) != 1 || sink.released[0] != "10.22.0.10" {
+		t.Fatalf("released = %v, want one release", sink.released)
+	}
+	if sink.exhausted != 1 {
+		t.Fatalf("exhausted = %d, want 1", sink.exhausted)
+	}
+	if sink.waits != 4 {
+		t.Fatalf("lock waits = %d, want 4", sink.waits)
+	}
+}
@@ -0,0 +1,166 @@
+package ipam
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// Lister reads allocations from a backend.
+type Lister interface {
+	Networks(ctx context.Context, dataDir string) ([]string, error)
+	List(ctx context.Context, dataDir, network string) (map[string]net.IP, error)
+}
+
+// Importer writes a batch of allocations into a backend in one step.
+type Importer interface {
+	Import(ctx context.Context, dataDir, network string, allocations map[string]net.IP) error
+}
+
+// Import merges allocations into the network state in a single atomic write.
+// Nothing is written when any entry conflicts with an existing reservation.
+func (a *FileAllocator) Import(ctx context.Context, dataDir, network string, allocations map[string]net.IP) error {
+	if network == "" {
+		return errors.New("network is required")
+	}
+
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return err
+	}
+	defer unlockNetwork(lockFile)
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return err
+	}
+
+	for containerID, ip := range allocations {
+		addr, ok := netaddr.V4(ip)
+		if !ok {
+			return fmt.Errorf("container %q: IP %s is not IPv4", containerID, ip)
+		}
+		ipStr := addr.String()
+		if held, ok := st.ContainerToIP[containerID]; ok && held != ipStr {
+			return fmt.Errorf("container %q already holds %s, cannot import %s", containerID, held, ipStr)
+		}
+		if owner, ok := st.IPToContainer[ipStr]; ok && owner != containerID {
+			return fmt.Errorf("IP %s already held by %q, cannot import for %q", ipStr, owner, containerID)
+		}
+	}
+	now := a.now()
+	var imported []historyEvent
+	for containerID, ip := range allocations {
+		addr, _ := netaddr.V4(ip)
+		ipStr := addr.String()
+		if _, held := st.ContainerToIP[containerID]; !held {
+			if st.AllocatedAt == nil {
+				st.AllocatedAt = map[string]time.Time{}
+			}
+			st.AllocatedAt[containerID] = now
+			imported = append(imported, historyEvent{IP: ipStr, Owner: containerID, At: now})
+		}
+		st.ContainerToIP[containerID] = ipStr
+		st.IPToContainer[ipStr] = containerID
+	}
+	if err := saveState(statePath, st); err != nil {
+		return err
+	}
+	_ = recordHistory(dataDir, network, imported...)
+	return nil
+}
+
+// HostLocalStore reads state written by the CNI host-local IPAM plugin:
+// one directory per network with one file per reserved IP holding the owner.
+type HostLocalStore struct{}
+
+// Networks returns the network directories under dataDir.
+func (HostLocalStore) Networks(_ context.Context, dataDir string) ([]string, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("read host-local dir: %w", err)
+	}
+	var networks []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			networks = append(networks, entry.Name())
+		}
+	}
+	sort.Strings(networks)
+	return networks, nil
+}
+
+// List parses reservation files; non-IPv4 and bookkeeping files are skipped.
+func (HostLocalStore) List(_ context.Context, dataDir, network string) (map[string]net.IP, error) {
+	dir := filepath.Join(dataDir, network)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read host-local network dir: %w", err)
+	}
+
+	out := map[string]net.IP{}
+	for _, entry := range entries {
+		ip := storedIP(entry.Name())
+		if entry.IsDir() || ip == nil {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("open reservation %s: %w", entry.Name(), err)
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan()
+		containerID := strings.TrimSpace(scanner.Text())
+		_ = f.Close()
+		if containerID == "" {
+			return nil, fmt.Errorf("reservation %s has no container ID", entry.Name())
+		}
+		if prev, ok := out[containerID]; ok {
+			return nil, fmt.Errorf("container %q holds both %s and %s", containerID, prev, ip)
+		}
+		out[containerID] = ip
+	}
+	return out, nil
+}
+
+// MigrateBackend is a destination that can be both read and written.
+type MigrateBackend interface {
+	Lister
+	Importer
+}
+
+// Migrate copies one network's allocations from src to dst and verifies that
+// every reservation reads back identically. With dryRun only the source is read.
+func Migrate(ctx context.Context, src Lister, srcDir string, dst MigrateBackend, dstDir, network string, dryRun bool) (map[string]net.IP, error) {
+	allocations, err := src.List(ctx, srcDir, network)
+	if err != nil {
+		return nil, fmt.Errorf("read source: %w", err)
+	}
+	if dryRun || len(allocations) == 0 {
+		return allocations, nil
+	}
+
+	if err := dst.Import(ctx, dstDir, network, allocations); err != nil {
+		return nil, fmt.Errorf("import: %w", err)
+	}
+
+	written, err := dst.List(ctx, dstDir, network)
+	if err != nil {
+		return nil, fmt.Errorf("verify: %w", err)
+	}
+	for containerID, ip := range allocations {
+		if got, ok := written[containerID]; !ok || !got.Equal(ip) {
+			return nil, fmt.Errorf("verify: container %q expected %s, found %v", containerID, ip, got)
+		}
+	}
+	return allocations, nil
+}
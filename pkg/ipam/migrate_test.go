@@ -0,0 +1,84 @@
+package ipam
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/clock"
+)
+
+func TestMigrateHostLocalToFile(t *testing.T) {
+	src := t.TempDir()
+	netDir := filepath.Join(src, "atomic-net")
+	if err := os.MkdirAll(netDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	files := map[string]string{
+		"10.22.0.10":         "c1\r\neth0",
+		"10.22.0.11":         "c2\r\neth0",
+		"last_reserved_ip.0": "10.22.0.11",
+		"lock":               "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(netDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	dst := t.TempDir()
+	alloc := NewFileAllocator()
+	migrated, err := Migrate(context.Background(), HostLocalStore{}, src, alloc, dst, "atomic-net", false)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if len(migrated) != 2 {
+		t.Fatalf("expected 2 migrated allocations, got %v", migrated)
+	}
+
+	ip, ok, err := alloc.GetByContainer(context.Background(), dst, "atomic-net", "c2")
+	if err != nil || !ok || ip.String() != "10.22.0.11" {
+		t.Fatalf("expected c2 -> 10.22.0.11, got %v ok=%v err=%v", ip, ok, err)
+	}
+}
+
+func TestImportRejectsConflicts(t *testing.T) {
+	dir := t.TempDir()
+	alloc := NewFileAllocator()
+	if err := alloc.Import(context.Background(), dir, "atomic-net", map[string]net.IP{"c1": mustIP(t, "10.22.0.10")}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	err := alloc.Import(context.Background(), dir, "atomic-net", map[string]net.IP{
+		"c2": mustIP(t, "10.22.0.20"),
+		"c3": mustIP(t, "10.22.0.10"),
+	})
+	if err == nil {
+		t.Fatalf("expected conflict error")
+	}
+	if _, ok, _ := alloc.GetByContainer(context.Background(), dir, "atomic-net", "c2"); ok {
+		t.Fatalf("conflicting import must not be partially applied")
+	}
+}
+
+func TestImportStampsAllocationTime(t *testing.T) {
+	dir := t.TempDir()
+	fake := clock.NewFake(time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC))
+	alloc := NewFileAllocator()
+	alloc.Clock = fake
+	if err := alloc.Import(context.Background(), dir, "atomic-net", map[string]net.IP{"c1": mustIP(t, "10.22.0.10")}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	fake.Advance(48 * time.Hour)
+
+	report, err := alloc.Prune(context.Background(), dir, "atomic-net", PruneFilter{OlderThan: 24 * time.Hour}, true)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(report) != 1 || report[0].ContainerID != "c1" || !report[0].AllocatedAt.Equal(time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("imported allocation not selectable by age: %+v", report)
+	}
+}
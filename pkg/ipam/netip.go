@@ -0,0 +1,42 @@
+package ipam
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// RangeFromAddrs builds a Range from netip bounds.
+func RangeFromAddrs(start, end netip.Addr, priority int) Range {
+	return Range{Start: netaddr.ToIP(start), End: netaddr.ToIP(end), Priority: priority}
+}
+
+// AllocateAddr is Allocate returning a netip.Addr.
+func AllocateAddr(ctx context.Context, a Allocator, req AllocationRequest) (netip.Addr, error) {
+	ip, err := a.Allocate(ctx, req)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, ok := netaddr.FromIP(ip)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("allocator returned invalid IP %v", ip)
+	}
+	return addr, nil
+}
+
+// GetAddrByContainer is GetByContainer returning a netip.Addr.
+func GetAddrByContainer(ctx context.Context, a Allocator, dataDir, network, containerID string) (netip.Addr, bool, error) {
+	ip, ok, err := a.GetByContainer(ctx, dataDir, network, containerID)
+	if err != nil || !ok {
+		return netip.Addr{}, ok, err
+	}
+	addr, valid := netaddr.FromIP(ip)
+	return addr, valid, nil
+}
+
+// ReleaseAddr is ReleaseIP taking a netip.Addr.
+func ReleaseAddr(ctx context.Context, a Allocator, dataDir, network string, addr netip.Addr) error {
+	return a.ReleaseIP(ctx, dataDir, network, netaddr.ToIP(addr))
+}
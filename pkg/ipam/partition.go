@@ -0,0 +1,131 @@
+package ipam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+type partitionIndex struct {
+	Partitions map[string]string `json:"partitions"`
+}
+
+// CarveSubnet returns the subnet recorded for key in the shared index file,
+// carving and recording the first free /prefix block of cluster when none exists.
+func CarveSubnet(indexPath string, cluster *net.IPNet, prefix int, key string) (*net.IPNet, error) {
+	if key == "" {
+		return nil, errors.New("partition key is required")
+	}
+	clusterPrefix, ok := netaddr.V4Prefix(cluster)
+	if !ok || prefix < clusterPrefix.Bits() || prefix > 32 {
+		return nil, fmt.Errorf("cannot carve /%d from %s", prefix, cluster)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o755); err != nil {
+		return nil, fmt.Errorf("create partition dir: %w", err)
+	}
+	f, err := os.OpenFile(indexPath+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open partition lock: %w", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return nil, fmt.Errorf("lock partitions: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	idx, err := loadPartitionIndex(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, ok := idx.Partitions[key]; ok {
+		_, subnet, err := net.ParseCIDR(existing)
+		if err != nil {
+			return nil, fmt.Errorf("partition for %q is invalid: %q", key, existing)
+		}
+		if size, _ := subnet.Mask.Size(); size != prefix || !cluster.Contains(subnet.IP) {
+			return nil, fmt.Errorf("partition for %q is %s, which does not match %s /%d", key, existing, cluster, prefix)
+		}
+		return subnet, nil
+	}
+
+	var used []netip.Prefix
+	for _, cidr := range idx.Partitions {
+		if n, err := netip.ParsePrefix(cidr); err == nil {
+			used = append(used, n.Masked())
+		}
+	}
+
+	base := netaddr.Uint32(clusterPrefix.Addr())
+	blockSize := uint64(1) << uint(32-prefix)
+	blocks := uint64(1) << uint(prefix-clusterPrefix.Bits())
+	for i := uint64(0); i < blocks; i++ {
+		candidate := netip.PrefixFrom(netaddr.FromUint32(uint32(uint64(base)+i*blockSize)), prefix)
+		if overlapsAny(candidate, used) {
+			continue
+		}
+		idx.Partitions[key] = candidate.String()
+		if err := savePartitionIndex(indexPath, idx); err != nil {
+			return nil, err
+		}
+		return netaddr.ToIPNet(candidate), nil
+	}
+	return nil, fmt.Errorf("clusterSubnet %s has no free /%d partition", cluster, prefix)
+}
+
+// overlapsAny reports whether n overlaps any of the given subnets.
+func overlapsAny(n netip.Prefix, others []netip.Prefix) bool {
+	for _, o := range others {
+		if n.Overlaps(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadPartitionIndex reads the index, returning an empty one when missing.
+func loadPartitionIndex(path string) (*partitionIndex, error) {
+	idx := &partitionIndex{Partitions: map[string]string{}}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("read partition index: %w", err)
+	}
+	if len(content) == 0 {
+		return idx, nil
+	}
+	if err := json.Unmarshal(content, idx); err != nil {
+		return nil, fmt.Errorf("partition index %s is corrupted: %w", path, err)
+	}
+	if idx.Partitions == nil {
+		idx.Partitions = map[string]string{}
+	}
+	return idx, nil
+}
+
+// savePartitionIndex atomically persists the index using write-then-rename.
+func savePartitionIndex(path string, idx *partitionIndex) error {
+	content, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal partition index: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o644); err != nil {
+		return fmt.Errorf("write temp partition index: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace partition index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package ipam
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCarveSubnetStableAndDisjoint(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "partitions", "index.json")
+	cluster := mustCIDR(t, "10.40.0.0/24")
+
+	a, err := CarveSubnet(index, cluster, 26, "net-a")
+	if err != nil {
+		t.Fatalf("CarveSubnet(net-a): %v", err)
+	}
+	b, err := CarveSubnet(index, cluster, 26, "net-b")
+	if err != nil {
+		t.Fatalf("CarveSubnet(net-b): %v", err)
+	}
+	again, err := CarveSubnet(index, cluster, 26, "net-a")
+	if err != nil {
+		t.Fatalf("CarveSubnet(net-a again): %v", err)
+	}
+
+	if a.String() != "10.40.0.0/26" || b.String() != "10.40.0.64/26" {
+		t.Fatalf("unexpected partitions: %s %s", a, b)
+	}
+	if again.String() != a.String() {
+		t.Fatalf("expected stable partition, got %s then %s", a, again)
+	}
+}
+
+func TestCarveSubnetExhaustion(t *testing.T) {
+	index := filepath.Join(t.TempDir(), "index.json")
+	cluster := mustCIDR(t, "10.40.0.0/25")
+	for _, key := range []string{"a", "b"} {
+		if _, err := CarveSubnet(index, cluster, 26, key); err != nil {
+			t.Fatalf("CarveSubnet(%s): %v", key, err)
+		}
+	}
+	if _, err := CarveSubnet(index, cluster, 26, "c"); err == nil {
+		t.Fatalf("expected exhaustion error")
+	}
+}
@@ -0,0 +1,109 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// PruneFilter selects allocations to prune; set criteria are combined with AND.
+// Entries without a recorded allocation time never match OlderThan.
+type PruneFilter struct {
+	OlderThan time.Duration
+	Match     *regexp.Regexp
+	Now       time.Time
+}
+
+// PrunedEntry describes one pruned (or, in dry-run, prunable) allocation.
+type PrunedEntry struct {
+	ContainerID string
+	IP          string
+	AllocatedAt time.Time
+}
+
+// matches reports whether an entry satisfies every configured criterion.
+func (f PruneFilter) matches(containerID string, allocatedAt time.Time) bool {
+	if f.Match != nil && !f.Match.MatchString(containerID) {
+		return false
+	}
+	if f.OlderThan > 0 {
+		if allocatedAt.IsZero() || f.Now.Sub(allocatedAt) < f.OlderThan {
+			return false
+		}
+	}
+	return true
+}
+
+// Prune removes allocations selected by filter and compacts the state file:
+// reverse-index entries that do not map back and timestamps of unknown
+// containers are dropped. With dryRun nothing is written.
+func (a *FileAllocator) Prune(ctx context.Context, dataDir, network string, filter PruneFilter, dryRun bool) ([]PrunedEntry, error) {
+	if network == "" {
+		return nil, errors.New("network is required")
+	}
+	if filter.OlderThan <= 0 && filter.Match == nil {
+		return nil, errors.New("prune requires an age or container ID filter")
+	}
+	if filter.Now.IsZero() {
+		filter.Now = a.now()
+	}
+
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockNetwork(lockFile)
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []PrunedEntry
+	for containerID, ip := range st.ContainerToIP {
+		allocatedAt := st.AllocatedAt[containerID]
+		if !filter.matches(containerID, allocatedAt) {
+			continue
+		}
+		pruned = append(pruned, PrunedEntry{ContainerID: containerID, IP: ip, AllocatedAt: allocatedAt})
+		delete(st.ContainerToIP, containerID)
+		delete(st.AllocatedAt, containerID)
+	}
+	sort.Slice(pruned, func(i, j int) bool { return pruned[i].ContainerID < pruned[j].ContainerID })
+
+	if dryRun {
+		return pruned, nil
+	}
+	compactState(st)
+	if err := saveState(statePath, st); err != nil {
+		return nil, err
+	}
+	var events []historyEvent
+	for _, entry := range pruned {
+		events = append(events, historyEvent{IP: entry.IP, Owner: entry.ContainerID, At: filter.Now, Released: true, AllocatedAt: entry.AllocatedAt})
+	}
+	_ = recordHistory(dataDir, network, events...)
+	for _, entry := range pruned {
+		a.sink(dataDir).OnRelease(network, storedIP(entry.IP))
+	}
+	return pruned, nil
+}
+
+// compactState rebuilds the reverse index from the forward index and drops
+// bookkeeping for containers that no longer hold an address.
+func compactState(st *state) {
+	st.IPToContainer = make(map[string]string, len(st.ContainerToIP))
+	for containerID, ip := range st.ContainerToIP {
+		st.IPToContainer[ip] = containerID
+	}
+	for containerID := range st.AllocatedAt {
+		if _, ok := st.ContainerToIP[containerID]; !ok {
+			delete(st.AllocatedAt, containerID)
+		}
+	}
+	if len(st.AllocatedAt) == 0 {
+		st.AllocatedAt = nil
+	}
+}
@@ -0,0 +1,102 @@
+package ipam
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/clock"
+)
+
+func TestPruneByAgeAndRegex(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "atomic-net.json")
+	now := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+
+	st := newState()
+	st.AllocatedAt = map[string]time.Time{}
+	for id, ip := range map[string]string{"test-old": "10.22.0.10", "test-new": "10.22.0.11", "prod-old": "10.22.0.12", "test-unknown": "10.22.0.13"} {
+		st.ContainerToIP[id] = ip
+		st.IPToContainer[ip] = id
+	}
+	st.AllocatedAt["test-old"] = now.Add(-48 * time.Hour)
+	st.AllocatedAt["test-new"] = now.Add(-time.Hour)
+	st.AllocatedAt["prod-old"] = now.Add(-48 * time.Hour)
+	st.IPToContainer["10.22.0.99"] = "ghost"
+	if err := saveState(statePath, st); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	alloc := NewFileAllocator()
+	filter := PruneFilter{OlderThan: 24 * time.Hour, Match: regexp.MustCompile(`^test-`), Now: now}
+
+	report, err := alloc.Prune(context.Background(), dir, "atomic-net", filter, true)
+	if err != nil {
+		t.Fatalf("Prune(dry-run): %v", err)
+	}
+	if len(report) != 1 || report[0].ContainerID != "test-old" {
+		t.Fatalf("unexpected dry-run report: %+v", report)
+	}
+	if got, _ := loadState(statePath); len(got.ContainerToIP) != 4 {
+		t.Fatalf("dry-run must not modify state")
+	}
+
+	if _, err := alloc.Prune(context.Background(), dir, "atomic-net", filter, false); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	got, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if _, ok := got.ContainerToIP["test-old"]; ok || len(got.ContainerToIP) != 3 {
+		t.Fatalf("unexpected forward index: %v", got.ContainerToIP)
+	}
+	if _, ok := got.IPToContainer["10.22.0.99"]; ok || len(got.IPToContainer) != 3 {
+		t.Fatalf("expected compacted reverse index, got %v", got.IPToContainer)
+	}
+}
+
+func TestPruneRequiresFilter(t *testing.T) {
+	if _, err := NewFileAllocator().Prune(context.Background(), t.TempDir(), "atomic-net", PruneFilter{}, true); err == nil {
+		t.Fatalf("expected Prune without filter to fail")
+	}
+}
+
+func TestPruneAgesAllocationsOnTheAllocatorClock(t *testing.T) {
+	dir := t.TempDir()
+	fake := clock.NewFake(time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC))
+	alloc := NewFileAllocator()
+	alloc.Clock = fake
+	req := AllocationRequest{
+		DataDir:    dir,
+		Network:    "atomic-net",
+		Subnet:     mustCIDR(t, "10.22.0.0/29"),
+		Gateway:    mustIP(t, "10.22.0.1"),
+		RangeStart: mustIP(t, "10.22.0.2"),
+		RangeEnd:   mustIP(t, "10.22.0.6"),
+	}
+	for _, id := range []string{"old", "new"} {
+		req.ContainerID = id
+		if _, err := alloc.Allocate(context.Background(), req); err != nil {
+			t.Fatalf("Allocate(%s): %v", id, err)
+		}
+		fake.Advance(24 * time.Hour)
+	}
+
+	report, err := alloc.Prune(context.Background(), dir, "atomic-net", PruneFilter{OlderThan: 36 * time.Hour}, false)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if len(report) != 1 || report[0].ContainerID != "old" || !report[0].AllocatedAt.Equal(time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	records, err := alloc.History(context.Background(), dir, "atomic-net")
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	if len(records) != 2 || records[0].Owner != "old" || !records[0].ReleasedAt.Equal(fake.Now()) || !records[1].AllocatedAt.Equal(fake.Now().Add(-24*time.Hour)) {
+		t.Fatalf("history does not follow the clock: %+v", records)
+	}
+}
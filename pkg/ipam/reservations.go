@@ -0,0 +1,188 @@
+package ipam
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// LoadReservations reads identity -> IP pre-reservations from a seed file.
+// A .csv file holds "identity,ip" rows, optionally under an "identity,ip"
+// header; a .yaml or .yml file holds a flat "identity: ip" mapping. Lines
+// starting with # are comments in both. The digest identifies the content.
+func LoadReservations(path string) (reservations map[string]net.IP, digest string, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("read reservations: %w", err)
+	}
+	var pairs [][2]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		pairs, err = parseReservationsCSV(content)
+	case ".yaml", ".yml":
+		pairs, err = parseReservationsYAML(content)
+	default:
+		return nil, "", fmt.Errorf("reservations file %s: want a .csv, .yaml, or .yml file", path)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("reservations file %s: %w", path, err)
+	}
+
+	out := make(map[string]net.IP, len(pairs))
+	owners := map[string]string{}
+	for _, pair := range pairs {
+		identity, value := pair[0], pair[1]
+		addr, ok := netaddr.ParseV4(value)
+		if identity == "" {
+			return nil, "", fmt.Errorf("reservations file %s: empty identity for %s", path, value)
+		}
+		if !ok {
+			return nil, "", fmt.Errorf("reservations file %s: %s: %q is not an IPv4 address", path, identity, value)
+		}
+		ip := netaddr.ToIP(addr)
+		if _, dup := out[identity]; dup {
+			return nil, "", fmt.Errorf("reservations file %s: %s is reserved twice", path, identity)
+		}
+		if other, dup := owners[ip.String()]; dup {
+			return nil, "", fmt.Errorf("reservations file %s: %s is reserved for both %s and %s", path, ip, other, identity)
+		}
+		out[identity] = ip
+		owners[ip.String()] = identity
+	}
+	sum := sha256.Sum256(content)
+	return out, hex.EncodeToString(sum[:]), nil
+}
+
+// parseReservationsCSV reads "identity,ip" rows.
+func parseReservationsCSV(content []byte) ([][2]string, error) {
+	r := csv.NewReader(bytes.NewReader(content))
+	r.Comment = '#'
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	var pairs [][2]string
+	for first := true; ; first = false {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return pairs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		identity, ip := strings.TrimSpace(row[0]), strings.TrimSpace(row[1])
+		if first && strings.EqualFold(identity, "identity") && strings.EqualFold(ip, "ip") {
+			continue
+		}
+		pairs = append(pairs, [2]string{identity, ip})
+	}
+}
+
+// parseReservationsYAML reads a flat YAML mapping of identity to IP. Nested
+// documents are rejected rather than half understood.
+func parseReservationsYAML(content []byte) ([][2]string, error) {
+	var pairs [][2]string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: want a flat \"identity: ip\" mapping", n)
+		}
+		key, value, ok := strings.Cut(trimmed, ": ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: want \"identity: ip\"", n)
+		}
+		pairs = append(pairs, [2]string{unquoteYAML(key), unquoteYAML(value)})
+	}
+	return pairs, scanner.Err()
+}
+
+// unquoteYAML strips matching single or double quotes from a scalar.
+func unquoteYAML(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// syncReservations loads the request's reservations file into the state on
+// first use, and again whenever the file changes. Existing allocations are
+// left alone; a reservation only steers future allocations.
+func syncReservations(st *state, req AllocationRequest, b bounds) error {
+	defer func() {
+		st.reservedBy = make(map[string]string, len(st.Reservations))
+		for identity, ip := range st.Reservations {
+			st.reservedBy[ip] = identity
+		}
+	}()
+	if req.ReservationsFile == "" {
+		st.Reservations, st.ReservationsDigest = nil, ""
+		return nil
+	}
+	reservations, digest, err := LoadReservations(req.ReservationsFile)
+	if err != nil {
+		return err
+	}
+	if digest == st.ReservationsDigest {
+		return nil
+	}
+	loaded := make(map[string]string, len(reservations))
+	for identity, ip := range reservations {
+		addr, _ := netaddr.V4(ip)
+		if !b.subnet.Contains(addr) {
+			return fmt.Errorf("reservations file %s: %s: %s is outside subnet %s", req.ReservationsFile, identity, ip, req.Subnet)
+		}
+		if !b.allocatable(addr) {
+			return fmt.Errorf("reservations file %s: %s: %s is not allocatable", req.ReservationsFile, identity, ip)
+		}
+		loaded[identity] = addr.String()
+	}
+	st.Reservations, st.ReservationsDigest = loaded, digest
+	return nil
+}
+
+// reservationOf returns the address reserved for the request, matching its
+// pod name first and then its owner.
+func reservationOf(st *state, req AllocationRequest) (string, bool) {
+	if req.Pod != "" {
+		if ip, ok := st.Reservations[req.Pod]; ok {
+			return ip, true
+		}
+	}
+	ip, ok := st.Reservations[req.ContainerID]
+	return ip, ok
+}
+
+// reservedForOther reports whether ip is reserved for an identity other than
+// the request's.
+func reservedForOther(st *state, req AllocationRequest, ip string) bool {
+	identity, ok := st.reservedBy[ip]
+	return ok && identity != req.Pod && identity != req.ContainerID
+}
+
+// tryReserved returns the request's reserved address when it is free.
+func tryReserved(st *state, req AllocationRequest) (netip.Addr, bool) {
+	ipStr, ok := reservationOf(st, req)
+	if !ok || unavailable(st, req, ipStr) {
+		return netip.Addr{}, false
+	}
+	return netaddr.ParseV4(ipStr)
+}
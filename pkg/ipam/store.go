@@ -1,18 +1,51 @@
 package ipam
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/schema"
+	"github.com/annis-souames/atomicni/pkg/statecrypt"
 )
 
+// StateSchema is the schema version of the state files this build writes.
+const StateSchema = 1
+
+// stateMigrations upgrade older state files; entry i upgrades version i.
+var stateMigrations = [StateSchema]schema.Migration{
+	// 0 -> 1: the version field itself.
+	schema.Stamp,
+}
+
 type state struct {
-	ContainerToIP map[string]string `json:"containerToIP"`
-	IPToContainer map[string]string `json:"ipToContainer"`
-	LastReserved  string            `json:"lastReserved,omitempty"`
+	SchemaVersion int                  `json:"schemaVersion"`
+	ContainerToIP map[string]string    `json:"containerToIP"`
+	IPToContainer map[string]string    `json:"ipToContainer"`
+	AllocatedAt   map[string]time.Time `json:"allocatedAt,omitempty"`
+	LastReserved  string               `json:"lastReserved,omitempty"`
+	// Reservations maps identities to addresses loaded from the network's
+	// reservations file, whose content ReservationsDigest identifies.
+	Reservations       map[string]string `json:"reservations,omitempty"`
+	ReservationsDigest string            `json:"reservationsDigest,omitempty"`
+	// DHCPExcluded lists the subnet addresses the DHCP lease file held when
+	// it was last imported at DHCPImportedAt; DHCPLeasesModTime is the
+	// file's mtime then.
+	DHCPExcluded      []string  `json:"dhcpExcluded,omitempty"`
+	DHCPImportedAt    time.Time `json:"dhcpImportedAt,omitzero"`
+	DHCPLeasesModTime time.Time `json:"dhcpLeasesModTime,omitzero"`
+
+	// reservedBy indexes Reservations by address.
+	reservedBy map[string]string
+	// leased indexes DHCPExcluded.
+	leased map[string]bool
 }
 
 // newState returns an initialized empty allocation state.
@@ -23,22 +56,52 @@ func newState() *state {
 	}
 }
 
+// lockPollInterval is how often a lock with a deadline is retried.
+const lockPollInterval = 10 * time.Millisecond
+
 // lockNetwork creates/locks a per-network file and returns state file path.
-func lockNetwork(dataDir, network string) (*os.File, string, error) {
-	if err := os.MkdirAll(dataDir, 0o755); err != nil {
-		return nil, "", fmt.Errorf("create data dir: %w", err)
+// When ctx has a deadline it stops waiting for the lock once ctx is done.
+func lockNetwork(ctx context.Context, dataDir, network string) (*os.File, string, error) {
+	lockPath := filepath.Join(dataDir, network+".lock")
+	for {
+		if err := os.MkdirAll(dataDir, 0o755); err != nil {
+			return nil, "", fmt.Errorf("create data dir: %w", err)
+		}
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
+		if err != nil {
+			return nil, "", fmt.Errorf("open lock file: %w", err)
+		}
+		if err := flock(ctx, f); err != nil {
+			_ = f.Close()
+			return nil, "", fmt.Errorf("lock state: %w", err)
+		}
+		// A restore may have moved the data dir aside while we waited; the
+		// lock only guards the state if its file is still at lockPath.
+		if held, err := f.Stat(); err == nil {
+			if current, err := os.Stat(lockPath); err == nil && os.SameFile(held, current) {
+				return f, filepath.Join(dataDir, network+".json"), nil
+			}
+		}
+		unlockNetwork(f)
 	}
+}
 
-	lockPath := filepath.Join(dataDir, network+".lock")
-	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0o644)
-	if err != nil {
-		return nil, "", fmt.Errorf("open lock file: %w", err)
+// flock takes an exclusive lock on f, polling when ctx can be cancelled.
+func flock(ctx context.Context, f *os.File) error {
+	if ctx.Done() == nil {
+		return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
 	}
-	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
-		_ = f.Close()
-		return nil, "", fmt.Errorf("lock state: %w", err)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
 	}
-	return f, filepath.Join(dataDir, network+".json"), nil
 }
 
 // unlockNetwork releases the advisory lock and closes the file handle.
@@ -47,6 +110,30 @@ func unlockNetwork(f *os.File) {
 	_ = f.Close()
 }
 
+// LockAll locks every network's state in dataDir, in name order so that two
+// callers cannot deadlock, and returns the function that unlocks them.
+func LockAll(ctx context.Context, dataDir string) (func(), error) {
+	networks, err := listNetworks(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var locked []*os.File
+	unlock := func() {
+		for _, f := range locked {
+			unlockNetwork(f)
+		}
+	}
+	for _, network := range networks {
+		f, _, err := lockNetwork(ctx, dataDir, network)
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("lock %q: %w", network, err)
+		}
+		locked = append(locked, f)
+	}
+	return unlock, nil
+}
+
 // loadState reads state from disk, returning an empty state when missing.
 func loadState(path string) (*state, error) {
 	content, err := os.ReadFile(path)
@@ -61,7 +148,18 @@ func loadState(path string) (*state, error) {
 	if len(content) == 0 {
 		return st, nil
 	}
-	if err := json.Unmarshal(content, st); err != nil {
+	content, err = statecrypt.Open(filepath.Dir(path), filepath.Base(path), content)
+	if err != nil {
+		return nil, fmt.Errorf("read state file: %w", err)
+	}
+	content, _, err = schema.Upgrade(content, stateMigrations[:])
+	if errors.Is(err, schema.ErrNewer) {
+		return nil, fmt.Errorf("ipam state file %s: %w", path, err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, st)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("ipam state file %s is corrupted: %w", path, err)
 	}
 	if st.ContainerToIP == nil {
@@ -75,10 +173,14 @@ func loadState(path string) (*state, error) {
 
 // saveState atomically persists state to disk using write-then-rename.
 func saveState(path string, st *state) error {
+	st.SchemaVersion = StateSchema
 	content, err := json.MarshalIndent(st, "", "  ")
 	if err != nil {
 		return fmt.Errorf("marshal state: %w", err)
 	}
+	if content, err = statecrypt.Seal(filepath.Dir(path), filepath.Base(path), content); err != nil {
+		return fmt.Errorf("seal state: %w", err)
+	}
 
 	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, content, 0o644); err != nil {
@@ -90,3 +192,25 @@ func saveState(path string, st *state) error {
 	}
 	return nil
 }
+
+// listNetworks returns network names that have a state file in dataDir.
+func listNetworks(dataDir string) ([]string, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read data dir: %w", err)
+	}
+
+	var networks []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		networks = append(networks, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(networks)
+	return networks, nil
+}
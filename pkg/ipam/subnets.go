@@ -0,0 +1,90 @@
+package ipam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// SubnetIndexFile records the subnet of every network in a data dir.
+const SubnetIndexFile = "subnets.index"
+
+// ClaimSubnet records network's subnet in the data dir's subnet index. The
+// first claim, or a claim of a changed subnet, first calls check with the
+// subnets of the other networks that still have allocation state, and
+// records nothing when check fails. Later claims of the same subnet cost one
+// small read.
+func ClaimSubnet(dataDir, network string, subnet *net.IPNet, check func(others map[string]*net.IPNet) error) error {
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	indexPath := filepath.Join(dataDir, SubnetIndexFile)
+	f, err := os.OpenFile(indexPath+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("open subnet index lock: %w", err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock subnet index: %w", err)
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	index := map[string]string{}
+	content, err := os.ReadFile(indexPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read subnet index: %w", err)
+	}
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &index); err != nil {
+			return fmt.Errorf("subnet index %s is corrupted: %w", indexPath, err)
+		}
+	}
+	if index[network] == subnet.String() {
+		return nil
+	}
+
+	// A network whose state file is gone has been removed from the node.
+	others := map[string]*net.IPNet{}
+	for name, cidr := range index {
+		if name == network {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dataDir, name+".json")); err != nil {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			others[name] = n
+		}
+	}
+	if err := check(others); err != nil {
+		return err
+	}
+
+	index[network] = subnet.String()
+	if content, err = json.MarshalIndent(index, "", "  "); err != nil {
+		return fmt.Errorf("marshal subnet index: %w", err)
+	}
+	tmpPath := indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o644); err != nil {
+		return fmt.Errorf("write temp subnet index: %w", err)
+	}
+	if err := os.Rename(tmpPath, indexPath); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace subnet index: %w", err)
+	}
+	return nil
+}
+
+// Overlaps reports whether two subnets share any address.
+func Overlaps(a, b *net.IPNet) bool {
+	pa, okA := netaddr.FromIPNet(a)
+	pb, okB := netaddr.FromIPNet(b)
+	return okA && okB && overlapsAny(pa.Masked(), []netip.Prefix{pb.Masked()})
+}
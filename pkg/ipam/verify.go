@@ -0,0 +1,54 @@
+package ipam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// Verify checks that a network's state is internally consistent: every
+// address is valid IPv4, held by at most one container, and both indexes
+// agree. All problems are reported together.
+func (a *FileAllocator) Verify(ctx context.Context, dataDir, network string) error {
+	if network == "" {
+		return errors.New("network is required")
+	}
+
+	lockFile, statePath, err := a.lock(ctx, dataDir, network)
+	if err != nil {
+		return err
+	}
+	defer unlockNetwork(lockFile)
+
+	st, err := loadState(statePath)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	owners := map[string][]string{}
+	for containerID, ip := range st.ContainerToIP {
+		if _, ok := netaddr.ParseV4(ip); !ok {
+			errs = append(errs, fmt.Errorf("container %q holds invalid IP %q", containerID, ip))
+		}
+		owners[ip] = append(owners[ip], containerID)
+		if st.IPToContainer[ip] != containerID {
+			errs = append(errs, fmt.Errorf("container %q holds %s but reverse index points to %q", containerID, ip, st.IPToContainer[ip]))
+		}
+	}
+	for ip, ids := range owners {
+		if len(ids) > 1 {
+			sort.Strings(ids)
+			errs = append(errs, fmt.Errorf("ip %s is held by %d containers: %v", ip, len(ids), ids))
+		}
+	}
+	for ip, containerID := range st.IPToContainer {
+		if st.ContainerToIP[containerID] != ip {
+			errs = append(errs, fmt.Errorf("reverse index maps %s to %q which does not hold it", ip, containerID))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+package ipam
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyDetectsInconsistentState(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	alloc := NewFileAllocator()
+
+	req := AllocationRequest{
+		DataDir:     dir,
+		Network:     "atomic-net",
+		ContainerID: "c1",
+		Subnet:      mustCIDR(t, "10.22.0.0/24"),
+		Gateway:     mustIP(t, "10.22.0.1"),
+		RangeStart:  mustIP(t, "10.22.0.10"),
+		RangeEnd:    mustIP(t, "10.22.0.20"),
+	}
+	if _, err := alloc.Allocate(ctx, req); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if err := alloc.Verify(ctx, dir, "atomic-net"); err != nil {
+		t.Fatalf("Verify(clean): %v", err)
+	}
+
+	statePath := filepath.Join(dir, "atomic-net.json")
+	st, err := loadState(statePath)
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	st.ContainerToIP["c2"] = st.ContainerToIP["c1"]
+	st.IPToContainer["10.22.0.99"] = "ghost"
+	if err := saveState(statePath, st); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	err = alloc.Verify(ctx, dir, "atomic-net")
+	if err == nil {
+		t.Fatal("Verify succeeded on corrupted state")
+	}
+	for _, want := range []string{"held by 2 containers", "10.22.0.99"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Verify error %q missing %q", err, want)
+		}
+	}
+}
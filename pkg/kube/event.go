@@ -0,0 +1,103 @@
+// Package kube posts Kubernetes Events about the node so warnings reach
+// operators through the cluster's usual tooling.
+package kube
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+const DefaultBinary = "kubectl"
+
+// Component is the event source reported to Kubernetes.
+const Component = "atomicni"
+
+// EventClient creates Events through the kubectl tool.
+type EventClient struct {
+	Kubeconfig string
+	Binary     string
+}
+
+// NewEventClient returns a client authenticating with kubeconfig.
+func NewEventClient(kubeconfig string) *EventClient {
+	return &EventClient{Kubeconfig: kubeconfig, Binary: DefaultBinary}
+}
+
+// NodeWarning records a Warning Event with reason and message against node.
+func (c *EventClient) NodeWarning(ctx context.Context, node, reason, message string) error {
+	if node == "" {
+		return fmt.Errorf("node name is required")
+	}
+	manifest, err := nodeEvent(node, reason, message, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	binary := c.Binary
+	if binary == "" {
+		binary = DefaultBinary
+	}
+	args := []string{}
+	if c.Kubeconfig != "" {
+		args = append(args, "--kubeconfig", c.Kubeconfig)
+	}
+	args = append(args, "create", "-f", "-")
+
+	cmd, audit, err := netops.Command(ctx, binary, args...)
+	if err != nil {
+		return fmt.Errorf("create event for node %q: %w", node, err)
+	}
+	cmd.Stdin = strings.NewReader(string(manifest))
+	out, err := cmd.CombinedOutput()
+	audit(err)
+	if err != nil {
+		output := strings.TrimSpace(string(out))
+		if output == "" {
+			output = err.Error()
+		}
+		return fmt.Errorf("create event for node %q: %s", node, output)
+	}
+	return nil
+}
+
+// nodeEvent renders a core/v1 Event about node. Node events live in the
+// default namespace, like the kubelet's.
+func nodeEvent(node, reason, message string, now time.Time) ([]byte, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("event name: %w", err)
+	}
+	stamp := now.Format(time.RFC3339)
+	event := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]any{
+			"name":      node + "." + hex.EncodeToString(suffix),
+			"namespace": "default",
+		},
+		"involvedObject": map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Node",
+			"name":       node,
+			"uid":        node,
+		},
+		"reason":         reason,
+		"message":        message,
+		"type":           "Warning",
+		"count":          1,
+		"firstTimestamp": stamp,
+		"lastTimestamp":  stamp,
+		"source":         map[string]any{"component": Component, "host": node},
+	}
+	content, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event: %w", err)
+	}
+	return content, nil
+}
@@ -0,0 +1,56 @@
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/annis-souames/atomicni/pkg/netops"
+)
+
+func TestNodeWarning(t *testing.T) {
+	dir := t.TempDir()
+	captured := filepath.Join(dir, "args")
+	binary := filepath.Join(dir, "kubectl")
+	script := "#!/bin/sh\necho \"$@\" > " + captured + "\ncat >> " + captured + "\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatalf("write fake kubectl: %v", err)
+	}
+
+	client := NewEventClient("/etc/kubernetes/kubelet.conf")
+	client.Binary = binary
+	defer netops.SetExecPolicy(netops.ExecPolicy{Allow: []string{binary}})()
+	if err := client.NodeWarning(context.Background(), "node-1", "IPAMPoolNearlyExhausted", "network atomic-net is 90% full"); err != nil {
+		t.Fatalf("NodeWarning() error = %v", err)
+	}
+	content, err := os.ReadFile(captured)
+	if err != nil {
+		t.Fatalf("read captured: %v", err)
+	}
+	args, manifest, _ := strings.Cut(string(content), "\n")
+	if args != "--kubeconfig /etc/kubernetes/kubelet.conf create -f -" {
+		t.Fatalf("kubectl args = %q", args)
+	}
+	var event struct {
+		Type           string `json:"type"`
+		Reason         string `json:"reason"`
+		InvolvedObject struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"involvedObject"`
+	}
+	if err := json.Unmarshal([]byte(manifest), &event); err != nil {
+		t.Fatalf("manifest %q: %v", manifest, err)
+	}
+	if event.Type != "Warning" || event.Reason != "IPAMPoolNearlyExhausted" || event.InvolvedObject.Kind != "Node" || event.InvolvedObject.Name != "node-1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	client.Binary = filepath.Join(dir, "missing")
+	if err := client.NodeWarning(context.Background(), "node-1", "r", "m"); err == nil {
+		t.Fatal("expected error from missing kubectl")
+	}
+}
@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/ipam"
+)
+
+// IPAM event kinds, one per ipam.MetricsSink method.
+const (
+	IPAMAllocate  = "allocate"
+	IPAMRelease   = "release"
+	IPAMExhausted = "exhausted"
+	IPAMLockWait  = "lockWait"
+)
+
+// IPAMEvent is one allocator observation.
+type IPAMEvent struct {
+	Network string        `json:"network"`
+	Kind    string        `json:"kind"`
+	Wait    time.Duration `json:"wait,omitempty"`
+}
+
+// IPAMStats aggregates the allocator observations of one network.
+type IPAMStats struct {
+	Allocations        uint64  `json:"allocations"`
+	Releases           uint64  `json:"releases"`
+	Exhaustions        uint64  `json:"exhaustions"`
+	LockWaits          uint64  `json:"lockWaits"`
+	LockWaitSeconds    float64 `json:"lockWaitSeconds"`
+	LockWaitMaxSeconds float64 `json:"lockWaitMaxSeconds"`
+}
+
+// add folds one event into the stats.
+func (s *IPAMStats) add(ev IPAMEvent) {
+	switch ev.Kind {
+	case IPAMAllocate:
+		s.Allocations++
+	case IPAMRelease:
+		s.Releases++
+	case IPAMExhausted:
+		s.Exhaustions++
+	case IPAMLockWait:
+		s.LockWaits++
+		s.LockWaitSeconds += ev.Wait.Seconds()
+		if ev.Wait.Seconds() > s.LockWaitMaxSeconds {
+			s.LockWaitMaxSeconds = ev.Wait.Seconds()
+		}
+	}
+}
+
+// addIPAM folds one allocator event into the snapshot.
+func (s *Snapshot) addIPAM(ev IPAMEvent) {
+	if s.IPAM == nil {
+		s.IPAM = map[string]*IPAMStats{}
+	}
+	addIPAMEvent(s.IPAM, ev)
+}
+
+// addIPAMEvent folds ev into the stats of its network, creating them on
+// first use.
+func addIPAMEvent(networks map[string]*IPAMStats, ev IPAMEvent) {
+	st, ok := networks[ev.Network]
+	if !ok {
+		st = &IPAMStats{}
+		networks[ev.Network] = st
+	}
+	st.add(ev)
+}
+
+// IPAMJournal is the ipam.MetricsSink of the one-shot plugin. The allocator
+// binds it to the data dir of each call through In, and every observation is
+// appended to that dir's metrics journal, which Load and Compact fold into
+// the snapshot atomicnid renders. A journal without a data dir drops them.
+type IPAMJournal struct {
+	DataDir string
+}
+
+// In implements ipam.DirSink.
+func (j IPAMJournal) In(dataDir string) ipam.MetricsSink {
+	return IPAMJournal{DataDir: dataDir}
+}
+
+// OnAllocate journals a successful allocation.
+func (j IPAMJournal) OnAllocate(network string, _ net.IP) {
+	j.append(IPAMEvent{Network: network, Kind: IPAMAllocate})
+}
+
+// OnRelease journals a released address.
+func (j IPAMJournal) OnRelease(network string, _ net.IP) {
+	j.append(IPAMEvent{Network: network, Kind: IPAMRelease})
+}
+
+// OnExhausted journals an allocation that found no free address.
+func (j IPAMJournal) OnExhausted(network string) {
+	j.append(IPAMEvent{Network: network, Kind: IPAMExhausted})
+}
+
+// OnLockWait journals time spent acquiring the network lock.
+func (j IPAMJournal) OnLockWait(network string, wait time.Duration) {
+	j.append(IPAMEvent{Network: network, Kind: IPAMLockWait, Wait: wait})
+}
+
+// append writes ev to the journal. Metrics must never fail an allocation, so
+// errors are dropped.
+func (j IPAMJournal) append(ev IPAMEvent) {
+	if j.DataDir == "" {
+		return
+	}
+	_ = appendJournal(j.DataDir, ipamLine{IPAM: ev})
+}
+
+// IPAMCollector is an in-memory ipam.MetricsSink rendered in Prometheus text
+// format, for processes that want their own allocator counters.
+type IPAMCollector struct {
+	mu       sync.Mutex
+	networks map[string]*IPAMStats
+}
+
+// NewIPAMCollector returns an empty collector.
+func NewIPAMCollector() *IPAMCollector {
+	return &IPAMCollector{networks: map[string]*IPAMStats{}}
+}
+
+// add folds one event in under the collector lock.
+func (c *IPAMCollector) add(ev IPAMEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	addIPAMEvent(c.networks, ev)
+}
+
+// OnAllocate counts a successful allocation.
+func (c *IPAMCollector) OnAllocate(network string, _ net.IP) {
+	c.add(IPAMEvent{Network: network, Kind: IPAMAllocate})
+}
+
+// OnRelease counts a released address.
+func (c *IPAMCollector) OnRelease(network string, _ net.IP) {
+	c.add(IPAMEvent{Network: network, Kind: IPAMRelease})
+}
+
+// OnExhausted counts an allocation that found no free address.
+func (c *IPAMCollector) OnExhausted(network string) {
+	c.add(IPAMEvent{Network: network, Kind: IPAMExhausted})
+}
+
+// OnLockWait records time spent acquiring the network lock.
+func (c *IPAMCollector) OnLockWait(network string, wait time.Duration) {
+	c.add(IPAMEvent{Network: network, Kind: IPAMLockWait, Wait: wait})
+}
+
+// WritePrometheus renders the collected IPAM counters.
+func (c *IPAMCollector) WritePrometheus(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeIPAM(w, c.networks)
+}
+
+// writeIPAM renders per-network allocator counters.
+func writeIPAM(w io.Writer, networks map[string]*IPAMStats) error {
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := &errWriter{w: w}
+	counter := func(metric, help string, value func(*IPAMStats) uint64) {
+		bw.printf("# HELP %s %s\n", metric, help)
+		bw.printf("# TYPE %s counter\n", metric)
+		for _, name := range names {
+			bw.printf("%s{network=%q} %d\n", metric, name, value(networks[name]))
+		}
+	}
+	counter("atomicni_ipam_allocations_total", "Addresses allocated.", func(s *IPAMStats) uint64 { return s.Allocations })
+	counter("atomicni_ipam_releases_total", "Addresses released.", func(s *IPAMStats) uint64 { return s.Releases })
+	counter("atomicni_ipam_exhausted_total", "Allocations that found no free address.", func(s *IPAMStats) uint64 { return s.Exhaustions })
+
+	bw.printf("# HELP atomicni_ipam_lock_wait_seconds Time spent acquiring IPAM network locks.\n")
+	bw.printf("# TYPE atomicni_ipam_lock_wait_seconds summary\n")
+	for _, name := range names {
+		st := networks[name]
+		bw.printf("atomicni_ipam_lock_wait_seconds_sum{network=%q} %g\n", name, st.LockWaitSeconds)
+		bw.printf("atomicni_ipam_lock_wait_seconds_count{network=%q} %d\n", name, st.LockWaits)
+	}
+	bw.printf("# HELP atomicni_ipam_lock_wait_max_seconds Longest observed IPAM lock wait.\n")
+	bw.printf("# TYPE atomicni_ipam_lock_wait_max_seconds gauge\n")
+	for _, name := range names {
+		bw.printf("atomicni_ipam_lock_wait_max_seconds{network=%q} %g\n", name, networks[name].LockWaitMaxSeconds)
+	}
+	return bw.err
+}
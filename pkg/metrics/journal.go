@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const journalFile = "journal.jsonl"
+
+// Deferrer is a Recorder that can queue an observation cheaply and fold it
+// into the snapshot later, keeping the write off a latency-sensitive path.
+type Deferrer interface {
+	Defer(dataDir string, obs Observation) error
+}
+
+// Defer appends obs to <dataDir>/metrics/journal.jsonl instead of rewriting
+// the snapshot. The next Observe, or Compact, folds the journal in, and Load
+// includes it meanwhile. Appenders share the lock, so they never wait on
+// each other, only on a compaction.
+func (r *FileRecorder) Defer(dataDir string, obs Observation) error {
+	return appendJournal(dataDir, obs)
+}
+
+// journalEntry is one journal line: an Observation, or an allocator event
+// when IPAM is set.
+type journalEntry struct {
+	Observation
+	IPAM *IPAMEvent `json:"ipam,omitempty"`
+}
+
+// ipamLine is how an allocator event is journaled, without the empty
+// Observation fields.
+type ipamLine struct {
+	IPAM IPAMEvent `json:"ipam"`
+}
+
+// appendJournal appends entry to the journal as one line.
+func appendJournal(dataDir string, entry any) error {
+	unlock, err := lock(dataDir, syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("marshal journal entry: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dataDir, metricsDir, journalFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open metrics journal: %w", err)
+	}
+	defer f.Close()
+	// One write per line: O_APPEND keeps concurrent lines whole.
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("append metrics journal: %w", err)
+	}
+	return nil
+}
+
+// Compact folds the journal into the snapshot. atomicnid runs it on every GC
+// pass so the journal stays short on nodes that rarely see a DEL.
+func Compact(dataDir string) error {
+	unlock, err := lock(dataDir, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return compact(dataDir, nil)
+}
+
+// compact folds the journal and obs, when set, into the snapshot and empties
+// the journal. Callers hold the exclusive metrics lock.
+func compact(dataDir string, obs *Observation) error {
+	snap, err := load(dataDir)
+	if err != nil {
+		return err
+	}
+	pending, err := readJournal(dataDir, snap)
+	if err != nil {
+		return err
+	}
+	if obs == nil && pending == 0 {
+		return nil
+	}
+	if obs != nil {
+		snap.add(*obs)
+	}
+	snap.UpdatedAt = time.Now().UTC()
+	if err := save(dataDir, snap); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dataDir, metricsDir, journalFile)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("truncate metrics journal: %w", err)
+	}
+	return nil
+}
+
+// readJournal folds journaled observations and allocator events into snap
+// and returns how many there were. A line cut short by a crash is skipped.
+func readJournal(dataDir string, snap *Snapshot) (int, error) {
+	f, err := os.Open(filepath.Join(dataDir, metricsDir, journalFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("read metrics journal: %w", err)
+	}
+	defer f.Close()
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var entry journalEntry
+		if json.Unmarshal(sc.Bytes(), &entry) != nil {
+			continue
+		}
+		if entry.IPAM != nil {
+			snap.addIPAM(*entry.IPAM)
+		} else {
+			snap.add(entry.Observation)
+		}
+		n++
+	}
+	if err := sc.Err(); err != nil {
+		return n, fmt.Errorf("read metrics journal: %w", err)
+	}
+	return n, nil
+}
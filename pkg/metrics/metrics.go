@@ -0,0 +1,208 @@
+// Package metrics persists per-network operation counters so the one-shot CNI
+// binary can contribute to numbers exported by atomicnid or read from disk.
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	metricsDir   = "metrics"
+	snapshotFile = "snapshot.json"
+	lockFile     = "snapshot.lock"
+)
+
+// Observation is one completed plugin operation.
+type Observation struct {
+	Network   string
+	Operation string
+	// Stage is the failing stage (config, bridge, veth, ipam, address, ...),
+	// empty when the operation succeeded.
+	Stage    string
+	Duration time.Duration
+	// Steps holds the time spent in each ADD setup step that ran.
+	Steps map[string]time.Duration
+}
+
+// Recorder receives operation observations.
+type Recorder interface {
+	Observe(dataDir string, obs Observation) error
+}
+
+// OpStats aggregates observations of one operation on one network.
+type OpStats struct {
+	Count           uint64            `json:"count"`
+	Failures        uint64            `json:"failures"`
+	FailuresByStage map[string]uint64 `json:"failuresByStage,omitempty"`
+	TotalSeconds    float64           `json:"totalSeconds"`
+	MaxSeconds      float64           `json:"maxSeconds"`
+}
+
+// Snapshot is the persisted metrics document: network -> operation -> stats,
+// network -> setup step -> recent durations, and network -> pool warnings.
+type Snapshot struct {
+	UpdatedAt           time.Time                        `json:"updatedAt"`
+	Networks            map[string]map[string]*OpStats   `json:"networks"`
+	Steps               map[string]map[string]*StepStats `json:"steps,omitempty"`
+	UtilizationWarnings map[string]*UtilizationWarning   `json:"utilizationWarnings,omitempty"`
+	IPAM                map[string]*IPAMStats            `json:"ipam,omitempty"`
+}
+
+// FileRecorder aggregates observations into <dataDir>/metrics/snapshot.json.
+type FileRecorder struct{}
+
+// NewFileRecorder returns a recorder persisting snapshots on local disk.
+func NewFileRecorder() *FileRecorder {
+	return &FileRecorder{}
+}
+
+// Observe folds one observation, and any journaled ones, into the on-disk
+// snapshot under a file lock.
+func (r *FileRecorder) Observe(dataDir string, obs Observation) error {
+	unlock, err := lock(dataDir, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return compact(dataDir, &obs)
+}
+
+// lock takes the metrics lock in mode, creating the metrics dir.
+func lock(dataDir string, mode int) (func(), error) {
+	if dataDir == "" {
+		return nil, errors.New("dataDir is required")
+	}
+	dir := filepath.Join(dataDir, metricsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create metrics dir: %w", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open metrics lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), mode); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("lock metrics: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// Load reads the metrics snapshot with journaled observations folded in,
+// returning an empty one when missing.
+func Load(dataDir string) (*Snapshot, error) {
+	if _, err := os.Stat(filepath.Join(dataDir, metricsDir)); errors.Is(err, os.ErrNotExist) {
+		return &Snapshot{Networks: map[string]map[string]*OpStats{}}, nil
+	}
+	// A shared lock keeps a concurrent compaction from being seen half done.
+	unlock, err := lock(dataDir, syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	snap, err := load(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readJournal(dataDir, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// load reads the persisted snapshot alone. Callers hold the metrics lock.
+func load(dataDir string) (*Snapshot, error) {
+	snap := &Snapshot{Networks: map[string]map[string]*OpStats{}}
+	content, err := os.ReadFile(filepath.Join(dataDir, metricsDir, snapshotFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return snap, nil
+		}
+		return nil, fmt.Errorf("read metrics: %w", err)
+	}
+	if len(content) == 0 {
+		return snap, nil
+	}
+	if err := json.Unmarshal(content, snap); err != nil {
+		return nil, fmt.Errorf("metrics file is corrupted: %w", err)
+	}
+	if snap.Networks == nil {
+		snap.Networks = map[string]map[string]*OpStats{}
+	}
+	return snap, nil
+}
+
+// add folds one observation into the snapshot.
+func (s *Snapshot) add(obs Observation) {
+	ops, ok := s.Networks[obs.Network]
+	if !ok {
+		ops = map[string]*OpStats{}
+		s.Networks[obs.Network] = ops
+	}
+	st, ok := ops[obs.Operation]
+	if !ok {
+		st = &OpStats{}
+		ops[obs.Operation] = st
+	}
+
+	seconds := obs.Duration.Seconds()
+	st.Count++
+	st.TotalSeconds += seconds
+	if seconds > st.MaxSeconds {
+		st.MaxSeconds = seconds
+	}
+	if obs.Stage != "" {
+		st.Failures++
+		if st.FailuresByStage == nil {
+			st.FailuresByStage = map[string]uint64{}
+		}
+		st.FailuresByStage[obs.Stage]++
+	}
+
+	if len(obs.Steps) == 0 {
+		return
+	}
+	if s.Steps == nil {
+		s.Steps = map[string]map[string]*StepStats{}
+	}
+	steps, ok := s.Steps[obs.Network]
+	if !ok {
+		steps = map[string]*StepStats{}
+		s.Steps[obs.Network] = steps
+	}
+	for step, d := range obs.Steps {
+		ss, ok := steps[step]
+		if !ok {
+			ss = &StepStats{}
+			steps[step] = ss
+		}
+		ss.add(d.Seconds())
+	}
+}
+
+// save atomically persists the snapshot using write-then-rename.
+func save(dataDir string, snap *Snapshot) error {
+	content, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal metrics: %w", err)
+	}
+	path := filepath.Join(dataDir, metricsDir, snapshotFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o644); err != nil {
+		return fmt.Errorf("write temp metrics: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace metrics: %w", err)
+	}
+	return nil
+}
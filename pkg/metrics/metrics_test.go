@@ -0,0 +1,245 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestObserveAggregatesByNetworkAndStage(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewFileRecorder()
+
+	obs := []Observation{
+		{Network: "atomic-net", Operation: "ADD", Duration: 20 * time.Millisecond},
+		{Network: "atomic-net", Operation: "ADD", Stage: "ipam", Duration: 50 * time.Millisecond},
+		{Network: "atomic-net", Operation: "DEL", Duration: 5 * time.Millisecond},
+	}
+	for _, o := range obs {
+		if err := rec.Observe(dir, o); err != nil {
+			t.Fatalf("Observe: %v", err)
+		}
+	}
+
+	snap, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	add := snap.Networks["atomic-net"]["ADD"]
+	if add == nil || add.Count != 2 || add.Failures != 1 || add.FailuresByStage["ipam"] != 1 {
+		t.Fatalf("unexpected ADD stats: %+v", add)
+	}
+	if add.MaxSeconds != 0.05 {
+		t.Fatalf("expected max 0.05s, got %v", add.MaxSeconds)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, snap); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`atomicni_operations_total{network="atomic-net",operation="ADD"} 2`,
+		`atomicni_operation_failures_total{network="atomic-net",operation="ADD",stage="ipam"} 1`,
+		`atomicni_operation_duration_seconds_count{network="atomic-net",operation="DEL"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestObserveConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewFileRecorder()
+
+	const n = 10
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rec.Observe(dir, Observation{Network: "n", Operation: "ADD"}); err != nil {
+				t.Errorf("Observe: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := snap.Networks["n"]["ADD"].Count; got != n {
+		t.Fatalf("expected %d observations, got %d", n, got)
+	}
+}
+
+func TestIPAMCollectorWritePrometheus(t *testing.T) {
+	c := NewIPAMCollector()
+	c.OnAllocate("atomic-net", nil)
+	c.OnAllocate("atomic-net", nil)
+	c.OnRelease("atomic-net", nil)
+	c.OnExhausted("atomic-net")
+	c.OnLockWait("atomic-net", 250*time.Millisecond)
+
+	var buf bytes.Buffer
+	if err := c.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`atomicni_ipam_allocations_total{network="atomic-net"} 2`,
+		`atomicni_ipam_releases_total{network="atomic-net"} 1`,
+		`atomicni_ipam_exhausted_total{network="atomic-net"} 1`,
+		`atomicni_ipam_lock_wait_seconds_count{network="atomic-net"} 1`,
+		`atomicni_ipam_lock_wait_max_seconds{network="atomic-net"} 0.25`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestIPAMJournalSurvivesCompaction(t *testing.T) {
+	dir := t.TempDir()
+	sink := IPAMJournal{}.In(dir)
+	sink.OnAllocate("atomic-net", nil)
+	sink.OnLockWait("atomic-net", 250*time.Millisecond)
+	if err := Compact(dir); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	sink.OnExhausted("atomic-net")
+
+	snap, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	st := snap.IPAM["atomic-net"]
+	if st == nil || st.Allocations != 1 || st.Exhaustions != 1 || st.LockWaits != 1 || st.LockWaitMaxSeconds != 0.25 {
+		t.Fatalf("IPAM stats = %+v", st)
+	}
+}
+
+func TestWriteConnLimitDrops(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteConnLimitDrops(&buf, map[string]uint64{"atomic-net-b-eth0": 3, "atomic-net-a-eth0": 0}); err != nil {
+		t.Fatalf("WriteConnLimitDrops: %v", err)
+	}
+	out := buf.String()
+	a := strings.Index(out, `{attachment="atomic-net-a-eth0"} 0`)
+	b := strings.Index(out, `{attachment="atomic-net-b-eth0"} 3`)
+	if a < 0 || b < a {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestWriteHostVeths(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteHostVeths(&buf, map[string]int{"atomic0": 2, "": 1}); err != nil {
+		t.Fatalf("WriteHostVeths: %v", err)
+	}
+	out := buf.String()
+	ptp := strings.Index(out, `atomicni_host_veths{bridge=""} 1`)
+	bridge := strings.Index(out, `atomicni_host_veths{bridge="atomic0"} 2`)
+	if ptp < 0 || bridge < ptp {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+}
+
+func TestStepQuantilesUseRecentSamples(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewFileRecorder()
+
+	observe := func(d time.Duration) {
+		obs := Observation{Network: "atomic-net", Operation: "ADD", Duration: d, Steps: map[string]time.Duration{"ipam": d}}
+		if err := rec.Observe(dir, obs); err != nil {
+			t.Fatalf("Observe: %v", err)
+		}
+	}
+	for i := 1; i <= 100; i++ {
+		observe(time.Duration(i) * time.Millisecond)
+	}
+	snap, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	st := snap.Steps["atomic-net"]["ipam"]
+	if got := st.Quantile(0.5); got != 0.05 {
+		t.Fatalf("p50 = %v, want 0.05", got)
+	}
+	if got := st.Quantile(0.99); got != 0.099 {
+		t.Fatalf("p99 = %v, want 0.099", got)
+	}
+
+	// A slower regime evicts the old samples once the window is full.
+	for i := 0; i < stepWindow; i++ {
+		observe(time.Second)
+	}
+	if snap, err = Load(dir); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	st = snap.Steps["atomic-net"]["ipam"]
+	if st.Count != 100+stepWindow || len(st.Samples) != stepWindow || st.Quantile(0.5) != 1 {
+		t.Fatalf("window not rolled: count %d, %d samples, p50 %v", st.Count, len(st.Samples), st.Quantile(0.5))
+	}
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, snap); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	for _, want := range []string{
+		`atomicni_step_duration_seconds{network="atomic-net",step="ipam",quantile="0.95"} 1`,
+		`atomicni_step_duration_seconds_count{network="atomic-net",step="ipam"} 356`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("missing %q in output:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestDeferJournalsUntilCompact(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewFileRecorder()
+
+	for i := 0; i < 3; i++ {
+		if err := rec.Defer(dir, Observation{Network: "atomic-net", Operation: "ADD", Duration: time.Millisecond}); err != nil {
+			t.Fatalf("Defer: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, metricsDir, snapshotFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Defer wrote the snapshot: %v", err)
+	}
+	snap, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := snap.Networks["atomic-net"]["ADD"].Count; got != 3 {
+		t.Fatalf("Load saw %d journaled ADDs, want 3", got)
+	}
+
+	// Observe folds the journal along with its own observation.
+	if err := rec.Observe(dir, Observation{Network: "atomic-net", Operation: "DEL", Duration: time.Millisecond}); err != nil {
+		t.Fatalf("Observe: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, metricsDir, journalFile)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Observe left the journal: %v", err)
+	}
+	if err := rec.Defer(dir, Observation{Network: "atomic-net", Operation: "ADD", Duration: time.Millisecond}); err != nil {
+		t.Fatalf("Defer: %v", err)
+	}
+	if err := Compact(dir); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	if snap, err = Load(dir); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if add, del := snap.Networks["atomic-net"]["ADD"].Count, snap.Networks["atomic-net"]["DEL"].Count; add != 4 || del != 1 {
+		t.Fatalf("counts after compaction: ADD %d, DEL %d", add, del)
+	}
+}
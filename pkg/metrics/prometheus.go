@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WritePrometheus renders a snapshot in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, snap *Snapshot) error {
+	type row struct {
+		network, op string
+		st          *OpStats
+	}
+	var rows []row
+	for network, ops := range snap.Networks {
+		for op, st := range ops {
+			rows = append(rows, row{network, op, st})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].network != rows[j].network {
+			return rows[i].network < rows[j].network
+		}
+		return rows[i].op < rows[j].op
+	})
+
+	bw := &errWriter{w: w}
+	bw.printf("# HELP atomicni_operations_total CNI operations handled.\n")
+	bw.printf("# TYPE atomicni_operations_total counter\n")
+	for _, r := range rows {
+		bw.printf("atomicni_operations_total{network=%q,operation=%q} %d\n", r.network, r.op, r.st.Count)
+	}
+
+	bw.printf("# HELP atomicni_operation_failures_total Failed CNI operations by stage.\n")
+	bw.printf("# TYPE atomicni_operation_failures_total counter\n")
+	for _, r := range rows {
+		stages := make([]string, 0, len(r.st.FailuresByStage))
+		for stage := range r.st.FailuresByStage {
+			stages = append(stages, stage)
+		}
+		sort.Strings(stages)
+		for _, stage := range stages {
+			bw.printf("atomicni_operation_failures_total{network=%q,operation=%q,stage=%q} %d\n", r.network, r.op, stage, r.st.FailuresByStage[stage])
+		}
+	}
+
+	bw.printf("# HELP atomicni_operation_duration_seconds Time spent in CNI operations.\n")
+	bw.printf("# TYPE atomicni_operation_duration_seconds summary\n")
+	for _, r := range rows {
+		bw.printf("atomicni_operation_duration_seconds_sum{network=%q,operation=%q} %g\n", r.network, r.op, r.st.TotalSeconds)
+		bw.printf("atomicni_operation_duration_seconds_count{network=%q,operation=%q} %d\n", r.network, r.op, r.st.Count)
+	}
+
+	bw.printf("# HELP atomicni_operation_duration_max_seconds Slowest observed CNI operation.\n")
+	bw.printf("# TYPE atomicni_operation_duration_max_seconds gauge\n")
+	for _, r := range rows {
+		bw.printf("atomicni_operation_duration_max_seconds{network=%q,operation=%q} %g\n", r.network, r.op, r.st.MaxSeconds)
+	}
+
+	if len(snap.UtilizationWarnings) > 0 {
+		networks := make([]string, 0, len(snap.UtilizationWarnings))
+		for network := range snap.UtilizationWarnings {
+			networks = append(networks, network)
+		}
+		sort.Strings(networks)
+		bw.printf("# HELP atomicni_ipam_utilization_warnings_total Times a network's pool crossed its utilization warning threshold.\n")
+		bw.printf("# TYPE atomicni_ipam_utilization_warnings_total counter\n")
+		for _, network := range networks {
+			bw.printf("atomicni_ipam_utilization_warnings_total{network=%q} %d\n", network, snap.UtilizationWarnings[network].Count)
+		}
+	}
+
+	if len(snap.IPAM) > 0 && bw.err == nil {
+		bw.err = writeIPAM(w, snap.IPAM)
+	}
+
+	steps := snap.StepRows()
+	if len(steps) == 0 {
+		return bw.err
+	}
+	bw.printf("# HELP atomicni_step_duration_seconds Time spent in ADD setup steps; quantiles cover the most recent samples.\n")
+	bw.printf("# TYPE atomicni_step_duration_seconds summary\n")
+	for _, r := range steps {
+		for _, q := range StepQuantiles {
+			bw.printf("atomicni_step_duration_seconds{network=%q,step=%q,quantile=\"%g\"} %g\n", r.Network, r.Step, q, r.Stats.Quantile(q))
+		}
+		bw.printf("atomicni_step_duration_seconds_sum{network=%q,step=%q} %g\n", r.Network, r.Step, r.Stats.TotalSeconds)
+		bw.printf("atomicni_step_duration_seconds_count{network=%q,step=%q} %d\n", r.Network, r.Step, r.Stats.Count)
+	}
+	return bw.err
+}
+
+// errWriter keeps the first write error so rendering code stays linear.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...any) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = fmt.Fprintf(e.w, format, args...)
+}
+
+// WriteConnLimitDrops renders per-attachment connection-limit drop counters,
+// keyed by attachment key.
+func WriteConnLimitDrops(w io.Writer, drops map[string]uint64) error {
+	keys := make([]string, 0, len(drops))
+	for key := range drops {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bw := &errWriter{w: w}
+	bw.printf("# HELP atomicni_connlimit_dropped_packets_total New connections dropped by per-pod rate limits.\n")
+	bw.printf("# TYPE atomicni_connlimit_dropped_packets_total counter\n")
+	for _, key := range keys {
+		bw.printf("atomicni_connlimit_dropped_packets_total{attachment=%q} %d\n", key, drops[key])
+	}
+	return bw.err
+}
+
+// WriteHostVeths appends the number of AtomicNI host veths per bridge; veths
+// without a master (ptp mode) are counted under bridge="".
+func WriteHostVeths(w io.Writer, counts map[string]int) error {
+	bridges := make([]string, 0, len(counts))
+	for bridge := range counts {
+		bridges = append(bridges, bridge)
+	}
+	sort.Strings(bridges)
+
+	bw := &errWriter{w: w}
+	bw.printf("# HELP atomicni_host_veths Host veths created by AtomicNI that currently exist.\n")
+	bw.printf("# TYPE atomicni_host_veths gauge\n")
+	for _, bridge := range bridges {
+		bw.printf("atomicni_host_veths{bridge=%q} %d\n", bridge, counts[bridge])
+	}
+	return bw.err
+}
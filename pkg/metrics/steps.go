@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+)
+
+// stepWindow is how many recent durations each setup step keeps; quantiles
+// describe this window, so a regression shows up within a few hundred ADDs.
+const stepWindow = 256
+
+// StepStats is a rolling window of the most recent durations of one ADD
+// setup step (bridge, veth, netns, ipam, address).
+type StepStats struct {
+	Count        uint64    `json:"count"`
+	TotalSeconds float64   `json:"totalSeconds"`
+	Samples      []float64 `json:"samples"`
+	// Next is the slot the next sample overwrites once Samples is full.
+	Next int `json:"next"`
+}
+
+// add records one duration, evicting the oldest once the window is full.
+func (s *StepStats) add(seconds float64) {
+	s.Count++
+	s.TotalSeconds += seconds
+	if len(s.Samples) < stepWindow {
+		s.Samples = append(s.Samples, seconds)
+		return
+	}
+	s.Next %= len(s.Samples)
+	s.Samples[s.Next] = seconds
+	s.Next++
+}
+
+// Quantile returns the nearest-rank q-quantile (0 < q <= 1) of the window in
+// seconds, 0 when it is empty.
+func (s *StepStats) Quantile(q float64) float64 {
+	if len(s.Samples) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), s.Samples...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// StepQuantiles are the quantiles reported for setup steps.
+var StepQuantiles = []float64{0.5, 0.95, 0.99}
+
+// StepRow is one network's stats for one setup step.
+type StepRow struct {
+	Network string
+	Step    string
+	Stats   *StepStats
+}
+
+// StepRows flattens the step stats sorted by network and step.
+func (s *Snapshot) StepRows() []StepRow {
+	var rows []StepRow
+	for network, steps := range s.Steps {
+		for step, st := range steps {
+			rows = append(rows, StepRow{Network: network, Step: step, Stats: st})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Network != rows[j].Network {
+			return rows[i].Network < rows[j].Network
+		}
+		return rows[i].Step < rows[j].Step
+	})
+	return rows
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"syscall"
+	"time"
+)
+
+// UtilizationWarning records the pool warnings raised for one network.
+type UtilizationWarning struct {
+	Count    uint64    `json:"count"`
+	Used     int       `json:"used"`
+	Capacity int       `json:"capacity"`
+	Last     time.Time `json:"last"`
+}
+
+// RecordUtilizationWarning counts a pool utilization warning for network and
+// keeps the usage that triggered it. Warnings are rare, so it rewrites the
+// snapshot directly instead of going through the journal.
+func RecordUtilizationWarning(dataDir, network string, used, capacity int) error {
+	unlock, err := lock(dataDir, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	snap, err := load(dataDir)
+	if err != nil {
+		return err
+	}
+	if snap.UtilizationWarnings == nil {
+		snap.UtilizationWarnings = map[string]*UtilizationWarning{}
+	}
+	w, ok := snap.UtilizationWarnings[network]
+	if !ok {
+		w = &UtilizationWarning{}
+		snap.UtilizationWarnings[network] = w
+	}
+	w.Count++
+	w.Used, w.Capacity, w.Last = used, capacity, time.Now().UTC()
+	snap.UpdatedAt = time.Now().UTC()
+	return save(dataDir, snap)
+}
@@ -0,0 +1,103 @@
+// Package netaddr converts between the net.IP/net.IPNet types used by the
+// CNI libraries and net/netip values. IPv4 addresses always come out
+// unmapped (netip) or as 4-byte slices (net), so callers no longer need
+// To4() checks.
+package netaddr
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+)
+
+// FromIP converts ip, unmapping IPv4-in-IPv6 forms. It reports false for a
+// nil or malformed ip.
+func FromIP(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// FromIPNet converts n, keeping its address bits (not just the network).
+func FromIPNet(n *net.IPNet) (netip.Prefix, bool) {
+	if n == nil {
+		return netip.Prefix{}, false
+	}
+	addr, ok := FromIP(n.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, bits := n.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, false
+	}
+	if addr.Is4() && bits == 128 {
+		ones -= 96
+	}
+	return netip.PrefixFrom(addr, ones), true
+}
+
+// ToIP converts addr; an invalid addr yields nil.
+func ToIP(addr netip.Addr) net.IP {
+	if !addr.IsValid() {
+		return nil
+	}
+	return net.IP(addr.Unmap().AsSlice())
+}
+
+// ToIPNet converts p, keeping its address bits; an invalid p yields nil.
+func ToIPNet(p netip.Prefix) *net.IPNet {
+	if !p.IsValid() {
+		return nil
+	}
+	addr := p.Addr().Unmap()
+	return &net.IPNet{IP: net.IP(addr.AsSlice()), Mask: net.CIDRMask(p.Bits(), addr.BitLen())}
+}
+
+// V4 converts ip when it is an IPv4 address in either form; it reports false
+// for nil, malformed, and IPv6 addresses.
+func V4(ip net.IP) (netip.Addr, bool) {
+	addr, ok := FromIP(ip)
+	return addr, ok && addr.Is4()
+}
+
+// ParseV4 parses s as an IPv4 address, accepting the IPv4-mapped form.
+func ParseV4(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+	return addr, addr.Is4()
+}
+
+// V4Prefix converts n when it is an IPv4 network, masked to its network
+// address.
+func V4Prefix(n *net.IPNet) (netip.Prefix, bool) {
+	p, ok := FromIPNet(n)
+	if !ok || !p.Addr().Is4() {
+		return netip.Prefix{}, false
+	}
+	return p.Masked(), true
+}
+
+// Broadcast returns the last address of the IPv4 prefix p.
+func Broadcast(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr().As4()
+	return FromUint32(binary.BigEndian.Uint32(a[:]) | ^uint32(0)>>p.Bits())
+}
+
+// Uint32 returns an IPv4 address as a big-endian integer for range math.
+func Uint32(addr netip.Addr) uint32 {
+	a := addr.As4()
+	return binary.BigEndian.Uint32(a[:])
+}
+
+// FromUint32 is the inverse of Uint32.
+func FromUint32(v uint32) netip.Addr {
+	var a [4]byte
+	binary.BigEndian.PutUint32(a[:], v)
+	return netip.AddrFrom4(a)
+}
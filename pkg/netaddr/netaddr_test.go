@@ -0,0 +1,79 @@
+package netaddr
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	// ParseIP returns the 16-byte form for IPv4.
+	addr, ok := FromIP(net.ParseIP("10.22.0.5"))
+	if !ok || !addr.Is4() || addr.String() != "10.22.0.5" {
+		t.Fatalf("FromIP() = %v, %v", addr, ok)
+	}
+	if ip := ToIP(addr); len(ip) != net.IPv4len || !ip.Equal(net.ParseIP("10.22.0.5")) {
+		t.Fatalf("ToIP() = %v (len %d)", ip, len(ip))
+	}
+
+	_, ipnet, _ := net.ParseCIDR("10.22.0.0/24")
+	ipnet.IP = net.ParseIP("10.22.0.9")
+	ipnet.Mask = net.CIDRMask(120, 128)
+	prefix, ok := FromIPNet(ipnet)
+	if !ok || prefix != netip.MustParsePrefix("10.22.0.9/24") {
+		t.Fatalf("FromIPNet() = %v, %v", prefix, ok)
+	}
+	if n := ToIPNet(prefix); n.String() != "10.22.0.9/24" || len(n.IP) != net.IPv4len {
+		t.Fatalf("ToIPNet() = %v", n)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	if _, ok := FromIP(nil); ok {
+		t.Fatalf("FromIP(nil) reported ok")
+	}
+	if _, ok := FromIPNet(nil); ok {
+		t.Fatalf("FromIPNet(nil) reported ok")
+	}
+	if ToIP(netip.Addr{}) != nil || ToIPNet(netip.Prefix{}) != nil {
+		t.Fatalf("zero values must convert to nil")
+	}
+}
+
+func TestV4(t *testing.T) {
+	if addr, ok := V4(net.ParseIP("10.22.0.5")); !ok || addr != netip.MustParseAddr("10.22.0.5") {
+		t.Fatalf("V4() = %v, %v", addr, ok)
+	}
+	if _, ok := V4(net.ParseIP("fd00::1")); ok {
+		t.Fatalf("V4() accepted an IPv6 address")
+	}
+	if addr, ok := ParseV4("::ffff:10.22.0.5"); !ok || addr != netip.MustParseAddr("10.22.0.5") {
+		t.Fatalf("ParseV4() = %v, %v", addr, ok)
+	}
+	if _, ok := ParseV4("10.22.0"); ok {
+		t.Fatalf("ParseV4() accepted a malformed address")
+	}
+	_, ipnet, _ := net.ParseCIDR("10.22.0.0/24")
+	ipnet.IP = net.ParseIP("10.22.0.9")
+	if p, ok := V4Prefix(ipnet); !ok || p != netip.MustParsePrefix("10.22.0.0/24") {
+		t.Fatalf("V4Prefix() = %v, %v", p, ok)
+	}
+}
+
+func TestBroadcastAndUint32(t *testing.T) {
+	for prefix, want := range map[string]string{
+		"10.22.0.0/24":  "10.22.0.255",
+		"10.22.0.9/30":  "10.22.0.11",
+		"10.22.0.9/32":  "10.22.0.9",
+		"0.0.0.0/0":     "255.255.255.255",
+		"172.16.0.0/12": "172.31.255.255",
+	} {
+		if got := Broadcast(netip.MustParsePrefix(prefix)); got.String() != want {
+			t.Errorf("Broadcast(%s) = %v, want %s", prefix, got, want)
+		}
+	}
+	addr := netip.MustParseAddr("10.22.1.2")
+	if v := Uint32(addr); v != 0x0a160102 || FromUint32(v) != addr {
+		t.Fatalf("Uint32() = %#x, FromUint32() = %v", v, FromUint32(v))
+	}
+}
@@ -0,0 +1,146 @@
+package netnsutil
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// HostPath is the path of the host namespace a Fake passes to Do callbacks.
+const HostPath = "/proc/self/ns/net"
+
+// Fake is an in-process Opener. Its namespaces are names only: Do runs the
+// callback on the calling goroutine without switching namespace, which is
+// all code talking to a mock NetOps needs.
+type Fake struct {
+	mu         sync.Mutex
+	namespaces map[string]*FakeNS
+	host       *FakeNS
+	open       int
+}
+
+// NewFake returns a Fake holding only the host namespace.
+func NewFake() *Fake {
+	f := &Fake{namespaces: map[string]*FakeNS{}}
+	f.host = &FakeNS{fake: f, path: HostPath}
+	f.namespaces[HostPath] = f.host
+	return f
+}
+
+// Add creates the namespace at path, as a runtime does before ADD, and
+// returns it.
+func (f *Fake) Add(path string) *FakeNS {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := &FakeNS{fake: f, path: path}
+	f.namespaces[path] = n
+	return n
+}
+
+// Remove deletes the namespace at path, as a runtime tearing down a sandbox
+// does; handles opened earlier keep working until closed.
+func (f *Fake) Remove(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.namespaces, path)
+}
+
+// GetNS returns the namespace at path.
+func (f *Fake) GetNS(path string) (ns.NetNS, error) {
+	if err := f.IsNS(path); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.open++
+	return &fakeHandle{FakeNS: f.namespaces[path]}, nil
+}
+
+// IsNS reports whether a namespace exists at path.
+func (f *Fake) IsNS(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.namespaces[path]; !ok {
+		return ns.NSPathNotExistErr{}
+	}
+	return nil
+}
+
+// Open returns the number of handles opened with GetNS and not yet closed,
+// across all namespaces, so tests can check nothing leaks.
+func (f *Fake) Open() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open
+}
+
+// FakeNS is one namespace of a Fake.
+type FakeNS struct {
+	fake    *Fake
+	path    string
+	entered int
+}
+
+// Entered returns how often code ran inside the namespace through Do.
+func (n *FakeNS) Entered() int {
+	n.fake.mu.Lock()
+	defer n.fake.mu.Unlock()
+	return n.entered
+}
+
+// Path returns the namespace's path.
+func (n *FakeNS) Path() string { return n.path }
+
+// Do runs toRun with the host namespace as its argument.
+func (n *FakeNS) Do(toRun func(ns.NetNS) error) error {
+	n.fake.mu.Lock()
+	n.entered++
+	host := n.fake.host
+	n.fake.mu.Unlock()
+	return toRun(host)
+}
+
+// Set is a no-op: a Fake never switches namespace.
+func (n *FakeNS) Set() error { return nil }
+
+// Fd returns an invalid descriptor so that real namespace calls fail.
+func (n *FakeNS) Fd() uintptr { return ^uintptr(0) }
+
+// Close is a no-op; handles from GetNS track their own.
+func (n *FakeNS) Close() error { return nil }
+
+// fakeHandle is one GetNS result; it refuses use after Close like a closed
+// file descriptor would.
+type fakeHandle struct {
+	*FakeNS
+	closed bool
+}
+
+var errClosed = errors.New("use of closed namespace handle")
+
+func (h *fakeHandle) Do(toRun func(ns.NetNS) error) error {
+	if h.closed {
+		return fmt.Errorf("%s: %w", h.path, errClosed)
+	}
+	return h.FakeNS.Do(toRun)
+}
+
+func (h *fakeHandle) Set() error {
+	if h.closed {
+		return fmt.Errorf("%s: %w", h.path, errClosed)
+	}
+	return nil
+}
+
+func (h *fakeHandle) Close() error {
+	if h.closed {
+		return fmt.Errorf("%s: %w", h.path, errClosed)
+	}
+	h.closed = true
+	h.fake.mu.Lock()
+	h.fake.open--
+	h.fake.mu.Unlock()
+	return nil
+}
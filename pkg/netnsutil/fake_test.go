@@ -0,0 +1,62 @@
+package netnsutil
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+func TestFakeTracksNamespacesAndHandles(t *testing.T) {
+	f := NewFake()
+	pod := f.Add("/var/run/netns/pod")
+
+	h, err := f.GetNS("/var/run/netns/pod")
+	if err != nil {
+		t.Fatalf("GetNS() error = %v", err)
+	}
+	if f.Open() != 1 {
+		t.Fatalf("Open() = %d, want 1", f.Open())
+	}
+	err = h.Do(func(host ns.NetNS) error {
+		if host.Path() != HostPath {
+			t.Fatalf("Do callback got %s, want the host namespace", host.Path())
+		}
+		return nil
+	})
+	if err != nil || pod.Entered() != 1 {
+		t.Fatalf("Do() error = %v, Entered() = %d", err, pod.Entered())
+	}
+
+	// A removed namespace is gone for new opens but not for open handles.
+	f.Remove("/var/run/netns/pod")
+	if _, err := f.GetNS("/var/run/netns/pod"); !errors.As(err, &ns.NSPathNotExistErr{}) {
+		t.Fatalf("GetNS() after Remove error = %v", err)
+	}
+	if err := h.Do(func(ns.NetNS) error { return nil }); err != nil {
+		t.Fatalf("Do() on open handle after Remove error = %v", err)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if f.Open() != 0 {
+		t.Fatalf("Open() = %d after Close", f.Open())
+	}
+	if err := h.Do(func(ns.NetNS) error { return nil }); err == nil {
+		t.Fatal("Do() on closed handle succeeded")
+	}
+	if err := h.Close(); err == nil {
+		t.Fatal("second Close() succeeded")
+	}
+}
+
+func TestOrDefaultsToHost(t *testing.T) {
+	if Or(nil) != Host {
+		t.Fatal("Or(nil) is not Host")
+	}
+	f := NewFake()
+	if Or(f) != f {
+		t.Fatal("Or(f) is not f")
+	}
+}
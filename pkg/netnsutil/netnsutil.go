@@ -0,0 +1,36 @@
+// Package netnsutil opens network namespaces through an interface, so code
+// that enters pod namespaces can be tested with an in-process Fake instead of
+// the namespaces of the machine running the tests.
+package netnsutil
+
+import (
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// Opener opens network namespaces by path.
+type Opener interface {
+	// GetNS opens the namespace at path, failing with ns.NSPathNotExistErr
+	// or ns.NSPathNotNSErr when it is gone.
+	GetNS(path string) (ns.NetNS, error)
+	// IsNS returns nil when path refers to a namespace, with the same errors
+	// as GetNS otherwise.
+	IsNS(path string) error
+}
+
+// Host opens the host's namespaces with the ns package.
+var Host Opener = hostOpener{}
+
+type hostOpener struct{}
+
+func (hostOpener) GetNS(path string) (ns.NetNS, error) { return ns.GetNS(path) }
+
+func (hostOpener) IsNS(path string) error { return ns.IsNSorErr(path) }
+
+// Or returns o, or Host when o is nil, so a nil Opener field means the host's
+// namespaces.
+func Or(o Opener) Opener {
+	if o == nil {
+		return Host
+	}
+	return o
+}
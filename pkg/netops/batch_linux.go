@@ -0,0 +1,139 @@
+package netops
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// HostVeth describes the host half of a pod attachment for SetupHostVeth.
+type HostVeth struct {
+	Name    string
+	Peer    string
+	MTU     int
+	Alias   string
+	AltName string
+	// Bridge, when set, enslaves the veth; Address, when set, is assigned to
+	// it instead (ptp mode).
+	Bridge  string
+	Address *net.IPNet
+	// Netns receives Peer.
+	Netns ns.NetNS
+}
+
+// SetupHostVeth creates the veth pair, labels and attaches or addresses the
+// host end, brings it up, and moves the peer into v.Netns with one
+// `ip -batch` invocation instead of one process per command. A failure
+// leaves whatever the earlier lines did; the caller deletes v.Name.
+func (n *NetlinkOps) SetupHostVeth(v HostVeth) error {
+	if v.Name == "" || v.Peer == "" {
+		return errors.New("host and peer names are required")
+	}
+	if v.MTU <= 0 {
+		v.MTU = 1500
+	}
+	mtu := strconv.Itoa(v.MTU)
+	lines := [][]string{
+		{"link", "add", v.Name, "mtu", mtu, "type", "veth", "peer", "name", v.Peer, "mtu", mtu},
+		// Quoted: the alias holds a space between the owner tag and the pod.
+		{"link", "set", "dev", v.Name, "alias", strconv.Quote(v.Alias)},
+	}
+	if v.Bridge != "" {
+		lines = append(lines, []string{"link", "set", "dev", v.Name, "master", v.Bridge})
+	}
+	lines = append(lines, []string{"link", "set", "dev", v.Name, "up"})
+	if v.Address != nil {
+		lines = append(lines, []string{"addr", "add", v.Address.String(), "dev", v.Name})
+	}
+	lines = append(lines, []string{"link", "set", "dev", v.Peer, "netns", v.Netns.Path()})
+	optional := 0
+	if v.AltName != "" {
+		// Last, so that kernels before 5.5, which lack alternative names,
+		// fail only this line; see SetLinkAlias.
+		lines = append(lines, []string{"link", "property", "add", "dev", v.Name, "altname", v.AltName})
+		optional = len(lines)
+	}
+	if line, err := runIPBatch(lines); err != nil && (line == 0 || line != optional) {
+		return fmt.Errorf("set up host veth %q: %w", v.Name, err)
+	}
+	return nil
+}
+
+// SetupContainerLink renames the peer to ifName inside target, brings it up,
+// assigns addr, and, when route is set, installs the default route, with one
+// `ip -batch` invocation. It returns the link's MAC.
+func (n *NetlinkOps) SetupContainerLink(target ns.NetNS, peer, ifName string, addr *net.IPNet, route *DefaultRoute) (string, RouteOutcome, error) {
+	lines := [][]string{
+		{"link", "set", "dev", peer, "name", ifName},
+		{"link", "set", "dev", ifName, "up"},
+		{"addr", "add", addr.String(), "dev", ifName},
+	}
+	var mac string
+	var out RouteOutcome
+	err := target.Do(func(_ ns.NetNS) error {
+		if route != nil {
+			existing, err := defaultRoutes()
+			if err != nil {
+				return err
+			}
+			cmds, outcome, err := planDefaultRoute(ifName, *route, existing)
+			if err != nil {
+				return err
+			}
+			lines, out = append(lines, cmds...), outcome
+		}
+		if _, err := runIPBatch(lines); err != nil {
+			return fmt.Errorf("set up container link %q: %w", ifName, err)
+		}
+		// The net package reads the MAC over netlink in this thread's netns,
+		// saving the exec readMAC would cost.
+		iface, err := net.InterfaceByName(ifName)
+		if err != nil {
+			return fmt.Errorf("read container link mac: %w", err)
+		}
+		mac = iface.HardwareAddr.String()
+		return nil
+	})
+	return mac, out, err
+}
+
+// batchFailedLine matches iproute2's report of the first failing batch line.
+var batchFailedLine = regexp.MustCompile(`Command failed -:(\d+)`)
+
+// runIPBatch feeds lines to one `ip -batch -` process, which stops at the
+// first failing line. On failure it also returns that line's 1-based number,
+// 0 when ip did not report it.
+func runIPBatch(lines [][]string) (int, error) {
+	var script strings.Builder
+	for _, l := range lines {
+		script.WriteString(strings.Join(l, " "))
+		script.WriteByte('\n')
+	}
+	cmd, audit, err := command("ip", "-batch", "-")
+	if err != nil {
+		return 0, err
+	}
+	cmd.Stdin = strings.NewReader(script.String())
+	out, err := cmd.CombinedOutput()
+	audit(err)
+	if err == nil {
+		return 0, nil
+	}
+	output := strings.TrimSpace(string(out))
+	if output == "" {
+		output = err.Error()
+	}
+	line := 0
+	if m := batchFailedLine.FindStringSubmatch(output); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	if line > 0 && line <= len(lines) {
+		return line, fmt.Errorf("%s (%s)", output, strings.Join(lines[line-1], " "))
+	}
+	return line, fmt.Errorf("%s (ip -batch)", output)
+}
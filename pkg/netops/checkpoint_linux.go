@@ -0,0 +1,86 @@
+package netops
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// PodLink is the state of a pod interface as a checkpoint records it.
+type PodLink struct {
+	MAC    string         `json:"mac"`
+	MTU    int            `json:"mtu"`
+	Addrs  []string       `json:"addrs"`
+	Routes []PodLinkRoute `json:"routes,omitempty"`
+}
+
+// PodLinkRoute is one IPv4 route out of a pod interface. Dst is "default" or
+// a CIDR.
+type PodLinkRoute struct {
+	Dst     string `json:"dst"`
+	Gateway string `json:"gateway,omitempty"`
+	Metric  int    `json:"metric,omitempty"`
+}
+
+// PodLinkState reads ifName's MAC, MTU, IPv4 addresses, and the IPv4 routes
+// of the main table leaving through it, inside target.
+func (n *NetlinkOps) PodLinkState(target ns.NetNS, ifName string) (PodLink, error) {
+	var link PodLink
+	err := target.Do(func(_ ns.NetNS) error {
+		out, err := runIP("-j", "-4", "addr", "show", "dev", ifName)
+		if err != nil {
+			return fmt.Errorf("read %q: %w", ifName, err)
+		}
+		var links []struct {
+			Address  string `json:"address"`
+			MTU      int    `json:"mtu"`
+			AddrInfo []struct {
+				Local     string `json:"local"`
+				PrefixLen int    `json:"prefixlen"`
+			} `json:"addr_info"`
+		}
+		if err := json.Unmarshal([]byte(out), &links); err != nil {
+			return fmt.Errorf("decode %q: %w", ifName, err)
+		}
+		if len(links) != 1 {
+			return fmt.Errorf("read %q: %w", ifName, ErrLinkNotFound)
+		}
+		link.MAC, link.MTU = links[0].Address, links[0].MTU
+		for _, a := range links[0].AddrInfo {
+			link.Addrs = append(link.Addrs, a.Local+"/"+strconv.Itoa(a.PrefixLen))
+		}
+
+		out, err = runIP("-j", "-4", "route", "show", "dev", ifName)
+		if err != nil {
+			return fmt.Errorf("read routes of %q: %w", ifName, err)
+		}
+		if out == "" {
+			return nil
+		}
+		var routes []struct {
+			Dst     string `json:"dst"`
+			Gateway string `json:"gateway"`
+			Metric  int    `json:"metric"`
+		}
+		if err := json.Unmarshal([]byte(out), &routes); err != nil {
+			return fmt.Errorf("decode routes of %q: %w", ifName, err)
+		}
+		for _, r := range routes {
+			link.Routes = append(link.Routes, PodLinkRoute{Dst: r.Dst, Gateway: r.Gateway, Metric: r.Metric})
+		}
+		return nil
+	})
+	return link, err
+}
+
+// SetLinkMACInNS sets the MAC address of a link inside target.
+func (n *NetlinkOps) SetLinkMACInNS(target ns.NetNS, name, mac string) error {
+	return target.Do(func(_ ns.NetNS) error {
+		if _, err := runIP("link", "set", "dev", name, "address", mac); err != nil {
+			return fmt.Errorf("set MAC %s on %q: %w", mac, name, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// connLimitChain drops new connections from pods exceeding their rate.
+const connLimitChain = "connlimit"
+
+const connLimitPrefix = "atomicni connlimit "
+
+var nftCounterPackets = regexp.MustCompile(`counter packets (\d+)`)
+
+// SetConnLimit drops new connections from podIP above perSecond (with burst
+// allowance), replacing any limit already installed under key.
+func (f nftFirewall) SetConnLimit(key string, podIP net.IP, perSecond, burst int) error {
+	if err := ensureNFTChain(connLimitChain, "type filter hook forward priority filter;"); err != nil {
+		return err
+	}
+	if err := f.ClearConnLimit(key); err != nil {
+		return err
+	}
+	if _, err := runNFT("add", "rule", "inet", nftTable, connLimitChain,
+		"ip", "saddr", podIP.String(), "ct", "state", "new",
+		"limit", "rate", "over", fmt.Sprintf("%d/second", perSecond), "burst", strconv.Itoa(burst), "packets",
+		"counter", "drop", "comment", strconv.Quote(connLimitPrefix+key)); err != nil {
+		return fmt.Errorf("limit connections from %s: %w", podIP, err)
+	}
+	return nil
+}
+
+// ClearConnLimit removes the limit installed under key, if any.
+func (f nftFirewall) ClearConnLimit(key string) error {
+	err := deleteNFTRules(connLimitChain, func(r nftRule) bool {
+		return r.comment == connLimitPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove connection limit %s: %w", key, err)
+	}
+	return nil
+}
+
+// ConnLimitDrops returns the packets dropped by each installed limit, keyed
+// by the key passed to SetConnLimit.
+func (f nftFirewall) ConnLimitDrops() (map[string]uint64, error) {
+	rules, err := listNFTRules(connLimitChain)
+	if err != nil {
+		return nil, err
+	}
+	drops := map[string]uint64{}
+	for _, r := range rules {
+		key, ok := strings.CutPrefix(r.comment, connLimitPrefix)
+		if !ok {
+			continue
+		}
+		var packets uint64
+		if m := nftCounterPackets.FindStringSubmatch(r.expr); m != nil {
+			packets, _ = strconv.ParseUint(m[1], 10, 64)
+		}
+		drops[key] = packets
+	}
+	return drops, nil
+}
@@ -0,0 +1,42 @@
+package netops
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// conntrackShown matches the count conntrack prints after a listing.
+var conntrackShown = regexp.MustCompile(`(\d+) flow entries have been shown`)
+
+// ConntrackZoneEntries counts the conntrack entries in zone. Hosts without
+// conntrack-tools report none.
+func (n *NetlinkOps) ConntrackZoneEntries(zone int) (int, error) {
+	if !hasTool("conntrack") {
+		return 0, nil
+	}
+	out, err := runTool("conntrack", "-L", "-w", strconv.Itoa(zone))
+	if err != nil {
+		return 0, fmt.Errorf("list conntrack zone %d: %w", zone, err)
+	}
+	m := conntrackShown.FindStringSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("list conntrack zone %d: no entry count in %q", zone, out)
+	}
+	return strconv.Atoi(m[1])
+}
+
+// FlushConntrackZone deletes every conntrack entry in zone. Hosts without
+// conntrack-tools keep the entries until they time out.
+func (n *NetlinkOps) FlushConntrackZone(zone int) error {
+	if !hasTool("conntrack") {
+		return nil
+	}
+	_, err := runTool("conntrack", "-D", "-w", strconv.Itoa(zone))
+	// conntrack exits non-zero when nothing matched.
+	if err != nil && !strings.Contains(err.Error(), "0 flow entries") {
+		return fmt.Errorf("flush conntrack zone %d: %w", zone, err)
+	}
+	return nil
+}
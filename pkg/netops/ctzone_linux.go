@@ -0,0 +1,57 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ctZoneChain and ctZoneOutChain assign conntrack zones before connection
+// tracking runs, for forwarded and host-originated traffic respectively.
+const (
+	ctZoneChain    = "ctzone"
+	ctZoneOutChain = "ctzone_out"
+)
+
+const ctZonePrefix = "atomicni ctzone "
+
+// SetCTZone installs the zone assignment for z, replacing any installed
+// under key.
+func (f nftFirewall) SetCTZone(key string, z CTZone) error {
+	if err := ensureNFTChain(ctZoneChain, "type filter hook prerouting priority raw;"); err != nil {
+		return err
+	}
+	if err := ensureNFTChain(ctZoneOutChain, "type filter hook output priority raw;"); err != nil {
+		return err
+	}
+	if err := f.ClearCTZone(key); err != nil {
+		return err
+	}
+	zone := strconv.Itoa(z.Zone)
+	comment := strconv.Quote(ctZonePrefix + key)
+	for _, rule := range [][]string{
+		{ctZoneChain, "iifname", strconv.Quote(z.HostLink), "ct", "zone", "set", zone},
+		{ctZoneChain, "iifname", "!=", strconv.Quote(z.PodLinkPrefix + "*"), "ip", "daddr", z.PodIP.String(), "ct", "zone", "set", zone},
+		{ctZoneOutChain, "ip", "daddr", z.PodIP.String(), "ct", "zone", "set", zone},
+	} {
+		args := append([]string{"add", "rule", "inet", nftTable}, rule...)
+		if _, err := runNFT(append(args, "comment", comment)...); err != nil {
+			return fmt.Errorf("assign conntrack zone %d to %s: %w", z.Zone, z.PodIP, err)
+		}
+	}
+	return nil
+}
+
+// ClearCTZone removes the zone assignment installed under key, if any.
+func (f nftFirewall) ClearCTZone(key string) error {
+	for _, chain := range []string{ctZoneChain, ctZoneOutChain} {
+		err := deleteNFTRules(chain, func(r nftRule) bool {
+			return r.comment == ctZonePrefix+key
+		})
+		if err != nil {
+			return fmt.Errorf("remove conntrack zone %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package netops
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// What to do when the pod already has a default route that is not the one
+// being installed, e.g. from a previous attachment.
+const (
+	// RouteConflictKeep leaves the existing route and installs none.
+	RouteConflictKeep = "keep"
+	// RouteConflictReplace deletes the existing routes and installs ours.
+	RouteConflictReplace = "replace"
+	// RouteConflictAddWithMetric installs ours ranked behind the existing
+	// routes, with a metric one above the highest of theirs.
+	RouteConflictAddWithMetric = "add-with-metric"
+	// RouteConflictFail fails with ErrDefaultRouteConflict.
+	RouteConflictFail = "fail"
+)
+
+// ErrDefaultRouteConflict is returned under RouteConflictFail.
+var ErrDefaultRouteConflict = errors.New("pod already has a default route")
+
+// DefaultRoute is the default route an ADD installs in the pod.
+type DefaultRoute struct {
+	Gateway net.IP
+	// Nexthops, when it holds two or more gateways, makes the route an
+	// equal-cost multipath route over them instead of one via Gateway.
+	Nexthops []net.IP
+	// Metric ranks the route against other default routes; 0 is the
+	// kernel's default and the most preferred.
+	Metric int
+	// OnConflict is a RouteConflict* policy; empty means RouteConflictKeep.
+	OnConflict string
+}
+
+// RouteOutcome reports what became of a DefaultRoute.
+type RouteOutcome struct {
+	// Installed is false when an existing default route was kept instead.
+	Installed bool
+	Metric    int
+	// Replaced lists the conflicting routes deleted under
+	// RouteConflictReplace.
+	Replaced []string
+}
+
+// firstHop is the gateway the kernel lists for the route: a multipath route
+// shows its first next hop.
+func (r DefaultRoute) firstHop() net.IP {
+	if len(r.Nexthops) > 1 {
+		return r.Nexthops[0]
+	}
+	return r.Gateway
+}
+
+// addArgs returns the ip arguments adding the route on ifName with metric.
+func (r DefaultRoute) addArgs(ifName string, metric int) []string {
+	args := []string{"route", "add", "default"}
+	if metric != 0 {
+		args = append(args, "metric", strconv.Itoa(metric))
+	}
+	if len(r.Nexthops) < 2 {
+		return append(args, "via", r.Gateway.String(), "dev", ifName)
+	}
+	for _, hop := range r.Nexthops {
+		args = append(args, "nexthop", "via", hop.String(), "dev", ifName, "weight", "1")
+	}
+	return args
+}
+
+// podRoute is an IPv4 default route found in a namespace.
+type podRoute struct {
+	Dev     string
+	Gateway net.IP
+	Metric  int
+}
+
+func (r podRoute) String() string {
+	s := "default"
+	if r.Gateway != nil {
+		s += " via " + r.Gateway.String()
+	}
+	return s + " dev " + r.Dev + " metric " + strconv.Itoa(r.Metric)
+}
+
+// defaultRoutes reads the IPv4 default routes of the main table in the
+// calling thread's namespace. It reads procfs rather than running ip, so a
+// batched ADD costs no extra process.
+func defaultRoutes() ([]podRoute, error) {
+	f, err := os.Open("/proc/thread-self/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("read routes: %w", err)
+	}
+	defer f.Close()
+	var routes []podRoute
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		metric, _ := strconv.Atoi(fields[6])
+		r := podRoute{Dev: fields[0], Metric: metric}
+		if raw, err := hex.DecodeString(fields[2]); err == nil && len(raw) == 4 {
+			if gw := binary.LittleEndian.Uint32(raw); gw != 0 {
+				r.Gateway = make(net.IP, 4)
+				binary.BigEndian.PutUint32(r.Gateway, gw)
+			}
+		}
+		routes = append(routes, r)
+	}
+	return routes, scanner.Err()
+}
+
+// planDefaultRoute returns the ip commands installing route on ifName given
+// the namespace's existing default routes. With a metric set, only routes
+// of the same metric conflict: the others are ranked against it.
+func planDefaultRoute(ifName string, route DefaultRoute, existing []podRoute) ([][]string, RouteOutcome, error) {
+	var conflicts []podRoute
+	for _, r := range existing {
+		if r.Dev == ifName && r.Gateway.Equal(route.firstHop()) {
+			// Already installed, e.g. by a retried ADD.
+			return nil, RouteOutcome{Installed: true, Metric: r.Metric}, nil
+		}
+		if route.Metric == 0 || r.Metric == route.Metric {
+			conflicts = append(conflicts, r)
+		}
+	}
+	add := route.addArgs(ifName, route.Metric)
+	if len(conflicts) == 0 {
+		return [][]string{add}, RouteOutcome{Installed: true, Metric: route.Metric}, nil
+	}
+
+	switch route.OnConflict {
+	case RouteConflictKeep, "":
+		return nil, RouteOutcome{}, nil
+	case RouteConflictFail:
+		return nil, RouteOutcome{}, fmt.Errorf("%w: %s", ErrDefaultRouteConflict, conflicts[0])
+	case RouteConflictReplace:
+		var cmds [][]string
+		out := RouteOutcome{Installed: true, Metric: route.Metric}
+		for _, r := range conflicts {
+			del := []string{"route", "del", "default"}
+			if r.Gateway != nil {
+				del = append(del, "via", r.Gateway.String())
+			}
+			cmds = append(cmds, append(del, "dev", r.Dev, "metric", strconv.Itoa(r.Metric)))
+			out.Replaced = append(out.Replaced, r.String())
+		}
+		return append(cmds, add), out, nil
+	case RouteConflictAddWithMetric:
+		metric := route.Metric
+		for _, r := range conflicts {
+			metric = max(metric, r.Metric+1)
+		}
+		return [][]string{route.addArgs(ifName, metric)}, RouteOutcome{Installed: true, Metric: metric}, nil
+	default:
+		return nil, RouteOutcome{}, fmt.Errorf("unknown default route conflict policy %q", route.OnConflict)
+	}
+}
+
+// DefaultRouteMetric returns the metric of the default route via gateway on
+// ifName inside target; ok is false when there is none.
+func (n *NetlinkOps) DefaultRouteMetric(target ns.NetNS, ifName string, gateway net.IP) (metric int, ok bool, err error) {
+	err = target.Do(func(_ ns.NetNS) error {
+		routes, err := defaultRoutes()
+		if err != nil {
+			return err
+		}
+		for _, r := range routes {
+			if r.Dev == ifName && r.Gateway.Equal(gateway) {
+				metric, ok = r.Metric, true
+				return nil
+			}
+		}
+		return nil
+	})
+	return metric, ok, err
+}
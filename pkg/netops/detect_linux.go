@@ -0,0 +1,35 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"strings"
+	"sync"
+)
+
+var (
+	detectOnce sync.Once
+	detected   Firewall
+)
+
+// detectFirewall probes the host once per process.
+func detectFirewall() Firewall {
+	detectOnce.Do(func() {
+		detected = chooseFirewall(toolOutput("iptables", "--version"), hasTool("nft"))
+	})
+	return detected
+}
+
+// chooseFirewall applies the detection rules to an `iptables --version` line.
+func chooseFirewall(iptablesVersion string, haveNFT bool) Firewall {
+	switch {
+	case strings.Contains(iptablesVersion, "legacy"):
+		return iptFirewall{}
+	case haveNFT:
+		return nftFirewall{}
+	case iptablesVersion != "":
+		return iptFirewall{}
+	default:
+		return nftFirewall{}
+	}
+}
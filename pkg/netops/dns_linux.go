@@ -0,0 +1,71 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// dnsChain DNATs pod DNS queries for a node-local address before routing.
+const dnsChain = "dns"
+
+const dnsPrefix = "atomicni dns "
+
+// SetDNSRedirect installs the redirect for d, replacing any installed under
+// key.
+func (f nftFirewall) SetDNSRedirect(key string, d DNSRedirect) error {
+	if err := ensureNFTChain(dnsChain, "type nat hook prerouting priority dstnat;"); err != nil {
+		return err
+	}
+	if err := f.ClearDNSRedirect(key); err != nil {
+		return err
+	}
+	args := []string{"add", "rule", "inet", nftTable, dnsChain,
+		"ip", "saddr", d.PodIP.String(), "ip", "daddr", d.Listen.String(),
+		"meta", "l4proto", "{ tcp, udp }", "th", "dport", "53",
+		"dnat", "ip", "to", d.Target.String(),
+		"comment", strconv.Quote(dnsPrefix + key)}
+	if _, err := runNFT(args...); err != nil {
+		return fmt.Errorf("redirect dns of %s to %s: %w", d.PodIP, d.Target, err)
+	}
+	return nil
+}
+
+// ClearDNSRedirect removes the redirect installed under key, if any.
+func (f nftFirewall) ClearDNSRedirect(key string) error {
+	err := deleteNFTRules(dnsChain, func(r nftRule) bool {
+		return r.comment == dnsPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove dns redirect %s: %w", key, err)
+	}
+	return nil
+}
+
+// SetDNSRedirect installs the redirect for d with one DNAT rule per protocol.
+func (f iptFirewall) SetDNSRedirect(key string, d DNSRedirect) error {
+	if err := f.ClearDNSRedirect(key); err != nil {
+		return err
+	}
+	for _, proto := range []string{"udp", "tcp"} {
+		err := appendIPTRule(iptDNSChain, dnsPrefix+key,
+			"-s", d.PodIP.String()+"/32", "-d", d.Listen.String()+"/32",
+			"-p", proto, "--dport", "53", "-j", "DNAT", "--to-destination", d.Target.String())
+		if err != nil {
+			return fmt.Errorf("redirect dns of %s to %s: %w", d.PodIP, d.Target, err)
+		}
+	}
+	return nil
+}
+
+// ClearDNSRedirect removes the redirect installed under key, if any.
+func (f iptFirewall) ClearDNSRedirect(key string) error {
+	err := deleteIPTRules(iptDNSChain, func(comment string) bool {
+		return comment == dnsPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove dns redirect %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package netops
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// How ProbeGateway checks a gateway.
+const (
+	GatewayProbeICMP = "icmp"
+	GatewayProbeARP  = "arp"
+)
+
+// EgressGateway policy-routes traffic from PodIP that leaves Exclude through
+// Gateway: such packets get firewall mark Table, and an `ip rule` sends marked
+// packets to routing table Table, whose default route points at Gateway.
+//
+// With Backup set the table's route may point at Backup instead: atomicnid
+// probes Gateway with Probe every ProbeInterval seconds and moves the route
+// after ProbeFailures failures or successes in a row.
+type EgressGateway struct {
+	PodIP   net.IP
+	Exclude *net.IPNet
+	Gateway net.IP
+	Table   int
+
+	Backup        net.IP `json:",omitempty"`
+	Probe         string `json:",omitempty"`
+	ProbeInterval int    `json:",omitempty"`
+	ProbeFailures int    `json:",omitempty"`
+}
+
+// SetEgressGateway installs the mark for g under key and makes sure routing
+// table g.Table and its `ip rule` send marked packets to g.Gateway. The table
+// and rule are shared by every pod using the gateway and stay in place when
+// the last mark is removed; without marks they match nothing. A route via
+// g.Backup is left alone: atomicnid failed over to it.
+func (n *NetlinkOps) SetEgressGateway(key string, g EgressGateway) error {
+	table := strconv.Itoa(g.Table)
+	via, err := n.EgressRoute(g.Table)
+	if err != nil {
+		return err
+	}
+	if g.Backup == nil || !via.Equal(g.Backup) {
+		if err := n.SetEgressRoute(g.Table, g.Gateway); err != nil {
+			return err
+		}
+	}
+	out, err := runIP("rule", "show", "fwmark", table, "lookup", table)
+	if err != nil {
+		return fmt.Errorf("list egress gateway rule: %w", err)
+	}
+	if out == "" {
+		if _, err := runIP("rule", "add", "fwmark", table, "lookup", table); err != nil && !isAlreadyExists(err) {
+			return fmt.Errorf("add egress gateway rule for table %s: %w", table, err)
+		}
+	}
+	return n.firewall().SetEgressMark(key, g)
+}
+
+// ClearEgressGateway removes the mark installed under key.
+func (n *NetlinkOps) ClearEgressGateway(key string) error {
+	return n.firewall().ClearEgressMark(key)
+}
+
+// EgressRoute returns the gateway of routing table table's default route, or
+// nil when it has none.
+func (n *NetlinkOps) EgressRoute(table int) (net.IP, error) {
+	out, err := runIP("-j", "-4", "route", "show", "default", "table", strconv.Itoa(table))
+	if err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read route table %d: %w", table, err)
+	}
+	if out == "" {
+		return nil, nil
+	}
+	var routes []struct {
+		Gateway string `json:"gateway"`
+	}
+	if err := json.Unmarshal([]byte(out), &routes); err != nil {
+		return nil, fmt.Errorf("decode route table %d: %w", table, err)
+	}
+	for _, r := range routes {
+		if addr, ok := netaddr.ParseV4(r.Gateway); ok {
+			return netaddr.ToIP(addr), nil
+		}
+	}
+	return nil, nil
+}
+
+// SetEgressRoute points routing table table's default route at gateway.
+func (n *NetlinkOps) SetEgressRoute(table int, gateway net.IP) error {
+	if _, err := runIP("route", "replace", "default", "via", gateway.String(), "table", strconv.Itoa(table)); err != nil {
+		return fmt.Errorf("route table %d via egress gateway %s: %w", table, gateway, err)
+	}
+	return nil
+}
+
+// ProbeGateway checks from the host that gateway is alive. GatewayProbeICMP
+// requires an echo reply; GatewayProbeARP only requires the gateway to answer
+// ARP, so its neighbor entry is flushed first and a stale MAC cannot pass.
+func (n *NetlinkOps) ProbeGateway(gateway net.IP, method string) error {
+	ping := []string{"-c", "1", "-W", "1", gateway.String()}
+	switch method {
+	case GatewayProbeICMP, "":
+		if _, err := runTool("ping", ping...); err != nil {
+			return fmt.Errorf("gateway %s did not answer ping: %w", gateway, err)
+		}
+		return nil
+	case GatewayProbeARP:
+		link, err := routeDevice(gateway)
+		if err != nil {
+			return err
+		}
+		_, _ = runIP("neigh", "del", gateway.String(), "dev", link)
+		// The echo may be filtered; it is only sent to trigger resolution.
+		_, _ = runTool("ping", ping...)
+		mac, err := neighborMAC(link, gateway)
+		if err != nil {
+			return err
+		}
+		if mac == "" {
+			return fmt.Errorf("gateway %s did not answer ARP on %q", gateway, link)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown gateway probe %q", method)
+	}
+}
+
+// routeDevice returns the host link the kernel routes ip through.
+func routeDevice(ip net.IP) (string, error) {
+	out, err := runIP("-j", "route", "get", ip.String())
+	if err != nil {
+		return "", fmt.Errorf("route to %s: %w", ip, err)
+	}
+	var routes []struct {
+		Dev string `json:"dev"`
+	}
+	if err := json.Unmarshal([]byte(out), &routes); err != nil {
+		return "", fmt.Errorf("decode route to %s: %w", ip, err)
+	}
+	if len(routes) == 0 || routes[0].Dev == "" {
+		return "", fmt.Errorf("no route to %s", ip)
+	}
+	return routes[0].Dev, nil
+}
@@ -0,0 +1,71 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// egressChain marks pod egress for policy routing through an egress gateway.
+const egressChain = "egress"
+
+const egressPrefix = "atomicni egress "
+
+// SetEgressMark marks g.PodIP's egress with g.Table before routing, replacing
+// any mark installed under key.
+func (f nftFirewall) SetEgressMark(key string, g EgressGateway) error {
+	if err := ensureNFTChain(egressChain, "type filter hook prerouting priority mangle;"); err != nil {
+		return err
+	}
+	if err := f.ClearEgressMark(key); err != nil {
+		return err
+	}
+	args := []string{"add", "rule", "inet", nftTable, egressChain, "ip", "saddr", g.PodIP.String()}
+	if g.Exclude != nil {
+		args = append(args, "ip", "daddr", "!=", g.Exclude.String())
+	}
+	args = append(args, "meta", "mark", "set", strconv.Itoa(g.Table), "comment", strconv.Quote(egressPrefix+key))
+	if _, err := runNFT(args...); err != nil {
+		return fmt.Errorf("mark egress of %s: %w", g.PodIP, err)
+	}
+	return nil
+}
+
+// ClearEgressMark removes the mark installed under key, if any.
+func (f nftFirewall) ClearEgressMark(key string) error {
+	err := deleteNFTRules(egressChain, func(r nftRule) bool {
+		return r.comment == egressPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove egress mark %s: %w", key, err)
+	}
+	return nil
+}
+
+// SetEgressMark marks g.PodIP's egress with the MARK target.
+func (f iptFirewall) SetEgressMark(key string, g EgressGateway) error {
+	if err := f.ClearEgressMark(key); err != nil {
+		return err
+	}
+	rule := []string{"-s", g.PodIP.String() + "/32"}
+	if g.Exclude != nil {
+		rule = append(rule, "!", "-d", g.Exclude.String())
+	}
+	rule = append(rule, "-j", "MARK", "--set-mark", strconv.Itoa(g.Table))
+	if err := appendIPTRule(iptEgressChain, egressPrefix+key, rule...); err != nil {
+		return fmt.Errorf("mark egress of %s: %w", g.PodIP, err)
+	}
+	return nil
+}
+
+// ClearEgressMark removes the mark installed under key, if any.
+func (f iptFirewall) ClearEgressMark(key string) error {
+	err := deleteIPTRules(iptEgressChain, func(comment string) bool {
+		return comment == egressPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove egress mark %s: %w", key, err)
+	}
+	return nil
+}
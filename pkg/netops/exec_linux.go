@@ -0,0 +1,85 @@
+package netops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sync"
+)
+
+// DefaultExecAllow lists every external program AtomicNI runs: the host tools
+// netops drives, the CRI and Kubernetes clients atomicnid uses, and the tcpdump
+// behind `atomicni capture`. The plugin runs privileged, so anything else is
+// refused.
+var DefaultExecAllow = []string{"ip", "bridge", "tc", "nft", "iptables", "ipset", "conntrack", "ping", "firewall-cmd", "crictl", "kubectl", "tcpdump"}
+
+// ErrExecDenied is returned when the exec policy refuses to run a program.
+var ErrExecDenied = errors.New("not in exec allow-list")
+
+// ExecPolicy decides which external programs netops may run and audits every
+// attempt, including refused ones.
+type ExecPolicy struct {
+	// Allow holds program names; nil means DefaultExecAllow.
+	Allow []string
+	// Audit, when set, is called after each command with its result.
+	Audit func(name string, args []string, err error)
+}
+
+var (
+	execMu     sync.Mutex
+	execPolicy ExecPolicy
+)
+
+// SetExecPolicy replaces the process-wide exec policy and returns a function
+// restoring the previous one.
+func SetExecPolicy(p ExecPolicy) (restore func()) {
+	execMu.Lock()
+	prev := execPolicy
+	execPolicy = p
+	execMu.Unlock()
+	return func() {
+		execMu.Lock()
+		execPolicy = prev
+		execMu.Unlock()
+	}
+}
+
+// currentExecPolicy returns the policy in effect.
+func currentExecPolicy() ExecPolicy {
+	execMu.Lock()
+	defer execMu.Unlock()
+	return execPolicy
+}
+
+func (p ExecPolicy) allows(name string) bool {
+	if p.Allow == nil {
+		return slices.Contains(DefaultExecAllow, name)
+	}
+	return slices.Contains(p.Allow, name)
+}
+
+// command builds an exec.Cmd for name, or fails when the policy refuses it.
+// The returned audit function must be called with the command's result.
+func command(name string, args ...string) (*exec.Cmd, func(error), error) {
+	return Command(context.Background(), name, args...)
+}
+
+// Command is command for programs run outside netops, such as crictl, kubectl
+// and tcpdump, so they obey the same allow-list and audit. ctx kills the
+// program when it ends.
+func Command(ctx context.Context, name string, args ...string) (*exec.Cmd, func(error), error) {
+	p := currentExecPolicy()
+	audit := func(err error) {
+		if p.Audit != nil {
+			p.Audit(name, args, err)
+		}
+	}
+	if !p.allows(name) {
+		err := fmt.Errorf("run %s: %w", name, ErrExecDenied)
+		audit(err)
+		return nil, nil, err
+	}
+	return exec.CommandContext(ctx, name, args...), audit, nil
+}
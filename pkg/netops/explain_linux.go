@@ -0,0 +1,683 @@
+package netops
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// PlanStep is one NetOps call recorded by Explainer.
+type PlanStep struct {
+	Op   string
+	Args string
+	Why  string
+	Err  error
+}
+
+// Explainer is a NetOps that records every call together with why the
+// plugin makes it. Calls are forwarded to Next; with a nil Next nothing is
+// executed and reads return zero values, so an ADD can be planned without
+// touching the host.
+type Explainer struct {
+	Next  NetOps
+	Steps []PlanStep
+}
+
+// NewExplainer returns an Explainer forwarding to next, which may be nil.
+func NewExplainer(next NetOps) *Explainer {
+	return &Explainer{Next: next}
+}
+
+func (e *Explainer) record(op, args, why string, err error) error {
+	e.Steps = append(e.Steps, PlanStep{Op: op, Args: args, Why: why, Err: err})
+	return err
+}
+
+func nsPath(target ns.NetNS) string {
+	if target == nil {
+		return "-"
+	}
+	return target.Path()
+}
+
+func (e *Explainer) EnsureBridge(name string, gateway *net.IPNet) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.EnsureBridge(name, gateway)
+	}
+	return e.record("EnsureBridge", fmt.Sprintf("%s gateway=%v", name, gateway),
+		"create the node bridge if missing, bring it up and give it the gateway address pods route through", err)
+}
+
+func (e *Explainer) CreateVethPair(hostName, peerName string, mtu int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.CreateVethPair(hostName, peerName, mtu)
+	}
+	return e.record("CreateVethPair", fmt.Sprintf("%s <-> %s mtu=%d", hostName, peerName, mtu),
+		"a veth pair is the cable between the host and the pod: one end stays on the node, the other moves into the pod", err)
+}
+
+func (e *Explainer) AttachHostVethToBridge(hostName, bridgeName string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AttachHostVethToBridge(hostName, bridgeName)
+	}
+	return e.record("AttachHostVethToBridge", fmt.Sprintf("%s master=%s", hostName, bridgeName),
+		"enslave the host end to the bridge so the pod shares an L2 segment with its gateway and peers", err)
+}
+
+func (e *Explainer) SetLinkAlias(name, alias, altName string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetLinkAlias(name, alias, altName)
+	}
+	return e.record("SetLinkAlias", fmt.Sprintf("%s alias=%q altname=%q", name, alias, altName),
+		"tag the host end with its owner so GC and operators can map the link back to the container", err)
+}
+
+func (e *Explainer) SetBridgeOptions(name string, opts BridgeOptions) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetBridgeOptions(name, opts)
+	}
+	return e.record("SetBridgeOptions", name,
+		"apply the configured FDB ageing and multicast snooping settings to the bridge", err)
+}
+
+func (e *Explainer) SetBridgePortVLANs(bridgeName, portName string, vids []int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetBridgePortVLANs(bridgeName, portName, vids)
+	}
+	return e.record("SetBridgePortVLANs", fmt.Sprintf("%s port=%s vids=%v", bridgeName, portName, vids),
+		"set the port's VLAN membership on a VLAN-aware bridge; an empty list leaves the bridge default", err)
+}
+
+func (e *Explainer) SetBridgePortIsolated(portName string, isolated bool) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetBridgePortIsolated(portName, isolated)
+	}
+	return e.record("SetBridgePortIsolated", fmt.Sprintf("%s isolated=%t", portName, isolated),
+		"isolated ports may only talk to non-isolated ones, which keeps pods on the bridge from reaching each other", err)
+}
+
+func (e *Explainer) EnsureVLANLink(parent, name string, id int, protocol string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.EnsureVLANLink(parent, name, id, protocol)
+	}
+	return e.record("EnsureVLANLink", fmt.Sprintf("%s parent=%s id=%d proto=%s", name, parent, id, protocol),
+		"create the tagged uplink so bridge traffic leaves the node on the configured VLAN", err)
+}
+
+func (e *Explainer) MoveToNamespace(linkName string, target ns.NetNS) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.MoveToNamespace(linkName, target)
+	}
+	return e.record("MoveToNamespace", fmt.Sprintf("%s netns=%s", linkName, nsPath(target)),
+		"hand the peer end to the pod's network namespace; from here on only the pod sees it", err)
+}
+
+func (e *Explainer) PrepareContainerLink(target ns.NetNS, currentName, targetName string) (string, error) {
+	mac, err := "", error(nil)
+	if e.Next != nil {
+		mac, err = e.Next.PrepareContainerLink(target, currentName, targetName)
+	}
+	return mac, e.record("PrepareContainerLink", fmt.Sprintf("%s -> %s netns=%s", currentName, targetName, nsPath(target)),
+		"rename the peer to the interface name the runtime asked for and bring it and loopback up", err)
+}
+
+func (e *Explainer) AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, route DefaultRoute) (RouteOutcome, error) {
+	out, err := RouteOutcome{Installed: true, Metric: route.Metric}, error(nil)
+	if e.Next != nil {
+		out, err = e.Next.AddAddressAndRoute(target, ifName, addr, route)
+	}
+	return out, e.record("AddAddressAndRoute", fmt.Sprintf("%s addr=%v via=%v on-conflict=%s netns=%s", ifName, addr, route.Gateway, route.OnConflict, nsPath(target)),
+		"give the pod its address and a default route through the gateway", err)
+}
+
+func (e *Explainer) DefaultRouteMetric(target ns.NetNS, ifName string, gateway net.IP) (int, bool, error) {
+	metric, ok, err := 0, false, error(nil)
+	if e.Next != nil {
+		metric, ok, err = e.Next.DefaultRouteMetric(target, ifName, gateway)
+	}
+	return metric, ok, e.record("DefaultRouteMetric", fmt.Sprintf("%s via=%v netns=%s", ifName, gateway, nsPath(target)),
+		"read the pod's default route metric to compare it with defaultRouteMetric", err)
+}
+
+func (e *Explainer) AddAddress(target ns.NetNS, ifName string, addr *net.IPNet) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddAddress(target, ifName, addr)
+	}
+	return e.record("AddAddress", fmt.Sprintf("%s addr=%v netns=%s", ifName, addr, nsPath(target)),
+		"add an address without a default route, used for secondary interfaces and extra families", err)
+}
+
+func (e *Explainer) AddRoute(target ns.NetNS, ifName string, dst *net.IPNet, gateway net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddRoute(target, ifName, dst, gateway)
+	}
+	return e.record("AddRoute", fmt.Sprintf("%v dev %s via=%v netns=%s", dst, ifName, gateway, nsPath(target)),
+		"install a configured route inside the pod", err)
+}
+
+func (e *Explainer) AddAddressOnHostLink(name string, addr *net.IPNet) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddAddressOnHostLink(name, addr)
+	}
+	return e.record("AddAddressOnHostLink", fmt.Sprintf("%s addr=%v", name, addr),
+		"in ptp mode the host end carries the gateway address since there is no bridge", err)
+}
+
+func (e *Explainer) AddStaticNeighbor(target ns.NetNS, link string, ip net.IP, mac string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddStaticNeighbor(target, link, ip, mac)
+	}
+	return e.record("AddStaticNeighbor", fmt.Sprintf("%s %v lladdr %s netns=%s", link, ip, mac, nsPath(target)),
+		"pin the gateway's MAC in the pod so the first packet does not wait on ARP", err)
+}
+
+func (e *Explainer) AddHostStaticNeighbor(link string, ip net.IP, mac string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddHostStaticNeighbor(link, ip, mac)
+	}
+	return e.record("AddHostStaticNeighbor", fmt.Sprintf("%s %v lladdr %s", link, ip, mac),
+		"pin the pod's MAC on the host so return traffic does not wait on ARP", err)
+}
+
+func (e *Explainer) VerifyGateway(target ns.NetNS, ifName string, gateway net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.VerifyGateway(target, ifName, gateway)
+	}
+	return e.record("VerifyGateway", fmt.Sprintf("%s gateway=%v netns=%s", ifName, gateway, nsPath(target)),
+		"ping the gateway from the pod and check it resolved, failing ADD before the runtime trusts a dead datapath", err)
+}
+
+func (e *Explainer) DeleteHostStaticNeighbor(link string, ip net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.DeleteHostStaticNeighbor(link, ip)
+	}
+	return e.record("DeleteHostStaticNeighbor", fmt.Sprintf("%s %v", link, ip),
+		"drop the pinned host neighbor so a later pod reusing the address is not sent to a stale MAC", err)
+}
+
+func (e *Explainer) HostStaticNeighborPresent(link string, ip net.IP) (bool, error) {
+	ok, err := false, error(nil)
+	if e.Next != nil {
+		ok, err = e.Next.HostStaticNeighborPresent(link, ip)
+	}
+	return ok, e.record("HostStaticNeighborPresent", fmt.Sprintf("%s %v", link, ip),
+		"check no pinned host neighbor outlived its pod", err)
+}
+
+func (e *Explainer) SetProxyARP(name string, enabled bool) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetProxyARP(name, enabled)
+	}
+	return e.record("SetProxyARP", fmt.Sprintf("%s enabled=%t", name, enabled),
+		"let the host answer ARP for the gateway on a routed link that has no bridge behind it", err)
+}
+
+func (e *Explainer) TuneNeighbors(link string, t NeighborTuning) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.TuneNeighbors(link, t)
+	}
+	return e.record("TuneNeighbors", link,
+		"raise the neighbor table limits so dense nodes do not drop ARP entries", err)
+}
+
+func (e *Explainer) AddHostRoute(dst *net.IPNet, linkName string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddHostRoute(dst, linkName)
+	}
+	return e.record("AddHostRoute", fmt.Sprintf("%v dev %s", dst, linkName),
+		"route the pod address to its host veth, since in ptp mode no bridge subnet covers it", err)
+}
+
+func (e *Explainer) AddProtoRoute(dst *net.IPNet, linkName string, proto int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddProtoRoute(dst, linkName, proto)
+	}
+	return e.record("AddProtoRoute", fmt.Sprintf("%v dev %s proto %d", dst, linkName, proto),
+		"announce the pod to the node's routing daemon, which redistributes routes of this protocol number", err)
+}
+
+func (e *Explainer) DeleteProtoRoute(dst *net.IPNet, proto int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.DeleteProtoRoute(dst, proto)
+	}
+	return e.record("DeleteProtoRoute", fmt.Sprintf("%v proto %d", dst, proto),
+		"withdraw the pod route; matching the protocol leaves other daemons' routes for the prefix alone", err)
+}
+
+func (e *Explainer) ProtoRoutePresent(dst *net.IPNet, proto int) (bool, error) {
+	ok, err := false, error(nil)
+	if e.Next != nil {
+		ok, err = e.Next.ProtoRoutePresent(dst, proto)
+	}
+	return ok, e.record("ProtoRoutePresent", fmt.Sprintf("%v proto %d", dst, proto),
+		"check the pod route the routing daemon redistributes is still installed", err)
+}
+
+func (e *Explainer) EnsureServiceRoute(dst *net.IPNet, via net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.EnsureServiceRoute(dst, via)
+	}
+	return e.record("EnsureServiceRoute", fmt.Sprintf("%v via %v", dst, via),
+		"send service CIDR traffic to the configured next hop instead of the default route", err)
+}
+
+func (e *Explainer) SetDSCP(hostLink string, dscp int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetDSCP(hostLink, dscp)
+	}
+	return e.record("SetDSCP", fmt.Sprintf("%s dscp=%d", hostLink, dscp),
+		"mark the pod's egress packets with its QoS class", err)
+}
+
+func (e *Explainer) ClearDSCP(hostLink string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearDSCP(hostLink)
+	}
+	return e.record("ClearDSCP", hostLink, "remove the pod's DSCP marking rule", err)
+}
+
+func (e *Explainer) GetDSCP(hostLink string) (int, bool, error) {
+	dscp, ok, err := 0, false, error(nil)
+	if e.Next != nil {
+		dscp, ok, err = e.Next.GetDSCP(hostLink)
+	}
+	return dscp, ok, e.record("GetDSCP", hostLink, "read back the installed DSCP marking", err)
+}
+
+func (e *Explainer) SetConnLimit(key string, podIP net.IP, perSecond, burst int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetConnLimit(key, podIP, perSecond, burst)
+	}
+	return e.record("SetConnLimit", fmt.Sprintf("%s %v rate=%d/s burst=%d", key, podIP, perSecond, burst),
+		"cap how fast the pod may open new connections so one pod cannot exhaust conntrack", err)
+}
+
+func (e *Explainer) ClearConnLimit(key string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearConnLimit(key)
+	}
+	return e.record("ClearConnLimit", key, "remove the pod's connection rate limit", err)
+}
+
+func (e *Explainer) ConnLimitDrops() (map[string]uint64, error) {
+	var drops map[string]uint64
+	var err error
+	if e.Next != nil {
+		drops, err = e.Next.ConnLimitDrops()
+	}
+	return drops, e.record("ConnLimitDrops", "", "read per-pod drop counters of the connection limit", err)
+}
+
+func (e *Explainer) SetPortMappings(key string, podIP net.IP, forwards []PortForward) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetPortMappings(key, podIP, forwards)
+	}
+	return e.record("SetPortMappings", fmt.Sprintf("%s %v forwards=%d", key, podIP, len(forwards)),
+		"DNAT the requested host ports to the pod", err)
+}
+
+func (e *Explainer) ClearPortMappings(key string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearPortMappings(key)
+	}
+	return e.record("ClearPortMappings", key, "remove the pod's host port DNAT rules", err)
+}
+
+func (e *Explainer) SetMasquerade(key string, m Masquerade) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetMasquerade(key, m)
+	}
+	return e.record("SetMasquerade", fmt.Sprintf("%s src=%v exclude=%v", key, m.Source, m.Exclude),
+		"source NAT traffic leaving the pod network so replies find their way back to the node", err)
+}
+
+func (e *Explainer) ClearMasquerade(key string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearMasquerade(key)
+	}
+	return e.record("ClearMasquerade", key, "remove the pod's source NAT rule", err)
+}
+
+func (e *Explainer) SetCTZone(key string, z CTZone) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetCTZone(key, z)
+	}
+	return e.record("SetCTZone", fmt.Sprintf("%s %v zone=%d", key, z.PodIP, z.Zone),
+		"keep the pod's connections in their own conntrack zone so overlapping tenants do not collide", err)
+}
+
+func (e *Explainer) ClearCTZone(key string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearCTZone(key)
+	}
+	return e.record("ClearCTZone", key, "remove the pod's conntrack zone assignment", err)
+}
+
+func (e *Explainer) FlushConntrackZone(zone int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.FlushConntrackZone(zone)
+	}
+	return e.record("FlushConntrackZone", fmt.Sprintf("zone=%d", zone),
+		"drop the zone's conntrack entries so a reused zone starts clean", err)
+}
+
+func (e *Explainer) ConntrackZoneEntries(zone int) (int, error) {
+	n, err := 0, error(nil)
+	if e.Next != nil {
+		n, err = e.Next.ConntrackZoneEntries(zone)
+	}
+	return n, e.record("ConntrackZoneEntries", fmt.Sprintf("zone=%d", zone),
+		"check the zone's conntrack entries were flushed", err)
+}
+
+func (e *Explainer) SetEgressGateway(key string, g EgressGateway) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetEgressGateway(key, g)
+	}
+	return e.record("SetEgressGateway", fmt.Sprintf("%s %v via=%v table=%d", key, g.PodIP, g.Gateway, g.Table),
+		"mark the pod's traffic so policy routing sends it out through the egress gateway", err)
+}
+
+func (e *Explainer) ClearEgressGateway(key string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearEgressGateway(key)
+	}
+	return e.record("ClearEgressGateway", key, "remove the pod's egress gateway mark", err)
+}
+
+func (e *Explainer) EgressRoute(table int) (net.IP, error) {
+	var (
+		via net.IP
+		err error
+	)
+	if e.Next != nil {
+		via, err = e.Next.EgressRoute(table)
+	}
+	return via, e.record("EgressRoute", fmt.Sprintf("table=%d", table),
+		"read which gateway the egress table routes through", err)
+}
+
+func (e *Explainer) SetEgressRoute(table int, gateway net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetEgressRoute(table, gateway)
+	}
+	return e.record("SetEgressRoute", fmt.Sprintf("table=%d via=%v", table, gateway),
+		"point the egress table's default route at another gateway", err)
+}
+
+func (e *Explainer) ProbeGateway(gateway net.IP, method string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ProbeGateway(gateway, method)
+	}
+	return e.record("ProbeGateway", fmt.Sprintf("%v probe=%s", gateway, method),
+		"check from the host that the gateway is alive", err)
+}
+
+func (e *Explainer) SetDNSRedirect(key string, d DNSRedirect) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetDNSRedirect(key, d)
+	}
+	return e.record("SetDNSRedirect", fmt.Sprintf("%s %v %v -> %v", key, d.PodIP, d.Listen, d.Target),
+		"send the pod's DNS queries to the node-local cache", err)
+}
+
+func (e *Explainer) ClearDNSRedirect(key string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.ClearDNSRedirect(key)
+	}
+	return e.record("ClearDNSRedirect", key, "remove the pod's DNS redirect", err)
+}
+
+func (e *Explainer) AddPodSetMember(network string, ip net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.AddPodSetMember(network, ip)
+	}
+	return e.record("AddPodSetMember", fmt.Sprintf("%s %v", network, ip),
+		"add the pod to the network's address set that firewall rules match on", err)
+}
+
+func (e *Explainer) RemovePodSetMember(network string, ip net.IP) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.RemovePodSetMember(network, ip)
+	}
+	return e.record("RemovePodSetMember", fmt.Sprintf("%s %v", network, ip),
+		"take the pod out of the network's address set", err)
+}
+
+func (e *Explainer) PodSetHasMember(network string, ip net.IP) (bool, error) {
+	ok, err := false, error(nil)
+	if e.Next != nil {
+		ok, err = e.Next.PodSetHasMember(network, ip)
+	}
+	return ok, e.record("PodSetHasMember", fmt.Sprintf("%s %v", network, ip),
+		"check the pod is still in the address set firewall rules match on", err)
+}
+
+func (e *Explainer) RuleDigest(kind, key string) (string, error) {
+	digest, err := "", error(nil)
+	if e.Next != nil {
+		digest, err = e.Next.RuleDigest(kind, key)
+	}
+	return digest, e.record("RuleDigest", fmt.Sprintf("%s %s", kind, key), "check that a recorded host rule is still installed as recorded", err)
+}
+
+func (e *Explainer) FirewalldRunning() bool {
+	ok := false
+	if e.Next != nil {
+		ok = e.Next.FirewalldRunning()
+	}
+	e.record("FirewalldRunning", "", "firewalld rewrites host rules on reload, so its zones must trust the pod links", nil)
+	return ok
+}
+
+func (e *Explainer) FirewalldTrust(zone, iface string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.FirewalldTrust(zone, iface)
+	}
+	return e.record("FirewalldTrust", fmt.Sprintf("%s zone=%s", iface, zone),
+		"add the link to a trusted firewalld zone so firewalld does not drop pod traffic", err)
+}
+
+func (e *Explainer) FirewalldUntrust(zone, iface string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.FirewalldUntrust(zone, iface)
+	}
+	return e.record("FirewalldUntrust", fmt.Sprintf("%s zone=%s", iface, zone), "remove the link from the firewalld zone", err)
+}
+
+func (e *Explainer) FirewalldBound(zone, iface string) bool {
+	ok := false
+	if e.Next != nil {
+		ok = e.Next.FirewalldBound(zone, iface)
+	}
+	e.record("FirewalldBound", fmt.Sprintf("%s zone=%s", iface, zone), "check the link left the firewalld zone", nil)
+	return ok
+}
+
+func (e *Explainer) ListOwnedLinks(bridge, prefix string) ([]OwnedLink, error) {
+	var links []OwnedLink
+	var err error
+	if e.Next != nil {
+		links, err = e.Next.ListOwnedLinks(bridge, prefix)
+	}
+	return links, e.record("ListOwnedLinks", fmt.Sprintf("bridge=%s prefix=%s", bridge, prefix),
+		"list host veths this plugin created so GC can find ones without an owner", err)
+}
+
+func (e *Explainer) ListHostAddresses() ([]HostAddress, error) {
+	var addrs []HostAddress
+	var err error
+	if e.Next != nil {
+		addrs, err = e.Next.ListHostAddresses()
+	}
+	return addrs, e.record("ListHostAddresses", "", "list host addresses to refuse a subnet that overlaps a host network", err)
+}
+
+func (e *Explainer) DeleteLink(name string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.DeleteLink(name)
+	}
+	return e.record("DeleteLink", name, "delete the host end; the kernel removes its peer with it", err)
+}
+
+func (e *Explainer) DeleteLinkInNS(target ns.NetNS, name string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.DeleteLinkInNS(target, name)
+	}
+	return e.record("DeleteLinkInNS", fmt.Sprintf("%s netns=%s", name, nsPath(target)),
+		"delete the pod end from inside the namespace", err)
+}
+
+func (e *Explainer) GetLinkMAC(name string) (string, error) {
+	mac, err := "", error(nil)
+	if e.Next != nil {
+		mac, err = e.Next.GetLinkMAC(name)
+	}
+	return mac, e.record("GetLinkMAC", name, "read the MAC reported in the CNI result and used for static neighbors", err)
+}
+
+func (e *Explainer) GetLinkMACInNS(target ns.NetNS, name string) (string, error) {
+	mac, err := "", error(nil)
+	if e.Next != nil {
+		mac, err = e.Next.GetLinkMACInNS(target, name)
+	}
+	return mac, e.record("GetLinkMACInNS", fmt.Sprintf("%s netns=%s", name, nsPath(target)),
+		"read the pod interface's MAC for the CNI result", err)
+}
+
+func (e *Explainer) PodLinkState(target ns.NetNS, ifName string) (PodLink, error) {
+	var (
+		link PodLink
+		err  error
+	)
+	if e.Next != nil {
+		link, err = e.Next.PodLinkState(target, ifName)
+	}
+	return link, e.record("PodLinkState", fmt.Sprintf("%s netns=%s", ifName, nsPath(target)),
+		"read the pod interface's addresses and routes for a checkpoint", err)
+}
+
+func (e *Explainer) SetLinkMAC(name, mac string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetLinkMAC(name, mac)
+	}
+	return e.record("SetLinkMAC", name+" "+mac, "pin the host veth MAC derived from the container key", err)
+}
+
+func (e *Explainer) SetLinkMACInNS(target ns.NetNS, name, mac string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetLinkMACInNS(target, name, mac)
+	}
+	return e.record("SetLinkMACInNS", fmt.Sprintf("%s %s netns=%s", name, mac, nsPath(target)),
+		"give the pod interface the MAC requested in CNI_ARGS", err)
+}
+
+func (e *Explainer) SetSysctls(target ns.NetNS, values map[string]string) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetSysctls(target, values)
+	}
+	keys := make([]string, 0, len(values))
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		keys = append(keys, key+"="+values[key])
+	}
+	return e.record("SetSysctls", fmt.Sprintf("%s netns=%s", strings.Join(keys, " "), nsPath(target)),
+		"apply the network's pod sysctls inside the pod netns", err)
+}
+
+func (e *Explainer) LinkMTU(name string) (int, int, error) {
+	mtu, maxMTU, err := 0, 0, error(nil)
+	if e.Next != nil {
+		mtu, maxMTU, err = e.Next.LinkMTU(name)
+	}
+	return mtu, maxMTU, e.record("LinkMTU", name, "read the link MTU to size the pod link below it", err)
+}
+
+func (e *Explainer) SetLinkMTU(name string, mtu int) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetLinkMTU(name, mtu)
+	}
+	return e.record("SetLinkMTU", fmt.Sprintf("%s mtu=%d", name, mtu), "apply the MTU the network is configured for", err)
+}
+
+func (e *Explainer) GetShaper(link string) (Shaper, bool, error) {
+	s, ok, err := Shaper{}, false, error(nil)
+	if e.Next != nil {
+		s, ok, err = e.Next.GetShaper(link)
+	}
+	return s, ok, e.record("GetShaper", link, "read the pod's bandwidth shaper counters", err)
+}
+
+func (e *Explainer) SetupHostVeth(v HostVeth) error {
+	var err error
+	if e.Next != nil {
+		err = e.Next.SetupHostVeth(v)
+	}
+	return e.record("SetupHostVeth", fmt.Sprintf("%s <-> %s master=%s netns=%s", v.Name, v.Peer, v.Bridge, nsPath(v.Netns)),
+		"create, label, attach and hand over the veth pair in a single ip -batch run", err)
+}
+
+func (e *Explainer) SetupContainerLink(target ns.NetNS, peer, ifName string, addr *net.IPNet, route *DefaultRoute) (string, RouteOutcome, error) {
+	mac, out, err := "", RouteOutcome{}, error(nil)
+	var via net.IP
+	if route != nil {
+		via, out = route.Gateway, RouteOutcome{Installed: true, Metric: route.Metric}
+	}
+	if e.Next != nil {
+		mac, out, err = e.Next.SetupContainerLink(target, peer, ifName, addr, route)
+	}
+	return mac, out, e.record("SetupContainerLink", fmt.Sprintf("%s -> %s addr=%v via=%v netns=%s", peer, ifName, addr, via, nsPath(target)),
+		"rename, bring up and address the pod interface in a single ip -batch run inside the namespace", err)
+}
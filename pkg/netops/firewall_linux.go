@@ -0,0 +1,244 @@
+package netops
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os/exec"
+	"regexp"
+)
+
+// Firewall backend names.
+const (
+	FirewallNFTables = "nftables"
+	FirewallIPTables = "iptables"
+	FirewallDisabled = "disabled"
+)
+
+// Firewall installs every host rule AtomicNI owns. Rules are tagged with an
+// owner key (host link or attachment key) so they can be replaced and removed.
+type Firewall interface {
+	Name() string
+	SetDSCP(hostLink string, dscp int) error
+	ClearDSCP(hostLink string) error
+	GetDSCP(hostLink string) (int, bool, error)
+	SetConnLimit(key string, podIP net.IP, perSecond, burst int) error
+	ClearConnLimit(key string) error
+	ConnLimitDrops() (map[string]uint64, error)
+	SetPortMappings(key string, podIP net.IP, forwards []PortForward) error
+	ClearPortMappings(key string) error
+	SetMasquerade(key string, m Masquerade) error
+	ClearMasquerade(key string) error
+	SetCTZone(key string, z CTZone) error
+	ClearCTZone(key string) error
+	SetEgressMark(key string, g EgressGateway) error
+	ClearEgressMark(key string) error
+	SetDNSRedirect(key string, d DNSRedirect) error
+	ClearDNSRedirect(key string) error
+	AddSetMember(set string, ip net.IP) error
+	RemoveSetMember(set string, ip net.IP) error
+	HasSetMember(set string, ip net.IP) (bool, error)
+	RuleDigest(kind, key string) (string, error)
+}
+
+// ErrFirewallDisabled is returned for host rules by builds tagged
+// atomicni_nofirewall.
+var ErrFirewallDisabled = errors.New("firewall support not compiled in (built with atomicni_nofirewall)")
+
+// PortForward forwards HostStart..HostEnd to ContainerStart onwards.
+type PortForward struct {
+	Protocol       string
+	HostIP         net.IP
+	HostStart      int
+	HostEnd        int
+	ContainerStart int
+}
+
+// Masquerade describes source NAT for one pod. A nil EgressIP masquerades to
+// the outgoing interface address; a zero port range keeps kernel defaults.
+type Masquerade struct {
+	Source   net.IP
+	Exclude  *net.IPNet
+	EgressIP net.IP
+	PortMin  int
+	PortMax  int
+}
+
+// CTZone places a pod's flows in conntrack zone Zone: packets arriving from
+// HostLink, and packets addressed to PodIP that do not arrive from another
+// pod. A pod-to-pod flow thus stays in the zone of the pod that opened it
+// instead of being reassigned to the destination's zone mid-path.
+type CTZone struct {
+	HostLink string
+	// PodLinkPrefix starts the name of every pod's host veth.
+	PodLinkPrefix string
+	PodIP         net.IP
+	Zone          int
+}
+
+// DNSRedirect sends DNS queries from PodIP to Listen on to Target, where the
+// node-local cache actually listens.
+type DNSRedirect struct {
+	PodIP  net.IP
+	Listen net.IP
+	Target net.IP
+}
+
+// Host rule kinds, used to look installed rules up by owner key.
+const (
+	RuleDSCP       = "dscp"
+	RuleConnLimit  = "connlimit"
+	RulePortMap    = "portmap"
+	RuleMasquerade = "masquerade"
+	RuleCTZone     = "ctzone"
+	RuleEgress     = "egress"
+	RuleDNS        = "dns"
+)
+
+// DetectFirewall picks the backend matching the host so AtomicNI never splits
+// rules between the legacy x_tables and nf_tables: hosts whose iptables is the
+// legacy variant get iptables rules, everything else (native nftables or
+// iptables-nft) gets a native nftables table. Without nft, iptables is used.
+// Builds tagged atomicni_nofirewall leave both backends out and get one that
+// refuses every rule.
+func DetectFirewall() Firewall {
+	return detectFirewall()
+}
+
+// firewall returns the configured backend, detecting it on first use.
+func (n *NetlinkOps) firewall() Firewall {
+	if n.Firewall != nil {
+		return n.Firewall
+	}
+	return DetectFirewall()
+}
+
+// SetDSCP marks IPv4 traffic entering the host from hostLink with dscp.
+func (n *NetlinkOps) SetDSCP(hostLink string, dscp int) error {
+	return n.firewall().SetDSCP(hostLink, dscp)
+}
+
+// ClearDSCP removes the DSCP mark installed for hostLink.
+func (n *NetlinkOps) ClearDSCP(hostLink string) error {
+	return n.firewall().ClearDSCP(hostLink)
+}
+
+// GetDSCP returns the DSCP value marked on hostLink, if any.
+func (n *NetlinkOps) GetDSCP(hostLink string) (int, bool, error) {
+	return n.firewall().GetDSCP(hostLink)
+}
+
+// SetConnLimit rate-limits new connections from podIP.
+func (n *NetlinkOps) SetConnLimit(key string, podIP net.IP, perSecond, burst int) error {
+	return n.firewall().SetConnLimit(key, podIP, perSecond, burst)
+}
+
+// ClearConnLimit removes the connection limit installed under key.
+func (n *NetlinkOps) ClearConnLimit(key string) error {
+	return n.firewall().ClearConnLimit(key)
+}
+
+// ConnLimitDrops returns dropped packet counts per connection limit key.
+func (n *NetlinkOps) ConnLimitDrops() (map[string]uint64, error) {
+	return n.firewall().ConnLimitDrops()
+}
+
+// SetPortMappings forwards host ports to podIP.
+func (n *NetlinkOps) SetPortMappings(key string, podIP net.IP, forwards []PortForward) error {
+	return n.firewall().SetPortMappings(key, podIP, forwards)
+}
+
+// ClearPortMappings removes the port mappings installed under key.
+func (n *NetlinkOps) ClearPortMappings(key string) error {
+	return n.firewall().ClearPortMappings(key)
+}
+
+// SetMasquerade installs source NAT for one pod.
+func (n *NetlinkOps) SetMasquerade(key string, m Masquerade) error {
+	return n.firewall().SetMasquerade(key, m)
+}
+
+// ClearMasquerade removes the source NAT installed under key.
+func (n *NetlinkOps) ClearMasquerade(key string) error {
+	return n.firewall().ClearMasquerade(key)
+}
+
+// SetCTZone assigns a pod's flows to a conntrack zone.
+func (n *NetlinkOps) SetCTZone(key string, z CTZone) error {
+	return n.firewall().SetCTZone(key, z)
+}
+
+// ClearCTZone removes the zone assignment installed under key.
+func (n *NetlinkOps) ClearCTZone(key string) error {
+	return n.firewall().ClearCTZone(key)
+}
+
+// SetDNSRedirect sends a pod's node-local DNS queries to the cache.
+func (n *NetlinkOps) SetDNSRedirect(key string, d DNSRedirect) error {
+	return n.firewall().SetDNSRedirect(key, d)
+}
+
+// ClearDNSRedirect removes the DNS redirect installed under key.
+func (n *NetlinkOps) ClearDNSRedirect(key string) error {
+	return n.firewall().ClearDNSRedirect(key)
+}
+
+// RuleDigest returns a digest of the installed bodies of the rules of kind
+// owned by key, or "" when none is installed.
+func (n *NetlinkOps) RuleDigest(kind, key string) (string, error) {
+	return n.firewall().RuleDigest(kind, key)
+}
+
+// maxSetName is the ipset name limit; nftables names follow it too so both
+// backends expose the same name.
+const maxSetName = 31
+
+var setNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// PodSetName returns the name of the set holding every pod IP of network:
+// "pods_" and the network name with other characters than letters, digits,
+// and underscores replaced, shortened with a hash when too long.
+func PodSetName(network string) string {
+	name := "pods_" + setNameInvalid.ReplaceAllString(network, "_")
+	if len(name) <= maxSetName {
+		return name
+	}
+	sum := sha1.Sum([]byte(network))
+	return name[:maxSetName-9] + "_" + hex.EncodeToString(sum[:])[:8]
+}
+
+// AddPodSetMember adds ip to the pod set of network, creating the set.
+func (n *NetlinkOps) AddPodSetMember(network string, ip net.IP) error {
+	return n.firewall().AddSetMember(PodSetName(network), ip)
+}
+
+// RemovePodSetMember removes ip from the pod set of network. A missing set
+// or member is not an error.
+func (n *NetlinkOps) RemovePodSetMember(network string, ip net.IP) error {
+	return n.firewall().RemoveSetMember(PodSetName(network), ip)
+}
+
+// PodSetHasMember reports whether ip is in the pod set of network. A missing
+// set has no members.
+func (n *NetlinkOps) PodSetHasMember(network string, ip net.IP) (bool, error) {
+	return n.firewall().HasSetMember(PodSetName(network), ip)
+}
+
+// toolOutput returns a tool's trimmed output, or "" when it fails.
+func toolOutput(name string, args ...string) string {
+	out, err := runTool(name, args...)
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// hasTool reports whether name is on PATH and allowed by the exec policy.
+func hasTool(name string) bool {
+	if !currentExecPolicy().allows(name) {
+		return false
+	}
+	_, err := exec.LookPath(name)
+	return err == nil
+}
@@ -0,0 +1,64 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FirewalldRunning reports whether firewalld is active on the host. A missing
+// firewall-cmd means it is not.
+func (n *NetlinkOps) FirewalldRunning() bool {
+	out, err := runTool("firewall-cmd", "--state")
+	return err == nil && out == "running"
+}
+
+// FirewalldTrust binds iface to zone in both the runtime and permanent
+// configuration, so the binding survives `firewall-cmd --reload`. firewall-cmd
+// talks to firewalld over D-Bus, keeping firewalld the owner of its rules.
+func (n *NetlinkOps) FirewalldTrust(zone, iface string) error {
+	if _, err := runTool("firewall-cmd", "--zone="+zone, "--query-interface="+iface); err == nil {
+		return nil
+	}
+	if _, err := runTool("firewall-cmd", "--permanent", "--zone="+zone, "--change-interface="+iface); err != nil {
+		return fmt.Errorf("add %q to firewalld zone %s (permanent): %w", iface, zone, err)
+	}
+	if _, err := runTool("firewall-cmd", "--zone="+zone, "--change-interface="+iface); err != nil {
+		return fmt.Errorf("add %q to firewalld zone %s: %w", iface, zone, err)
+	}
+	return nil
+}
+
+// FirewalldBound reports whether iface is in zone in either the runtime or
+// the permanent configuration.
+func (n *NetlinkOps) FirewalldBound(zone, iface string) bool {
+	if _, err := runTool("firewall-cmd", "--zone="+zone, "--query-interface="+iface); err == nil {
+		return true
+	}
+	_, err := runTool("firewall-cmd", "--permanent", "--zone="+zone, "--query-interface="+iface)
+	return err == nil
+}
+
+// FirewalldUntrust removes iface from zone in both configurations; an unbound
+// interface is not an error. The permanent binding is removed even when the
+// runtime one is already gone, e.g. after a reload or a firewalld restart
+// that dropped runtime state, so it cannot come back with the next reload.
+func (n *NetlinkOps) FirewalldUntrust(zone, iface string) error {
+	if _, err := runTool("firewall-cmd", "--permanent", "--zone="+zone, "--remove-interface="+iface); err != nil && !isFirewalldUnbound(err) {
+		return fmt.Errorf("remove %q from firewalld zone %s (permanent): %w", iface, zone, err)
+	}
+	if _, err := runTool("firewall-cmd", "--zone="+zone, "--query-interface="+iface); err != nil {
+		return nil
+	}
+	if _, err := runTool("firewall-cmd", "--zone="+zone, "--remove-interface="+iface); err != nil && !isFirewalldUnbound(err) {
+		return fmt.Errorf("remove %q from firewalld zone %s: %w", iface, zone, err)
+	}
+	return nil
+}
+
+// isFirewalldUnbound reports whether firewall-cmd failed because iface is not
+// in the zone.
+func isFirewalldUnbound(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "NOT_ENABLED") || strings.Contains(err.Error(), "UNKNOWN_INTERFACE"))
+}
@@ -0,0 +1,426 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// iptFirewall programs AtomicNI rules into dedicated iptables chains. It is
+// used on hosts still running legacy iptables, where nftables rules would end
+// up in a second, separately evaluated rule set.
+type iptFirewall struct{}
+
+// iptChain is an AtomicNI chain and the built-in chain that jumps to it.
+type iptChain struct {
+	table, name, parent string
+}
+
+var (
+	iptDSCPChain       = iptChain{"mangle", "ATOMICNI-DSCP", "PREROUTING"}
+	iptConnLimitChain  = iptChain{"filter", "ATOMICNI-CONNLIMIT", "FORWARD"}
+	iptPortMapChain    = iptChain{"nat", "ATOMICNI-PORTMAP", "PREROUTING"}
+	iptPortMapOutChain = iptChain{"nat", "ATOMICNI-PORTMAP-OUT", "OUTPUT"}
+	iptMasqChain       = iptChain{"nat", "ATOMICNI-MASQ", "POSTROUTING"}
+	iptCTZoneChain     = iptChain{"raw", "ATOMICNI-CTZONE", "PREROUTING"}
+	iptCTZoneOutChain  = iptChain{"raw", "ATOMICNI-CTZONE-OUT", "OUTPUT"}
+	iptEgressChain     = iptChain{"mangle", "ATOMICNI-EGRESS", "PREROUTING"}
+	iptDNSChain        = iptChain{"nat", "ATOMICNI-DNS", "PREROUTING"}
+)
+
+var iptComment = regexp.MustCompile(`/\* (.*) \*/`)
+
+// Name implements Firewall.
+func (iptFirewall) Name() string { return FirewallIPTables }
+
+// SetDSCP marks IPv4 traffic entering the host from hostLink with dscp.
+func (f iptFirewall) SetDSCP(hostLink string, dscp int) error {
+	if err := f.ClearDSCP(hostLink); err != nil {
+		return err
+	}
+	err := appendIPTRule(iptDSCPChain, dscpComment(hostLink, dscp),
+		"-i", hostLink, "-j", "DSCP", "--set-dscp", strconv.Itoa(dscp))
+	if err != nil {
+		return fmt.Errorf("mark dscp %d on %q: %w", dscp, hostLink, err)
+	}
+	return nil
+}
+
+// ClearDSCP removes the mark installed for hostLink.
+func (f iptFirewall) ClearDSCP(hostLink string) error {
+	err := deleteIPTRules(iptDSCPChain, func(comment string) bool {
+		_, ok := parseDSCPComment(comment, hostLink)
+		return ok
+	})
+	if err != nil {
+		return fmt.Errorf("remove dscp mark on %q: %w", hostLink, err)
+	}
+	return nil
+}
+
+// GetDSCP returns the DSCP value marked on hostLink, if any.
+func (f iptFirewall) GetDSCP(hostLink string) (int, bool, error) {
+	rules, err := listIPTRules(iptDSCPChain)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, r := range rules {
+		if dscp, ok := parseDSCPComment(r.comment, hostLink); ok {
+			return dscp, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// SetConnLimit drops new connections from podIP above perSecond.
+func (f iptFirewall) SetConnLimit(key string, podIP net.IP, perSecond, burst int) error {
+	if err := f.ClearConnLimit(key); err != nil {
+		return err
+	}
+	// hashlimit names are capped at 15 characters; derive a stable short one.
+	sum := sha1.Sum([]byte(key))
+	err := appendIPTRule(iptConnLimitChain, connLimitPrefix+key,
+		"-s", podIP.String()+"/32", "-m", "conntrack", "--ctstate", "NEW",
+		"-m", "hashlimit", "--hashlimit-above", fmt.Sprintf("%d/sec", perSecond),
+		"--hashlimit-burst", strconv.Itoa(burst), "--hashlimit-mode", "srcip",
+		"--hashlimit-name", "an"+hex.EncodeToString(sum[:])[:12], "-j", "DROP")
+	if err != nil {
+		return fmt.Errorf("limit connections from %s: %w", podIP, err)
+	}
+	return nil
+}
+
+// ClearConnLimit removes the limit installed under key, if any.
+func (f iptFirewall) ClearConnLimit(key string) error {
+	err := deleteIPTRules(iptConnLimitChain, func(comment string) bool {
+		return comment == connLimitPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove connection limit %s: %w", key, err)
+	}
+	return nil
+}
+
+// ConnLimitDrops returns the packets dropped by each installed limit.
+func (f iptFirewall) ConnLimitDrops() (map[string]uint64, error) {
+	rules, err := listIPTRules(iptConnLimitChain)
+	if err != nil {
+		return nil, err
+	}
+	drops := map[string]uint64{}
+	for _, r := range rules {
+		if key, ok := strings.CutPrefix(r.comment, connLimitPrefix); ok {
+			drops[key] = r.packets
+		}
+	}
+	return drops, nil
+}
+
+// SetPortMappings DNATs each forward to podIP in both portmap chains. iptables
+// has no per-port map, so ranges with a host-to-container offset expand to one
+// rule per port.
+func (f iptFirewall) SetPortMappings(key string, podIP net.IP, forwards []PortForward) error {
+	if err := f.ClearPortMappings(key); err != nil {
+		return err
+	}
+	for _, fw := range forwards {
+		var match []string
+		if fw.HostIP != nil {
+			match = append(match, "-d", fw.HostIP.String()+"/32")
+		} else {
+			match = append(match, "-m", "addrtype", "--dst-type", "LOCAL")
+		}
+		match = append(match, "-p", fw.Protocol)
+
+		offset := fw.ContainerStart - fw.HostStart
+		for _, chain := range []iptChain{iptPortMapChain, iptPortMapOutChain} {
+			if offset == 0 {
+				rule := append(append([]string{}, match...), "--dport", fmt.Sprintf("%d:%d", fw.HostStart, fw.HostEnd), "-j", "DNAT", "--to-destination", podIP.String())
+				if err := appendIPTRule(chain, portMapPrefix+key, rule...); err != nil {
+					return fmt.Errorf("map %s port %s: %w", fw.Protocol, portSpan(fw.HostStart, fw.HostEnd), err)
+				}
+				continue
+			}
+			for port := fw.HostStart; port <= fw.HostEnd; port++ {
+				rule := append(append([]string{}, match...), "--dport", strconv.Itoa(port), "-j", "DNAT", "--to-destination", fmt.Sprintf("%s:%d", podIP, port+offset))
+				if err := appendIPTRule(chain, portMapPrefix+key, rule...); err != nil {
+					return fmt.Errorf("map %s port %d: %w", fw.Protocol, port, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ClearPortMappings removes every rule installed under key.
+func (f iptFirewall) ClearPortMappings(key string) error {
+	for _, c := range []iptChain{iptPortMapChain, iptPortMapOutChain} {
+		err := deleteIPTRules(c, func(comment string) bool {
+			return comment == portMapPrefix+key
+		})
+		if err != nil {
+			return fmt.Errorf("remove port mappings %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// SetMasquerade installs source NAT for m.Source. iptables only accepts a
+// port range with a port-carrying protocol, so a range yields TCP and UDP
+// rules followed by a catch-all without it.
+func (f iptFirewall) SetMasquerade(key string, m Masquerade) error {
+	if err := f.ClearMasquerade(key); err != nil {
+		return err
+	}
+	base := []string{"-s", m.Source.String() + "/32"}
+	if m.Exclude != nil {
+		base = append(base, "!", "-d", m.Exclude.String())
+	}
+
+	var rules [][]string
+	if m.PortMin > 0 {
+		for _, proto := range []string{"tcp", "udp"} {
+			rule := append(append([]string{}, base...), "-p", proto)
+			rules = append(rules, append(rule, iptMasqTarget(m, true)...))
+		}
+	}
+	rules = append(rules, append(append([]string{}, base...), iptMasqTarget(m, false)...))
+
+	for _, rule := range rules {
+		if err := appendIPTRule(iptMasqChain, masqPrefix+key, rule...); err != nil {
+			return fmt.Errorf("masquerade %s: %w", m.Source, err)
+		}
+	}
+	return nil
+}
+
+// iptMasqTarget renders the MASQUERADE or SNAT target.
+func iptMasqTarget(m Masquerade, withPorts bool) []string {
+	if m.EgressIP != nil {
+		to := m.EgressIP.String()
+		if withPorts {
+			to += fmt.Sprintf(":%d-%d", m.PortMin, m.PortMax)
+		}
+		return []string{"-j", "SNAT", "--to-source", to}
+	}
+	if withPorts {
+		return []string{"-j", "MASQUERADE", "--to-ports", fmt.Sprintf("%d-%d", m.PortMin, m.PortMax)}
+	}
+	return []string{"-j", "MASQUERADE"}
+}
+
+// ClearMasquerade removes the rules installed under key, if any.
+func (f iptFirewall) ClearMasquerade(key string) error {
+	err := deleteIPTRules(iptMasqChain, func(comment string) bool {
+		return comment == masqPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove masquerade %s: %w", key, err)
+	}
+	return nil
+}
+
+// SetCTZone installs the zone assignment for z with the CT target.
+func (f iptFirewall) SetCTZone(key string, z CTZone) error {
+	if err := f.ClearCTZone(key); err != nil {
+		return err
+	}
+	zone := strconv.Itoa(z.Zone)
+	dst := z.PodIP.String() + "/32"
+	for _, r := range []struct {
+		chain iptChain
+		rule  []string
+	}{
+		{iptCTZoneChain, []string{"-i", z.HostLink, "-j", "CT", "--zone", zone}},
+		{iptCTZoneChain, []string{"!", "-i", z.PodLinkPrefix + "+", "-d", dst, "-j", "CT", "--zone", zone}},
+		{iptCTZoneOutChain, []string{"-d", dst, "-j", "CT", "--zone", zone}},
+	} {
+		if err := appendIPTRule(r.chain, ctZonePrefix+key, r.rule...); err != nil {
+			return fmt.Errorf("assign conntrack zone %d to %s: %w", z.Zone, z.PodIP, err)
+		}
+	}
+	return nil
+}
+
+// ClearCTZone removes the zone assignment installed under key, if any.
+func (f iptFirewall) ClearCTZone(key string) error {
+	for _, c := range []iptChain{iptCTZoneChain, iptCTZoneOutChain} {
+		err := deleteIPTRules(c, func(comment string) bool {
+			return comment == ctZonePrefix+key
+		})
+		if err != nil {
+			return fmt.Errorf("remove conntrack zone %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// iptRule is one listed rule of an AtomicNI chain.
+type iptRule struct {
+	packets uint64
+	comment string
+}
+
+// iptSpec is one rule of an AtomicNI chain as printed by iptables -S: its
+// comment and the arguments following "-A <chain>".
+type iptSpec struct {
+	comment string
+	args    []string
+}
+
+// appendIPTRule appends a commented rule, creating the chain and its jump
+// from the parent chain on first use.
+func appendIPTRule(c iptChain, comment string, rule ...string) error {
+	if err := ensureIPTChain(c); err != nil {
+		return err
+	}
+	args := append([]string{"-A", c.name}, rule...)
+	args = append(args, "-m", "comment", "--comment", comment)
+	_, err := runIPT(c.table, args...)
+	return err
+}
+
+// ensureIPTChain creates c and the jump to it if either is missing.
+func ensureIPTChain(c iptChain) error {
+	if _, err := runIPT(c.table, "-N", c.name); err != nil && !strings.Contains(err.Error(), "already exists") {
+		return fmt.Errorf("create chain %s: %w", c.name, err)
+	}
+	if _, err := runIPT(c.table, "-C", c.parent, "-j", c.name); err == nil {
+		return nil
+	}
+	if _, err := runIPT(c.table, "-I", c.parent, "-j", c.name); err != nil {
+		return fmt.Errorf("jump to chain %s: %w", c.name, err)
+	}
+	return nil
+}
+
+// listIPTRules lists the rules of c; a missing chain yields no rules.
+func listIPTRules(c iptChain) ([]iptRule, error) {
+	out, err := runIPT(c.table, "-L", c.name, "-n", "-v", "-x")
+	if err != nil {
+		if isIPTMissing(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list chain %s: %w", c.name, err)
+	}
+	var rules []iptRule
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		packets, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		r := iptRule{packets: packets}
+		if m := iptComment.FindStringSubmatch(line); m != nil {
+			r.comment = m[1]
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// listIPTSpecs lists the rules of c in iptables -S form; a missing chain
+// yields no rules.
+func listIPTSpecs(c iptChain) ([]iptSpec, error) {
+	out, err := runIPT(c.table, "-S", c.name)
+	if err != nil {
+		if isIPTMissing(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list chain %s: %w", c.name, err)
+	}
+	var specs []iptSpec
+	for _, line := range strings.Split(out, "\n") {
+		fields := splitIPTSpec(line)
+		if len(fields) < 2 || fields[0] != "-A" {
+			continue
+		}
+		s := iptSpec{args: fields[2:]}
+		for i, f := range s.args {
+			if f == "--comment" && i+1 < len(s.args) {
+				s.comment = s.args[i+1]
+			}
+		}
+		specs = append(specs, s)
+	}
+	return specs, nil
+}
+
+// splitIPTSpec splits one line of iptables -S output into arguments,
+// undoing the double quotes and backslash escapes iptables puts around
+// values such as comments.
+func splitIPTSpec(line string) []string {
+	var (
+		fields  []string
+		cur     strings.Builder
+		inField bool
+		quoted  bool
+	)
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quoted && ch == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+		case ch == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (ch == ' ' || ch == '\t'):
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteByte(ch)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// deleteIPTRules removes every rule of c whose comment matches. Rules are
+// deleted by their full spec rather than by rule number, so a rule another
+// ADD or DEL inserts or removes between the listing and the delete cannot
+// shift the wrong rule into the deleted slot.
+func deleteIPTRules(c iptChain, match func(comment string) bool) error {
+	specs, err := listIPTSpecs(c)
+	if err != nil {
+		return err
+	}
+	for _, s := range specs {
+		if !match(s.comment) {
+			continue
+		}
+		args := append([]string{"-D", c.name}, s.args...)
+		if _, err := runIPT(c.table, args...); err != nil && !isIPTMissing(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runIPT executes iptables against table, waiting for the xtables lock.
+func runIPT(table string, args ...string) (string, error) {
+	return runTool("iptables", append([]string{"-w", "-t", table}, args...)...)
+}
+
+// isIPTMissing reports whether iptables failed because the chain or rule
+// does not exist.
+func isIPTMissing(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "No chain/target/match") ||
+		strings.Contains(err.Error(), "does not exist") ||
+		strings.Contains(err.Error(), "does a matching rule exist"))
+}
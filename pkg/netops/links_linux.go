@@ -0,0 +1,132 @@
+package netops
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+)
+
+// OwnedLink is a host veth created by AtomicNI.
+type OwnedLink struct {
+	Name   string
+	Alias  string
+	Master string
+	MAC    string
+}
+
+// ListOwnedLinks returns host veths whose name starts with prefix, limited to
+// ports of bridge when bridge is not empty.
+func (n *NetlinkOps) ListOwnedLinks(bridge, prefix string) ([]OwnedLink, error) {
+	out, err := runIP("-j", "-d", "link", "show", "type", "veth")
+	if err != nil {
+		return nil, fmt.Errorf("list veths: %w", err)
+	}
+	if out == "" {
+		return nil, nil
+	}
+	var links []struct {
+		IfName  string `json:"ifname"`
+		IfAlias string `json:"ifalias"`
+		Master  string `json:"master"`
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal([]byte(out), &links); err != nil {
+		return nil, fmt.Errorf("decode veth list: %w", err)
+	}
+
+	var owned []OwnedLink
+	for _, l := range links {
+		if !strings.HasPrefix(l.IfName, prefix) || (bridge != "" && l.Master != bridge) {
+			continue
+		}
+		owned = append(owned, OwnedLink{Name: l.IfName, Alias: l.IfAlias, Master: l.Master, MAC: l.Address})
+	}
+	return owned, nil
+}
+
+// HostAddress is an IPv4 address configured on a host link.
+type HostAddress struct {
+	Link string
+	Addr *net.IPNet
+}
+
+// ListHostAddresses returns the IPv4 addresses of every host link.
+func (n *NetlinkOps) ListHostAddresses() ([]HostAddress, error) {
+	out, err := runIP("-j", "-4", "addr", "show")
+	if err != nil {
+		return nil, fmt.Errorf("list host addresses: %w", err)
+	}
+	if out == "" {
+		return nil, nil
+	}
+	var links []struct {
+		IfName   string `json:"ifname"`
+		AddrInfo []struct {
+			Local     string `json:"local"`
+			PrefixLen int    `json:"prefixlen"`
+		} `json:"addr_info"`
+	}
+	if err := json.Unmarshal([]byte(out), &links); err != nil {
+		return nil, fmt.Errorf("decode host addresses: %w", err)
+	}
+	var addrs []HostAddress
+	for _, l := range links {
+		for _, a := range l.AddrInfo {
+			addr, ok := netaddr.ParseV4(a.Local)
+			if !ok {
+				continue
+			}
+			addrs = append(addrs, HostAddress{Link: l.IfName, Addr: netaddr.ToIPNet(netip.PrefixFrom(addr, a.PrefixLen))})
+		}
+	}
+	return addrs, nil
+}
+
+// LinkCounters are a link's traffic counters as seen by the host.
+type LinkCounters struct {
+	RxBytes, RxPackets, RxDropped uint64
+	TxBytes, TxPackets, TxDropped uint64
+}
+
+// ListLinkCounters returns the counters of every host veth whose name starts
+// with prefix, keyed by name.
+func (n *NetlinkOps) ListLinkCounters(prefix string) (map[string]LinkCounters, error) {
+	out, err := runIP("-j", "-s", "link", "show", "type", "veth")
+	if err != nil {
+		return nil, fmt.Errorf("list veth counters: %w", err)
+	}
+	counters := map[string]LinkCounters{}
+	if out == "" {
+		return counters, nil
+	}
+	type dir struct {
+		Bytes   uint64 `json:"bytes"`
+		Packets uint64 `json:"packets"`
+		Dropped uint64 `json:"dropped"`
+	}
+	var links []struct {
+		IfName  string `json:"ifname"`
+		Stats64 struct {
+			Rx dir `json:"rx"`
+			Tx dir `json:"tx"`
+		} `json:"stats64"`
+	}
+	if err := json.Unmarshal([]byte(out), &links); err != nil {
+		return nil, fmt.Errorf("decode veth counters: %w", err)
+	}
+	for _, l := range links {
+		if !strings.HasPrefix(l.IfName, prefix) {
+			continue
+		}
+		s := l.Stats64
+		counters[l.IfName] = LinkCounters{
+			RxBytes: s.Rx.Bytes, RxPackets: s.Rx.Packets, RxDropped: s.Rx.Dropped,
+			TxBytes: s.Tx.Bytes, TxPackets: s.Tx.Packets, TxDropped: s.Tx.Dropped,
+		}
+	}
+	return counters, nil
+}
@@ -0,0 +1,71 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// masqChain source-NATs pod egress leaving the pod subnet.
+const masqChain = "masq"
+
+const masqPrefix = "atomicni masq "
+
+// SetMasquerade installs source NAT for m.Source, replacing any rules already
+// installed under key. A port range only applies to TCP and UDP, so it adds a
+// rule for those ahead of a catch-all without ports.
+func (f nftFirewall) SetMasquerade(key string, m Masquerade) error {
+	if err := ensureNFTChain(masqChain, "type nat hook postrouting priority srcnat;"); err != nil {
+		return err
+	}
+	if err := f.ClearMasquerade(key); err != nil {
+		return err
+	}
+	match := []string{"ip", "saddr", m.Source.String()}
+	if m.Exclude != nil {
+		match = append(match, "ip", "daddr", "!=", m.Exclude.String())
+	}
+
+	var rules [][]string
+	if m.PortMin > 0 {
+		rule := append(append([]string{}, match...), "meta", "l4proto", "{ tcp, udp }")
+		rules = append(rules, append(rule, masqStatement(m, true)...))
+	}
+	rules = append(rules, append(append([]string{}, match...), masqStatement(m, false)...))
+
+	for _, rule := range rules {
+		args := append([]string{"add", "rule", "inet", nftTable, masqChain}, rule...)
+		args = append(args, "comment", strconv.Quote(masqPrefix+key))
+		if _, err := runNFT(args...); err != nil {
+			return fmt.Errorf("masquerade %s: %w", m.Source, err)
+		}
+	}
+	return nil
+}
+
+// ClearMasquerade removes the rules installed under key, if any.
+func (f nftFirewall) ClearMasquerade(key string) error {
+	err := deleteNFTRules(masqChain, func(r nftRule) bool {
+		return r.comment == masqPrefix+key
+	})
+	if err != nil {
+		return fmt.Errorf("remove masquerade %s: %w", key, err)
+	}
+	return nil
+}
+
+// masqStatement renders `masquerade` or `snat`, optionally with the port range.
+func masqStatement(m Masquerade, withPorts bool) []string {
+	ports := ""
+	if withPorts {
+		ports = ":" + portSpan(m.PortMin, m.PortMax)
+	}
+	if m.EgressIP != nil {
+		return []string{"snat", "ip", "to", m.EgressIP.String() + ports}
+	}
+	if ports != "" {
+		return []string{"masquerade", "to", ports}
+	}
+	return []string{"masquerade"}
+}
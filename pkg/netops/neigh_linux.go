@@ -0,0 +1,119 @@
+package netops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// neighSysctlDir holds the IPv4 neighbor (ARP) table sysctls.
+const neighSysctlDir = "/proc/sys/net/ipv4/neigh"
+
+// NeighborTuning sizes the IPv4 neighbor table. Zero fields are left alone.
+type NeighborTuning struct {
+	// GCThresh1-3 are the table-wide garbage collection thresholds: below 1
+	// nothing is collected, above 2 collection becomes aggressive, and 3 is
+	// the hard limit past which new neighbors are dropped.
+	GCThresh1, GCThresh2, GCThresh3 int
+	// BaseReachableTime applies to the link passed to TuneNeighbors.
+	BaseReachableTime time.Duration
+}
+
+// TuneNeighbors applies t. The thresholds are shared by every network on the
+// host, so they are only ever raised; base_reachable_time is set on link when
+// link is not empty. Settings the namespace cannot change, such as the
+// thresholds inside a kind node, are skipped and reported together as
+// ErrSysctlUnavailable once the others are applied.
+func (n *NetlinkOps) TuneNeighbors(link string, t NeighborTuning) error {
+	var skipped []string
+	for i, want := range []int{t.GCThresh1, t.GCThresh2, t.GCThresh3} {
+		if want == 0 {
+			continue
+		}
+		path := filepath.Join(neighSysctlDir, "default", fmt.Sprintf("gc_thresh%d", i+1))
+		current, err := readSysctlInt(path)
+		if err == nil && current < want {
+			err = writeSysctlInt(path, want)
+		}
+		if err != nil {
+			if !sysctlUnavailable(err) {
+				return err
+			}
+			skipped = append(skipped, filepath.Base(path))
+		}
+	}
+	if link != "" && t.BaseReachableTime > 0 {
+		path := filepath.Join(neighSysctlDir, link, "base_reachable_time_ms")
+		if err := writeSysctlInt(path, int(t.BaseReachableTime/time.Millisecond)); err != nil {
+			if !sysctlUnavailable(err) {
+				return err
+			}
+			skipped = append(skipped, link+" base_reachable_time_ms")
+		}
+	}
+	if len(skipped) > 0 {
+		return fmt.Errorf("%w: skipped %s", ErrSysctlUnavailable, strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// NeighborSettings reads the current thresholds and, when link is not empty,
+// its base_reachable_time. Thresholds this namespace does not have are
+// reported as ErrSysctlUnavailable.
+func (n *NetlinkOps) NeighborSettings(link string) (NeighborTuning, error) {
+	var t NeighborTuning
+	for i, dst := range []*int{&t.GCThresh1, &t.GCThresh2, &t.GCThresh3} {
+		v, err := readSysctlInt(filepath.Join(neighSysctlDir, "default", fmt.Sprintf("gc_thresh%d", i+1)))
+		if err != nil {
+			if sysctlUnavailable(err) {
+				return t, fmt.Errorf("%w: %v", ErrSysctlUnavailable, err)
+			}
+			return t, err
+		}
+		*dst = v
+	}
+	if link != "" {
+		ms, err := readSysctlInt(filepath.Join(neighSysctlDir, link, "base_reachable_time_ms"))
+		if err != nil {
+			return t, err
+		}
+		t.BaseReachableTime = time.Duration(ms) * time.Millisecond
+	}
+	return t, nil
+}
+
+// NeighborCount returns the number of IPv4 neighbor entries on the host.
+func (n *NetlinkOps) NeighborCount() (int, error) {
+	out, err := runIP("-4", "neigh", "show")
+	if err != nil {
+		return 0, fmt.Errorf("list neighbors: %w", err)
+	}
+	if out == "" {
+		return 0, nil
+	}
+	return len(strings.Split(out, "\n")), nil
+}
+
+// readSysctlInt reads an integer sysctl file.
+func readSysctlInt(path string) (int, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", path, err)
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// writeSysctlInt writes an integer sysctl file.
+func writeSysctlInt(path string, v int) error {
+	if err := os.WriteFile(path, []byte(strconv.Itoa(v)), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
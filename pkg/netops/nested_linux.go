@@ -0,0 +1,89 @@
+package netops
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// Nested environments AtomicNI recognizes.
+const (
+	NestedKind      = "kind"
+	NestedSysbox    = "sysbox"
+	NestedContainer = "container"
+)
+
+// ErrSysctlUnavailable reports a sysctl that this namespace cannot change:
+// host-wide settings such as the neighbor gc thresholds only exist in the
+// host's initial network namespace, and nested runtimes may mount /proc/sys
+// read-only.
+var ErrSysctlUnavailable = errors.New("sysctl not available in this namespace")
+
+// Nesting describes the environment the host side of AtomicNI runs in.
+type Nesting struct {
+	// Runtime is NestedKind, NestedSysbox, NestedContainer, or empty on a
+	// plain host.
+	Runtime string
+	// SysctlReadOnly is set when /proc/sys is mounted read-only.
+	SysctlReadOnly bool
+	// HostSysctls is false when the host-wide neighbor sysctls are absent,
+	// i.e. the "host" is itself a network namespace such as a kind node.
+	HostSysctls bool
+}
+
+// Nested reports whether AtomicNI runs inside another container.
+func (n Nesting) Nested() bool {
+	return n.Runtime != ""
+}
+
+// DetectNesting inspects the mount table and well-known marker files.
+func DetectNesting() Nesting {
+	n := Nesting{}
+	_, err := os.Stat(neighSysctlDir + "/default/gc_thresh1")
+	n.HostSysctls = err == nil
+
+	sysbox := false
+	if f, err := os.Open("/proc/self/mountinfo"); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// ID parent major:minor root mountpoint options ... - fstype source super
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 6 {
+				continue
+			}
+			if slices.Contains(fields, "sysboxfs") {
+				sysbox = true
+			}
+			if fields[4] == "/proc/sys" && slices.Contains(strings.Split(fields[5], ","), "ro") {
+				n.SysctlReadOnly = true
+			}
+		}
+		f.Close()
+	}
+
+	switch {
+	case sysbox:
+		n.Runtime = NestedSysbox
+	case exists("/kind"):
+		// kind node images ship their version under /kind.
+		n.Runtime = NestedKind
+	case exists("/.dockerenv"), exists("/run/.containerenv"):
+		n.Runtime = NestedContainer
+	}
+	return n
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// sysctlUnavailable reports whether err writing or reading a sysctl means the
+// namespace cannot have it, rather than a failure worth retrying.
+func sysctlUnavailable(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.EROFS) ||
+		errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,28 @@
+package netops
+
+import (
+	"net/netip"
+
+	"github.com/annis-souames/atomicni/pkg/netaddr"
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// AddPrefixAndRoute is NetOps.AddAddressAndRoute taking a netip.Prefix.
+func AddPrefixAndRoute(ops NetOps, target ns.NetNS, ifName string, addr netip.Prefix, route DefaultRoute) (RouteOutcome, error) {
+	return ops.AddAddressAndRoute(target, ifName, netaddr.ToIPNet(addr), route)
+}
+
+// AddPrefix is NetOps.AddAddress taking a netip.Prefix.
+func AddPrefix(ops NetOps, target ns.NetNS, ifName string, addr netip.Prefix) error {
+	return ops.AddAddress(target, ifName, netaddr.ToIPNet(addr))
+}
+
+// AddPrefixRoute is NetOps.AddRoute taking netip values.
+func AddPrefixRoute(ops NetOps, target ns.NetNS, ifName string, dst netip.Prefix, gateway netip.Addr) error {
+	return ops.AddRoute(target, ifName, netaddr.ToIPNet(dst), netaddr.ToIP(gateway))
+}
+
+// AddHostPrefixRoute is NetOps.AddHostRoute taking a netip.Prefix.
+func AddHostPrefixRoute(ops NetOps, dst netip.Prefix, linkName string) error {
+	return ops.AddHostRoute(netaddr.ToIPNet(dst), linkName)
+}
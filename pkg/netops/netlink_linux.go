@@ -1,12 +1,13 @@
 package netops
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ns"
@@ -17,16 +18,81 @@ type NetOps interface {
 	EnsureBridge(name string, gateway *net.IPNet) error
 	CreateVethPair(hostName, peerName string, mtu int) error
 	AttachHostVethToBridge(hostName, bridgeName string) error
+	SetLinkAlias(name, alias, altName string) error
+	SetBridgeOptions(name string, opts BridgeOptions) error
+	SetBridgePortVLANs(bridgeName, portName string, vids []int) error
+	SetBridgePortIsolated(portName string, isolated bool) error
+	EnsureVLANLink(parent, name string, id int, protocol string) error
 	MoveToNamespace(linkName string, target ns.NetNS) error
 	PrepareContainerLink(target ns.NetNS, currentName, targetName string) (string, error)
-	AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, gateway net.IP) error
+	AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, route DefaultRoute) (RouteOutcome, error)
+	AddAddress(target ns.NetNS, ifName string, addr *net.IPNet) error
+	DefaultRouteMetric(target ns.NetNS, ifName string, gateway net.IP) (int, bool, error)
+	AddRoute(target ns.NetNS, ifName string, dst *net.IPNet, gateway net.IP) error
+	AddAddressOnHostLink(name string, addr *net.IPNet) error
+	AddStaticNeighbor(target ns.NetNS, link string, ip net.IP, mac string) error
+	AddHostStaticNeighbor(link string, ip net.IP, mac string) error
+	VerifyGateway(target ns.NetNS, ifName string, gateway net.IP) error
+	DeleteHostStaticNeighbor(link string, ip net.IP) error
+	HostStaticNeighborPresent(link string, ip net.IP) (bool, error)
+	SetProxyARP(name string, enabled bool) error
+	TuneNeighbors(link string, t NeighborTuning) error
+	AddHostRoute(dst *net.IPNet, linkName string) error
+	AddProtoRoute(dst *net.IPNet, linkName string, proto int) error
+	DeleteProtoRoute(dst *net.IPNet, proto int) error
+	ProtoRoutePresent(dst *net.IPNet, proto int) (bool, error)
+	EnsureServiceRoute(dst *net.IPNet, via net.IP) error
+	SetDSCP(hostLink string, dscp int) error
+	ClearDSCP(hostLink string) error
+	GetDSCP(hostLink string) (int, bool, error)
+	SetConnLimit(key string, podIP net.IP, perSecond, burst int) error
+	ClearConnLimit(key string) error
+	ConnLimitDrops() (map[string]uint64, error)
+	SetPortMappings(key string, podIP net.IP, forwards []PortForward) error
+	ClearPortMappings(key string) error
+	SetMasquerade(key string, m Masquerade) error
+	ClearMasquerade(key string) error
+	SetCTZone(key string, z CTZone) error
+	ClearCTZone(key string) error
+	FlushConntrackZone(zone int) error
+	ConntrackZoneEntries(zone int) (int, error)
+	SetEgressGateway(key string, g EgressGateway) error
+	ClearEgressGateway(key string) error
+	EgressRoute(table int) (net.IP, error)
+	SetEgressRoute(table int, gateway net.IP) error
+	ProbeGateway(gateway net.IP, method string) error
+	SetDNSRedirect(key string, d DNSRedirect) error
+	ClearDNSRedirect(key string) error
+	AddPodSetMember(network string, ip net.IP) error
+	RemovePodSetMember(network string, ip net.IP) error
+	PodSetHasMember(network string, ip net.IP) (bool, error)
+	RuleDigest(kind, key string) (string, error)
+	FirewalldRunning() bool
+	FirewalldTrust(zone, iface string) error
+	FirewalldUntrust(zone, iface string) error
+	FirewalldBound(zone, iface string) bool
+	ListOwnedLinks(bridge, prefix string) ([]OwnedLink, error)
+	ListHostAddresses() ([]HostAddress, error)
 	DeleteLink(name string) error
 	DeleteLinkInNS(target ns.NetNS, name string) error
 	GetLinkMAC(name string) (string, error)
+	GetLinkMACInNS(target ns.NetNS, name string) (string, error)
+	SetLinkMAC(name, mac string) error
+	SetLinkMACInNS(target ns.NetNS, name, mac string) error
+	SetSysctls(target ns.NetNS, values map[string]string) error
+	PodLinkState(target ns.NetNS, ifName string) (PodLink, error)
+	LinkMTU(name string) (mtu, maxMTU int, err error)
+	SetLinkMTU(name string, mtu int) error
+	GetShaper(link string) (Shaper, bool, error)
+	SetupHostVeth(v HostVeth) error
+	SetupContainerLink(target ns.NetNS, peer, ifName string, addr *net.IPNet, route *DefaultRoute) (string, RouteOutcome, error)
 }
 
 // NetlinkOps is a Linux implementation of NetOps backed by iproute2 commands.
-type NetlinkOps struct{}
+type NetlinkOps struct {
+	// Firewall overrides host rule backend detection; nil means DetectFirewall.
+	Firewall Firewall
+}
 
 // NewNetlinkOps returns a NetOps implementation backed by the ip command.
 func NewNetlinkOps() *NetlinkOps {
@@ -34,7 +100,11 @@ func NewNetlinkOps() *NetlinkOps {
 }
 
 // EnsureBridge creates the bridge if needed, brings it up, and sets gateway CIDR.
+// A bridge that is already up with the gateway costs no ip invocation.
 func (n *NetlinkOps) EnsureBridge(name string, gateway *net.IPNet) error {
+	if linkReady(name, gateway) {
+		return nil
+	}
 	if !linkExists(name) {
 		if _, err := runIP("link", "add", "name", name, "type", "bridge"); err != nil && !isAlreadyExists(err) {
 			return fmt.Errorf("create bridge: %w", err)
@@ -60,6 +130,44 @@ func (n *NetlinkOps) EnsureBridge(name string, gateway *net.IPNet) error {
 	return nil
 }
 
+// BridgeOptions are bridge tunables; nil fields are left unchanged.
+type BridgeOptions struct {
+	AgeingTime    *int // seconds
+	McastSnooping *bool
+	McastQuerier  *bool
+}
+
+// SetBridgeOptions applies FDB ageing and multicast snooping settings to an
+// existing bridge.
+func (n *NetlinkOps) SetBridgeOptions(name string, opts BridgeOptions) error {
+	args := []string{"link", "set", "dev", name, "type", "bridge"}
+	if opts.AgeingTime != nil {
+		// iproute2 takes ageing_time in centiseconds.
+		args = append(args, "ageing_time", strconv.Itoa(*opts.AgeingTime*100))
+	}
+	if opts.McastSnooping != nil {
+		args = append(args, "mcast_snooping", boolFlag(*opts.McastSnooping))
+	}
+	if opts.McastQuerier != nil {
+		args = append(args, "mcast_querier", boolFlag(*opts.McastQuerier))
+	}
+	if len(args) == 6 {
+		return nil
+	}
+	if _, err := runIP(args...); err != nil {
+		return fmt.Errorf("set bridge options on %q: %w", name, err)
+	}
+	return nil
+}
+
+// boolFlag renders b as the 0/1 argument iproute2 expects.
+func boolFlag(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
 // CreateVethPair creates host/container veth interfaces and applies MTU.
 func (n *NetlinkOps) CreateVethPair(hostName, peerName string, mtu int) error {
 	if hostName == "" || peerName == "" {
@@ -95,6 +203,66 @@ func (n *NetlinkOps) AttachHostVethToBridge(hostName, bridgeName string) error {
 	return nil
 }
 
+// SetBridgePortVLANs enables VLAN filtering on the bridge and trunks vids on
+// one of its ports, batching contiguous IDs into ranges.
+func (n *NetlinkOps) SetBridgePortVLANs(bridgeName, portName string, vids []int) error {
+	if len(vids) == 0 {
+		return nil
+	}
+	if _, err := runIP("link", "set", "dev", bridgeName, "type", "bridge", "vlan_filtering", "1"); err != nil {
+		return fmt.Errorf("enable vlan filtering on %q: %w", bridgeName, err)
+	}
+	for _, spec := range vlanRanges(vids) {
+		if _, err := runBridge("vlan", "add", "vid", spec, "dev", portName); err != nil && !isAlreadyExists(err) {
+			return fmt.Errorf("add vlan %s to %q: %w", spec, portName, err)
+		}
+	}
+	return nil
+}
+
+// SetLinkAlias sets the alias shown by `ip link` on a host link and, when
+// altName is not empty, adds it as an alternative name. Kernels before 5.5
+// lack alternative names, so failing to add one is not an error.
+func (n *NetlinkOps) SetLinkAlias(name, alias, altName string) error {
+	if _, err := runIP("link", "set", "dev", name, "alias", alias); err != nil {
+		return fmt.Errorf("set alias on %q: %w", name, err)
+	}
+	if altName != "" {
+		_, _ = runIP("link", "property", "add", "dev", name, "altname", altName)
+	}
+	return nil
+}
+
+// SetBridgePortIsolated toggles port isolation: isolated ports only forward
+// to non-isolated ports such as the bridge itself, never to each other.
+func (n *NetlinkOps) SetBridgePortIsolated(portName string, isolated bool) error {
+	state := "off"
+	if isolated {
+		state = "on"
+	}
+	if _, err := runBridge("link", "set", "dev", portName, "isolated", state); err != nil {
+		return fmt.Errorf("set isolation on %q: %w", portName, err)
+	}
+	return nil
+}
+
+// EnsureVLANLink creates a VLAN sub-interface of parent if missing and brings
+// it up. protocol is "802.1Q" or "802.1ad" (service tag, for QinQ).
+func (n *NetlinkOps) EnsureVLANLink(parent, name string, id int, protocol string) error {
+	if linkReady(name, nil) {
+		return nil
+	}
+	if !linkExists(name) {
+		if _, err := runIP("link", "add", "link", parent, "name", name, "type", "vlan", "protocol", protocol, "id", strconv.Itoa(id)); err != nil && !isAlreadyExists(err) {
+			return fmt.Errorf("create %s vlan %q: %w", protocol, name, err)
+		}
+	}
+	if _, err := runIP("link", "set", "dev", name, "up"); err != nil {
+		return fmt.Errorf("set vlan %q up: %w", name, err)
+	}
+	return nil
+}
+
 // MoveToNamespace moves a link from host namespace into target namespace.
 func (n *NetlinkOps) MoveToNamespace(linkName string, target ns.NetNS) error {
 	if !linkExists(linkName) {
@@ -135,19 +303,180 @@ func (n *NetlinkOps) PrepareContainerLink(target ns.NetNS, currentName, targetNa
 }
 
 // AddAddressAndRoute configures pod IPv4 address and default route.
-func (n *NetlinkOps) AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, gateway net.IP) error {
-	return target.Do(func(_ ns.NetNS) error {
+func (n *NetlinkOps) AddAddressAndRoute(target ns.NetNS, ifName string, addr *net.IPNet, route DefaultRoute) (RouteOutcome, error) {
+	var out RouteOutcome
+	err := target.Do(func(_ ns.NetNS) error {
 		if _, err := runIP("addr", "add", addr.String(), "dev", ifName); err != nil && !isAlreadyExists(err) {
 			return fmt.Errorf("assign IP address: %w", err)
 		}
 
-		if _, err := runIP("route", "add", "default", "via", gateway.String(), "dev", ifName); err != nil && !isAlreadyExists(err) {
-			return fmt.Errorf("add default route: %w", err)
+		existing, err := defaultRoutes()
+		if err != nil {
+			return err
+		}
+		cmds, outcome, err := planDefaultRoute(ifName, route, existing)
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			if _, err := runIP(cmd...); err != nil {
+				return fmt.Errorf("add default route: %w", err)
+			}
+		}
+		out = outcome
+		return nil
+	})
+	return out, err
+}
+
+// AddAddress assigns one IPv4 address to a container link.
+func (n *NetlinkOps) AddAddress(target ns.NetNS, ifName string, addr *net.IPNet) error {
+	return target.Do(func(_ ns.NetNS) error {
+		if _, err := runIP("addr", "add", addr.String(), "dev", ifName); err != nil && !isAlreadyExists(err) {
+			return fmt.Errorf("assign IP address %s: %w", addr, err)
 		}
 		return nil
 	})
 }
 
+// AddRoute installs one route via gateway on a container link.
+func (n *NetlinkOps) AddRoute(target ns.NetNS, ifName string, dst *net.IPNet, gateway net.IP) error {
+	return target.Do(func(_ ns.NetNS) error {
+		if _, err := runIP("route", "add", dst.String(), "via", gateway.String(), "dev", ifName); err != nil && !isAlreadyExists(err) {
+			return fmt.Errorf("add route %s: %w", dst, err)
+		}
+		return nil
+	})
+}
+
+// AddAddressOnHostLink brings a host-namespace link up and assigns an address.
+func (n *NetlinkOps) AddAddressOnHostLink(name string, addr *net.IPNet) error {
+	if _, err := runIP("link", "set", "dev", name, "up"); err != nil {
+		return fmt.Errorf("set link %q up: %w", name, err)
+	}
+	if _, err := runIP("addr", "add", addr.String(), "dev", name); err != nil && !isAlreadyExists(err) {
+		return fmt.Errorf("assign %s to %q: %w", addr, name, err)
+	}
+	return nil
+}
+
+// AddStaticNeighbor installs a permanent neighbor entry on a container link.
+func (n *NetlinkOps) AddStaticNeighbor(target ns.NetNS, link string, ip net.IP, mac string) error {
+	return target.Do(func(_ ns.NetNS) error {
+		return n.AddHostStaticNeighbor(link, ip, mac)
+	})
+}
+
+// AddHostStaticNeighbor installs a permanent neighbor entry on a link in the
+// current namespace, replacing a learned or stale one.
+func (n *NetlinkOps) AddHostStaticNeighbor(link string, ip net.IP, mac string) error {
+	if _, err := runIP("neigh", "replace", ip.String(), "lladdr", mac, "dev", link, "nud", "permanent"); err != nil {
+		return fmt.Errorf("add neighbor %s on %q: %w", ip, link, err)
+	}
+	return nil
+}
+
+// DeleteHostStaticNeighbor removes a neighbor entry; a missing one is not an
+// error.
+func (n *NetlinkOps) DeleteHostStaticNeighbor(link string, ip net.IP) error {
+	if _, err := runIP("neigh", "del", ip.String(), "dev", link); err != nil &&
+		!strings.Contains(err.Error(), "No such file or directory") && !strings.Contains(err.Error(), "Cannot find device") {
+		return fmt.Errorf("delete neighbor %s on %q: %w", ip, link, err)
+	}
+	return nil
+}
+
+// HostStaticNeighborPresent reports whether a permanent neighbor entry for ip
+// is on link; a missing link has none.
+func (n *NetlinkOps) HostStaticNeighborPresent(link string, ip net.IP) (bool, error) {
+	out, err := runIP("-j", "neigh", "show", ip.String(), "dev", link, "nud", "permanent")
+	if err != nil {
+		if strings.Contains(err.Error(), "Cannot find device") || strings.Contains(err.Error(), "does not exist") {
+			return false, nil
+		}
+		return false, fmt.Errorf("show neighbor %s on %q: %w", ip, link, err)
+	}
+	var entries []json.RawMessage
+	if out != "" {
+		if err := json.Unmarshal([]byte(out), &entries); err != nil {
+			return false, fmt.Errorf("parse neighbors on %q: %w", link, err)
+		}
+	}
+	return len(entries) > 0, nil
+}
+
+// SetProxyARP toggles IPv4 proxy ARP on a host-namespace link.
+func (n *NetlinkOps) SetProxyARP(name string, enabled bool) error {
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	path := filepath.Join("/proc/sys/net/ipv4/conf", name, "proxy_arp")
+	if err := os.WriteFile(path, []byte(value), 0o644); err != nil {
+		if sysctlUnavailable(err) {
+			// Nested runtimes may mount /proc/sys read-only.
+			return fmt.Errorf("set proxy_arp on %q: %w: %v", name, ErrSysctlUnavailable, err)
+		}
+		return fmt.Errorf("set proxy_arp on %q: %w", name, err)
+	}
+	return nil
+}
+
+// AddHostRoute routes dst directly out of a host-namespace link.
+func (n *NetlinkOps) AddHostRoute(dst *net.IPNet, linkName string) error {
+	if _, err := runIP("route", "add", dst.String(), "dev", linkName); err != nil && !isAlreadyExists(err) {
+		return fmt.Errorf("add host route %s: %w", dst, err)
+	}
+	return nil
+}
+
+// AddProtoRoute is AddHostRoute tagging the route with a routing protocol
+// number, so a routing daemon can select it for redistribution.
+func (n *NetlinkOps) AddProtoRoute(dst *net.IPNet, linkName string, proto int) error {
+	if _, err := runIP("route", "add", dst.String(), "dev", linkName, "proto", strconv.Itoa(proto)); err != nil && !isAlreadyExists(err) {
+		return fmt.Errorf("add proto %d route %s: %w", proto, dst, err)
+	}
+	return nil
+}
+
+// DeleteProtoRoute removes the route to dst only if it carries proto, leaving
+// routes other daemons installed for the same prefix alone.
+func (n *NetlinkOps) DeleteProtoRoute(dst *net.IPNet, proto int) error {
+	if _, err := runIP("route", "del", dst.String(), "proto", strconv.Itoa(proto)); err != nil && !isRouteNotFound(err) {
+		return fmt.Errorf("delete proto %d route %s: %w", proto, dst, err)
+	}
+	return nil
+}
+
+// ProtoRoutePresent reports whether the main table holds a route for exactly
+// dst carrying proto.
+func (n *NetlinkOps) ProtoRoutePresent(dst *net.IPNet, proto int) (bool, error) {
+	out, err := runIP("-j", "route", "show", "exact", dst.String(), "proto", strconv.Itoa(proto))
+	if err != nil {
+		return false, fmt.Errorf("show proto %d route %s: %w", proto, dst, err)
+	}
+	var routes []json.RawMessage
+	if out != "" {
+		if err := json.Unmarshal([]byte(out), &routes); err != nil {
+			return false, fmt.Errorf("parse routes for %s: %w", dst, err)
+		}
+	}
+	return len(routes) > 0, nil
+}
+
+// EnsureServiceRoute routes a service range on the host via via, or installs
+// a blackhole route for it when via is nil.
+func (n *NetlinkOps) EnsureServiceRoute(dst *net.IPNet, via net.IP) error {
+	args := []string{"route", "replace", "blackhole", dst.String()}
+	if via != nil {
+		args = []string{"route", "replace", dst.String(), "via", via.String()}
+	}
+	if _, err := runIP(args...); err != nil {
+		return fmt.Errorf("route service range %s: %w", dst, err)
+	}
+	return nil
+}
+
 // DeleteLink deletes a host-namespace link if it exists.
 func (n *NetlinkOps) DeleteLink(name string) error {
 	if _, err := runIP("link", "del", "dev", name); err != nil {
@@ -177,10 +506,73 @@ func (n *NetlinkOps) GetLinkMAC(name string) (string, error) {
 	return readMAC(name)
 }
 
+// SetLinkMAC sets the MAC address of a host-namespace link.
+func (n *NetlinkOps) SetLinkMAC(name, mac string) error {
+	if _, err := runIP("link", "set", "dev", name, "address", mac); err != nil {
+		return fmt.Errorf("set MAC %s on %q: %w", mac, name, err)
+	}
+	return nil
+}
+
+// GetLinkMACInNS returns the MAC address of a link inside target.
+func (n *NetlinkOps) GetLinkMACInNS(target ns.NetNS, name string) (string, error) {
+	var mac string
+	err := target.Do(func(_ ns.NetNS) error {
+		var err error
+		mac, err = readMAC(name)
+		return err
+	})
+	return mac, err
+}
+
+// LinkMTU returns a host link's MTU and the largest MTU its driver accepts;
+// maxMTU is 0 when the driver does not report one.
+func (n *NetlinkOps) LinkMTU(name string) (mtu, maxMTU int, err error) {
+	out, err := runIP("-d", "-o", "link", "show", "dev", name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read MTU for %q: %w", name, err)
+	}
+	fields := strings.Fields(out)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "mtu":
+			mtu, _ = strconv.Atoi(fields[i+1])
+		case "maxmtu":
+			maxMTU, _ = strconv.Atoi(fields[i+1])
+		}
+	}
+	if mtu == 0 {
+		return 0, 0, fmt.Errorf("read MTU for %q: no mtu in %q", name, out)
+	}
+	return mtu, maxMTU, nil
+}
+
+// SetLinkMTU sets a host link's MTU.
+func (n *NetlinkOps) SetLinkMTU(name string, mtu int) error {
+	if _, err := runIP("link", "set", "dev", name, "mtu", strconv.Itoa(mtu)); err != nil {
+		return fmt.Errorf("set MTU %d on %q: %w", mtu, name, err)
+	}
+	return nil
+}
+
 // runIP executes iproute2 and returns trimmed output with contextual errors.
 func runIP(args ...string) (string, error) {
-	cmd := exec.Command("ip", args...)
+	return runTool("ip", args...)
+}
+
+// runBridge executes the iproute2 bridge tool with the same error handling.
+func runBridge(args ...string) (string, error) {
+	return runTool("bridge", args...)
+}
+
+// runTool executes an allowed host tool and returns trimmed output.
+func runTool(name string, args ...string) (string, error) {
+	cmd, audit, err := command(name, args...)
+	if err != nil {
+		return "", err
+	}
 	out, err := cmd.CombinedOutput()
+	audit(err)
 	output := strings.TrimSpace(string(out))
 	if err != nil {
 		if output == "" {
@@ -191,6 +583,24 @@ func runIP(args ...string) (string, error) {
 	return output, nil
 }
 
+// vlanRanges collapses sorted VLAN IDs into `bridge vlan` vid specs.
+func vlanRanges(vids []int) []string {
+	var specs []string
+	for i := 0; i < len(vids); {
+		j := i
+		for j+1 < len(vids) && vids[j+1] == vids[j]+1 {
+			j++
+		}
+		if i == j {
+			specs = append(specs, strconv.Itoa(vids[i]))
+		} else {
+			specs = append(specs, fmt.Sprintf("%d-%d", vids[i], vids[j]))
+		}
+		i = j + 1
+	}
+	return specs
+}
+
 // linkExists checks whether a link name is present in the current namespace.
 func linkExists(name string) bool {
 	_, err := runIP("link", "show", "dev", name)
@@ -205,21 +615,45 @@ func isAlreadyExists(err error) bool {
 	return strings.Contains(err.Error(), "File exists")
 }
 
+// isRouteNotFound matches iproute2's error for deleting a route that does not
+// exist, or whose attributes do not match.
+func isRouteNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "No such process")
+}
+
+// ErrLinkNotFound reports a link missing from the namespace it was read in.
+var ErrLinkNotFound = errors.New("link not found")
+
 // isLinkNotFound normalizes not-found cases across iproute2 error forms.
 func isLinkNotFound(err error) bool {
 	if err == nil {
 		return false
 	}
-	return errors.Is(err, os.ErrNotExist) ||
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrLinkNotFound) ||
 		strings.Contains(err.Error(), "Cannot find device") ||
 		strings.Contains(err.Error(), "does not exist")
 }
 
-// readMAC reads interface MAC address from sysfs.
+// readMAC reads a link's MAC address from its netlink attributes in the
+// current network namespace. Unlike /sys/class/net, which reflects the
+// namespace sysfs was mounted in, this also works inside ns.Do and where
+// sysfs is not mounted.
 func readMAC(ifName string) (string, error) {
-	content, err := os.ReadFile(filepath.Join("/sys/class/net", ifName, "address"))
+	out, err := runIP("-j", "link", "show", "dev", ifName)
+	if isLinkNotFound(err) {
+		return "", fmt.Errorf("read MAC for %q: %w: %v", ifName, ErrLinkNotFound, err)
+	}
 	if err != nil {
 		return "", fmt.Errorf("read MAC for %q: %w", ifName, err)
 	}
-	return strings.TrimSpace(string(content)), nil
+	var links []struct {
+		Address string `json:"address"`
+	}
+	if err := json.Unmarshal([]byte(out), &links); err != nil {
+		return "", fmt.Errorf("read MAC for %q: decode: %w", ifName, err)
+	}
+	if len(links) != 1 || links[0].Address == "" {
+		return "", fmt.Errorf("read MAC for %q: no address attribute", ifName)
+	}
+	return links[0].Address, nil
 }
@@ -0,0 +1,155 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nftFirewall programs AtomicNI rules into its own nftables table. It is used
+// on hosts running native nftables or iptables-nft.
+type nftFirewall struct{}
+
+// Name implements Firewall.
+func (nftFirewall) Name() string { return FirewallNFTables }
+
+// nftTable is the nftables table owning every AtomicNI rule.
+const nftTable = "atomicni"
+
+// dscpChain marks pod egress in prerouting, where host veth ingress is pod egress.
+const dscpChain = "dscp"
+
+// SetDSCP marks IPv4 traffic entering the host from hostLink with dscp,
+// replacing any mark already installed for that link.
+func (f nftFirewall) SetDSCP(hostLink string, dscp int) error {
+	if err := ensureNFTChain(dscpChain, "type filter hook prerouting priority mangle;"); err != nil {
+		return err
+	}
+	if err := f.ClearDSCP(hostLink); err != nil {
+		return err
+	}
+	if _, err := runNFT("add", "rule", "inet", nftTable, dscpChain,
+		"iifname", hostLink, "ip", "dscp", "set", strconv.Itoa(dscp),
+		"comment", strconv.Quote(dscpComment(hostLink, dscp))); err != nil {
+		return fmt.Errorf("mark dscp %d on %q: %w", dscp, hostLink, err)
+	}
+	return nil
+}
+
+// ClearDSCP removes the mark installed for hostLink; a missing mark or table
+// is not an error.
+func (f nftFirewall) ClearDSCP(hostLink string) error {
+	err := deleteNFTRules(dscpChain, func(r nftRule) bool {
+		_, ok := parseDSCPComment(r.comment, hostLink)
+		return ok
+	})
+	if err != nil {
+		return fmt.Errorf("remove dscp mark on %q: %w", hostLink, err)
+	}
+	return nil
+}
+
+// GetDSCP returns the DSCP value marked on hostLink, if any.
+func (f nftFirewall) GetDSCP(hostLink string) (int, bool, error) {
+	rules, err := listNFTRules(dscpChain)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, r := range rules {
+		if dscp, ok := parseDSCPComment(r.comment, hostLink); ok {
+			return dscp, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// dscpComment tags a DSCP rule with its link and value so it can be found and
+// verified without parsing nft's symbolic DSCP names.
+func dscpComment(hostLink string, dscp int) string {
+	return fmt.Sprintf("atomicni dscp %s %d", hostLink, dscp)
+}
+
+// parseDSCPComment extracts the value from a comment written by dscpComment.
+func parseDSCPComment(comment, hostLink string) (int, bool) {
+	fields := strings.Fields(comment)
+	if len(fields) != 4 || fields[0] != "atomicni" || fields[1] != "dscp" || fields[2] != hostLink {
+		return 0, false
+	}
+	dscp, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return 0, false
+	}
+	return dscp, true
+}
+
+// nftRule is one rule of a listed chain.
+type nftRule struct {
+	handle  string
+	comment string
+	expr    string
+}
+
+var nftRuleLine = regexp.MustCompile(`^(.*?)(?:\s+comment\s+"([^"]*)")?\s+# handle (\d+)$`)
+
+// listNFTRules lists rules of an AtomicNI chain; a missing table or chain
+// yields no rules.
+func listNFTRules(chain string) ([]nftRule, error) {
+	out, err := runNFT("-a", "list", "chain", "inet", nftTable, chain)
+	if err != nil {
+		if isNFTMissing(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("list nft chain %s: %w", chain, err)
+	}
+	var rules []nftRule
+	for _, line := range strings.Split(out, "\n") {
+		m := nftRuleLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || strings.HasPrefix(m[1], "chain ") || strings.HasPrefix(m[1], "type ") {
+			continue
+		}
+		rules = append(rules, nftRule{handle: m[3], comment: m[2], expr: m[1]})
+	}
+	return rules, nil
+}
+
+// deleteNFTRules removes every rule of chain selected by match.
+func deleteNFTRules(chain string, match func(nftRule) bool) error {
+	rules, err := listNFTRules(chain)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if !match(r) {
+			continue
+		}
+		if _, err := runNFT("delete", "rule", "inet", nftTable, chain, "handle", r.handle); err != nil && !isNFTMissing(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureNFTChain creates the AtomicNI table and a base chain if missing.
+func ensureNFTChain(chain, spec string) error {
+	if _, err := runNFT("add", "table", "inet", nftTable); err != nil {
+		return fmt.Errorf("create nft table: %w", err)
+	}
+	if _, err := runNFT("add", "chain", "inet", nftTable, chain, "{ "+spec+" }"); err != nil {
+		return fmt.Errorf("create nft chain %s: %w", chain, err)
+	}
+	return nil
+}
+
+// runNFT executes nft with the same error shape as runIP.
+func runNFT(args ...string) (string, error) {
+	return runTool("nft", args...)
+}
+
+// isNFTMissing reports whether nft failed because the table, chain, or rule
+// does not exist.
+func isNFTMissing(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "No such file or directory")
+}
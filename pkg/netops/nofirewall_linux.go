@@ -0,0 +1,70 @@
+//go:build atomicni_nofirewall
+
+package netops
+
+import "net"
+
+// detectFirewall returns the disabled backend; there is nothing to detect.
+func detectFirewall() Firewall { return disabledFirewall{} }
+
+// disabledFirewall stands in for the nftables and iptables backends when they
+// are compiled out. Installing or reading a rule fails; removing one succeeds,
+// since none can have been installed.
+type disabledFirewall struct{}
+
+func (disabledFirewall) Name() string { return FirewallDisabled }
+
+func (disabledFirewall) SetDSCP(string, int) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) ClearDSCP(string) error { return nil }
+
+func (disabledFirewall) GetDSCP(string) (int, bool, error) { return 0, false, ErrFirewallDisabled }
+
+func (disabledFirewall) SetConnLimit(string, net.IP, int, int) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) ClearConnLimit(string) error { return nil }
+
+func (disabledFirewall) ConnLimitDrops() (map[string]uint64, error) { return nil, nil }
+
+func (disabledFirewall) SetPortMappings(string, net.IP, []PortForward) error {
+	return ErrFirewallDisabled
+}
+
+func (disabledFirewall) ClearPortMappings(string) error { return nil }
+
+func (disabledFirewall) SetMasquerade(string, Masquerade) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) ClearMasquerade(string) error { return nil }
+
+func (disabledFirewall) SetCTZone(string, CTZone) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) ClearCTZone(string) error { return nil }
+
+func (disabledFirewall) SetEgressMark(string, EgressGateway) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) ClearEgressMark(string) error { return nil }
+
+func (disabledFirewall) SetDNSRedirect(string, DNSRedirect) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) ClearDNSRedirect(string) error { return nil }
+
+func (disabledFirewall) AddSetMember(string, net.IP) error { return ErrFirewallDisabled }
+
+func (disabledFirewall) RemoveSetMember(string, net.IP) error { return nil }
+
+func (disabledFirewall) HasSetMember(string, net.IP) (bool, error) { return false, ErrFirewallDisabled }
+
+func (disabledFirewall) RuleDigest(string, string) (string, error) { return "", ErrFirewallDisabled }
+
+// FirewalldRunning reports false: firewalld zone bindings are left out with
+// the rest of the firewall support.
+func (n *NetlinkOps) FirewalldRunning() bool { return false }
+
+// FirewalldTrust refuses the binding.
+func (n *NetlinkOps) FirewalldTrust(string, string) error { return ErrFirewallDisabled }
+
+// FirewalldBound reports false, since no binding can have been made.
+func (n *NetlinkOps) FirewalldBound(string, string) bool { return false }
+
+// FirewalldUntrust has nothing to remove.
+func (n *NetlinkOps) FirewalldUntrust(string, string) error { return nil }
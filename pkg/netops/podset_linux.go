@@ -0,0 +1,81 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AddSetMember adds ip to IPv4 set `set` of `table inet atomicni`.
+func (f nftFirewall) AddSetMember(set string, ip net.IP) error {
+	if _, err := runNFT("add", "table", "inet", nftTable); err != nil {
+		return fmt.Errorf("create nft table: %w", err)
+	}
+	if _, err := runNFT("add", "set", "inet", nftTable, set, "{ type ipv4_addr; }"); err != nil {
+		return fmt.Errorf("create nft set %s: %w", set, err)
+	}
+	if _, err := runNFT("add", "element", "inet", nftTable, set, "{ "+ip.String()+" }"); err != nil {
+		return fmt.Errorf("add %s to set %s: %w", ip, set, err)
+	}
+	return nil
+}
+
+// RemoveSetMember removes ip from an nftables set.
+func (f nftFirewall) RemoveSetMember(set string, ip net.IP) error {
+	if _, err := runNFT("delete", "element", "inet", nftTable, set, "{ "+ip.String()+" }"); err != nil && !isNFTMissing(err) {
+		return fmt.Errorf("remove %s from set %s: %w", ip, set, err)
+	}
+	return nil
+}
+
+// HasSetMember reports whether ip is in an nftables set.
+func (f nftFirewall) HasSetMember(set string, ip net.IP) (bool, error) {
+	_, err := runNFT("get", "element", "inet", nftTable, set, "{ "+ip.String()+" }")
+	if isNFTMissing(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("look up %s in set %s: %w", ip, set, err)
+	}
+	return true, nil
+}
+
+// AddSetMember adds ip to a hash:ip ipset, creating the set.
+func (f iptFirewall) AddSetMember(set string, ip net.IP) error {
+	if _, err := runTool("ipset", "create", set, "hash:ip", "family", "inet", "-exist"); err != nil {
+		return fmt.Errorf("create ipset %s: %w", set, err)
+	}
+	if _, err := runTool("ipset", "add", set, ip.String(), "-exist"); err != nil {
+		return fmt.Errorf("add %s to ipset %s: %w", ip, set, err)
+	}
+	return nil
+}
+
+// RemoveSetMember removes ip from an ipset. Without ipset there is no set.
+func (f iptFirewall) RemoveSetMember(set string, ip net.IP) error {
+	if !hasTool("ipset") {
+		return nil
+	}
+	_, err := runTool("ipset", "del", set, ip.String(), "-exist")
+	if err != nil && !strings.Contains(err.Error(), "does not exist") {
+		return fmt.Errorf("remove %s from ipset %s: %w", ip, set, err)
+	}
+	return nil
+}
+
+// HasSetMember reports whether ip is in an ipset.
+func (f iptFirewall) HasSetMember(set string, ip net.IP) (bool, error) {
+	if !hasTool("ipset") {
+		return false, nil
+	}
+	_, err := runTool("ipset", "test", set, ip.String())
+	if err != nil && (strings.Contains(err.Error(), "is NOT in set") || strings.Contains(err.Error(), "does not exist")) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("look up %s in ipset %s: %w", ip, set, err)
+	}
+	return true, nil
+}
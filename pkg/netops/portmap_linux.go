@@ -0,0 +1,89 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// portMapChain and portMapOutChain DNAT host ports to pods, for traffic
+// arriving at the host and for connections the host itself opens.
+const (
+	portMapChain    = "portmap"
+	portMapOutChain = "portmap_out"
+)
+
+const portMapPrefix = "atomicni portmap "
+
+// SetPortMappings installs one DNAT rule per forward to podIP in each portmap
+// chain, replacing any rules already installed under key. A range with a host-to-container offset
+// uses a port map so it stays a single rule.
+func (f nftFirewall) SetPortMappings(key string, podIP net.IP, forwards []PortForward) error {
+	if err := ensureNFTChain(portMapChain, "type nat hook prerouting priority dstnat;"); err != nil {
+		return err
+	}
+	if err := ensureNFTChain(portMapOutChain, "type nat hook output priority dstnat;"); err != nil {
+		return err
+	}
+	if err := f.ClearPortMappings(key); err != nil {
+		return err
+	}
+	for _, f := range forwards {
+		for _, chain := range []string{portMapChain, portMapOutChain} {
+			args := append([]string{"add", "rule", "inet", nftTable, chain}, portForwardExpr(f, podIP)...)
+			args = append(args, "comment", strconv.Quote(portMapPrefix+key))
+			if _, err := runNFT(args...); err != nil {
+				return fmt.Errorf("map %s port %s: %w", f.Protocol, portSpan(f.HostStart, f.HostEnd), err)
+			}
+		}
+	}
+	return nil
+}
+
+// ClearPortMappings removes every rule installed under key.
+func (f nftFirewall) ClearPortMappings(key string) error {
+	for _, chain := range []string{portMapChain, portMapOutChain} {
+		err := deleteNFTRules(chain, func(r nftRule) bool {
+			return r.comment == portMapPrefix+key
+		})
+		if err != nil {
+			return fmt.Errorf("remove port mappings %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// portForwardExpr builds the match and DNAT statement for one forward.
+func portForwardExpr(f PortForward, podIP net.IP) []string {
+	var expr []string
+	if f.HostIP != nil {
+		expr = append(expr, "ip", "daddr", f.HostIP.String())
+	} else {
+		expr = append(expr, "fib", "daddr", "type", "local")
+	}
+	expr = append(expr, "meta", "l4proto", f.Protocol, "th", "dport", portSpan(f.HostStart, f.HostEnd))
+
+	offset := f.ContainerStart - f.HostStart
+	if offset == 0 {
+		return append(expr, "dnat", "ip", "to", podIP.String())
+	}
+	if f.HostStart == f.HostEnd {
+		return append(expr, "dnat", "ip", "to", podIP.String()+":"+strconv.Itoa(f.ContainerStart))
+	}
+	pairs := make([]string, 0, f.HostEnd-f.HostStart+1)
+	for port := f.HostStart; port <= f.HostEnd; port++ {
+		pairs = append(pairs, fmt.Sprintf("%d : %d", port, port+offset))
+	}
+	return append(expr, "dnat", "ip", "to", podIP.String(), ":", "th", "dport", "map", "{ "+strings.Join(pairs, ", ")+" }")
+}
+
+// portSpan renders a port or port range for nft.
+func portSpan(start, end int) string {
+	if start == end {
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d-%d", start, end)
+}
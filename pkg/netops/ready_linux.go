@@ -0,0 +1,36 @@
+package netops
+
+import "net"
+
+// linkReady reports whether name exists, is administratively up, and, when
+// gateway is set, carries it. It reads live kernel state through the net
+// package's in-process netlink dump, so shared links that every ADD ensures
+// (bridges, uplink VLANs) cost no ip invocation once ready and there is no
+// cached state to invalidate. Any lookup error reports not ready and leaves
+// the decision to the ip-based slow path.
+func linkReady(name string, gateway *net.IPNet) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil || iface.Flags&net.FlagUp == 0 {
+		return false
+	}
+	if gateway == nil {
+		return true
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(gateway.IP) && sameMask(ipn.Mask, gateway.Mask) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameMask compares prefix lengths, ignoring 4- versus 16-byte encodings.
+func sameMask(a, b net.IPMask) bool {
+	aOnes, _ := a.Size()
+	bOnes, _ := b.Size()
+	return aOnes == bOnes
+}
@@ -0,0 +1,117 @@
+//go:build !atomicni_nofirewall
+
+package netops
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ruleMatcher returns a predicate selecting the rule comments that belong to
+// key for kind.
+func ruleMatcher(kind, key string) (func(comment string) bool, error) {
+	var prefix string
+	switch kind {
+	case RuleDSCP:
+		return func(comment string) bool {
+			_, ok := parseDSCPComment(comment, key)
+			return ok
+		}, nil
+	case RuleConnLimit:
+		prefix = connLimitPrefix
+	case RulePortMap:
+		prefix = portMapPrefix
+	case RuleMasquerade:
+		prefix = masqPrefix
+	case RuleCTZone:
+		prefix = ctZonePrefix
+	case RuleEgress:
+		prefix = egressPrefix
+	case RuleDNS:
+		prefix = dnsPrefix
+	default:
+		return nil, fmt.Errorf("unknown rule kind %q", kind)
+	}
+	return func(comment string) bool { return comment == prefix+key }, nil
+}
+
+// nftRuleChains and iptRuleChains are the chains holding each kind's rules.
+var (
+	nftRuleChains = map[string][]string{
+		RuleDSCP:       {dscpChain},
+		RuleConnLimit:  {connLimitChain},
+		RulePortMap:    {portMapChain, portMapOutChain},
+		RuleMasquerade: {masqChain},
+		RuleCTZone:     {ctZoneChain, ctZoneOutChain},
+		RuleEgress:     {egressChain},
+		RuleDNS:        {dnsChain},
+	}
+	iptRuleChains = map[string][]iptChain{
+		RuleDSCP:       {iptDSCPChain},
+		RuleConnLimit:  {iptConnLimitChain},
+		RulePortMap:    {iptPortMapChain, iptPortMapOutChain},
+		RuleMasquerade: {iptMasqChain},
+		RuleCTZone:     {iptCTZoneChain, iptCTZoneOutChain},
+		RuleEgress:     {iptEgressChain},
+		RuleDNS:        {iptDNSChain},
+	}
+)
+
+// nftCounters matches the packet and byte counts nft prints for a counter,
+// which change with traffic rather than with the rule.
+var nftCounters = regexp.MustCompile(`counter packets \d+ bytes \d+`)
+
+// RuleDigest implements Firewall.
+func (f nftFirewall) RuleDigest(kind, key string) (string, error) {
+	match, err := ruleMatcher(kind, key)
+	if err != nil {
+		return "", err
+	}
+	var bodies []string
+	for _, chain := range nftRuleChains[kind] {
+		rules, err := listNFTRules(chain)
+		if err != nil {
+			return "", err
+		}
+		for _, r := range rules {
+			if match(r.comment) {
+				bodies = append(bodies, chain+" "+nftCounters.ReplaceAllString(r.expr, "counter"))
+			}
+		}
+	}
+	return digestRuleBodies(bodies), nil
+}
+
+// RuleDigest implements Firewall.
+func (f iptFirewall) RuleDigest(kind, key string) (string, error) {
+	match, err := ruleMatcher(kind, key)
+	if err != nil {
+		return "", err
+	}
+	var bodies []string
+	for _, c := range iptRuleChains[kind] {
+		specs, err := listIPTSpecs(c)
+		if err != nil {
+			return "", err
+		}
+		for _, s := range specs {
+			if match(s.comment) {
+				bodies = append(bodies, c.name+" "+strings.Join(s.args, " "))
+			}
+		}
+	}
+	return digestRuleBodies(bodies), nil
+}
+
+// digestRuleBodies hashes the normalized rule bodies, in chain order, or
+// returns "" when there are none.
+func digestRuleBodies(bodies []string) string {
+	if len(bodies) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(bodies, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
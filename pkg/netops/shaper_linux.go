@@ -0,0 +1,43 @@
+package netops
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Shaper is a token bucket (tbf) qdisc, as installed by the bandwidth plugin,
+// with its rate in bytes per second and its counters.
+type Shaper struct {
+	Rate       uint64
+	Bytes      uint64
+	Packets    uint64
+	Drops      uint64
+	Overlimits uint64
+}
+
+// GetShaper returns the tbf qdisc on link, reporting false when there is none.
+func (n *NetlinkOps) GetShaper(link string) (Shaper, bool, error) {
+	out, err := runTool("tc", "-s", "-j", "qdisc", "show", "dev", link)
+	if err != nil {
+		return Shaper{}, false, fmt.Errorf("list qdiscs on %q: %w", link, err)
+	}
+	var qdiscs []struct {
+		Kind    string `json:"kind"`
+		Options struct {
+			Rate uint64 `json:"rate"`
+		} `json:"options"`
+		Bytes      uint64 `json:"bytes"`
+		Packets    uint64 `json:"packets"`
+		Drops      uint64 `json:"drops"`
+		Overlimits uint64 `json:"overlimits"`
+	}
+	if err := json.Unmarshal([]byte(out), &qdiscs); err != nil {
+		return Shaper{}, false, fmt.Errorf("decode qdiscs on %q: %w", link, err)
+	}
+	for _, q := range qdiscs {
+		if q.Kind == "tbf" {
+			return Shaper{Rate: q.Options.Rate, Bytes: q.Bytes, Packets: q.Packets, Drops: q.Drops, Overlimits: q.Overlimits}, true, nil
+		}
+	}
+	return Shaper{}, false, nil
+}
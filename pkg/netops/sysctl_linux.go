@@ -0,0 +1,28 @@
+package netops
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// SetSysctls writes net.* sysctls, keyed by dotted name, inside target.
+// /proc/sys/net shows the namespace of the thread that opens it, so the
+// writes happen in target's Do. Keys are written in sorted order and the
+// first failure stops the rest.
+func (n *NetlinkOps) SetSysctls(target ns.NetNS, values map[string]string) error {
+	return target.Do(func(_ ns.NetNS) error {
+		for _, key := range slices.Sorted(maps.Keys(values)) {
+			path := filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+			if err := os.WriteFile(path, []byte(values[key]), 0o644); err != nil {
+				return fmt.Errorf("set %s: %w", key, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package netops
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+)
+
+// verifyDeadline is how many seconds VerifyGateway keeps pinging before it
+// declares the gateway unreachable.
+const verifyDeadline = 3
+
+// VerifyGateway checks the datapath from inside target: ifName must resolve
+// gateway's MAC through ARP and the gateway must answer ping.
+func (n *NetlinkOps) VerifyGateway(target ns.NetNS, ifName string, gateway net.IP) error {
+	return target.Do(func(_ ns.NetNS) error {
+		// ping resolves the neighbor as a side effect, so a failed ping with a
+		// resolved MAC points at filtering rather than L2.
+		_, pingErr := runTool("ping", "-c", "1", "-W", "1", "-w", strconv.Itoa(verifyDeadline), "-I", ifName, gateway.String())
+		mac, err := neighborMAC(ifName, gateway)
+		if err != nil {
+			return err
+		}
+		if mac == "" {
+			return fmt.Errorf("gateway %s did not answer ARP on %q", gateway, ifName)
+		}
+		if pingErr != nil {
+			return fmt.Errorf("gateway %s (%s) did not answer ping: %w", gateway, mac, pingErr)
+		}
+		return nil
+	})
+}
+
+// neighborMAC returns the resolved MAC of ip on link, or "" when the entry
+// is missing or failed.
+func neighborMAC(link string, ip net.IP) (string, error) {
+	out, err := runIP("-j", "neigh", "show", "to", ip.String(), "dev", link)
+	if err != nil {
+		return "", fmt.Errorf("read neighbor %s on %q: %w", ip, link, err)
+	}
+	if out == "" {
+		return "", nil
+	}
+	var entries []struct {
+		LLAddr string   `json:"lladdr"`
+		State  []string `json:"state"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		return "", fmt.Errorf("decode neighbor %s on %q: %w", ip, link, err)
+	}
+	for _, e := range entries {
+		if e.LLAddr != "" {
+			return e.LLAddr, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,112 @@
+package result
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// Canonical serializes res deterministically: interfaces sorted by sandbox
+// and name with IP interface indexes renumbered to match, IPs grouped by
+// interface, and routes sorted by destination and gateway. IPs keep their
+// order within an interface because runtimes take the first address of a
+// family as the pod IP. res is not modified.
+func Canonical(res *current.Result) ([]byte, error) {
+	out := *res
+
+	order := make([]int, len(res.Interfaces))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		ia, ib := res.Interfaces[a], res.Interfaces[b]
+		return cmp.Or(cmp.Compare(ia.Sandbox, ib.Sandbox), cmp.Compare(ia.Name, ib.Name))
+	})
+	remap := make(map[int]int, len(order))
+	out.Interfaces = make([]*current.Interface, len(order))
+	for newIdx, oldIdx := range order {
+		out.Interfaces[newIdx] = res.Interfaces[oldIdx]
+		remap[oldIdx] = newIdx
+	}
+
+	out.IPs = make([]*current.IPConfig, len(res.IPs))
+	for i, ipc := range res.IPs {
+		dup := *ipc
+		if ipc.Interface != nil {
+			if idx, ok := remap[*ipc.Interface]; ok {
+				dup.Interface = &idx
+			}
+		}
+		out.IPs[i] = &dup
+	}
+	slices.SortStableFunc(out.IPs, func(a, b *current.IPConfig) int {
+		return cmp.Compare(ipInterface(a), ipInterface(b))
+	})
+
+	out.Routes = slices.Clone(res.Routes)
+	slices.SortStableFunc(out.Routes, func(a, b *types.Route) int {
+		return cmp.Or(cmp.Compare(a.Dst.String(), b.Dst.String()), cmp.Compare(a.GW.String(), b.GW.String()))
+	})
+
+	content, err := json.MarshalIndent(&out, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal result: %w", err)
+	}
+	return append(content, '\n'), nil
+}
+
+// ipInterface is the interface index of ipc, -1 when unset.
+func ipInterface(ipc *current.IPConfig) int {
+	if ipc.Interface == nil {
+		return -1
+	}
+	return *ipc.Interface
+}
+
+// CheckGolden compares the canonical form of res with the golden file at
+// path, reporting the first differing line. With update it writes the file
+// instead, creating its directory.
+func CheckGolden(path string, res *current.Result, update bool) error {
+	got, err := Canonical(res)
+	if err != nil {
+		return err
+	}
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("create golden dir: %w", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			return fmt.Errorf("write golden file: %w", err)
+		}
+		return nil
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read golden file: %w", err)
+	}
+	if bytes.Equal(got, want) {
+		return nil
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < max(len(gotLines), len(wantLines)); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			return fmt.Errorf("%s:%d: result differs from golden file\n  want: %s\n  got:  %s", path, i+1, w, g)
+		}
+	}
+	return fmt.Errorf("%s: result differs from golden file", path)
+}
@@ -0,0 +1,95 @@
+package result
+
+import (
+	"flag"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// goldenResults are the result shapes the plugin prints; a change to any of
+// them must come with a reviewed golden file update (go test -update).
+func goldenResults() map[string]*current.Result {
+	single := validResult()
+
+	dual := validResult()
+	AppendIP(dual, &net.IPNet{IP: net.ParseIP("fd00:22::10"), Mask: net.CIDRMask(64, 128)}, net.ParseIP("fd00:22::1"))
+	AppendRoute(dual, &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, net.ParseIP("fd00:22::1"))
+	SetDNS(dual, net.ParseIP("10.96.0.10"), "cluster.local", []string{"svc.cluster.local"}, []string{"ndots:5"})
+
+	first := BuildAddResultAddr("1.1.0", "av1", "aa:bb:cc:dd:ee:01", "eth0", "11:22:33:44:55:01", "/var/run/netns/test",
+		netip.MustParsePrefix("10.10.0.5/24"), netip.MustParseAddr("10.10.0.1"))
+	second := BuildAddResultAddr("1.1.0", "av2", "aa:bb:cc:dd:ee:02", "net1", "11:22:33:44:55:02", "/var/run/netns/test",
+		netip.MustParsePrefix("10.20.0.5/24"), netip.MustParseAddr("10.20.0.1"))
+
+	return map[string]*current.Result{
+		"single":     single,
+		"dual-stack": dual,
+		"multi":      Merge("1.1.0", first, second),
+	}
+}
+
+func TestGoldenResults(t *testing.T) {
+	for name, res := range goldenResults() {
+		if err := CheckGolden(filepath.Join("testdata", name+".golden.json"), res, *update); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestCanonicalIgnoresOrder(t *testing.T) {
+	res := goldenResults()["multi"]
+	AppendRoute(res, &net.IPNet{IP: net.ParseIP("10.30.0.0").To4(), Mask: net.CIDRMask(16, 32)}, net.ParseIP("10.20.0.1"))
+
+	// The same result with interfaces and routes reversed.
+	shuffled := *res
+	shuffled.Interfaces = slices.Clone(res.Interfaces)
+	slices.Reverse(shuffled.Interfaces)
+	shuffled.IPs = nil
+	for _, ipc := range res.IPs {
+		dup := *ipc
+		idx := len(res.Interfaces) - 1 - *ipc.Interface
+		dup.Interface = &idx
+		shuffled.IPs = append(shuffled.IPs, &dup)
+	}
+	shuffled.Routes = slices.Clone(res.Routes)
+	slices.Reverse(shuffled.Routes)
+
+	want, err := Canonical(res)
+	if err != nil {
+		t.Fatalf("Canonical() error = %v", err)
+	}
+	got, err := Canonical(&shuffled)
+	if err != nil {
+		t.Fatalf("Canonical() error = %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("Canonical() depends on order:\n%s\nvs\n%s", got, want)
+	}
+	if *res.IPs[1].Interface != 3 || res.Interfaces[0].Name != "av1" {
+		t.Fatalf("Canonical() mutated its input")
+	}
+}
+
+func TestCheckGoldenReportsDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "r.golden.json")
+	if err := CheckGolden(path, validResult(), true); err != nil {
+		t.Fatalf("CheckGolden(update) error = %v", err)
+	}
+	if err := CheckGolden(path, validResult(), false); err != nil {
+		t.Fatalf("CheckGolden() error = %v", err)
+	}
+	changed := validResult()
+	changed.IPs[0].Gateway = net.ParseIP("10.22.0.254").To4()
+	err := CheckGolden(path, changed, false)
+	if err == nil || !strings.Contains(err.Error(), `"gateway": "10.22.0.1"`) || !strings.Contains(err.Error(), `"gateway": "10.22.0.254"`) {
+		t.Fatalf("CheckGolden() error = %v, want the differing gateway line", err)
+	}
+}
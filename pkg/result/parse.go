@@ -0,0 +1,38 @@
+package result
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+)
+
+// ParsePrevResult decodes a result document in any result version the CNI
+// library supports, such as a config's prevResult, and converts it to the
+// current.Result that CHECK and DEL work with. An empty cniVersion takes the
+// version the document declares.
+func ParsePrevResult(data []byte, cniVersion string) (*current.Result, error) {
+	if cniVersion == "" {
+		var doc struct {
+			CNIVersion string `json:"cniVersion"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("decode result: %w", err)
+		}
+		if doc.CNIVersion == "" {
+			return nil, errors.New("decode result: cniVersion is required")
+		}
+		cniVersion = doc.CNIVersion
+	}
+	generic, err := version.NewResult(cniVersion, data)
+	if err != nil {
+		return nil, fmt.Errorf("decode result: %w", err)
+	}
+	res, err := current.NewResultFromResult(generic)
+	if err != nil {
+		return nil, fmt.Errorf("convert result: %w", err)
+	}
+	return res, nil
+}
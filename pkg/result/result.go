@@ -2,7 +2,9 @@ package result
 
 import (
 	"net"
+	"net/netip"
 
+	"github.com/annis-souames/atomicni/pkg/netaddr"
 	"github.com/containernetworking/cni/pkg/types"
 	current "github.com/containernetworking/cni/pkg/types/100"
 )
@@ -18,25 +20,157 @@ func BuildAddResult(
 	address *net.IPNet,
 	gateway net.IP,
 ) *current.Result {
-	containerInterfaceIndex := 1
-	return &current.Result{
-		CNIVersion: cniVersion,
-		Interfaces: []*current.Interface{
-			{Name: hostName, Mac: hostMAC},
-			{Name: containerName, Mac: containerMAC, Sandbox: netnsPath},
-		},
-		IPs: []*current.IPConfig{
-			{
-				Address:   *address,
-				Gateway:   gateway,
-				Interface: &containerInterfaceIndex,
-			},
-		},
-		Routes: []*types.Route{
-			{
-				Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
-				GW:  gateway,
-			},
+	res := &current.Result{CNIVersion: cniVersion}
+	AddInterface(res, hostName, hostMAC, "")
+	container := AddInterface(res, containerName, containerMAC, netnsPath)
+	AppendIPOn(res, container, address, gateway)
+	res.Routes = []*types.Route{
+		{
+			Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)},
+			GW:  gateway,
 		},
 	}
+	return res
+}
+
+// AddInterface appends an interface, in sandbox or on the host when sandbox
+// is empty, and returns its index for the IP configs that belong to it.
+func AddInterface(res *current.Result, name, mac, sandbox string) int {
+	res.Interfaces = append(res.Interfaces, &current.Interface{Name: name, Mac: mac, Sandbox: sandbox})
+	return len(res.Interfaces) - 1
+}
+
+// InterfaceIndex returns the index of the interface called name in sandbox
+// (the host when empty), or -1.
+func InterfaceIndex(res *current.Result, name, sandbox string) int {
+	for i, iface := range res.Interfaces {
+		if iface != nil && iface.Name == name && iface.Sandbox == sandbox {
+			return i
+		}
+	}
+	return -1
+}
+
+// AppendIPOn adds an address on interface idx of the result.
+func AppendIPOn(res *current.Result, idx int, address *net.IPNet, gateway net.IP) {
+	res.IPs = append(res.IPs, &current.IPConfig{
+		Address:   *address,
+		Gateway:   gateway,
+		Interface: &idx,
+	})
+}
+
+// AppendIP adds another address on the first container interface of an ADD
+// result.
+func AppendIP(res *current.Result, address *net.IPNet, gateway net.IP) {
+	idx := -1
+	for i, iface := range res.Interfaces {
+		if iface != nil && iface.Sandbox != "" {
+			idx = i
+			break
+		}
+	}
+	AppendIPOn(res, idx, address, gateway)
+}
+
+// AppendRoute adds a route to an ADD result.
+func AppendRoute(res *current.Result, dst *net.IPNet, gateway net.IP) {
+	res.Routes = append(res.Routes, &types.Route{Dst: *dst, GW: gateway})
+}
+
+// SetDefaultRoute reflects what became of the default route in the pod: it
+// is dropped when the pod kept another default route instead, and otherwise
+// carries metric as its priority.
+func SetDefaultRoute(res *current.Result, installed bool, metric int) {
+	routes := res.Routes[:0]
+	for _, r := range res.Routes {
+		if isDefault(r) {
+			if !installed {
+				continue
+			}
+			r.Priority = metric
+		}
+		routes = append(routes, r)
+	}
+	res.Routes = routes
+}
+
+// SetECMPDefaultRoute replaces the default route with one per next hop of
+// an equal-cost multipath route, the first hop first.
+func SetECMPDefaultRoute(res *current.Result, nexthops []net.IP) {
+	routes := res.Routes[:0]
+	for _, r := range res.Routes {
+		if !isDefault(r) {
+			routes = append(routes, r)
+		}
+	}
+	for _, hop := range nexthops {
+		routes = append(routes, &types.Route{Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, GW: hop})
+	}
+	res.Routes = routes
+}
+
+// isDefault reports whether r is an IPv4 default route.
+func isDefault(r *types.Route) bool {
+	ones, _ := r.Dst.Mask.Size()
+	return ones == 0 && r.Dst.IP.Equal(net.IPv4zero)
+}
+
+// SetDNS points the result DNS block at nameserver.
+func SetDNS(res *current.Result, nameserver net.IP, domain string, search, options []string) {
+	res.DNS = types.DNS{
+		Nameservers: []string{nameserver.String()},
+		Domain:      domain,
+		Search:      search,
+		Options:     options,
+	}
+}
+
+// SetRoutes replaces the result routes.
+func SetRoutes(res *current.Result, routes []*types.Route) {
+	res.Routes = routes
+}
+
+// BuildAddResultAddr is BuildAddResult taking netip values.
+func BuildAddResultAddr(
+	cniVersion string,
+	hostName string,
+	hostMAC string,
+	containerName string,
+	containerMAC string,
+	netnsPath string,
+	address netip.Prefix,
+	gateway netip.Addr,
+) *current.Result {
+	return BuildAddResult(cniVersion, hostName, hostMAC, containerName, containerMAC, netnsPath,
+		netaddr.ToIPNet(address), netaddr.ToIP(gateway))
+}
+
+// Merge combines the ADD results of several networks attached in one call.
+// Interface indexes are renumbered, and only the first result keeps its
+// default route since the pod can only have one.
+func Merge(cniVersion string, results ...*current.Result) *current.Result {
+	merged := &current.Result{CNIVersion: cniVersion}
+	for i, res := range results {
+		offset := len(merged.Interfaces)
+		merged.Interfaces = append(merged.Interfaces, res.Interfaces...)
+		for _, ipc := range res.IPs {
+			dup := *ipc
+			if ipc.Interface != nil {
+				idx := *ipc.Interface + offset
+				dup.Interface = &idx
+			}
+			merged.IPs = append(merged.IPs, &dup)
+		}
+		for _, route := range res.Routes {
+			if ones, _ := route.Dst.Mask.Size(); ones == 0 && i > 0 {
+				continue
+			}
+			merged.Routes = append(merged.Routes, route)
+		}
+		if i == 0 {
+			merged.DNS = res.DNS
+		}
+	}
+	return merged
 }
@@ -1,8 +1,13 @@
 package result
 
 import (
+	"encoding/json"
 	"net"
+	"net/netip"
+	"strings"
 	"testing"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
 )
 
 func TestBuildAddResult(t *testing.T) {
@@ -29,6 +34,12 @@ func TestBuildAddResult(t *testing.T) {
 	if res.Interfaces[1].Name != "eth0" {
 		t.Fatalf("unexpected container interface name: %s", res.Interfaces[1].Name)
 	}
+	if res.Interfaces[0].Sandbox != "" {
+		t.Fatalf("host interface must not have a sandbox, got %q", res.Interfaces[0].Sandbox)
+	}
+	if res.Interfaces[1].Sandbox != "/var/run/netns/test" {
+		t.Fatalf("unexpected container sandbox: %q", res.Interfaces[1].Sandbox)
+	}
 	if len(res.IPs) != 1 {
 		t.Fatalf("expected 1 IP config, got %d", len(res.IPs))
 	}
@@ -42,3 +53,182 @@ func TestBuildAddResult(t *testing.T) {
 		t.Fatalf("expected default route in result")
 	}
 }
+
+func validResult() *current.Result {
+	addr := &net.IPNet{IP: net.ParseIP("10.22.0.10").To4(), Mask: net.CIDRMask(24, 32)}
+	return BuildAddResult(
+		"1.1.0",
+		"av123",
+		"aa:bb:cc:dd:ee:ff",
+		"eth0",
+		"11:22:33:44:55:66",
+		"/var/run/netns/test",
+		addr,
+		net.ParseIP("10.22.0.1").To4(),
+	)
+}
+
+func TestValidateAcceptsBuiltResult(t *testing.T) {
+	if err := Validate(validResult()); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+}
+
+func TestValidateRejectsMalformedResults(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(res *current.Result)
+		want   string
+	}{
+		{
+			name:   "bad mac",
+			mutate: func(res *current.Result) { res.Interfaces[1].Mac = "not-a-mac" },
+			want:   "invalid mac",
+		},
+		{
+			name:   "missing sandbox",
+			mutate: func(res *current.Result) { res.Interfaces[1].Sandbox = "" },
+			want:   "must have a sandbox",
+		},
+		{
+			name: "index out of bounds",
+			mutate: func(res *current.Result) {
+				idx := 5
+				res.IPs[0].Interface = &idx
+			},
+			want: "out of bounds",
+		},
+		{
+			name: "duplicate interface",
+			mutate: func(res *current.Result) {
+				AddInterface(res, "eth0", "", "/var/run/netns/test")
+			},
+			want: "duplicates interfaces[1]",
+		},
+		{
+			name:   "missing version",
+			mutate: func(res *current.Result) { res.CNIVersion = "" },
+			want:   "cniVersion is required",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := validResult()
+			tc.mutate(res)
+			err := Validate(res)
+			if err == nil {
+				t.Fatalf("expected Validate() to fail")
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestInterfaceIndexes(t *testing.T) {
+	res := validResult()
+	vlan := AddInterface(res, "eth0.100", "11:22:33:44:55:66", "/var/run/netns/test")
+	AppendIPOn(res, vlan, &net.IPNet{IP: net.ParseIP("192.168.100.10").To4(), Mask: net.CIDRMask(24, 32)}, nil)
+	AppendIP(res, &net.IPNet{IP: net.ParseIP("10.22.0.11").To4(), Mask: net.CIDRMask(24, 32)}, nil)
+
+	if vlan != 2 || InterfaceIndex(res, "eth0.100", "/var/run/netns/test") != 2 {
+		t.Fatalf("vlan interface index = %d", vlan)
+	}
+	if InterfaceIndex(res, "eth0", "") != -1 || InterfaceIndex(res, "av123", "") != 0 {
+		t.Fatalf("InterfaceIndex must match the sandbox")
+	}
+	for i, want := range []int{1, 2, 1} {
+		if got := *res.IPs[i].Interface; got != want {
+			t.Fatalf("ips[%d] interface = %d, want %d", i, got, want)
+		}
+	}
+	if err := Validate(res); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	hostOnly := &current.Result{CNIVersion: "1.1.0"}
+	AddInterface(hostOnly, "av123", "", "")
+	AppendIP(hostOnly, &net.IPNet{IP: net.ParseIP("10.22.0.12").To4(), Mask: net.CIDRMask(24, 32)}, nil)
+	if err := Validate(hostOnly); err == nil || !strings.Contains(err.Error(), "out of bounds") {
+		t.Fatalf("AppendIP without a container interface: Validate() = %v", err)
+	}
+}
+
+func TestBuildAddResultAddr(t *testing.T) {
+	res := BuildAddResultAddr("1.1.0", "av123", "aa:bb:cc:dd:ee:ff", "eth0", "11:22:33:44:55:66", "/var/run/netns/test",
+		netip.MustParsePrefix("10.22.0.10/24"), netip.MustParseAddr("10.22.0.1"))
+	if err := Validate(res); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if got := res.IPs[0].Address.String(); got != "10.22.0.10/24" {
+		t.Fatalf("unexpected address: %s", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	first := BuildAddResultAddr("1.1.0", "av1", "aa:bb:cc:dd:ee:01", "eth0", "11:22:33:44:55:01", "/var/run/netns/test",
+		netip.MustParsePrefix("10.10.0.5/24"), netip.MustParseAddr("10.10.0.1"))
+	second := BuildAddResultAddr("1.1.0", "av2", "aa:bb:cc:dd:ee:02", "eth1", "11:22:33:44:55:02", "/var/run/netns/test",
+		netip.MustParsePrefix("10.20.0.5/24"), netip.MustParseAddr("10.20.0.1"))
+
+	res := Merge("1.1.0", first, second)
+	if err := Validate(res); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if len(res.Interfaces) != 4 || len(res.IPs) != 2 || *res.IPs[1].Interface != 3 {
+		t.Fatalf("unexpected merge: %+v", res)
+	}
+	if *second.IPs[0].Interface != 1 {
+		t.Fatalf("Merge() mutated its input")
+	}
+	if len(res.Routes) != 1 || !res.Routes[0].GW.Equal(net.ParseIP("10.10.0.1")) {
+		t.Fatalf("expected only the first default route, got %+v", res.Routes)
+	}
+}
+
+func TestParsePrevResult(t *testing.T) {
+	v04 := []byte(`{
+		"cniVersion":"0.4.0",
+		"interfaces":[{"name":"av1"},{"name":"eth0","sandbox":"/var/run/netns/test"}],
+		"ips":[{"version":"4","interface":1,"address":"10.22.0.5/24","gateway":"10.22.0.1"}],
+		"routes":[{"dst":"0.0.0.0/0","gw":"10.22.0.1"}]
+	}`)
+	for _, version := range []string{"0.4.0", ""} {
+		res, err := ParsePrevResult(v04, version)
+		if err != nil {
+			t.Fatalf("ParsePrevResult(%q) error = %v", version, err)
+		}
+		if len(res.IPs) != 1 || res.IPs[0].Address.String() != "10.22.0.5/24" || *res.IPs[0].Interface != 1 {
+			t.Fatalf("ParsePrevResult(%q) IPs = %+v", version, res.IPs)
+		}
+		if len(res.Routes) != 1 || len(res.Interfaces) != 2 || res.Interfaces[1].Sandbox != "/var/run/netns/test" {
+			t.Fatalf("ParsePrevResult(%q) = %+v", version, res)
+		}
+	}
+
+	built := BuildAddResultAddr("1.1.0", "av1", "aa:bb:cc:dd:ee:01", "eth0", "11:22:33:44:55:01", "/var/run/netns/test",
+		netip.MustParsePrefix("10.10.0.5/24"), netip.MustParseAddr("10.10.0.1"))
+	data, err := json.Marshal(built)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := ParsePrevResult(data, "1.1.0")
+	if err != nil || res.CNIVersion != "1.1.0" || res.Interfaces[0].Mac != "aa:bb:cc:dd:ee:01" {
+		t.Fatalf("ParsePrevResult(1.1.0) = %+v, %v", res, err)
+	}
+
+	for _, tc := range []struct {
+		data    string
+		version string
+	}{
+		{`{"ips":[]}`, ""},
+		{`{"cniVersion":"9.9.9"}`, ""},
+		{`not json`, "1.1.0"},
+	} {
+		if _, err := ParsePrevResult([]byte(tc.data), tc.version); err == nil || !strings.Contains(err.Error(), "decode result") {
+			t.Fatalf("ParsePrevResult(%s) error = %v", tc.data, err)
+		}
+	}
+}
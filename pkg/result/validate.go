@@ -0,0 +1,70 @@
+package result
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	current "github.com/containernetworking/cni/pkg/types/100"
+)
+
+// Validate checks spec-required fields of a result before it is printed.
+func Validate(res *current.Result) error {
+	if res == nil {
+		return errors.New("result is nil")
+	}
+	if res.CNIVersion == "" {
+		return errors.New("cniVersion is required")
+	}
+
+	seen := map[[2]string]int{}
+	for i, iface := range res.Interfaces {
+		if iface == nil {
+			return fmt.Errorf("interfaces[%d]: interface is nil", i)
+		}
+		if iface.Name == "" {
+			return fmt.Errorf("interfaces[%d]: name is required", i)
+		}
+		// Names are unique per namespace, so a repeat would make IP config
+		// indexes ambiguous.
+		id := [2]string{iface.Sandbox, iface.Name}
+		if j, ok := seen[id]; ok {
+			return fmt.Errorf("interfaces[%d]: %q duplicates interfaces[%d]", i, iface.Name, j)
+		}
+		seen[id] = i
+		if iface.Mac != "" {
+			if _, err := net.ParseMAC(iface.Mac); err != nil {
+				return fmt.Errorf("interfaces[%d]: invalid mac %q", i, iface.Mac)
+			}
+		}
+	}
+
+	for i, ipc := range res.IPs {
+		if ipc == nil {
+			return fmt.Errorf("ips[%d]: ip config is nil", i)
+		}
+		if ipc.Address.IP == nil || ipc.Address.Mask == nil {
+			return fmt.Errorf("ips[%d]: address is required", i)
+		}
+		if ipc.Interface == nil {
+			continue
+		}
+		idx := *ipc.Interface
+		if idx < 0 || idx >= len(res.Interfaces) {
+			return fmt.Errorf("ips[%d]: interface index %d out of bounds", i, idx)
+		}
+		if res.Interfaces[idx].Sandbox == "" {
+			return fmt.Errorf("ips[%d]: interface %q must have a sandbox", i, res.Interfaces[idx].Name)
+		}
+	}
+
+	for i, route := range res.Routes {
+		if route == nil {
+			return fmt.Errorf("routes[%d]: route is nil", i)
+		}
+		if route.Dst.IP == nil || route.Dst.Mask == nil {
+			return fmt.Errorf("routes[%d]: dst is required", i)
+		}
+	}
+	return nil
+}
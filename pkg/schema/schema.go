@@ -0,0 +1,64 @@
+// Package schema versions the JSON state files the plugin persists. Each file
+// carries a schemaVersion field; a reader upgrades older files in memory
+// through a list of migrations, and refuses files from a newer build instead
+// of dropping fields it does not know when it writes them back.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Field is the JSON field holding a document's schema version. Documents
+// without it are version 0.
+const Field = "schemaVersion"
+
+// ErrNewer is returned for documents written with a schema newer than the
+// reader's.
+var ErrNewer = errors.New("written by a newer atomicni")
+
+// Migration upgrades a decoded document by one schema version, in place.
+type Migration func(doc map[string]json.RawMessage) error
+
+// Upgrade brings content up to len(migrations), the current version:
+// migrations[i] upgrades version i to i+1. It returns content unchanged when
+// it is already current, and the version content had.
+func Upgrade(content []byte, migrations []Migration) ([]byte, int, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, 0, err
+	}
+	if doc == nil {
+		doc = map[string]json.RawMessage{}
+	}
+	version := 0
+	if raw, ok := doc[Field]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("decode %s: %w", Field, err)
+		}
+	}
+	current := len(migrations)
+	switch {
+	case version == current:
+		return content, version, nil
+	case version > current:
+		return nil, version, fmt.Errorf("schema version %d, this build reads up to %d: %w", version, current, ErrNewer)
+	case version < 0:
+		return nil, version, fmt.Errorf("invalid schema version %d", version)
+	}
+	for v := version; v < current; v++ {
+		if err := migrations[v](doc); err != nil {
+			return nil, version, fmt.Errorf("migrate schema %d to %d: %w", v, v+1, err)
+		}
+	}
+	doc[Field] = json.RawMessage(fmt.Sprint(current))
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, version, err
+	}
+	return upgraded, version, nil
+}
+
+// Stamp is the migration of a format that only gained the version field.
+func Stamp(map[string]json.RawMessage) error { return nil }
@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// testMigrations rename a field, add a defaulted one, and split one in two,
+// the kinds of change the state formats expect.
+var testMigrations = []Migration{
+	func(doc map[string]json.RawMessage) error {
+		doc["owner"] = doc["container"]
+		delete(doc, "container")
+		return nil
+	},
+	func(doc map[string]json.RawMessage) error {
+		if _, ok := doc["leaseSeconds"]; !ok {
+			doc["leaseSeconds"] = json.RawMessage("0")
+		}
+		return nil
+	},
+	func(doc map[string]json.RawMessage) error {
+		var owner string
+		if err := json.Unmarshal(doc["owner"], &owner); err != nil {
+			return err
+		}
+		doc["pod"] = json.RawMessage(fmt.Sprintf("%q", owner))
+		doc["ifName"] = json.RawMessage(`"eth0"`)
+		return nil
+	},
+}
+
+func TestUpgradeFromEveryVersion(t *testing.T) {
+	docs := []string{
+		`{"container":"abc"}`,
+		`{"schemaVersion":1,"owner":"abc"}`,
+		`{"schemaVersion":2,"owner":"abc","leaseSeconds":0}`,
+		`{"schemaVersion":3,"owner":"abc","leaseSeconds":0,"pod":"abc","ifName":"eth0"}`,
+	}
+	var want map[string]any
+	if err := json.Unmarshal([]byte(docs[3]), &want); err != nil {
+		t.Fatal(err)
+	}
+	for from, doc := range docs {
+		out, version, err := Upgrade([]byte(doc), testMigrations)
+		if err != nil || version != from {
+			t.Fatalf("from %d: version=%d err=%v", from, version, err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("from %d: %v", from, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("from %d: got %v, want %v", from, got, want)
+		}
+	}
+}
+
+func TestUpgradeRefusesNewerAndSurfacesFailures(t *testing.T) {
+	if _, _, err := Upgrade([]byte(`{"schemaVersion":4}`), testMigrations); !errors.Is(err, ErrNewer) {
+		t.Fatalf("newer document: err = %v", err)
+	}
+	if _, _, err := Upgrade([]byte(`{"schemaVersion":2,"owner":7}`), testMigrations); err == nil {
+		t.Fatal("failing migration: expected error")
+	}
+	if _, _, err := Upgrade([]byte(`{"schemaVersion":"one"}`), testMigrations); err == nil {
+		t.Fatal("non-numeric version: expected error")
+	}
+	out, _, err := Upgrade([]byte(`null`), []Migration{Stamp})
+	if err != nil || string(out) != `{"schemaVersion":1}` {
+		t.Fatalf("null document: %s %v", out, err)
+	}
+}
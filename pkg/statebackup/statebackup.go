@@ -0,0 +1,343 @@
+// Package statebackup writes a plugin data dir to a single tar and restores
+// it. The tar starts with a manifest listing every file with its SHA-256 and
+// the fingerprint of every host rule the cached attachments recorded; restore
+// checks all of it before the data dir is touched.
+package statebackup
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/buildinfo"
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+)
+
+// FormatVersion is the backup format this build writes and restores.
+const FormatVersion = 1
+
+// ManifestName is the first entry of every backup.
+const ManifestName = "manifest.json"
+
+// skippedDirs hold runtime-only files (ADD admission slots, the metrics
+// journal) that a restored node rebuilds on its own.
+var skippedDirs = map[string]bool{"admission": true, "metrics": true}
+
+// Manifest describes the content of a backup.
+type Manifest struct {
+	Version     int       `json:"version"`
+	PluginBuild string    `json:"pluginBuild"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Files       []File    `json:"files"`
+	Rules       []Rule    `json:"rules,omitempty"`
+}
+
+// File is one data dir file in a backup; Name is slash-separated and relative
+// to the data dir.
+type File struct {
+	Name   string      `json:"name"`
+	Size   int64       `json:"size"`
+	Mode   fs.FileMode `json:"mode"`
+	SHA256 string      `json:"sha256"`
+}
+
+// Rule is the fingerprint of one host rule a cached attachment recorded.
+type Rule struct {
+	Attachment  string `json:"attachment"`
+	Kind        string `json:"kind"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Backup writes dataDir to w as a tar. Every network's IPAM state is locked
+// while the files are read, so allocations and their history are consistent
+// with each other; lock files and runtime-only directories are left out.
+func Backup(ctx context.Context, dataDir string, w io.Writer) (*Manifest, error) {
+	unlock, err := ipam.LockAll(ctx, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	contents := map[string][]byte{}
+	m := &Manifest{Version: FormatVersion, PluginBuild: buildinfo.Get().String(), CreatedAt: time.Now().UTC()}
+	err = filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if skippedDirs[rel] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(rel, ".lock") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		contents[name] = content
+		m.Files = append(m.Files, File{Name: name, Size: int64(len(content)), Mode: info.Mode().Perm(), SHA256: digest(content)})
+		return nil
+	})
+	if err == nil {
+		m.Rules, err = ruleFingerprints(dataDir)
+	}
+	unlock()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", dataDir, err)
+	}
+
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeEntry(tw, ManifestName, 0o644, m.CreatedAt, raw); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if err := writeEntry(tw, f.Name, f.Mode, m.CreatedAt, contents[f.Name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("write backup: %w", err)
+	}
+	return m, nil
+}
+
+// RestoreResult reports what Restore put in place.
+type RestoreResult struct {
+	Manifest *Manifest
+	// Previous is where the replaced data dir was moved, if there was one.
+	Previous string
+}
+
+// Restore replaces dataDir with the backup read from r. The backup is
+// unpacked into a sibling directory and checked first: the format version,
+// every file's size and checksum, that no listed file is missing and no other
+// is present, that each network's IPAM state is consistent, and that the
+// cached attachments decode and carry the rules the manifest lists. Only then
+// is the old data dir moved aside and the new one renamed into place, with
+// every network's IPAM state locked as Backup does, so a failed restore
+// leaves dataDir as it was. A data dir holding state is only replaced with
+// force.
+func Restore(ctx context.Context, dataDir string, r io.Reader, force bool) (*RestoreResult, error) {
+	dataDir = filepath.Clean(dataDir)
+	existing, err := hasState(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if existing && !force {
+		return nil, fmt.Errorf("%s already holds state; restoring would replace it", dataDir)
+	}
+	if err := os.MkdirAll(filepath.Dir(dataDir), 0o755); err != nil {
+		return nil, err
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dataDir), filepath.Base(dataDir)+".restore-")
+	if err != nil {
+		return nil, fmt.Errorf("create staging dir: %w", err)
+	}
+	m, err := unpack(r, staging)
+	if err == nil {
+		err = verify(ctx, staging, m)
+	}
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return nil, err
+	}
+
+	// Held across the swap so no ADD or GC pass writes into the data dir
+	// being replaced; waiters retry on the restored one.
+	unlock, err := ipam.LockAll(ctx, dataDir)
+	if err != nil {
+		_ = os.RemoveAll(staging)
+		return nil, err
+	}
+	defer unlock()
+
+	res := &RestoreResult{Manifest: m}
+	if _, err := os.Stat(dataDir); err == nil {
+		res.Previous = fmt.Sprintf("%s.pre-restore-%d", dataDir, time.Now().Unix())
+		if err := os.Rename(dataDir, res.Previous); err != nil {
+			_ = os.RemoveAll(staging)
+			return nil, fmt.Errorf("move %s aside: %w", dataDir, err)
+		}
+	}
+	if err := os.Rename(staging, dataDir); err != nil {
+		if res.Previous != "" {
+			_ = os.Rename(res.Previous, dataDir)
+		}
+		_ = os.RemoveAll(staging)
+		return nil, fmt.Errorf("move restored state into place: %w", err)
+	}
+	return res, nil
+}
+
+// unpack extracts a backup into dir and returns its manifest after checking
+// the version and every entry against it.
+func unpack(r io.Reader, dir string) (*Manifest, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("read backup: %w", err)
+	}
+	if hdr.Name != ManifestName {
+		return nil, fmt.Errorf("backup starts with %q, not %s", hdr.Name, ManifestName)
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if m.Version != FormatVersion {
+		return nil, fmt.Errorf("backup format version %d, this build restores %d", m.Version, FormatVersion)
+	}
+
+	want := map[string]File{}
+	for _, f := range m.Files {
+		want[f.Name] = f
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read backup: %w", err)
+		}
+		f, ok := want[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("backup entry %q is not in the manifest", hdr.Name)
+		}
+		delete(want, hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !filepath.IsLocal(filepath.FromSlash(hdr.Name)) {
+			return nil, fmt.Errorf("backup entry %q is not a data dir file", hdr.Name)
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, f.Size+1))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", hdr.Name, err)
+		}
+		if int64(len(content)) != f.Size || digest(content) != f.SHA256 {
+			return nil, fmt.Errorf("%s does not match its checksum", hdr.Name)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, content, f.Mode.Perm()); err != nil {
+			return nil, err
+		}
+	}
+	if len(want) > 0 {
+		missing := make([]string, 0, len(want))
+		for name := range want {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("backup is truncated: missing %s", strings.Join(missing, ", "))
+	}
+	return &m, nil
+}
+
+// verify checks the unpacked state in dir the way the plugin will read it.
+func verify(ctx context.Context, dir string, m *Manifest) error {
+	alloc := ipam.NewFileAllocator()
+	networks, err := alloc.Networks(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		if err := alloc.Verify(ctx, dir, network); err != nil {
+			return fmt.Errorf("network %q: %w", network, err)
+		}
+	}
+	rules, err := ruleFingerprints(dir)
+	if err != nil {
+		return err
+	}
+	if len(rules) != len(m.Rules) {
+		return fmt.Errorf("cached attachments record %d rules, the manifest %d", len(rules), len(m.Rules))
+	}
+	for i := range rules {
+		if rules[i] != m.Rules[i] {
+			return fmt.Errorf("rule %s %s of %s does not match the manifest", m.Rules[i].Kind, m.Rules[i].Key, m.Rules[i].Attachment)
+		}
+	}
+	return nil
+}
+
+// ruleFingerprints lists the host rules of every cached attachment in dir,
+// sorted by attachment, kind, and key.
+func ruleFingerprints(dir string) ([]Rule, error) {
+	attachments, err := cache.List(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read cached attachments: %w", err)
+	}
+	var rules []Rule
+	for _, a := range attachments {
+		for _, r := range a.Rules {
+			rules = append(rules, Rule{Attachment: a.Key(), Kind: r.Kind, Key: r.Key, Fingerprint: r.Fingerprint})
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		a, b := rules[i], rules[j]
+		if a.Attachment != b.Attachment {
+			return a.Attachment < b.Attachment
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Key < b.Key
+	})
+	return rules, nil
+}
+
+// hasState reports whether dataDir exists and holds any file.
+func hasState(dataDir string) (bool, error) {
+	entries, err := os.ReadDir(dataDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read %s: %w", dataDir, err)
+	}
+	return len(entries) > 0, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, mode fs.FileMode, modTime time.Time, content []byte) error {
+	hdr := &tar.Header{Name: name, Mode: int64(mode.Perm()), Size: int64(len(content)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+	return nil
+}
+
+func digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
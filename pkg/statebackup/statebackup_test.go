@@ -0,0 +1,196 @@
+package statebackup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/annis-souames/atomicni/pkg/cache"
+	"github.com/annis-souames/atomicni/pkg/ipam"
+)
+
+// populate writes one allocation and one cached attachment with a rule.
+func populate(t *testing.T, dataDir string) {
+	t.Helper()
+	_, subnet, _ := net.ParseCIDR("10.22.0.0/24")
+	_, err := ipam.NewFileAllocator().Allocate(context.Background(), ipam.AllocationRequest{
+		DataDir:     dataDir,
+		Network:     "atomic-net",
+		ContainerID: "pod-a",
+		Subnet:      subnet,
+		Gateway:     net.ParseIP("10.22.0.1").To4(),
+		RangeStart:  net.ParseIP("10.22.0.10").To4(),
+		RangeEnd:    net.ParseIP("10.22.0.20").To4(),
+	})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	a := &cache.Attachment{
+		Network:     "atomic-net",
+		ContainerID: "pod-a",
+		IfName:      "eth0",
+		Rules:       []cache.Rule{{Kind: "dscp", Key: "veth0", Spec: []byte(`{"dscp":46}`), Fingerprint: "0123456789abcdef"}},
+	}
+	if err := cache.Save(dataDir, a); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+}
+
+func TestBackupAndRestoreRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	populate(t, src)
+	var buf bytes.Buffer
+	m, err := Backup(context.Background(), src, &buf)
+	if err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	if len(m.Rules) != 1 || m.Rules[0].Fingerprint != "0123456789abcdef" {
+		t.Fatalf("manifest rules = %+v", m.Rules)
+	}
+	for _, f := range m.Files {
+		if strings.HasSuffix(f.Name, ".lock") {
+			t.Fatalf("backup includes lock file %s", f.Name)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "atomicni")
+	res, err := Restore(context.Background(), dst, bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if res.Previous != "" {
+		t.Fatalf("Previous = %q for a fresh data dir", res.Previous)
+	}
+	ip, ok, err := ipam.NewFileAllocator().GetByContainer(context.Background(), dst, "atomic-net", "pod-a")
+	if err != nil || !ok || ip.String() != "10.22.0.10" {
+		t.Fatalf("restored allocation = %v %v %v", ip, ok, err)
+	}
+	if _, ok, err := cache.Load(dst, "atomic-net", "pod-a", "eth0"); err != nil || !ok {
+		t.Fatalf("restored attachment: ok=%v err=%v", ok, err)
+	}
+
+	// Existing state is only replaced with force, and then kept aside.
+	if _, err := Restore(context.Background(), dst, bytes.NewReader(buf.Bytes()), false); err == nil {
+		t.Fatal("Restore() over existing state without force succeeded")
+	}
+	res, err = Restore(context.Background(), dst, bytes.NewReader(buf.Bytes()), true)
+	if err != nil {
+		t.Fatalf("Restore(force) error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(res.Previous, "atomic-net.json")); err != nil {
+		t.Fatalf("previous data dir not kept: %v", err)
+	}
+}
+
+func TestRestoreWaitsForIPAMLocks(t *testing.T) {
+	src := t.TempDir()
+	populate(t, src)
+	var buf bytes.Buffer
+	if _, err := Backup(context.Background(), src, &buf); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	dst := t.TempDir()
+	populate(t, dst)
+	unlock, err := ipam.LockAll(context.Background(), dst)
+	if err != nil {
+		t.Fatalf("LockAll: %v", err)
+	}
+	defer unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Restore(ctx, dst, bytes.NewReader(buf.Bytes()), true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Restore() under a held lock error = %v, want deadline", err)
+	}
+	if siblings, _ := filepath.Glob(dst + ".*"); len(siblings) != 0 {
+		t.Fatalf("Restore() left %v behind", siblings)
+	}
+}
+
+func TestRestoreRefusesDamagedBackups(t *testing.T) {
+	src := t.TempDir()
+	populate(t, src)
+	var buf bytes.Buffer
+	if _, err := Backup(context.Background(), src, &buf); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+
+	for name, damage := range map[string]func(name string, content []byte) (string, []byte, bool){
+		"checksum": func(name string, content []byte) (string, []byte, bool) {
+			if name == "atomic-net.json" {
+				content = bytes.Replace(content, []byte("10.22.0.10"), []byte("10.22.0.11"), 1)
+			}
+			return name, content, true
+		},
+		"truncated": func(name string, content []byte) (string, []byte, bool) {
+			return name, content, !strings.HasPrefix(name, "results/")
+		},
+		"version": func(name string, content []byte) (string, []byte, bool) {
+			if name == ManifestName {
+				content = bytes.Replace(content, []byte(`"version": 1`), []byte(`"version": 99`), 1)
+			}
+			return name, content, true
+		},
+		"unlisted": func(name string, content []byte) (string, []byte, bool) {
+			if name == "atomic-net.json" {
+				name = "../escape.json"
+			}
+			return name, content, true
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dst := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dst, "marker"), []byte("old"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Restore(context.Background(), dst, rewrite(t, buf.Bytes(), damage), true); err == nil {
+				t.Fatal("Restore() succeeded")
+			}
+			entries, _ := os.ReadDir(dst)
+			if len(entries) != 1 || entries[0].Name() != "marker" {
+				t.Fatalf("data dir changed: %v", entries)
+			}
+			siblings, _ := filepath.Glob(dst + ".restore-*")
+			if len(siblings) != 0 {
+				t.Fatalf("staging left behind: %v", siblings)
+			}
+		})
+	}
+}
+
+// rewrite copies a backup entry by entry through damage, which may rename an
+// entry, change its content, or drop it.
+func rewrite(t *testing.T, backup []byte, damage func(string, []byte) (string, []byte, bool)) io.Reader {
+	t.Helper()
+	var out bytes.Buffer
+	tr, tw := tar.NewReader(bytes.NewReader(backup)), tar.NewWriter(&out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		name, content, keep := damage(hdr.Name, content)
+		if !keep {
+			continue
+		}
+		hdr.Name, hdr.Size = name, int64(len(content))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		_, _ = tw.Write(content)
+	}
+	_ = tw.Close()
+	return &out
+}
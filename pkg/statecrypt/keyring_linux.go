@@ -0,0 +1,30 @@
+package statecrypt
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// readKeyring reads the payload of the "user" key described by desc. Keys
+// reachable from the caller's thread, process, or session keyrings are
+// tried first, then the user keyring; reading one there without possessing
+// it needs the user-read permission (`keyctl setperm <id> 0x3f030000`).
+func readKeyring(desc string) ([]byte, error) {
+	id, err := unix.RequestKey("user", desc, "", 0)
+	if err != nil {
+		id, err = unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", desc, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find keyring key %q: %w", desc, err)
+	}
+	buf := make([]byte, 512)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read keyring key %q: %w", desc, err)
+	}
+	if n > len(buf) {
+		return nil, fmt.Errorf("keyring key %q is too large (%d bytes)", desc, n)
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package statecrypt
+
+import "errors"
+
+// readKeyring is only supported on Linux.
+func readKeyring(string) ([]byte, error) {
+	return nil, errors.New("kernel keyring is only available on linux")
+}
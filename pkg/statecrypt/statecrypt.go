@@ -0,0 +1,200 @@
+// Package statecrypt encrypts plugin state files at rest. A data dir is
+// encrypted once it holds ConfigFile naming where the key lives; from then on
+// Seal encrypts every state file written there and Open decrypts it, so
+// callers that only know the data dir (the allocator, GC, the CLI) need no
+// key configuration of their own.
+package statecrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ConfigFile marks an encrypted data dir and names its key. It is not a
+// .json file so IPAM never mistakes it for the state of a network.
+const ConfigFile = "encryption.conf"
+
+// Key source prefixes: "file:/etc/atomicni/state.key" reads the key from a
+// file, "keyring:atomicni-state" from a user key in the kernel keyring.
+const (
+	KeyFilePrefix    = "file:"
+	KeyringPrefix    = "keyring:"
+	DefaultKeySource = KeyFilePrefix + "/etc/atomicni/state.key"
+)
+
+// sealedMagic starts every encrypted file, followed by the GCM nonce and the
+// ciphertext.
+var sealedMagic = []byte("atomicni-sealed-v1\n")
+
+// Config is the content of ConfigFile.
+type Config struct {
+	Key string `json:"key"`
+}
+
+// Enable marks dataDir as encrypted with the key at source. It fails when
+// the dir is already encrypted with a different key source, since files
+// sealed with the old key would become unreadable.
+func Enable(dataDir, source string) error {
+	if _, err := loadKey(source); err != nil {
+		return err
+	}
+	cfg, err := readConfig(dataDir)
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		if cfg.Key != source {
+			return fmt.Errorf("%s is encrypted with key %q, not %q", dataDir, cfg.Key, source)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return fmt.Errorf("create data dir: %w", err)
+	}
+	content, err := json.Marshal(Config{Key: source})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dataDir, ConfigFile)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0o600); err != nil {
+		return fmt.Errorf("write encryption config: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("replace encryption config: %w", err)
+	}
+	return nil
+}
+
+// Enabled reports whether dataDir is encrypted.
+func Enabled(dataDir string) (bool, error) {
+	cfg, err := readConfig(dataDir)
+	return cfg != nil, err
+}
+
+// Seal encrypts plain for the file called name in dataDir, or returns it
+// unchanged when dataDir is not encrypted. The name is authenticated, so a
+// sealed file does not open under another name.
+func Seal(dataDir, name string, plain []byte) ([]byte, error) {
+	aead, err := dirAEAD(dataDir)
+	if err != nil || aead == nil {
+		return plain, err
+	}
+	out := make([]byte, len(sealedMagic)+aead.NonceSize(), len(sealedMagic)+aead.NonceSize()+len(plain)+aead.Overhead())
+	copy(out, sealedMagic)
+	nonce := out[len(sealedMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return aead.Seal(out, nonce, plain, []byte(name)), nil
+}
+
+// Open decrypts a file sealed for name in dataDir. Plaintext passes through,
+// so files written before encryption was enabled stay readable until they
+// are next written.
+func Open(dataDir, name string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, sealedMagic) {
+		return data, nil
+	}
+	aead, err := dirAEAD(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if aead == nil {
+		return nil, fmt.Errorf("%s is encrypted but %s has no %s", name, dataDir, ConfigFile)
+	}
+	data = data[len(sealedMagic):]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("%s: sealed file is truncated", name)
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: decrypt: %w", name, err)
+	}
+	return plain, nil
+}
+
+var (
+	aeadMu    sync.Mutex
+	aeadCache = map[string]cipher.AEAD{}
+)
+
+// dirAEAD returns the cipher for dataDir, or nil when it is not encrypted.
+// Keys are loaded once per process and source.
+func dirAEAD(dataDir string) (cipher.AEAD, error) {
+	cfg, err := readConfig(dataDir)
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+	aeadMu.Lock()
+	defer aeadMu.Unlock()
+	if aead, ok := aeadCache[cfg.Key]; ok {
+		return aead, nil
+	}
+	key, err := loadKey(cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	aeadCache[cfg.Key] = aead
+	return aead, nil
+}
+
+// readConfig reads ConfigFile from dataDir, returning nil when absent.
+func readConfig(dataDir string) (*Config, error) {
+	content, err := os.ReadFile(filepath.Join(dataDir, ConfigFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read encryption config: %w", err)
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(content, cfg); err != nil {
+		return nil, fmt.Errorf("encryption config %s is corrupted: %w", filepath.Join(dataDir, ConfigFile), err)
+	}
+	return cfg, nil
+}
+
+// loadKey reads an AES-256 key from source: 32 raw bytes, or 64 hex digits
+// with surrounding whitespace ignored.
+func loadKey(source string) ([]byte, error) {
+	var raw []byte
+	var err error
+	switch {
+	case strings.HasPrefix(source, KeyFilePrefix):
+		raw, err = os.ReadFile(strings.TrimPrefix(source, KeyFilePrefix))
+	case strings.HasPrefix(source, KeyringPrefix):
+		raw, err = readKeyring(strings.TrimPrefix(source, KeyringPrefix))
+	default:
+		return nil, fmt.Errorf("key %q: want %s<path> or %s<description>", source, KeyFilePrefix, KeyringPrefix)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load state key: %w", err)
+	}
+	if len(raw) == 32 {
+		return raw, nil
+	}
+	if key, err := hex.DecodeString(strings.TrimSpace(string(raw))); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("state key from %q must be 32 bytes or 64 hex digits", source)
+}
@@ -0,0 +1,79 @@
+package statecrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKey writes a hex key file and returns its key source.
+func writeKey(t *testing.T, b byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "state.key")
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(bytes.Repeat([]byte{b}, 32))+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return KeyFilePrefix + path
+}
+
+func TestSealOpen(t *testing.T) {
+	dir := t.TempDir()
+	plain := []byte(`{"containerID":"c1","k8sPodName":"web-0"}`)
+
+	out, err := Seal(dir, "a.json", plain)
+	if err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("Seal() before Enable = %q, %v; want plaintext", out, err)
+	}
+
+	key := writeKey(t, 1)
+	if err := Enable(dir, key); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if on, err := Enabled(dir); err != nil || !on {
+		t.Fatalf("Enabled() = %v, %v", on, err)
+	}
+	sealed, err := Seal(dir, "a.json", plain)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if bytes.Contains(sealed, []byte("web-0")) {
+		t.Fatalf("sealed output leaks plaintext: %q", sealed)
+	}
+	got, err := Open(dir, "a.json", sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open() = %q, %v", got, err)
+	}
+	if _, err := Open(dir, "b.json", sealed); err == nil {
+		t.Fatalf("Open() under another name succeeded")
+	}
+	if got, err := Open(dir, "old.json", plain); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("Open() of plaintext = %q, %v", got, err)
+	}
+}
+
+func TestEnableKeyMismatch(t *testing.T) {
+	dir := t.TempDir()
+	first := writeKey(t, 1)
+	if err := Enable(dir, first); err != nil {
+		t.Fatalf("Enable() error = %v", err)
+	}
+	if err := Enable(dir, first); err != nil {
+		t.Fatalf("repeat Enable() error = %v", err)
+	}
+	if err := Enable(dir, writeKey(t, 2)); err == nil || !strings.Contains(err.Error(), "is encrypted with key") {
+		t.Fatalf("Enable() with another key = %v", err)
+	}
+}
+
+func TestLoadKeyRejectsShortKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short.key")
+	if err := os.WriteFile(path, []byte("abcd"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enable(t.TempDir(), KeyFilePrefix+path); err == nil || !strings.Contains(err.Error(), "32 bytes or 64 hex digits") {
+		t.Fatalf("Enable() error = %v", err)
+	}
+}